@@ -0,0 +1,139 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package arxml imports CAN network descriptions from AUTOSAR system
+// description (ARXML) files.
+//
+// The CAN clusters of the system are converted into candb databases:
+// each frame triggering of a cluster becomes a candb message, holding
+// the I-signals of the PDUs mapped into the frame, with their scaling,
+// value tables, units and ranges derived from their compu-methods,
+// base types and data constraints.
+// The AUTOSAR specific properties of frames and PDUs, such as E2E and
+// SecOC protections, are described alongside the candb messages.
+//
+// Multiplexed, container and other PDUs that do not directly map
+// I-signals are listed with their frame, but their signals are not
+// imported.
+package arxml
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-daq/canbus/candb"
+)
+
+// System holds the CAN clusters described by an ARXML document.
+type System struct {
+	Clusters []*Cluster
+}
+
+// Cluster returns the cluster named name, or nil.
+func (sys *System) Cluster(name string) *Cluster {
+	for _, c := range sys.Clusters {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Cluster is a CAN network.
+type Cluster struct {
+	Name       string
+	Baudrate   uint64 // nominal bit rate, in bit/s
+	FDBaudrate uint64 // CAN FD data phase bit rate, in bit/s (0 if unused)
+
+	// DB holds the messages of the frames triggered on the cluster.
+	DB *candb.Database
+	// Frames describes the frames of the cluster, in the same order
+	// as the messages of DB.
+	Frames []*Frame
+}
+
+// Frame returns the frame named name, or nil.
+func (c *Cluster) Frame(name string) *Frame {
+	for _, f := range c.Frames {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Frame describes a frame triggered on a CAN cluster.
+type Frame struct {
+	Name       string // short name of the frame
+	Triggering string // short name of the frame triggering
+	Channel    string // short name of the physical channel
+	FD         bool   // whether the frame is sent with the CAN FD format
+	Message    *candb.Message
+	PDUs       []*PDU
+}
+
+// PDU describes a protocol data unit mapped into a frame.
+type PDU struct {
+	Name   string
+	Kind   string // element type of the PDU, e.g. I-SIGNAL-I-PDU
+	Start  int    // start bit of the PDU in the frame
+	Length int    // length of the PDU, in bytes
+
+	E2E   *E2E   // end-to-end protection of the PDU, or nil
+	SecOC *SecOC // secure onboard communication properties, or nil
+}
+
+// E2E describes the end-to-end protection of a PDU.
+type E2E struct {
+	Profile         string // E2E profile, e.g. PROFILE_05
+	DataIDs         []uint64
+	DataIDMode      string
+	DataLength      int // length of the protected data, in bits
+	CRCOffset       int // bit offset of the CRC
+	CounterOffset   int // bit offset of the counter
+	MaxDeltaCounter int
+}
+
+// SecOC describes the secure onboard communication properties of a
+// secured PDU.
+// The authentic payload is followed in the secured PDU by the
+// truncated freshness value and the truncated authenticator.
+type SecOC struct {
+	Payload                string // name of the authentic PDU
+	DataID                 uint64
+	AuthAlgorithm          string
+	AuthInfoTxLength       int // length of the truncated authenticator, in bits
+	FreshnessValueID       uint64
+	FreshnessValueLength   int // length of the freshness value, in bits
+	FreshnessValueTxLength int // length of the truncated freshness value, in bits
+}
+
+// ParseFile parses the named ARXML file.
+func ParseFile(fname string) (*System, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("arxml: could not open ARXML file: %w", err)
+	}
+	defer f.Close()
+
+	sys, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return sys, nil
+}
+
+// Parse parses an ARXML document from the provided reader.
+func Parse(r io.Reader) (*System, error) {
+	root, err := readTree(r)
+	if err != nil {
+		return nil, err
+	}
+	if root.tag != "AUTOSAR" {
+		return nil, fmt.Errorf("arxml: invalid root element %q", root.tag)
+	}
+	l := newLoader(root)
+	return l.load()
+}
@@ -0,0 +1,253 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package arxml_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/arxml"
+	"github.com/go-daq/canbus/candb"
+)
+
+func TestParseFile(t *testing.T) {
+	sys, err := arxml.ParseFile("testdata/system.arxml")
+	if err != nil {
+		t.Fatalf("could not parse ARXML file: %+v", err)
+	}
+
+	if got, want := len(sys.Clusters), 1; got != want {
+		t.Fatalf("invalid number of clusters: got=%d, want=%d", got, want)
+	}
+	c := sys.Cluster("Powertrain")
+	if c == nil {
+		t.Fatalf("could not find cluster")
+	}
+	if c.Baudrate != 500000 || c.FDBaudrate != 2000000 {
+		t.Fatalf("invalid baudrates: got=%d/%d", c.Baudrate, c.FDBaudrate)
+	}
+	if got, want := len(c.Frames), 3; got != want {
+		t.Fatalf("invalid number of frames: got=%d, want=%d", got, want)
+	}
+
+	engine := c.Frame("EngineStatus")
+	if got, want := engine.Message, c.DB.Message(0x100, false); got != want {
+		t.Fatalf("invalid message: got=%p, want=%p", got, want)
+	}
+	if got, want := engine.Message.Sender, "EngineECU"; got != want {
+		t.Fatalf("invalid sender: got=%q, want=%q", got, want)
+	}
+	if got, want := engine.Message.Comment, "Engine status"; got != want {
+		t.Fatalf("invalid comment: got=%q, want=%q", got, want)
+	}
+	if got, want := engine.PDUs[0].E2E, (&arxml.E2E{
+		Profile:         "PROFILE_01",
+		DataIDs:         []uint64{0x123},
+		DataIDMode:      "ALL-16-BIT",
+		DataLength:      64,
+		CRCOffset:       0,
+		CounterOffset:   8,
+		MaxDeltaCounter: 1,
+	}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid E2E protection:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		want candb.Signal
+	}{
+		{
+			name: "VehicleSpeed",
+			want: candb.Signal{
+				Name: "VehicleSpeed", Start: 16, Length: 16,
+				Factor: 0.01, Max: 300, Unit: "km/h",
+				Receivers: []string{"BodyECU"},
+				Comment:   "Vehicle speed over ground",
+			},
+		},
+		{
+			name: "EngineTemp",
+			want: candb.Signal{
+				Name: "EngineTemp", Start: 32, Length: 8,
+				Factor: 1, Offset: -40, Min: -40, Max: 215, Unit: "degC",
+				Receivers: []string{"BodyECU"},
+			},
+		},
+		{
+			name: "EngineCounter",
+			want: candb.Signal{
+				Name: "EngineCounter", Start: 8, Length: 4,
+				Factor: 1, Max: 14,
+				Receivers: []string{"BodyECU"},
+			},
+		},
+		{
+			name: "Gear",
+			want: candb.Signal{
+				Name: "Gear", Start: 40, Length: 3, Factor: 1,
+				Receivers: []string{"BodyECU"},
+				Values: []candb.ValueDesc{
+					{Value: 0, Desc: "Park"},
+					{Value: 1, Desc: "Reverse"},
+					{Value: 2, Desc: "Neutral"},
+					{Value: 3, Desc: "Drive"},
+					{Value: 4, Desc: "Drive"},
+					{Value: 5, Desc: "Drive"},
+					{Value: 6, Desc: "Drive"},
+					{Value: 7, Desc: "Drive"},
+				},
+			},
+		},
+		{
+			name: "Torque",
+			want: candb.Signal{
+				Name: "Torque", Start: 55, Length: 12,
+				ByteOrder: candb.BigEndian, Type: candb.Signed,
+				Factor: 0.5, Min: -1023.5, Max: 1023.5, Unit: "Nm",
+				Receivers: []string{"BodyECU"},
+				Values:    []candb.ValueDesc{{Value: -2048, Desc: "Invalid"}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sig := engine.Message.Signal(tc.name)
+			if sig == nil {
+				t.Fatalf("could not find signal")
+			}
+			if !reflect.DeepEqual(*sig, tc.want) {
+				t.Fatalf("invalid signal:\ngot= %+v\nwant=%+v", *sig, tc.want)
+			}
+		})
+	}
+
+	frame, err := engine.Message.Encode(map[string]float64{
+		"EngineCRC":     0xab,
+		"EngineCounter": 5,
+		"VehicleSpeed":  123.45,
+		"EngineTemp":    90,
+		"Gear":          3,
+		"Torque":        -100,
+	})
+	if err != nil {
+		t.Fatalf("could not encode frame: %+v", err)
+	}
+	if got, want := frame, (canbus.Frame{
+		ID:   0x100,
+		Kind: canbus.SFF,
+		Data: []byte{0xab, 0x05, 0x39, 0x30, 0x82, 0x03, 0xf3, 0x80},
+	}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frame:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	body := c.Frame("BodyStatus")
+	if !body.FD || !body.Message.Extended || body.Message.ID != 0x18ff0010 {
+		t.Fatalf("invalid frame: %+v, %+v", body, body.Message)
+	}
+	if got, want := body.Message.Sender, "BodyECU"; got != want {
+		t.Fatalf("invalid sender: got=%q, want=%q", got, want)
+	}
+	if got, want := body.Message.Signal("Flow").Type, candb.Float; got != want {
+		t.Fatalf("invalid signal type: got=%v, want=%v", got, want)
+	}
+	if got, want := body.PDUs[0], (&arxml.PDU{
+		Name:   "PDU_Body_Secured",
+		Kind:   "SECURED-I-PDU",
+		Length: 12,
+		SecOC: &arxml.SecOC{
+			Payload:                "PDU_Body",
+			DataID:                 42,
+			AuthAlgorithm:          "CMAC/AES-128",
+			AuthInfoTxLength:       24,
+			FreshnessValueID:       7,
+			FreshnessValueLength:   64,
+			FreshnessValueTxLength: 8,
+		},
+	}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid secured PDU:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	nm := c.Frame("NetworkManagement")
+	if got, want := nm.PDUs[0].Kind, "NM-PDU"; got != want {
+		t.Fatalf("invalid PDU kind: got=%q, want=%q", got, want)
+	}
+	if got := len(nm.Message.Signals); got != 0 {
+		t.Fatalf("invalid number of signals: got=%d, want=0", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	const (
+		head = `<?xml version="1.0" encoding="UTF-8"?>
+<AUTOSAR xmlns="http://autosar.org/schema/r4.0"><AR-PACKAGES><AR-PACKAGE><SHORT-NAME>P</SHORT-NAME><ELEMENTS>`
+		tail = `</ELEMENTS></AR-PACKAGE></AR-PACKAGES></AUTOSAR>`
+
+		cluster = `<CAN-CLUSTER><SHORT-NAME>C</SHORT-NAME><CAN-CLUSTER-VARIANTS><CAN-CLUSTER-CONDITIONAL>
+<PHYSICAL-CHANNELS><CAN-PHYSICAL-CHANNEL><SHORT-NAME>CH</SHORT-NAME><FRAME-TRIGGERINGS>
+<CAN-FRAME-TRIGGERING><SHORT-NAME>FT</SHORT-NAME><FRAME-REF DEST="CAN-FRAME">/P/F</FRAME-REF><IDENTIFIER>%s</IDENTIFIER></CAN-FRAME-TRIGGERING>
+</FRAME-TRIGGERINGS></CAN-PHYSICAL-CHANNEL></PHYSICAL-CHANNELS></CAN-CLUSTER-CONDITIONAL></CAN-CLUSTER-VARIANTS></CAN-CLUSTER>`
+		frame = `<CAN-FRAME><SHORT-NAME>F</SHORT-NAME><FRAME-LENGTH>1</FRAME-LENGTH><PDU-TO-FRAME-MAPPINGS><PDU-TO-FRAME-MAPPING>
+<PDU-REF DEST="I-SIGNAL-I-PDU">/P/PDU</PDU-REF></PDU-TO-FRAME-MAPPING></PDU-TO-FRAME-MAPPINGS></CAN-FRAME>`
+		pdu = `<I-SIGNAL-I-PDU><SHORT-NAME>PDU</SHORT-NAME><LENGTH>1</LENGTH><I-SIGNAL-TO-PDU-MAPPINGS>
+<I-SIGNAL-TO-I-PDU-MAPPING><I-SIGNAL-REF DEST="I-SIGNAL">/P/A</I-SIGNAL-REF><START-POSITION>0</START-POSITION></I-SIGNAL-TO-I-PDU-MAPPING>
+<I-SIGNAL-TO-I-PDU-MAPPING><I-SIGNAL-REF DEST="I-SIGNAL">/P/B</I-SIGNAL-REF><START-POSITION>%s</START-POSITION></I-SIGNAL-TO-I-PDU-MAPPING>
+</I-SIGNAL-TO-PDU-MAPPINGS></I-SIGNAL-I-PDU>
+<I-SIGNAL><SHORT-NAME>A</SHORT-NAME><LENGTH>4</LENGTH></I-SIGNAL>
+<I-SIGNAL><SHORT-NAME>B</SHORT-NAME><LENGTH>4</LENGTH></I-SIGNAL>`
+	)
+
+	for _, tc := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{
+			name: "invalid-xml",
+			src:  `<AUTOSAR><AR-PACKAGES></AUTOSAR>`,
+			err:  "arxml: could not decode XML: XML syntax error on line 1: element <AR-PACKAGES> closed by </AUTOSAR>",
+		},
+		{
+			name: "invalid-root",
+			src:  `<FIBEX></FIBEX>`,
+			err:  `arxml: invalid root element "FIBEX"`,
+		},
+		{
+			name: "ok",
+			src:  head + strings.Replace(cluster, "%s", "1", 1) + frame + strings.Replace(pdu, "%s", "4", 1) + tail,
+		},
+		{
+			name: "unresolved-reference",
+			src:  head + strings.Replace(cluster, "%s", "1", 1) + tail,
+			err:  `arxml: unresolved FRAME-REF "/P/F"`,
+		},
+		{
+			name: "invalid-identifier",
+			src:  head + strings.Replace(cluster, "%s", "0xZZ", 1) + frame + strings.Replace(pdu, "%s", "4", 1) + tail,
+			err:  `arxml: invalid integer "0xZZ"`,
+		},
+		{
+			name: "overlap",
+			src:  head + strings.Replace(cluster, "%s", "1", 1) + frame + strings.Replace(pdu, "%s", "2", 1) + tail,
+			err:  `arxml: cluster "C": invalid message: candb: message "F": signals "A" and "B" overlap`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := arxml.Parse(strings.NewReader(tc.src))
+			switch {
+			case err == nil && tc.err == "":
+				// ok.
+			case err == nil:
+				t.Fatalf("expected an error")
+			case tc.err == "":
+				t.Fatalf("could not parse ARXML: %+v", err)
+			default:
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package arxml
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/go-daq/canbus/candb"
+)
+
+// maxTextTableRange is the maximum number of raw values a single
+// text table compu-scale is expanded into.
+const maxTextTableRange = 256
+
+// loader converts the element tree of an ARXML document into a System.
+type loader struct {
+	root *node
+	idx  map[string]*node
+	e2e  map[*node]*E2E // E2E protections, keyed by PDU
+	err  error
+}
+
+func newLoader(root *node) *loader {
+	return &loader{
+		root: root,
+		idx:  index(root),
+		e2e:  make(map[*node]*E2E),
+	}
+}
+
+func (l *loader) load() (*System, error) {
+	l.loadE2E()
+
+	sys := new(System)
+	for _, cn := range l.root.all("CAN-CLUSTER") {
+		c, err := l.loadCluster(cn)
+		if err != nil {
+			return nil, err
+		}
+		sys.Clusters = append(sys.Clusters, c)
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+	return sys, nil
+}
+
+// loadE2E loads the E2E protection sets of the document.
+func (l *loader) loadE2E() {
+	for _, pn := range l.root.all("END-TO-END-PROTECTION") {
+		prof := pn.child("END-TO-END-PROFILE")
+		e2e := &E2E{
+			Profile:         prof.value("CATEGORY"),
+			DataIDMode:      prof.value("DATA-ID-MODE"),
+			DataLength:      l.int(prof.value("DATA-LENGTH")),
+			CRCOffset:       l.int(prof.value("CRC-OFFSET")),
+			CounterOffset:   l.int(prof.value("COUNTER-OFFSET")),
+			MaxDeltaCounter: l.int(first(prof, "MAX-DELTA-COUNTER-INIT", "MAX-DELTA-COUNTER")),
+		}
+		for _, id := range prof.get("DATA-IDS").children("DATA-ID") {
+			e2e.DataIDs = append(e2e.DataIDs, l.uint(id.text))
+		}
+		for _, ref := range pn.all("I-SIGNAL-I-PDU-REF") {
+			if pdu := l.resolve(ref); pdu != nil {
+				l.e2e[pdu] = e2e
+			}
+		}
+	}
+}
+
+func (l *loader) loadCluster(cn *node) (*Cluster, error) {
+	cond := cn.get("CAN-CLUSTER-VARIANTS", "CAN-CLUSTER-CONDITIONAL")
+	if cond == nil {
+		cond = cn
+	}
+	c := &Cluster{
+		Name:       cn.name(),
+		Baudrate:   l.uint(cond.value("BAUDRATE")),
+		FDBaudrate: l.uint(cond.value("CAN-FD-BAUDRATE")),
+		DB:         new(candb.Database),
+	}
+
+	for _, ch := range cond.get("PHYSICAL-CHANNELS").children("CAN-PHYSICAL-CHANNEL") {
+		for _, ft := range ch.get("FRAME-TRIGGERINGS").children("CAN-FRAME-TRIGGERING") {
+			f, err := l.loadFrame(ch, ft)
+			switch {
+			case l.err != nil:
+				return nil, l.err
+			case err != nil:
+				return nil, fmt.Errorf("arxml: cluster %q: %w", c.Name, err)
+			}
+			c.Frames = append(c.Frames, f)
+			c.DB.Messages = append(c.DB.Messages, f.Message)
+		}
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+
+	err := c.DB.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("arxml: cluster %q: invalid message: %w", c.Name, err)
+	}
+	return c, nil
+}
+
+func (l *loader) loadFrame(ch, ft *node) (*Frame, error) {
+	fn := l.ref(ft, "FRAME-REF")
+	if fn == nil {
+		return nil, fmt.Errorf("frame triggering %q: missing frame", ft.name())
+	}
+
+	msg := &candb.Message{
+		ID:       uint32(l.uint(ft.value("IDENTIFIER"))),
+		Extended: ft.value("CAN-ADDRESSING-MODE") == "EXTENDED",
+		Name:     fn.name(),
+		Size:     l.int(fn.value("FRAME-LENGTH")),
+		Comment:  desc(fn),
+	}
+	f := &Frame{
+		Name:       fn.name(),
+		Triggering: ft.name(),
+		Channel:    ch.name(),
+		FD: ft.value("CAN-FRAME-TX-BEHAVIOR") == "CAN-FD" ||
+			ft.value("CAN-FRAME-RX-BEHAVIOR") == "CAN-FD",
+		Message: msg,
+	}
+
+	var receivers []string
+	for _, ref := range ft.get("FRAME-PORT-REFS").children("FRAME-PORT-REF") {
+		port := l.resolve(ref)
+		ecu := port.ancestor("ECU-INSTANCE")
+		if ecu == nil {
+			continue
+		}
+		switch port.value("COMMUNICATION-DIRECTION") {
+		case "OUT":
+			msg.Sender = ecu.name()
+		case "IN":
+			receivers = append(receivers, ecu.name())
+		}
+	}
+
+	for _, m := range fn.get("PDU-TO-FRAME-MAPPINGS").children("PDU-TO-FRAME-MAPPING") {
+		pn := l.ref(m, "PDU-REF")
+		if pn == nil {
+			return nil, fmt.Errorf("frame %q: missing PDU", f.Name)
+		}
+		pdu := &PDU{
+			Name:   pn.name(),
+			Kind:   pn.tag,
+			Start:  l.int(m.value("START-POSITION")),
+			Length: l.int(pn.value("LENGTH")),
+		}
+		payload := pn
+		if pn.tag == "SECURED-I-PDU" {
+			pdu.SecOC, payload = l.loadSecOC(pn)
+			if payload == nil {
+				return nil, fmt.Errorf("secured PDU %q: missing payload", pdu.Name)
+			}
+		}
+		pdu.E2E = l.e2e[payload]
+		f.PDUs = append(f.PDUs, pdu)
+
+		msg.Signals = append(msg.Signals, l.loadSignals(payload, pdu.Start, receivers)...)
+	}
+
+	return f, nil
+}
+
+// loadSecOC returns the SecOC properties of a secured PDU, and its
+// authentic PDU.
+func (l *loader) loadSecOC(pn *node) (*SecOC, *node) {
+	var (
+		props = pn.child("SECURE-COMMUNICATION-PROPS")
+		auth  = l.ref(pn, "AUTHENTICATION-PROPS-REF")
+		fresh = l.ref(pn, "FRESHNESS-PROPS-REF")
+	)
+
+	payload := l.ref(pn, "PAYLOAD-REF")
+	if payload != nil && payload.tag == "PDU-TRIGGERING" {
+		payload = l.ref(payload, "I-PDU-REF")
+	}
+	if payload == nil {
+		return nil, nil
+	}
+
+	return &SecOC{
+		Payload:                payload.name(),
+		DataID:                 l.uint(props.value("DATA-ID")),
+		AuthAlgorithm:          first2(auth, props, "AUTH-ALGORITHM"),
+		AuthInfoTxLength:       l.int(first2(auth, props, "AUTH-INFO-TX-LENGTH")),
+		FreshnessValueID:       l.uint(props.value("FRESHNESS-VALUE-ID")),
+		FreshnessValueLength:   l.int(first2(fresh, props, "FRESHNESS-VALUE-LENGTH")),
+		FreshnessValueTxLength: l.int(first2(fresh, props, "FRESHNESS-VALUE-TX-LENGTH")),
+	}, payload
+}
+
+// loadSignals returns the I-signals mapped into the provided PDU,
+// located at the offset bit of its frame.
+func (l *loader) loadSignals(pn *node, offset int, receivers []string) []*candb.Signal {
+	var sigs []*candb.Signal
+	for _, m := range pn.get("I-SIGNAL-TO-PDU-MAPPINGS").children("I-SIGNAL-TO-I-PDU-MAPPING") {
+		sn := l.ref(m, "I-SIGNAL-REF")
+		if sn == nil {
+			// mapping of a signal group.
+			continue
+		}
+		sig := l.loadSignal(sn)
+		sig.Receivers = receivers
+
+		pos := offset + l.int(m.value("START-POSITION"))
+		switch m.value("PACKING-BYTE-ORDER") {
+		case "MOST-SIGNIFICANT-BYTE-FIRST":
+			sig.ByteOrder = candb.BigEndian
+			sig.Start = msbStart(pos, sig.Length)
+		default:
+			sig.ByteOrder = candb.LittleEndian
+			sig.Start = pos
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+func (l *loader) loadSignal(sn *node) *candb.Signal {
+	sig := &candb.Signal{
+		Name:   sn.name(),
+		Length: l.int(sn.value("LENGTH")),
+		Factor: 1,
+	}
+
+	sys := l.ref(sn, "SYSTEM-SIGNAL-REF")
+	sig.Comment = desc(sys)
+	if sig.Comment == "" {
+		sig.Comment = desc(sn)
+	}
+
+	var (
+		netProps  = sn.get("NETWORK-REPRESENTATION-PROPS", "SW-DATA-DEF-PROPS-VARIANTS", "SW-DATA-DEF-PROPS-CONDITIONAL")
+		physProps = sys.get("PHYSICAL-PROPS", "SW-DATA-DEF-PROPS-VARIANTS", "SW-DATA-DEF-PROPS-CONDITIONAL")
+		refOf     = func(tag string) *node {
+			if n := l.ref(netProps, tag); n != nil {
+				return n
+			}
+			return l.ref(physProps, tag)
+		}
+	)
+
+	switch base := refOf("BASE-TYPE-REF"); base.value("BASE-TYPE-ENCODING") {
+	case "2C":
+		sig.Type = candb.Signed
+	case "IEEE754":
+		sig.Type = candb.Float
+	}
+
+	cm := refOf("COMPU-METHOD-REF")
+	lo, hi, limits := l.loadCompuMethod(sig, cm)
+
+	unit := refOf("UNIT-REF")
+	if unit == nil {
+		unit = l.ref(cm, "UNIT-REF")
+	}
+	if unit != nil {
+		sig.Unit = first(unit, "DISPLAY-NAME", "SHORT-NAME")
+	}
+
+	if !l.loadDataConstr(sig, refOf("DATA-CONSTR-REF")) && limits {
+		sig.Min, sig.Max = physRange(sig, lo, hi)
+	}
+	return sig
+}
+
+// loadCompuMethod sets the scaling and value descriptions of the
+// signal from its compu-method, and returns the internal limits of
+// its linear scale, if any.
+//
+// Only the first linear scale of the compu-method is used.
+func (l *loader) loadCompuMethod(sig *candb.Signal, cm *node) (lo, hi float64, ok bool) {
+	linear := false
+	for _, scale := range cm.get("COMPU-INTERNAL-TO-PHYS", "COMPU-SCALES").children("COMPU-SCALE") {
+		if c := scale.child("COMPU-CONST"); c != nil {
+			from := l.int(scale.value("LOWER-LIMIT"))
+			to := from
+			if v := scale.value("UPPER-LIMIT"); v != "" {
+				to = l.int(v)
+			}
+			if to-from >= maxTextTableRange {
+				to = from
+			}
+			for v := from; v <= to; v++ {
+				sig.Values = append(sig.Values, candb.ValueDesc{
+					Value: int64(v),
+					Desc:  first(c, "VT", "V"),
+				})
+			}
+			continue
+		}
+
+		coeffs := scale.child("COMPU-RATIONAL-COEFFS")
+		if coeffs == nil || linear {
+			continue
+		}
+		linear = true
+		var (
+			num = coeffs.get("COMPU-NUMERATOR").children("V")
+			den = coeffs.get("COMPU-DENOMINATOR").children("V")
+			d   = 1.0
+		)
+		if len(den) > 0 {
+			d = l.float(den[0].text)
+		}
+		if len(num) > 0 {
+			sig.Offset = l.float(num[0].text) / d
+		}
+		if len(num) > 1 {
+			sig.Factor = l.float(num[1].text) / d
+		}
+
+		lower, upper := scale.value("LOWER-LIMIT"), scale.value("UPPER-LIMIT")
+		if lower != "" && upper != "" {
+			lo, hi, ok = l.float(lower), l.float(upper), true
+		}
+	}
+	return lo, hi, ok
+}
+
+// loadDataConstr sets the range of the signal from its data
+// constraints, and reports whether a range was found.
+func (l *loader) loadDataConstr(sig *candb.Signal, dc *node) bool {
+	rule := dc.get("DATA-CONSTR-RULES", "DATA-CONSTR-RULE")
+	if c := rule.child("PHYS-CONSTRS"); c != nil {
+		sig.Min = finite(l.float(c.value("LOWER-LIMIT")))
+		sig.Max = finite(l.float(c.value("UPPER-LIMIT")))
+		return true
+	}
+	if c := rule.child("INTERNAL-CONSTRS"); c != nil {
+		sig.Min, sig.Max = physRange(sig, l.float(c.value("LOWER-LIMIT")), l.float(c.value("UPPER-LIMIT")))
+		return true
+	}
+	return false
+}
+
+// ref resolves the reference held by the child element of n
+// reached by following path.
+func (l *loader) ref(n *node, path ...string) *node {
+	return l.resolve(n.get(path...))
+}
+
+func (l *loader) resolve(ref *node) *node {
+	if ref == nil || ref.text == "" {
+		return nil
+	}
+	n, ok := l.idx[ref.text]
+	if !ok {
+		l.fail(fmt.Errorf("arxml: unresolved %s %q", ref.tag, ref.text))
+		return nil
+	}
+	return n
+}
+
+func (l *loader) fail(err error) {
+	if l.err == nil {
+		l.err = err
+	}
+}
+
+func (l *loader) uint(s string) uint64 {
+	if s == "" {
+		return 0
+	}
+	v, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		l.fail(fmt.Errorf("arxml: invalid integer %q", s))
+	}
+	return v
+}
+
+func (l *loader) int(s string) int {
+	if s == "" {
+		return 0
+	}
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		l.fail(fmt.Errorf("arxml: invalid integer %q", s))
+	}
+	return int(v)
+}
+
+func (l *loader) float(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		l.fail(fmt.Errorf("arxml: invalid number %q", s))
+	}
+	return v
+}
+
+// desc returns the description of the element.
+func desc(n *node) string {
+	return n.value("DESC", "L-2")
+}
+
+// first returns the first non-empty value among the provided child
+// elements of n.
+func first(n *node, tags ...string) string {
+	for _, tag := range tags {
+		if v := n.value(tag); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// first2 returns the first non-empty value of the child element tag
+// of a or b.
+func first2(a, b *node, tag string) string {
+	if v := a.value(tag); v != "" {
+		return v
+	}
+	return b.value(tag)
+}
+
+// msbStart converts the position of the least significant bit of a
+// big-endian signal, as used by AUTOSAR, into the position of its most
+// significant bit, as used by candb.
+func msbStart(lsb, length int) int {
+	lin := lsb/8*8 + 7 - lsb%8 - (length - 1)
+	if lin < 0 {
+		return -1
+	}
+	return lin/8*8 + 7 - lin%8
+}
+
+// physRange converts a range of raw values into a range of physical
+// values.
+func physRange(sig *candb.Signal, lo, hi float64) (min, max float64) {
+	min = finite(lo*sig.Factor + sig.Offset)
+	max = finite(hi*sig.Factor + sig.Offset)
+	if min > max {
+		min, max = max, min
+	}
+	return min, max
+}
+
+// finite returns v, or 0 if v is infinite or not a number.
+func finite(v float64) float64 {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,168 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package arxml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// node is an element of an ARXML document.
+type node struct {
+	tag    string // local name of the element
+	text   string // trimmed character data of the element
+	parent *node
+	kids   []*node
+}
+
+// readTree reads the element tree of an XML document.
+func readTree(r io.Reader) (*node, error) {
+	var (
+		dec  = xml.NewDecoder(r)
+		root *node
+		cur  *node
+		text strings.Builder
+	)
+	dec.Strict = true
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("arxml: could not decode XML: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &node{tag: tok.Name.Local, parent: cur}
+			switch cur {
+			case nil:
+				if root != nil {
+					return nil, fmt.Errorf("arxml: multiple root elements")
+				}
+				root = n
+			default:
+				cur.kids = append(cur.kids, n)
+			}
+			cur = n
+			text.Reset()
+		case xml.EndElement:
+			cur.text = strings.TrimSpace(text.String())
+			text.Reset()
+			cur = cur.parent
+		case xml.CharData:
+			text.Write(tok)
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("arxml: empty document")
+	}
+	return root, nil
+}
+
+// child returns the first child element named tag, or nil.
+func (n *node) child(tag string) *node {
+	if n == nil {
+		return nil
+	}
+	for _, k := range n.kids {
+		if k.tag == tag {
+			return k
+		}
+	}
+	return nil
+}
+
+// get returns the element reached by following the provided path of
+// child element names, or nil.
+func (n *node) get(path ...string) *node {
+	for _, tag := range path {
+		n = n.child(tag)
+	}
+	return n
+}
+
+// value returns the text of the element reached by following the
+// provided path, or an empty string.
+func (n *node) value(path ...string) string {
+	n = n.get(path...)
+	if n == nil {
+		return ""
+	}
+	return n.text
+}
+
+// children returns the child elements named tag.
+func (n *node) children(tag string) []*node {
+	if n == nil {
+		return nil
+	}
+	var out []*node
+	for _, k := range n.kids {
+		if k.tag == tag {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// all returns the descendant elements named tag, in document order.
+func (n *node) all(tag string) []*node {
+	var out []*node
+	var walk func(n *node)
+	walk = func(n *node) {
+		for _, k := range n.kids {
+			if k.tag == tag {
+				out = append(out, k)
+			}
+			walk(k)
+		}
+	}
+	if n != nil {
+		walk(n)
+	}
+	return out
+}
+
+// name returns the short name of the element.
+func (n *node) name() string {
+	return n.value("SHORT-NAME")
+}
+
+// ancestor returns the closest ancestor element named tag, or nil.
+func (n *node) ancestor(tag string) *node {
+	if n == nil {
+		return nil
+	}
+	for p := n.parent; p != nil; p = p.parent {
+		if p.tag == tag {
+			return p
+		}
+	}
+	return nil
+}
+
+// index returns the elements with a short name of the tree, keyed by
+// their absolute AUTOSAR path.
+func index(root *node) map[string]*node {
+	idx := make(map[string]*node)
+	var walk func(n *node, path string)
+	walk = func(n *node, path string) {
+		if name := n.name(); name != "" {
+			path += "/" + name
+			idx[path] = n
+		}
+		for _, k := range n.kids {
+			if k.tag == "SHORT-NAME" {
+				continue
+			}
+			walk(k, path)
+		}
+	}
+	walk(root, "")
+	return idx
+}
@@ -11,4 +11,9 @@
 //	for {
 //	    msg, err := sck.Recv()
 //	}
+//
+// Sockets implement the Transport interface, through which the other
+// packages and commands exchange frames, so that they may also be used
+// with other kinds of CAN buses, such as the in-memory buses of package
+// vcan.
 package canbus
@@ -0,0 +1,232 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package candb provides a format-agnostic description of the messages
+// and signals exchanged over a CAN network, and the means to decode
+// CAN frames into physical signal values and to encode physical signal
+// values into CAN frames.
+//
+// Message definitions may be built in code or imported from network
+// description files (e.g. with the dbc package).
+//
+// A typical usage might look like:
+//
+//	db := &candb.Database{Messages: msgs}
+//	vals := make(map[string]float64)
+//	for {
+//	    frame, err := sck.Recv()
+//	    msg, err := db.Decode(frame, vals)
+//	}
+package candb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-daq/canbus"
+)
+
+var (
+	// ErrUnknownMessage is returned when decoding a frame whose
+	// identifier is not described in the database.
+	ErrUnknownMessage = errors.New("candb: unknown message")
+
+	// ErrShortFrame is returned when a frame is too short to hold
+	// some of the signals of its message.
+	ErrShortFrame = errors.New("candb: frame too short")
+
+	// ErrRange is returned when a signal value lies outside of its
+	// allowed range.
+	ErrRange = errors.New("candb: value out of range")
+)
+
+// Database is a collection of message definitions.
+type Database struct {
+	Messages []*Message
+}
+
+// Message returns the message with the provided identifier, or nil.
+func (db *Database) Message(id uint32, extended bool) *Message {
+	for _, msg := range db.Messages {
+		if msg.ID == id && msg.Extended == extended {
+			return msg
+		}
+	}
+	return nil
+}
+
+// MessageByName returns the message named name, or nil.
+func (db *Database) MessageByName(name string) *Message {
+	for _, msg := range db.Messages {
+		if msg.Name == name {
+			return msg
+		}
+	}
+	return nil
+}
+
+// Lookup returns the message describing the provided frame, or nil.
+func (db *Database) Lookup(f canbus.Frame) *Message {
+	return db.Message(f.ID, f.Kind == canbus.EFF)
+}
+
+// Decode decodes the provided frame with the matching message of the
+// database, as described in Message.Decode.
+func (db *Database) Decode(f canbus.Frame, vals map[string]float64) (*Message, error) {
+	msg := db.Lookup(f)
+	if msg == nil {
+		return nil, ErrUnknownMessage
+	}
+	return msg, msg.Decode(f, vals)
+}
+
+// Validate checks the consistency of all the messages of the database.
+func (db *Database) Validate() error {
+	for _, msg := range db.Messages {
+		err := msg.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Message describes a CAN frame and the signals it carries.
+type Message struct {
+	ID       uint32 // CAN identifier
+	Extended bool   // whether the message uses the extended frame format
+	Name     string
+	Size     int    // size of the message payload, in bytes
+	Sender   string // name of the transmitting node
+	Comment  string
+	Signals  []*Signal
+}
+
+// Signal returns the signal named name, or nil.
+func (msg *Message) Signal(name string) *Signal {
+	for _, sig := range msg.Signals {
+		if sig.Name == name {
+			return sig
+		}
+	}
+	return nil
+}
+
+// Kind returns the frame format of the message.
+func (msg *Message) Kind() canbus.Kind {
+	if msg.Extended {
+		return canbus.EFF
+	}
+	return canbus.SFF
+}
+
+// ByteOrder describes how the bits of a signal are laid out in a message.
+type ByteOrder uint8
+
+const (
+	// LittleEndian is the Intel byte order.
+	// The start bit of the signal is the position of its least
+	// significant bit.
+	LittleEndian ByteOrder = iota
+	// BigEndian is the Motorola byte order.
+	// The start bit of the signal is the position of its most
+	// significant bit, bits being numbered from the least significant
+	// bit of the first byte (0) to the most significant bit of the
+	// last byte.
+	BigEndian
+)
+
+func (bo ByteOrder) String() string {
+	switch bo {
+	case LittleEndian:
+		return "little-endian"
+	case BigEndian:
+		return "big-endian"
+	}
+	return fmt.Sprintf("ByteOrder(%d)", uint8(bo))
+}
+
+// Type describes the encoding of the raw value of a signal.
+type Type uint8
+
+const (
+	Unsigned Type = iota // unsigned integer
+	Signed               // two's complement signed integer
+	Float                // IEEE 754 floating point, 32 or 64 bits long
+)
+
+func (t Type) String() string {
+	switch t {
+	case Unsigned:
+		return "unsigned"
+	case Signed:
+		return "signed"
+	case Float:
+		return "float"
+	}
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
+// Signal describes a value encoded in a message.
+//
+// The physical value of a signal is computed from its raw value as:
+//
+//	phys = raw*Factor + Offset
+type Signal struct {
+	Name      string
+	Start     int // start bit
+	Length    int // length in bits
+	ByteOrder ByteOrder
+	Type      Type
+
+	Factor float64
+	Offset float64
+	// Min and Max define the allowed range of physical values.
+	// The range is not checked when Min and Max are both zero.
+	Min  float64
+	Max  float64
+	Unit string
+
+	Receivers []string
+	Values    []ValueDesc // descriptions of raw values
+	Comment   string
+
+	// Mux is the multiplexer switch signal this signal depends on.
+	// A multiplexed signal is only present in a frame when its switch
+	// is present and holds a raw value within MuxRanges.
+	// Mux is nil for signals that are always present.
+	Mux       *Signal
+	MuxRanges []MuxRange
+}
+
+// MuxRange is an inclusive range of multiplexer switch raw values.
+type MuxRange struct {
+	Min uint64
+	Max uint64
+}
+
+// ValueDesc associates a description to a raw signal value.
+type ValueDesc struct {
+	Value int64
+	Desc  string
+}
+
+// Desc returns the description associated with the provided raw value.
+func (sig *Signal) Desc(raw int64) (string, bool) {
+	for _, v := range sig.Values {
+		if v.Value == raw {
+			return v.Desc, true
+		}
+	}
+	return "", false
+}
+
+// InRange reports whether the physical value v lies within the
+// allowed range of the signal.
+func (sig *Signal) InRange(v float64) bool {
+	if sig.Min == 0 && sig.Max == 0 {
+		return true
+	}
+	return sig.Min <= v && v <= sig.Max
+}
@@ -0,0 +1,461 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package candb
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-daq/canbus"
+)
+
+const (
+	maxSize = 64 // maximum payload size of a CAN (FD) frame
+
+	sffMask = 0x7ff      // standard frame format identifier mask
+	effMask = 0x1fffffff // extended frame format identifier mask
+)
+
+// Decode decodes the physical values of the signals of msg carried by
+// the provided frame into vals, keyed by signal name.
+//
+// Multiplexed signals that are not present in the frame are removed
+// from vals.
+// Decode does not allocate once vals holds an entry for each signal
+// of the message.
+//
+// Decode returns ErrShortFrame if some signals could not be decoded
+// because the frame is too short, and ErrRange if some decoded values
+// lie outside of their allowed range. In both cases, all the other
+// signals are decoded.
+func (msg *Message) Decode(f canbus.Frame, vals map[string]float64) error {
+	var err error
+	for _, sig := range msg.Signals {
+		if !sig.fits(f.Data) {
+			delete(vals, sig.Name)
+			err = ErrShortFrame
+			continue
+		}
+		if !sig.active(f.Data, len(msg.Signals)) {
+			delete(vals, sig.Name)
+			continue
+		}
+		v := sig.phys(sig.raw(f.Data))
+		if !sig.InRange(v) && err == nil {
+			err = ErrRange
+		}
+		vals[sig.Name] = v
+	}
+	return err
+}
+
+// Encode encodes the provided physical values, keyed by signal name,
+// into a new frame.
+//
+// Values must be provided for all the signals present in the frame,
+// i.e. all the signals that are not multiplexed and the multiplexed
+// signals selected by their multiplexer switch values.
+// Values for signals absent from the frame are ignored.
+func (msg *Message) Encode(vals map[string]float64) (canbus.Frame, error) {
+	frame := canbus.Frame{
+		ID:   msg.ID,
+		Data: make([]byte, msg.Size),
+		Kind: msg.Kind(),
+	}
+	err := msg.EncodeTo(frame.Data, vals)
+	if err != nil {
+		return canbus.Frame{}, err
+	}
+	return frame, nil
+}
+
+// EncodeTo encodes the provided physical values, keyed by signal name,
+// into data, as described in Encode.
+func (msg *Message) EncodeTo(data []byte, vals map[string]float64) error {
+	// encode multiplexer switches before the signals they select.
+	for depth, done := 0, false; !done; depth++ {
+		done = true
+		for _, sig := range msg.Signals {
+			d, ok := sig.depth(len(msg.Signals))
+			if !ok {
+				return fmt.Errorf(
+					"candb: message %q: signal %q: multiplexer cycle",
+					msg.Name, sig.Name,
+				)
+			}
+			if d > depth {
+				done = false
+			}
+			if d != depth || !sig.active(data, d) {
+				continue
+			}
+			v, ok := vals[sig.Name]
+			if !ok {
+				return fmt.Errorf(
+					"candb: missing value for signal %q of message %q",
+					sig.Name, msg.Name,
+				)
+			}
+			err := sig.Encode(data, v)
+			if err != nil {
+				return fmt.Errorf("candb: could not encode message %q: %w", msg.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Decode decodes the physical value of the signal from data.
+// Decode does not check whether the signal is present in data when
+// the signal is multiplexed.
+func (sig *Signal) Decode(data []byte) (float64, error) {
+	if !sig.fits(data) {
+		return 0, ErrShortFrame
+	}
+	return sig.phys(sig.raw(data)), nil
+}
+
+// Encode encodes the physical value v of the signal into data.
+func (sig *Signal) Encode(data []byte, v float64) error {
+	if !sig.fits(data) {
+		return ErrShortFrame
+	}
+	if !sig.InRange(v) {
+		return fmt.Errorf(
+			"candb: signal %q: value %v outside of [%v, %v]: %w",
+			sig.Name, v, sig.Min, sig.Max, ErrRange,
+		)
+	}
+	raw, ok := sig.toRaw(v)
+	if !ok {
+		return fmt.Errorf(
+			"candb: signal %q: value %v does not fit in %d bits: %w",
+			sig.Name, v, sig.Length, ErrRange,
+		)
+	}
+	sig.setRaw(data, raw)
+	return nil
+}
+
+// Raw extracts the raw value of the signal from data.
+func (sig *Signal) Raw(data []byte) (uint64, error) {
+	if !sig.fits(data) {
+		return 0, ErrShortFrame
+	}
+	return sig.raw(data), nil
+}
+
+// bounds returns the indices of the first and last bytes spanned by
+// the signal.
+//
+// Big-endian signals are handled in "linear" big-endian bit numbering,
+// where bit 0 is the most significant bit of the first byte.
+func (sig *Signal) bounds() (first, last int) {
+	switch sig.ByteOrder {
+	case BigEndian:
+		lin := sig.Start/8*8 + 7 - sig.Start%8
+		return lin / 8, (lin + sig.Length - 1) / 8
+	default:
+		return sig.Start / 8, (sig.Start + sig.Length - 1) / 8
+	}
+}
+
+// shift returns the left shift to apply to byte i of the frame data
+// to align it with the raw value of the signal.
+func (sig *Signal) shift(i int) int {
+	switch sig.ByteOrder {
+	case BigEndian:
+		lin := sig.Start/8*8 + 7 - sig.Start%8
+		return lin + sig.Length - 8 - 8*i
+	default:
+		return 8*i - sig.Start
+	}
+}
+
+func (sig *Signal) mask() uint64 {
+	if sig.Length >= 64 {
+		return math.MaxUint64
+	}
+	return 1<<uint(sig.Length) - 1
+}
+
+func (sig *Signal) fits(data []byte) bool {
+	_, last := sig.bounds()
+	return last < len(data)
+}
+
+func (sig *Signal) raw(data []byte) uint64 {
+	var v uint64
+	first, last := sig.bounds()
+	for i := first; i <= last; i++ {
+		b := uint64(data[i])
+		switch off := sig.shift(i); {
+		case off < 0:
+			v |= b >> uint(-off)
+		default:
+			v |= b << uint(off)
+		}
+	}
+	return v & sig.mask()
+}
+
+func (sig *Signal) setRaw(data []byte, raw uint64) {
+	mask := sig.mask()
+	raw &= mask
+	first, last := sig.bounds()
+	for i := first; i <= last; i++ {
+		var v, m byte
+		switch off := sig.shift(i); {
+		case off < 0:
+			v, m = byte(raw<<uint(-off)), byte(mask<<uint(-off))
+		default:
+			v, m = byte(raw>>uint(off)), byte(mask>>uint(off))
+		}
+		data[i] = data[i]&^m | v&m
+	}
+}
+
+// phys converts a raw value into a physical value.
+func (sig *Signal) phys(raw uint64) float64 {
+	var v float64
+	switch sig.Type {
+	case Signed:
+		n := uint(64 - sig.Length)
+		v = float64(int64(raw<<n) >> n)
+	case Float:
+		if sig.Length == 32 {
+			v = float64(math.Float32frombits(uint32(raw)))
+		} else {
+			v = math.Float64frombits(raw)
+		}
+	default:
+		v = float64(raw)
+	}
+	return v*sig.Factor + sig.Offset
+}
+
+// toRaw converts a physical value into a raw value, reporting whether
+// the raw value fits in the signal.
+func (sig *Signal) toRaw(v float64) (uint64, bool) {
+	x := (v - sig.Offset) / sig.Factor
+	switch sig.Type {
+	case Float:
+		if sig.Length == 32 {
+			return uint64(math.Float32bits(float32(x))), true
+		}
+		return math.Float64bits(x), true
+	case Signed:
+		x = math.Round(x)
+		lim := math.Ldexp(1, sig.Length-1)
+		if !(-lim <= x && x < lim) {
+			return 0, false
+		}
+		return uint64(int64(x)), true
+	default:
+		x = math.Round(x)
+		if !(0 <= x && x < math.Ldexp(1, sig.Length)) {
+			return 0, false
+		}
+		return uint64(x), true
+	}
+}
+
+// active reports whether the signal is present in data, according to
+// the values of its multiplexer switches.
+// Signals depending on more than max switches, as with multiplexer
+// cycles, are not present.
+func (sig *Signal) active(data []byte, max int) bool {
+	for s, n := sig, 0; s.Mux != nil; s, n = s.Mux, n+1 {
+		if n >= max {
+			return false
+		}
+		if !s.Mux.fits(data) {
+			return false
+		}
+		if !inRanges(s.MuxRanges, s.Mux.raw(data)) {
+			return false
+		}
+	}
+	return true
+}
+
+// depth returns the number of multiplexer switches the signal depends on.
+// depth reports false if the signal depends on more than max switches,
+// as with multiplexer cycles.
+func (sig *Signal) depth(max int) (int, bool) {
+	n := 0
+	for s := sig.Mux; s != nil; s = s.Mux {
+		n++
+		if n > max {
+			return n, false
+		}
+	}
+	return n, true
+}
+
+func inRanges(ranges []MuxRange, v uint64) bool {
+	for _, r := range ranges {
+		if r.Min <= v && v <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the consistency of the message definition: signal
+// lengths and types, signals extending past the end of the message
+// and signals overlapping each other.
+func (msg *Message) Validate() error {
+	if msg.Size < 0 || msg.Size > maxSize {
+		return fmt.Errorf("candb: message %q: invalid size %d", msg.Name, msg.Size)
+	}
+	if msg.Extended && msg.ID > effMask || !msg.Extended && msg.ID > sffMask {
+		return fmt.Errorf("candb: message %q: invalid identifier 0x%x", msg.Name, msg.ID)
+	}
+
+	masks := make([][maxSize / 8]uint64, len(msg.Signals))
+	for i, sig := range msg.Signals {
+		err := msg.validateSignal(sig)
+		if err != nil {
+			return err
+		}
+		masks[i] = sig.bits()
+		for j, other := range msg.Signals[:i] {
+			if other.Name == sig.Name {
+				return fmt.Errorf("candb: message %q: duplicate signal %q", msg.Name, sig.Name)
+			}
+			if !overlap(masks[i], masks[j]) || exclusive(sig, other) {
+				continue
+			}
+			return fmt.Errorf(
+				"candb: message %q: signals %q and %q overlap",
+				msg.Name, other.Name, sig.Name,
+			)
+		}
+	}
+	return nil
+}
+
+func (msg *Message) validateSignal(sig *Signal) error {
+	switch {
+	case sig.Length <= 0 || sig.Length > 64:
+		return fmt.Errorf(
+			"candb: message %q: signal %q: invalid length %d",
+			msg.Name, sig.Name, sig.Length,
+		)
+	case sig.Type == Float && sig.Length != 32 && sig.Length != 64:
+		return fmt.Errorf(
+			"candb: message %q: signal %q: invalid float length %d",
+			msg.Name, sig.Name, sig.Length,
+		)
+	case sig.Factor == 0:
+		return fmt.Errorf(
+			"candb: message %q: signal %q: invalid zero factor",
+			msg.Name, sig.Name,
+		)
+	case sig.Start < 0 || sig.Start >= 8*maxSize:
+		return fmt.Errorf(
+			"candb: message %q: signal %q: invalid start bit %d",
+			msg.Name, sig.Name, sig.Start,
+		)
+	}
+	first, last := sig.bounds()
+	if first < 0 || last >= msg.Size {
+		return fmt.Errorf(
+			"candb: message %q: signal %q: bits past the end of the %d-byte message",
+			msg.Name, sig.Name, msg.Size,
+		)
+	}
+
+	for s, n := sig, 0; s.Mux != nil; s, n = s.Mux, n+1 {
+		switch {
+		case n > len(msg.Signals):
+			return fmt.Errorf(
+				"candb: message %q: signal %q: multiplexer cycle",
+				msg.Name, sig.Name,
+			)
+		case !msg.owns(s.Mux):
+			return fmt.Errorf(
+				"candb: message %q: signal %q: multiplexer %q not in message",
+				msg.Name, s.Name, s.Mux.Name,
+			)
+		case s.Mux.Type == Float:
+			return fmt.Errorf(
+				"candb: message %q: signal %q: invalid float multiplexer %q",
+				msg.Name, s.Name, s.Mux.Name,
+			)
+		case len(s.MuxRanges) == 0:
+			return fmt.Errorf(
+				"candb: message %q: signal %q: no multiplexer values",
+				msg.Name, s.Name,
+			)
+		}
+	}
+	return nil
+}
+
+func (msg *Message) owns(sig *Signal) bool {
+	for _, s := range msg.Signals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// bits returns the bit mask of the signal over a maximum-size frame.
+func (sig *Signal) bits() [maxSize / 8]uint64 {
+	var m [maxSize / 8]uint64
+	set := func(pos int) {
+		m[pos/64] |= 1 << uint(pos%64)
+	}
+	switch sig.ByteOrder {
+	case BigEndian:
+		lin := sig.Start/8*8 + 7 - sig.Start%8
+		for k := 0; k < sig.Length; k++ {
+			q := lin + k
+			set(q/8*8 + 7 - q%8)
+		}
+	default:
+		for k := 0; k < sig.Length; k++ {
+			set(sig.Start + k)
+		}
+	}
+	return m
+}
+
+func overlap(a, b [maxSize / 8]uint64) bool {
+	for i := range a {
+		if a[i]&b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// exclusive reports whether the two signals can never be present in
+// the same frame, because they require disjoint values of a common
+// multiplexer switch.
+func exclusive(a, b *Signal) bool {
+	for sa := a; sa.Mux != nil; sa = sa.Mux {
+		for sb := b; sb.Mux != nil; sb = sb.Mux {
+			if sa.Mux == sb.Mux && disjoint(sa.MuxRanges, sb.MuxRanges) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func disjoint(a, b []MuxRange) bool {
+	for _, ra := range a {
+		for _, rb := range b {
+			if ra.Min <= rb.Max && rb.Min <= ra.Max {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,493 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package candb_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/candb"
+)
+
+func TestSignalDecode(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		sig  candb.Signal
+		data []byte
+		want float64
+	}{
+		{
+			name: "intel-u16",
+			sig:  candb.Signal{Start: 0, Length: 16, Factor: 1},
+			data: []byte{0x34, 0x12},
+			want: 0x1234,
+		},
+		{
+			name: "intel-u12-unaligned",
+			sig:  candb.Signal{Start: 4, Length: 12, Factor: 1},
+			data: []byte{0x4f, 0x23, 0xff},
+			want: 0x234,
+		},
+		{
+			name: "intel-u64",
+			sig:  candb.Signal{Start: 0, Length: 64, Factor: 1},
+			data: []byte{0, 0, 0, 0, 0, 0, 0, 0x80},
+			want: 1 << 63,
+		},
+		{
+			name: "intel-u33-crossing",
+			sig:  candb.Signal{Start: 7, Length: 33, Factor: 1},
+			data: []byte{0x80, 0xff, 0xff, 0xff, 0xff, 0xff},
+			want: 1<<33 - 1,
+		},
+		{
+			name: "motorola-u8",
+			sig:  candb.Signal{Start: 7, Length: 8, ByteOrder: candb.BigEndian, Factor: 1},
+			data: []byte{0xab},
+			want: 0xab,
+		},
+		{
+			name: "motorola-u16",
+			sig:  candb.Signal{Start: 15, Length: 16, ByteOrder: candb.BigEndian, Factor: 1},
+			data: []byte{0xff, 0x12, 0x34, 0xff},
+			want: 0x1234,
+		},
+		{
+			name: "motorola-u10",
+			sig:  candb.Signal{Start: 31, Length: 10, ByteOrder: candb.BigEndian, Factor: 0.5},
+			data: []byte{0, 0, 0, 0xff, 0x40, 0, 0, 0},
+			want: 0x3fd * 0.5,
+		},
+		{
+			name: "motorola-u4-mid-byte",
+			sig:  candb.Signal{Start: 5, Length: 4, ByteOrder: candb.BigEndian, Factor: 1},
+			data: []byte{0x28},
+			want: 0xa,
+		},
+		{
+			name: "motorola-u12-crossing",
+			sig:  candb.Signal{Start: 3, Length: 12, ByteOrder: candb.BigEndian, Factor: 1},
+			data: []byte{0xfa, 0xbc, 0xff},
+			want: 0xabc,
+		},
+		{
+			name: "intel-s8",
+			sig:  candb.Signal{Start: 16, Length: 8, Type: candb.Signed, Factor: 1, Offset: -40},
+			data: []byte{0, 0, 0xfe},
+			want: -42,
+		},
+		{
+			name: "motorola-s12",
+			sig:  candb.Signal{Start: 7, Length: 12, ByteOrder: candb.BigEndian, Type: candb.Signed, Factor: 0.5},
+			data: []byte{0x80, 0x0f},
+			want: -2048 * 0.5,
+		},
+		{
+			name: "intel-f32",
+			sig:  candb.Signal{Start: 32, Length: 32, Type: candb.Float, Factor: 1},
+			data: []byte{0, 0, 0, 0, 0x00, 0x00, 0xc0, 0x3f},
+			want: 1.5,
+		},
+		{
+			name: "motorola-f64",
+			sig:  candb.Signal{Start: 7, Length: 64, ByteOrder: candb.BigEndian, Type: candb.Float, Factor: 2},
+			data: []byte{0xc0, 0x09, 0x21, 0xfb, 0x54, 0x44, 0x2d, 0x18},
+			want: -2 * math.Pi,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.sig.Decode(tc.data)
+			if err != nil {
+				t.Fatalf("could not decode signal: %+v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("invalid value: got=%v, want=%v", got, tc.want)
+			}
+
+			data := make([]byte, len(tc.data))
+			for i := range data {
+				data[i] = 0xff ^ tc.data[i]
+			}
+			err = tc.sig.Encode(data, tc.want)
+			if err != nil {
+				t.Fatalf("could not encode signal: %+v", err)
+			}
+			got, err = tc.sig.Decode(data)
+			if err != nil {
+				t.Fatalf("could not decode signal: %+v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("invalid round-trip value: got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSignalEncode(t *testing.T) {
+	sig := candb.Signal{Start: 4, Length: 8, Factor: 1, ByteOrder: candb.BigEndian}
+	data := []byte{0xff, 0xff}
+	err := sig.Encode(data, 0)
+	if err != nil {
+		t.Fatalf("could not encode: %+v", err)
+	}
+	// bits 4..0 of byte 0 and 7..5 of byte 1.
+	if got, want := data, []byte{0xe0, 0x1f}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid data: got=%x, want=%x", got, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		sig  candb.Signal
+		v    float64
+	}{
+		{"overflow", candb.Signal{Length: 4, Factor: 1}, 16},
+		{"underflow", candb.Signal{Length: 4, Factor: 1}, -1},
+		{"signed-overflow", candb.Signal{Length: 4, Factor: 1, Type: candb.Signed}, 8},
+		{"signed-underflow", candb.Signal{Length: 4, Factor: 1, Type: candb.Signed}, -9},
+		{"range", candb.Signal{Length: 8, Factor: 1, Min: 0, Max: 100}, 101},
+		{"scaled-overflow", candb.Signal{Length: 8, Factor: 0.1}, 25.6},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.sig.Encode(make([]byte, 8), tc.v)
+			if !errors.Is(err, candb.ErrRange) {
+				t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrRange)
+			}
+		})
+	}
+
+	err = (&candb.Signal{Start: 60, Length: 8, Factor: 1}).Encode(make([]byte, 8), 1)
+	if !errors.Is(err, candb.ErrShortFrame) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrShortFrame)
+	}
+}
+
+func newMuxMessage() *candb.Message {
+	mode := &candb.Signal{Name: "Mode", Start: 0, Length: 8, Factor: 1}
+	sub := &candb.Signal{
+		Name: "Sub", Start: 8, Length: 8, Factor: 1,
+		Mux: mode, MuxRanges: []candb.MuxRange{{1, 1}},
+	}
+	return &candb.Message{
+		ID:       0x160,
+		Extended: true,
+		Name:     "Diagnostics",
+		Size:     8,
+		Signals: []*candb.Signal{
+			mode,
+			sub,
+			{
+				Name: "Counter", Start: 56, Length: 8, Factor: 1,
+			},
+			{
+				Name: "Voltage", Start: 8, Length: 16, Factor: 0.001, Unit: "V",
+				Mux: mode, MuxRanges: []candb.MuxRange{{0, 0}},
+			},
+			{
+				Name: "Current", Start: 16, Length: 32, Type: candb.Signed, Factor: 0.5,
+				Mux: mode, MuxRanges: []candb.MuxRange{{2, 2}, {4, 6}},
+			},
+			{
+				Name: "Temperature", Start: 16, Length: 16, Factor: 0.1, Offset: -273.15,
+				Min: -273.15, Max: 1000,
+				Mux: sub, MuxRanges: []candb.MuxRange{{3, 3}},
+			},
+			{
+				Name: "Pressure", Start: 23, Length: 16, ByteOrder: candb.BigEndian, Factor: 1,
+				Mux: sub, MuxRanges: []candb.MuxRange{{4, 4}},
+			},
+		},
+	}
+}
+
+func TestMessageMux(t *testing.T) {
+	msg := newMuxMessage()
+	err := msg.Validate()
+	if err != nil {
+		t.Fatalf("invalid message: %+v", err)
+	}
+
+	for _, tc := range []map[string]float64{
+		{"Mode": 0, "Voltage": 12.5, "Counter": 1},
+		{"Mode": 1, "Sub": 3, "Temperature": 26.85, "Counter": 2},
+		{"Mode": 1, "Sub": 4, "Pressure": 0xabcd, "Counter": 3},
+		{"Mode": 1, "Sub": 5, "Counter": 4},
+		{"Mode": 2, "Current": -100.5, "Counter": 5},
+		{"Mode": 5, "Current": 100.5, "Counter": 6},
+		{"Mode": 3, "Counter": 7},
+	} {
+		frame, err := msg.Encode(tc)
+		if err != nil {
+			t.Fatalf("could not encode %v: %+v", tc, err)
+		}
+		if frame.ID != 0x160 || frame.Kind != canbus.EFF || len(frame.Data) != 8 {
+			t.Fatalf("invalid frame: %+v", frame)
+		}
+
+		got := map[string]float64{"Voltage": 42, "Sub": 42}
+		err = msg.Decode(frame, got)
+		if err != nil {
+			t.Fatalf("could not decode %v: %+v", tc, err)
+		}
+		if len(got) != len(tc) {
+			t.Fatalf("invalid decoded signals:\ngot= %v\nwant=%v", got, tc)
+		}
+		for k, want := range tc {
+			if math.Abs(got[k]-want) > 1e-9 {
+				t.Fatalf("invalid value for %q: got=%v, want=%v", k, got[k], want)
+			}
+		}
+	}
+
+	_, err = msg.Encode(map[string]float64{"Mode": 0, "Counter": 1})
+	if err == nil || err.Error() != `candb: missing value for signal "Voltage" of message "Diagnostics"` {
+		t.Fatalf("invalid error: %v", err)
+	}
+
+	_, err = msg.Encode(map[string]float64{"Mode": 1, "Sub": 3, "Temperature": 1001, "Counter": 2})
+	if !errors.Is(err, candb.ErrRange) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrRange)
+	}
+}
+
+func TestMessageMuxCycle(t *testing.T) {
+	var (
+		a = &candb.Signal{Name: "A", Length: 8, Factor: 1, MuxRanges: []candb.MuxRange{{0, 0}}}
+		b = &candb.Signal{Name: "B", Start: 8, Length: 8, Factor: 1, MuxRanges: []candb.MuxRange{{0, 0}}}
+	)
+	a.Mux, b.Mux = b, a
+	msg := &candb.Message{Name: "M", Size: 8, Signals: []*candb.Signal{a, b}}
+
+	_, err := msg.Encode(map[string]float64{"A": 0, "B": 0})
+	if got, want := fmt.Sprint(err), `candb: message "M": signal "A": multiplexer cycle`; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	vals := map[string]float64{"A": 1, "B": 1}
+	err = msg.Decode(canbus.Frame{Data: make([]byte, 8)}, vals)
+	if err != nil {
+		t.Fatalf("could not decode frame: %+v", err)
+	}
+	if len(vals) != 0 {
+		t.Fatalf("invalid decoded values: %v", vals)
+	}
+}
+
+func TestMessageDecodeErrors(t *testing.T) {
+	msg := newMuxMessage()
+	vals := make(map[string]float64)
+
+	err := msg.Decode(canbus.Frame{Data: []byte{0, 0xff, 0xff}}, vals)
+	if !errors.Is(err, candb.ErrShortFrame) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrShortFrame)
+	}
+	if got, want := vals, map[string]float64{"Mode": 0, "Voltage": 65.535}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid decoded values:\ngot= %v\nwant=%v", got, want)
+	}
+
+	// Temperature: raw=0xffff -> 6280.35 > Max
+	err = msg.Decode(canbus.Frame{Data: []byte{1, 3, 0xff, 0xff, 0, 0, 0, 0}}, vals)
+	if !errors.Is(err, candb.ErrRange) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrRange)
+	}
+	if got, want := vals["Temperature"], 0xffff*0.1-273.15; got != want {
+		t.Fatalf("invalid out-of-range value: got=%v, want=%v", got, want)
+	}
+
+	db := &candb.Database{Messages: []*candb.Message{msg}}
+	_, err = db.Decode(canbus.Frame{ID: 0x160, Kind: canbus.SFF}, vals)
+	if !errors.Is(err, candb.ErrUnknownMessage) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrUnknownMessage)
+	}
+	got, err := db.Decode(canbus.Frame{ID: 0x160, Kind: canbus.EFF, Data: make([]byte, 8)}, vals)
+	if err != nil || got != msg {
+		t.Fatalf("could not decode frame: %v", err)
+	}
+}
+
+func TestMessageValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  *candb.Message
+		err  string
+	}{
+		{
+			name: "overlap",
+			msg: &candb.Message{Name: "M", Size: 8, Signals: []*candb.Signal{
+				{Name: "A", Start: 0, Length: 12, Factor: 1},
+				{Name: "B", Start: 7, Length: 8, ByteOrder: candb.BigEndian, Factor: 1},
+			}},
+			err: `candb: message "M": signals "A" and "B" overlap`,
+		},
+		{
+			name: "overlap-motorola",
+			msg: &candb.Message{Name: "M", Size: 8, Signals: []*candb.Signal{
+				{Name: "A", Start: 7, Length: 12, ByteOrder: candb.BigEndian, Factor: 1},
+				{Name: "B", Start: 12, Length: 1, Factor: 1},
+			}},
+			err: `candb: message "M": signals "A" and "B" overlap`,
+		},
+		{
+			name: "past-end",
+			msg: &candb.Message{Name: "M", Size: 2, Signals: []*candb.Signal{
+				{Name: "A", Start: 10, Length: 8, Factor: 1},
+			}},
+			err: `candb: message "M": signal "A": bits past the end of the 2-byte message`,
+		},
+		{
+			name: "past-end-motorola",
+			msg: &candb.Message{Name: "M", Size: 8, Signals: []*candb.Signal{
+				{Name: "A", Start: 3, Length: 61, ByteOrder: candb.BigEndian, Factor: 1},
+			}},
+			err: `candb: message "M": signal "A": bits past the end of the 8-byte message`,
+		},
+		{
+			name: "float-length",
+			msg: &candb.Message{Name: "M", Size: 8, Signals: []*candb.Signal{
+				{Name: "A", Length: 16, Type: candb.Float, Factor: 1},
+			}},
+			err: `candb: message "M": signal "A": invalid float length 16`,
+		},
+		{
+			name: "zero-factor",
+			msg: &candb.Message{Name: "M", Size: 8, Signals: []*candb.Signal{
+				{Name: "A", Length: 16},
+			}},
+			err: `candb: message "M": signal "A": invalid zero factor`,
+		},
+		{
+			name: "duplicate",
+			msg: &candb.Message{Name: "M", Size: 8, Signals: []*candb.Signal{
+				{Name: "A", Length: 8, Factor: 1},
+				{Name: "A", Start: 8, Length: 8, Factor: 1},
+			}},
+			err: `candb: message "M": duplicate signal "A"`,
+		},
+		{
+			name: "invalid-id",
+			msg:  &candb.Message{Name: "M", ID: 0x800, Size: 8},
+			err:  `candb: message "M": invalid identifier 0x800`,
+		},
+		{
+			name: "mux-not-in-message",
+			msg: &candb.Message{Name: "M", Size: 8, Signals: []*candb.Signal{
+				{
+					Name: "A", Length: 8, Factor: 1,
+					Mux:       &candb.Signal{Name: "S", Length: 8, Factor: 1},
+					MuxRanges: []candb.MuxRange{{0, 0}},
+				},
+			}},
+			err: `candb: message "M": signal "A": multiplexer "S" not in message`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.msg.Validate()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1234))
+	for i := 0; i < 2000; i++ {
+		sig := candb.Signal{
+			Length:    1 + rnd.Intn(64),
+			ByteOrder: candb.ByteOrder(rnd.Intn(2)),
+			Type:      candb.Type(rnd.Intn(2)),
+			Factor:    1,
+		}
+		switch sig.ByteOrder {
+		case candb.LittleEndian:
+			sig.Start = rnd.Intn(64*8 - sig.Length + 1)
+		default:
+			// pick the position of the LSB in linear numbering.
+			lsb := sig.Length - 1 + rnd.Intn(64*8-sig.Length+1)
+			msb := lsb - sig.Length + 1
+			sig.Start = msb/8*8 + 7 - msb%8
+		}
+		raw := rnd.Uint64()
+		if sig.Length < 64 {
+			raw &= 1<<uint(sig.Length) - 1
+		}
+
+		data := make([]byte, 64)
+		rnd.Read(data)
+		orig := append([]byte(nil), data...)
+
+		var v float64
+		switch sig.Type {
+		case candb.Signed:
+			n := uint(64 - sig.Length)
+			v = float64(int64(raw<<n) >> n)
+		default:
+			v = float64(raw)
+		}
+		if sig.Length > 52 {
+			// keep exactly representable values.
+			v = math.Trunc(v / 4096)
+		}
+
+		err := sig.Encode(data, v)
+		if err != nil {
+			t.Fatalf("could not encode %+v (v=%v): %+v", sig, v, err)
+		}
+		got, err := sig.Decode(data)
+		if err != nil {
+			t.Fatalf("could not decode %+v: %+v", sig, err)
+		}
+		if got != v {
+			t.Fatalf("invalid round-trip for %+v: got=%v, want=%v", sig, got, v)
+		}
+
+		// bits outside of the signal are left untouched.
+		msg := candb.Message{Name: "M", Size: 64, Signals: []*candb.Signal{&sig}}
+		if err := msg.Validate(); err != nil {
+			t.Fatalf("invalid signal: %+v", err)
+		}
+		_ = sig.Encode(orig, 0)
+		_ = sig.Encode(data, 0)
+		if !bytes.Equal(data, orig) {
+			t.Fatalf("bits outside of signal %+v modified", sig)
+		}
+	}
+}
+
+func TestDecodeAllocs(t *testing.T) {
+	msg := newMuxMessage()
+	frame := canbus.Frame{ID: 0x160, Kind: canbus.EFF, Data: []byte{1, 3, 0x10, 0x0b, 0, 0, 0, 42}}
+	vals := make(map[string]float64)
+	err := msg.Decode(frame, vals)
+	if err != nil {
+		t.Fatalf("could not decode frame: %+v", err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = msg.Decode(frame, vals)
+	})
+	if allocs != 0 {
+		t.Fatalf("decoding allocates: %v allocs/op", allocs)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	msg := newMuxMessage()
+	frame := canbus.Frame{ID: 0x160, Kind: canbus.EFF, Data: []byte{1, 3, 0x10, 0x0b, 0, 0, 0, 42}}
+	vals := make(map[string]float64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = msg.Decode(frame, vals)
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cangw forwards CAN frames between CAN interfaces, according to
+// per-direction rules.
+//
+// Rules select the frames received on a source interface with
+// identifier filters, and drop them, or forward them to a destination
+// interface after remapping their identifier, modifying their fields
+// and recomputing their checksums, within a rate limit.
+//
+// Rules can also be installed in the CAN gateway of the Linux kernel
+// through Kernel, trading some of that flexibility for a much lower
+// forwarding latency.
+//
+// A typical usage might look like:
+//
+//	gw, err := cangw.Open(
+//	    cangw.Rule{Src: "can0", Dst: "can1"},
+//	    cangw.Rule{Src: "can1", Dst: "can0", Filters: []cangw.Filter{{ID: 0x7e8, Mask: 0x7f8}}},
+//	)
+//	defer gw.Close()
+//	err = gw.Run()
+package cangw
+
+import (
+	"fmt"
+
+	"github.com/go-daq/canbus"
+)
+
+// Rule describes how frames received on an interface are forwarded to
+// another interface.
+//
+// Frames are handled, for each destination, by the first rule between
+// their source and that destination whose filters select them. Frames
+// selected by no rule are not forwarded.
+type Rule struct {
+	Src string // name of the source interface
+	Dst string // name of the destination interface
+
+	// Filters select the frames handled by the rule. Frames are
+	// selected when they match any of the filters, or when there are
+	// no filters.
+	Filters []Filter
+
+	// Drop drops the selected frames, instead of forwarding them.
+	Drop bool
+
+	// Map remaps the identifiers of the forwarded frames.
+	// Standard data frames remapped to identifiers that do not fit in
+	// 11 bits are forwarded as extended frames. Remote frames can only be
+	// standard frames, and are not forwarded when remapped to such
+	// identifiers.
+	Map map[uint32]uint32
+
+	// Mods modify the forwarded frames, in order, once their identifier
+	// has been remapped.
+	Mods []Mod
+
+	// Checksums are computed, in order, once the forwarded frames have
+	// been modified.
+	Checksums []Checksum
+
+	// Rate limits the number of frames forwarded per second, with
+	// bursts of up to Burst frames. A zero Rate disables the limit.
+	Rate  float64
+	Burst int
+}
+
+// Filter selects the frames whose identifier matches ID on the bits set
+// in Mask, or the frames whose identifier does not match when Invert is
+// set.
+type Filter struct {
+	ID     uint32
+	Mask   uint32
+	Invert bool
+}
+
+// Match reports whether the filter selects the frame.
+func (f Filter) Match(frame canbus.Frame) bool {
+	return (frame.ID&f.Mask == f.ID&f.Mask) != f.Invert
+}
+
+// Op is an operation modifying the fields of a frame.
+type Op uint8
+
+const (
+	And Op = iota // bitwise AND
+	Or            // bitwise OR
+	Xor           // bitwise XOR
+	Set           // assignment
+)
+
+func (op Op) String() string {
+	switch op {
+	case And:
+		return "and"
+	case Or:
+		return "or"
+	case Xor:
+		return "xor"
+	case Set:
+		return "set"
+	}
+	return fmt.Sprintf("Op(%d)", uint8(op))
+}
+
+func (op Op) apply(v, arg uint32) uint32 {
+	switch op {
+	case And:
+		return v & arg
+	case Or:
+		return v | arg
+	case Xor:
+		return v ^ arg
+	default:
+		return arg
+	}
+}
+
+// Field is a set of fields of a frame.
+type Field uint8
+
+const (
+	ModID    Field = 1 << iota // frame identifier
+	ModLen                     // payload length
+	ModData                    // payload bytes
+	ModFlags                   // CAN FD flags, for kernel FD rules only
+)
+
+// Mod modifies fields of a frame, combining them with the values of the
+// modification.
+//
+// Identifiers of standard frames are truncated to 11 bits, and the other
+// ones to 29 bits. Payload lengths are truncated to 8 bytes, and the
+// payload bytes added by a length modification are zero.
+type Mod struct {
+	Op     Op
+	Fields Field
+	ID     uint32
+	Len    uint8
+	Data   [8]byte
+}
+
+func (mod Mod) apply(frame *canbus.Frame) {
+	if mod.Fields&ModID != 0 {
+		mask := uint32(0x1fffffff)
+		if frame.Kind == canbus.SFF {
+			mask = 0x7ff
+		}
+		frame.ID = mod.Op.apply(frame.ID, mod.ID) & mask
+	}
+	var data [8]byte
+	copy(data[:], frame.Data)
+	n := len(frame.Data)
+	if mod.Fields&ModLen != 0 {
+		if v := mod.Op.apply(uint32(n), uint32(mod.Len)); v < uint32(len(data)) {
+			n = int(v)
+		} else {
+			n = len(data)
+		}
+	}
+	if mod.Fields&ModData != 0 {
+		for i := range data {
+			data[i] = uint8(mod.Op.apply(uint32(data[i]), uint32(mod.Data[i])))
+		}
+	}
+	frame.Data = append(frame.Data[:0:0], data[:n]...)
+}
+
+// Algo is a checksum algorithm.
+type Algo uint8
+
+const (
+	XOR8 Algo = iota // XOR of the bytes
+	CRC8             // 8-bit CRC
+)
+
+func (algo Algo) String() string {
+	switch algo {
+	case XOR8:
+		return "xor8"
+	case CRC8:
+		return "crc8"
+	}
+	return fmt.Sprintf("Algo(%d)", uint8(algo))
+}
+
+// Checksum computes a checksum over a range of payload bytes, and
+// stores it in a payload byte.
+//
+// Negative byte indices are relative to the payload length: -1 is the
+// last byte of the payload. Checksums involving bytes beyond the
+// payload of a frame are not computed.
+type Checksum struct {
+	Algo  Algo
+	From  int   // index of the first byte of the range
+	To    int   // index of the last byte of the range
+	At    int   // index of the checksum byte
+	Init  uint8 // initial value of the checksum
+	Poly  uint8 // polynomial of the CRC, in normal representation
+	Final uint8 // value XORed with the checksum
+}
+
+func (sum Checksum) apply(data []byte) {
+	idx := func(i int) int {
+		if i < 0 {
+			i += len(data)
+		}
+		return i
+	}
+	var (
+		beg = idx(sum.From)
+		end = idx(sum.To)
+		at  = idx(sum.At)
+	)
+	if beg < 0 || end >= len(data) || at < 0 || at >= len(data) {
+		return
+	}
+
+	v := sum.Init
+	for i := beg; i <= end; i++ {
+		switch sum.Algo {
+		case XOR8:
+			v ^= data[i]
+		case CRC8:
+			v = crc8(sum.Poly, v^data[i])
+		}
+	}
+	data[at] = v ^ sum.Final
+}
+
+func (r Rule) validate() error {
+	switch {
+	case r.Src == "" || r.Dst == "":
+		return fmt.Errorf("missing interface")
+	case r.Src == r.Dst:
+		return fmt.Errorf("same source and destination interface %q", r.Src)
+	case r.Rate < 0:
+		return fmt.Errorf("invalid rate %v", r.Rate)
+	}
+	for src, dst := range r.Map {
+		if dst > 0x1fffffff {
+			return fmt.Errorf("invalid identifier mapping 0x%x to 0x%x", src, dst)
+		}
+	}
+	for _, mod := range r.Mods {
+		if mod.Op > Set || mod.Fields == 0 || mod.Fields > ModID|ModLen|ModData {
+			return fmt.Errorf("invalid modification %+v", mod)
+		}
+	}
+	for _, sum := range r.Checksums {
+		for _, i := range []int{sum.From, sum.To, sum.At} {
+			if sum.Algo > CRC8 || i < -8 || i > 7 {
+				return fmt.Errorf("invalid checksum %+v", sum)
+			}
+		}
+	}
+	return nil
+}
+
+// selects reports whether the rule handles the frame.
+func (r Rule) selects(frame canbus.Frame) bool {
+	if len(r.Filters) == 0 {
+		return true
+	}
+	for _, f := range r.Filters {
+		if f.Match(frame) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite returns the forwarded version of the frame.
+func (r Rule) rewrite(frame canbus.Frame) (canbus.Frame, error) {
+	frame.Data = append([]byte(nil), frame.Data...)
+	if id, ok := r.Map[frame.ID]; ok {
+		if id > 0x7ff {
+			switch frame.Kind {
+			case canbus.SFF:
+				frame.Kind = canbus.EFF
+			case canbus.RTR:
+				return frame, fmt.Errorf("could not remap remote frame 0x%x to extended identifier 0x%x", frame.ID, id)
+			}
+		}
+		frame.ID = id
+	}
+	for _, mod := range r.Mods {
+		mod.apply(&frame)
+	}
+	for _, sum := range r.Checksums {
+		sum.apply(frame.Data)
+	}
+	return frame, nil
+}
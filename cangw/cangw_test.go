@@ -0,0 +1,462 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cangw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/vcan"
+	"golang.org/x/sys/unix"
+)
+
+// fakeBus is an interface receiving frames from a channel, and
+// recording the frames sent.
+type fakeBus struct {
+	in chan canbus.Frame
+
+	mu     sync.Mutex
+	sent   []string
+	fail   bool
+	closed bool
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{in: make(chan canbus.Frame, 16)}
+}
+
+func (b *fakeBus) Send(frame canbus.Frame) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail {
+		return 0, fmt.Errorf("no buffer space available")
+	}
+	b.sent = append(b.sent, fmt.Sprintf("%03x#% x", frame.ID, frame.Data))
+	return len(frame.Data), nil
+}
+
+func (b *fakeBus) Recv() (canbus.Frame, error) {
+	select {
+	case frame, ok := <-b.in:
+		if !ok {
+			return frame, io.EOF
+		}
+		return frame, nil
+	case <-time.After(10 * time.Millisecond):
+		return canbus.Frame{}, fmt.Errorf("recv timeout: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+func (b *fakeBus) Name() string                       { return "fake" }
+func (b *fakeBus) SetFilters([]unix.CanFilter) error  { return nil }
+func (b *fakeBus) SetRecvTimeout(time.Duration) error { return nil }
+
+func (b *fakeBus) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *fakeBus) frames() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sent
+}
+
+func TestRewrite(t *testing.T) {
+	sff := func(id uint32, data ...byte) canbus.Frame {
+		return canbus.Frame{ID: id, Kind: canbus.SFF, Data: data}
+	}
+	for _, tc := range []struct {
+		name  string
+		rule  Rule
+		frame canbus.Frame
+		want  canbus.Frame
+	}{
+		{
+			name:  "none",
+			frame: sff(0x123, 1, 2, 3),
+			want:  sff(0x123, 1, 2, 3),
+		},
+		{
+			name:  "map",
+			rule:  Rule{Map: map[uint32]uint32{0x123: 0x321, 0x321: 0x100}},
+			frame: sff(0x123, 1, 2, 3),
+			want:  sff(0x321, 1, 2, 3),
+		},
+		{
+			name:  "map-eff",
+			rule:  Rule{Map: map[uint32]uint32{0x123: 0x18fef100}},
+			frame: sff(0x123, 1, 2, 3),
+			want:  canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF, Data: []byte{1, 2, 3}},
+		},
+		{
+			name: "map-mods",
+			rule: Rule{
+				Map: map[uint32]uint32{0x123: 0x321},
+				Mods: []Mod{
+					{Op: And, Fields: ModID | ModData, ID: 0x0ff, Data: [8]byte{0xff, 0x0f, 0xff, 0xff}},
+					{Op: Or, Fields: ModID, ID: 0x400},
+					{Op: Xor, Fields: ModData, Data: [8]byte{0, 0, 0xff}},
+				},
+			},
+			frame: sff(0x123, 0x11, 0x22, 0x33),
+			want:  sff(0x421, 0x11, 0x02, 0xcc),
+		},
+		{
+			name:  "set",
+			rule:  Rule{Mods: []Mod{{Op: Set, Fields: ModID | ModLen | ModData, ID: 0x18fef100, Len: 5, Data: [8]byte{1, 2, 3, 4, 5, 6}}}},
+			frame: canbus.Frame{ID: 0x18fef101, Kind: canbus.EFF, Data: []byte{0xff}},
+			want:  canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF, Data: []byte{1, 2, 3, 4, 5}},
+		},
+		{
+			name:  "sff-id",
+			rule:  Rule{Mods: []Mod{{Op: Set, Fields: ModID, ID: 0x18fef100}}},
+			frame: sff(0x123),
+			want:  sff(0x100),
+		},
+		{
+			name:  "len",
+			rule:  Rule{Mods: []Mod{{Op: Or, Fields: ModLen, Len: 0x4}, {Op: Xor, Fields: ModData, Data: [8]byte{0, 0, 0, 0, 0, 0, 0, 0xff}}}},
+			frame: sff(0x123, 1, 2),
+			want:  sff(0x123, 1, 2, 0, 0, 0, 0),
+		},
+		{
+			name:  "len-max",
+			rule:  Rule{Mods: []Mod{{Op: Set, Fields: ModLen, Len: 15}}},
+			frame: sff(0x123, 1, 2),
+			want:  sff(0x123, 1, 2, 0, 0, 0, 0, 0, 0),
+		},
+		{
+			name: "xor8",
+			rule: Rule{Checksums: []Checksum{
+				{Algo: XOR8, From: 0, To: -2, At: -1},
+				{Algo: XOR8, From: 0, To: 1, At: 2, Init: 0xf0, Final: 0xff},
+			}},
+			frame: sff(0x123, 0x01, 0x02, 0x04, 0x08),
+			want:  sff(0x123, 0x01, 0x02, 0x0c, 0x07),
+		},
+		{
+			name:  "xor8-mod",
+			rule:  Rule{Mods: []Mod{{Op: Set, Fields: ModData, Data: [8]byte{1, 2, 3}}}, Checksums: []Checksum{{Algo: XOR8, From: 0, To: 2, At: 3}}},
+			frame: sff(0x123, 0, 0, 0, 0),
+			want:  sff(0x123, 1, 2, 3, 0),
+		},
+		{
+			name:  "out-of-range",
+			rule:  Rule{Checksums: []Checksum{{Algo: XOR8, From: 0, To: 6, At: 7}, {Algo: XOR8, From: -8, To: 0, At: 1}}},
+			frame: sff(0x123, 1, 2, 3),
+			want:  sff(0x123, 1, 2, 3),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			orig := append([]byte(nil), tc.frame.Data...)
+			got, err := tc.rule.rewrite(tc.frame)
+			if err != nil {
+				t.Fatalf("could not rewrite frame: %+v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid frame:\ngot= %+v\nwant=%+v", got, tc.want)
+			}
+			if !reflect.DeepEqual(tc.frame.Data, orig) {
+				t.Fatalf("received frame modified: got=% x, want=% x", tc.frame.Data, orig)
+			}
+		})
+	}
+	rule := Rule{Map: map[uint32]uint32{0x123: 0x18fef100}}
+	_, err := rule.rewrite(canbus.Frame{ID: 0x123, Kind: canbus.RTR})
+	if got, want := fmt.Sprint(err), "could not remap remote frame 0x123 to extended identifier 0x18fef100"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestCRC8(t *testing.T) {
+	// examples of the AUTOSAR specification of the CRC routines.
+	for _, tc := range []struct {
+		data  []byte
+		j1850 byte
+		h2f   byte
+	}{
+		{[]byte{0x00, 0x00, 0x00, 0x00}, 0x59, 0x12},
+		{[]byte{0xf2, 0x01, 0x83}, 0x37, 0xc2},
+		{[]byte{0x0f, 0xaa, 0x00, 0x55}, 0x79, 0xc6},
+		{[]byte{0x00, 0xff, 0x55, 0x11}, 0xb8, 0x77},
+		{[]byte{0x92, 0x6b, 0x55}, 0x8c, 0x33},
+		{[]byte{0xff, 0xff, 0xff, 0xff}, 0x74, 0x6c},
+	} {
+		for _, v := range []struct {
+			poly byte
+			want byte
+		}{
+			{0x1d, tc.j1850},
+			{0x2f, tc.h2f},
+		} {
+			data := append(append([]byte(nil), tc.data...), 0)
+			sum := Checksum{Algo: CRC8, From: 0, To: -2, At: -1, Init: 0xff, Poly: v.poly, Final: 0xff}
+			sum.apply(data)
+			if got := data[len(data)-1]; got != v.want {
+				t.Fatalf("invalid CRC8 (poly=0x%02x) of % x: got=0x%02x, want=0x%02x", v.poly, tc.data, got, v.want)
+			}
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	var (
+		can0 = newFakeBus()
+		can1 = newFakeBus()
+		can2 = newFakeBus()
+		now  = time.Unix(1660000000, 0)
+	)
+	gw, err := New(map[string]canbus.Transport{"can0": can0, "can1": can1, "can2": can2}, []Rule{
+		{Src: "can0", Dst: "can1", Drop: true, Filters: []Filter{{ID: 0x666, Mask: 0x7ff}}},
+		{Src: "can0", Dst: "can1", Rate: 10, Burst: 2},
+		{Src: "can0", Dst: "can2", Filters: []Filter{{ID: 0x100, Mask: 0x700}, {ID: 0x666, Mask: 0x7ff}}, Map: map[uint32]uint32{0x100: 0x200}},
+		{Src: "can1", Dst: "can0", Filters: []Filter{{ID: 0x7e8, Mask: 0x7f8, Invert: true}}},
+		{Src: "can1", Dst: "can2", Drop: true},
+		{Src: "can1", Dst: "can2"},
+	}...)
+	if err != nil {
+		t.Fatalf("could not create gateway: %+v", err)
+	}
+	gw.now = func() time.Time { return now }
+
+	for _, tc := range []struct {
+		src   string
+		dt    time.Duration
+		frame canbus.Frame
+	}{
+		{"can0", 0, canbus.Frame{ID: 0x100, Data: []byte{1}}},
+		{"can0", 0, canbus.Frame{ID: 0x666, Data: []byte{2}}},
+		{"can0", 10 * time.Millisecond, canbus.Frame{ID: 0x101, Data: []byte{3}}},
+		{"can0", 10 * time.Millisecond, canbus.Frame{ID: 0x102, Data: []byte{4}}}, // rate limited
+		{"can0", 50 * time.Millisecond, canbus.Frame{ID: 0x103, Data: []byte{5}}}, // rate limited
+		{"can0", 50 * time.Millisecond, canbus.Frame{ID: 0x204, Data: []byte{6}}},
+		{"can1", 0, canbus.Frame{ID: 0x7e8, Data: []byte{7}}},
+		{"can1", 0, canbus.Frame{ID: 0x7df, Data: []byte{8}}},
+	} {
+		now = now.Add(tc.dt)
+		gw.forward(tc.frame, gw.routes[tc.src])
+	}
+
+	for _, tc := range []struct {
+		name string
+		bus  *fakeBus
+		want []string
+	}{
+		{"can0", can0, []string{"7df#08"}},
+		{"can1", can1, []string{"100#01", "101#03", "204#06"}},
+		{"can2", can2, []string{"200#01", "666#02", "101#03", "102#04", "103#05"}},
+	} {
+		if got := tc.bus.frames(); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("invalid frames sent on %s:\ngot= %q\nwant=%q", tc.name, got, tc.want)
+		}
+	}
+
+	want := []Stats{
+		{Dropped: 1},
+		{Forwarded: 3, Limited: 2},
+		{Forwarded: 5},
+		{Forwarded: 1},
+		{Dropped: 2},
+		{},
+	}
+	if got := gw.Stats(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid stats:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	can1.fail = true
+	now = now.Add(time.Second)
+	gw.forward(canbus.Frame{ID: 0x100}, gw.routes["can0"])
+	if got, want := gw.Stats()[1], (Stats{Forwarded: 3, Limited: 2, Failed: 1}); got != want {
+		t.Fatalf("invalid stats:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var (
+		can0 = newFakeBus()
+		can1 = newFakeBus()
+	)
+	gw, err := New(map[string]canbus.Transport{"can0": can0, "can1": can1}, []Rule{
+		{Src: "can0", Dst: "can1"},
+		{Src: "can1", Dst: "can0"},
+	}...)
+	if err != nil {
+		t.Fatalf("could not create gateway: %+v", err)
+	}
+
+	can0.in <- canbus.Frame{ID: 0x100, Kind: canbus.SFF, Data: []byte{1}}
+	can0.in <- canbus.Frame{ID: 0x004, Kind: canbus.ERR, Data: make([]byte, 8)}
+	can0.in <- canbus.Frame{ID: 0x101, Kind: canbus.SFF, Data: []byte{2}}
+	close(can0.in)
+
+	err = gw.Run()
+	if got, want := fmt.Sprint(err), `cangw: could not receive frame on "can0": EOF`; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+	err = gw.Close()
+	if err != nil {
+		t.Fatalf("could not close gateway: %+v", err)
+	}
+	if !can0.closed || !can1.closed {
+		t.Fatalf("interfaces not closed")
+	}
+	if got, want := can1.frames(), []string{"100#01", "101#02"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frames:\ngot= %q\nwant=%q", got, want)
+	}
+	if got := can0.frames(); len(got) != 0 {
+		t.Fatalf("invalid frames sent back: %q", got)
+	}
+
+	err = gw.Run()
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("invalid error: %+v", err)
+	}
+}
+
+func TestVCAN(t *testing.T) {
+	var (
+		can0 = vcan.New("can0")
+		can1 = vcan.New("can1")
+		w    = can0.Open()
+		r    = can1.Open()
+	)
+	defer w.Close()
+	defer r.Close()
+
+	gw, err := New(map[string]canbus.Transport{"can0": can0.Open(), "can1": can1.Open()}, Rule{
+		Src: "can0", Dst: "can1", Map: map[uint32]uint32{0x100: 0x200},
+	})
+	if err != nil {
+		t.Fatalf("could not create gateway: %+v", err)
+	}
+	errc := make(chan error)
+	go func() {
+		errc <- gw.Run()
+	}()
+
+	_, err = w.Send(canbus.Frame{ID: 0x100, Data: []byte{1, 2}})
+	if err != nil {
+		t.Fatalf("could not send frame: %+v", err)
+	}
+	err = r.SetRecvTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("could not set recv timeout: %+v", err)
+	}
+	got, err := r.Recv()
+	if err != nil {
+		t.Fatalf("could not receive frame: %+v", err)
+	}
+	if want := (canbus.Frame{ID: 0x200, Data: []byte{1, 2}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frame:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	err = gw.Close()
+	if err != nil {
+		t.Fatalf("could not close gateway: %+v", err)
+	}
+	if err := <-errc; !errors.Is(err, ErrClosed) {
+		t.Fatalf("invalid error: %+v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	gw, err := New(map[string]canbus.Transport{"can0": newFakeBus(), "can1": newFakeBus()}, []Rule{
+		{Src: "can0", Dst: "can1"},
+	}...)
+	if err != nil {
+		t.Fatalf("could not create gateway: %+v", err)
+	}
+
+	errc := make(chan error)
+	go func() {
+		errc <- gw.Run()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	err = gw.Close()
+	if err != nil {
+		t.Fatalf("could not close gateway: %+v", err)
+	}
+	err = <-errc
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("invalid error: %+v", err)
+	}
+}
+
+func TestRuleErrors(t *testing.T) {
+	buses := map[string]canbus.Transport{"can0": newFakeBus(), "can1": newFakeBus()}
+	for _, tc := range []struct {
+		name  string
+		rules []Rule
+		err   string
+	}{
+		{
+			name: "empty",
+			err:  "cangw: no rules",
+		},
+		{
+			name:  "missing",
+			rules: []Rule{{Src: "can0"}},
+			err:   "cangw: invalid rule 0: missing interface",
+		},
+		{
+			name:  "same",
+			rules: []Rule{{Src: "can0", Dst: "can1"}, {Src: "can0", Dst: "can0"}},
+			err:   `cangw: invalid rule 1: same source and destination interface "can0"`,
+		},
+		{
+			name:  "unknown",
+			rules: []Rule{{Src: "can0", Dst: "can2"}},
+			err:   `cangw: invalid rule 0: unknown interface "can2"`,
+		},
+		{
+			name:  "rate",
+			rules: []Rule{{Src: "can0", Dst: "can1", Rate: -1}},
+			err:   "cangw: invalid rule 0: invalid rate -1",
+		},
+		{
+			name:  "map",
+			rules: []Rule{{Src: "can0", Dst: "can1", Map: map[uint32]uint32{0x123: 0x20000000}}},
+			err:   "cangw: invalid rule 0: invalid identifier mapping 0x123 to 0x20000000",
+		},
+		{
+			name:  "mod",
+			rules: []Rule{{Src: "can0", Dst: "can1", Mods: []Mod{{Op: Set}}}},
+			err:   "cangw: invalid rule 0: invalid modification {Op:set Fields:0 ID:0 Len:0 Data:[0 0 0 0 0 0 0 0]}",
+		},
+		{
+			name:  "op",
+			rules: []Rule{{Src: "can0", Dst: "can1", Mods: []Mod{{Op: 4, Fields: ModID}}}},
+			err:   "cangw: invalid rule 0: invalid modification {Op:Op(4) Fields:1 ID:0 Len:0 Data:[0 0 0 0 0 0 0 0]}",
+		},
+		{
+			name:  "checksum",
+			rules: []Rule{{Src: "can0", Dst: "can1", Checksums: []Checksum{{From: 0, To: 8, At: 0}}}},
+			err:   "cangw: invalid rule 0: invalid checksum {Algo:xor8 From:0 To:8 At:0 Init:0 Poly:0 Final:0}",
+		},
+		{
+			name:  "algo",
+			rules: []Rule{{Src: "can0", Dst: "can1", Checksums: []Checksum{{Algo: 2}}}},
+			err:   "cangw: invalid rule 0: invalid checksum {Algo:Algo(2) From:0 To:0 At:0 Init:0 Poly:0 Final:0}",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(buses, tc.rules...)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
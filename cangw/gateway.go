@@ -0,0 +1,291 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cangw
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-daq/canbus"
+)
+
+// pollTimeout is the receive timeout of the transports of the gateway,
+// bounding the time it takes to notice it has been closed.
+const pollTimeout = 100 * time.Millisecond
+
+// ErrClosed is returned by Run once the gateway has been closed.
+var ErrClosed = errors.New("cangw: gateway closed")
+
+// Stats describes the frames handled by a rule.
+type Stats struct {
+	Forwarded uint64 // frames forwarded
+	Dropped   uint64 // frames dropped by a Drop rule
+	Limited   uint64 // frames dropped by the rate limit
+	Failed    uint64 // frames that could not be sent
+}
+
+// route is a rule of a gateway, along with its state.
+type route struct {
+	stats Stats // first, for the alignment of its atomic counters
+
+	Rule
+	dst canbus.Transport
+
+	// token bucket of the rate limit.
+	tokens float64
+	last   time.Time
+}
+
+// allow reports whether a frame received at time now is within the rate
+// limit of the rule.
+func (r *route) allow(now time.Time) bool {
+	if r.Rate == 0 {
+		return true
+	}
+	burst := float64(r.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if r.last.IsZero() {
+		r.tokens = burst
+	} else {
+		r.tokens += now.Sub(r.last).Seconds() * r.Rate
+		if r.tokens > burst {
+			r.tokens = burst
+		}
+	}
+	r.last = now
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// Gateway forwards frames between CAN interfaces.
+//
+// Each interface is accessed through a single transport, used both to
+// receive the frames to forward and to send the forwarded frames. As
+// the transports do not receive their own frames, forwarded frames are
+// never forwarded back.
+type Gateway struct {
+	buses  map[string]canbus.Transport
+	routes map[string][][]*route // routes by source, grouped by destination
+	rules  []*route
+	now    func() time.Time
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed uint32
+}
+
+// Open opens CAN sockets on the interfaces of the rules, and returns a
+// gateway forwarding frames between them.
+func Open(rules ...Rule) (*Gateway, error) {
+	buses := make(map[string]canbus.Transport)
+	for _, r := range rules {
+		for _, iface := range []string{r.Src, r.Dst} {
+			if _, ok := buses[iface]; ok || iface == "" {
+				continue
+			}
+			sck, err := open(iface)
+			if err != nil {
+				for _, b := range buses {
+					b.Close()
+				}
+				return nil, fmt.Errorf("cangw: could not open %q: %w", iface, err)
+			}
+			buses[iface] = sck
+		}
+	}
+	gw, err := New(buses, rules...)
+	if err != nil {
+		for _, b := range buses {
+			b.Close()
+		}
+		return nil, err
+	}
+	return gw, nil
+}
+
+func open(iface string) (*canbus.Socket, error) {
+	sck, err := canbus.New()
+	if err != nil {
+		return nil, err
+	}
+	err = sck.Bind(iface)
+	if err != nil {
+		sck.Close()
+		return nil, err
+	}
+	// the frames sent by the gateway are looped back to the other
+	// sockets of the interface, but must not be received, and
+	// forwarded, again by the gateway.
+	err = sck.SetRecvOwnMsgs(false)
+	if err != nil {
+		sck.Close()
+		return nil, err
+	}
+	return sck, nil
+}
+
+// New returns a gateway forwarding frames between the provided
+// transports, keyed by the interface names of the rules.
+//
+// The transports must not receive the frames they send, as CAN sockets
+// and vcan endpoints by default. Their receive timeout is set by New, so
+// that Run notices when the gateway is closed. The gateway closes the
+// transports when it is closed.
+func New(buses map[string]canbus.Transport, rules ...Rule) (*Gateway, error) {
+	gw := &Gateway{
+		buses:  buses,
+		routes: make(map[string][][]*route),
+		now:    time.Now,
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("cangw: no rules")
+	}
+	for i, r := range rules {
+		err := r.validate()
+		if err != nil {
+			return nil, fmt.Errorf("cangw: invalid rule %d: %w", i, err)
+		}
+		for _, iface := range []string{r.Src, r.Dst} {
+			if buses[iface] == nil {
+				return nil, fmt.Errorf("cangw: invalid rule %d: unknown interface %q", i, iface)
+			}
+		}
+		rt := &route{Rule: r, dst: buses[r.Dst]}
+		gw.rules = append(gw.rules, rt)
+
+		groups := gw.routes[r.Src]
+		j := 0
+		for j < len(groups) && groups[j][0].Dst != r.Dst {
+			j++
+		}
+		if j == len(groups) {
+			groups = append(groups, nil)
+		}
+		groups[j] = append(groups[j], rt)
+		gw.routes[r.Src] = groups
+	}
+	for iface, b := range buses {
+		err := b.SetRecvTimeout(pollTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("cangw: could not set receive timeout of %q: %w", iface, err)
+		}
+	}
+	return gw, nil
+}
+
+// Run forwards frames until the gateway is closed, or until receiving
+// frames fails on one of its interfaces.
+// Run returns ErrClosed once the gateway has been closed.
+func (gw *Gateway) Run() error {
+	gw.mu.Lock()
+	if atomic.LoadUint32(&gw.closed) != 0 {
+		gw.mu.Unlock()
+		return ErrClosed
+	}
+	errc := make(chan error, len(gw.routes))
+	for src, groups := range gw.routes {
+		gw.wg.Add(1)
+		go func(src string, groups [][]*route) {
+			defer gw.wg.Done()
+			errc <- gw.serve(src, groups)
+		}(src, groups)
+	}
+	gw.mu.Unlock()
+
+	err := <-errc
+	atomic.StoreUint32(&gw.closed, 1)
+	return err
+}
+
+// serve forwards the frames received on the src interface.
+func (gw *Gateway) serve(src string, groups [][]*route) error {
+	b := gw.buses[src]
+	for {
+		frame, err := b.Recv()
+		if atomic.LoadUint32(&gw.closed) != 0 {
+			return ErrClosed
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cangw: could not receive frame on %q: %w", src, err)
+		}
+		if frame.Kind == canbus.ERR {
+			continue
+		}
+		gw.forward(frame, groups)
+	}
+}
+
+// forward forwards the frame to the destinations of the rules selecting
+// it.
+func (gw *Gateway) forward(frame canbus.Frame, groups [][]*route) {
+	now := gw.now()
+	for _, routes := range groups {
+		for _, r := range routes {
+			if !r.selects(frame) {
+				continue
+			}
+			switch {
+			case r.Drop:
+				atomic.AddUint64(&r.stats.Dropped, 1)
+			case !r.allow(now):
+				atomic.AddUint64(&r.stats.Limited, 1)
+			default:
+				out, err := r.rewrite(frame)
+				if err == nil {
+					_, err = r.dst.Send(out)
+				}
+				if err != nil {
+					atomic.AddUint64(&r.stats.Failed, 1)
+				} else {
+					atomic.AddUint64(&r.stats.Forwarded, 1)
+				}
+			}
+			break
+		}
+	}
+}
+
+// Stats returns the statistics of the rules of the gateway, in order.
+func (gw *Gateway) Stats() []Stats {
+	stats := make([]Stats, len(gw.rules))
+	for i, r := range gw.rules {
+		stats[i] = Stats{
+			Forwarded: atomic.LoadUint64(&r.stats.Forwarded),
+			Dropped:   atomic.LoadUint64(&r.stats.Dropped),
+			Limited:   atomic.LoadUint64(&r.stats.Limited),
+			Failed:    atomic.LoadUint64(&r.stats.Failed),
+		}
+	}
+	return stats
+}
+
+// Close stops the gateway, and closes its transports.
+func (gw *Gateway) Close() error {
+	gw.mu.Lock()
+	atomic.StoreUint32(&gw.closed, 1)
+	gw.mu.Unlock()
+	gw.wg.Wait()
+
+	var err error
+	for iface, b := range gw.buses {
+		if e := b.Close(); e != nil && err == nil {
+			err = fmt.Errorf("cangw: could not close %q: %w", iface, e)
+		}
+		delete(gw.buses, iface)
+	}
+	return err
+}
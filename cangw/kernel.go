@@ -0,0 +1,643 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cangw
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// definitions of linux/can/gw.h.
+const (
+	cgwTypeCANCAN = 1 // CGW_TYPE_CAN_CAN
+
+	// netlink attributes.
+	cgwModAnd   = 1 // CGW_MOD_AND, followed by CGW_MOD_{OR,XOR,SET}
+	cgwCSXor    = 5
+	cgwCSCRC8   = 6
+	cgwHandled  = 7
+	cgwDropped  = 8
+	cgwSrcIf    = 9
+	cgwDstIf    = 10
+	cgwFilter   = 11
+	cgwDeleted  = 12
+	cgwLimHops  = 13
+	cgwModUID   = 14
+	cgwFDModAnd = 15 // CGW_FDMOD_AND, followed by CGW_FDMOD_{OR,XOR,SET}
+	cgwMax      = 19
+
+	cgwFlagsEcho      = 0x01
+	cgwFlagsSrcTstamp = 0x02
+	cgwFlagsIIFTxOK   = 0x04
+	cgwFlagsFD        = 0x08
+
+	frameModSize   = 16 + 1 // struct cgw_frame_mod
+	fdFrameModSize = 72 + 1 // struct cgw_fdframe_mod
+	csumXORSize    = 4      // struct cgw_csum_xor
+	csumCRC8Size   = 282    // struct cgw_csum_crc8
+	filterSize     = 8      // struct can_filter
+)
+
+// attrSizes are the sizes of the payloads of the netlink attributes.
+var attrSizes = [cgwMax]int{
+	cgwModAnd:       frameModSize,
+	cgwModAnd + 1:   frameModSize,
+	cgwModAnd + 2:   frameModSize,
+	cgwModAnd + 3:   frameModSize,
+	cgwCSXor:        csumXORSize,
+	cgwCSCRC8:       csumCRC8Size,
+	cgwHandled:      4,
+	cgwDropped:      4,
+	cgwSrcIf:        4,
+	cgwDstIf:        4,
+	cgwFilter:       filterSize,
+	cgwDeleted:      4,
+	cgwLimHops:      1,
+	cgwModUID:       4,
+	cgwFDModAnd:     fdFrameModSize,
+	cgwFDModAnd + 1: fdFrameModSize,
+	cgwFDModAnd + 2: fdFrameModSize,
+	cgwFDModAnd + 3: fdFrameModSize,
+}
+
+var errInvalidMsg = errors.New("cangw: invalid netlink message")
+
+// KernelRule describes how the CAN gateway of the Linux kernel forwards
+// frames received on an interface to another interface.
+//
+// Unlike a Rule, the identifiers of a kernel rule are raw identifiers,
+// including the unix.CAN_EFF_FLAG, unix.CAN_RTR_FLAG and
+// unix.CAN_ERR_FLAG flags of the frames.
+type KernelRule struct {
+	Src string // name of the source interface
+	Dst string // name of the destination interface
+
+	// Filter selects the frames handled by the rule. The zero Filter
+	// selects all the frames.
+	Filter Filter
+
+	// Mods modify the forwarded frames, with at most one modification
+	// per operation. The kernel applies them in the And, Or, Xor, Set
+	// order, whatever their order in Mods.
+	Mods []KernelMod
+
+	// Checksums are computed once the forwarded frames have been
+	// modified, with at most one checksum per algorithm.
+	// The Final value of XOR8 checksums is folded into their Init value.
+	Checksums []Checksum
+
+	// Profile adds data to the CRC8 checksum of the rule.
+	Profile CRC8Profile
+
+	// Hops limits the number of times a frame may be forwarded by the
+	// kernel gateway. Zero uses the max_hops parameter of the module.
+	Hops uint8
+
+	// UID identifies the rule, so that its modifications may be updated,
+	// and that it may be deleted, without describing it fully.
+	UID uint32
+
+	Echo         bool // echo the forwarded frames to the sockets of the host
+	SrcTimestamp bool // keep the timestamps of the received frames
+	IIFTxOK      bool // allow forwarding frames to the interface they were received on
+	FD           bool // forward CAN FD frames, instead of classical ones
+
+	// Stats are the statistics of the rule, as listed by the kernel.
+	Stats KernelStats
+}
+
+// KernelMod modifies fields of the frames forwarded by a kernel rule,
+// combining them with the values of the modification.
+type KernelMod struct {
+	Op     Op
+	Fields Field
+	ID     uint32
+	Len    uint8
+	Flags  uint8    // CAN FD flags, for FD rules only
+	Data   [64]byte // only the first 8 bytes are used by classical rules
+}
+
+// ProfileKind is a kind of additional data of CRC8 checksums.
+type ProfileKind uint8
+
+const (
+	NoProfile       ProfileKind = iota // no additional data
+	Profile1U8                         // Data[0]
+	Profile16U8                        // Data[data[1]&0xf], e.g. for AUTOSAR E2E profile 1
+	ProfileSFFIDXor                    // XOR of the bytes of the identifier
+)
+
+// CRC8Profile describes the additional data the kernel includes in the
+// CRC8 checksums, once the payload bytes have been processed.
+type CRC8Profile struct {
+	Kind ProfileKind
+	Data [20]byte
+}
+
+// KernelStats describes the frames handled by a kernel rule.
+type KernelStats struct {
+	Handled uint32 // frames forwarded
+	Dropped uint32 // frames that could not be forwarded
+	Deleted uint32 // frames deleted by the hop limit, or invalid once modified
+}
+
+func (r KernelRule) validate() error {
+	if r.Src == "" || r.Dst == "" {
+		return fmt.Errorf("missing interface")
+	}
+	var (
+		size  = 8
+		valid = ModID | ModLen | ModData
+	)
+	if r.FD {
+		size = 64
+		valid |= ModFlags
+	}
+	var ops [Set + 1]bool
+	for _, mod := range r.Mods {
+		var data [64]byte // the payload bytes of classical frames
+		copy(data[:8], mod.Data[:])
+		switch {
+		case mod.Op > Set || mod.Fields == 0 || mod.Fields&^valid != 0:
+			return fmt.Errorf("invalid modification %+v", mod)
+		case !r.FD && (mod.Flags != 0 || mod.Data != data):
+			return fmt.Errorf("invalid modification %+v", mod)
+		case ops[mod.Op]:
+			return fmt.Errorf("duplicate %v modification", mod.Op)
+		}
+		ops[mod.Op] = true
+	}
+	var algos [CRC8 + 1]bool
+	for _, sum := range r.Checksums {
+		if sum.Algo > CRC8 {
+			return fmt.Errorf("invalid checksum %+v", sum)
+		}
+		for _, i := range []int{sum.From, sum.To, sum.At} {
+			if i < -size || i >= size {
+				return fmt.Errorf("invalid checksum %+v", sum)
+			}
+		}
+		if algos[sum.Algo] {
+			return fmt.Errorf("duplicate %v checksum", sum.Algo)
+		}
+		algos[sum.Algo] = true
+	}
+	if r.Profile.Kind > ProfileSFFIDXor {
+		return fmt.Errorf("invalid CRC8 profile %d", r.Profile.Kind)
+	}
+	return nil
+}
+
+// marshal returns the rtcanmsg header and the netlink attributes
+// describing the rule, between the interfaces of the src and dst indices.
+func (r KernelRule) marshal(src, dst int) []byte {
+	b := []byte{unix.AF_CAN, cgwTypeCANCAN, 0, 0}
+	var flags uint16
+	for _, f := range []struct {
+		set  bool
+		flag uint16
+	}{
+		{r.Echo, cgwFlagsEcho},
+		{r.SrcTimestamp, cgwFlagsSrcTstamp},
+		{r.IIFTxOK, cgwFlagsIIFTxOK},
+		{r.FD, cgwFlagsFD},
+	} {
+		if f.set {
+			flags |= f.flag
+		}
+	}
+	binary.LittleEndian.PutUint16(b[2:], flags)
+
+	for _, mod := range r.Mods {
+		if r.FD {
+			v := make([]byte, fdFrameModSize)
+			binary.LittleEndian.PutUint32(v[0:], mod.ID)
+			v[4] = mod.Len
+			v[5] = mod.Flags
+			copy(v[8:], mod.Data[:])
+			v[72] = uint8(mod.Fields)
+			b = appendAttr(b, cgwFDModAnd+uint16(mod.Op), v)
+		} else {
+			v := make([]byte, frameModSize)
+			binary.LittleEndian.PutUint32(v[0:], mod.ID)
+			v[4] = mod.Len
+			copy(v[8:], mod.Data[:8])
+			v[16] = uint8(mod.Fields)
+			b = appendAttr(b, cgwModAnd+uint16(mod.Op), v)
+		}
+	}
+	for _, sum := range r.Checksums {
+		switch sum.Algo {
+		case XOR8:
+			b = appendAttr(b, cgwCSXor, []byte{
+				byte(int8(sum.From)), byte(int8(sum.To)), byte(int8(sum.At)),
+				sum.Init ^ sum.Final,
+			})
+		case CRC8:
+			v := make([]byte, csumCRC8Size)
+			v[0] = byte(int8(sum.From))
+			v[1] = byte(int8(sum.To))
+			v[2] = byte(int8(sum.At))
+			v[3] = sum.Init
+			v[4] = sum.Final
+			for i := 0; i < 256; i++ {
+				v[5+i] = crc8(sum.Poly, uint8(i))
+			}
+			v[261] = uint8(r.Profile.Kind)
+			copy(v[262:], r.Profile.Data[:])
+			b = appendAttr(b, cgwCSCRC8, v)
+		}
+	}
+	if r.UID != 0 {
+		b = appendAttr(b, cgwModUID, u32(r.UID))
+	}
+	if r.Hops != 0 {
+		b = appendAttr(b, cgwLimHops, []byte{r.Hops})
+	}
+	if r.Filter != (Filter{}) {
+		id := r.Filter.ID
+		if r.Filter.Invert {
+			id |= unix.CAN_INV_FILTER
+		}
+		b = appendAttr(b, cgwFilter, append(u32(id), u32(r.Filter.Mask)...))
+	}
+	b = appendAttr(b, cgwSrcIf, u32(uint32(src)))
+	b = appendAttr(b, cgwDstIf, u32(uint32(dst)))
+	return b
+}
+
+// unmarshalKernelRule decodes the rtcanmsg header and the netlink
+// attributes of a rule, and returns it along with the indices of its
+// interfaces.
+func unmarshalKernelRule(b []byte) (r KernelRule, src, dst int, err error) {
+	if len(b) < 4 || b[0] != unix.AF_CAN || b[1] != cgwTypeCANCAN {
+		return r, 0, 0, errInvalidMsg
+	}
+	flags := binary.LittleEndian.Uint16(b[2:])
+	r.Echo = flags&cgwFlagsEcho != 0
+	r.SrcTimestamp = flags&cgwFlagsSrcTstamp != 0
+	r.IIFTxOK = flags&cgwFlagsIIFTxOK != 0
+	r.FD = flags&cgwFlagsFD != 0
+
+	b = b[4:]
+	for len(b) >= unix.SizeofRtAttr {
+		n := int(binary.LittleEndian.Uint16(b[0:]))
+		typ := binary.LittleEndian.Uint16(b[2:])
+		if n < unix.SizeofRtAttr || n > len(b) {
+			return r, 0, 0, errInvalidMsg
+		}
+		v := b[unix.SizeofRtAttr:n]
+		b = b[align(n, len(b)):]
+		if int(typ) >= len(attrSizes) || attrSizes[typ] == 0 {
+			continue
+		}
+		if len(v) < attrSizes[typ] {
+			return r, 0, 0, errInvalidMsg
+		}
+
+		switch {
+		case typ >= cgwModAnd && typ <= cgwModAnd+uint16(Set):
+			mod := KernelMod{
+				Op:     Op(typ - cgwModAnd),
+				Fields: Field(v[16]),
+				ID:     binary.LittleEndian.Uint32(v[0:]),
+				Len:    v[4],
+			}
+			copy(mod.Data[:], v[8:16])
+			r.Mods = append(r.Mods, mod)
+		case typ >= cgwFDModAnd && typ <= cgwFDModAnd+uint16(Set):
+			mod := KernelMod{
+				Op:     Op(typ - cgwFDModAnd),
+				Fields: Field(v[72]),
+				ID:     binary.LittleEndian.Uint32(v[0:]),
+				Len:    v[4],
+				Flags:  v[5],
+			}
+			copy(mod.Data[:], v[8:72])
+			r.Mods = append(r.Mods, mod)
+		case typ == cgwCSXor:
+			r.Checksums = append(r.Checksums, Checksum{
+				Algo: XOR8,
+				From: int(int8(v[0])),
+				To:   int(int8(v[1])),
+				At:   int(int8(v[2])),
+				Init: v[3],
+			})
+		case typ == cgwCSCRC8:
+			r.Checksums = append(r.Checksums, Checksum{
+				Algo:  CRC8,
+				From:  int(int8(v[0])),
+				To:    int(int8(v[1])),
+				At:    int(int8(v[2])),
+				Init:  v[3],
+				Final: v[4],
+				Poly:  v[5+1], // the CRC of 0x01 is the polynomial.
+			})
+			r.Profile.Kind = ProfileKind(v[261])
+			copy(r.Profile.Data[:], v[262:])
+		case typ == cgwHandled:
+			r.Stats.Handled = binary.LittleEndian.Uint32(v)
+		case typ == cgwDropped:
+			r.Stats.Dropped = binary.LittleEndian.Uint32(v)
+		case typ == cgwDeleted:
+			r.Stats.Deleted = binary.LittleEndian.Uint32(v)
+		case typ == cgwSrcIf:
+			src = int(binary.LittleEndian.Uint32(v))
+		case typ == cgwDstIf:
+			dst = int(binary.LittleEndian.Uint32(v))
+		case typ == cgwFilter:
+			id := binary.LittleEndian.Uint32(v[0:])
+			r.Filter = Filter{
+				ID:     id &^ unix.CAN_INV_FILTER,
+				Mask:   binary.LittleEndian.Uint32(v[4:]),
+				Invert: id&unix.CAN_INV_FILTER != 0,
+			}
+		case typ == cgwLimHops:
+			r.Hops = v[0]
+		case typ == cgwModUID:
+			r.UID = binary.LittleEndian.Uint32(v)
+		}
+	}
+	return r, src, dst, nil
+}
+
+// crc8 returns the CRC of the byte v, with a zero initial value.
+func crc8(poly, v uint8) uint8 {
+	for bit := 0; bit < 8; bit++ {
+		if v&0x80 != 0 {
+			v = v<<1 ^ poly
+		} else {
+			v <<= 1
+		}
+	}
+	return v
+}
+
+func appendAttr(b []byte, typ uint16, v []byte) []byte {
+	var hdr [unix.SizeofRtAttr]byte
+	binary.LittleEndian.PutUint16(hdr[0:], uint16(len(hdr)+len(v)))
+	binary.LittleEndian.PutUint16(hdr[2:], typ)
+	b = append(b, hdr[:]...)
+	b = append(b, v...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// align returns n aligned on 4 bytes, capped at max.
+func align(n, max int) int {
+	n = (n + 3) &^ 3
+	if n > max {
+		return max
+	}
+	return n
+}
+
+func u32(v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return b[:]
+}
+
+type netlink interface {
+	send(msg []byte) error
+	recv() ([]byte, error)
+	Close() error
+}
+
+// Kernel manages the rules of the CAN gateway of the Linux kernel,
+// provided by the can-gw module.
+//
+// Kernel rules forward frames without leaving the kernel, with a much
+// lower latency than a Gateway, but only support a single filter per
+// rule, and neither identifier remapping nor rate limits.
+// Modifying the kernel rules requires the CAP_NET_ADMIN capability.
+type Kernel struct {
+	mu    sync.Mutex
+	conn  netlink
+	seq   uint32
+	index func(name string) (int, error)
+	name  func(index int) (string, error)
+}
+
+// OpenKernel opens a netlink socket managing the rules of the CAN gateway
+// of the Linux kernel.
+func OpenKernel() (*Kernel, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("cangw: could not open netlink socket: %w", err)
+	}
+	err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("cangw: could not bind netlink socket: %w", err)
+	}
+	return newKernel(nlSocket{fd}), nil
+}
+
+func newKernel(conn netlink) *Kernel {
+	return &Kernel{
+		conn: conn,
+		index: func(name string) (int, error) {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				return 0, err
+			}
+			return iface.Index, nil
+		},
+		name: func(index int) (string, error) {
+			iface, err := net.InterfaceByIndex(index)
+			if err != nil {
+				return "", err
+			}
+			return iface.Name, nil
+		},
+	}
+}
+
+// Add adds a rule to the kernel gateway.
+// When a rule with the same non-zero UID exists, its modifications are
+// updated instead.
+func (k *Kernel) Add(r KernelRule) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	msg, err := k.marshal(r)
+	if err != nil {
+		return fmt.Errorf("cangw: could not add kernel rule: %w", err)
+	}
+	_, err = k.do(unix.RTM_NEWROUTE, unix.NLM_F_ACK, msg)
+	if err != nil {
+		return fmt.Errorf("cangw: could not add kernel rule: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes a rule of the kernel gateway.
+// Rules with a non-zero UID are identified by their interfaces and UID,
+// and the other ones by their whole description, statistics excepted.
+func (k *Kernel) Delete(r KernelRule) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	msg, err := k.marshal(r)
+	if err != nil {
+		return fmt.Errorf("cangw: could not delete kernel rule: %w", err)
+	}
+	_, err = k.do(unix.RTM_DELROUTE, unix.NLM_F_ACK, msg)
+	if err != nil {
+		return fmt.Errorf("cangw: could not delete kernel rule: %w", err)
+	}
+	return nil
+}
+
+// Flush deletes all the rules of the kernel gateway.
+func (k *Kernel) Flush() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var r KernelRule
+	_, err := k.do(unix.RTM_DELROUTE, unix.NLM_F_ACK, r.marshal(0, 0))
+	if err != nil {
+		return fmt.Errorf("cangw: could not flush kernel rules: %w", err)
+	}
+	return nil
+}
+
+// List returns the rules of the kernel gateway, along with their
+// statistics.
+func (k *Kernel) List() ([]KernelRule, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	msgs, err := k.do(unix.RTM_GETROUTE, unix.NLM_F_DUMP, []byte{unix.AF_CAN, 0, 0, 0})
+	if err != nil {
+		return nil, fmt.Errorf("cangw: could not list kernel rules: %w", err)
+	}
+	rules := make([]KernelRule, 0, len(msgs))
+	for _, msg := range msgs {
+		r, src, dst, err := unmarshalKernelRule(msg)
+		if err != nil {
+			return nil, err
+		}
+		r.Src, err = k.name(src)
+		if err != nil {
+			return nil, fmt.Errorf("cangw: could not find interface %d: %w", src, err)
+		}
+		r.Dst, err = k.name(dst)
+		if err != nil {
+			return nil, fmt.Errorf("cangw: could not find interface %d: %w", dst, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Close closes the netlink socket.
+func (k *Kernel) Close() error {
+	return k.conn.Close()
+}
+
+func (k *Kernel) marshal(r KernelRule) ([]byte, error) {
+	err := r.validate()
+	if err != nil {
+		return nil, err
+	}
+	src, err := k.index(r.Src)
+	if err != nil {
+		return nil, fmt.Errorf("could not find interface %q: %w", r.Src, err)
+	}
+	dst, err := k.index(r.Dst)
+	if err != nil {
+		return nil, fmt.Errorf("could not find interface %q: %w", r.Dst, err)
+	}
+	return r.marshal(src, dst), nil
+}
+
+// do sends a netlink request, and returns the payloads of the replies
+// received until its acknowledgment, or the end of the dump.
+func (k *Kernel) do(typ, flags uint16, body []byte) ([][]byte, error) {
+	k.seq++
+	msg := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(body))
+	binary.LittleEndian.PutUint32(msg[0:], uint32(cap(msg)))
+	binary.LittleEndian.PutUint16(msg[4:], typ)
+	binary.LittleEndian.PutUint16(msg[6:], unix.NLM_F_REQUEST|flags)
+	binary.LittleEndian.PutUint32(msg[8:], k.seq)
+	msg = append(msg, body...)
+
+	err := k.conn.send(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs [][]byte
+	for {
+		b, err := k.conn.recv()
+		if err != nil {
+			return nil, err
+		}
+		for len(b) > 0 {
+			if len(b) < unix.SizeofNlMsghdr {
+				return nil, errInvalidMsg
+			}
+			n := int(binary.LittleEndian.Uint32(b[0:]))
+			if n < unix.SizeofNlMsghdr || n > len(b) {
+				return nil, errInvalidMsg
+			}
+			var (
+				typ  = binary.LittleEndian.Uint16(b[4:])
+				seq  = binary.LittleEndian.Uint32(b[8:])
+				data = b[unix.SizeofNlMsghdr:n]
+			)
+			b = b[align(n, len(b)):]
+			if seq != k.seq {
+				continue
+			}
+			switch typ {
+			case unix.NLMSG_ERROR, unix.NLMSG_DONE:
+				if len(data) < 4 {
+					if typ == unix.NLMSG_DONE {
+						return msgs, nil
+					}
+					return nil, errInvalidMsg
+				}
+				if errno := int32(binary.LittleEndian.Uint32(data)); errno < 0 {
+					return nil, unix.Errno(-errno)
+				}
+				return msgs, nil
+			default:
+				msgs = append(msgs, data)
+			}
+		}
+	}
+}
+
+// nlSocket is a NETLINK_ROUTE socket.
+type nlSocket struct {
+	fd int
+}
+
+func (s nlSocket) send(msg []byte) error {
+	return unix.Sendto(s.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+}
+
+func (s nlSocket) recv() ([]byte, error) {
+	buf := make([]byte, 1<<16)
+	n, _, err := unix.Recvfrom(s.fd, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (s nlSocket) Close() error {
+	return unix.Close(s.fd)
+}
@@ -0,0 +1,358 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cangw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeNetlink is a kernel gateway storing the rules it is sent.
+type fakeNetlink struct {
+	rules   [][]byte // rtcanmsg and attributes of the rules
+	replies [][]byte
+	errno   unix.Errno
+	closed  bool
+}
+
+func (nl *fakeNetlink) send(msg []byte) error {
+	var (
+		typ   = binary.LittleEndian.Uint16(msg[4:])
+		flags = binary.LittleEndian.Uint16(msg[6:])
+		seq   = binary.LittleEndian.Uint32(msg[8:])
+		body  = msg[unix.SizeofNlMsghdr:]
+	)
+	reply := func(typ uint16, data []byte) []byte {
+		b := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(data))
+		binary.LittleEndian.PutUint32(b[0:], uint32(cap(b)))
+		binary.LittleEndian.PutUint16(b[4:], typ)
+		binary.LittleEndian.PutUint32(b[8:], seq)
+		return append(b, data...)
+	}
+	ack := func(errno unix.Errno) {
+		data := append(u32(uint32(-int32(errno))), msg[:unix.SizeofNlMsghdr]...)
+		nl.replies = append(nl.replies, reply(unix.NLMSG_ERROR, data))
+	}
+	if flags&unix.NLM_F_REQUEST == 0 {
+		return fmt.Errorf("not a request")
+	}
+	if nl.errno != 0 {
+		ack(nl.errno)
+		return nil
+	}
+
+	switch typ {
+	case unix.RTM_NEWROUTE:
+		nl.rules = append(nl.rules, body)
+		ack(0)
+	case unix.RTM_DELROUTE:
+		if bytes.HasSuffix(body, append(appendAttr(nil, cgwSrcIf, u32(0)), appendAttr(nil, cgwDstIf, u32(0))...)) {
+			nl.rules = nil
+			ack(0)
+			return nil
+		}
+		for i, r := range nl.rules {
+			if bytes.Equal(r, body) {
+				nl.rules = append(nl.rules[:i], nl.rules[i+1:]...)
+				ack(0)
+				return nil
+			}
+		}
+		ack(unix.ENOENT)
+	case unix.RTM_GETROUTE:
+		// send the dump in two parts, with the statistics of the rules.
+		var b []byte
+		for i, r := range nl.rules {
+			r = appendAttr(r, cgwHandled, u32(uint32(10*i+1)))
+			r = appendAttr(r, cgwDeleted, u32(uint32(10*i+2)))
+			b = append(b, reply(unix.RTM_NEWROUTE, r)...)
+			if i == 0 {
+				nl.replies = append(nl.replies, b)
+				b = nil
+			}
+		}
+		nl.replies = append(nl.replies, append(b, reply(unix.NLMSG_DONE, u32(0))...))
+	default:
+		ack(unix.EOPNOTSUPP)
+	}
+	return nil
+}
+
+func (nl *fakeNetlink) recv() ([]byte, error) {
+	if len(nl.replies) == 0 {
+		return nil, fmt.Errorf("no reply")
+	}
+	b := nl.replies[0]
+	nl.replies = nl.replies[1:]
+	return b, nil
+}
+
+func (nl *fakeNetlink) Close() error {
+	nl.closed = true
+	return nil
+}
+
+func newFakeKernel() (*Kernel, *fakeNetlink) {
+	nl := new(fakeNetlink)
+	k := newKernel(nl)
+	ifaces := []string{"", "lo", "can0", "can1", "vcan0"}
+	k.index = func(name string) (int, error) {
+		for i, iface := range ifaces {
+			if iface == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no such network interface")
+	}
+	k.name = func(index int) (string, error) {
+		if index <= 0 || index >= len(ifaces) {
+			return "", fmt.Errorf("no such network interface")
+		}
+		return ifaces[index], nil
+	}
+	return k, nl
+}
+
+func TestKernelRuleMarshal(t *testing.T) {
+	var data [64]byte
+	for i := range data {
+		data[i] = byte(i)
+	}
+	for _, tc := range []struct {
+		name string
+		rule KernelRule
+	}{
+		{
+			name: "simple",
+			rule: KernelRule{Src: "can0", Dst: "can1"},
+		},
+		{
+			name: "classic",
+			rule: KernelRule{
+				Src:    "can0",
+				Dst:    "vcan0",
+				Filter: Filter{ID: 0x123, Mask: 0x7ff | unix.CAN_EFF_FLAG, Invert: true},
+				Mods: []KernelMod{
+					{Op: And, Fields: ModID | ModData, ID: 0xe00007ff, Data: [64]byte{0xff, 0xff, 0x0f}},
+					{Op: Set, Fields: ModLen, Len: 4},
+				},
+				Checksums: []Checksum{
+					{Algo: CRC8, From: 0, To: -2, At: -1, Init: 0xff, Poly: 0x1d, Final: 0xff},
+					{Algo: XOR8, From: 1, To: 2, At: 3, Init: 0x5a},
+				},
+				Profile:      CRC8Profile{Kind: Profile16U8, Data: [20]byte{1, 2, 3}},
+				Hops:         2,
+				UID:          0xcafe,
+				Echo:         true,
+				SrcTimestamp: true,
+				IIFTxOK:      true,
+			},
+		},
+		{
+			name: "fd",
+			rule: KernelRule{
+				Src: "can1",
+				Dst: "can0",
+				Mods: []KernelMod{
+					{Op: Or, Fields: ModFlags | ModData, Flags: 0x01, Data: data},
+					{Op: Xor, Fields: ModID, ID: 0x100},
+				},
+				Checksums: []Checksum{
+					{Algo: XOR8, From: -64, To: 62, At: 63},
+				},
+				FD: true,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			k, nl := newFakeKernel()
+			err := k.Add(tc.rule)
+			if err != nil {
+				t.Fatalf("could not add rule: %+v", err)
+			}
+			rules, err := k.List()
+			if err != nil {
+				t.Fatalf("could not list rules: %+v", err)
+			}
+			want := tc.rule
+			want.Stats = KernelStats{Handled: 1, Deleted: 2}
+			if got := rules; !reflect.DeepEqual(got, []KernelRule{want}) {
+				t.Fatalf("invalid rules:\ngot= %+v\nwant=%+v", got, []KernelRule{want})
+			}
+			if len(nl.replies) != 0 {
+				t.Fatalf("invalid pending replies: got=%d, want=0", len(nl.replies))
+			}
+		})
+	}
+}
+
+func TestKernelRuleLayout(t *testing.T) {
+	r := KernelRule{
+		Filter: Filter{ID: 0x123, Mask: 0x7ff},
+		Mods:   []KernelMod{{Op: Xor, Fields: ModData, Data: [64]byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+		Hops:   3,
+		Echo:   true,
+	}
+	got := r.marshal(2, 3)
+	want := []byte{
+		unix.AF_CAN, 1, 0x01, 0x00, // rtcanmsg
+		21, 0, 3, 0, // CGW_MOD_XOR
+		0, 0, 0, 0, 0, 0, 0, 0,
+		1, 2, 3, 4, 5, 6, 7, 8,
+		4, 0, 0, 0,
+		5, 0, 13, 0, 3, 0, 0, 0, // CGW_LIM_HOPS
+		12, 0, 11, 0, 0x23, 0x01, 0, 0, 0xff, 0x07, 0, 0, // CGW_FILTER
+		8, 0, 9, 0, 2, 0, 0, 0, // CGW_SRC_IF
+		8, 0, 10, 0, 3, 0, 0, 0, // CGW_DST_IF
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("invalid message:\ngot= % x\nwant=% x", got, want)
+	}
+
+	// the kernel computes the CRC8 checksums with the table of the rule.
+	var tab [256]byte
+	for i := range tab {
+		tab[i] = crc8(0x2f, uint8(i))
+	}
+	sum := Checksum{Algo: CRC8, From: 0, To: 1, At: 2, Init: 0xff, Poly: 0x2f, Final: 0xff}
+	for i := 0; i < 256; i++ {
+		data := []byte{uint8(i), uint8(255 - i), 0}
+		sum.apply(data)
+		want := tab[tab[0xff^uint8(i)]^uint8(255-i)] ^ 0xff
+		if got := data[2]; got != want {
+			t.Fatalf("invalid CRC of %02x: got=0x%02x, want=0x%02x", i, got, want)
+		}
+	}
+}
+
+func TestKernel(t *testing.T) {
+	k, nl := newFakeKernel()
+	rules := []KernelRule{
+		{Src: "can0", Dst: "can1"},
+		{Src: "can1", Dst: "can0", Hops: 1},
+		{Src: "can0", Dst: "vcan0", UID: 42},
+	}
+	for _, r := range rules {
+		err := k.Add(r)
+		if err != nil {
+			t.Fatalf("could not add rule %+v: %+v", r, err)
+		}
+	}
+
+	err := k.Delete(rules[1])
+	if err != nil {
+		t.Fatalf("could not delete rule: %+v", err)
+	}
+	err = k.Delete(rules[1])
+	if !errors.Is(err, unix.ENOENT) {
+		t.Fatalf("invalid error: got=%+v, want=%v", err, unix.ENOENT)
+	}
+
+	got, err := k.List()
+	if err != nil {
+		t.Fatalf("could not list rules: %+v", err)
+	}
+	if len(got) != 2 || got[0].Dst != "can1" || got[1].UID != 42 {
+		t.Fatalf("invalid rules: %+v", got)
+	}
+	if got, want := got[1].Stats, (KernelStats{Handled: 11, Deleted: 12}); got != want {
+		t.Fatalf("invalid stats: got=%+v, want=%+v", got, want)
+	}
+
+	err = k.Flush()
+	if err != nil {
+		t.Fatalf("could not flush rules: %+v", err)
+	}
+	got, err = k.List()
+	if err != nil {
+		t.Fatalf("could not list rules: %+v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("invalid rules: %+v", got)
+	}
+
+	nl.errno = unix.EPERM
+	err = k.Add(rules[0])
+	if !errors.Is(err, unix.EPERM) {
+		t.Fatalf("invalid error: got=%+v, want=%v", err, unix.EPERM)
+	}
+
+	err = k.Close()
+	if err != nil {
+		t.Fatalf("could not close: %+v", err)
+	}
+	if !nl.closed {
+		t.Fatalf("netlink socket not closed")
+	}
+}
+
+func TestKernelRuleErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		rule KernelRule
+		err  error
+	}{
+		{
+			name: "no-interface",
+			rule: KernelRule{Src: "can0"},
+			err:  fmt.Errorf("cangw: could not add kernel rule: missing interface"),
+		},
+		{
+			name: "unknown-interface",
+			rule: KernelRule{Src: "can0", Dst: "can9"},
+			err:  fmt.Errorf(`cangw: could not add kernel rule: could not find interface "can9": no such network interface`),
+		},
+		{
+			name: "invalid-op",
+			rule: KernelRule{Src: "can0", Dst: "can1", Mods: []KernelMod{{Op: 4, Fields: ModID}}},
+			err:  fmt.Errorf("cangw: could not add kernel rule: invalid modification {Op:Op(4) Fields:1 ID:0 Len:0 Flags:0 Data:[0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0]}"),
+		},
+		{
+			name: "classic-flags",
+			rule: KernelRule{Src: "can0", Dst: "can1", Mods: []KernelMod{{Op: Set, Fields: ModFlags}}},
+			err:  fmt.Errorf("cangw: could not add kernel rule: invalid modification {Op:set Fields:8 ID:0 Len:0 Flags:0 Data:[0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0]}"),
+		},
+		{
+			name: "duplicate-mod",
+			rule: KernelRule{Src: "can0", Dst: "can1", Mods: []KernelMod{{Op: Or, Fields: ModID}, {Op: Or, Fields: ModLen}}},
+			err:  fmt.Errorf("cangw: could not add kernel rule: duplicate or modification"),
+		},
+		{
+			name: "checksum-index",
+			rule: KernelRule{Src: "can0", Dst: "can1", Checksums: []Checksum{{Algo: XOR8, To: 8}}},
+			err:  fmt.Errorf("cangw: could not add kernel rule: invalid checksum {Algo:xor8 From:0 To:8 At:0 Init:0 Poly:0 Final:0}"),
+		},
+		{
+			name: "duplicate-checksum",
+			rule: KernelRule{Src: "can0", Dst: "can1", Checksums: []Checksum{{Algo: CRC8}, {Algo: CRC8, At: 1}}},
+			err:  fmt.Errorf("cangw: could not add kernel rule: duplicate crc8 checksum"),
+		},
+		{
+			name: "profile",
+			rule: KernelRule{Src: "can0", Dst: "can1", Profile: CRC8Profile{Kind: 4}},
+			err:  fmt.Errorf("cangw: could not add kernel rule: invalid CRC8 profile 4"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			k, nl := newFakeKernel()
+			err := k.Add(tc.rule)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err.Error(); got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+			if len(nl.rules) != 0 {
+				t.Fatalf("invalid rules: got=%d, want=0", len(nl.rules))
+			}
+		})
+	}
+}
@@ -0,0 +1,564 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package asc reads and writes Vector ASCII (ASC) CAN bus log files, as
+// produced and read by CANalyzer and CANoe:
+//
+//	date Mon Aug 08 11:06:40.000 pm 2022
+//	base hex  timestamps absolute
+//	internal events logged
+//	Begin Triggerblock Mon Aug 08 11:06:40.000 pm 2022
+//	   0.000000 Start of measurement
+//	   0.000000 1  123             Rx   d 4 DE AD BE EF
+//	   0.001234 2  18FEF100x       Tx   d 8 01 02 03 04 05 06 07 08
+//	   0.002345 2  100x            Rx   r 8
+//	   0.003456 CANFD   1 Rx        321                                  1 0 9 12 11 22 33 44 55 66 77 88 99 AA BB CC        0    0     3000        0        0        0        0        0
+//	  12.004567 1  ErrorFrame
+//	End TriggerBlock
+//
+// Records read from ASC files are named after their channel number
+// ("1", "2", ...), and their timestamps are relative to the start of
+// the measurement, in the local time zone.
+// Events other than frames, such as comments and statistics, are
+// skipped.
+package asc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+// Date layouts of ASC files.
+var layouts = []string{
+	"Mon Jan 2 03:04:05.000 pm 2006",
+	"Mon Jan 2 03:04:05 pm 2006",
+	"Mon Jan 2 15:04:05.000 2006",
+	"Mon Jan 2 15:04:05 2006",
+}
+
+const (
+	effMask = 0x1fffffff
+	sffMask = 0x7ff
+
+	// flags of CAN FD events
+	fdRTR = 0x0010 // remote frame
+	fdEDL = 0x1000 // CAN FD frame
+	fdBRS = 0x2000 // bit rate switch
+	fdESI = 0x4000 // error state indicator
+)
+
+// Reader reads records from an ASC log file.
+type Reader struct {
+	sc   *bufio.Scanner
+	line int
+
+	base  int       // base of numbers, 16 or 10
+	rel   bool      // whether timestamps are relative to the previous event
+	start time.Time // start of the measurement
+	last  time.Duration
+}
+
+// NewReader returns a new ASC log reader, reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{sc: bufio.NewScanner(r), base: 16}
+}
+
+func (r *Reader) errorf(format string, args ...any) error {
+	return fmt.Errorf("asc: line %d: %s", r.line, fmt.Sprintf(format, args...))
+}
+
+// Read returns the next frame of the log, or io.EOF at the end of the
+// log.
+func (r *Reader) Read() (canlog.Record, error) {
+	for r.sc.Scan() {
+		r.line++
+		toks := strings.Fields(r.sc.Text())
+		if len(toks) == 0 || strings.HasPrefix(toks[0], "//") {
+			continue
+		}
+		switch strings.ToLower(toks[0]) {
+		case "date":
+			start, err := parseDate(toks[1:])
+			if err != nil {
+				return canlog.Record{}, r.errorf("invalid date %q", strings.Join(toks[1:], " "))
+			}
+			r.start = start
+			continue
+		case "base":
+			err := r.parseBase(toks)
+			if err != nil {
+				return canlog.Record{}, err
+			}
+			continue
+		case "begin":
+			if len(toks) > 2 && strings.EqualFold(toks[1], "triggerblock") {
+				start, err := parseDate(toks[2:])
+				if err != nil {
+					return canlog.Record{}, r.errorf("invalid date %q", strings.Join(toks[2:], " "))
+				}
+				r.start = start
+			}
+			r.last = 0
+			continue
+		}
+
+		ts, err := parseDuration(toks[0])
+		if err != nil {
+			// header lines and unknown events.
+			continue
+		}
+		rec, ok, err := r.parseEvent(toks[1:])
+		if err != nil {
+			return rec, err
+		}
+		if r.rel {
+			ts += r.last
+		}
+		r.last = ts
+		if !ok {
+			continue
+		}
+		rec.Time = r.start.Add(ts)
+		return rec, nil
+	}
+	if err := r.sc.Err(); err != nil {
+		return canlog.Record{}, fmt.Errorf("asc: could not read log: %w", err)
+	}
+	return canlog.Record{}, io.EOF
+}
+
+func (r *Reader) parseBase(toks []string) error {
+	// base hex|dec  timestamps absolute|relative
+	if len(toks) < 2 {
+		return r.errorf("invalid base")
+	}
+	switch toks[1] {
+	case "hex":
+		r.base = 16
+	case "dec":
+		r.base = 10
+	default:
+		return r.errorf("invalid base %q", toks[1])
+	}
+	if len(toks) >= 4 && toks[2] == "timestamps" {
+		switch toks[3] {
+		case "absolute":
+			r.rel = false
+		case "relative":
+			r.rel = true
+		default:
+			return r.errorf("invalid timestamps %q", toks[3])
+		}
+	}
+	return nil
+}
+
+// parseEvent parses the event following a timestamp.
+// parseEvent reports whether the event is a frame.
+func (r *Reader) parseEvent(toks []string) (canlog.Record, bool, error) {
+	var rec canlog.Record
+	if len(toks) < 2 {
+		return rec, false, nil
+	}
+	if toks[0] == "CANFD" {
+		return r.parseFD(toks[1:])
+	}
+
+	ch, err := strconv.Atoi(toks[0])
+	if err != nil || ch <= 0 {
+		return rec, false, nil
+	}
+	rec.Iface = toks[0]
+	if strings.EqualFold(toks[1], "ErrorFrame") {
+		rec.Frame = canbus.Frame{Kind: canbus.ERR, Data: []byte{}}
+		return rec, true, nil
+	}
+	if len(toks) < 4 {
+		return rec, false, nil
+	}
+
+	// <ch> <id> <dir> d <dlc> <data...> or <ch> <id> <dir> r [<dlc>]
+	id, ext, err := r.parseID(toks[1])
+	if err != nil {
+		return rec, false, nil
+	}
+	tx, ok := parseDir(toks[2])
+	if !ok {
+		return rec, false, nil
+	}
+	if tx {
+		rec.Flags |= canlog.Tx
+	}
+	rec.Frame.ID = id
+	rec.Frame.Kind = canbus.SFF
+	if ext {
+		rec.Frame.Kind = canbus.EFF
+	}
+
+	switch toks[3] {
+	case "r", "R":
+		if ext {
+			rec.Flags |= canlog.Ext
+		}
+		rec.Frame.Kind = canbus.RTR
+		n := 0
+		if len(toks) > 4 {
+			v, err := strconv.ParseUint(toks[4], r.base, 8)
+			if err == nil {
+				n = int(v)
+				if n > 8 {
+					n = 8
+				}
+			}
+		}
+		rec.Frame.Data = make([]byte, n)
+		return rec, true, nil
+	case "d", "D":
+		if len(toks) < 5 {
+			return rec, false, r.errorf("missing data length")
+		}
+		dlc, err := strconv.ParseUint(toks[4], r.base, 8)
+		if err != nil || dlc > 15 {
+			return rec, false, r.errorf("invalid data length %q", toks[4])
+		}
+		n := int(dlc)
+		if n > 8 {
+			n = 8
+		}
+		rec.Frame.Data, err = r.parseData(toks[5:], n)
+		if err != nil {
+			return rec, false, err
+		}
+		return rec, true, nil
+	}
+	return rec, false, nil
+}
+
+// parseFD parses a CAN FD event:
+//
+//	<ch> <dir> <id> [<name>] <brs> <esi> <dlc> <len> <data...> <duration> <length> <flags> ...
+func (r *Reader) parseFD(toks []string) (canlog.Record, bool, error) {
+	var rec canlog.Record
+	if len(toks) < 3 {
+		return rec, false, r.errorf("invalid CAN FD event")
+	}
+	ch, err := strconv.Atoi(toks[0])
+	if err != nil || ch <= 0 {
+		return rec, false, r.errorf("invalid channel %q", toks[0])
+	}
+	rec.Iface = toks[0]
+	tx, ok := parseDir(toks[1])
+	if !ok {
+		return rec, false, r.errorf("invalid direction %q", toks[1])
+	}
+	if tx {
+		rec.Flags |= canlog.Tx
+	}
+	if strings.EqualFold(toks[2], "ErrorFrame") {
+		rec.Frame = canbus.Frame{Kind: canbus.ERR, Data: []byte{}}
+		return rec, true, nil
+	}
+
+	id, ext, err := r.parseID(toks[2])
+	if err != nil {
+		return rec, false, r.errorf("invalid identifier %q", toks[2])
+	}
+	rec.Frame.ID = id
+	rec.Frame.Kind = canbus.SFF
+	if ext {
+		rec.Frame.Kind = canbus.EFF
+	}
+
+	toks = toks[3:]
+	if len(toks) > 0 && toks[0] != "0" && toks[0] != "1" {
+		toks = toks[1:] // symbolic name
+	}
+	if len(toks) < 4 {
+		return rec, false, r.errorf("invalid CAN FD event")
+	}
+	brs, esi := toks[0] == "1", toks[1] == "1"
+	n, err := strconv.ParseUint(toks[3], 10, 8)
+	if err != nil || n > canlog.MaxLen {
+		return rec, false, r.errorf("invalid data length %q", toks[3])
+	}
+	rec.Frame.Data, err = r.parseData(toks[4:], int(n))
+	if err != nil {
+		return rec, false, err
+	}
+
+	flags := uint64(fdEDL)
+	if rest := toks[4+n:]; len(rest) >= 3 {
+		flags, err = strconv.ParseUint(rest[2], 16, 32)
+		if err != nil {
+			return rec, false, r.errorf("invalid flags %q", rest[2])
+		}
+	}
+	switch {
+	case flags&fdEDL != 0:
+		rec.Flags |= canlog.FD
+		if brs {
+			rec.Flags |= canlog.BRS
+		}
+		if esi {
+			rec.Flags |= canlog.ESI
+		}
+	case flags&fdRTR != 0:
+		if ext {
+			rec.Flags |= canlog.Ext
+		}
+		rec.Frame.Kind = canbus.RTR
+	}
+	return rec, true, nil
+}
+
+// parseID parses a frame identifier, with a trailing 'x' for extended
+// identifiers.
+func (r *Reader) parseID(s string) (uint32, bool, error) {
+	ext := strings.HasSuffix(s, "x") || strings.HasSuffix(s, "X")
+	if ext {
+		s = s[:len(s)-1]
+	}
+	id, err := strconv.ParseUint(s, r.base, 32)
+	switch {
+	case err != nil:
+		return 0, false, err
+	case ext && id > effMask, !ext && id > sffMask:
+		return 0, false, fmt.Errorf("invalid identifier")
+	}
+	return uint32(id), ext, nil
+}
+
+func (r *Reader) parseData(toks []string, n int) ([]byte, error) {
+	if len(toks) < n {
+		return nil, r.errorf("missing data bytes")
+	}
+	data := make([]byte, n)
+	for i, tok := range toks[:n] {
+		v, err := strconv.ParseUint(tok, r.base, 8)
+		if err != nil {
+			return nil, r.errorf("invalid data byte %q", tok)
+		}
+		data[i] = byte(v)
+	}
+	return data, nil
+}
+
+func parseDir(s string) (tx bool, ok bool) {
+	switch s {
+	case "Rx":
+		return false, true
+	case "Tx", "TxRq":
+		return true, true
+	}
+	return false, false
+}
+
+// parseDuration parses a decimal number of seconds.
+func parseDuration(s string) (time.Duration, error) {
+	sec, frac := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		sec, frac = s[:i], s[i+1:]
+	}
+	if len(frac) > 9 {
+		frac = frac[:9]
+	}
+	v, err := strconv.ParseUint(sec, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	var ns uint64
+	if frac != "" {
+		ns, err = strconv.ParseUint(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return time.Duration(v)*time.Second + time.Duration(ns), nil
+}
+
+func parseDate(toks []string) (time.Time, error) {
+	for i, tok := range toks {
+		switch tok {
+		case "AM", "PM", "am", "pm":
+			toks[i] = strings.ToLower(tok)
+		}
+	}
+	s := strings.Join(toks, " ")
+	var err error
+	for _, layout := range layouts {
+		var t time.Time
+		t, err = time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// Writer writes records to an ASC log file.
+type Writer struct {
+	w     *bufio.Writer
+	start time.Time
+	hdr   bool
+	chans canlog.Channels
+	err   error
+}
+
+// NewWriter returns a new ASC log writer, writing to w.
+//
+// The measurement starts at the time of the first record written.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+func (w *Writer) header(start time.Time) {
+	w.start = start.Truncate(time.Millisecond)
+	w.hdr = true
+	date := start.Format("Mon Jan 02 03:04:05.000 pm 2006")
+	w.printf("date %s\n", date)
+	w.printf("base hex  timestamps absolute\n")
+	w.printf("internal events logged\n")
+	w.printf("// version 9.0.0\n")
+	w.printf("Begin Triggerblock %s\n", date)
+	w.printf("%11.6f Start of measurement\n", 0.0)
+}
+
+// Write writes the record to the log, with a microsecond resolution.
+// Records must not precede the first record of the log.
+//
+// Records are written on the channel numbers assigned by a
+// canlog.Channels to their interface names.
+func (w *Writer) Write(rec canlog.Record) error {
+	if !w.hdr {
+		w.header(rec.Time)
+	}
+	dt := rec.Time.Sub(w.start)
+	if dt < 0 {
+		return fmt.Errorf("asc: record at %v precedes the start of the measurement", rec.Time)
+	}
+
+	var (
+		frame = rec.Frame
+		ch    = w.chans.Channel(rec.Iface)
+		ts    = fmt.Sprintf("%4d.%06d", dt/time.Second, (dt%time.Second)/time.Microsecond)
+		dir   = "Rx"
+		ext   bool
+		max   = 8
+		fd    = rec.Flags&canlog.FD != 0
+	)
+	if rec.Flags&canlog.Tx != 0 {
+		dir = "Tx"
+	}
+	switch frame.Kind {
+	case canbus.SFF:
+	case canbus.EFF:
+		ext = true
+	case canbus.RTR:
+		ext = rec.Flags&canlog.Ext != 0
+	case canbus.ERR:
+		w.printf("%s %d  ErrorFrame\n", ts, ch)
+		return w.error()
+	default:
+		return fmt.Errorf("asc: invalid frame kind %v", frame.Kind)
+	}
+	if ext && frame.ID > effMask || !ext && frame.ID > sffMask {
+		return fmt.Errorf("asc: invalid frame identifier 0x%x", frame.ID)
+	}
+	if fd {
+		if frame.Kind == canbus.RTR {
+			return fmt.Errorf("asc: invalid CAN FD frame kind %v", frame.Kind)
+		}
+		max = canlog.MaxLen
+	}
+	if len(frame.Data) > max {
+		return fmt.Errorf("asc: invalid frame length %d", len(frame.Data))
+	}
+
+	id := strconv.FormatUint(uint64(frame.ID), 16)
+	id = strings.ToUpper(id)
+	if ext {
+		id += "x"
+	}
+
+	var data strings.Builder
+	for _, b := range frame.Data {
+		fmt.Fprintf(&data, " %02X", b)
+	}
+
+	switch {
+	case fd:
+		var brs, esi int
+		flags := fdEDL
+		if rec.Flags&canlog.BRS != 0 {
+			brs = 1
+			flags |= fdBRS
+		}
+		if rec.Flags&canlog.ESI != 0 {
+			esi = 1
+			flags |= fdESI
+		}
+		w.printf(
+			"%s CANFD %3d %-4s %8s %32s %d %d %x %2d%s %8d %4d %8X %8d %8d %8d %8d %8d\n",
+			ts, ch, dir, id, "", brs, esi, canlog.DLC(len(frame.Data)), len(frame.Data),
+			data.String(), 0, 0, flags, 0, 0, 0, 0, 0,
+		)
+	case frame.Kind == canbus.RTR:
+		w.printf("%s %d  %-15s %-4s r %x\n", ts, ch, id, dir, len(frame.Data))
+	default:
+		w.printf("%s %d  %-15s %-4s d %x%s\n", ts, ch, id, dir, len(frame.Data), data.String())
+	}
+	return w.error()
+}
+
+func (w *Writer) error() error {
+	if w.err != nil {
+		return fmt.Errorf("asc: could not write record: %w", w.err)
+	}
+	return nil
+}
+
+// Flush writes the buffered records to the underlying writer.
+// The log is not terminated, and can still be written to.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return fmt.Errorf("asc: could not write log: %w", w.err)
+	}
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("asc: could not flush log: %w", err)
+	}
+	return nil
+}
+
+// Close writes the end of the log and flushes the records to the
+// underlying writer.
+// The measurement of an empty log starts at the time Close is called.
+func (w *Writer) Close() error {
+	if !w.hdr {
+		w.header(time.Now())
+	}
+	w.printf("End TriggerBlock\n")
+	if w.err != nil {
+		return fmt.Errorf("asc: could not write log: %w", w.err)
+	}
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("asc: could not flush log: %w", err)
+	}
+	return nil
+}
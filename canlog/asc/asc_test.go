@@ -0,0 +1,231 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asc_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/asc"
+)
+
+func readAll(t *testing.T, r canlog.Reader) []canlog.Record {
+	t.Helper()
+	var recs []canlog.Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("could not read record: %+v", err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	t0 := time.Date(2022, time.August, 8, 23, 6, 40, 0, time.Local)
+	recs := []canlog.Record{
+		{
+			Time: t0, Iface: "can0",
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		},
+		{
+			Time: t0.Add(1234 * time.Microsecond), Iface: "can1", Flags: canlog.Tx,
+			Frame: canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+		{
+			Time: t0.Add(2 * time.Millisecond), Iface: "can0",
+			Frame: canbus.Frame{ID: 0x7ff, Kind: canbus.SFF, Data: []byte{}},
+		},
+		{
+			Time: t0.Add(2345 * time.Microsecond), Iface: "can1", Flags: canlog.Ext,
+			Frame: canbus.Frame{ID: 0x100, Kind: canbus.RTR, Data: make([]byte, 8)},
+		},
+		{
+			Time: t0.Add(3456 * time.Microsecond), Iface: "can0", Flags: canlog.FD | canlog.BRS,
+			Frame: canbus.Frame{
+				ID: 0x321, Kind: canbus.SFF,
+				Data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc},
+			},
+		},
+		{
+			Time: t0.Add(3500 * time.Microsecond), Iface: "can1", Flags: canlog.FD | canlog.ESI | canlog.Tx,
+			Frame: canbus.Frame{ID: 0xabcd, Kind: canbus.EFF, Data: []byte{}},
+		},
+		{
+			Time: t0.Add(12*time.Second + 4567*time.Microsecond), Iface: "can0",
+			Frame: canbus.Frame{Kind: canbus.ERR, Data: []byte{}},
+		},
+	}
+
+	var buf bytes.Buffer
+	w := asc.NewWriter(&buf)
+	for _, rec := range recs {
+		err := w.Write(rec)
+		if err != nil {
+			t.Fatalf("could not write record: %+v", err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("could not close writer: %+v", err)
+	}
+
+	want, err := os.ReadFile("testdata/example.asc")
+	if err != nil {
+		t.Fatalf("could not read golden file: %+v", err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("invalid ASC output:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got := readAll(t, asc.NewReader(&buf))
+	for i := range recs {
+		switch recs[i].Iface {
+		case "can0":
+			recs[i].Iface = "1"
+		case "can1":
+			recs[i].Iface = "2"
+		}
+	}
+	if len(got) != len(recs) {
+		t.Fatalf("invalid number of records: got=%d, want=%d", len(got), len(recs))
+	}
+	for i := range got {
+		if !got[i].Time.Equal(recs[i].Time) {
+			t.Fatalf("invalid time for record %d: got=%v, want=%v", i, got[i].Time, recs[i].Time)
+		}
+		got[i].Time = recs[i].Time
+		if !reflect.DeepEqual(got[i], recs[i]) {
+			t.Fatalf("invalid record %d:\ngot= %+v\nwant=%+v", i, got[i], recs[i])
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	const src = `date Tue Aug 9 09:15:00 2022
+base dec  timestamps relative
+no internal events logged
+// version 13.0.0
+Begin Triggerblock Tue Aug 9 09:15:00 2022
+   0.000000 Start of measurement
+   0.500000 1  Statistic: D 0 R 0 XD 0 XR 0 E 0 O 0 B 0.00%
+   0.250000 CAN 1 Status:chip status error active
+   0.250000 1  291             Rx   d 2 17 255  Length = 111910 BitCount = 57 ID = 291
+   0.001000 2  256x            TxRq r 3
+   0.001000 CANFD   1 Rx        801  Engine_Speed 1 0 9 12 1 2 3 4 5 6 7 8 9 10 11 12   0    0     3000        0        0        0        0        0
+   0.001000 CANFD   2 Tx        802                  0 0 8 8 1 2 3 4 5 6 7 8   0    0     0010        0        0        0        0        0
+End TriggerBlock
+`
+	t0 := time.Date(2022, time.August, 9, 9, 15, 0, 0, time.Local)
+	want := []canlog.Record{
+		{
+			Time: t0.Add(time.Second), Iface: "1",
+			Frame: canbus.Frame{ID: 291, Kind: canbus.SFF, Data: []byte{17, 255}},
+		},
+		{
+			Time: t0.Add(1001 * time.Millisecond), Iface: "2", Flags: canlog.Ext | canlog.Tx,
+			Frame: canbus.Frame{ID: 256, Kind: canbus.RTR, Data: make([]byte, 3)},
+		},
+		{
+			Time: t0.Add(1002 * time.Millisecond), Iface: "1", Flags: canlog.FD | canlog.BRS,
+			Frame: canbus.Frame{ID: 801, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		},
+		{
+			Time: t0.Add(1003 * time.Millisecond), Iface: "2", Flags: canlog.Tx,
+			Frame: canbus.Frame{ID: 802, Kind: canbus.RTR, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+	}
+	got := readAll(t, asc.NewReader(strings.NewReader(src)))
+	if len(got) != len(want) {
+		t.Fatalf("invalid number of records: got=%d, want=%d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Time.Equal(want[i].Time) {
+			t.Fatalf("invalid time for record %d: got=%v, want=%v", i, got[i].Time, want[i].Time)
+		}
+		got[i].Time = want[i].Time
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Fatalf("invalid record %d:\ngot= %+v\nwant=%+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"date", "date yesterday", `asc: line 1: invalid date "yesterday"`},
+		{"base", "base oct", `asc: line 1: invalid base "oct"`},
+		{"dlc", "0.1 1 123 Rx d X", `asc: line 1: invalid data length "X"`},
+		{"data", "0.1 1 123 Rx d 2 11", `asc: line 1: missing data bytes`},
+		{"byte", "0.1 1 123 Rx d 1 XYZ", `asc: line 1: invalid data byte "XYZ"`},
+		{"fd-dir", "0.1 CANFD 1 Up 123", `asc: line 1: invalid direction "Up"`},
+		{"fd-len", "0.1 CANFD 1 Rx 123 1 0 f 65", `asc: line 1: invalid data length "65"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := asc.NewReader(strings.NewReader(tc.src)).Read()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	for _, tc := range []struct {
+		name string
+		recs []canlog.Record
+		err  string
+	}{
+		{
+			name: "order",
+			recs: []canlog.Record{{Time: t0}, {Time: t0.Add(-time.Second)}},
+			err:  "asc: record at " + t0.Add(-time.Second).String() + " precedes the start of the measurement",
+		},
+		{
+			name: "id",
+			recs: []canlog.Record{{Time: t0, Frame: canbus.Frame{ID: 0x800}}},
+			err:  "asc: invalid frame identifier 0x800",
+		},
+		{
+			name: "length",
+			recs: []canlog.Record{{Time: t0, Frame: canbus.Frame{ID: 1, Data: make([]byte, 9)}}},
+			err:  "asc: invalid frame length 9",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := asc.NewWriter(io.Discard)
+			var err error
+			for _, rec := range tc.recs {
+				err = w.Write(rec)
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
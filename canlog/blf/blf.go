@@ -0,0 +1,554 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package blf reads and writes Vector binary logging format (BLF) CAN
+// bus log files, as produced by CANoe and CANalyzer.
+//
+// A BLF file is made of a file header followed by a stream of objects,
+// usually grouped into zlib-compressed log containers.
+// The reader decodes the CAN_MESSAGE, CAN_MESSAGE2, CAN_FD_MESSAGE,
+// CAN_FD_MESSAGE_64 and CAN_ERROR_EXT objects and skips the others.
+// The writer produces CAN_MESSAGE, CAN_FD_MESSAGE and CAN_ERROR_EXT
+// objects.
+// Both decode and encode a single log container at a time, so files of
+// any size can be streamed.
+//
+// Records read from BLF files are named after their channel number
+// ("1", "2", ...), and the start time of the measurement is interpreted
+// in the local time zone.
+package blf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+const (
+	fileHeaderSize = 144
+	objHeaderSize  = 16 // size of the base object header
+	objHeaderV1    = 16 // size of the version 1 object header
+	objHeaderV2    = 24 // size of the version 2 object header
+	containerSize  = 16 // size of the log container header
+
+	// maxContainerSize is the size of the uncompressed data of the log
+	// containers produced by the writer.
+	maxContainerSize = 128 * 1024
+
+	// maxObjectSize is the maximum size of the objects handled by the
+	// reader.
+	maxObjectSize = 64 * 1024 * 1024
+)
+
+// object types
+const (
+	objCANMessage     = 1
+	objLogContainer   = 10
+	objCANErrorExt    = 73
+	objCANMessage2    = 86
+	objCANFDMessage   = 100
+	objCANFDMessage64 = 101
+)
+
+// log container compression methods
+const (
+	noCompression = 0
+	zlibDeflate   = 2
+)
+
+const (
+	timeTenMics = 0x1 // object timestamps in units of 10µs
+	timeOneNans = 0x2 // object timestamps in units of 1ns
+
+	canMsgExt  = 0x80000000 // extended identifier flag
+	canMsgDir  = 0x01       // transmitted frame flag
+	canMsgRTR  = 0x80       // remote frame flag
+	canFDEDL   = 0x01       // CAN_FD_MESSAGE: CAN FD frame flag
+	canFDBRS   = 0x02       // CAN_FD_MESSAGE: bit rate switch flag
+	canFDESI   = 0x04       // CAN_FD_MESSAGE: error state indicator flag
+	canFD64RTR = 0x0010     // CAN_FD_MESSAGE_64: remote frame flag
+	canFD64EDL = 0x1000     // CAN_FD_MESSAGE_64: CAN FD frame flag
+	canFD64BRS = 0x2000     // CAN_FD_MESSAGE_64: bit rate switch flag
+	canFD64ESI = 0x4000     // CAN_FD_MESSAGE_64: error state indicator flag
+
+	idMask = 0x1fffffff
+)
+
+var (
+	sigFile   = []byte("LOGG")
+	sigObject = []byte("LOBJ")
+
+	errSignature = errors.New("blf: invalid object signature")
+)
+
+// Reader reads records from a BLF log file.
+type Reader struct {
+	r     *bufio.Reader
+	start time.Time
+	init  bool
+
+	zr  io.ReadCloser
+	buf []byte // uncompressed object data
+	pos int
+	eof bool
+}
+
+// NewReader returns a new BLF log reader, reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Start returns the start time of the measurement, once the first
+// record has been read.
+func (r *Reader) Start() time.Time {
+	return r.start
+}
+
+func (r *Reader) readHeader() error {
+	var hdr [fileHeaderSize]byte
+	_, err := io.ReadFull(r.r, hdr[:8])
+	if err != nil {
+		return fmt.Errorf("blf: could not read file header: %w", noEOF(err))
+	}
+	if !bytes.Equal(hdr[:4], sigFile) {
+		return fmt.Errorf("blf: invalid file signature %q", hdr[:4])
+	}
+	size := binary.LittleEndian.Uint32(hdr[4:8])
+	if size < 72 || size > maxObjectSize {
+		return fmt.Errorf("blf: invalid file header size %d", size)
+	}
+	raw := make([]byte, size)
+	copy(raw, hdr[:8])
+	_, err = io.ReadFull(r.r, raw[8:])
+	if err != nil {
+		return fmt.Errorf("blf: could not read file header: %w", noEOF(err))
+	}
+	r.start = systemTime(raw[40:56])
+	return nil
+}
+
+// Read returns the next CAN frame of the log, or io.EOF at the end of
+// the log.
+func (r *Reader) Read() (canlog.Record, error) {
+	if !r.init {
+		r.init = true
+		err := r.readHeader()
+		if err != nil {
+			r.eof = true
+			return canlog.Record{}, err
+		}
+	}
+	for {
+		rec, ok, err := r.next()
+		if err != nil {
+			return rec, err
+		}
+		if ok {
+			return rec, nil
+		}
+	}
+}
+
+// next decodes the next object of the uncompressed data, and reports
+// whether it is a CAN frame.
+func (r *Reader) next() (canlog.Record, bool, error) {
+	var rec canlog.Record
+
+	pad := 0
+	for {
+		// skip the padding bytes of the previous object.
+		for pad < 3 && r.pos < len(r.buf) && r.buf[r.pos] == 0 {
+			r.pos++
+			pad++
+		}
+		if len(r.buf)-r.pos >= objHeaderSize+objHeaderV1 {
+			break
+		}
+		err := r.fill()
+		if err != nil {
+			return rec, false, err
+		}
+	}
+	obj := r.buf[r.pos:]
+	if !bytes.Equal(obj[:4], sigObject) {
+		return rec, false, errSignature
+	}
+	var (
+		hsize = int(binary.LittleEndian.Uint16(obj[4:6]))
+		hvers = binary.LittleEndian.Uint16(obj[6:8])
+		size  = int(binary.LittleEndian.Uint32(obj[8:12]))
+		typ   = binary.LittleEndian.Uint32(obj[12:16])
+	)
+	if size < hsize || size > maxObjectSize {
+		return rec, false, fmt.Errorf("blf: invalid object size %d", size)
+	}
+	for len(r.buf)-r.pos < size {
+		err := r.fill()
+		if err != nil {
+			return rec, false, err
+		}
+	}
+	obj = r.buf[r.pos : r.pos+size]
+	r.pos += size
+
+	var (
+		flags uint32
+		ts    uint64
+	)
+	switch hvers {
+	case 1:
+		if hsize < objHeaderSize+objHeaderV1 {
+			return rec, false, fmt.Errorf("blf: invalid object header size %d", hsize)
+		}
+		flags = binary.LittleEndian.Uint32(obj[16:20])
+		ts = binary.LittleEndian.Uint64(obj[24:32])
+	case 2:
+		if hsize < objHeaderSize+objHeaderV2 {
+			return rec, false, fmt.Errorf("blf: invalid object header size %d", hsize)
+		}
+		flags = binary.LittleEndian.Uint32(obj[16:20])
+		ts = binary.LittleEndian.Uint64(obj[24:32])
+	default:
+		// unknown object header (e.g. nested log containers, only
+		// found at the top level): skip the object.
+		return rec, false, nil
+	}
+	switch {
+	case flags&timeTenMics != 0:
+		rec.Time = r.start.Add(time.Duration(ts) * 10 * time.Microsecond)
+	default:
+		rec.Time = r.start.Add(time.Duration(ts))
+	}
+
+	body := obj[hsize:]
+	ok, err := decode(&rec, typ, body, hsize)
+	if err != nil {
+		return rec, false, fmt.Errorf("blf: could not decode object (type=%d): %w", typ, err)
+	}
+	return rec, ok, nil
+}
+
+// decode decodes the body of a CAN object into rec, and reports whether
+// the object is a CAN frame.
+// hsize is the size of the header preceding the body of the object.
+func decode(rec *canlog.Record, typ uint32, body []byte, hsize int) (bool, error) {
+	var min int
+	switch typ {
+	case objCANMessage, objCANMessage2:
+		min = 16
+	case objCANErrorExt:
+		min = 32
+	case objCANFDMessage:
+		min = 20
+	case objCANFDMessage64:
+		min = 40
+	default:
+		return false, nil
+	}
+	if len(body) < min {
+		return false, io.ErrUnexpectedEOF
+	}
+
+	var (
+		ch   uint16
+		id   uint32
+		data []byte
+	)
+	switch typ {
+	case objCANMessage, objCANMessage2:
+		// channel(u16) flags(u8) dlc(u8) id(u32) data[8]
+		ch = binary.LittleEndian.Uint16(body[0:2])
+		flags := body[2]
+		n := min8(int(body[3]))
+		id = binary.LittleEndian.Uint32(body[4:8])
+		if flags&canMsgDir != 0 {
+			rec.Flags |= canlog.Tx
+		}
+		if flags&canMsgRTR != 0 {
+			rec.Frame.Kind = canbus.RTR
+			data = make([]byte, n)
+			break
+		}
+		data = body[8 : 8+n]
+
+	case objCANErrorExt:
+		// channel(u16) length(u16) flags(u32) ecc(u8) position(u8)
+		// dlc(u8) reserved(u8) frame_length(u32) id(u32) flags_ext(u16)
+		// reserved(u16) data[8]
+		ch = binary.LittleEndian.Uint16(body[0:2])
+		n := min8(int(body[10]))
+		id = binary.LittleEndian.Uint32(body[16:20])
+		rec.Frame.Kind = canbus.ERR
+		rec.Frame.ID = id & idMask
+		rec.Frame.Data = append([]byte{}, body[24:24+n]...)
+		rec.Iface = strconv.Itoa(int(ch))
+		return true, nil
+
+	case objCANFDMessage:
+		// channel(u16) flags(u8) dlc(u8) id(u32) frame_length(u32)
+		// bit_count(u8) fd_flags(u8) valid_bytes(u8) reserved[5]
+		// data[64]
+		ch = binary.LittleEndian.Uint16(body[0:2])
+		flags := body[2]
+		dlc := body[3]
+		id = binary.LittleEndian.Uint32(body[4:8])
+		fd := body[13]
+		n := int(body[14])
+		if flags&canMsgDir != 0 {
+			rec.Flags |= canlog.Tx
+		}
+		switch {
+		case fd&canFDEDL != 0:
+			rec.Flags |= canlog.FD
+			if fd&canFDBRS != 0 {
+				rec.Flags |= canlog.BRS
+			}
+			if fd&canFDESI != 0 {
+				rec.Flags |= canlog.ESI
+			}
+			if n > canlog.MaxLen {
+				n = canlog.MaxLen
+			}
+		case flags&canMsgRTR != 0:
+			rec.Frame.Kind = canbus.RTR
+			data = make([]byte, min8(int(dlc)))
+		default:
+			n = min8(n)
+		}
+		if data == nil {
+			data = make([]byte, n)
+			copy(data, body[20:])
+		}
+
+	case objCANFDMessage64:
+		// channel(u8) dlc(u8) valid_bytes(u8) tx_count(u8) id(u32)
+		// frame_length(u32) flags(u32) btr_arb(u32) btr_data(u32)
+		// brs_offset(u32) crc_offset(u32) bit_count(u16) dir(u8)
+		// ext_data_offset(u8) crc(u32) data[...]
+		ch = uint16(body[0])
+		dlc := body[1]
+		n := int(body[2])
+		id = binary.LittleEndian.Uint32(body[4:8])
+		flags := binary.LittleEndian.Uint32(body[12:16])
+		dir := body[34]
+		ext := int(body[35])
+		if dir != 0 {
+			rec.Flags |= canlog.Tx
+		}
+		switch {
+		case flags&canFD64EDL != 0:
+			rec.Flags |= canlog.FD
+			if flags&canFD64BRS != 0 {
+				rec.Flags |= canlog.BRS
+			}
+			if flags&canFD64ESI != 0 {
+				rec.Flags |= canlog.ESI
+			}
+			if n > canlog.MaxLen {
+				n = canlog.MaxLen
+			}
+		case flags&canFD64RTR != 0:
+			rec.Frame.Kind = canbus.RTR
+			data = make([]byte, min8(int(dlc)))
+		default:
+			n = min8(n)
+		}
+		if data == nil {
+			// the data may be shorter than the number of valid bytes,
+			// and is then padded with zeros.
+			end := len(body)
+			if ext > 0 && ext-hsize < end {
+				end = ext - hsize
+			}
+			data = make([]byte, n)
+			if end > 40 {
+				copy(data, body[40:end])
+			}
+		}
+	}
+
+	if id&canMsgExt != 0 {
+		switch rec.Frame.Kind {
+		case canbus.RTR:
+			rec.Flags |= canlog.Ext
+		default:
+			rec.Frame.Kind = canbus.EFF
+		}
+	}
+	rec.Frame.ID = id & idMask
+	rec.Frame.Data = append(make([]byte, 0, len(data)), data...)
+	rec.Iface = strconv.Itoa(int(ch))
+	return true, nil
+}
+
+// fill reads the next top-level object of the file, and appends its
+// uncompressed content to the buffer of objects.
+func (r *Reader) fill() error {
+	if r.eof {
+		if r.pos < len(r.buf) {
+			return fmt.Errorf("blf: truncated object: %w", io.ErrUnexpectedEOF)
+		}
+		return io.EOF
+	}
+
+	// discard the consumed data.
+	n := copy(r.buf, r.buf[r.pos:])
+	r.buf = r.buf[:n]
+	r.pos = 0
+
+	var hdr [objHeaderSize + containerSize]byte
+	n, err := io.ReadFull(r.r, hdr[:4])
+	switch {
+	case err == nil:
+	case err == io.EOF || err == io.ErrUnexpectedEOF && isZero(hdr[:n]):
+		// end of file, possibly after the padding bytes of the
+		// previous object.
+		r.eof = true
+		return r.fill()
+	default:
+		return fmt.Errorf("blf: could not read object: %w", noEOF(err))
+	}
+	if !bytes.Equal(hdr[:4], sigObject) {
+		// skip the padding bytes of the previous object.
+		skip := 0
+		for skip < 3 && hdr[skip] == 0 {
+			skip++
+		}
+		if skip == 0 {
+			return errSignature
+		}
+		copy(hdr[:], hdr[skip:4])
+		n, err = io.ReadFull(r.r, hdr[4-skip:4])
+		switch {
+		case err == nil:
+		case isZero(hdr[:4-skip+n]):
+			r.eof = true
+			return r.fill()
+		default:
+			return fmt.Errorf("blf: could not read object: %w", noEOF(err))
+		}
+		if !bytes.Equal(hdr[:4], sigObject) {
+			return errSignature
+		}
+	}
+
+	_, err = io.ReadFull(r.r, hdr[4:objHeaderSize])
+	if err != nil {
+		return fmt.Errorf("blf: could not read object header: %w", noEOF(err))
+	}
+	size := int(binary.LittleEndian.Uint32(hdr[8:12]))
+	typ := binary.LittleEndian.Uint32(hdr[12:16])
+	if size < objHeaderSize || size > maxObjectSize {
+		return fmt.Errorf("blf: invalid object size %d", size)
+	}
+
+	if typ != objLogContainer {
+		// uncompressed object, outside of any container.
+		r.buf = append(r.buf, hdr[:objHeaderSize]...)
+		return r.readInto(size - objHeaderSize)
+	}
+
+	if size < objHeaderSize+containerSize {
+		return fmt.Errorf("blf: invalid log container size %d", size)
+	}
+	_, err = io.ReadFull(r.r, hdr[objHeaderSize:])
+	if err != nil {
+		return fmt.Errorf("blf: could not read log container: %w", noEOF(err))
+	}
+	method := binary.LittleEndian.Uint16(hdr[objHeaderSize:])
+	usize := int(binary.LittleEndian.Uint32(hdr[objHeaderSize+8:]))
+	csize := size - objHeaderSize - containerSize
+	if usize > maxObjectSize {
+		return fmt.Errorf("blf: invalid log container size %d", usize)
+	}
+
+	switch method {
+	case noCompression:
+		return r.readInto(csize)
+	case zlibDeflate:
+		lr := io.LimitReader(r.r, int64(csize))
+		if r.zr == nil {
+			r.zr, err = zlib.NewReader(lr)
+		} else {
+			err = r.zr.(zlib.Resetter).Reset(lr, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("blf: could not decompress log container: %w", noEOF(err))
+		}
+		n := len(r.buf)
+		r.buf = append(r.buf, make([]byte, usize)...)
+		_, err = io.ReadFull(r.zr, r.buf[n:])
+		if err != nil {
+			return fmt.Errorf("blf: could not decompress log container: %w", noEOF(err))
+		}
+		// discard any remaining compressed bytes.
+		_, err = io.Copy(io.Discard, lr)
+		if err != nil {
+			return fmt.Errorf("blf: could not read log container: %w", err)
+		}
+		return nil
+	default:
+		_, err = r.r.Discard(csize)
+		if err != nil {
+			return fmt.Errorf("blf: could not read log container: %w", noEOF(err))
+		}
+		return nil
+	}
+}
+
+// readInto appends the next n bytes of the file to the buffer of objects.
+func (r *Reader) readInto(n int) error {
+	beg := len(r.buf)
+	r.buf = append(r.buf, make([]byte, n)...)
+	_, err := io.ReadFull(r.r, r.buf[beg:])
+	if err != nil {
+		return fmt.Errorf("blf: could not read object: %w", noEOF(err))
+	}
+	return nil
+}
+
+// systemTime decodes a Windows SYSTEMTIME structure.
+func systemTime(p []byte) time.Time {
+	u16 := func(i int) int { return int(binary.LittleEndian.Uint16(p[2*i:])) }
+	if u16(0) == 0 {
+		return time.Time{}
+	}
+	return time.Date(
+		u16(0), time.Month(u16(1)), u16(3),
+		u16(4), u16(5), u16(6), u16(7)*int(time.Millisecond),
+		time.Local,
+	)
+}
+
+func min8(n int) int {
+	if n > 8 {
+		return 8
+	}
+	return n
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
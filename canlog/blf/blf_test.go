@@ -0,0 +1,341 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blf_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/blf"
+)
+
+func readAll(t *testing.T, r canlog.Reader) []canlog.Record {
+	t.Helper()
+	var recs []canlog.Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("could not read record %d: %+v", len(recs), err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func compare(t *testing.T, got, want []canlog.Record) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("invalid number of records: got=%d, want=%d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Time.Equal(want[i].Time) {
+			t.Fatalf("invalid time for record %d: got=%v, want=%v", i, got[i].Time, want[i].Time)
+		}
+		got[i].Time = want[i].Time
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Fatalf("invalid record %d:\ngot= %+v\nwant=%+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	t0 := time.Date(2022, time.August, 8, 23, 6, 40, 123456789, time.Local)
+	recs := []canlog.Record{
+		{
+			Time: t0, Iface: "can0",
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		},
+		{
+			Time: t0.Add(1234 * time.Nanosecond), Iface: "can1", Flags: canlog.Tx,
+			Frame: canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+		{
+			Time: t0.Add(2 * time.Millisecond), Iface: "can0",
+			Frame: canbus.Frame{ID: 0x7ff, Kind: canbus.SFF, Data: []byte{}},
+		},
+		{
+			Time: t0.Add(2345 * time.Microsecond), Iface: "can1", Flags: canlog.Ext,
+			Frame: canbus.Frame{ID: 0x100, Kind: canbus.RTR, Data: make([]byte, 8)},
+		},
+		{
+			Time: t0.Add(3456 * time.Microsecond), Iface: "can0", Flags: canlog.FD | canlog.BRS,
+			Frame: canbus.Frame{
+				ID: 0x321, Kind: canbus.SFF,
+				Data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc},
+			},
+		},
+		{
+			Time: t0.Add(3500 * time.Microsecond), Iface: "can1", Flags: canlog.FD | canlog.ESI | canlog.Tx,
+			Frame: canbus.Frame{ID: 0xabcd, Kind: canbus.EFF, Data: []byte{}},
+		},
+		{
+			Time: t0.Add(12*time.Second + 4567*time.Microsecond), Iface: "can0",
+			Frame: canbus.Frame{ID: 0x004, Kind: canbus.ERR, Data: []byte{0, 0, 0x80, 0, 0, 0, 0, 0}},
+		},
+	}
+	// span several log containers.
+	for i := 0; i < 10000; i++ {
+		recs = append(recs, canlog.Record{
+			Time: t0.Add(13*time.Second + time.Duration(i)*time.Millisecond), Iface: "can1",
+			Frame: canbus.Frame{ID: uint32(i % 0x800), Kind: canbus.SFF, Data: []byte{byte(i), byte(i >> 8)}},
+		})
+	}
+
+	write := func(w io.Writer) {
+		t.Helper()
+		bw := blf.NewWriter(w)
+		for _, rec := range recs {
+			err := bw.Write(rec)
+			if err != nil {
+				t.Fatalf("could not write record: %+v", err)
+			}
+		}
+		err := bw.Close()
+		if err != nil {
+			t.Fatalf("could not close writer: %+v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	write(&buf)
+
+	var pipe pipeWriter
+	write(&pipe)
+	if !bytes.Equal(pipe.Bytes(), buf.Bytes()) {
+		t.Fatalf("invalid content written to pipe")
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.blf"))
+	if err != nil {
+		t.Fatalf("could not create file: %+v", err)
+	}
+	defer f.Close()
+	write(f)
+	raw, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("could not read file: %+v", err)
+	}
+	if got, want := binary.LittleEndian.Uint64(raw[16:24]), uint64(len(raw)); got != want {
+		t.Fatalf("invalid file size: got=%d, want=%d", got, want)
+	}
+	if got, want := binary.LittleEndian.Uint32(raw[32:36]), uint32(len(recs)); got != want {
+		t.Fatalf("invalid number of objects: got=%d, want=%d", got, want)
+	}
+	if !bytes.Equal(raw[144:], buf.Bytes()[144:]) {
+		t.Fatalf("invalid file content")
+	}
+
+	for i := range recs {
+		switch recs[i].Iface {
+		case "can0":
+			recs[i].Iface = "1"
+		case "can1":
+			recs[i].Iface = "2"
+		}
+	}
+	r := blf.NewReader(&buf)
+	got := readAll(t, r)
+	if got, want := r.Start(), t0.Truncate(time.Millisecond); !got.Equal(want) {
+		t.Fatalf("invalid start time: got=%v, want=%v", got, want)
+	}
+	compare(t, got, recs)
+}
+
+// object returns a BLF object with a version 1 header.
+// pipeWriter is an io.WriteSeeker that can not seek, as *os.File
+// writing to a pipe.
+type pipeWriter struct {
+	bytes.Buffer
+}
+
+func (*pipeWriter) Seek(offset int64, whence int) (int64, error) {
+	return 0, syscall.ESPIPE
+}
+
+func object(typ uint32, flags uint32, ts uint64, body []byte) []byte {
+	obj := make([]byte, 32, 32+len(body))
+	copy(obj, "LOBJ")
+	binary.LittleEndian.PutUint16(obj[4:], 32)
+	binary.LittleEndian.PutUint16(obj[6:], 1)
+	binary.LittleEndian.PutUint32(obj[8:], uint32(32+len(body)))
+	binary.LittleEndian.PutUint32(obj[12:], typ)
+	binary.LittleEndian.PutUint32(obj[16:], flags)
+	binary.LittleEndian.PutUint64(obj[24:], ts)
+	return append(obj, body...)
+}
+
+func TestRead(t *testing.T) {
+	var (
+		t0   = time.Date(2022, time.August, 9, 9, 15, 0, 500e6, time.Local)
+		file = make([]byte, 144)
+	)
+	copy(file, "LOGG")
+	binary.LittleEndian.PutUint32(file[4:], 144)
+	for i, v := range []uint16{2022, 8, 2, 9, 9, 15, 0, 500} {
+		binary.LittleEndian.PutUint16(file[40+2*i:], v)
+	}
+
+	// CAN_MESSAGE2, with a 10µs timestamp.
+	msg2 := make([]byte, 24)
+	binary.LittleEndian.PutUint16(msg2[0:], 3)
+	msg2[2] = 0x01
+	msg2[3] = 2
+	binary.LittleEndian.PutUint32(msg2[4:], 0x80000123)
+	copy(msg2[8:], []byte{0xca, 0xfe})
+	objs := object(86, 0x1, 100, msg2)
+
+	// CAN_FD_MESSAGE_64, with a version 2 header.
+	fd64 := make([]byte, 40+12)
+	fd64[0] = 1
+	fd64[1] = 9
+	fd64[2] = 12
+	binary.LittleEndian.PutUint32(fd64[4:], 0x42)
+	binary.LittleEndian.PutUint32(fd64[12:], 0x1000|0x2000)
+	for i := range fd64[40:] {
+		fd64[40+i] = byte(i + 1)
+	}
+	v2 := make([]byte, 40, 40+len(fd64))
+	copy(v2, "LOBJ")
+	binary.LittleEndian.PutUint16(v2[4:], 40)
+	binary.LittleEndian.PutUint16(v2[6:], 2)
+	binary.LittleEndian.PutUint32(v2[8:], uint32(40+len(fd64)))
+	binary.LittleEndian.PutUint32(v2[12:], 101)
+	binary.LittleEndian.PutUint32(v2[16:], 0x2)
+	binary.LittleEndian.PutUint64(v2[24:], 2e6)
+	objs = append(objs, append(v2, fd64...)...)
+
+	// unknown object, skipped.
+	objs = append(objs, object(65, 0x2, 0, make([]byte, 6))...)
+	objs = append(objs, 0, 0)
+
+	// uncompressed log container.
+	cont := make([]byte, 32)
+	copy(cont, "LOBJ")
+	binary.LittleEndian.PutUint16(cont[4:], 16)
+	binary.LittleEndian.PutUint16(cont[6:], 1)
+	binary.LittleEndian.PutUint32(cont[8:], uint32(32+len(objs)))
+	binary.LittleEndian.PutUint32(cont[12:], 10)
+	binary.LittleEndian.PutUint32(cont[24:], uint32(len(objs)))
+	file = append(file, cont...)
+	file = append(file, objs...)
+
+	// CAN_MESSAGE remote frame, outside of any log container.
+	msg := make([]byte, 16)
+	binary.LittleEndian.PutUint16(msg[0:], 2)
+	msg[2] = 0x80
+	msg[3] = 4
+	binary.LittleEndian.PutUint32(msg[4:], 0x7ff)
+	file = append(file, object(1, 0x2, 3e6, msg)...)
+
+	want := []canlog.Record{
+		{
+			Time: t0.Add(time.Millisecond), Iface: "3", Flags: canlog.Tx,
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.EFF, Data: []byte{0xca, 0xfe}},
+		},
+		{
+			Time: t0.Add(2 * time.Millisecond), Iface: "1", Flags: canlog.FD | canlog.BRS,
+			Frame: canbus.Frame{ID: 0x42, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		},
+		{
+			Time: t0.Add(3 * time.Millisecond), Iface: "2",
+			Frame: canbus.Frame{ID: 0x7ff, Kind: canbus.RTR, Data: make([]byte, 4)},
+		},
+	}
+	compare(t, readAll(t, blf.NewReader(bytes.NewReader(file))), want)
+}
+
+func TestReadErrors(t *testing.T) {
+	hdr := make([]byte, 144)
+	copy(hdr, "LOGG")
+	binary.LittleEndian.PutUint32(hdr[4:], 144)
+
+	obj := object(1, 0x2, 0, make([]byte, 16))
+	for _, tc := range []struct {
+		name string
+		src  []byte
+		err  string
+	}{
+		{"empty", nil, "blf: could not read file header: unexpected EOF"},
+		{"file-signature", []byte("LOBJ\x90\x00\x00\x00"), `blf: invalid file signature "LOBJ"`},
+		{"header-size", []byte("LOGG\x10\x00\x00\x00"), "blf: invalid file header size 16"},
+		{"header", hdr[:100], "blf: could not read file header: unexpected EOF"},
+		{"object-signature", append(hdr[:144:144], "LOGG"...), "blf: invalid object signature"},
+		{"object-size", append(hdr[:144:144], "LOBJ\x20\x00\x01\x00\x08\x00\x00\x00\x01\x00\x00\x00"...), "blf: invalid object size 8"},
+		{"object", append(hdr[:144:144], obj[:40]...), "blf: could not read object: unexpected EOF"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := blf.NewReader(bytes.NewReader(tc.src)).Read()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	for _, tc := range []struct {
+		name string
+		recs []canlog.Record
+		err  string
+	}{
+		{
+			name: "order",
+			recs: []canlog.Record{{Time: t0}, {Time: t0.Add(-time.Second)}},
+			err:  "blf: record at " + t0.Add(-time.Second).String() + " precedes the start of the measurement",
+		},
+		{
+			name: "id",
+			recs: []canlog.Record{{Time: t0, Frame: canbus.Frame{ID: 0x800}}},
+			err:  "blf: invalid frame identifier 0x800",
+		},
+		{
+			name: "length",
+			recs: []canlog.Record{{Time: t0, Frame: canbus.Frame{ID: 1, Data: make([]byte, 9)}}},
+			err:  "blf: invalid frame length 9",
+		},
+		{
+			name: "fd-kind",
+			recs: []canlog.Record{{Time: t0, Flags: canlog.FD, Frame: canbus.Frame{ID: 1, Kind: canbus.RTR}}},
+			err:  "blf: invalid CAN FD frame kind RTR",
+		},
+		{
+			name: "channel",
+			recs: []canlog.Record{{Time: t0, Iface: "70000"}},
+			err:  "blf: invalid channel 70000",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := blf.NewWriter(io.Discard)
+			var err error
+			for _, rec := range tc.recs {
+				err = w.Write(rec)
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
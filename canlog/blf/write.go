@@ -0,0 +1,317 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+const (
+	appID = 5 // application identifier of the files produced by the writer
+
+	objCANMessageSize   = 16 // size of the body of a CAN_MESSAGE object
+	objCANFDMessageSize = 84 // size of the body of a CAN_FD_MESSAGE object
+	objCANErrorExtSize  = 32 // size of the body of a CAN_ERROR_EXT object
+)
+
+// Writer writes records to a BLF log file.
+//
+// Records are buffered until a complete log container can be
+// compressed and written.
+// When the underlying writer implements io.WriteSeeker, Close updates
+// the file header with the statistics of the log. Otherwise, the file
+// header is written along with the first log container, and only holds
+// the start time of the measurement.
+type Writer struct {
+	w    io.Writer
+	hdr  bool // whether the file header has been written
+	err  error
+	buf  bytes.Buffer // uncompressed objects
+	zbuf bytes.Buffer // compressed log container
+	zw   *zlib.Writer
+
+	chans canlog.Channels
+	start time.Time
+	stop  time.Time
+	count uint32 // number of objects
+	size  uint64 // size of the file
+	usize uint64 // uncompressed size of the file
+}
+
+// NewWriter returns a new BLF log writer, writing to w.
+//
+// The measurement starts at the time of the first record written.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes the record to the log, with a nanosecond resolution.
+// Records must not precede the first record of the log.
+//
+// Records are written on the channel numbers assigned by a
+// canlog.Channels to their interface names.
+func (w *Writer) Write(rec canlog.Record) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.count == 0 {
+		// the file header holds the start time with a millisecond
+		// resolution.
+		w.start = rec.Time.Truncate(time.Millisecond)
+	}
+	dt := rec.Time.Sub(w.start)
+	if dt < 0 {
+		return fmt.Errorf("blf: record at %v precedes the start of the measurement", rec.Time)
+	}
+
+	var (
+		frame = rec.Frame
+		ch    = w.chans.Channel(rec.Iface)
+		id    = frame.ID
+		ext   bool
+		fd    = rec.Flags&canlog.FD != 0
+		max   = 8
+		flags byte
+	)
+	if ch > 0xffff {
+		return fmt.Errorf("blf: invalid channel %d", ch)
+	}
+	switch frame.Kind {
+	case canbus.SFF:
+	case canbus.EFF:
+		ext = true
+	case canbus.RTR:
+		ext = rec.Flags&canlog.Ext != 0
+		flags |= canMsgRTR
+	case canbus.ERR:
+		ext = id > 0x7ff
+	default:
+		return fmt.Errorf("blf: invalid frame kind %v", frame.Kind)
+	}
+	if ext && id > idMask || !ext && id > 0x7ff {
+		return fmt.Errorf("blf: invalid frame identifier 0x%x", id)
+	}
+	if ext {
+		id |= canMsgExt
+	}
+	if fd {
+		if frame.Kind == canbus.RTR || frame.Kind == canbus.ERR {
+			return fmt.Errorf("blf: invalid CAN FD frame kind %v", frame.Kind)
+		}
+		max = canlog.MaxLen
+	}
+	if len(frame.Data) > max {
+		return fmt.Errorf("blf: invalid frame length %d", len(frame.Data))
+	}
+	if rec.Flags&canlog.Tx != 0 {
+		flags |= canMsgDir
+	}
+
+	var (
+		typ  uint32
+		body []byte
+	)
+	switch {
+	case frame.Kind == canbus.ERR:
+		typ = objCANErrorExt
+		body = make([]byte, objCANErrorExtSize)
+		binary.LittleEndian.PutUint16(body[0:2], uint16(ch))
+		body[10] = byte(len(frame.Data))
+		binary.LittleEndian.PutUint32(body[16:20], id)
+		copy(body[24:], frame.Data)
+	case fd:
+		typ = objCANFDMessage
+		body = make([]byte, objCANFDMessageSize)
+		binary.LittleEndian.PutUint16(body[0:2], uint16(ch))
+		body[2] = flags
+		body[3] = canlog.DLC(len(frame.Data))
+		binary.LittleEndian.PutUint32(body[4:8], id)
+		fdf := byte(canFDEDL)
+		if rec.Flags&canlog.BRS != 0 {
+			fdf |= canFDBRS
+		}
+		if rec.Flags&canlog.ESI != 0 {
+			fdf |= canFDESI
+		}
+		body[13] = fdf
+		body[14] = byte(len(frame.Data))
+		copy(body[20:], frame.Data)
+	default:
+		typ = objCANMessage
+		body = make([]byte, objCANMessageSize)
+		binary.LittleEndian.PutUint16(body[0:2], uint16(ch))
+		body[2] = flags
+		body[3] = byte(len(frame.Data))
+		binary.LittleEndian.PutUint32(body[4:8], id)
+		if frame.Kind != canbus.RTR {
+			copy(body[8:], frame.Data)
+		}
+	}
+
+	w.object(typ, uint64(dt), body)
+	w.stop = rec.Time
+	if w.buf.Len() >= maxContainerSize {
+		w.flush(maxContainerSize)
+	}
+	return w.err
+}
+
+// object appends an object to the buffer of uncompressed objects.
+func (w *Writer) object(typ uint32, ts uint64, body []byte) {
+	var hdr [objHeaderSize + objHeaderV1]byte
+	copy(hdr[:4], sigObject)
+	binary.LittleEndian.PutUint16(hdr[4:6], objHeaderSize+objHeaderV1)
+	binary.LittleEndian.PutUint16(hdr[6:8], 1)
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(len(hdr)+len(body)))
+	binary.LittleEndian.PutUint32(hdr[12:16], typ)
+	binary.LittleEndian.PutUint32(hdr[16:20], timeOneNans)
+	binary.LittleEndian.PutUint64(hdr[24:32], ts)
+	w.buf.Write(hdr[:])
+	w.buf.Write(body)
+	w.count++
+}
+
+// flush writes the buffered objects in log containers holding at most
+// n bytes of uncompressed data.
+func (w *Writer) flush(n int) {
+	if !w.hdr {
+		w.header()
+	}
+	for w.err == nil && w.buf.Len() >= n && w.buf.Len() > 0 {
+		data := w.buf.Next(n)
+		w.zbuf.Reset()
+		if w.zw == nil {
+			w.zw = zlib.NewWriter(&w.zbuf)
+		} else {
+			w.zw.Reset(&w.zbuf)
+		}
+		_, w.err = w.zw.Write(data)
+		if w.err != nil {
+			break
+		}
+		w.err = w.zw.Close()
+		if w.err != nil {
+			break
+		}
+
+		var hdr [objHeaderSize + containerSize]byte
+		size := len(hdr) + w.zbuf.Len()
+		copy(hdr[:4], sigObject)
+		binary.LittleEndian.PutUint16(hdr[4:6], objHeaderSize)
+		binary.LittleEndian.PutUint16(hdr[6:8], 1)
+		binary.LittleEndian.PutUint32(hdr[8:12], uint32(size))
+		binary.LittleEndian.PutUint32(hdr[12:16], objLogContainer)
+		binary.LittleEndian.PutUint16(hdr[16:18], zlibDeflate)
+		binary.LittleEndian.PutUint32(hdr[24:28], uint32(len(data)))
+		w.write(hdr[:])
+		w.write(w.zbuf.Bytes())
+		w.write(make([]byte, size%4))
+		w.usize += uint64(len(hdr) + len(data))
+	}
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(p)
+	w.size += uint64(n)
+}
+
+// header writes the file header.
+func (w *Writer) header() {
+	w.hdr = true
+	w.usize += fileHeaderSize
+	w.write(w.fileHeader())
+}
+
+func (w *Writer) fileHeader() []byte {
+	hdr := make([]byte, fileHeaderSize)
+	copy(hdr[:4], sigFile)
+	binary.LittleEndian.PutUint32(hdr[4:8], fileHeaderSize)
+	hdr[8] = appID
+	copy(hdr[12:16], []byte{2, 6, 8, 1}) // binary log version
+	binary.LittleEndian.PutUint64(hdr[16:24], w.size)
+	binary.LittleEndian.PutUint64(hdr[24:32], w.usize)
+	binary.LittleEndian.PutUint32(hdr[32:36], w.count)
+	putSystemTime(hdr[40:56], w.start)
+	putSystemTime(hdr[56:72], w.stop)
+	return hdr
+}
+
+// Flush writes the buffered records to the underlying writer, in a log
+// container.
+// The statistics of the file header are only updated by Close.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	w.flush(maxContainerSize)
+	w.flush(w.buf.Len())
+	if w.err != nil {
+		return fmt.Errorf("blf: could not write log: %w", w.err)
+	}
+	return nil
+}
+
+// Close flushes the buffered records to the underlying writer and
+// updates the file header, if possible.
+// The file header is left as is when the underlying writer can not seek,
+// as pipes.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return fmt.Errorf("blf: could not write log: %w", w.err)
+	}
+	w.flush(maxContainerSize)
+	w.flush(w.buf.Len())
+	if w.err != nil {
+		return fmt.Errorf("blf: could not write log: %w", w.err)
+	}
+
+	ws, ok := w.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	_, err := ws.Seek(0, io.SeekStart)
+	if err != nil {
+		// not seekable: the log is complete, with its initial header.
+		return nil
+	}
+	_, err = ws.Write(w.fileHeader())
+	if err != nil {
+		return fmt.Errorf("blf: could not update file header: %w", err)
+	}
+	_, err = ws.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("blf: could not seek to end of file: %w", err)
+	}
+	return nil
+}
+
+// putSystemTime encodes t as a Windows SYSTEMTIME structure.
+func putSystemTime(p []byte, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	t = t.Local()
+	for i, v := range []int{
+		t.Year(), int(t.Month()), int(t.Weekday()), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond() / int(time.Millisecond),
+	} {
+		binary.LittleEndian.PutUint16(p[2*i:], uint16(v))
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package candump reads and writes CAN bus log files in the format of
+// the can-utils candump command (candump -l), as replayed by canplayer:
+//
+//	(1660000000.123456) vcan0 123#DEADBEEF
+//	(1660000000.123789) vcan0 12345678#R
+//	(1660000000.124012) vcan1 321##1112233445566778899AABB
+//
+// A typical usage might look like:
+//
+//	r := candump.NewReader(f)
+//	for {
+//	    rec, err := r.Read()
+//	    if err == io.EOF {
+//	        break
+//	    }
+//	    fmt.Println(rec.Time, rec.Iface, rec.Frame)
+//	}
+package candump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+const (
+	sffMask = 0x000007ff
+	effMask = 0x1fffffff
+	errFlag = 0x20000000
+	errMask = 0x1fffffff
+)
+
+// Reader reads records from a candump log file.
+type Reader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+// NewReader returns a new candump log reader, reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{sc: bufio.NewScanner(r)}
+}
+
+// Read returns the next record of the log, or io.EOF at the end of the
+// log.
+// Empty lines and lines starting with '#' are skipped.
+func (r *Reader) Read() (canlog.Record, error) {
+	for r.sc.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rec, err := parseRecord(line)
+		if err != nil {
+			return rec, fmt.Errorf("candump: line %d: %w", r.line, err)
+		}
+		return rec, nil
+	}
+	if err := r.sc.Err(); err != nil {
+		return canlog.Record{}, fmt.Errorf("candump: could not read log: %w", err)
+	}
+	return canlog.Record{}, io.EOF
+}
+
+func parseRecord(line string) (canlog.Record, error) {
+	var rec canlog.Record
+	toks := strings.Fields(line)
+	if len(toks) < 3 || len(toks) > 4 {
+		return rec, fmt.Errorf("invalid record %q", line)
+	}
+
+	var err error
+	rec.Time, err = parseTime(toks[0])
+	if err != nil {
+		return rec, err
+	}
+	rec.Iface = toks[1]
+	rec.Frame, rec.Flags, err = ParseFrame(toks[2])
+	if err != nil {
+		return rec, err
+	}
+	if len(toks) == 4 {
+		switch toks[3] {
+		case "R":
+		case "T":
+			rec.Flags |= canlog.Tx
+		default:
+			return rec, fmt.Errorf("invalid direction %q", toks[3])
+		}
+	}
+	return rec, nil
+}
+
+// parseTime parses a (seconds.fraction) timestamp.
+func parseTime(s string) (time.Time, error) {
+	if len(s) < 3 || s[0] != '(' || s[len(s)-1] != ')' {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	v := s[1 : len(s)-1]
+	frac := ""
+	if i := strings.Index(v, "."); i >= 0 {
+		v, frac = v[:i], v[i+1:]
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || len(frac) > 9 {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	var nsec int64
+	if frac != "" {
+		nsec, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil || nsec < 0 {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// ParseFrame parses a frame written in the can-utils compact notation
+// (as used by candump -l and cansend):
+//
+//	<can_id>#{data}          classic frame
+//	<can_id>#R{len}          remote frame
+//	<can_id>##<flags>{data}  CAN FD frame
+//
+// where can_id is made of 3 (standard) or 8 (extended) hexadecimal
+// digits, data of hexadecimal byte values optionally separated by '.',
+// and flags of a single hexadecimal digit holding the CAN FD flags.
+// Error frames are written with the CAN_ERR_FLAG (0x20000000) set in
+// their 8-digit identifier.
+func ParseFrame(s string) (canbus.Frame, canlog.Flags, error) {
+	var (
+		frame canbus.Frame
+		flags canlog.Flags
+	)
+	i := strings.Index(s, "#")
+	if i < 0 {
+		return frame, flags, fmt.Errorf("invalid frame %q", s)
+	}
+	id, err := strconv.ParseUint(s[:i], 16, 32)
+	if err != nil {
+		return frame, flags, fmt.Errorf("invalid frame identifier %q", s[:i])
+	}
+	switch i {
+	case 3:
+		frame.Kind = canbus.SFF
+		if id > sffMask {
+			return frame, flags, fmt.Errorf("invalid frame identifier %q", s[:i])
+		}
+	case 8:
+		frame.Kind = canbus.EFF
+		switch {
+		case id&errFlag != 0 && id&^(errFlag|errMask) == 0:
+			frame.Kind = canbus.ERR
+			id &= errMask
+		case id > effMask:
+			return frame, flags, fmt.Errorf("invalid frame identifier %q", s[:i])
+		}
+	default:
+		return frame, flags, fmt.Errorf("invalid frame identifier %q", s[:i])
+	}
+	frame.ID = uint32(id)
+
+	max := 8
+	data := s[i+1:]
+	switch {
+	case strings.HasPrefix(data, "#"):
+		if frame.Kind == canbus.ERR || len(data) < 2 {
+			return frame, flags, fmt.Errorf("invalid CAN FD frame %q", s)
+		}
+		v, err := strconv.ParseUint(data[1:2], 16, 8)
+		if err != nil {
+			return frame, flags, fmt.Errorf("invalid CAN FD flags %q", data[1:2])
+		}
+		flags |= canlog.FD
+		if v&0x1 != 0 {
+			flags |= canlog.BRS
+		}
+		if v&0x2 != 0 {
+			flags |= canlog.ESI
+		}
+		data = data[2:]
+		max = canlog.MaxLen
+	case strings.HasPrefix(data, "R"), strings.HasPrefix(data, "r"):
+		if frame.Kind == canbus.ERR {
+			return frame, flags, fmt.Errorf("invalid remote frame %q", s)
+		}
+		if frame.Kind == canbus.EFF {
+			flags |= canlog.Ext
+		}
+		frame.Kind = canbus.RTR
+		data = data[1:]
+		n := 0
+		if data != "" && data[0] != '_' {
+			n = int(data[0] - '0')
+			if n < 0 || n > 8 {
+				return frame, flags, fmt.Errorf("invalid remote frame length %q", data[:1])
+			}
+			data = data[1:]
+		}
+		if data != "" && !validLen8(data) {
+			return frame, flags, fmt.Errorf("invalid remote frame %q", s)
+		}
+		frame.Data = make([]byte, n)
+		return frame, flags, nil
+	}
+
+	frame.Data = make([]byte, 0, 8)
+	for data != "" {
+		if data[0] == '.' {
+			data = data[1:]
+			continue
+		}
+		if data[0] == '_' && flags&canlog.FD == 0 && len(frame.Data) == 8 && validLen8(data) {
+			break
+		}
+		if len(data) < 2 {
+			return frame, flags, fmt.Errorf("invalid frame data %q", s[i+1:])
+		}
+		v, err := strconv.ParseUint(data[:2], 16, 8)
+		if err != nil {
+			return frame, flags, fmt.Errorf("invalid frame data %q", s[i+1:])
+		}
+		frame.Data = append(frame.Data, byte(v))
+		data = data[2:]
+	}
+	if len(frame.Data) > max {
+		return frame, flags, fmt.Errorf("invalid frame length %d", len(frame.Data))
+	}
+	if flags&canlog.FD != 0 {
+		// pad the payload to a valid CAN FD length, like can-utils.
+		n := canlog.Len(canlog.DLC(len(frame.Data)))
+		frame.Data = append(frame.Data, make([]byte, n-len(frame.Data))...)
+	}
+	return frame, flags, nil
+}
+
+// validLen8 reports whether s is a valid _{dlc} suffix, giving the
+// data length code of classic frames with a DLC greater than 8.
+func validLen8(s string) bool {
+	if len(s) != 2 || s[0] != '_' {
+		return false
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 8)
+	return err == nil && v > 8
+}
+
+// Writer writes records to a candump log file.
+type Writer struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+// NewWriter returns a new candump log writer, writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:   bufio.NewWriter(w),
+		buf: make([]byte, 0, 256),
+	}
+}
+
+// Write writes the record to the log, formatted as candump -l does.
+// The Tx flag of the record is not written.
+func (w *Writer) Write(rec canlog.Record) error {
+	if rec.Iface == "" || strings.ContainsAny(rec.Iface, " \t\r\n") {
+		return fmt.Errorf("candump: invalid interface name %q", rec.Iface)
+	}
+	sec := rec.Time.Unix()
+	usec := rec.Time.Nanosecond() / 1000
+	if sec < 0 {
+		return fmt.Errorf("candump: invalid timestamp %v", rec.Time)
+	}
+
+	buf := w.buf[:0]
+	buf = append(buf, '(')
+	buf = appendPadded(buf, uint64(sec), 10)
+	buf = append(buf, '.')
+	buf = appendPadded(buf, uint64(usec), 6)
+	buf = append(buf, ") "...)
+	buf = append(buf, rec.Iface...)
+	buf = append(buf, ' ')
+	buf, err := AppendFrame(buf, rec.Frame, rec.Flags)
+	if err != nil {
+		return fmt.Errorf("candump: %w", err)
+	}
+	buf = append(buf, '\n')
+	w.buf = buf
+
+	_, err = w.w.Write(buf)
+	if err != nil {
+		return fmt.Errorf("candump: could not write record: %w", err)
+	}
+	return nil
+}
+
+// Flush writes the buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("candump: could not flush log: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the records to the underlying writer.
+func (w *Writer) Close() error {
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("candump: could not flush log: %w", err)
+	}
+	return nil
+}
+
+const hexDigits = "0123456789ABCDEF"
+
+// AppendFrame appends the can-utils compact notation of the frame to
+// buf, as described in ParseFrame.
+func AppendFrame(buf []byte, frame canbus.Frame, flags canlog.Flags) ([]byte, error) {
+	var (
+		id   = frame.ID
+		ext  bool
+		max  = 8
+		fd   = flags&canlog.FD != 0
+		kind = frame.Kind
+	)
+	switch kind {
+	case canbus.SFF:
+	case canbus.EFF:
+		ext = true
+	case canbus.RTR:
+		ext = flags&canlog.Ext != 0
+	case canbus.ERR:
+		if id > errMask {
+			return buf, fmt.Errorf("invalid frame identifier 0x%x", id)
+		}
+		ext = true
+		id |= errFlag
+	default:
+		return buf, fmt.Errorf("invalid frame kind %v", kind)
+	}
+	switch {
+	case kind == canbus.ERR:
+	case ext && id > effMask, !ext && id > sffMask:
+		return buf, fmt.Errorf("invalid frame identifier 0x%x", id)
+	}
+	if fd {
+		if kind == canbus.RTR || kind == canbus.ERR {
+			return buf, fmt.Errorf("invalid CAN FD frame kind %v", kind)
+		}
+		max = canlog.MaxLen
+	}
+	if len(frame.Data) > max {
+		return buf, fmt.Errorf("invalid frame length %d", len(frame.Data))
+	}
+
+	switch {
+	case ext:
+		buf = appendHex(buf, uint64(id), 8)
+	default:
+		buf = appendHex(buf, uint64(id), 3)
+	}
+	buf = append(buf, '#')
+
+	switch {
+	case kind == canbus.RTR:
+		buf = append(buf, 'R')
+		if n := len(frame.Data); n > 0 {
+			buf = append(buf, hexDigits[n])
+		}
+		return buf, nil
+	case fd:
+		var v byte
+		if flags&canlog.BRS != 0 {
+			v |= 0x1
+		}
+		if flags&canlog.ESI != 0 {
+			v |= 0x2
+		}
+		buf = append(buf, '#', hexDigits[v])
+	}
+	for _, b := range frame.Data {
+		buf = append(buf, hexDigits[b>>4], hexDigits[b&0xf])
+	}
+	return buf, nil
+}
+
+func appendHex(buf []byte, v uint64, width int) []byte {
+	for i := width - 1; i >= 0; i-- {
+		buf = append(buf, hexDigits[(v>>(4*uint(i)))&0xf])
+	}
+	return buf
+}
+
+func appendPadded(buf []byte, v uint64, width int) []byte {
+	s := strconv.FormatUint(v, 10)
+	for i := len(s); i < width; i++ {
+		buf = append(buf, '0')
+	}
+	return append(buf, s...)
+}
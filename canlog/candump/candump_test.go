@@ -0,0 +1,220 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package candump_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/candump"
+)
+
+func TestReadWrite(t *testing.T) {
+	raw, err := os.ReadFile("testdata/example.log")
+	if err != nil {
+		t.Fatalf("could not read log file: %+v", err)
+	}
+
+	var recs []canlog.Record
+	r := candump.NewReader(bytes.NewReader(raw))
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read record: %+v", err)
+		}
+		recs = append(recs, rec)
+	}
+
+	ts := func(sec, usec int64) time.Time {
+		return time.Unix(sec, usec*1000)
+	}
+	want := []canlog.Record{
+		{
+			Time: ts(1660000000, 123456), Iface: "vcan0",
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		},
+		{
+			Time: ts(1660000000, 123500), Iface: "vcan0",
+			Frame: canbus.Frame{ID: 0x12345678, Kind: canbus.EFF, Data: []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77}},
+		},
+		{
+			Time: ts(1660000000, 200000), Iface: "vcan0",
+			Frame: canbus.Frame{ID: 0x7ff, Kind: canbus.SFF, Data: []byte{}},
+		},
+		{
+			Time: ts(1660000000, 300001), Iface: "vcan1",
+			Frame: canbus.Frame{ID: 0x456, Kind: canbus.RTR, Data: []byte{}},
+		},
+		{
+			Time: ts(1660000000, 300002), Iface: "vcan1",
+			Frame: canbus.Frame{ID: 0x456, Kind: canbus.RTR, Data: make([]byte, 3)},
+		},
+		{
+			Time: ts(1660000000, 300003), Iface: "vcan1", Flags: canlog.Ext,
+			Frame: canbus.Frame{ID: 0x456, Kind: canbus.RTR, Data: make([]byte, 8)},
+		},
+		{
+			Time: ts(1660000001, 0), Iface: "can0", Flags: canlog.FD | canlog.BRS,
+			Frame: canbus.Frame{
+				ID: 0x321, Kind: canbus.SFF,
+				Data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0},
+			},
+		},
+		{
+			Time: ts(1660000001, 1), Iface: "can0", Flags: canlog.FD | canlog.BRS | canlog.ESI,
+			Frame: canbus.Frame{ID: 0xabcd, Kind: canbus.EFF, Data: []byte{0}},
+		},
+		{
+			Time: ts(1660000002, 500000), Iface: "can0",
+			Frame: canbus.Frame{ID: 0x4, Kind: canbus.ERR, Data: []byte{0, 4, 0, 0, 0, 0, 0, 0}},
+		},
+	}
+	if !reflect.DeepEqual(recs, want) {
+		t.Fatalf("invalid records:\ngot= %+v\nwant=%+v", recs, want)
+	}
+
+	var buf bytes.Buffer
+	w := candump.NewWriter(&buf)
+	for _, rec := range recs {
+		err := w.Write(rec)
+		if err != nil {
+			t.Fatalf("could not write record: %+v", err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("could not close writer: %+v", err)
+	}
+
+	// the FD payload of the first CAN FD frame has been padded.
+	want8 := strings.Replace(string(raw), "##1112233445566778899AABB", "##1112233445566778899AABB00", 1)
+	if got := buf.String(); got != want8 {
+		t.Fatalf("invalid log output:\ngot:\n%s\nwant:\n%s", got, want8)
+	}
+}
+
+func TestReadExtra(t *testing.T) {
+	const src = `# comment
+
+(1660000000.5) can0 123#11.22.33 T
+(1660000000.000000001) can0 123#1122334455667788_C R
+`
+	r := candump.NewReader(strings.NewReader(src))
+	rec, err := r.Read()
+	if err != nil {
+		t.Fatalf("could not read record: %+v", err)
+	}
+	want := canlog.Record{
+		Time: time.Unix(1660000000, 500000000), Iface: "can0", Flags: canlog.Tx,
+		Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0x11, 0x22, 0x33}},
+	}
+	if !reflect.DeepEqual(rec, want) {
+		t.Fatalf("invalid record:\ngot= %+v\nwant=%+v", rec, want)
+	}
+
+	rec, err = r.Read()
+	if err != nil {
+		t.Fatalf("could not read record: %+v", err)
+	}
+	if got, want := rec.Time, time.Unix(1660000000, 1); !got.Equal(want) {
+		t.Fatalf("invalid timestamp: got=%v, want=%v", got, want)
+	}
+	if got, want := len(rec.Frame.Data), 8; got != want {
+		t.Fatalf("invalid length: got=%d, want=%d", got, want)
+	}
+
+	_, err = r.Read()
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, got %+v", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"fields", "(1.0) can0", `candump: line 1: invalid record "(1.0) can0"`},
+		{"timestamp", "1.0 can0 123#", `candump: line 1: invalid timestamp "1.0"`},
+		{"no-hash", "(1.0) can0 123", `candump: line 1: invalid frame "123"`},
+		{"id-len", "(1.0) can0 1234#", `candump: line 1: invalid frame identifier "1234"`},
+		{"sff-id", "(1.0) can0 800#", `candump: line 1: invalid frame identifier "800"`},
+		{"eff-id", "(1.0) can0 40000000#", `candump: line 1: invalid frame identifier "40000000"`},
+		{"data", "(1.0) can0 123#1", `candump: line 1: invalid frame data "1"`},
+		{"hex", "(1.0) can0 123#XY", `candump: line 1: invalid frame data "XY"`},
+		{"too-long", "(1.0) can0 123#112233445566778899", `candump: line 1: invalid frame length 9`},
+		{"rtr-len", "(1.0) can0 123#R9", `candump: line 1: invalid remote frame length "9"`},
+		{"fd-flags", "(1.0) can0 123##Z", `candump: line 1: invalid CAN FD flags "Z"`},
+		{"direction", "(1.0) can0 123# X", `candump: line 1: invalid direction "X"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := candump.NewReader(strings.NewReader(tc.src))
+			_, err := r.Read()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	for _, tc := range []struct {
+		name string
+		rec  canlog.Record
+		err  string
+	}{
+		{
+			name: "iface",
+			rec:  canlog.Record{Time: t0, Iface: "can 0"},
+			err:  `candump: invalid interface name "can 0"`,
+		},
+		{
+			name: "sff-id",
+			rec:  canlog.Record{Time: t0, Iface: "can0", Frame: canbus.Frame{ID: 0x800}},
+			err:  `candump: invalid frame identifier 0x800`,
+		},
+		{
+			name: "timestamp",
+			rec:  canlog.Record{Time: time.Unix(-1, 0).UTC(), Iface: "can0"},
+			err:  `candump: invalid timestamp 1969-12-31 23:59:59 +0000 UTC`,
+		},
+		{
+			name: "length",
+			rec:  canlog.Record{Time: t0, Iface: "can0", Frame: canbus.Frame{ID: 1, Data: make([]byte, 9)}},
+			err:  `candump: invalid frame length 9`,
+		},
+		{
+			name: "fd-rtr",
+			rec:  canlog.Record{Time: t0, Iface: "can0", Flags: canlog.FD, Frame: canbus.Frame{ID: 1, Kind: canbus.RTR}},
+			err:  `candump: invalid CAN FD frame kind RTR`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := candump.NewWriter(io.Discard)
+			err := w.Write(tc.rec)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package canlog describes timestamped CAN frames, as stored in CAN bus
+// log files.
+//
+// The sub-packages of canlog read and write the records of the various
+// log file formats (candump, ...) through the Reader and Writer
+// interfaces, so logs can be converted between formats and replayed on
+// a CAN bus.
+package canlog
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-daq/canbus"
+)
+
+// Record is a CAN frame logged at a given time on a given interface.
+type Record struct {
+	Time  time.Time
+	Iface string // name of the interface or channel of the frame
+	Flags Flags
+	Frame canbus.Frame
+}
+
+// Flags describes the properties of a logged frame that are not held
+// by canbus.Frame.
+type Flags uint8
+
+const (
+	FD  Flags = 1 << iota // CAN FD frame
+	BRS                   // CAN FD frame sent with a bit rate switch
+	ESI                   // CAN FD frame with the error state indicator set
+	Ext                   // remote frame with an extended identifier
+	Tx                    // frame transmitted, rather than received, by the logger
+)
+
+// Reader is the interface implemented by log file readers.
+//
+// Read returns the next record of the log, or io.EOF at the end of
+// the log.
+type Reader interface {
+	Read() (Record, error)
+}
+
+// Writer is the interface implemented by log file writers.
+//
+// Close flushes the pending records and finalizes the log, without
+// closing the underlying output.
+type Writer interface {
+	Write(rec Record) error
+	Close() error
+}
+
+// Flusher is the interface implemented by log file writers able to
+// flush their pending records to the underlying output.
+//
+// Flush writes the records written so far to the underlying output, so
+// they can be read back even if the log is never closed, e.g. after a
+// crash of the logger.
+type Flusher interface {
+	Flush() error
+}
+
+// MaxLen is the maximum payload size of a CAN FD frame.
+const MaxLen = 64
+
+var dlc2len = [16]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 16, 20, 24, 32, 48, 64}
+
+// Len returns the payload size of a CAN FD frame with the provided
+// data length code.
+func Len(dlc uint8) int {
+	return dlc2len[dlc&0xf]
+}
+
+// DLC returns the data length code of a CAN FD frame carrying n bytes.
+// Payload sizes that cannot be encoded are rounded up to the next
+// valid size.
+func DLC(n int) uint8 {
+	for dlc, v := range dlc2len {
+		if n <= v {
+			return uint8(dlc)
+		}
+	}
+	return 15
+}
+
+// Channels assigns channel numbers to interface names, for the log
+// formats identifying interfaces by number.
+//
+// Interface names made of a positive decimal number are used as channel
+// numbers. Other names are assigned the lowest channel numbers not yet
+// in use, in order of appearance.
+type Channels struct {
+	chans map[string]int
+	used  map[int]bool
+}
+
+// Channel returns the channel number of the named interface.
+func (c *Channels) Channel(iface string) int {
+	if c.chans == nil {
+		c.chans = make(map[string]int)
+		c.used = make(map[int]bool)
+	}
+	if ch, ok := c.chans[iface]; ok {
+		return ch
+	}
+	ch, err := strconv.Atoi(iface)
+	if err != nil || ch <= 0 || strconv.Itoa(ch) != iface {
+		ch = 1
+		for c.used[ch] {
+			ch++
+		}
+	}
+	c.chans[iface] = ch
+	c.used[ch] = true
+	return ch
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package canlog_test
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus/canlog"
+)
+
+func TestDLC(t *testing.T) {
+	for _, tc := range []struct {
+		n   int
+		dlc uint8
+		len int
+	}{
+		{0, 0, 0},
+		{8, 8, 8},
+		{9, 9, 12},
+		{12, 9, 12},
+		{33, 14, 48},
+		{64, 15, 64},
+	} {
+		dlc := canlog.DLC(tc.n)
+		if dlc != tc.dlc {
+			t.Fatalf("invalid DLC(%d): got=%d, want=%d", tc.n, dlc, tc.dlc)
+		}
+		if got, want := canlog.Len(dlc), tc.len; got != want {
+			t.Fatalf("invalid Len(%d): got=%d, want=%d", dlc, got, want)
+		}
+	}
+}
+
+func TestChannels(t *testing.T) {
+	var chans canlog.Channels
+	for _, tc := range []struct {
+		iface string
+		ch    int
+	}{
+		{"2", 2},
+		{"can0", 1},
+		{"can1", 3},
+		{"2", 2},
+		{"can0", 1},
+		{"01", 4},
+		{"7", 7},
+	} {
+		if got, want := chans.Channel(tc.iface), tc.ch; got != want {
+			t.Fatalf("invalid channel for %q: got=%d, want=%d", tc.iface, got, want)
+		}
+	}
+}
+
+type sliceReader struct {
+	recs []canlog.Record
+	err  error
+}
+
+func (r *sliceReader) Read() (canlog.Record, error) {
+	if len(r.recs) == 0 {
+		if r.err != nil {
+			return canlog.Record{}, r.err
+		}
+		return canlog.Record{}, io.EOF
+	}
+	rec := r.recs[0]
+	r.recs = r.recs[1:]
+	return rec, nil
+}
+
+func TestMerge(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	rec := func(ms int, iface string) canlog.Record {
+		return canlog.Record{Time: t0.Add(time.Duration(ms) * time.Millisecond), Iface: iface}
+	}
+	r := canlog.Merge(
+		&sliceReader{recs: []canlog.Record{rec(1, "a"), rec(3, "a"), rec(3, "a"), rec(7, "a")}},
+		&sliceReader{},
+		&sliceReader{recs: []canlog.Record{rec(0, "b"), rec(3, "b"), rec(8, "b")}},
+		&sliceReader{recs: []canlog.Record{rec(2, "c")}},
+	)
+	var got []canlog.Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read record: %+v", err)
+		}
+		got = append(got, rec)
+	}
+	want := []canlog.Record{
+		rec(0, "b"), rec(1, "a"), rec(2, "c"), rec(3, "a"),
+		rec(3, "a"), rec(3, "b"), rec(7, "a"), rec(8, "b"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid records:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	errBad := errors.New("bad record")
+	r = canlog.Merge(
+		&sliceReader{recs: []canlog.Record{rec(1, "a")}},
+		&sliceReader{recs: []canlog.Record{rec(0, "b")}, err: errBad},
+	)
+	for i := 0; i < 3; i++ {
+		_, err := r.Read()
+		switch i {
+		case 0:
+			if err != nil {
+				t.Fatalf("could not read record: %+v", err)
+			}
+		default:
+			if err != errBad {
+				t.Fatalf("invalid error: got=%v, want=%v", err, errBad)
+			}
+		}
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gvret reads and writes the GVRET comma-separated values (CSV)
+// CAN bus log files, native to SavvyCAN:
+//
+//	Time Stamp,ID,Extended,Dir,Bus,LEN,D1,D2,D3,D4,D5,D6,D7,D8
+//	1660000000123456,00000123,false,Rx,0,4,DE,AD,BE,EF,
+//	1660000000124690,18FEF100,true,Tx,1,8,01,02,03,04,05,06,07,08,
+//
+// Timestamps are stored in microseconds. The reader interprets them as
+// microseconds since the Unix epoch, and the writer stores them as
+// such: files holding timestamps relative to the start of the capture
+// are read as starting on January 1st, 1970.
+//
+// Records read from GVRET files are named after their bus number,
+// counted from 1 like the channels of the other log formats ("1" for
+// the bus 0, "2" for the bus 1, ...), and written on the bus numbers
+// assigned by a canlog.Channels, minus one.
+//
+// The format does not describe remote frames, error frames and the
+// flags of CAN FD frames: frames with more than 8 bytes of data are read
+// as CAN FD frames.
+package gvret
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+const (
+	effMask = 0x1fffffff
+	sffMask = 0x7ff
+)
+
+// headerLine is the header line written by SavvyCAN.
+const headerLine = "Time Stamp,ID,Extended,Dir,Bus,LEN,D1,D2,D3,D4,D5,D6,D7,D8"
+
+// columns of a GVRET file.
+const (
+	colTime = iota
+	colID
+	colExt
+	colDir
+	colBus
+	colLen
+	colData
+	numCols
+)
+
+var names = [numCols]string{
+	colTime: "Time Stamp",
+	colID:   "ID",
+	colExt:  "Extended",
+	colDir:  "Dir",
+	colBus:  "Bus",
+	colLen:  "LEN",
+	colData: "D1",
+}
+
+// Reader reads records from a GVRET log file.
+type Reader struct {
+	sc   *bufio.Scanner
+	line int
+	cols [numCols]int // indices of the columns, -1 for missing columns
+}
+
+// NewReader returns a new GVRET log reader, reading from r.
+func NewReader(r io.Reader) *Reader {
+	rr := &Reader{sc: bufio.NewScanner(r)}
+	for i := range rr.cols {
+		rr.cols[i] = i
+	}
+	return rr
+}
+
+func (r *Reader) errorf(format string, args ...any) error {
+	return fmt.Errorf("gvret: line %d: %s", r.line, fmt.Sprintf(format, args...))
+}
+
+// Read returns the next frame of the log, or io.EOF at the end of the
+// log.
+func (r *Reader) Read() (canlog.Record, error) {
+	for r.sc.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.sc.Text())
+		if line == "" {
+			continue
+		}
+		// data bytes are followed by a trailing comma.
+		toks := strings.Split(strings.TrimSuffix(line, ","), ",")
+		if strings.EqualFold(toks[0], names[colTime]) {
+			err := r.parseHeader(toks)
+			if err != nil {
+				return canlog.Record{}, err
+			}
+			continue
+		}
+		return r.parseRecord(toks)
+	}
+	if err := r.sc.Err(); err != nil {
+		return canlog.Record{}, fmt.Errorf("gvret: could not read log: %w", err)
+	}
+	return canlog.Record{}, io.EOF
+}
+
+// parseHeader locates the columns of the file, as older versions of
+// SavvyCAN do not write the Dir and Bus columns.
+func (r *Reader) parseHeader(toks []string) error {
+	for i, name := range names {
+		r.cols[i] = -1
+		for j, tok := range toks {
+			if strings.EqualFold(strings.TrimSpace(tok), name) {
+				r.cols[i] = j
+				break
+			}
+		}
+		switch i {
+		case colDir, colBus:
+		default:
+			if r.cols[i] < 0 {
+				return r.errorf("missing column %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Reader) parseRecord(toks []string) (canlog.Record, error) {
+	var rec canlog.Record
+	col := func(i int) string {
+		if r.cols[i] < 0 || r.cols[i] >= len(toks) {
+			return ""
+		}
+		return strings.TrimSpace(toks[r.cols[i]])
+	}
+	if len(toks) < r.cols[colData] {
+		return rec, r.errorf("missing columns")
+	}
+
+	ts, err := strconv.ParseInt(col(colTime), 10, 64)
+	if err != nil {
+		return rec, r.errorf("invalid timestamp %q", col(colTime))
+	}
+	rec.Time = time.Unix(ts/1e6, ts%1e6*1e3)
+
+	s := col(colID)
+	id, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 32)
+	if err != nil {
+		return rec, r.errorf("invalid identifier %q", s)
+	}
+	ext, err := strconv.ParseBool(col(colExt))
+	if err != nil {
+		return rec, r.errorf("invalid extended flag %q", col(colExt))
+	}
+	if ext && id > effMask || !ext && id > sffMask {
+		return rec, r.errorf("invalid identifier %q", s)
+	}
+	rec.Frame.ID = uint32(id)
+	rec.Frame.Kind = canbus.SFF
+	if ext {
+		rec.Frame.Kind = canbus.EFF
+	}
+
+	switch dir := col(colDir); dir {
+	case "Rx", "":
+	case "Tx":
+		rec.Flags |= canlog.Tx
+	default:
+		return rec, r.errorf("invalid direction %q", dir)
+	}
+
+	bus := 0
+	if s := col(colBus); s != "" {
+		bus, err = strconv.Atoi(s)
+		if err != nil || bus < 0 {
+			return rec, r.errorf("invalid bus %q", s)
+		}
+	}
+	rec.Iface = strconv.Itoa(bus + 1)
+
+	s = col(colLen)
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || n > canlog.MaxLen || canlog.Len(canlog.DLC(int(n))) != int(n) {
+		return rec, r.errorf("invalid data length %q", s)
+	}
+	if n > 8 {
+		rec.Flags |= canlog.FD
+	}
+	data := toks[r.cols[colData]:]
+	if len(data) < int(n) {
+		return rec, r.errorf("missing data bytes")
+	}
+	rec.Frame.Data = make([]byte, n)
+	for i, tok := range data[:n] {
+		v, err := strconv.ParseUint(strings.TrimSpace(tok), 16, 8)
+		if err != nil {
+			return rec, r.errorf("invalid data byte %q", tok)
+		}
+		rec.Frame.Data[i] = byte(v)
+	}
+	return rec, nil
+}
+
+// Writer writes records to a GVRET log file.
+type Writer struct {
+	w     *bufio.Writer
+	hdr   bool
+	chans canlog.Channels
+	buf   []byte
+}
+
+// NewWriter returns a new GVRET log writer, writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) header() error {
+	if w.hdr {
+		return nil
+	}
+	w.hdr = true
+	_, err := w.w.WriteString(headerLine + "\n")
+	if err != nil {
+		return fmt.Errorf("gvret: could not write header: %w", err)
+	}
+	return nil
+}
+
+// Write writes the record to the log, with a microsecond resolution.
+func (w *Writer) Write(rec canlog.Record) error {
+	err := w.header()
+	if err != nil {
+		return err
+	}
+
+	var (
+		frame = rec.Frame
+		ext   bool
+		max   = 8
+		dir   = "Rx"
+		data  = frame.Data
+	)
+	switch frame.Kind {
+	case canbus.SFF:
+	case canbus.EFF:
+		ext = true
+	default:
+		return fmt.Errorf("gvret: unsupported frame kind %v", frame.Kind)
+	}
+	if ext && frame.ID > effMask || !ext && frame.ID > sffMask {
+		return fmt.Errorf("gvret: invalid frame identifier 0x%x", frame.ID)
+	}
+	if rec.Flags&canlog.FD != 0 {
+		max = canlog.MaxLen
+	}
+	if len(data) > max {
+		return fmt.Errorf("gvret: invalid frame length %d", len(data))
+	}
+	if n := canlog.Len(canlog.DLC(len(data))); n != len(data) {
+		// pad the payload to a valid CAN FD length.
+		data = append(data[:len(data):len(data)], make([]byte, n-len(data))...)
+	}
+	if rec.Flags&canlog.Tx != 0 {
+		dir = "Tx"
+	}
+
+	buf := w.buf[:0]
+	buf = strconv.AppendInt(buf, rec.Time.UnixNano()/1e3, 10)
+	buf = append(buf, fmt.Sprintf(",%08X,%t,%s,", frame.ID, ext, dir)...)
+	buf = strconv.AppendInt(buf, int64(w.chans.Channel(rec.Iface)-1), 10)
+	buf = append(buf, ',')
+	buf = strconv.AppendInt(buf, int64(len(data)), 10)
+	buf = append(buf, ',')
+	for _, b := range data {
+		buf = append(buf, fmt.Sprintf("%02X,", b)...)
+	}
+	buf = append(buf, '\n')
+	w.buf = buf
+
+	_, err = w.w.Write(buf)
+	if err != nil {
+		return fmt.Errorf("gvret: could not write record: %w", err)
+	}
+	return nil
+}
+
+// Flush writes the buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	err := w.header()
+	if err != nil {
+		return err
+	}
+	err = w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("gvret: could not flush log: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the records to the underlying writer.
+func (w *Writer) Close() error {
+	err := w.header()
+	if err != nil {
+		return err
+	}
+	err = w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("gvret: could not flush log: %w", err)
+	}
+	return nil
+}
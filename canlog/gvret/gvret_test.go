@@ -0,0 +1,185 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gvret_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/gvret"
+)
+
+func readAll(t *testing.T, r canlog.Reader) []canlog.Record {
+	t.Helper()
+	var recs []canlog.Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("could not read record: %+v", err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	t0 := time.Unix(1660000000, 123456000)
+	recs := []canlog.Record{
+		{
+			Time: t0, Iface: "can0",
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		},
+		{
+			Time: t0.Add(1234 * time.Microsecond), Iface: "can1", Flags: canlog.Tx,
+			Frame: canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+		{
+			Time: t0.Add(2 * time.Millisecond), Iface: "can0",
+			Frame: canbus.Frame{ID: 0x7ff, Kind: canbus.SFF, Data: []byte{}},
+		},
+		{
+			Time: t0.Add(3456 * time.Microsecond), Iface: "can0", Flags: canlog.FD,
+			Frame: canbus.Frame{
+				ID: 0x321, Kind: canbus.SFF,
+				Data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	w := gvret.NewWriter(&buf)
+	for _, rec := range recs {
+		err := w.Write(rec)
+		if err != nil {
+			t.Fatalf("could not write record: %+v", err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("could not close writer: %+v", err)
+	}
+
+	want, err := os.ReadFile("testdata/example.csv")
+	if err != nil {
+		t.Fatalf("could not read golden file: %+v", err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("invalid GVRET output:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got := readAll(t, gvret.NewReader(&buf))
+	for i := range recs {
+		switch recs[i].Iface {
+		case "can0":
+			recs[i].Iface = "1"
+		case "can1":
+			recs[i].Iface = "2"
+		}
+	}
+	if !reflect.DeepEqual(got, recs) {
+		t.Fatalf("invalid records:\ngot= %+v\nwant=%+v", got, recs)
+	}
+}
+
+func TestRead(t *testing.T) {
+	const src = `Time Stamp,ID,Extended,Bus,LEN,D1,D2,D3,D4,D5,D6,D7,D8
+1500000,0x0000021A,False,2,3,FE,36,12,
+1500010,1FFFFFFF,true,0,0,
+
+2000000,00000001,false,0,8,1,2,3,4,5,6,7,8,
+`
+	want := []canlog.Record{
+		{
+			Time: time.Unix(1, 500000000), Iface: "3",
+			Frame: canbus.Frame{ID: 0x21a, Kind: canbus.SFF, Data: []byte{0xfe, 0x36, 0x12}},
+		},
+		{
+			Time: time.Unix(1, 500010000), Iface: "1",
+			Frame: canbus.Frame{ID: 0x1fffffff, Kind: canbus.EFF, Data: []byte{}},
+		},
+		{
+			Time: time.Unix(2, 0), Iface: "1",
+			Frame: canbus.Frame{ID: 1, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+	}
+	got := readAll(t, gvret.NewReader(strings.NewReader(src)))
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid records:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"header", "Time Stamp,ID,Bus,LEN,D1", `gvret: line 1: missing column "Extended"`},
+		{"columns", "1,2,3", `gvret: line 1: missing columns`},
+		{"time", "1.5,001,false,Rx,0,0,", `gvret: line 1: invalid timestamp "1.5"`},
+		{"id", "1,XYZ,false,Rx,0,0,", `gvret: line 1: invalid identifier "XYZ"`},
+		{"sff", "1,800,false,Rx,0,0,", `gvret: line 1: invalid identifier "800"`},
+		{"ext", "1,001,maybe,Rx,0,0,", `gvret: line 1: invalid extended flag "maybe"`},
+		{"dir", "1,001,false,Up,0,0,", `gvret: line 1: invalid direction "Up"`},
+		{"bus", "1,001,false,Rx,-1,0,", `gvret: line 1: invalid bus "-1"`},
+		{"len", "1,001,false,Rx,0,10,", `gvret: line 1: invalid data length "10"`},
+		{"data", "1,001,false,Rx,0,2,11,", `gvret: line 1: missing data bytes`},
+		{"byte", "1,001,false,Rx,0,1,XYZ,", `gvret: line 1: invalid data byte "XYZ"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := gvret.NewReader(strings.NewReader(tc.src)).Read()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	for _, tc := range []struct {
+		name string
+		rec  canlog.Record
+		err  string
+	}{
+		{
+			name: "kind",
+			rec:  canlog.Record{Time: t0, Frame: canbus.Frame{ID: 1, Kind: canbus.RTR}},
+			err:  "gvret: unsupported frame kind RTR",
+		},
+		{
+			name: "id",
+			rec:  canlog.Record{Time: t0, Frame: canbus.Frame{ID: 0x800}},
+			err:  "gvret: invalid frame identifier 0x800",
+		},
+		{
+			name: "length",
+			rec:  canlog.Record{Time: t0, Frame: canbus.Frame{ID: 1, Data: make([]byte, 9)}},
+			err:  "gvret: invalid frame length 9",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := gvret.NewWriter(io.Discard).Write(tc.rec)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
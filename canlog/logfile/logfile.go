@@ -0,0 +1,350 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package logfile opens and creates CAN bus log files in any of the
+// formats handled by the sub-packages of canlog.
+//
+// The format of existing log files is detected from their content, and
+// the format of new log files from their extension:
+//
+//	r, err := logfile.Open("trace.blf")
+//	if err != nil {
+//	    return err
+//	}
+//	defer r.Close()
+//
+//	w, err := logfile.Create("trace.mf4", logfile.Unknown)
+//	if err != nil {
+//	    return err
+//	}
+//	defer w.Close()
+package logfile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/asc"
+	"github.com/go-daq/canbus/canlog/blf"
+	"github.com/go-daq/canbus/canlog/candump"
+	"github.com/go-daq/canbus/canlog/gvret"
+	"github.com/go-daq/canbus/canlog/mdf"
+	"github.com/go-daq/canbus/canlog/pcap"
+	"github.com/go-daq/canbus/canlog/pcapng"
+	"github.com/go-daq/canbus/canlog/trc"
+)
+
+// Format is a log file format.
+type Format uint8
+
+const (
+	Unknown Format = iota
+	Candump        // candump log files
+	ASC            // Vector ASCII log files
+	BLF            // Vector binary log files
+	PCAP           // pcap capture files
+	PCAPNG         // pcapng capture files
+	MDF            // ASAM MDF 4.x measurement files
+	TRC            // PEAK-System trace files
+	GVRET          // SavvyCAN GVRET CSV files
+)
+
+var formats = [...]struct {
+	name string
+	exts []string
+}{
+	Unknown: {"unknown", nil},
+	Candump: {"candump", []string{".log"}},
+	ASC:     {"asc", []string{".asc"}},
+	BLF:     {"blf", []string{".blf"}},
+	PCAP:    {"pcap", []string{".pcap"}},
+	PCAPNG:  {"pcapng", []string{".pcapng"}},
+	MDF:     {"mf4", []string{".mf4", ".mdf"}},
+	TRC:     {"trc", []string{".trc"}},
+	GVRET:   {"csv", []string{".csv"}},
+}
+
+func (f Format) String() string {
+	if int(f) < len(formats) {
+		return formats[f].name
+	}
+	return fmt.Sprintf("Format(%d)", uint8(f))
+}
+
+// Formats returns the known log file formats.
+func Formats() []Format {
+	fmts := make([]Format, 0, len(formats)-1)
+	for f := range formats[1:] {
+		fmts = append(fmts, Format(f+1))
+	}
+	return fmts
+}
+
+// ParseFormat returns the log file format with the provided name, as
+// returned by Format.String.
+func ParseFormat(name string) (Format, error) {
+	for f, v := range formats[1:] {
+		if strings.EqualFold(name, v.name) {
+			return Format(f + 1), nil
+		}
+	}
+	return Unknown, fmt.Errorf("logfile: unknown format %q", name)
+}
+
+// FormatOf returns the log file format associated with the extension
+// of the named file, or Unknown.
+func FormatOf(name string) Format {
+	ext := strings.ToLower(filepath.Ext(name))
+	for f, v := range formats {
+		for _, e := range v.exts {
+			if ext == e {
+				return Format(f)
+			}
+		}
+	}
+	return Unknown
+}
+
+// Detect returns the log file format of the log starting with the
+// provided bytes, or Unknown.
+//
+// Binary formats are detected from their magic number, text formats
+// from their first line.
+func Detect(head []byte) Format {
+	switch {
+	case bytes.HasPrefix(head, []byte("LOGG")):
+		return BLF
+	case bytes.HasPrefix(head, []byte("MDF     ")),
+		bytes.HasPrefix(head, []byte("UnFinMF ")):
+		return MDF
+	case bytes.HasPrefix(head, []byte{0x0a, 0x0d, 0x0d, 0x0a}):
+		return PCAPNG
+	}
+	for _, magic := range [][]byte{
+		{0xa1, 0xb2, 0xc3, 0xd4},
+		{0xd4, 0xc3, 0xb2, 0xa1},
+		{0xa1, 0xb2, 0x3c, 0x4d},
+		{0x4d, 0x3c, 0xb2, 0xa1},
+	} {
+		if bytes.HasPrefix(head, magic) {
+			return PCAP
+		}
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(head))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "("):
+			return Candump
+		case strings.HasPrefix(line, ";"):
+			return TRC
+		case strings.HasPrefix(line, "//"):
+			return ASC
+		}
+		toks := strings.Fields(line)
+		switch strings.ToLower(toks[0]) {
+		case "date", "base", "begin", "internal", "no":
+			return ASC
+		}
+		if strings.HasPrefix(strings.ToLower(line), "time stamp,") {
+			return GVRET
+		}
+		if i := strings.Index(line, ","); i > 0 && strings.Trim(line[:i], "0123456789") == "" {
+			return GVRET
+		}
+		return Unknown
+	}
+	return Unknown
+}
+
+// NewReader returns a new reader of log files in the provided format,
+// reading from r.
+// MDF files are read through an io.ReaderAt.
+func NewReader(f Format, r io.Reader) (canlog.Reader, error) {
+	switch f {
+	case Candump:
+		return candump.NewReader(r), nil
+	case ASC:
+		return asc.NewReader(r), nil
+	case BLF:
+		return blf.NewReader(r), nil
+	case PCAP:
+		return pcap.NewReader(r), nil
+	case PCAPNG:
+		return pcapng.NewReader(r), nil
+	case MDF:
+		ra, ok := r.(io.ReaderAt)
+		if !ok {
+			return nil, fmt.Errorf("logfile: %v files require an io.ReaderAt", f)
+		}
+		return mdf.NewReader(ra), nil
+	case TRC:
+		return trc.NewReader(r), nil
+	case GVRET:
+		return gvret.NewReader(r), nil
+	}
+	return nil, fmt.Errorf("logfile: invalid format %v", f)
+}
+
+// NewWriter returns a new writer of log files in the provided format,
+// writing to w.
+// MDF files are written through an io.WriteSeeker, that must be able
+// to seek: *os.File writing to pipes or terminals is rejected.
+func NewWriter(f Format, w io.Writer) (canlog.Writer, error) {
+	switch f {
+	case Candump:
+		return candump.NewWriter(w), nil
+	case ASC:
+		return asc.NewWriter(w), nil
+	case BLF:
+		return blf.NewWriter(w), nil
+	case PCAP:
+		return pcap.NewWriter(w), nil
+	case PCAPNG:
+		return pcapng.NewWriter(w), nil
+	case MDF:
+		ws, ok := w.(io.WriteSeeker)
+		if !ok {
+			return nil, fmt.Errorf("logfile: %v files require an io.WriteSeeker", f)
+		}
+		_, err := ws.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, fmt.Errorf("logfile: %v files require a seekable writer: %w", f, err)
+		}
+		return mdf.NewWriter(ws), nil
+	case TRC:
+		return trc.NewWriter(w), nil
+	case GVRET:
+		return gvret.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("logfile: invalid format %v", f)
+}
+
+// Reader reads the records of a log file.
+type Reader struct {
+	r canlog.Reader
+	f *os.File
+
+	// Format is the detected format of the log file.
+	Format Format
+}
+
+// Open opens the named log file for reading, detecting its format from
+// its content.
+func Open(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("logfile: could not open log file: %w", err)
+	}
+
+	head := make([]byte, 4096)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, fmt.Errorf("logfile: could not read log file %q: %w", name, err)
+	}
+	format := Detect(head[:n])
+	if format == Unknown {
+		f.Close()
+		return nil, fmt.Errorf("logfile: could not detect format of log file %q", name)
+	}
+
+	r, err := NewReader(format, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Reader{r: r, f: f, Format: format}, nil
+}
+
+// Read returns the next record of the log file, or io.EOF at the end
+// of the log.
+func (r *Reader) Read() (canlog.Record, error) {
+	return r.r.Read()
+}
+
+// Close closes the log file.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+// Writer writes records to a log file.
+type Writer struct {
+	w canlog.Writer
+	f *os.File
+
+	// Format is the format of the log file.
+	Format Format
+}
+
+// Create creates the named log file in the provided format.
+// The format of log files created with the Unknown format is derived
+// from their extension.
+func Create(name string, format Format) (*Writer, error) {
+	if format == Unknown {
+		format = FormatOf(name)
+		if format == Unknown {
+			return nil, fmt.Errorf("logfile: unknown format for log file %q", name)
+		}
+	}
+	if int(format) >= len(formats) {
+		return nil, fmt.Errorf("logfile: invalid format %v", format)
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("logfile: could not create log file: %w", err)
+	}
+	w, err := NewWriter(format, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Writer{w: w, f: f, Format: format}, nil
+}
+
+// Write writes the record to the log file.
+func (w *Writer) Write(rec canlog.Record) error {
+	return w.w.Write(rec)
+}
+
+// Flush writes the buffered records to the log file, and commits it to
+// stable storage, so the records written so far can be read back even
+// if the log is never finalized.
+func (w *Writer) Flush() error {
+	if f, ok := w.w.(canlog.Flusher); ok {
+		err := f.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	err := w.f.Sync()
+	if err != nil {
+		return fmt.Errorf("logfile: could not sync log file: %w", err)
+	}
+	return nil
+}
+
+// Close finalizes the log, and closes the log file.
+func (w *Writer) Close() error {
+	err := w.w.Close()
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	err = w.f.Close()
+	if err != nil {
+		return fmt.Errorf("logfile: could not close log file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logfile_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/logfile"
+)
+
+func TestFormat(t *testing.T) {
+	for _, f := range logfile.Formats() {
+		got, err := logfile.ParseFormat(f.String())
+		if err != nil {
+			t.Fatalf("could not parse format %v: %+v", f, err)
+		}
+		if got != f {
+			t.Fatalf("invalid format: got=%v, want=%v", got, f)
+		}
+	}
+	_, err := logfile.ParseFormat("xls")
+	if got, want := err.Error(), `logfile: unknown format "xls"`; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		want logfile.Format
+	}{
+		{"dump.log", logfile.Candump},
+		{"dir/trace.ASC", logfile.ASC},
+		{"trace.blf", logfile.BLF},
+		{"capture.pcap", logfile.PCAP},
+		{"capture.pcapng", logfile.PCAPNG},
+		{"measure.mf4", logfile.MDF},
+		{"measure.mdf", logfile.MDF},
+		{"trace.trc", logfile.TRC},
+		{"savvy.csv", logfile.GVRET},
+		{"trace.txt", logfile.Unknown},
+		{"trace", logfile.Unknown},
+	} {
+		if got := logfile.FormatOf(tc.name); got != tc.want {
+			t.Fatalf("invalid format of %q: got=%v, want=%v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, tc := range []struct {
+		head string
+		want logfile.Format
+	}{
+		{"", logfile.Unknown},
+		{"LOGG\x90\x00\x00\x00", logfile.BLF},
+		{"MDF     4.10    ", logfile.MDF},
+		{"UnFinMF 4.10    ", logfile.MDF},
+		{"\x0a\x0d\x0d\x0a\x1c\x00\x00\x00", logfile.PCAPNG},
+		{"\xd4\xc3\xb2\xa1\x02\x00", logfile.PCAP},
+		{"\xa1\xb2\x3c\x4d\x00\x02", logfile.PCAP},
+		{"(1660000000.123456) vcan0 123#DEADBEEF\n", logfile.Candump},
+		{"\n# comment\n(1660000000.123456) vcan0 123#R\n", logfile.Candump},
+		{"date Mon Aug 08 11:06:40.000 pm 2022\nbase hex  timestamps absolute\n", logfile.ASC},
+		{"// version 9.0.0\n", logfile.ASC},
+		{";$FILEVERSION=2.1\n", logfile.TRC},
+		{"Time Stamp,ID,Extended,Dir,Bus,LEN,D1,D2,D3,D4,D5,D6,D7,D8\n", logfile.GVRET},
+		{"1500000,0000021A,false,Rx,0,0,\n", logfile.GVRET},
+		{"hello, world\n", logfile.Unknown},
+	} {
+		if got := logfile.Detect([]byte(tc.head)); got != tc.want {
+			t.Fatalf("invalid format of %q: got=%v, want=%v", tc.head, got, tc.want)
+		}
+	}
+}
+
+func TestCreateOpen(t *testing.T) {
+	t0 := time.Date(2022, time.August, 8, 23, 6, 40, 0, time.Local)
+	recs := []canlog.Record{
+		{
+			Time: t0, Iface: "1",
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		},
+		{
+			Time: t0.Add(1234 * time.Microsecond), Iface: "1",
+			Frame: canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+	}
+	dir := t.TempDir()
+	for _, format := range logfile.Formats() {
+		t.Run(format.String(), func(t *testing.T) {
+			fname := filepath.Join(dir, "test."+format.String())
+			w, err := logfile.Create(fname, format)
+			if err != nil {
+				t.Fatalf("could not create log file: %+v", err)
+			}
+			for _, rec := range recs {
+				err = w.Write(rec)
+				if err != nil {
+					t.Fatalf("could not write record: %+v", err)
+				}
+			}
+
+			// flushed records can be read back before the log file is
+			// finalized.
+			err = w.Flush()
+			if err != nil {
+				t.Fatalf("could not flush log file: %+v", err)
+			}
+			got := readFile(t, fname, format)
+			if len(got) != len(recs) {
+				t.Fatalf("invalid number of flushed records: got=%d, want=%d", len(got), len(recs))
+			}
+
+			err = w.Close()
+			if err != nil {
+				t.Fatalf("could not close log file: %+v", err)
+			}
+
+			got = readFile(t, fname, format)
+			if len(got) != len(recs) {
+				t.Fatalf("invalid number of records: got=%d, want=%d", len(got), len(recs))
+			}
+			for i := range got {
+				if !got[i].Time.Equal(recs[i].Time) {
+					t.Fatalf("invalid time for record %d: got=%v, want=%v", i, got[i].Time, recs[i].Time)
+				}
+				if format == logfile.PCAP {
+					// pcap captures do not store interfaces.
+					got[i].Iface = recs[i].Iface
+				}
+				got[i].Time = recs[i].Time
+				if !reflect.DeepEqual(got[i], recs[i]) {
+					t.Fatalf("invalid record %d:\ngot= %+v\nwant=%+v", i, got[i], recs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "test.txt")
+	err := os.WriteFile(fname, []byte("hello\n"), 0644)
+	if err != nil {
+		t.Fatalf("could not write file: %+v", err)
+	}
+
+	_, err = logfile.Open(fname)
+	if got, want := err.Error(), "logfile: could not detect format of log file \""+fname+"\""; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	_, err = logfile.Create(fname, logfile.Unknown)
+	if got, want := err.Error(), "logfile: unknown format for log file \""+fname+"\""; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	_, err = logfile.NewWriter(logfile.MDF, io.Discard)
+	if got, want := err.Error(), "logfile: mf4 files require an io.WriteSeeker"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("could not create pipe: %+v", err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+	_, err = logfile.NewWriter(logfile.MDF, pw)
+	if got, want := err, syscall.ESPIPE; !errors.Is(got, want) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", got, want)
+	}
+}
+
+func readFile(t *testing.T, fname string, format logfile.Format) []canlog.Record {
+	t.Helper()
+	r, err := logfile.Open(fname)
+	if err != nil {
+		t.Fatalf("could not open log file: %+v", err)
+	}
+	defer r.Close()
+	if r.Format != format {
+		t.Fatalf("invalid format: got=%v, want=%v", r.Format, format)
+	}
+	var recs []canlog.Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("could not read record: %+v", err)
+		}
+		recs = append(recs, rec)
+	}
+}
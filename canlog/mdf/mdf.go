@@ -0,0 +1,160 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mdf reads and writes ASAM MDF 4.x (MF4) measurement files
+// holding CAN bus logging data, as described by the ASAM MDF bus
+// logging convention.
+//
+// Bus logging files store the frames of the bus in channel groups
+// named after the bus events they hold (CAN_DataFrame, CAN_RemoteFrame
+// and CAN_ErrorFrame), whose channels describe the fields of the frames
+// (CAN_DataFrame.ID, CAN_DataFrame.DataBytes, ...).
+//
+// The reader handles sorted and unsorted data groups, data stored in
+// DT, DZ (deflate and transposed deflate), DL and HL blocks, and data
+// bytes stored in fixed-length channels or in variable-length signal
+// data. The records of the data groups are merged by time.
+//
+// The writer produces a single unsorted data group with a channel group
+// for each bus event, and stores its records in DZ blocks.
+// Error frames are written with the identifier (error class) and the
+// data of their SocketCAN representation.
+//
+// Records read from MDF files are named after their bus channel number
+// ("1", "2", ...), and written with the channel numbers assigned by a
+// canlog.Channels.
+//
+// MDF files are made of blocks linked by their file offsets: the reader
+// requires an io.ReaderAt, and the writer an io.WriteSeeker, so it can
+// update the links and the statistics of the file once all the records
+// have been written.
+package mdf
+
+// block identifiers
+const (
+	idHD = "##HD"
+	idFH = "##FH"
+	idDG = "##DG"
+	idCG = "##CG"
+	idCN = "##CN"
+	idCC = "##CC"
+	idSI = "##SI"
+	idTX = "##TX"
+	idMD = "##MD"
+	idDT = "##DT"
+	idSD = "##SD"
+	idDZ = "##DZ"
+	idDL = "##DL"
+	idHL = "##HL"
+)
+
+const (
+	idBlockSize  = 64 // size of the file identification block
+	headerSize   = 24 // size of the common header of the blocks
+	dzHeaderSize = 24 // size of the header of the DZ block data
+
+	// maxBlockSize is the maximum size of the blocks loaded in memory by
+	// the reader.
+	maxBlockSize = 64 * 1024 * 1024
+)
+
+// channel types
+const (
+	cnFixed  = 0 // fixed length data channel
+	cnVLSD   = 1 // variable length signal data channel
+	cnMaster = 2 // master channel
+)
+
+// channel data types
+const (
+	dtUintLE    = 0
+	dtUintBE    = 1
+	dtIntLE     = 2
+	dtIntBE     = 3
+	dtFloatLE   = 4
+	dtFloatBE   = 5
+	dtByteArray = 10
+)
+
+const (
+	syncTime = 1 // cn_sync_type: time master channel
+
+	cgVLSD     = 0x0001 // cg_flags: variable length signal data channel group
+	cgBusEvent = 0x0002 // cg_flags: bus event channel group
+	cgPlainBus = 0x0004 // cg_flags: plain bus event channel group
+
+	cnBusEvent = 0x0400 // cn_flags: bus event channel
+
+	ccIdentity = 0 // cc_type: 1:1 conversion
+	ccLinear   = 1 // cc_type: linear conversion
+
+	siBus    = 2 // si_type: bus
+	siBusCAN = 2 // si_bus_type: CAN
+
+	zipDeflate    = 0 // dz_zip_type: deflate
+	zipTransposed = 1 // dz_zip_type: transposition and deflate
+)
+
+// events are the bus events of the CAN bus logging convention.
+var events = [...]string{
+	dataFrame:   "CAN_DataFrame",
+	remoteFrame: "CAN_RemoteFrame",
+	errorFrame:  "CAN_ErrorFrame",
+}
+
+const (
+	dataFrame = iota
+	remoteFrame
+	errorFrame
+)
+
+// field describes a channel of the records of a bus event.
+type field struct {
+	name string
+	off  int // byte offset in the record, after the record ID
+	bit  int // bit offset
+	bits int // number of bits
+	typ  uint8
+}
+
+// layouts describe the records written for each bus event, after their
+// timestamp.
+var layouts = [...][]field{
+	dataFrame: {
+		{"BusChannel", 8, 0, 8, dtUintLE},
+		{"ID", 9, 0, 29, dtUintLE},
+		{"IDE", 12, 7, 1, dtUintLE},
+		{"DLC", 13, 0, 4, dtUintLE},
+		{"Dir", 13, 4, 1, dtUintLE},
+		{"EDL", 13, 5, 1, dtUintLE},
+		{"BRS", 13, 6, 1, dtUintLE},
+		{"ESI", 13, 7, 1, dtUintLE},
+		{"DataLength", 14, 0, 8, dtUintLE},
+		{"DataBytes", 15, 0, 64 * 8, dtByteArray},
+	},
+	remoteFrame: {
+		{"BusChannel", 8, 0, 8, dtUintLE},
+		{"ID", 9, 0, 29, dtUintLE},
+		{"IDE", 12, 7, 1, dtUintLE},
+		{"DLC", 13, 0, 4, dtUintLE},
+		{"Dir", 13, 4, 1, dtUintLE},
+		{"DataLength", 14, 0, 8, dtUintLE},
+	},
+	errorFrame: {
+		{"BusChannel", 8, 0, 8, dtUintLE},
+		{"ID", 9, 0, 29, dtUintLE},
+		{"IDE", 12, 7, 1, dtUintLE},
+		{"DLC", 13, 0, 4, dtUintLE},
+		{"Dir", 13, 4, 1, dtUintLE},
+		{"DataLength", 14, 0, 8, dtUintLE},
+		{"DataBytes", 15, 0, 8 * 8, dtByteArray},
+	},
+}
+
+// recordSize returns the size of the records written for a bus event,
+// after their record ID.
+func recordSize(event int) int {
+	last := layouts[event][len(layouts[event])-1]
+	return last.off + (last.bit+last.bits+7)/8
+}
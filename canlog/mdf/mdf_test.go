@@ -0,0 +1,549 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mdf_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/mdf"
+)
+
+func readAll(t *testing.T, r canlog.Reader) []canlog.Record {
+	t.Helper()
+	var recs []canlog.Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("could not read record %d: %+v", len(recs), err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func compare(t *testing.T, got, want []canlog.Record) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("invalid number of records: got=%d, want=%d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Time.Equal(want[i].Time) {
+			t.Fatalf("invalid time for record %d: got=%v, want=%v", i, got[i].Time, want[i].Time)
+		}
+		got[i].Time = want[i].Time
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Fatalf("invalid record %d:\ngot= %+v\nwant=%+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	t0 := time.Unix(1660000000, 123456789)
+	recs := []canlog.Record{
+		{
+			Time: t0, Iface: "can0",
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		},
+		{
+			Time: t0.Add(1234 * time.Nanosecond), Iface: "can1", Flags: canlog.Tx,
+			Frame: canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+		{
+			Time: t0.Add(2 * time.Millisecond), Iface: "can0",
+			Frame: canbus.Frame{ID: 0x7ff, Kind: canbus.SFF, Data: []byte{}},
+		},
+		{
+			Time: t0.Add(2345 * time.Microsecond), Iface: "can1", Flags: canlog.Ext,
+			Frame: canbus.Frame{ID: 0x100, Kind: canbus.RTR, Data: make([]byte, 8)},
+		},
+		{
+			Time: t0.Add(3456 * time.Microsecond), Iface: "can0", Flags: canlog.FD | canlog.BRS,
+			Frame: canbus.Frame{
+				ID: 0x321, Kind: canbus.SFF,
+				Data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc},
+			},
+		},
+		{
+			Time: t0.Add(3500 * time.Microsecond), Iface: "can1", Flags: canlog.FD | canlog.ESI | canlog.Tx,
+			Frame: canbus.Frame{ID: 0xabcd, Kind: canbus.EFF, Data: []byte{}},
+		},
+		{
+			Time: t0.Add(12*time.Second + 4567*time.Microsecond), Iface: "can0",
+			Frame: canbus.Frame{ID: 0x004, Kind: canbus.ERR, Data: []byte{0, 0, 0x80, 0, 0, 0, 0, 0}},
+		},
+	}
+	// span several data blocks.
+	for i := 0; i < 30000; i++ {
+		recs = append(recs, canlog.Record{
+			Time: t0.Add(13*time.Second + time.Duration(i)*time.Millisecond), Iface: "can1",
+			Frame: canbus.Frame{ID: uint32(i % 0x800), Kind: canbus.SFF, Data: []byte{byte(i), byte(i >> 8)}},
+		})
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.mf4"))
+	if err != nil {
+		t.Fatalf("could not create file: %+v", err)
+	}
+	defer f.Close()
+
+	w := mdf.NewWriter(f)
+	for _, rec := range recs {
+		err := w.Write(rec)
+		if err != nil {
+			t.Fatalf("could not write record: %+v", err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("could not close writer: %+v", err)
+	}
+
+	var id [64]byte
+	_, err = f.ReadAt(id[:], 0)
+	if err != nil {
+		t.Fatalf("could not read file identification: %+v", err)
+	}
+	if got, want := string(id[:8]), "MDF     "; got != want {
+		t.Fatalf("invalid file identifier: got=%q, want=%q", got, want)
+	}
+
+	for i := range recs {
+		switch recs[i].Iface {
+		case "can0":
+			recs[i].Iface = "1"
+		case "can1":
+			recs[i].Iface = "2"
+		}
+	}
+	r := mdf.NewReader(f)
+	got := readAll(t, r)
+	if got, want := r.Start(), t0; !got.Equal(want) {
+		t.Fatalf("invalid start time: got=%v, want=%v", got, want)
+	}
+	compare(t, got, recs)
+}
+
+func TestFlush(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	var recs []canlog.Record
+	for i := 0; i < 100000; i++ {
+		recs = append(recs, canlog.Record{
+			Time: t0.Add(time.Duration(i) * time.Millisecond), Iface: "1",
+			Frame: canbus.Frame{ID: uint32(i % 0x800), Kind: canbus.SFF, Data: []byte{byte(i), byte(i >> 8)}},
+		})
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.mf4"))
+	if err != nil {
+		t.Fatalf("could not create file: %+v", err)
+	}
+	defer f.Close()
+
+	w := mdf.NewWriter(f)
+	err = w.Flush()
+	if err != nil {
+		t.Fatalf("could not flush empty writer: %+v", err)
+	}
+
+	// each flush chains a data list to the file, and the records
+	// flushed so far can be read from the unfinalized file.
+	done := 0
+	for _, n := range []int{1, 10, 60000, 60001, len(recs)} {
+		for _, rec := range recs[done:n] {
+			err := w.Write(rec)
+			if err != nil {
+				t.Fatalf("could not write record: %+v", err)
+			}
+		}
+		done = n
+		err = w.Flush()
+		if err != nil {
+			t.Fatalf("could not flush writer: %+v", err)
+		}
+		var id [8]byte
+		_, err = f.ReadAt(id[:], 0)
+		if err != nil {
+			t.Fatalf("could not read file identification: %+v", err)
+		}
+		if got, want := string(id[:]), "UnFinMF "; got != want {
+			t.Fatalf("invalid file identifier: got=%q, want=%q", got, want)
+		}
+		compare(t, readAll(t, mdf.NewReader(f)), recs[:n])
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("could not close writer: %+v", err)
+	}
+	compare(t, readAll(t, mdf.NewReader(f)), recs)
+}
+
+// builder builds MDF files block by block.
+type builder struct {
+	bytes.Buffer
+}
+
+func newBuilder() *builder {
+	b := new(builder)
+	var id [64]byte
+	copy(id[:], "MDF     4.20    test")
+	binary.LittleEndian.PutUint16(id[28:], 420)
+	b.Write(id[:])
+	return b
+}
+
+// block appends a block, and returns its offset.
+func (b *builder) block(id string, links []uint64, data []byte) uint64 {
+	off := uint64(b.Len())
+	for len(data)%8 != 0 {
+		data = append(data, 0)
+	}
+	var hdr [24]byte
+	copy(hdr[:], id)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(24+8*len(links)+len(data)))
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(len(links)))
+	b.Write(hdr[:])
+	for _, link := range links {
+		binary.Write(b, binary.LittleEndian, link)
+	}
+	b.Write(data)
+	return off
+}
+
+// link sets the i-th link of the block at off.
+func (b *builder) link(off uint64, i int, v uint64) {
+	binary.LittleEndian.PutUint64(b.Bytes()[off+24+8*uint64(i):], v)
+}
+
+// channel appends a CN block, and returns its offset.
+func (b *builder) channel(next, comp uint64, name string, typ, sync, dtype uint8, off, bits int, cc, data uint64) uint64 {
+	tx := b.block("##TX", nil, append([]byte(name), 0))
+	p := make([]byte, 72)
+	p[0] = typ
+	p[1] = sync
+	p[2] = dtype
+	p[3] = uint8(off % 8)
+	binary.LittleEndian.PutUint32(p[4:], uint32(off/8))
+	binary.LittleEndian.PutUint32(p[8:], uint32(bits))
+	return b.block("##CN", []uint64{next, comp, tx, 0, cc, data, 0, 0}, p)
+}
+
+// group appends a CG block, and returns its offset.
+func (b *builder) group(next, cn uint64, id uint64, flags uint16, size int) uint64 {
+	p := make([]byte, 32)
+	binary.LittleEndian.PutUint64(p[0:], id)
+	binary.LittleEndian.PutUint16(p[16:], flags)
+	binary.LittleEndian.PutUint32(p[24:], uint32(size))
+	return b.block("##CG", []uint64{next, cn, 0, 0, 0, 0}, p)
+}
+
+func deflate(p []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(p)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	b := newBuilder()
+	hdData := make([]byte, 32)
+	binary.LittleEndian.PutUint64(hdData, uint64(t0.UnixNano()))
+	hd := b.block("##HD", make([]uint64, 6), hdData)
+
+	// sorted data group, with a float64 time master channel, and data
+	// bytes stored in a signal data block.
+	// record: t(f64) bus(u8) id(u32, IDE in bit 31) dlc(u8) offset(u64)
+	sd := new(bytes.Buffer)
+	var recs1 bytes.Buffer
+	for i, v := range []struct {
+		t    float64
+		id   uint32
+		data []byte
+	}{
+		{0.5, 0x123, []byte{1, 2, 3}},
+		{1.5, 0x80000042, []byte{4, 5, 6, 7, 8, 9, 10, 11}},
+	} {
+		binary.Write(&recs1, binary.LittleEndian, math.Float64bits(v.t))
+		recs1.WriteByte(byte(i + 1))
+		binary.Write(&recs1, binary.LittleEndian, v.id)
+		recs1.WriteByte(byte(len(v.data)))
+		binary.Write(&recs1, binary.LittleEndian, uint64(sd.Len()))
+		binary.Write(sd, binary.LittleEndian, uint32(len(v.data)))
+		sd.Write(v.data)
+	}
+	sdBlock := b.block("##SD", nil, sd.Bytes())
+	var (
+		dataBytes = b.channel(0, 0, "CAN_DataFrame.DataBytes", 1, 0, 10, 14*8, 64, 0, sdBlock)
+		dlc       = b.channel(dataBytes, 0, "CAN_DataFrame.DLC", 0, 0, 0, 13*8, 4, 0, 0)
+		id        = b.channel(dlc, 0, "CAN_DataFrame.ID", 0, 0, 0, 9*8, 32, 0, 0)
+		bus       = b.channel(id, 0, "CAN_DataFrame.BusChannel", 0, 0, 0, 8*8, 8, 0, 0)
+		frame     = b.channel(0, bus, "CAN_DataFrame", 0, 0, 10, 8*8, 14*8, 0, 0)
+		master    = b.channel(frame, 0, "t", 2, 1, 4, 0, 64, 0, 0)
+		cg1       = b.group(0, master, 0, 0x2, 22)
+	)
+	// transposed DZ block, in a data list.
+	dz := make([]byte, 24)
+	copy(dz, "DT")
+	dz[2] = 1
+	binary.LittleEndian.PutUint32(dz[4:], 22)
+	binary.LittleEndian.PutUint64(dz[8:], uint64(recs1.Len()))
+	{
+		raw := recs1.Bytes()
+		rows := len(raw) / 22
+		tr := make([]byte, len(raw))
+		for r := 0; r < rows; r++ {
+			for c := 0; c < 22; c++ {
+				tr[c*rows+r] = raw[r*22+c]
+			}
+		}
+		z := deflate(tr)
+		binary.LittleEndian.PutUint64(dz[16:], uint64(len(z)))
+		dz = append(dz, z...)
+	}
+	dzBlock := b.block("##DZ", nil, dz)
+	dlData := make([]byte, 16)
+	binary.LittleEndian.PutUint32(dlData[4:], 1)
+	dl := b.block("##DL", []uint64{0, dzBlock}, dlData)
+	dg1 := b.block("##DG", []uint64{0, cg1, dl, 0}, make([]byte, 8))
+
+	// unsorted data group, with an integer time master channel in µs,
+	// data bytes stored in a VLSD channel group, remote frames, and an
+	// unrelated channel group.
+	ccData := make([]byte, 40)
+	ccData[0] = 1
+	binary.LittleEndian.PutUint64(ccData[32:], math.Float64bits(1e-6))
+	cc := b.block("##CC", make([]uint64, 4), ccData)
+	var (
+		vlsd = b.group(0, 0, 2, 0x1, 0)
+
+		rtrDLC  = b.channel(0, 0, "CAN1.CAN_RemoteFrame.DLC", 0, 0, 0, 8*8, 4, 0, 0)
+		rtrDir  = b.channel(rtrDLC, 0, "CAN1.CAN_RemoteFrame.Dir", 0, 0, 0, 8*8+4, 1, 0, 0)
+		rtrIDE  = b.channel(rtrDir, 0, "CAN1.CAN_RemoteFrame.IDE", 0, 0, 0, 7*8+7, 1, 0, 0)
+		rtrID   = b.channel(rtrIDE, 0, "CAN1.CAN_RemoteFrame.ID", 0, 0, 0, 4*8, 29, 0, 0)
+		rtrTime = b.channel(rtrID, 0, "time", 2, 1, 0, 0, 32, cc, 0)
+		cg3     = b.group(vlsd, rtrTime, 3, 0x2, 9)
+
+		dfBytes = b.channel(0, 0, "CAN_DataFrame.DataBytes", 1, 0, 10, 4*8, 64, 0, vlsd)
+		dfID    = b.channel(dfBytes, 0, "CAN_DataFrame.ID", 0, 0, 0, 12*8, 32, 0, 0)
+		dfDLC   = b.channel(dfID, 0, "CAN_DataFrame.DLC", 0, 0, 0, 16*8, 4, 0, 0)
+		dfEDL   = b.channel(dfDLC, 0, "CAN_DataFrame.EDL", 0, 0, 0, 16*8+4, 1, 0, 0)
+		dfTime  = b.channel(dfEDL, 0, "time", 2, 1, 0, 0, 32, cc, 0)
+		cg1b    = b.group(cg3, dfTime, 1, 0x2, 17)
+
+		speed = b.channel(0, 0, "Speed", 0, 0, 0, 0, 16, 0, 0)
+		cg4   = b.group(cg1b, speed, 4, 0, 2)
+	)
+	var recs2 bytes.Buffer
+	u32 := func(v uint32) { binary.Write(&recs2, binary.LittleEndian, v) }
+	u64 := func(v uint64) { binary.Write(&recs2, binary.LittleEndian, v) }
+	recs2.Write([]byte{4, 0x34, 0x12})
+	// VLSD record, then the CAN FD frame referencing it.
+	recs2.WriteByte(2)
+	u32(12)
+	recs2.Write([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	recs2.WriteByte(1)
+	u32(1000000)
+	u64(0)
+	u32(0x321)
+	recs2.WriteByte(9 | 1<<4)
+	// remote frame, with an extended identifier, transmitted.
+	recs2.WriteByte(3)
+	u32(1000001)
+	u32(0x100 | 1<<31)
+	recs2.WriteByte(2 | 1<<4)
+	dt := b.block("##DT", nil, recs2.Bytes()[:recs2.Len()])
+	dg2 := b.block("##DG", []uint64{dg1, cg4, dt, 0}, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	b.link(hd, 0, dg2)
+
+	want := []canlog.Record{
+		{
+			Time: t0.Add(500 * time.Millisecond), Iface: "1",
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{1, 2, 3}},
+		},
+		{
+			Time: t0.Add(time.Second), Flags: canlog.FD,
+			Frame: canbus.Frame{ID: 0x321, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		},
+		{
+			Time: t0.Add(time.Second + time.Microsecond), Flags: canlog.Ext | canlog.Tx,
+			Frame: canbus.Frame{ID: 0x100, Kind: canbus.RTR, Data: make([]byte, 2)},
+		},
+		{
+			Time: t0.Add(1500 * time.Millisecond), Iface: "2",
+			Frame: canbus.Frame{ID: 0x42, Kind: canbus.EFF, Data: []byte{4, 5, 6, 7, 8, 9, 10, 11}},
+		},
+	}
+	compare(t, readAll(t, mdf.NewReader(bytes.NewReader(b.Bytes()))), want)
+}
+
+func TestReadErrors(t *testing.T) {
+	hd := func(b *builder) uint64 {
+		return b.block("##HD", make([]uint64, 6), make([]byte, 32))
+	}
+	for _, tc := range []struct {
+		name string
+		src  func() []byte
+		err  string
+	}{
+		{
+			name: "empty",
+			src:  func() []byte { return nil },
+			err:  "mdf: could not read file identification: unexpected EOF",
+		},
+		{
+			name: "identifier",
+			src:  func() []byte { return make([]byte, 64) },
+			err:  `mdf: invalid file identifier "\x00\x00\x00\x00\x00\x00\x00\x00"`,
+		},
+		{
+			name: "version",
+			src: func() []byte {
+				b := newBuilder()
+				binary.LittleEndian.PutUint16(b.Bytes()[28:], 330)
+				return b.Bytes()
+			},
+			err: "mdf: unsupported version 330",
+		},
+		{
+			name: "header",
+			src: func() []byte {
+				b := newBuilder()
+				b.block("##DG", make([]uint64, 4), make([]byte, 8))
+				return b.Bytes()
+			},
+			err: `mdf: invalid block at 0x40: got="##DG", want="##HD"`,
+		},
+		{
+			name: "record-id",
+			src: func() []byte {
+				b := newBuilder()
+				off := hd(b)
+				cg := b.group(0, 0, 1, 0, 0)
+				b.link(off, 0, b.block("##DG", []uint64{0, cg, 0, 0}, []byte{3}))
+				return b.Bytes()
+			},
+			err: "mdf: invalid record ID size 3",
+		},
+		{
+			name: "unknown-record",
+			src: func() []byte {
+				b := newBuilder()
+				off := hd(b)
+				cn := b.channel(0, 0, "CAN_DataFrame.ID", 0, 0, 0, 0, 32, 0, 0)
+				cg := b.group(0, cn, 1, 0x2, 4)
+				dt := b.block("##DT", nil, []byte{2})
+				b.link(off, 0, b.block("##DG", []uint64{0, cg, dt, 0}, []byte{1}))
+				return b.Bytes()
+			},
+			err: "mdf: unknown record ID 2",
+		},
+		{
+			name: "truncated",
+			src: func() []byte {
+				b := newBuilder()
+				off := hd(b)
+				cn := b.channel(0, 0, "CAN_DataFrame.ID", 0, 0, 0, 0, 32, 0, 0)
+				cg := b.group(0, cn, 0, 0x2, 4)
+				dt := b.block("##DT", nil, []byte{1, 2})
+				b.link(off, 0, b.block("##DG", []uint64{0, cg, dt, 0}, []byte{0}))
+				data := b.Bytes()
+				// shrink the DT block, with its padding.
+				binary.LittleEndian.PutUint64(data[dt+8:], 26)
+				return data
+			},
+			err: "mdf: could not read record: unexpected EOF",
+		},
+		{
+			name: "conversion",
+			src: func() []byte {
+				b := newBuilder()
+				off := hd(b)
+				cc := b.block("##CC", make([]uint64, 4), []byte{7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+				cn := b.channel(0, 0, "t", 2, 1, 0, 0, 32, cc, 0)
+				cg := b.group(0, cn, 0, 0x2, 4)
+				b.link(off, 0, b.block("##DG", []uint64{0, cg, 0, 0}, []byte{0}))
+				return b.Bytes()
+			},
+			err: "mdf: unsupported time conversion type 7",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := mdf.NewReader(bytes.NewReader(tc.src())).Read()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	for _, tc := range []struct {
+		name string
+		recs []canlog.Record
+		err  string
+	}{
+		{
+			name: "order",
+			recs: []canlog.Record{{Time: t0}, {Time: t0.Add(-time.Second)}},
+			err:  "mdf: record at " + t0.Add(-time.Second).String() + " precedes the start of the measurement",
+		},
+		{
+			name: "id",
+			recs: []canlog.Record{{Time: t0, Frame: canbus.Frame{ID: 0x800}}},
+			err:  "mdf: invalid frame identifier 0x800",
+		},
+		{
+			name: "length",
+			recs: []canlog.Record{{Time: t0, Frame: canbus.Frame{ID: 1, Data: make([]byte, 9)}}},
+			err:  "mdf: invalid frame length 9",
+		},
+		{
+			name: "channel",
+			recs: []canlog.Record{{Time: t0, Iface: "256"}},
+			err:  "mdf: invalid channel 256",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "test.mf4"))
+			if err != nil {
+				t.Fatalf("could not create file: %+v", err)
+			}
+			defer f.Close()
+
+			w := mdf.NewWriter(f)
+			for _, rec := range tc.recs {
+				err = w.Write(rec)
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,942 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+// Reader reads records from an MDF 4.x file.
+type Reader struct {
+	f     file
+	init  bool
+	err   error
+	start time.Time
+
+	groups []*group // data groups holding CAN frames
+}
+
+// NewReader returns a new MDF reader, reading from f.
+func NewReader(f io.ReaderAt) *Reader {
+	return &Reader{f: file{f}}
+}
+
+// Start returns the start time of the measurement, once the first
+// record has been read.
+func (r *Reader) Start() time.Time {
+	return r.start
+}
+
+// Read returns the next CAN frame of the file, or io.EOF at the end of
+// the file.
+func (r *Reader) Read() (canlog.Record, error) {
+	if !r.init {
+		r.init = true
+		r.err = r.open()
+	}
+	if r.err != nil {
+		return canlog.Record{}, r.err
+	}
+
+	var next *group
+	for _, g := range r.groups {
+		if !g.ok && !g.eof {
+			var err error
+			g.rec, err = g.next()
+			switch err {
+			case nil:
+				g.ok = true
+			case io.EOF:
+				g.eof = true
+			default:
+				return canlog.Record{}, err
+			}
+		}
+		if g.ok && (next == nil || g.rec.Time.Before(next.rec.Time)) {
+			next = g
+		}
+	}
+	if next == nil {
+		return canlog.Record{}, io.EOF
+	}
+	next.ok = false
+	return next.rec, nil
+}
+
+// file reads the blocks of an MDF file.
+type file struct {
+	r io.ReaderAt
+}
+
+// header is the common header of the blocks.
+type header struct {
+	id    string
+	size  uint64
+	links []uint64
+}
+
+// header reads the header and the links of the block at off.
+func (f file) header(off uint64) (header, error) {
+	var (
+		hdr header
+		raw [headerSize]byte
+	)
+	_, err := f.r.ReadAt(raw[:], int64(off))
+	if err != nil {
+		return hdr, fmt.Errorf("mdf: could not read block at 0x%x: %w", off, noEOF(err))
+	}
+	hdr.id = string(raw[:4])
+	hdr.size = binary.LittleEndian.Uint64(raw[8:16])
+	n := binary.LittleEndian.Uint64(raw[16:24])
+	if !strings.HasPrefix(hdr.id, "##") || hdr.size < headerSize+8*n || 8*n > maxBlockSize {
+		return hdr, fmt.Errorf("mdf: invalid block at 0x%x", off)
+	}
+	if n > 0 {
+		links := make([]byte, 8*n)
+		_, err = f.r.ReadAt(links, int64(off+headerSize))
+		if err != nil {
+			return hdr, fmt.Errorf("mdf: could not read block at 0x%x: %w", off, noEOF(err))
+		}
+		hdr.links = make([]uint64, n)
+		for i := range hdr.links {
+			hdr.links[i] = binary.LittleEndian.Uint64(links[8*i:])
+		}
+	}
+	return hdr, nil
+}
+
+// block reads the block at off, checking its identifier, and returns its
+// header and its data.
+// Missing links are reported as nil links.
+func (f file) block(off uint64, id string, links, size int) (header, []byte, error) {
+	hdr, err := f.header(off)
+	if err != nil {
+		return hdr, nil, err
+	}
+	if hdr.id != id {
+		return hdr, nil, fmt.Errorf("mdf: invalid block at 0x%x: got=%q, want=%q", off, hdr.id, id)
+	}
+	beg := headerSize + 8*uint64(len(hdr.links))
+	if hdr.size-beg > maxBlockSize {
+		return hdr, nil, fmt.Errorf("mdf: %s block at 0x%x is too big", id[2:], off)
+	}
+	data := make([]byte, hdr.size-beg)
+	_, err = f.r.ReadAt(data, int64(off+beg))
+	if err != nil {
+		return hdr, nil, fmt.Errorf("mdf: could not read block at 0x%x: %w", off, noEOF(err))
+	}
+	if len(data) < size {
+		return hdr, nil, fmt.Errorf("mdf: invalid %s block at 0x%x", id[2:], off)
+	}
+	for len(hdr.links) < links {
+		hdr.links = append(hdr.links, 0)
+	}
+	return hdr, data, nil
+}
+
+// text returns the content of the TX or MD block at off.
+func (f file) text(off uint64) (string, error) {
+	if off == 0 {
+		return "", nil
+	}
+	hdr, err := f.header(off)
+	if err != nil {
+		return "", err
+	}
+	_, data, err := f.block(off, hdr.id, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	if hdr.id != idTX && hdr.id != idMD {
+		return "", fmt.Errorf("mdf: invalid text block at 0x%x", off)
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data), nil
+}
+
+func (r *Reader) open() error {
+	var id [idBlockSize]byte
+	_, err := r.f.r.ReadAt(id[:], 0)
+	if err != nil {
+		return fmt.Errorf("mdf: could not read file identification: %w", noEOF(err))
+	}
+	if s := string(id[:8]); s != "MDF     " && s != "UnFinMF " {
+		return fmt.Errorf("mdf: invalid file identifier %q", s)
+	}
+	if v := binary.LittleEndian.Uint16(id[28:30]); v < 400 || v >= 500 {
+		return fmt.Errorf("mdf: unsupported version %d", v)
+	}
+
+	hd, data, err := r.f.block(idBlockSize, idHD, 6, 32)
+	if err != nil {
+		return err
+	}
+	var (
+		ns    = int64(binary.LittleEndian.Uint64(data[0:8]))
+		flags = data[12]
+	)
+	r.start = time.Unix(0, ns)
+	if flags&0x1 != 0 {
+		// start time of the measurement in local time.
+		t := r.start.UTC()
+		r.start = time.Date(
+			t.Year(), t.Month(), t.Day(),
+			t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
+			time.Local,
+		)
+	}
+
+	for off := hd.links[0]; off != 0; {
+		dg, data, err := r.f.block(off, idDG, 4, 1)
+		if err != nil {
+			return err
+		}
+		g, err := r.group(dg, int(data[0]))
+		if err != nil {
+			return err
+		}
+		if g != nil {
+			r.groups = append(r.groups, g)
+		}
+		off = dg.links[0]
+	}
+	return nil
+}
+
+// group describes a data group holding CAN frames.
+type group struct {
+	r      *bufio.Reader
+	idSize int
+	cgs    map[uint64]*chanGroup
+	buf    []byte
+
+	rec canlog.Record // next record of the group
+	ok  bool          // whether rec holds the next record
+	eof bool
+}
+
+// chanGroup describes a channel group of a data group.
+type chanGroup struct {
+	size int // size of the records
+
+	// variable length signal data channel groups.
+	vlsd bool
+	pos  uint64            // offset of the next signal data
+	data map[uint64][]byte // pending signal data, by offset
+
+	frames *frameGroup // bus event of the channel group, if any
+}
+
+// frameGroup describes the channels of a bus event channel group.
+type frameGroup struct {
+	event  int
+	start  time.Time
+	time   *channel
+	fields map[string]*channel
+}
+
+// channel describes a channel of a bus event channel group.
+type channel struct {
+	off  int
+	bit  int
+	bits int
+	typ  uint8
+
+	cc   *conversion
+	vlsd *chanGroup  // VLSD channel group holding the data of the channel
+	sd   *signalData // signal data block holding the data of the channel
+	link uint64      // offset of the signal data of the channel
+}
+
+// conversion is a linear conversion of the raw values of a channel.
+type conversion struct {
+	a, b float64
+}
+
+// group returns the description of the data group dg, or nil if it does
+// not hold any CAN frame.
+func (r *Reader) group(dg header, idSize int) (*group, error) {
+	switch idSize {
+	case 0, 1, 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("mdf: invalid record ID size %d", idSize)
+	}
+	g := &group{
+		idSize: idSize,
+		cgs:    make(map[uint64]*chanGroup),
+	}
+	var (
+		frames bool
+		byOff  = make(map[uint64]*chanGroup)
+	)
+	for off := dg.links[1]; off != 0; {
+		hdr, data, err := r.f.block(off, idCG, 6, 32)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			id    = binary.LittleEndian.Uint64(data[0:8])
+			flags = binary.LittleEndian.Uint16(data[16:18])
+			size  = binary.LittleEndian.Uint32(data[24:28]) + binary.LittleEndian.Uint32(data[28:32])
+		)
+		if idSize == 0 {
+			id = 0
+		}
+		if _, dup := g.cgs[id]; dup {
+			return nil, fmt.Errorf("mdf: duplicate record ID %d", id)
+		}
+		cg := &chanGroup{
+			size: int(size),
+			vlsd: flags&cgVLSD != 0,
+		}
+		if !cg.vlsd {
+			fg := &frameGroup{
+				event:  -1,
+				start:  r.start,
+				fields: make(map[string]*channel),
+			}
+			err = r.channels(fg, hdr.links[1])
+			if err != nil {
+				return nil, err
+			}
+			if fg.event >= 0 {
+				cg.frames = fg
+				frames = true
+			}
+		}
+		g.cgs[id] = cg
+		byOff[off] = cg
+		off = hdr.links[0]
+	}
+	if !frames {
+		return nil, nil
+	}
+
+	// resolve the VLSD channel groups holding the data bytes.
+	for _, cg := range g.cgs {
+		if cg.frames == nil {
+			continue
+		}
+		for _, ch := range cg.frames.fields {
+			if ch.link == 0 {
+				continue
+			}
+			if vlsd, ok := byOff[ch.link]; ok {
+				if !vlsd.vlsd {
+					return nil, fmt.Errorf("mdf: invalid VLSD channel group at 0x%x", ch.link)
+				}
+				vlsd.data = make(map[uint64][]byte)
+				ch.vlsd = vlsd
+				continue
+			}
+			ch.sd = &signalData{f: r.f, link: ch.link}
+		}
+	}
+
+	g.r = bufio.NewReader(newStream(r.f, dg.links[2]))
+	return g, nil
+}
+
+// channels reads the channel list starting at off.
+func (r *Reader) channels(fg *frameGroup, off uint64) error {
+	for off != 0 {
+		hdr, data, err := r.f.block(off, idCN, 8, 16)
+		if err != nil {
+			return err
+		}
+		name, err := r.f.text(hdr.links[2])
+		if err != nil {
+			return err
+		}
+		ch := &channel{
+			typ:  data[2],
+			bit:  int(data[3]),
+			off:  int(binary.LittleEndian.Uint32(data[4:8])),
+			bits: int(binary.LittleEndian.Uint32(data[8:12])),
+		}
+		switch typ, sync := data[0], data[1]; {
+		case typ == cnMaster && sync == syncTime:
+			ch.cc, err = r.conversion(hdr.links[4])
+			if err != nil {
+				return err
+			}
+			fg.time = ch
+		default:
+			if typ == cnVLSD {
+				ch.link = hdr.links[5]
+			}
+			event, key := eventField(name)
+			if event < 0 {
+				break
+			}
+			fg.event = event
+			if key != "" {
+				fg.fields[key] = ch
+			}
+		}
+
+		if comp := hdr.links[1]; comp != 0 {
+			sub, err := r.f.header(comp)
+			if err != nil {
+				return err
+			}
+			if sub.id == idCN {
+				err = r.channels(fg, comp)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		off = hdr.links[0]
+	}
+	return nil
+}
+
+// eventField returns the bus event and the field of the named channel,
+// or -1 if the channel does not belong to a CAN bus event.
+func eventField(name string) (int, string) {
+	toks := strings.Split(name, ".")
+	for i, tok := range toks {
+		for event, v := range events {
+			if tok != v {
+				continue
+			}
+			if i+1 < len(toks) {
+				return event, toks[i+1]
+			}
+			return event, ""
+		}
+	}
+	return -1, ""
+}
+
+// conversion reads the conversion block at off.
+func (r *Reader) conversion(off uint64) (*conversion, error) {
+	if off == 0 {
+		return nil, nil
+	}
+	_, data, err := r.f.block(off, idCC, 4, 24)
+	if err != nil {
+		return nil, err
+	}
+	switch typ := data[0]; typ {
+	case ccIdentity:
+		return nil, nil
+	case ccLinear:
+		if len(data) < 40 {
+			return nil, fmt.Errorf("mdf: invalid CC block at 0x%x", off)
+		}
+		return &conversion{
+			b: math.Float64frombits(binary.LittleEndian.Uint64(data[24:32])),
+			a: math.Float64frombits(binary.LittleEndian.Uint64(data[32:40])),
+		}, nil
+	default:
+		return nil, fmt.Errorf("mdf: unsupported time conversion type %d", typ)
+	}
+}
+
+// next returns the next CAN frame of the data group.
+func (g *group) next() (canlog.Record, error) {
+	var raw [8]byte
+	for {
+		var id uint64
+		if g.idSize > 0 {
+			n, err := io.ReadFull(g.r, raw[:g.idSize])
+			if err != nil {
+				if err == io.EOF || (err == io.ErrUnexpectedEOF && n == 0) {
+					return canlog.Record{}, io.EOF
+				}
+				return canlog.Record{}, fmt.Errorf("mdf: could not read record: %w", noEOF(err))
+			}
+			var v [8]byte
+			copy(v[:], raw[:g.idSize])
+			id = binary.LittleEndian.Uint64(v[:])
+		}
+		cg, ok := g.cgs[id]
+		if !ok {
+			return canlog.Record{}, fmt.Errorf("mdf: unknown record ID %d", id)
+		}
+
+		if cg.vlsd {
+			_, err := io.ReadFull(g.r, raw[:4])
+			if err != nil {
+				return canlog.Record{}, fmt.Errorf("mdf: could not read record: %w", noEOF(err))
+			}
+			n := binary.LittleEndian.Uint32(raw[:4])
+			if n > maxBlockSize {
+				return canlog.Record{}, fmt.Errorf("mdf: invalid signal data size %d", n)
+			}
+			data := make([]byte, n)
+			_, err = io.ReadFull(g.r, data)
+			if err != nil {
+				return canlog.Record{}, fmt.Errorf("mdf: could not read record: %w", noEOF(err))
+			}
+			if cg.data != nil {
+				cg.data[cg.pos] = data
+			}
+			cg.pos += 4 + uint64(n)
+			continue
+		}
+
+		if cap(g.buf) < cg.size {
+			g.buf = make([]byte, cg.size)
+		}
+		rec := g.buf[:cg.size]
+		_, err := io.ReadFull(g.r, rec)
+		if err != nil {
+			if g.idSize == 0 && err == io.EOF {
+				return canlog.Record{}, io.EOF
+			}
+			return canlog.Record{}, fmt.Errorf("mdf: could not read record: %w", noEOF(err))
+		}
+		if cg.frames == nil {
+			continue
+		}
+		return cg.frames.decode(rec)
+	}
+}
+
+// decode decodes a record of the bus event channel group.
+func (fg *frameGroup) decode(rec []byte) (canlog.Record, error) {
+	var (
+		out canlog.Record
+		err error
+	)
+	out.Time, err = fg.timeOf(rec)
+	if err != nil {
+		return out, err
+	}
+
+	value := func(name string) (uint64, bool, error) {
+		ch, ok := fg.fields[name]
+		if !ok {
+			return 0, false, nil
+		}
+		v, err := ch.uint(rec)
+		if err != nil {
+			return 0, false, fmt.Errorf("mdf: %s.%s: %w", events[fg.event], name, err)
+		}
+		return v, true, nil
+	}
+	var (
+		v  [9]uint64
+		ok [9]bool
+	)
+	for i, name := range []string{
+		"BusChannel", "ID", "IDE", "DLC", "DataLength", "Dir", "EDL", "BRS", "ESI",
+	} {
+		v[i], ok[i], err = value(name)
+		if err != nil {
+			return out, err
+		}
+	}
+	var (
+		bus, id, ide, dlc, n = v[0], v[1], v[2], v[3], v[4]
+		dir, edl, brs, esi   = v[5], v[6], v[7], v[8]
+	)
+	if ok[0] {
+		out.Iface = strconv.FormatUint(bus, 10)
+	}
+	if dir != 0 {
+		out.Flags |= canlog.Tx
+	}
+	ext := ide != 0 || id&0x80000000 != 0
+	id &= 0x1fffffff
+	if id > 0x7ff {
+		ext = true
+	}
+
+	max := 8
+	if edl != 0 {
+		max = canlog.MaxLen
+		out.Flags |= canlog.FD
+		if brs != 0 {
+			out.Flags |= canlog.BRS
+		}
+		if esi != 0 {
+			out.Flags |= canlog.ESI
+		}
+	}
+	if !ok[4] {
+		switch {
+		case edl != 0:
+			n = uint64(canlog.Len(uint8(dlc)))
+		default:
+			n = dlc
+		}
+	}
+	if n > uint64(max) {
+		n = uint64(max)
+	}
+
+	switch fg.event {
+	case remoteFrame:
+		out.Frame.Kind = canbus.RTR
+		out.Frame.ID = uint32(id)
+		if ext {
+			out.Flags |= canlog.Ext
+		}
+		out.Frame.Data = make([]byte, n)
+		return out, nil
+	case errorFrame:
+		out.Frame.Kind = canbus.ERR
+		out.Frame.ID = uint32(id)
+	default:
+		out.Frame.Kind = canbus.SFF
+		if ext {
+			out.Frame.Kind = canbus.EFF
+		}
+		out.Frame.ID = uint32(id)
+	}
+
+	out.Frame.Data = make([]byte, n)
+	if ch, ok := fg.fields["DataBytes"]; ok {
+		data, err := ch.bytes(rec)
+		if err != nil {
+			return out, fmt.Errorf("mdf: %s.DataBytes: %w", events[fg.event], err)
+		}
+		copy(out.Frame.Data, data)
+	}
+	return out, nil
+}
+
+// timeOf returns the time of the record.
+func (fg *frameGroup) timeOf(rec []byte) (time.Time, error) {
+	ch := fg.time
+	if ch == nil {
+		return fg.start, nil
+	}
+	var (
+		a, b = 1.0, 0.0
+		f    float64
+	)
+	if ch.cc != nil {
+		a, b = ch.cc.a, ch.cc.b
+	}
+	switch ch.typ {
+	case dtUintLE, dtUintBE:
+		v, err := ch.uint(rec)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("mdf: master channel: %w", err)
+		}
+		// integer timestamps with a decimal resolution are converted
+		// exactly.
+		if unit := a * 1e9; b == 0 && unit >= 1 && unit == math.Round(unit) {
+			return fg.start.Add(time.Duration(v * uint64(unit))), nil
+		}
+		f = float64(v)
+	case dtFloatLE, dtFloatBE:
+		v, err := ch.float(rec)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("mdf: master channel: %w", err)
+		}
+		f = v
+	default:
+		return time.Time{}, fmt.Errorf("mdf: master channel: unsupported data type %d", ch.typ)
+	}
+	sec := a*f + b
+	return fg.start.Add(time.Duration(math.Round(sec * 1e9))), nil
+}
+
+// uint returns the value of an integer channel.
+func (ch *channel) uint(rec []byte) (uint64, error) {
+	if ch.bits > 64 {
+		return 0, fmt.Errorf("unsupported bit count %d", ch.bits)
+	}
+	n := (ch.bit + ch.bits + 7) / 8
+	if n > 8 || ch.off+n > len(rec) {
+		return 0, fmt.Errorf("invalid channel position")
+	}
+	var raw [8]byte
+	switch ch.typ {
+	case dtUintLE, dtIntLE:
+		copy(raw[:], rec[ch.off:ch.off+n])
+	case dtUintBE, dtIntBE:
+		if ch.bit != 0 || ch.bits%8 != 0 {
+			return 0, fmt.Errorf("unsupported big-endian bit field")
+		}
+		for i := 0; i < n; i++ {
+			raw[i] = rec[ch.off+n-1-i]
+		}
+	default:
+		return 0, fmt.Errorf("unsupported data type %d", ch.typ)
+	}
+	v := binary.LittleEndian.Uint64(raw[:]) >> ch.bit
+	if ch.bits < 64 {
+		v &= 1<<ch.bits - 1
+	}
+	return v, nil
+}
+
+// float returns the value of a floating point channel.
+func (ch *channel) float(rec []byte) (float64, error) {
+	if ch.bit != 0 || (ch.bits != 32 && ch.bits != 64) || ch.off+ch.bits/8 > len(rec) {
+		return 0, fmt.Errorf("invalid floating point channel")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if ch.typ == dtFloatBE {
+		order = binary.BigEndian
+	}
+	p := rec[ch.off:]
+	if ch.bits == 32 {
+		return float64(math.Float32frombits(order.Uint32(p))), nil
+	}
+	return math.Float64frombits(order.Uint64(p)), nil
+}
+
+// bytes returns the value of a byte array channel.
+func (ch *channel) bytes(rec []byte) ([]byte, error) {
+	switch {
+	case ch.vlsd != nil:
+		off, err := ch.offset(rec)
+		if err != nil {
+			return nil, err
+		}
+		data, ok := ch.vlsd.data[off]
+		if !ok {
+			return nil, fmt.Errorf("missing signal data at offset %d", off)
+		}
+		delete(ch.vlsd.data, off)
+		return data, nil
+	case ch.sd != nil:
+		off, err := ch.offset(rec)
+		if err != nil {
+			return nil, err
+		}
+		return ch.sd.read(off)
+	}
+	n := ch.bits / 8
+	if ch.bit != 0 || ch.off+n > len(rec) {
+		return nil, fmt.Errorf("invalid channel position")
+	}
+	return rec[ch.off : ch.off+n], nil
+}
+
+// offset returns the offset of the signal data of a VLSD channel.
+func (ch *channel) offset(rec []byte) (uint64, error) {
+	if ch.off+8 > len(rec) {
+		return 0, fmt.Errorf("invalid channel position")
+	}
+	return binary.LittleEndian.Uint64(rec[ch.off:]), nil
+}
+
+// signalData reads the variable length values of a signal data stream.
+// Values are usually read in order: the stream is only read again from
+// its beginning when a value precedes the last one read.
+type signalData struct {
+	f    file
+	link uint64
+	r    *bufio.Reader
+	pos  uint64
+}
+
+func (sd *signalData) read(off uint64) ([]byte, error) {
+	if sd.r == nil || off < sd.pos {
+		sd.r = bufio.NewReader(newStream(sd.f, sd.link))
+		sd.pos = 0
+	}
+	_, err := sd.r.Discard(int(off - sd.pos))
+	if err != nil {
+		return nil, fmt.Errorf("could not read signal data: %w", noEOF(err))
+	}
+	var raw [4]byte
+	_, err = io.ReadFull(sd.r, raw[:])
+	if err != nil {
+		return nil, fmt.Errorf("could not read signal data: %w", noEOF(err))
+	}
+	n := binary.LittleEndian.Uint32(raw[:])
+	if n > maxBlockSize {
+		return nil, fmt.Errorf("invalid signal data size %d", n)
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(sd.r, data)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signal data: %w", noEOF(err))
+	}
+	sd.pos = off + 4 + uint64(n)
+	return data, nil
+}
+
+// stream reads the content of a chain of data blocks (DT, SD, DZ, DL
+// and HL blocks) as a continuous stream.
+type stream struct {
+	f     file
+	queue []uint64 // offsets of the pending data blocks
+	list  uint64   // offset of the next data list block
+	cur   io.Reader
+	zr    io.ReadCloser
+}
+
+func newStream(f file, off uint64) *stream {
+	s := &stream{f: f}
+	if off != 0 {
+		s.queue = []uint64{off}
+	}
+	return s
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	for {
+		if s.cur != nil {
+			n, err := s.cur.Read(p)
+			if err == io.EOF {
+				s.cur = nil
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		err := s.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// open opens the next data block of the stream.
+func (s *stream) open() error {
+	for len(s.queue) == 0 {
+		if s.list == 0 {
+			return io.EOF
+		}
+		dl, data, err := s.f.block(s.list, idDL, 1, 8)
+		if err != nil {
+			return err
+		}
+		n := int(binary.LittleEndian.Uint32(data[4:8]))
+		if n > len(dl.links)-1 {
+			return fmt.Errorf("mdf: invalid DL block at 0x%x", s.list)
+		}
+		s.queue = append(s.queue, dl.links[1:1+n]...)
+		s.list = dl.links[0]
+	}
+	off := s.queue[0]
+	s.queue = s.queue[1:]
+	if off == 0 {
+		return nil
+	}
+
+	hdr, err := s.f.header(off)
+	if err != nil {
+		return err
+	}
+	beg := off + headerSize + 8*uint64(len(hdr.links))
+	switch hdr.id {
+	case idDT, idSD:
+		s.cur = io.NewSectionReader(s.f.r, int64(beg), int64(hdr.size-(beg-off)))
+	case idDL:
+		s.list = off
+	case idHL:
+		if len(hdr.links) < 1 {
+			return fmt.Errorf("mdf: invalid HL block at 0x%x", off)
+		}
+		s.list = hdr.links[0]
+	case idDZ:
+		return s.openDZ(off, beg, hdr)
+	default:
+		return fmt.Errorf("mdf: invalid data block at 0x%x: %q", off, hdr.id)
+	}
+	return nil
+}
+
+func (s *stream) openDZ(off, beg uint64, hdr header) error {
+	var raw [dzHeaderSize]byte
+	_, err := s.f.r.ReadAt(raw[:], int64(beg))
+	if err != nil {
+		return fmt.Errorf("mdf: could not read DZ block at 0x%x: %w", off, noEOF(err))
+	}
+	var (
+		zip   = raw[2]
+		param = binary.LittleEndian.Uint32(raw[4:8])
+		size  = binary.LittleEndian.Uint64(raw[8:16])
+		zsize = binary.LittleEndian.Uint64(raw[16:24])
+	)
+	if zsize > hdr.size-(beg-off)-dzHeaderSize {
+		return fmt.Errorf("mdf: invalid DZ block at 0x%x", off)
+	}
+	sr := io.NewSectionReader(s.f.r, int64(beg+dzHeaderSize), int64(zsize))
+	if s.zr == nil {
+		s.zr, err = zlib.NewReader(sr)
+	} else {
+		err = s.zr.(zlib.Resetter).Reset(sr, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("mdf: could not decompress DZ block at 0x%x: %w", off, noEOF(err))
+	}
+
+	switch zip {
+	case zipDeflate:
+		s.cur = &dzReader{r: io.LimitReader(s.zr, int64(size)), n: size, off: off}
+	case zipTransposed:
+		if size > maxBlockSize {
+			return fmt.Errorf("mdf: DZ block at 0x%x is too big", off)
+		}
+		data := make([]byte, size)
+		_, err = io.ReadFull(s.zr, data)
+		if err != nil {
+			return fmt.Errorf("mdf: could not decompress DZ block at 0x%x: %w", off, noEOF(err))
+		}
+		s.cur = bytes.NewReader(untranspose(data, int(param)))
+	default:
+		return fmt.Errorf("mdf: unsupported DZ compression %d at 0x%x", zip, off)
+	}
+	return nil
+}
+
+// dzReader checks the size of the uncompressed data of a DZ block.
+type dzReader struct {
+	r   io.Reader
+	n   uint64 // number of remaining bytes
+	off uint64
+}
+
+func (r *dzReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n -= uint64(n)
+	if err == io.EOF && r.n != 0 {
+		err = fmt.Errorf("mdf: could not decompress DZ block at 0x%x: %w", r.off, io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+// untranspose restores data transposed with the provided number of
+// columns. The trailing bytes not filling a row are not transposed.
+func untranspose(data []byte, cols int) []byte {
+	if cols <= 1 {
+		return data
+	}
+	rows := len(data) / cols
+	out := make([]byte, len(data))
+	for c := 0; c < cols; c++ {
+		for i := 0; i < rows; i++ {
+			out[i*cols+c] = data[c*rows+i]
+		}
+	}
+	copy(out[rows*cols:], data[rows*cols:])
+	return out
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,470 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+const (
+	// blockSize is the size of the uncompressed data of the DZ blocks
+	// produced by the writer.
+	blockSize = 1024 * 1024
+
+	// unfinalized flags of the files being written: cycle counters and
+	// data lists not updated.
+	unfinFlags = 0x0011
+
+	fhComment = `<FHcomment>
+<TX>created</TX>
+<tool_id>canbus</tool_id>
+<tool_vendor>go-daq</tool_vendor>
+<tool_version>1.0</tool_version>
+</FHcomment>`
+)
+
+// Writer writes records to an MDF 4.1 file, following the ASAM MDF bus
+// logging convention.
+//
+// Records are buffered until a complete DZ block can be compressed and
+// written. Flush and Close write the list of the data blocks written so
+// far, and Close updates the statistics of the file.
+type Writer struct {
+	w   io.WriteSeeker
+	pos int64 // current offset in the file
+	err error
+
+	init   bool
+	start  time.Time
+	chans  canlog.Channels
+	dgData int64     // offset of the data link of the data group
+	cgs    [3]int64  // offsets of the channel groups
+	counts [3]uint64 // number of records of each channel group
+
+	buf    bytes.Buffer // uncompressed records
+	zbuf   bytes.Buffer // compressed records
+	zw     *zlib.Writer
+	blocks []uint64 // offsets of the DZ blocks not yet listed
+	offs   []uint64 // offsets of the data of the DZ blocks not yet listed
+	size   uint64   // size of the uncompressed records
+	next   int64    // offset of the next link of the last DL block
+}
+
+// NewWriter returns a new MDF writer, writing to w.
+//
+// The measurement starts at the time of the first record written.
+func NewWriter(w io.WriteSeeker) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes the record to the file, with a nanosecond resolution.
+// Records must not precede the first record of the file.
+func (w *Writer) Write(rec canlog.Record) error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.init {
+		w.start = rec.Time
+		w.err = w.writeHeader()
+		if w.err != nil {
+			return w.err
+		}
+	}
+	dt := rec.Time.Sub(w.start)
+	if dt < 0 {
+		return fmt.Errorf("mdf: record at %v precedes the start of the measurement", rec.Time)
+	}
+
+	var (
+		frame = rec.Frame
+		event = dataFrame
+		id    = frame.ID
+		ext   bool
+		fd    = rec.Flags&canlog.FD != 0
+		max   = 8
+		ch    = w.chans.Channel(rec.Iface)
+	)
+	if ch > 0xff {
+		return fmt.Errorf("mdf: invalid channel %d", ch)
+	}
+	switch frame.Kind {
+	case canbus.SFF:
+	case canbus.EFF:
+		ext = true
+	case canbus.RTR:
+		event = remoteFrame
+		ext = rec.Flags&canlog.Ext != 0
+	case canbus.ERR:
+		event = errorFrame
+		ext = id > 0x7ff
+	default:
+		return fmt.Errorf("mdf: invalid frame kind %v", frame.Kind)
+	}
+	if ext && id > 0x1fffffff || !ext && id > 0x7ff {
+		return fmt.Errorf("mdf: invalid frame identifier 0x%x", id)
+	}
+	if fd {
+		if event != dataFrame {
+			return fmt.Errorf("mdf: invalid CAN FD frame kind %v", frame.Kind)
+		}
+		max = canlog.MaxLen
+	}
+	if len(frame.Data) > max {
+		return fmt.Errorf("mdf: invalid frame length %d", len(frame.Data))
+	}
+
+	// record ID, timestamp and bus event fields.
+	size := recordSize(event)
+	beg := w.buf.Len()
+	w.buf.Write(make([]byte, 1+size))
+	p := w.buf.Bytes()[beg:]
+	p[0] = byte(event + 1)
+	p = p[1:]
+	binary.LittleEndian.PutUint64(p[0:8], uint64(dt))
+	p[8] = byte(ch)
+	if ext {
+		id |= 0x80000000 // IDE
+	}
+	binary.LittleEndian.PutUint32(p[9:13], id)
+	dlc := byte(len(frame.Data))
+	if fd {
+		dlc = canlog.DLC(len(frame.Data))
+	}
+	flags := dlc
+	if rec.Flags&canlog.Tx != 0 {
+		flags |= 1 << 4
+	}
+	if fd {
+		flags |= 1 << 5
+		if rec.Flags&canlog.BRS != 0 {
+			flags |= 1 << 6
+		}
+		if rec.Flags&canlog.ESI != 0 {
+			flags |= 1 << 7
+		}
+	}
+	p[13] = flags
+	p[14] = byte(len(frame.Data))
+	if event != remoteFrame {
+		copy(p[15:], frame.Data)
+	}
+	w.counts[event]++
+
+	if w.buf.Len() >= blockSize {
+		w.flush()
+	}
+	return w.err
+}
+
+// flush writes the buffered records in a DZ block.
+func (w *Writer) flush() {
+	if w.buf.Len() == 0 || w.err != nil {
+		return
+	}
+	w.zbuf.Reset()
+	if w.zw == nil {
+		w.zw = zlib.NewWriter(&w.zbuf)
+	} else {
+		w.zw.Reset(&w.zbuf)
+	}
+	_, w.err = w.zw.Write(w.buf.Bytes())
+	if w.err != nil {
+		return
+	}
+	w.err = w.zw.Close()
+	if w.err != nil {
+		return
+	}
+
+	data := make([]byte, dzHeaderSize, dzHeaderSize+w.zbuf.Len())
+	copy(data[0:2], "DT")
+	data[2] = zipDeflate
+	binary.LittleEndian.PutUint64(data[8:16], uint64(w.buf.Len()))
+	binary.LittleEndian.PutUint64(data[16:24], uint64(w.zbuf.Len()))
+	data = append(data, w.zbuf.Bytes()...)
+
+	w.blocks = append(w.blocks, uint64(w.pos))
+	w.offs = append(w.offs, w.size)
+	w.size += uint64(w.buf.Len())
+	w.buf.Reset()
+	w.write(appendBlock(nil, idDZ, nil, data))
+}
+
+// list writes a DL block listing the DZ blocks written since the
+// previous DL block, and links it to the file: the first DL block is
+// linked from a HL block referenced by the data group, and the other
+// ones from the previous DL block.
+func (w *Writer) list() {
+	if len(w.blocks) == 0 || w.err != nil {
+		return
+	}
+	b := builder{base: w.pos}
+	dl := b.block(idDL, append([]uint64{0}, w.blocks...), func(p []byte) {
+		binary.LittleEndian.PutUint32(p[4:8], uint32(len(w.blocks)))
+		for i, off := range w.offs {
+			binary.LittleEndian.PutUint64(p[8+8*i:], off)
+		}
+	}, 8+8*len(w.offs))
+	link, at := dl, w.next
+	if w.next == 0 {
+		hl := b.block(idHL, []uint64{dl}, func(p []byte) {
+			p[2] = zipDeflate
+		}, 8)
+		link, at = hl, w.dgData
+	}
+	w.write(b.buf)
+
+	// the DL block is linked once written, so the file stays readable
+	// if the writer does not complete.
+	var v [8]byte
+	binary.LittleEndian.PutUint64(v[:], link)
+	w.writeAt(v[:], at)
+	w.next = int64(dl) + headerSize
+	w.blocks = w.blocks[:0]
+	w.offs = w.offs[:0]
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(p)
+	w.pos += int64(n)
+}
+
+// writeAt writes p at the provided offset of the file.
+func (w *Writer) writeAt(p []byte, off int64) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Seek(off, io.SeekStart)
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(p)
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Seek(w.pos, io.SeekStart)
+}
+
+// writeHeader writes the identification block and the description of
+// the measurement.
+func (w *Writer) writeHeader() error {
+	w.init = true
+	pos, err := w.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("mdf: could not get file offset: %w", err)
+	}
+	if pos != 0 {
+		return fmt.Errorf("mdf: file does not start at offset 0")
+	}
+
+	var id [idBlockSize]byte
+	copy(id[0:8], "UnFinMF ")
+	copy(id[8:16], "4.10    ")
+	copy(id[16:24], "canbus  ")
+	binary.LittleEndian.PutUint16(id[28:30], 410)
+	binary.LittleEndian.PutUint16(id[60:62], unfinFlags)
+
+	var (
+		b  = builder{base: idBlockSize}
+		ns = uint64(w.start.UnixNano())
+	)
+
+	// header and file history.
+	hd := b.block(idHD, make([]uint64, 6), func(p []byte) {
+		binary.LittleEndian.PutUint64(p[0:8], ns)
+	}, 32)
+	md := b.text(idMD, fhComment)
+	fh := b.block(idFH, []uint64{0, md}, func(p []byte) {
+		binary.LittleEndian.PutUint64(p[0:8], ns)
+	}, 16)
+	b.link(hd, 1, fh)
+
+	// acquisition source, time conversion and unit of the channel
+	// groups.
+	si := b.block(idSI, []uint64{b.text(idTX, "CAN"), 0, 0}, func(p []byte) {
+		p[0] = siBus
+		p[1] = siBusCAN
+	}, 8)
+	cc := b.block(idCC, make([]uint64, 4), func(p []byte) {
+		p[0] = ccLinear
+		binary.LittleEndian.PutUint16(p[6:8], 2)
+		binary.LittleEndian.PutUint64(p[32:40], math.Float64bits(1e-9))
+	}, 40)
+	unit := b.text(idTX, "s")
+
+	var cgs [len(events)]uint64
+	for event := len(events) - 1; event >= 0; event-- {
+		name := events[event]
+		size := recordSize(event)
+
+		// fields of the bus event, composing the bus event channel.
+		var next uint64
+		fields := layouts[event]
+		for i := len(fields) - 1; i >= 0; i-- {
+			f := fields[i]
+			next = b.channel(next, 0, name+"."+f.name, cnFixed, 0, f, 0, si, 0, 0)
+		}
+		comp := b.channel(0, next, name, cnFixed, 0, field{
+			off: 8, bits: 8 * (size - 8), typ: dtByteArray,
+		}, cnBusEvent, si, 0, 0)
+		ts := b.channel(comp, 0, "Timestamp", cnMaster, syncTime, field{
+			bits: 64, typ: dtUintLE,
+		}, 0, si, cc, unit)
+
+		var nextCG uint64
+		if event+1 < len(events) {
+			nextCG = cgs[event+1]
+		}
+		cgs[event] = b.block(idCG, []uint64{nextCG, ts, b.text(idTX, name), si, 0, 0}, func(p []byte) {
+			binary.LittleEndian.PutUint64(p[0:8], uint64(event+1))
+			binary.LittleEndian.PutUint16(p[16:18], cgBusEvent|cgPlainBus)
+			binary.LittleEndian.PutUint16(p[18:20], '.')
+			binary.LittleEndian.PutUint32(p[24:28], uint32(size))
+		}, 32)
+		w.cgs[event] = int64(cgs[event])
+	}
+	dg := b.block(idDG, []uint64{0, cgs[0], 0, 0}, func(p []byte) {
+		p[0] = 1 // record ID size
+	}, 8)
+	b.link(hd, 0, dg)
+	w.dgData = int64(dg) + headerSize + 2*8
+
+	w.write(id[:])
+	w.write(b.buf)
+	if w.err != nil {
+		return fmt.Errorf("mdf: could not write file header: %w", w.err)
+	}
+	return nil
+}
+
+// Flush writes the buffered records to the underlying writer, and
+// links them to the file, so they can be read back even if the file is
+// not finalized.
+// Each flush adds blocks to the file, and should not follow every
+// record.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.init {
+		return nil
+	}
+	w.flush()
+	w.list()
+	if w.err != nil {
+		return fmt.Errorf("mdf: could not write file: %w", w.err)
+	}
+	return nil
+}
+
+// Close flushes the buffered records to the underlying writer, and
+// finalizes the file.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.init {
+		w.start = time.Now()
+		err := w.writeHeader()
+		if err != nil {
+			return err
+		}
+	}
+	w.flush()
+	w.list()
+
+	for i, off := range w.cgs {
+		var count [8]byte
+		binary.LittleEndian.PutUint64(count[:], w.counts[i])
+		w.writeAt(count[:], off+headerSize+6*8+8)
+	}
+	var id [8]byte
+	copy(id[:], "MDF     ")
+	w.writeAt(id[:], 0)
+	w.writeAt([]byte{0, 0}, 60)
+	if w.err != nil {
+		return fmt.Errorf("mdf: could not write file: %w", w.err)
+	}
+	return nil
+}
+
+// builder lays out blocks at consecutive offsets of a file.
+type builder struct {
+	base int64 // offset of the first block
+	buf  []byte
+}
+
+// block appends a block with the provided links, and with data of the
+// provided size filled by fill, and returns its offset.
+func (b *builder) block(id string, links []uint64, fill func(p []byte), size int) uint64 {
+	off := uint64(b.base) + uint64(len(b.buf))
+	data := make([]byte, size)
+	if fill != nil {
+		fill(data)
+	}
+	b.buf = appendBlock(b.buf, id, links, data)
+	return off
+}
+
+// text appends a TX or MD block, and returns its offset.
+func (b *builder) text(id, s string) uint64 {
+	return b.block(id, nil, func(p []byte) { copy(p, s) }, len(s)+1)
+}
+
+// link sets the i-th link of the block at off.
+func (b *builder) link(off uint64, i int, v uint64) {
+	pos := int(off-uint64(b.base)) + headerSize + 8*i
+	binary.LittleEndian.PutUint64(b.buf[pos:], v)
+}
+
+// channel appends a CN block, and returns its offset.
+func (b *builder) channel(next, comp uint64, name string, typ, sync uint8, f field, flags uint32, si, cc, unit uint64) uint64 {
+	tx := b.text(idTX, name)
+	return b.block(idCN, []uint64{next, comp, tx, si, cc, 0, unit, 0}, func(p []byte) {
+		p[0] = typ
+		p[1] = sync
+		p[2] = f.typ
+		p[3] = uint8(f.bit)
+		binary.LittleEndian.PutUint32(p[4:8], uint32(f.off))
+		binary.LittleEndian.PutUint32(p[8:12], uint32(f.bits))
+		binary.LittleEndian.PutUint32(p[12:16], flags)
+	}, 72)
+}
+
+// appendBlock appends a block to buf, padded to a multiple of 8 bytes.
+func appendBlock(buf []byte, id string, links []uint64, data []byte) []byte {
+	size := headerSize + 8*len(links) + len(data)
+	pad := (8 - size%8) % 8
+	if id != idDZ {
+		// the padding of the blocks is part of their data, except for
+		// the compressed blocks, whose size is the size of their data.
+		size += pad
+	}
+	var hdr [headerSize]byte
+	copy(hdr[0:4], id)
+	binary.LittleEndian.PutUint64(hdr[8:16], uint64(size))
+	binary.LittleEndian.PutUint64(hdr[16:24], uint64(len(links)))
+	buf = append(buf, hdr[:]...)
+	for _, link := range links {
+		var v [8]byte
+		binary.LittleEndian.PutUint64(v[:], link)
+		buf = append(buf, v[:]...)
+	}
+	buf = append(buf, data...)
+	return append(buf, make([]byte, pad)...)
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package canlog
+
+import "io"
+
+// Merge returns a Reader merging the records of the provided readers,
+// sorted by time.
+//
+// The records of each reader are expected to be sorted by time.
+// Records logged at the same time are returned in the order of their
+// readers.
+func Merge(rs ...Reader) Reader {
+	return &merger{
+		rs:   rs,
+		recs: make([]Record, len(rs)),
+		ok:   make([]bool, len(rs)),
+	}
+}
+
+type merger struct {
+	rs   []Reader
+	recs []Record // next record of each reader
+	ok   []bool   // whether recs holds the next record of each reader
+	err  error
+}
+
+// next reads the next record of the i-th reader.
+func (m *merger) next(i int) error {
+	if m.rs[i] == nil {
+		return nil
+	}
+	rec, err := m.rs[i].Read()
+	switch err {
+	case nil:
+		m.recs[i], m.ok[i] = rec, true
+	case io.EOF:
+		m.rs[i] = nil
+	default:
+		return err
+	}
+	return nil
+}
+
+func (m *merger) Read() (Record, error) {
+	if m.err != nil {
+		return Record{}, m.err
+	}
+	for i := range m.rs {
+		if m.ok[i] {
+			continue
+		}
+		m.err = m.next(i)
+		if m.err != nil {
+			return Record{}, m.err
+		}
+	}
+
+	j := -1
+	for i, ok := range m.ok {
+		if ok && (j < 0 || m.recs[i].Time.Before(m.recs[j].Time)) {
+			j = i
+		}
+	}
+	if j < 0 {
+		return Record{}, io.EOF
+	}
+	m.ok[j] = false
+	return m.recs[j], nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+// LinkType is the LINKTYPE_CAN_SOCKETCAN link-layer header type, the
+// link type of the packets holding SocketCAN frames.
+const LinkType = 227
+
+const (
+	canMTU   = 16 // size of a classic SocketCAN frame
+	canFDMTU = 72 // size of a CAN FD SocketCAN frame
+
+	effFlag = 0x80000000
+	rtrFlag = 0x40000000
+	errFlag = 0x20000000
+	sffMask = 0x000007ff
+	effMask = 0x1fffffff
+
+	fdBRS = 0x01 // CAN FD frame sent with a bit rate switch
+	fdESI = 0x02 // CAN FD frame with the error state indicator set
+	fdFDF = 0x04 // CAN FD frame
+)
+
+// DecodeFrame decodes a LINKTYPE_CAN_SOCKETCAN packet.
+//
+// The packet holds a SocketCAN can_frame or canfd_frame structure, with
+// its identifier in network byte order.
+// CAN FD frames are identified by their CANFD_FDF flag, or by the size
+// of the packet when the flag is not set.
+func DecodeFrame(p []byte) (canbus.Frame, canlog.Flags, error) {
+	var (
+		frame canbus.Frame
+		flags canlog.Flags
+	)
+	if len(p) < 8 {
+		return frame, flags, fmt.Errorf("pcap: invalid SocketCAN frame size %d", len(p))
+	}
+	var (
+		id  = binary.BigEndian.Uint32(p[0:4])
+		n   = int(p[4])
+		fdf = p[5]
+		max = 8
+	)
+	if fdf&fdFDF != 0 || len(p) > canMTU {
+		flags |= canlog.FD
+		max = canlog.MaxLen
+		if fdf&fdBRS != 0 {
+			flags |= canlog.BRS
+		}
+		if fdf&fdESI != 0 {
+			flags |= canlog.ESI
+		}
+	}
+	if n > max {
+		return frame, flags, fmt.Errorf("pcap: invalid SocketCAN frame length %d", n)
+	}
+
+	switch {
+	case id&errFlag != 0:
+		frame.Kind = canbus.ERR
+		frame.ID = id & effMask
+	case id&rtrFlag != 0:
+		frame.Kind = canbus.RTR
+		if id&effFlag != 0 {
+			flags |= canlog.Ext
+			frame.ID = id & effMask
+		} else {
+			frame.ID = id & sffMask
+		}
+		frame.Data = make([]byte, n)
+		return frame, flags, nil
+	case id&effFlag != 0:
+		frame.Kind = canbus.EFF
+		frame.ID = id & effMask
+	default:
+		frame.Kind = canbus.SFF
+		frame.ID = id & sffMask
+	}
+
+	// captures may be truncated: the missing data bytes are zeros.
+	frame.Data = make([]byte, n)
+	if len(p) > 8 {
+		copy(frame.Data, p[8:])
+	}
+	return frame, flags, nil
+}
+
+// AppendFrame appends the LINKTYPE_CAN_SOCKETCAN packet of the frame to
+// buf, as described in DecodeFrame.
+// Classic frames are encoded as 16-byte can_frame structures, and CAN FD
+// frames as 72-byte canfd_frame structures.
+func AppendFrame(buf []byte, frame canbus.Frame, flags canlog.Flags) ([]byte, error) {
+	var (
+		id   = frame.ID
+		size = canMTU
+		fdf  byte
+	)
+	switch frame.Kind {
+	case canbus.SFF:
+		if id > sffMask {
+			return buf, fmt.Errorf("pcap: invalid frame identifier 0x%x", id)
+		}
+	case canbus.EFF:
+		if id > effMask {
+			return buf, fmt.Errorf("pcap: invalid frame identifier 0x%x", id)
+		}
+		id |= effFlag
+	case canbus.RTR:
+		switch {
+		case flags&canlog.Ext != 0:
+			if id > effMask {
+				return buf, fmt.Errorf("pcap: invalid frame identifier 0x%x", id)
+			}
+			id |= effFlag
+		case id > sffMask:
+			return buf, fmt.Errorf("pcap: invalid frame identifier 0x%x", id)
+		}
+		id |= rtrFlag
+	case canbus.ERR:
+		if id > effMask {
+			return buf, fmt.Errorf("pcap: invalid frame identifier 0x%x", id)
+		}
+		id |= errFlag
+	default:
+		return buf, fmt.Errorf("pcap: invalid frame kind %v", frame.Kind)
+	}
+
+	max := 8
+	if flags&canlog.FD != 0 {
+		if frame.Kind == canbus.RTR || frame.Kind == canbus.ERR {
+			return buf, fmt.Errorf("pcap: invalid CAN FD frame kind %v", frame.Kind)
+		}
+		size = canFDMTU
+		max = canlog.MaxLen
+		fdf = fdFDF
+		if flags&canlog.BRS != 0 {
+			fdf |= fdBRS
+		}
+		if flags&canlog.ESI != 0 {
+			fdf |= fdESI
+		}
+	}
+	if len(frame.Data) > max {
+		return buf, fmt.Errorf("pcap: invalid frame length %d", len(frame.Data))
+	}
+
+	beg := len(buf)
+	buf = append(buf, make([]byte, size)...)
+	p := buf[beg:]
+	binary.BigEndian.PutUint32(p[0:4], id)
+	p[4] = byte(len(frame.Data))
+	p[5] = fdf
+	if frame.Kind != canbus.RTR {
+		copy(p[8:], frame.Data)
+	}
+	return buf, nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pcap reads and writes libpcap capture files of SocketCAN
+// frames, with the LINKTYPE_CAN_SOCKETCAN link type, as produced by
+// tcpdump on CAN interfaces and dissected by Wireshark.
+//
+// The reader handles captures with microsecond and nanosecond
+// timestamps, in both byte orders.
+// The writer produces captures with nanosecond timestamps.
+//
+// The pcap format records neither the interface nor the direction of
+// the frames: the Iface field and the Tx flag of the records are not
+// written, and records read from pcap files have an empty Iface.
+// Multi-interface captures are best stored in the pcapng format.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-daq/canbus/canlog"
+)
+
+const (
+	magicMicros = 0xa1b2c3d4 // magic number of captures with µs timestamps
+	magicNanos  = 0xa1b23c4d // magic number of captures with ns timestamps
+
+	fileHeaderSize = 24
+	recHeaderSize  = 16
+
+	// snapLen is the maximum size of the packets handled by the reader,
+	// and the snapshot length of the captures produced by the writer.
+	snapLen = 262144
+)
+
+// Reader reads records from a pcap capture file.
+type Reader struct {
+	r     *bufio.Reader
+	init  bool
+	order binary.ByteOrder
+	unit  time.Duration // resolution of the timestamps
+	err   error         // error of the file header
+	buf   []byte
+}
+
+// NewReader returns a new pcap capture reader, reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+func (r *Reader) readHeader() error {
+	var hdr [fileHeaderSize]byte
+	_, err := io.ReadFull(r.r, hdr[:])
+	if err != nil {
+		return fmt.Errorf("pcap: could not read file header: %w", noEOF(err))
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(hdr[0:4]) {
+		case magicMicros:
+			r.order = order
+			r.unit = time.Microsecond
+		case magicNanos:
+			r.order = order
+			r.unit = time.Nanosecond
+		}
+	}
+	if r.order == nil {
+		return fmt.Errorf("pcap: invalid magic number 0x%x", hdr[0:4])
+	}
+	if major := r.order.Uint16(hdr[4:6]); major != 2 {
+		return fmt.Errorf("pcap: unsupported version %d.%d", major, r.order.Uint16(hdr[6:8]))
+	}
+	if lt := r.order.Uint32(hdr[20:24]) & 0xffff; lt != LinkType {
+		return fmt.Errorf("pcap: unsupported link type %d", lt)
+	}
+	return nil
+}
+
+// Read returns the next record of the capture, or io.EOF at the end of
+// the capture.
+func (r *Reader) Read() (canlog.Record, error) {
+	var rec canlog.Record
+	if !r.init {
+		r.init = true
+		r.err = r.readHeader()
+	}
+	if r.err != nil {
+		return rec, r.err
+	}
+
+	var hdr [recHeaderSize]byte
+	_, err := io.ReadFull(r.r, hdr[:])
+	switch err {
+	case nil:
+	case io.EOF:
+		return rec, io.EOF
+	default:
+		return rec, fmt.Errorf("pcap: could not read packet header: %w", err)
+	}
+	var (
+		sec  = r.order.Uint32(hdr[0:4])
+		frac = r.order.Uint32(hdr[4:8])
+		size = r.order.Uint32(hdr[8:12])
+	)
+	if size > snapLen {
+		return rec, fmt.Errorf("pcap: invalid packet size %d", size)
+	}
+	if cap(r.buf) < int(size) {
+		r.buf = make([]byte, size)
+	}
+	r.buf = r.buf[:size]
+	_, err = io.ReadFull(r.r, r.buf)
+	if err != nil {
+		return rec, fmt.Errorf("pcap: could not read packet: %w", noEOF(err))
+	}
+
+	rec.Time = time.Unix(int64(sec), int64(frac)*int64(r.unit))
+	rec.Frame, rec.Flags, err = DecodeFrame(r.buf)
+	if err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// Writer writes records to a pcap capture file.
+type Writer struct {
+	w   *bufio.Writer
+	hdr bool
+	buf []byte
+}
+
+// NewWriter returns a new pcap capture writer, writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) writeHeader() error {
+	w.hdr = true
+	var hdr [fileHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], magicNanos)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2)
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], snapLen)
+	binary.LittleEndian.PutUint32(hdr[20:24], LinkType)
+	_, err := w.w.Write(hdr[:])
+	if err != nil {
+		return fmt.Errorf("pcap: could not write file header: %w", err)
+	}
+	return nil
+}
+
+// Write writes the record to the capture.
+// The Iface field and the Tx flag of the record are not written.
+func (w *Writer) Write(rec canlog.Record) error {
+	if !w.hdr {
+		err := w.writeHeader()
+		if err != nil {
+			return err
+		}
+	}
+
+	sec := rec.Time.Unix()
+	if sec < 0 || sec > 1<<32-1 {
+		return fmt.Errorf("pcap: invalid timestamp %v", rec.Time)
+	}
+
+	var err error
+	w.buf = append(w.buf[:0], make([]byte, recHeaderSize)...)
+	w.buf, err = AppendFrame(w.buf, rec.Frame, rec.Flags)
+	if err != nil {
+		return err
+	}
+	size := uint32(len(w.buf) - recHeaderSize)
+	binary.LittleEndian.PutUint32(w.buf[0:4], uint32(sec))
+	binary.LittleEndian.PutUint32(w.buf[4:8], uint32(rec.Time.Nanosecond()))
+	binary.LittleEndian.PutUint32(w.buf[8:12], size)
+	binary.LittleEndian.PutUint32(w.buf[12:16], size)
+
+	_, err = w.w.Write(w.buf)
+	if err != nil {
+		return fmt.Errorf("pcap: could not write packet: %w", err)
+	}
+	return nil
+}
+
+// Flush writes the buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	if !w.hdr {
+		err := w.writeHeader()
+		if err != nil {
+			return err
+		}
+	}
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("pcap: could not flush capture: %w", err)
+	}
+	return nil
+}
+
+// Close writes the pending records to the underlying writer.
+func (w *Writer) Close() error {
+	if !w.hdr {
+		err := w.writeHeader()
+		if err != nil {
+			return err
+		}
+	}
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("pcap: could not flush capture: %w", err)
+	}
+	return nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,233 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/pcap"
+)
+
+func readAll(t *testing.T, r canlog.Reader) []canlog.Record {
+	t.Helper()
+	var recs []canlog.Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("could not read record: %+v", err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		frame canbus.Frame
+		flags canlog.Flags
+		raw   []byte
+	}{
+		{
+			name:  "sff",
+			frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xde, 0xad}},
+			raw:   []byte{0, 0, 0x01, 0x23, 2, 0, 0, 0, 0xde, 0xad, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:  "eff",
+			frame: canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+			raw:   []byte{0x98, 0xfe, 0xf1, 0x00, 8, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8},
+		},
+		{
+			name:  "rtr",
+			frame: canbus.Frame{ID: 0x7ff, Kind: canbus.RTR, Data: make([]byte, 4)},
+			raw:   []byte{0x40, 0, 0x07, 0xff, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:  "rtr-ext",
+			frame: canbus.Frame{ID: 0x100, Kind: canbus.RTR, Data: []byte{}},
+			flags: canlog.Ext,
+			raw:   []byte{0xc0, 0, 0x01, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:  "err",
+			frame: canbus.Frame{ID: 0x004, Kind: canbus.ERR, Data: []byte{0, 0, 0x80, 0, 0, 0, 0, 0}},
+			raw:   []byte{0x20, 0, 0, 0x04, 8, 0, 0, 0, 0, 0, 0x80, 0, 0, 0, 0, 0},
+		},
+		{
+			name:  "fd",
+			frame: canbus.Frame{ID: 0x321, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+			flags: canlog.FD | canlog.BRS | canlog.ESI,
+			raw: append(
+				[]byte{0, 0, 0x03, 0x21, 12, 0x07, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+				make([]byte, 52)...,
+			),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := pcap.AppendFrame(nil, tc.frame, tc.flags)
+			if err != nil {
+				t.Fatalf("could not encode frame: %+v", err)
+			}
+			if !bytes.Equal(raw, tc.raw) {
+				t.Fatalf("invalid packet:\ngot= %x\nwant=%x", raw, tc.raw)
+			}
+			frame, flags, err := pcap.DecodeFrame(raw)
+			if err != nil {
+				t.Fatalf("could not decode frame: %+v", err)
+			}
+			if !reflect.DeepEqual(frame, tc.frame) {
+				t.Fatalf("invalid frame:\ngot= %+v\nwant=%+v", frame, tc.frame)
+			}
+			if flags != tc.flags {
+				t.Fatalf("invalid flags: got=%v, want=%v", flags, tc.flags)
+			}
+		})
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	t0 := time.Unix(1660000000, 123456789)
+	recs := []canlog.Record{
+		{
+			Time:  t0,
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		},
+		{
+			Time: t0.Add(1234 * time.Nanosecond), Flags: canlog.Ext,
+			Frame: canbus.Frame{ID: 0x100, Kind: canbus.RTR, Data: make([]byte, 8)},
+		},
+		{
+			Time: t0.Add(time.Second), Flags: canlog.FD | canlog.BRS,
+			Frame: canbus.Frame{ID: 0xabcd, Kind: canbus.EFF, Data: bytes.Repeat([]byte{0x55}, 64)},
+		},
+	}
+
+	var buf bytes.Buffer
+	w := pcap.NewWriter(&buf)
+	for _, rec := range recs {
+		err := w.Write(rec)
+		if err != nil {
+			t.Fatalf("could not write record: %+v", err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("could not close writer: %+v", err)
+	}
+	if got, want := buf.Len(), 24+3*16+16+16+72; got != want {
+		t.Fatalf("invalid capture size: got=%d, want=%d", got, want)
+	}
+
+	got := readAll(t, pcap.NewReader(&buf))
+	if !reflect.DeepEqual(got, recs) {
+		t.Fatalf("invalid records:\ngot= %+v\nwant=%+v", got, recs)
+	}
+}
+
+func TestRead(t *testing.T) {
+	// big-endian capture, with microsecond timestamps and a truncated
+	// packet.
+	raw := []byte{
+		0xa1, 0xb2, 0xc3, 0xd4, 0, 2, 0, 4,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 16, 0, 0, 0, 227,
+	}
+	raw = append(raw, 0x62, 0xf1, 0xee, 0x80, 0, 0x01, 0xe2, 0x40, 0, 0, 0, 10, 0, 0, 0, 16)
+	raw = append(raw, 0, 0, 0x01, 0x23, 4, 0, 0, 0, 0xca, 0xfe)
+
+	want := []canlog.Record{{
+		Time:  time.Unix(1660022400, 123456000),
+		Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xca, 0xfe, 0, 0}},
+	}}
+	got := readAll(t, pcap.NewReader(bytes.NewReader(raw)))
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid records:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	hdr := func(link uint32) []byte {
+		p := make([]byte, 24)
+		binary.LittleEndian.PutUint32(p[0:], 0xa1b23c4d)
+		binary.LittleEndian.PutUint16(p[4:], 2)
+		binary.LittleEndian.PutUint16(p[6:], 4)
+		binary.LittleEndian.PutUint32(p[20:], link)
+		return p
+	}
+	for _, tc := range []struct {
+		name string
+		src  []byte
+		err  string
+	}{
+		{"empty", nil, "pcap: could not read file header: unexpected EOF"},
+		{"magic", make([]byte, 24), "pcap: invalid magic number 0x00000000"},
+		{"link-type", hdr(1), "pcap: unsupported link type 1"},
+		{"packet-header", append(hdr(227), 1, 2, 3), "pcap: could not read packet header: unexpected EOF"},
+		{"packet-size", append(hdr(227), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1), "pcap: invalid packet size 16777216"},
+		{"packet", append(hdr(227), 0, 0, 0, 0, 0, 0, 0, 0, 16, 0, 0, 0, 16, 0, 0, 0, 1), "pcap: could not read packet: unexpected EOF"},
+		{"frame", append(hdr(227), 0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 4, 0, 0, 0, 1, 2, 3, 4), "pcap: invalid SocketCAN frame size 4"},
+		{"length", append(hdr(227), 0, 0, 0, 0, 0, 0, 0, 0, 8, 0, 0, 0, 8, 0, 0, 0, 0, 0, 1, 0x23, 9, 0, 0, 0), "pcap: invalid SocketCAN frame length 9"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := pcap.NewReader(bytes.NewReader(tc.src)).Read()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	for _, tc := range []struct {
+		name string
+		rec  canlog.Record
+		err  string
+	}{
+		{
+			name: "timestamp",
+			rec:  canlog.Record{Time: time.Unix(-1, 0)},
+			err:  "pcap: invalid timestamp " + time.Unix(-1, 0).String(),
+		},
+		{
+			name: "id",
+			rec:  canlog.Record{Time: t0, Frame: canbus.Frame{ID: 0x800}},
+			err:  "pcap: invalid frame identifier 0x800",
+		},
+		{
+			name: "length",
+			rec:  canlog.Record{Time: t0, Frame: canbus.Frame{ID: 1, Data: make([]byte, 9)}},
+			err:  "pcap: invalid frame length 9",
+		},
+		{
+			name: "fd-kind",
+			rec:  canlog.Record{Time: t0, Flags: canlog.FD, Frame: canbus.Frame{ID: 1, Kind: canbus.ERR}},
+			err:  "pcap: invalid CAN FD frame kind ERR",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := pcap.NewWriter(io.Discard).Write(tc.rec)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
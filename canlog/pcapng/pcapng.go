@@ -0,0 +1,357 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pcapng reads and writes pcapng capture files of SocketCAN
+// frames, with the LINKTYPE_CAN_SOCKETCAN link type, as produced by
+// tcpdump, dumpcap and Wireshark on CAN interfaces.
+//
+// Each interface of a capture is described by an interface description
+// block, naming the interface of its packets.
+// Packets are stored in enhanced packet blocks holding their nanosecond
+// timestamp and their direction (the Tx flag of the records).
+//
+// The reader skips the packets of interfaces with other link types, and
+// names the records after the if_name option of their interface, or
+// after the index of their interface ("0", "1", ...) when the option is
+// missing.
+package pcapng
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"time"
+
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/pcap"
+)
+
+// block types
+const (
+	blockSHB = 0x0a0d0d0a // section header block
+	blockIDB = 0x00000001 // interface description block
+	blockPB  = 0x00000002 // packet block (obsolete)
+	blockSPB = 0x00000003 // simple packet block
+	blockEPB = 0x00000006 // enhanced packet block
+)
+
+// option codes
+const (
+	optEndOfOpt = 0
+	optIfName   = 2  // IDB: name of the interface
+	optTsResol  = 9  // IDB: resolution of the timestamps
+	optTsOffset = 14 // IDB: offset of the timestamps, in seconds
+	optFlags    = 2  // EPB: packet flags
+)
+
+const (
+	byteOrderMagic = 0x1a2b3c4d
+
+	flagInbound  = 1 // packet flags: inbound packet
+	flagOutbound = 2 // packet flags: outbound packet
+	flagDirMask  = 3
+
+	// maxBlockSize is the maximum size of the blocks handled by the
+	// reader.
+	maxBlockSize = 16 * 1024 * 1024
+)
+
+// iface describes an interface of a capture section.
+type iface struct {
+	name   string
+	link   uint16
+	resol  uint8 // if_tsresol option
+	offset int64 // if_tsoffset option
+}
+
+// Reader reads records from a pcapng capture file.
+type Reader struct {
+	r      *bufio.Reader
+	order  binary.ByteOrder
+	ifaces []iface
+	buf    []byte
+}
+
+// NewReader returns a new pcapng capture reader, reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next record of the capture, or io.EOF at the end of
+// the capture.
+func (r *Reader) Read() (canlog.Record, error) {
+	for {
+		typ, body, err := r.block()
+		if err != nil {
+			return canlog.Record{}, err
+		}
+		rec, ok, err := r.decode(typ, body)
+		if err != nil {
+			return rec, err
+		}
+		if ok {
+			return rec, nil
+		}
+	}
+}
+
+// block reads the next block of the capture, and returns its type and
+// body.
+func (r *Reader) block() (uint32, []byte, error) {
+	var hdr [12]byte
+	_, err := io.ReadFull(r.r, hdr[:8])
+	switch err {
+	case nil:
+	case io.EOF:
+		return 0, nil, io.EOF
+	default:
+		return 0, nil, fmt.Errorf("pcapng: could not read block header: %w", err)
+	}
+
+	// the type of the section header block reads the same in both byte
+	// orders.
+	if typ := binary.LittleEndian.Uint32(hdr[0:4]); typ == blockSHB {
+		_, err = io.ReadFull(r.r, hdr[8:12])
+		if err != nil {
+			return 0, nil, fmt.Errorf("pcapng: could not read section header: %w", noEOF(err))
+		}
+		switch {
+		case binary.LittleEndian.Uint32(hdr[8:12]) == byteOrderMagic:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(hdr[8:12]) == byteOrderMagic:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("pcapng: invalid byte-order magic 0x%x", hdr[8:12])
+		}
+		r.ifaces = r.ifaces[:0]
+	}
+	if r.order == nil {
+		return 0, nil, fmt.Errorf("pcapng: invalid section header block type 0x%x", hdr[0:4])
+	}
+
+	typ := r.order.Uint32(hdr[0:4])
+	size := r.order.Uint32(hdr[4:8])
+	hsize := uint32(8)
+	if typ == blockSHB {
+		hsize = 12
+	}
+	if size < hsize+4 || size%4 != 0 || size > maxBlockSize {
+		return 0, nil, fmt.Errorf("pcapng: invalid block size %d", size)
+	}
+	n := int(size - hsize)
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	r.buf = r.buf[:n]
+	_, err = io.ReadFull(r.r, r.buf)
+	if err != nil {
+		return 0, nil, fmt.Errorf("pcapng: could not read block: %w", noEOF(err))
+	}
+	if tail := r.order.Uint32(r.buf[n-4:]); tail != size {
+		return 0, nil, fmt.Errorf("pcapng: block size mismatch (%d != %d)", tail, size)
+	}
+	return typ, r.buf[:n-4], nil
+}
+
+// decode decodes a block, and reports whether it holds a CAN frame.
+func (r *Reader) decode(typ uint32, body []byte) (canlog.Record, bool, error) {
+	var rec canlog.Record
+	switch typ {
+	case blockSHB:
+		if len(body) < 12 {
+			return rec, false, fmt.Errorf("pcapng: invalid section header block")
+		}
+		if major := r.order.Uint16(body[0:2]); major != 1 {
+			return rec, false, fmt.Errorf("pcapng: unsupported version %d.%d", major, r.order.Uint16(body[2:4]))
+		}
+		return rec, false, nil
+
+	case blockIDB:
+		if len(body) < 8 {
+			return rec, false, fmt.Errorf("pcapng: invalid interface description block")
+		}
+		ifc := iface{
+			name:  strconv.Itoa(len(r.ifaces)),
+			link:  r.order.Uint16(body[0:2]),
+			resol: 6,
+		}
+		err := r.options(body[8:], func(code uint16, val []byte) error {
+			switch code {
+			case optIfName:
+				ifc.name = string(bytes.TrimRight(val, "\x00"))
+			case optTsResol:
+				if len(val) != 1 {
+					return fmt.Errorf("pcapng: invalid if_tsresol option")
+				}
+				ifc.resol = val[0]
+			case optTsOffset:
+				if len(val) != 8 {
+					return fmt.Errorf("pcapng: invalid if_tsoffset option")
+				}
+				ifc.offset = int64(r.order.Uint64(val))
+			}
+			return nil
+		})
+		if err != nil {
+			return rec, false, err
+		}
+		r.ifaces = append(r.ifaces, ifc)
+		return rec, false, nil
+
+	case blockEPB, blockPB:
+		if len(body) < 20 {
+			return rec, false, fmt.Errorf("pcapng: invalid packet block")
+		}
+		var id int
+		switch typ {
+		case blockEPB:
+			id = int(r.order.Uint32(body[0:4]))
+		default:
+			id = int(r.order.Uint16(body[0:2]))
+		}
+		var (
+			ts   = uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+			size = int(r.order.Uint32(body[12:16]))
+			data = body[20:]
+		)
+		if size > len(data) {
+			return rec, false, fmt.Errorf("pcapng: invalid packet size %d", size)
+		}
+		var opts []byte
+		if n := (size + 3) &^ 3; n < len(data) {
+			opts = data[n:]
+		}
+		data = data[:size]
+
+		if id >= len(r.ifaces) {
+			return rec, false, fmt.Errorf("pcapng: invalid interface %d", id)
+		}
+		ifc := r.ifaces[id]
+		if ifc.link != pcap.LinkType {
+			return rec, false, nil
+		}
+		var err error
+		rec.Time, err = ifc.time(ts)
+		if err != nil {
+			return rec, false, err
+		}
+		err = r.options(opts, func(code uint16, val []byte) error {
+			if code == optFlags && len(val) == 4 && r.order.Uint32(val)&flagDirMask == flagOutbound {
+				rec.Flags |= canlog.Tx
+			}
+			return nil
+		})
+		if err != nil {
+			return rec, false, err
+		}
+		return r.frame(rec, ifc, data)
+
+	case blockSPB:
+		if len(body) < 4 {
+			return rec, false, fmt.Errorf("pcapng: invalid simple packet block")
+		}
+		if len(r.ifaces) == 0 {
+			return rec, false, fmt.Errorf("pcapng: invalid interface 0")
+		}
+		ifc := r.ifaces[0]
+		if ifc.link != pcap.LinkType {
+			return rec, false, nil
+		}
+		// simple packets have no timestamp, and their captured size is
+		// only bounded by the size of the block.
+		size := int(r.order.Uint32(body[0:4]))
+		data := body[4:]
+		if size < len(data) {
+			data = data[:size]
+		}
+		return r.frame(rec, ifc, data)
+
+	default:
+		return rec, false, nil
+	}
+}
+
+func (r *Reader) frame(rec canlog.Record, ifc iface, data []byte) (canlog.Record, bool, error) {
+	frame, flags, err := pcap.DecodeFrame(data)
+	if err != nil {
+		return rec, false, fmt.Errorf("pcapng: could not decode packet: %w", err)
+	}
+	rec.Iface = ifc.name
+	rec.Flags |= flags
+	rec.Frame = frame
+	return rec, true, nil
+}
+
+// options calls f with the code and value of each option.
+func (r *Reader) options(p []byte, f func(code uint16, val []byte) error) error {
+	for len(p) >= 4 {
+		code := r.order.Uint16(p[0:2])
+		n := int(r.order.Uint16(p[2:4]))
+		if code == optEndOfOpt {
+			return nil
+		}
+		p = p[4:]
+		if n > len(p) {
+			return fmt.Errorf("pcapng: invalid option size %d", n)
+		}
+		err := f(code, p[:n])
+		if err != nil {
+			return err
+		}
+		n = (n + 3) &^ 3
+		if n > len(p) {
+			n = len(p)
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// time returns the time of a timestamp of the interface.
+func (ifc iface) time(ts uint64) (time.Time, error) {
+	var sec, nsec uint64
+	switch v := uint(ifc.resol & 0x7f); {
+	case ifc.resol&0x80 != 0:
+		// negative power of 2.
+		if v >= 64 {
+			return time.Time{}, fmt.Errorf("pcapng: invalid timestamp resolution 0x%x", ifc.resol)
+		}
+		sec = ts >> v
+		hi, lo := bits.Mul64(ts&(1<<v-1), uint64(time.Second))
+		nsec = lo >> v
+		if v > 0 {
+			nsec |= hi << (64 - v)
+		}
+	case v <= 9:
+		unit := pow10(9 - v)
+		sec = ts / (uint64(time.Second) / unit)
+		nsec = ts % (uint64(time.Second) / unit) * unit
+	case v <= 19:
+		unit := pow10(v)
+		sec = ts / unit
+		nsec = ts % unit / pow10(v-9)
+	default:
+		return time.Time{}, fmt.Errorf("pcapng: invalid timestamp resolution %d", ifc.resol)
+	}
+	return time.Unix(ifc.offset+int64(sec), int64(nsec)), nil
+}
+
+func pow10(n uint) uint64 {
+	v := uint64(1)
+	for i := uint(0); i < n; i++ {
+		v *= 10
+	}
+	return v
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
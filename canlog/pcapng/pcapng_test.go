@@ -0,0 +1,297 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pcapng_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/pcapng"
+)
+
+func readAll(t *testing.T, r canlog.Reader) []canlog.Record {
+	t.Helper()
+	var recs []canlog.Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("could not read record: %+v", err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	t0 := time.Unix(1660000000, 123456789)
+	recs := []canlog.Record{
+		{
+			Time: t0, Iface: "can0",
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		},
+		{
+			Time: t0.Add(1234 * time.Nanosecond), Iface: "vcan1", Flags: canlog.Tx,
+			Frame: canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+		{
+			Time: t0.Add(2 * time.Millisecond), Iface: "can0", Flags: canlog.Ext,
+			Frame: canbus.Frame{ID: 0x100, Kind: canbus.RTR, Data: make([]byte, 8)},
+		},
+		{
+			Time: t0.Add(3 * time.Millisecond), Iface: "vcan1", Flags: canlog.FD | canlog.ESI | canlog.Tx,
+			Frame: canbus.Frame{ID: 0x321, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		},
+		{
+			Time: t0.Add(time.Second), Iface: "can0",
+			Frame: canbus.Frame{ID: 0x004, Kind: canbus.ERR, Data: []byte{0, 0, 0x80, 0, 0, 0, 0, 0}},
+		},
+	}
+
+	var buf bytes.Buffer
+	w := pcapng.NewWriter(&buf)
+	for _, rec := range recs {
+		err := w.Write(rec)
+		if err != nil {
+			t.Fatalf("could not write record: %+v", err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("could not close writer: %+v", err)
+	}
+
+	// section header, 2 interface descriptions, 5 packets.
+	if got, want := buf.Len(), 28+40+44+4*60+116; got != want {
+		t.Fatalf("invalid capture size: got=%d, want=%d", got, want)
+	}
+
+	got := readAll(t, pcapng.NewReader(&buf))
+	if !reflect.DeepEqual(got, recs) {
+		t.Fatalf("invalid records:\ngot= %+v\nwant=%+v", got, recs)
+	}
+}
+
+// builder builds big-endian pcapng blocks.
+type builder struct {
+	bytes.Buffer
+}
+
+func (b *builder) block(typ uint32, body []byte) {
+	size := uint32(12 + len(body))
+	for _, v := range []uint32{typ, size} {
+		binary.Write(b, binary.BigEndian, v)
+	}
+	b.Write(body)
+	binary.Write(b, binary.BigEndian, size)
+}
+
+func (b *builder) shb() {
+	b.block(0x0a0d0d0a, []byte{
+		0x1a, 0x2b, 0x3c, 0x4d, 0, 1, 0, 0,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	})
+}
+
+func (b *builder) idb(link uint16, opts ...byte) {
+	body := []byte{byte(link >> 8), byte(link), 0, 0, 0, 0, 0, 0}
+	b.block(1, append(body, opts...))
+}
+
+func (b *builder) epb(id, hi, lo uint32, data []byte, opts ...byte) {
+	body := make([]byte, 20)
+	for i, v := range []uint32{id, hi, lo, uint32(len(data)), uint32(len(data))} {
+		binary.BigEndian.PutUint32(body[4*i:], v)
+	}
+	body = append(body, data...)
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	b.block(6, append(body, opts...))
+}
+
+func TestRead(t *testing.T) {
+	var (
+		b   builder
+		sff = []byte{0, 0, 0x01, 0x23, 2, 0, 0, 0, 0xca, 0xfe, 0, 0, 0, 0, 0, 0}
+	)
+	b.shb()
+	// unnamed interface, with the default µs resolution.
+	b.idb(227)
+	// ethernet interface.
+	b.idb(1, 0, 2, 0, 4, 'e', 't', 'h', '0', 0, 0, 0, 0)
+	// interface with a 2^-10 s resolution, and a 1000s offset.
+	b.idb(227,
+		0, 2, 0, 5, 'c', 'a', 'n', '1', 0, 0, 0, 0,
+		0, 9, 0, 1, 0x8a, 0, 0, 0,
+		0, 14, 0, 8, 0, 0, 0, 0, 0, 0, 0x03, 0xe8,
+		0, 0, 0, 0,
+	)
+	b.epb(0, 0, 1500000, sff)
+	b.epb(1, 0, 0, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	b.epb(2, 0, 1536, sff, 0, 2, 0, 4, 0, 0, 0, 2, 0, 0, 0, 0)
+	// custom block.
+	b.block(0x40000bad, make([]byte, 8))
+	// new section, with a simple packet block.
+	b.shb()
+	b.idb(227, 0, 2, 0, 4, 'c', 'a', 'n', '2')
+	b.block(3, append([]byte{0, 0, 0, 16}, sff...))
+
+	frame := canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xca, 0xfe}}
+	want := []canlog.Record{
+		{Time: time.Unix(1, 500000000), Iface: "0", Frame: frame},
+		{Time: time.Unix(1001, 500000000), Iface: "can1", Flags: canlog.Tx, Frame: frame},
+		{Time: time.Time{}, Iface: "can2", Frame: frame},
+	}
+	got := readAll(t, pcapng.NewReader(&b))
+	if len(got) != len(want) {
+		t.Fatalf("invalid number of records: got=%d, want=%d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Time.Equal(want[i].Time) {
+			t.Fatalf("invalid time for record %d: got=%v, want=%v", i, got[i].Time, want[i].Time)
+		}
+		got[i].Time = want[i].Time
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Fatalf("invalid record %d:\ngot= %+v\nwant=%+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	shb := func() *builder {
+		b := new(builder)
+		b.shb()
+		return b
+	}
+	for _, tc := range []struct {
+		name string
+		src  func() []byte
+		err  string
+	}{
+		{
+			name: "section",
+			src:  func() []byte { return []byte{1, 0, 0, 0, 12, 0, 0, 0} },
+			err:  "pcapng: invalid section header block type 0x01000000",
+		},
+		{
+			name: "byte-order",
+			src:  func() []byte { return []byte{0x0a, 0x0d, 0x0d, 0x0a, 0, 0, 0, 28, 1, 2, 3, 4} },
+			err:  "pcapng: invalid byte-order magic 0x01020304",
+		},
+		{
+			name: "block-size",
+			src: func() []byte {
+				b := shb()
+				b.Write([]byte{0, 0, 0, 6, 0, 0, 0, 13})
+				return b.Bytes()
+			},
+			err: "pcapng: invalid block size 13",
+		},
+		{
+			name: "block",
+			src: func() []byte {
+				b := shb()
+				b.Write([]byte{0, 0, 0, 6, 0, 0, 0, 16, 0})
+				return b.Bytes()
+			},
+			err: "pcapng: could not read block: unexpected EOF",
+		},
+		{
+			name: "block-size-mismatch",
+			src: func() []byte {
+				b := shb()
+				b.Write([]byte{0, 0, 0, 1, 0, 0, 0, 12, 0, 0, 0, 16})
+				return b.Bytes()
+			},
+			err: "pcapng: block size mismatch (16 != 12)",
+		},
+		{
+			name: "interface",
+			src: func() []byte {
+				b := shb()
+				b.epb(0, 0, 0, nil)
+				return b.Bytes()
+			},
+			err: "pcapng: invalid interface 0",
+		},
+		{
+			name: "packet-size",
+			src: func() []byte {
+				b := shb()
+				b.idb(227)
+				b.block(6, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 4})
+				return b.Bytes()
+			},
+			err: "pcapng: invalid packet size 4",
+		},
+		{
+			name: "option",
+			src: func() []byte {
+				b := shb()
+				b.idb(227, 0, 2, 0, 8, 'c', 'a', 'n', '0')
+				return b.Bytes()
+			},
+			err: "pcapng: invalid option size 8",
+		},
+		{
+			name: "frame",
+			src: func() []byte {
+				b := shb()
+				b.idb(227)
+				b.epb(0, 0, 0, []byte{1, 2, 3, 4})
+				return b.Bytes()
+			},
+			err: "pcapng: could not decode packet: pcap: invalid SocketCAN frame size 4",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := pcapng.NewReader(bytes.NewReader(tc.src())).Read()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	for _, tc := range []struct {
+		name string
+		rec  canlog.Record
+		err  string
+	}{
+		{
+			name: "timestamp",
+			rec:  canlog.Record{Time: time.Unix(-1, 0)},
+			err:  "pcapng: invalid timestamp " + time.Unix(-1, 0).String(),
+		},
+		{
+			name: "id",
+			rec:  canlog.Record{Time: t0, Frame: canbus.Frame{ID: 0x800}},
+			err:  "pcap: invalid frame identifier 0x800",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := pcapng.NewWriter(io.Discard).Write(tc.rec)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pcapng
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/pcap"
+)
+
+// snapLen is the snapshot length of the interfaces of the captures
+// produced by the writer.
+const snapLen = 262144
+
+// Writer writes records to a pcapng capture file.
+//
+// The capture is made of a single section, with an interface
+// description block written for each interface name, before its first
+// packet.
+type Writer struct {
+	w      *bufio.Writer
+	shb    bool
+	ifaces map[string]uint32
+	buf    []byte
+}
+
+// NewWriter returns a new pcapng capture writer, writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:      bufio.NewWriter(w),
+		ifaces: make(map[string]uint32),
+	}
+}
+
+// Write writes the record to the capture, with a nanosecond
+// resolution.
+// The interface of the record is described by an interface description
+// block the first time it is encountered.
+func (w *Writer) Write(rec canlog.Record) error {
+	if !w.shb {
+		err := w.writeSHB()
+		if err != nil {
+			return err
+		}
+	}
+
+	ns := rec.Time.UnixNano()
+	if ns < 0 {
+		return fmt.Errorf("pcapng: invalid timestamp %v", rec.Time)
+	}
+
+	var err error
+	w.buf = append(w.buf[:0], make([]byte, 28)...)
+	w.buf, err = pcap.AppendFrame(w.buf, rec.Frame, rec.Flags)
+	if err != nil {
+		return err
+	}
+	size := len(w.buf) - 28
+	w.buf = pad(w.buf)
+
+	id, ok := w.ifaces[rec.Iface]
+	if !ok {
+		id = uint32(len(w.ifaces))
+		err = w.writeIDB(rec.Iface)
+		if err != nil {
+			return err
+		}
+		w.ifaces[rec.Iface] = id
+	}
+
+	flags := uint32(flagInbound)
+	if rec.Flags&canlog.Tx != 0 {
+		flags = flagOutbound
+	}
+	var opt [4]byte
+	binary.LittleEndian.PutUint32(opt[:], flags)
+	w.buf = appendOption(w.buf, optFlags, opt[:])
+	w.buf = appendOption(w.buf, optEndOfOpt, nil)
+
+	p := w.buf
+	binary.LittleEndian.PutUint32(p[0:4], blockEPB)
+	binary.LittleEndian.PutUint32(p[8:12], id)
+	binary.LittleEndian.PutUint32(p[12:16], uint32(uint64(ns)>>32))
+	binary.LittleEndian.PutUint32(p[16:20], uint32(ns))
+	binary.LittleEndian.PutUint32(p[20:24], uint32(size))
+	binary.LittleEndian.PutUint32(p[24:28], uint32(size))
+	return w.writeBlock("packet")
+}
+
+// writeSHB writes the section header block of the capture.
+func (w *Writer) writeSHB() error {
+	w.shb = true
+	w.buf = append(w.buf[:0], make([]byte, 24)...)
+	p := w.buf
+	binary.LittleEndian.PutUint32(p[0:4], blockSHB)
+	binary.LittleEndian.PutUint32(p[8:12], byteOrderMagic)
+	binary.LittleEndian.PutUint16(p[12:14], 1)
+	binary.LittleEndian.PutUint16(p[14:16], 0)
+	binary.LittleEndian.PutUint64(p[16:24], ^uint64(0)) // unspecified section length
+	return w.writeBlock("section header")
+}
+
+// writeIDB writes the interface description block of the named
+// interface.
+func (w *Writer) writeIDB(name string) error {
+	var buf []byte
+	buf = append(buf, make([]byte, 16)...)
+	binary.LittleEndian.PutUint32(buf[0:4], blockIDB)
+	binary.LittleEndian.PutUint16(buf[8:10], pcap.LinkType)
+	binary.LittleEndian.PutUint32(buf[12:16], snapLen)
+	if name != "" {
+		buf = appendOption(buf, optIfName, []byte(name))
+	}
+	buf = appendOption(buf, optTsResol, []byte{9})
+	buf = appendOption(buf, optEndOfOpt, nil)
+
+	// the packet block being built is kept aside.
+	pkt := w.buf
+	w.buf = buf
+	err := w.writeBlock("interface description")
+	w.buf = pkt
+	return err
+}
+
+// writeBlock completes the block held by the buffer with its size, and
+// writes it.
+func (w *Writer) writeBlock(kind string) error {
+	size := uint32(len(w.buf) + 4)
+	binary.LittleEndian.PutUint32(w.buf[4:8], size)
+	w.buf = append(w.buf, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(w.buf[size-4:], size)
+	_, err := w.w.Write(w.buf)
+	if err != nil {
+		return fmt.Errorf("pcapng: could not write %s block: %w", kind, err)
+	}
+	return nil
+}
+
+// Flush writes the buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	if !w.shb {
+		err := w.writeSHB()
+		if err != nil {
+			return err
+		}
+	}
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("pcapng: could not flush capture: %w", err)
+	}
+	return nil
+}
+
+// Close writes the pending records to the underlying writer.
+func (w *Writer) Close() error {
+	if !w.shb {
+		err := w.writeSHB()
+		if err != nil {
+			return err
+		}
+	}
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("pcapng: could not flush capture: %w", err)
+	}
+	return nil
+}
+
+func appendOption(buf []byte, code uint16, val []byte) []byte {
+	var hdr [4]byte
+	binary.LittleEndian.PutUint16(hdr[0:2], code)
+	binary.LittleEndian.PutUint16(hdr[2:4], uint16(len(val)))
+	buf = append(buf, hdr[:]...)
+	buf = append(buf, val...)
+	return pad(buf)
+}
+
+// pad pads buf with zeros to a multiple of 4 bytes.
+func pad(buf []byte) []byte {
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
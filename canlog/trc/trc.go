@@ -0,0 +1,549 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package trc reads and writes PEAK-System trace (TRC) CAN bus log
+// files, as produced and read by PCAN-View and PCAN-Explorer:
+//
+//	;$FILEVERSION=2.1
+//	;$STARTTIME=44781.9629629630
+//	;$COLUMNS=N,O,T,B,I,d,R,L,D
+//	;
+//	;   Start time: 08.08.2022 23:06:40.000.0
+//	;---+-- ------+------ +- +- --+----- +- +- +--- +- -- -- -- -- -- -- --
+//	      1         0.000 DT 1      0123 Rx -  4    DE AD BE EF
+//	      2         1.234 DT 2  18FEF100 Tx -  8    01 02 03 04 05 06 07 08
+//	      3         2.345 RR 2  00000100 Rx -  8
+//	      4         3.456 FB 1      0321 Rx -  9    11 22 33 44 55 66 77 88 99 AA BB CC
+//	      5     12004.567 ER 1         - Rx -  0
+//
+// The reader handles the versions 1.0 to 2.1 of the format. The writer
+// produces version 2.1 files.
+//
+// Records read from TRC files are named after their bus number ("1",
+// "2", ...), or have an empty interface name for the versions of the
+// format not recording the bus. Their timestamps are relative to the
+// start time of the file, in the local time zone, rounded to the
+// millisecond.
+// Events other than frames, such as status changes and error counters,
+// are skipped.
+package trc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+const (
+	effMask = 0x1fffffff
+	sffMask = 0x7ff
+
+	// oleEpoch is the number of days between the epoch of OLE
+	// automation dates, used by the start time of TRC files
+	// (1899-12-30), and the Unix epoch.
+	oleEpoch = 25569
+)
+
+// Default columns of the versions of the format:
+//
+//	N: message number
+//	O: time offset, in milliseconds
+//	T: type of the message (direction, for versions 1.x)
+//	B: bus
+//	I: identifier
+//	d: direction
+//	R: reserved
+//	l: data length
+//	L: data length code
+//	D: data bytes
+var columns = map[string]string{
+	"1.0": "NOILD",
+	"1.1": "NOTILD",
+	"1.2": "NOBTILD",
+	"1.3": "NOBTIRLD",
+	"2.0": "NOTIdlD",
+	"2.1": "NOTBIdRLD",
+}
+
+// Reader reads records from a TRC log file.
+type Reader struct {
+	sc   *bufio.Scanner
+	line int
+
+	version string
+	cols    string
+	start   time.Time
+}
+
+// NewReader returns a new TRC log reader, reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{sc: bufio.NewScanner(r), version: "1.0", cols: columns["1.0"]}
+}
+
+func (r *Reader) errorf(format string, args ...any) error {
+	return fmt.Errorf("trc: line %d: %s", r.line, fmt.Sprintf(format, args...))
+}
+
+// Read returns the next frame of the log, or io.EOF at the end of the
+// log.
+func (r *Reader) Read() (canlog.Record, error) {
+	for r.sc.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.sc.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] == ';' {
+			err := r.parseHeader(line[1:])
+			if err != nil {
+				return canlog.Record{}, err
+			}
+			continue
+		}
+		rec, ok, err := r.parseMessage(strings.Fields(line))
+		if err != nil {
+			return rec, err
+		}
+		if ok {
+			return rec, nil
+		}
+	}
+	if err := r.sc.Err(); err != nil {
+		return canlog.Record{}, fmt.Errorf("trc: could not read log: %w", err)
+	}
+	return canlog.Record{}, io.EOF
+}
+
+func (r *Reader) parseHeader(line string) error {
+	if !strings.HasPrefix(line, "$") {
+		return nil
+	}
+	i := strings.Index(line, "=")
+	if i < 0 {
+		return nil
+	}
+	key, val := line[1:i], strings.TrimSpace(line[i+1:])
+	switch key {
+	case "FILEVERSION":
+		cols, ok := columns[val]
+		if !ok {
+			return r.errorf("unsupported version %q", val)
+		}
+		r.version = val
+		r.cols = cols
+	case "STARTTIME":
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil || v < 0 {
+			return r.errorf("invalid start time %q", val)
+		}
+		days := math.Floor(v)
+		ms := math.Round((v - days) * 86400e3)
+		r.start = time.Date(1970, time.January, 1+int(days)-oleEpoch, 0, 0, 0, int(ms)*1e6, time.Local)
+	case "COLUMNS":
+		cols := strings.ReplaceAll(val, ",", "")
+		if !strings.HasSuffix(cols, "D") || strings.Trim(cols, "NOTBIdRlLD") != "" {
+			return r.errorf("invalid columns %q", val)
+		}
+		r.cols = cols
+	}
+	return nil
+}
+
+// parseMessage parses a message line.
+// parseMessage reports whether the message is a frame.
+func (r *Reader) parseMessage(toks []string) (canlog.Record, bool, error) {
+	var (
+		rec  canlog.Record
+		id   = ""
+		n    = -1
+		data []string
+		v1   = r.version[0] == '1'
+	)
+	rec.Frame.Kind = canbus.SFF
+	for i := 0; i < len(r.cols); i++ {
+		col := r.cols[i]
+		if col == 'D' {
+			data = toks
+			break
+		}
+		if len(toks) == 0 {
+			return rec, false, r.errorf("missing columns")
+		}
+		tok := toks[0]
+		toks = toks[1:]
+		switch col {
+		case 'O':
+			dt, err := parseOffset(tok)
+			if err != nil {
+				return rec, false, r.errorf("invalid time offset %q", tok)
+			}
+			rec.Time = r.start.Add(dt)
+		case 'T':
+			ok := r.parseType(&rec, tok)
+			if !ok {
+				return rec, false, nil
+			}
+			if rec.Frame.Kind == canbus.ERR && !v1 {
+				// the other columns of error frames describe the
+				// error, and may be empty.
+				return r.parseError(rec, toks), true, nil
+			}
+		case 'B':
+			ch, err := strconv.Atoi(tok)
+			if err != nil || ch <= 0 {
+				return rec, false, r.errorf("invalid bus %q", tok)
+			}
+			rec.Iface = tok
+		case 'I':
+			id = tok
+		case 'd':
+			tx, ok := parseDir(tok)
+			if !ok {
+				return rec, false, r.errorf("invalid direction %q", tok)
+			}
+			if tx {
+				rec.Flags |= canlog.Tx
+			}
+		case 'l':
+			v, err := strconv.ParseUint(tok, 10, 8)
+			if err != nil || v > canlog.MaxLen {
+				return rec, false, r.errorf("invalid data length %q", tok)
+			}
+			n = int(v)
+		case 'L':
+			v, err := strconv.ParseUint(tok, 10, 8)
+			if err != nil || v > 15 {
+				return rec, false, r.errorf("invalid data length code %q", tok)
+			}
+			n = int(v)
+			if rec.Flags&canlog.FD != 0 {
+				n = canlog.Len(uint8(v))
+			}
+		}
+	}
+
+	if rec.Frame.Kind == canbus.ERR {
+		rec.Frame.Data = []byte{}
+		return rec, true, nil
+	}
+
+	v, err := strconv.ParseUint(id, 16, 32)
+	if err != nil || v > effMask {
+		return rec, false, r.errorf("invalid identifier %q", id)
+	}
+	rec.Frame.ID = uint32(v)
+	ext := len(id) > 4 || v > sffMask
+	if ext && rec.Frame.Kind == canbus.SFF {
+		rec.Frame.Kind = canbus.EFF
+	}
+
+	if n < 0 {
+		return rec, false, r.errorf("missing data length")
+	}
+	if rec.Flags&canlog.FD == 0 && n > 8 {
+		n = 8
+	}
+	if v1 && len(data) > 0 && data[0] == "RTR" {
+		rec.Frame.Kind = canbus.RTR
+	}
+	if rec.Frame.Kind == canbus.RTR {
+		if ext {
+			rec.Flags |= canlog.Ext
+		}
+		rec.Frame.Data = make([]byte, n)
+		return rec, true, nil
+	}
+
+	if len(data) < n {
+		return rec, false, r.errorf("missing data bytes")
+	}
+	rec.Frame.Data = make([]byte, n)
+	for i, tok := range data[:n] {
+		v, err := strconv.ParseUint(tok, 16, 8)
+		if err != nil {
+			return rec, false, r.errorf("invalid data byte %q", tok)
+		}
+		rec.Frame.Data[i] = byte(v)
+	}
+	return rec, true, nil
+}
+
+// parseType parses the type of a message.
+// parseType reports whether the message is a frame.
+func (r *Reader) parseType(rec *canlog.Record, tok string) bool {
+	if r.version[0] == '1' {
+		switch tok {
+		case "Rx":
+		case "Tx":
+			rec.Flags |= canlog.Tx
+		case "Error":
+			rec.Frame.Kind = canbus.ERR
+		default:
+			return false
+		}
+		return true
+	}
+
+	switch tok {
+	case "DT":
+	case "FD":
+		rec.Flags |= canlog.FD
+	case "FB":
+		rec.Flags |= canlog.FD | canlog.BRS
+	case "FE":
+		rec.Flags |= canlog.FD | canlog.ESI
+	case "BI":
+		rec.Flags |= canlog.FD | canlog.BRS | canlog.ESI
+	case "RR":
+		rec.Frame.Kind = canbus.RTR
+	case "ER":
+		rec.Frame.Kind = canbus.ERR
+	default:
+		return false
+	}
+	return true
+}
+
+// parseError parses the columns following the type of an error frame,
+// looking for its bus and direction.
+func (r *Reader) parseError(rec canlog.Record, toks []string) canlog.Record {
+	rec.Frame.Data = []byte{}
+	i := strings.IndexByte(r.cols, 'T')
+	if strings.IndexByte(r.cols, 'B') == i+1 && len(toks) > 0 {
+		if ch, err := strconv.Atoi(toks[0]); err == nil && ch > 0 {
+			rec.Iface = toks[0]
+		}
+	}
+	for _, tok := range toks {
+		if tx, ok := parseDir(tok); ok {
+			if tx {
+				rec.Flags |= canlog.Tx
+			}
+			break
+		}
+	}
+	return rec
+}
+
+func parseDir(s string) (tx bool, ok bool) {
+	switch s {
+	case "Rx":
+		return false, true
+	case "Tx":
+		return true, true
+	}
+	return false, false
+}
+
+// parseOffset parses a decimal number of milliseconds.
+func parseOffset(s string) (time.Duration, error) {
+	ms, frac := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		ms, frac = s[:i], s[i+1:]
+	}
+	if len(frac) > 6 {
+		frac = frac[:6]
+	}
+	v, err := strconv.ParseUint(ms, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	var ns uint64
+	if frac != "" {
+		ns, err = strconv.ParseUint(frac+strings.Repeat("0", 6-len(frac)), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return time.Duration(v)*time.Millisecond + time.Duration(ns), nil
+}
+
+// Writer writes records to a TRC log file.
+type Writer struct {
+	w     *bufio.Writer
+	start time.Time
+	hdr   bool
+	n     int
+	chans canlog.Channels
+	err   error
+}
+
+// NewWriter returns a new TRC log writer, writing version 2.1 files to w.
+//
+// The measurement starts at the time of the first record written.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+func (w *Writer) header(start time.Time) {
+	w.start = start.Truncate(time.Millisecond)
+	w.hdr = true
+
+	t := w.start.Local()
+	y, m, d := t.Date()
+	days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix()/86400 + oleEpoch
+	h, min, s := t.Clock()
+	ms := h*3600000 + min*60000 + s*1000 + t.Nanosecond()/1e6
+
+	w.printf(";$FILEVERSION=2.1\n")
+	w.printf(";$STARTTIME=%.10f\n", float64(days)+float64(ms)/86400e3)
+	w.printf(";$COLUMNS=N,O,T,B,I,d,R,L,D\n")
+	w.printf(";\n")
+	w.printf(";   Start time: %s.0\n", t.Format("02.01.2006 15:04:05.000"))
+	w.printf(";   Generated by github.com/go-daq/canbus\n")
+	w.printf(";-------------------------------------------------------------------------------\n")
+	w.printf(";   Message   Time    Type    ID     Rx/Tx\n")
+	w.printf(";   Number    Offset  |  Bus  [hex]  |  Reserved\n")
+	w.printf(";   |         [ms]    |  |    |      |  |  Data Length Code\n")
+	w.printf(";   |         |       |  |    |      |  |  |    Data [hex] ...\n")
+	w.printf(";   |         |       |  |    |      |  |  |    |\n")
+	w.printf(";---+-- ------+------ +- +- --+----- +- +- +--- +- -- -- -- -- -- -- --\n")
+}
+
+// Write writes the record to the log, with a microsecond resolution.
+// Records must not precede the first record of the log.
+//
+// Records are written on the bus numbers assigned by a canlog.Channels
+// to their interface names.
+// The data of error frames is not written.
+func (w *Writer) Write(rec canlog.Record) error {
+	if !w.hdr {
+		w.header(rec.Time)
+	}
+	dt := rec.Time.Sub(w.start)
+	if dt < 0 {
+		return fmt.Errorf("trc: record at %v precedes the start of the measurement", rec.Time)
+	}
+
+	var (
+		frame = rec.Frame
+		ch    = w.chans.Channel(rec.Iface)
+		typ   = "DT"
+		dir   = "Rx"
+		ext   bool
+		max   = 8
+		fd    = rec.Flags&canlog.FD != 0
+		data  = frame.Data
+	)
+	if rec.Flags&canlog.Tx != 0 {
+		dir = "Tx"
+	}
+	switch frame.Kind {
+	case canbus.SFF:
+	case canbus.EFF:
+		ext = true
+	case canbus.RTR:
+		ext = rec.Flags&canlog.Ext != 0
+		typ = "RR"
+	case canbus.ERR:
+		typ = "ER"
+	default:
+		return fmt.Errorf("trc: invalid frame kind %v", frame.Kind)
+	}
+	if frame.Kind != canbus.ERR && (ext && frame.ID > effMask || !ext && frame.ID > sffMask) {
+		return fmt.Errorf("trc: invalid frame identifier 0x%x", frame.ID)
+	}
+	if fd {
+		switch {
+		case frame.Kind == canbus.RTR:
+			return fmt.Errorf("trc: invalid CAN FD frame kind %v", frame.Kind)
+		case frame.Kind == canbus.ERR:
+		case rec.Flags&(canlog.BRS|canlog.ESI) == canlog.BRS|canlog.ESI:
+			typ = "BI"
+		case rec.Flags&canlog.BRS != 0:
+			typ = "FB"
+		case rec.Flags&canlog.ESI != 0:
+			typ = "FE"
+		default:
+			typ = "FD"
+		}
+		max = canlog.MaxLen
+	}
+	if len(data) > max {
+		return fmt.Errorf("trc: invalid frame length %d", len(data))
+	}
+
+	var (
+		id  = "-"
+		dlc = len(data)
+	)
+	switch {
+	case frame.Kind == canbus.ERR:
+		data, dlc = nil, 0
+	case frame.Kind == canbus.RTR:
+		data = nil
+	case fd:
+		dlc = int(canlog.DLC(len(data)))
+		if n := canlog.Len(uint8(dlc)); n != len(data) {
+			// pad the payload to a valid CAN FD length.
+			data = append(data[:len(data):len(data)], make([]byte, n-len(data))...)
+		}
+	}
+	switch {
+	case frame.Kind == canbus.ERR:
+	case ext:
+		id = fmt.Sprintf("%08X", frame.ID)
+	default:
+		id = fmt.Sprintf("%04X", frame.ID)
+	}
+
+	w.n++
+	line := make([]byte, 0, 64+3*len(data))
+	line = append(line, fmt.Sprintf(
+		"%7d %9d.%03d %s %-2d %8s %s -  %-4d",
+		w.n, dt/time.Millisecond, (dt%time.Millisecond)/time.Microsecond,
+		typ, ch, id, dir, dlc,
+	)...)
+	for _, b := range data {
+		line = append(line, fmt.Sprintf(" %02X", b)...)
+	}
+	w.printf("%s\n", strings.TrimRight(string(line), " "))
+	if w.err != nil {
+		return fmt.Errorf("trc: could not write record: %w", w.err)
+	}
+	return nil
+}
+
+// Flush writes the buffered records to the underlying writer.
+// The log is not terminated, and can still be written to.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return fmt.Errorf("trc: could not write log: %w", w.err)
+	}
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("trc: could not flush log: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the records to the underlying writer.
+// The measurement of an empty log starts at the time Close is called.
+func (w *Writer) Close() error {
+	if !w.hdr {
+		w.header(time.Now())
+	}
+	if w.err != nil {
+		return fmt.Errorf("trc: could not write log: %w", w.err)
+	}
+	err := w.w.Flush()
+	if err != nil {
+		return fmt.Errorf("trc: could not flush log: %w", err)
+	}
+	return nil
+}
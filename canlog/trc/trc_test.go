@@ -0,0 +1,339 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trc_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/trc"
+)
+
+func readAll(t *testing.T, r canlog.Reader) []canlog.Record {
+	t.Helper()
+	var recs []canlog.Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("could not read record: %+v", err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func compare(t *testing.T, got, want []canlog.Record) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("invalid number of records: got=%d, want=%d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Time.Equal(want[i].Time) {
+			t.Fatalf("invalid time for record %d: got=%v, want=%v", i, got[i].Time, want[i].Time)
+		}
+		got[i].Time = want[i].Time
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Fatalf("invalid record %d:\ngot= %+v\nwant=%+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	t0 := time.Date(2022, time.August, 8, 23, 6, 40, 0, time.Local)
+	recs := []canlog.Record{
+		{
+			Time: t0, Iface: "can0",
+			Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		},
+		{
+			Time: t0.Add(1234 * time.Microsecond), Iface: "can1", Flags: canlog.Tx,
+			Frame: canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+		{
+			Time: t0.Add(2 * time.Millisecond), Iface: "can0",
+			Frame: canbus.Frame{ID: 0x7ff, Kind: canbus.SFF, Data: []byte{}},
+		},
+		{
+			Time: t0.Add(2345 * time.Microsecond), Iface: "can1", Flags: canlog.Ext,
+			Frame: canbus.Frame{ID: 0x100, Kind: canbus.RTR, Data: make([]byte, 8)},
+		},
+		{
+			Time: t0.Add(3456 * time.Microsecond), Iface: "can0", Flags: canlog.FD | canlog.BRS,
+			Frame: canbus.Frame{
+				ID: 0x321, Kind: canbus.SFF,
+				Data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc},
+			},
+		},
+		{
+			Time: t0.Add(3500 * time.Microsecond), Iface: "can1", Flags: canlog.FD | canlog.ESI | canlog.Tx,
+			Frame: canbus.Frame{ID: 0xabcd, Kind: canbus.EFF, Data: []byte{}},
+		},
+		{
+			Time: t0.Add(3600 * time.Microsecond), Iface: "can0", Flags: canlog.FD,
+			Frame: canbus.Frame{ID: 0x42, Kind: canbus.SFF, Data: []byte{1}},
+		},
+		{
+			Time: t0.Add(12*time.Second + 4567*time.Microsecond), Iface: "can0",
+			Frame: canbus.Frame{Kind: canbus.ERR, Data: []byte{}},
+		},
+	}
+
+	var buf bytes.Buffer
+	w := trc.NewWriter(&buf)
+	for _, rec := range recs {
+		err := w.Write(rec)
+		if err != nil {
+			t.Fatalf("could not write record: %+v", err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("could not close writer: %+v", err)
+	}
+
+	want, err := os.ReadFile("testdata/example.trc")
+	if err != nil {
+		t.Fatalf("could not read golden file: %+v", err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("invalid TRC output:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got := readAll(t, trc.NewReader(&buf))
+	for i := range recs {
+		switch recs[i].Iface {
+		case "can0":
+			recs[i].Iface = "1"
+		case "can1":
+			recs[i].Iface = "2"
+		}
+	}
+	compare(t, got, recs)
+}
+
+func TestRead(t *testing.T) {
+	t0 := time.Date(2018, time.November, 30, 13, 48, 30, 30e6, time.Local)
+	for _, tc := range []struct {
+		name string
+		src  string
+		want []canlog.Record
+	}{
+		{
+			name: "1.0",
+			src: `;##########################################################################
+;   Start time: 30.11.2018 13:48:30.030
+;---+--   ----+----  ----+---  +  -+ -- -- -- -- -- -- --
+     1)      1841  0001  8  00 11 22 33 44 55 66 77
+     2)      1842  18EFC034  2  01 02
+`,
+			want: []canlog.Record{
+				{
+					Time:  time.Time{}.Add(1841 * time.Millisecond),
+					Frame: canbus.Frame{ID: 1, Kind: canbus.SFF, Data: []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77}},
+				},
+				{
+					Time:  time.Time{}.Add(1842 * time.Millisecond),
+					Frame: canbus.Frame{ID: 0x18efc034, Kind: canbus.EFF, Data: []byte{1, 2}},
+				},
+			},
+		},
+		{
+			name: "1.1",
+			src: `;$FILEVERSION=1.1
+;$STARTTIME=43434.5753475694
+;---+--   ----+----  --+--  ----+---  +  -+ -- -- -- -- -- -- --
+     1)      1841.0  Rx         0001  8  00 00 00 00 00 00 00 00
+     2)      1842.3  Tx     00000100  4  RTR
+     3)      1843.0  Warng  FFFFFFFF  4  00 00 00 08  BUSHEAVY
+     4)      1844.5  Error      0100  0
+`,
+			want: []canlog.Record{
+				{
+					Time:  t0.Add(1841 * time.Millisecond),
+					Frame: canbus.Frame{ID: 1, Kind: canbus.SFF, Data: make([]byte, 8)},
+				},
+				{
+					Time: t0.Add(18423 * time.Millisecond / 10), Flags: canlog.Ext | canlog.Tx,
+					Frame: canbus.Frame{ID: 0x100, Kind: canbus.RTR, Data: make([]byte, 4)},
+				},
+				{
+					Time:  t0.Add(18445 * time.Millisecond / 10),
+					Frame: canbus.Frame{Kind: canbus.ERR, Data: []byte{}},
+				},
+			},
+		},
+		{
+			name: "1.2",
+			src: `;$FILEVERSION=1.2
+;$STARTTIME=43434.5753475694
+;---+--   ----+----  -+-  --+--  ----+---  +  -+ -- -- -- -- -- -- --
+     1)      1841.0  2    Rx         0001  2  0A 0B
+`,
+			want: []canlog.Record{
+				{
+					Time: t0.Add(1841 * time.Millisecond), Iface: "2",
+					Frame: canbus.Frame{ID: 1, Kind: canbus.SFF, Data: []byte{10, 11}},
+				},
+			},
+		},
+		{
+			name: "1.3",
+			src: `;$FILEVERSION=1.3
+;$STARTTIME=43434.5753475694
+;---+-- ------+------ +- --+-- ----+--- +- -+-- -+ -- -- -- -- -- -- --
+     1)      1841.123 1  Tx        07FF -  1    0A
+`,
+			want: []canlog.Record{
+				{
+					Time: t0.Add(1841123 * time.Microsecond), Iface: "1", Flags: canlog.Tx,
+					Frame: canbus.Frame{ID: 0x7ff, Kind: canbus.SFF, Data: []byte{10}},
+				},
+			},
+		},
+		{
+			name: "2.0",
+			src: `;$FILEVERSION=2.0
+;$STARTTIME=43434.5753475694
+;$COLUMNS=N,O,T,I,d,l,D
+;---+-- ------+------ +- --+----- +- +- +- -- -- -- -- -- -- --
+      1      1059.900 DT     0300 Rx 7  00 00 00 00 04 00 00
+      2      1283.231 FD     0400 Tx 12 01 02 03 04 05 06 07 08 09 0A 0B 0C
+      3      1300.000 ST          Rx    00 00 00 08
+      4      1400.000 RR 00000200 Rx 2
+`,
+			want: []canlog.Record{
+				{
+					Time:  t0.Add(1059900 * time.Microsecond),
+					Frame: canbus.Frame{ID: 0x300, Kind: canbus.SFF, Data: []byte{0, 0, 0, 0, 4, 0, 0}},
+				},
+				{
+					Time: t0.Add(1283231 * time.Microsecond), Flags: canlog.FD | canlog.Tx,
+					Frame: canbus.Frame{ID: 0x400, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+				},
+				{
+					Time: t0.Add(1400 * time.Millisecond), Flags: canlog.Ext,
+					Frame: canbus.Frame{ID: 0x200, Kind: canbus.RTR, Data: make([]byte, 2)},
+				},
+			},
+		},
+		{
+			name: "2.1",
+			src: `;$FILEVERSION=2.1
+;$STARTTIME=43434.5753475694
+;$COLUMNS=N,O,T,B,I,d,R,L,D
+;---+-- ------+------ +- +- --+----- +- +- +--- +- -- -- -- -- -- -- --
+      1      1059.900 BI 3      0300 Rx -  9    01 02 03 04 05 06 07 08 09 0A 0B 0C
+      2      1100.000 EC 3               Rx -  0    10 00
+      3      1200.000 ER 3               Tx -  5    04 00 08 00 00
+      4      1300.000 FE 1  00000001 Rx -  0
+`,
+			want: []canlog.Record{
+				{
+					Time: t0.Add(1059900 * time.Microsecond), Iface: "3", Flags: canlog.FD | canlog.BRS | canlog.ESI,
+					Frame: canbus.Frame{ID: 0x300, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+				},
+				{
+					Time: t0.Add(1200 * time.Millisecond), Iface: "3", Flags: canlog.Tx,
+					Frame: canbus.Frame{Kind: canbus.ERR, Data: []byte{}},
+				},
+				{
+					Time: t0.Add(1300 * time.Millisecond), Iface: "1", Flags: canlog.FD | canlog.ESI,
+					Frame: canbus.Frame{ID: 1, Kind: canbus.EFF, Data: []byte{}},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			compare(t, readAll(t, trc.NewReader(strings.NewReader(tc.src))), tc.want)
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"version", ";$FILEVERSION=3.0", `trc: line 1: unsupported version "3.0"`},
+		{"start", ";$STARTTIME=yesterday", `trc: line 1: invalid start time "yesterday"`},
+		{"columns", ";$COLUMNS=N,O,X,D", `trc: line 1: invalid columns "N,O,X,D"`},
+		{"offset", "1) 1.x 0001 0", `trc: line 1: invalid time offset "1.x"`},
+		{"id", "1) 1 XYZ 0", `trc: line 1: invalid identifier "XYZ"`},
+		{"missing", "1) 1", `trc: line 1: missing columns`},
+		{"data", "1) 1 0001 2 11", `trc: line 1: missing data bytes`},
+		{"byte", "1) 1 0001 1 XYZ", `trc: line 1: invalid data byte "XYZ"`},
+		{"bus", ";$FILEVERSION=1.2\n1) 1 0 Rx 0001 0", `trc: line 2: invalid bus "0"`},
+		{"dir", ";$FILEVERSION=2.0\n1 1 DT 0001 Up 0", `trc: line 2: invalid direction "Up"`},
+		{"len", ";$FILEVERSION=2.0\n1 1 FD 0001 Rx 65", `trc: line 2: invalid data length "65"`},
+		{"dlc", ";$FILEVERSION=2.1\n1 1 FD 1 0001 Rx - 16", `trc: line 2: invalid data length code "16"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := trc.NewReader(strings.NewReader(tc.src)).Read()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	for _, tc := range []struct {
+		name string
+		recs []canlog.Record
+		err  string
+	}{
+		{
+			name: "order",
+			recs: []canlog.Record{{Time: t0}, {Time: t0.Add(-time.Second)}},
+			err:  "trc: record at " + t0.Add(-time.Second).String() + " precedes the start of the measurement",
+		},
+		{
+			name: "id",
+			recs: []canlog.Record{{Time: t0, Frame: canbus.Frame{ID: 0x800}}},
+			err:  "trc: invalid frame identifier 0x800",
+		},
+		{
+			name: "length",
+			recs: []canlog.Record{{Time: t0, Frame: canbus.Frame{ID: 1, Data: make([]byte, 9)}}},
+			err:  "trc: invalid frame length 9",
+		},
+		{
+			name: "fd-kind",
+			recs: []canlog.Record{{Time: t0, Flags: canlog.FD, Frame: canbus.Frame{ID: 1, Kind: canbus.RTR}}},
+			err:  "trc: invalid CAN FD frame kind RTR",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := trc.NewWriter(io.Discard)
+			var err error
+			for _, rec := range tc.recs {
+				err = w.Write(rec)
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cannelloni tunnels CAN frames between hosts over UDP, with the
+// protocol of cannelloni (https://github.com/mguentner/cannelloni).
+//
+// cannelloni packs the CAN and CAN FD frames received on a bus into
+// numbered datagrams, sent to the remote endpoint of the tunnel once
+// full, or once a batching timeout has elapsed. Endpoints unpack the
+// datagrams they receive onto their own bus.
+//
+// A typical usage might look like:
+//
+//	tun, err := cannelloni.Open("can0", cannelloni.Config{
+//	    Remote:  "192.168.1.2:20000",
+//	    Timeout: cannelloni.DefaultTimeout,
+//	})
+//	defer tun.Close()
+//	err = tun.Run()
+package cannelloni
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+const (
+	// Version is the version of the cannelloni data packets.
+	Version = 2
+
+	opData = 0 // op code of data packets
+
+	headerSize = 5 // version, op code, sequence number and frame count
+
+	effFlag = 0x80000000
+	rtrFlag = 0x40000000
+	errFlag = 0x20000000
+	sffMask = 0x000007ff
+	effMask = 0x1fffffff
+
+	fdFrame = 0x80 // length flag of CAN FD frames
+	fdBRS   = 0x01 // CAN FD frame sent with a bit rate switch
+	fdESI   = 0x02 // CAN FD frame with the error state indicator set
+)
+
+// Frame is a frame carried by a cannelloni packet.
+type Frame struct {
+	Frame canbus.Frame
+	Flags canlog.Flags // FD, BRS, ESI and Ext flags of the frame
+}
+
+// Packet is a cannelloni data packet.
+type Packet struct {
+	Seq    uint8 // sequence number of the packet
+	Frames []Frame
+}
+
+// size returns the size of the encoded frame.
+func (f Frame) size() int {
+	n := 4 + 1
+	if f.Flags&canlog.FD != 0 {
+		n++
+	}
+	if f.Frame.Kind != canbus.RTR {
+		n += len(f.Frame.Data)
+	}
+	return n
+}
+
+// Append appends the encoded packet to buf.
+//
+// Identifiers are encoded in network byte order, along with their
+// SocketCAN flags. The length of CAN FD frames is followed by their
+// flags, and the data bytes of remote frames are omitted.
+func (p Packet) Append(buf []byte) ([]byte, error) {
+	if len(p.Frames) > 0xffff {
+		return buf, fmt.Errorf("cannelloni: too many frames %d", len(p.Frames))
+	}
+	buf = append(buf, Version, opData, p.Seq, 0, 0)
+	binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(len(p.Frames)))
+	for _, f := range p.Frames {
+		var err error
+		buf, err = appendFrame(buf, f)
+		if err != nil {
+			return buf, err
+		}
+	}
+	return buf, nil
+}
+
+func appendFrame(buf []byte, f Frame) ([]byte, error) {
+	var (
+		frame = f.Frame
+		id    = frame.ID
+		max   = 8
+	)
+	switch frame.Kind {
+	case canbus.SFF:
+		if id > sffMask {
+			return buf, fmt.Errorf("cannelloni: invalid frame identifier 0x%x", id)
+		}
+	case canbus.EFF:
+		if id > effMask {
+			return buf, fmt.Errorf("cannelloni: invalid frame identifier 0x%x", id)
+		}
+		id |= effFlag
+	case canbus.RTR:
+		switch {
+		case f.Flags&canlog.Ext != 0:
+			if id > effMask {
+				return buf, fmt.Errorf("cannelloni: invalid frame identifier 0x%x", id)
+			}
+			id |= effFlag
+		case id > sffMask:
+			return buf, fmt.Errorf("cannelloni: invalid frame identifier 0x%x", id)
+		}
+		id |= rtrFlag
+	case canbus.ERR:
+		if id > effMask {
+			return buf, fmt.Errorf("cannelloni: invalid frame identifier 0x%x", id)
+		}
+		id |= errFlag
+	default:
+		return buf, fmt.Errorf("cannelloni: invalid frame kind %v", frame.Kind)
+	}
+
+	n := byte(len(frame.Data))
+	if f.Flags&canlog.FD != 0 {
+		if frame.Kind == canbus.RTR || frame.Kind == canbus.ERR {
+			return buf, fmt.Errorf("cannelloni: invalid CAN FD frame kind %v", frame.Kind)
+		}
+		max = canlog.MaxLen
+		if canlog.Len(canlog.DLC(len(frame.Data))) != len(frame.Data) {
+			return buf, fmt.Errorf("cannelloni: invalid CAN FD frame length %d", len(frame.Data))
+		}
+		n |= fdFrame
+	}
+	if len(frame.Data) > max {
+		return buf, fmt.Errorf("cannelloni: invalid frame length %d", len(frame.Data))
+	}
+
+	buf = append(buf, 0, 0, 0, 0, n)
+	binary.BigEndian.PutUint32(buf[len(buf)-5:], id)
+	if f.Flags&canlog.FD != 0 {
+		var flags byte
+		if f.Flags&canlog.BRS != 0 {
+			flags |= fdBRS
+		}
+		if f.Flags&canlog.ESI != 0 {
+			flags |= fdESI
+		}
+		buf = append(buf, flags)
+	}
+	if frame.Kind != canbus.RTR {
+		buf = append(buf, frame.Data...)
+	}
+	return buf, nil
+}
+
+// Decode decodes a cannelloni data packet.
+func Decode(b []byte) (Packet, error) {
+	var p Packet
+	if len(b) < headerSize {
+		return p, fmt.Errorf("cannelloni: invalid packet size %d", len(b))
+	}
+	if b[0] != Version {
+		return p, fmt.Errorf("cannelloni: invalid packet version %d", b[0])
+	}
+	if b[1] != opData {
+		return p, fmt.Errorf("cannelloni: invalid packet op code %d", b[1])
+	}
+	p.Seq = b[2]
+	n := int(binary.BigEndian.Uint16(b[3:5]))
+	b = b[headerSize:]
+
+	p.Frames = make([]Frame, 0, n)
+	for i := 0; i < n; i++ {
+		if len(b) < 5 {
+			return p, fmt.Errorf("cannelloni: invalid packet: frame %d truncated", i)
+		}
+		var (
+			f   Frame
+			id  = binary.BigEndian.Uint32(b[0:4])
+			n   = int(b[4])
+			max = 8
+		)
+		b = b[5:]
+		if n&fdFrame != 0 {
+			if len(b) < 1 {
+				return p, fmt.Errorf("cannelloni: invalid packet: frame %d truncated", i)
+			}
+			n &^= fdFrame
+			max = canlog.MaxLen
+			f.Flags |= canlog.FD
+			if b[0]&fdBRS != 0 {
+				f.Flags |= canlog.BRS
+			}
+			if b[0]&fdESI != 0 {
+				f.Flags |= canlog.ESI
+			}
+			b = b[1:]
+		}
+		if n > max {
+			return p, fmt.Errorf("cannelloni: invalid packet: frame %d length %d", i, n)
+		}
+
+		switch {
+		case id&errFlag != 0:
+			f.Frame.Kind = canbus.ERR
+			f.Frame.ID = id & effMask
+		case id&rtrFlag != 0:
+			f.Frame.Kind = canbus.RTR
+			if id&effFlag != 0 {
+				f.Flags |= canlog.Ext
+				f.Frame.ID = id & effMask
+			} else {
+				f.Frame.ID = id & sffMask
+			}
+		case id&effFlag != 0:
+			f.Frame.Kind = canbus.EFF
+			f.Frame.ID = id & effMask
+		default:
+			f.Frame.Kind = canbus.SFF
+			f.Frame.ID = id & sffMask
+		}
+
+		f.Frame.Data = make([]byte, n)
+		if f.Frame.Kind != canbus.RTR {
+			if len(b) < n {
+				return p, fmt.Errorf("cannelloni: invalid packet: frame %d truncated", i)
+			}
+			copy(f.Frame.Data, b[:n])
+			b = b[n:]
+		}
+		p.Frames = append(p.Frames, f)
+	}
+	return p, nil
+}
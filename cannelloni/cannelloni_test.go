@@ -0,0 +1,170 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cannelloni
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+func TestPacket(t *testing.T) {
+	fd := make([]byte, 12)
+	for i := range fd {
+		fd[i] = byte(i)
+	}
+	p := Packet{
+		Seq: 7,
+		Frames: []Frame{
+			{Frame: canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{1, 2}}},
+			{Frame: canbus.Frame{ID: 0x12345678, Kind: canbus.EFF, Data: []byte{}}},
+			{Frame: canbus.Frame{ID: 0x7ff, Kind: canbus.RTR, Data: make([]byte, 4)}},
+			{Frame: canbus.Frame{ID: 0x1abcdef, Kind: canbus.RTR, Data: []byte{}}, Flags: canlog.Ext},
+			{Frame: canbus.Frame{ID: 0x100, Kind: canbus.SFF, Data: fd}, Flags: canlog.FD | canlog.BRS},
+			{Frame: canbus.Frame{ID: 0x004, Kind: canbus.ERR, Data: []byte{0, 0, 0, 0, 0, 0, 0, 0}}},
+		},
+	}
+	want := []byte{
+		0x02, 0x00, 0x07, 0x00, 0x06,
+		0x00, 0x00, 0x01, 0x23, 0x02, 0x01, 0x02,
+		0x92, 0x34, 0x56, 0x78, 0x00,
+		0x40, 0x00, 0x07, 0xff, 0x04,
+		0xc1, 0xab, 0xcd, 0xef, 0x00,
+		0x00, 0x00, 0x01, 0x00, 0x8c, 0x01, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
+		0x20, 0x00, 0x00, 0x04, 0x08, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	got, err := p.Append(nil)
+	if err != nil {
+		t.Fatalf("could not encode packet: %+v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("invalid packet:\ngot= % x\nwant=% x", got, want)
+	}
+
+	size := headerSize
+	for _, f := range p.Frames {
+		size += f.size()
+	}
+	if size != len(want) {
+		t.Fatalf("invalid packet size: got=%d, want=%d", size, len(want))
+	}
+
+	dec, err := Decode(got)
+	if err != nil {
+		t.Fatalf("could not decode packet: %+v", err)
+	}
+	if !reflect.DeepEqual(dec, p) {
+		t.Fatalf("invalid decoded packet:\ngot= %+v\nwant=%+v", dec, p)
+	}
+
+	for i := headerSize; i < len(want); i++ {
+		_, err := Decode(want[:i])
+		if err == nil {
+			t.Fatalf("expected an error decoding %d bytes", i)
+		}
+	}
+}
+
+func TestPacketErrors(t *testing.T) {
+	for _, tc := range []struct {
+		frame Frame
+		err   error
+	}{
+		{
+			frame: Frame{Frame: canbus.Frame{ID: 0x800, Kind: canbus.SFF}},
+			err:   fmt.Errorf("cannelloni: invalid frame identifier 0x800"),
+		},
+		{
+			frame: Frame{Frame: canbus.Frame{ID: 0x20000000, Kind: canbus.EFF}},
+			err:   fmt.Errorf("cannelloni: invalid frame identifier 0x20000000"),
+		},
+		{
+			frame: Frame{Frame: canbus.Frame{ID: 0x1, Kind: canbus.SFF, Data: make([]byte, 9)}},
+			err:   fmt.Errorf("cannelloni: invalid frame length 9"),
+		},
+		{
+			frame: Frame{Frame: canbus.Frame{ID: 0x1, Kind: canbus.SFF, Data: make([]byte, 9)}, Flags: canlog.FD},
+			err:   fmt.Errorf("cannelloni: invalid CAN FD frame length 9"),
+		},
+		{
+			frame: Frame{Frame: canbus.Frame{ID: 0x1, Kind: canbus.RTR}, Flags: canlog.FD},
+			err:   fmt.Errorf("cannelloni: invalid CAN FD frame kind RTR"),
+		},
+		{
+			frame: Frame{Frame: canbus.Frame{ID: 0x1, Kind: canbus.Kind(42)}},
+			err:   fmt.Errorf("cannelloni: invalid frame kind Kind(42)"),
+		},
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := Packet{Frames: []Frame{tc.frame}}.Append(nil)
+			if got, want := fmt.Sprint(err), tc.err.Error(); got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		raw []byte
+		err error
+	}{
+		{
+			raw: []byte{0x02, 0x00, 0x00, 0x00},
+			err: fmt.Errorf("cannelloni: invalid packet size 4"),
+		},
+		{
+			raw: []byte{0x01, 0x00, 0x00, 0x00, 0x00},
+			err: fmt.Errorf("cannelloni: invalid packet version 1"),
+		},
+		{
+			raw: []byte{0x02, 0x01, 0x00, 0x00, 0x00},
+			err: fmt.Errorf("cannelloni: invalid packet op code 1"),
+		},
+		{
+			raw: []byte{0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x01, 0x23, 0x09},
+			err: fmt.Errorf("cannelloni: invalid packet: frame 0 length 9"),
+		},
+		{
+			raw: []byte{0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x01, 0x23, 0x02, 0x01},
+			err: fmt.Errorf("cannelloni: invalid packet: frame 0 truncated"),
+		},
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := Decode(tc.raw)
+			if got, want := fmt.Sprint(err), tc.err.Error(); got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestSequencer(t *testing.T) {
+	var s sequencer
+	for i, tc := range []struct {
+		seq  uint8
+		lost int
+		late bool
+	}{
+		{seq: 254},
+		{seq: 255},
+		{seq: 0},
+		{seq: 1},
+		{seq: 5, lost: 3},
+		{seq: 3, late: true},
+		{seq: 5, late: true},
+		{seq: 6},
+		{seq: 6 + 127, lost: 126},
+		{seq: 7, late: true},
+	} {
+		lost, late := s.next(tc.seq)
+		if lost != tc.lost || late != tc.late {
+			t.Fatalf("invalid datagram %d (seq=%d): got=(%d, %v), want=(%d, %v)", i, tc.seq, lost, late, tc.lost, tc.late)
+		}
+	}
+}
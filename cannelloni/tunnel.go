@@ -0,0 +1,407 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cannelloni
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+const (
+	// DefaultPort is the default UDP port of cannelloni endpoints.
+	DefaultPort = 20000
+
+	// DefaultTimeout is the default batching timeout of cannelloni.
+	DefaultTimeout = 100 * time.Millisecond
+
+	// DefaultMaxSize is the default maximum size of the datagrams, so
+	// they fit in the payload of an Ethernet frame.
+	DefaultMaxSize = 1472
+
+	// minSize is the size of a packet holding a single CAN FD frame with
+	// 64 data bytes.
+	minSize = headerSize + 4 + 1 + 1 + canlog.MaxLen
+
+	// pollTimeout is the receive timeout of the transport of the tunnel,
+	// bounding the time it takes to notice it has been closed.
+	pollTimeout = 100 * time.Millisecond
+)
+
+// ErrClosed is returned by Run once the tunnel has been closed.
+var ErrClosed = errors.New("cannelloni: tunnel closed")
+
+// Config describes the endpoints of a tunnel.
+type Config struct {
+	// Local is the local UDP address of the tunnel.
+	// It defaults to port DefaultPort on all addresses.
+	Local string
+
+	// Remote is the UDP address of the remote endpoint of the tunnel.
+	// Only the datagrams sent from its IP address are received.
+	Remote string
+
+	// Timeout is the duration frames may wait for other frames to be
+	// sent along with them. A zero Timeout sends the frames as soon as
+	// they are received, batching only the frames already pending.
+	Timeout time.Duration
+
+	// MaxSize is the maximum size of the datagrams.
+	// It defaults to DefaultMaxSize.
+	MaxSize int
+}
+
+// Stats describes the traffic of a tunnel.
+type Stats struct {
+	TxFrames  uint64 // frames sent to the remote endpoint
+	TxPackets uint64 // datagrams sent to the remote endpoint
+	Failed    uint64 // datagrams that could not be sent
+
+	RxFrames  uint64 // frames received from the remote endpoint
+	RxPackets uint64 // datagrams received from the remote endpoint
+	Lost      uint64 // datagrams missing from the received sequence, and not received since
+	Reordered uint64 // datagrams received after the ones that followed them
+	Invalid   uint64 // invalid datagrams, or datagrams from other hosts
+	Dropped   uint64 // received frames that could not be sent on the bus
+}
+
+// Tunnel forwards the frames received on a CAN interface to the remote
+// endpoint of a cannelloni tunnel, and the frames received from that
+// endpoint to the interface.
+//
+// The interface is accessed through a canbus.Transport, which only
+// handles classical CAN frames: CAN FD frames received from the remote
+// endpoint are sent as classical frames, when their payload fits in one,
+// and dropped otherwise.
+type Tunnel struct {
+	stats Stats // first, for the alignment of its atomic counters
+
+	bus     canbus.Transport
+	conn    net.PacketConn
+	raddr   *net.UDPAddr
+	timeout time.Duration
+	maxSize int
+
+	frames chan canbus.Frame
+	seq    uint8     // sequence number of the next datagram sent
+	rx     sequencer // sequence numbers of the datagrams received
+
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+	closed uint32
+}
+
+// Open opens a CAN socket on the interface, and returns a tunnel
+// between that interface and the remote endpoint of the configuration.
+func Open(iface string, cfg Config) (*Tunnel, error) {
+	sck, err := open(iface)
+	if err != nil {
+		return nil, fmt.Errorf("cannelloni: could not open %q: %w", iface, err)
+	}
+	tun, err := New(sck, cfg)
+	if err != nil {
+		sck.Close()
+		return nil, err
+	}
+	return tun, nil
+}
+
+func open(iface string) (*canbus.Socket, error) {
+	sck, err := canbus.New()
+	if err != nil {
+		return nil, err
+	}
+	err = sck.Bind(iface)
+	if err != nil {
+		sck.Close()
+		return nil, err
+	}
+	return sck, nil
+}
+
+// New returns a tunnel between the provided transport and the remote
+// endpoint of the configuration.
+//
+// The receive timeout of the transport is set by New, so that Run
+// notices when the tunnel is closed. The tunnel closes the transport
+// when it is closed.
+func New(tr canbus.Transport, cfg Config) (*Tunnel, error) {
+	raddr, err := net.ResolveUDPAddr("udp", cfg.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("cannelloni: could not resolve remote address %q: %w", cfg.Remote, err)
+	}
+	laddr := cfg.Local
+	if laddr == "" {
+		laddr = ":" + strconv.Itoa(DefaultPort)
+	}
+	conn, err := net.ListenPacket("udp", laddr)
+	if err != nil {
+		return nil, fmt.Errorf("cannelloni: could not listen on %q: %w", laddr, err)
+	}
+
+	tun, err := newTunnel(tr, conn, raddr, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tun, nil
+}
+
+func newTunnel(b canbus.Transport, conn net.PacketConn, raddr *net.UDPAddr, cfg Config) (*Tunnel, error) {
+	maxSize := cfg.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+	switch {
+	case maxSize < minSize:
+		return nil, fmt.Errorf("cannelloni: invalid maximum datagram size %d", cfg.MaxSize)
+	case cfg.Timeout < 0:
+		return nil, fmt.Errorf("cannelloni: invalid timeout %v", cfg.Timeout)
+	}
+	err := b.SetRecvTimeout(pollTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("cannelloni: could not set receive timeout: %w", err)
+	}
+	return &Tunnel{
+		bus:     b,
+		conn:    conn,
+		raddr:   raddr,
+		timeout: cfg.Timeout,
+		maxSize: maxSize,
+		frames:  make(chan canbus.Frame, 256),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Run forwards frames until the tunnel is closed, or until receiving
+// frames or datagrams fails.
+// Run returns ErrClosed once the tunnel has been closed.
+func (tun *Tunnel) Run() error {
+	if atomic.LoadUint32(&tun.closed) != 0 {
+		return ErrClosed
+	}
+	errc := make(chan error, 3)
+	for _, f := range []func() error{tun.recvBus, tun.send, tun.recvNet} {
+		tun.wg.Add(1)
+		go func(f func() error) {
+			defer tun.wg.Done()
+			errc <- f()
+		}(f)
+	}
+
+	err := <-errc
+	tun.stop()
+	return err
+}
+
+// stop makes the goroutines of the tunnel return.
+func (tun *Tunnel) stop() {
+	tun.once.Do(func() {
+		atomic.StoreUint32(&tun.closed, 1)
+		close(tun.done)
+		// unblock the reads of the datagrams.
+		tun.conn.SetReadDeadline(time.Now())
+	})
+}
+
+// recvBus queues the frames received on the bus.
+func (tun *Tunnel) recvBus() error {
+	for {
+		frame, err := tun.bus.Recv()
+		if atomic.LoadUint32(&tun.closed) != 0 {
+			return ErrClosed
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannelloni: could not receive frame: %w", err)
+		}
+		select {
+		case tun.frames <- frame:
+		case <-tun.done:
+			return ErrClosed
+		}
+	}
+}
+
+// send packs the queued frames into datagrams, and sends them to the
+// remote endpoint.
+func (tun *Tunnel) send() error {
+	var (
+		frames []Frame
+		size   = headerSize
+		buf    = make([]byte, 0, tun.maxSize)
+		timer  *time.Timer
+		expire <-chan time.Time
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expire = nil, nil
+		}
+		if len(frames) == 0 {
+			return
+		}
+		var err error
+		buf, err = Packet{Seq: tun.seq, Frames: frames}.Append(buf[:0])
+		if err == nil {
+			_, err = tun.conn.WriteTo(buf, tun.raddr)
+		}
+		if err != nil {
+			atomic.AddUint64(&tun.stats.Failed, 1)
+		} else {
+			atomic.AddUint64(&tun.stats.TxPackets, 1)
+			atomic.AddUint64(&tun.stats.TxFrames, uint64(len(frames)))
+		}
+		tun.seq++
+		frames = frames[:0]
+		size = headerSize
+	}
+	add := func(frame canbus.Frame) {
+		f := Frame{Frame: frame}
+		if size+f.size() > tun.maxSize {
+			flush()
+		}
+		frames = append(frames, f)
+		size += f.size()
+	}
+
+	for {
+		select {
+		case <-tun.done:
+			return ErrClosed
+		case frame := <-tun.frames:
+			add(frame)
+			if tun.timeout == 0 {
+				for pending := true; pending; {
+					select {
+					case frame := <-tun.frames:
+						add(frame)
+					default:
+						pending = false
+					}
+				}
+				flush()
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(tun.timeout)
+				expire = timer.C
+			}
+		case <-expire:
+			timer, expire = nil, nil
+			flush()
+		}
+	}
+}
+
+// recvNet sends the frames of the datagrams received from the remote
+// endpoint on the bus.
+func (tun *Tunnel) recvNet() error {
+	buf := make([]byte, 1<<16)
+	for {
+		n, addr, err := tun.conn.ReadFrom(buf)
+		if atomic.LoadUint32(&tun.closed) != 0 {
+			return ErrClosed
+		}
+		if err != nil {
+			return fmt.Errorf("cannelloni: could not receive datagram: %w", err)
+		}
+		if ua, ok := addr.(*net.UDPAddr); !ok || !ua.IP.Equal(tun.raddr.IP) {
+			atomic.AddUint64(&tun.stats.Invalid, 1)
+			continue
+		}
+		p, err := Decode(buf[:n])
+		if err != nil {
+			atomic.AddUint64(&tun.stats.Invalid, 1)
+			continue
+		}
+
+		atomic.AddUint64(&tun.stats.RxPackets, 1)
+		lost, late := tun.rx.next(p.Seq)
+		switch {
+		case late:
+			atomic.AddUint64(&tun.stats.Reordered, 1)
+			if atomic.LoadUint64(&tun.stats.Lost) > 0 {
+				// the datagram was counted as lost.
+				atomic.AddUint64(&tun.stats.Lost, ^uint64(0))
+			}
+		case lost > 0:
+			atomic.AddUint64(&tun.stats.Lost, uint64(lost))
+		}
+
+		for _, f := range p.Frames {
+			atomic.AddUint64(&tun.stats.RxFrames, 1)
+			_, err := tun.bus.Send(f.Frame)
+			if err != nil {
+				atomic.AddUint64(&tun.stats.Dropped, 1)
+			}
+		}
+	}
+}
+
+// Stats returns the statistics of the tunnel.
+func (tun *Tunnel) Stats() Stats {
+	return Stats{
+		TxFrames:  atomic.LoadUint64(&tun.stats.TxFrames),
+		TxPackets: atomic.LoadUint64(&tun.stats.TxPackets),
+		Failed:    atomic.LoadUint64(&tun.stats.Failed),
+		RxFrames:  atomic.LoadUint64(&tun.stats.RxFrames),
+		RxPackets: atomic.LoadUint64(&tun.stats.RxPackets),
+		Lost:      atomic.LoadUint64(&tun.stats.Lost),
+		Reordered: atomic.LoadUint64(&tun.stats.Reordered),
+		Invalid:   atomic.LoadUint64(&tun.stats.Invalid),
+		Dropped:   atomic.LoadUint64(&tun.stats.Dropped),
+	}
+}
+
+// Close stops the tunnel, and closes its sockets.
+func (tun *Tunnel) Close() error {
+	tun.stop()
+	tun.wg.Wait()
+
+	err := tun.conn.Close()
+	if e := tun.bus.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		return fmt.Errorf("cannelloni: could not close tunnel: %w", err)
+	}
+	return nil
+}
+
+// sequencer follows the sequence numbers of the received datagrams.
+type sequencer struct {
+	want  uint8 // expected sequence number
+	valid bool  // whether a datagram has been received
+}
+
+// next returns the number of datagrams missing before the one with the
+// provided sequence number, or whether that datagram arrived late.
+//
+// As sequence numbers wrap around every 256 datagrams, datagrams
+// arriving more than 128 datagrams early are considered late.
+func (s *sequencer) next(seq uint8) (lost int, late bool) {
+	if !s.valid {
+		s.want, s.valid = seq+1, true
+		return 0, false
+	}
+	d := int8(seq - s.want)
+	if d < 0 {
+		return 0, true
+	}
+	s.want = seq + 1
+	return int(d), false
+}
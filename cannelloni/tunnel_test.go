@@ -0,0 +1,339 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cannelloni
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/vcan"
+	"golang.org/x/sys/unix"
+)
+
+// fakeBus is a bus receiving frames from a channel, and recording the
+// frames sent. As a canbus.Socket, it only sends classical frames.
+type fakeBus struct {
+	in chan canbus.Frame
+
+	mu     sync.Mutex
+	sent   []canbus.Frame
+	closed bool
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{in: make(chan canbus.Frame, 64)}
+}
+
+func (b *fakeBus) Send(frame canbus.Frame) (int, error) {
+	if len(frame.Data) > 8 {
+		return 0, fmt.Errorf("data too big")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent = append(b.sent, frame)
+	return len(frame.Data), nil
+}
+
+func (b *fakeBus) Recv() (canbus.Frame, error) {
+	select {
+	case frame := <-b.in:
+		return frame, nil
+	case <-time.After(10 * time.Millisecond):
+		return canbus.Frame{}, fmt.Errorf("recv timeout: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+func (b *fakeBus) Name() string                       { return "fake" }
+func (b *fakeBus) SetFilters([]unix.CanFilter) error  { return nil }
+func (b *fakeBus) SetRecvTimeout(time.Duration) error { return nil }
+
+func (b *fakeBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+func (b *fakeBus) frames() []canbus.Frame {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]canbus.Frame(nil), b.sent...)
+}
+
+func listen(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %+v", err)
+	}
+	return conn
+}
+
+func run(t *testing.T, tun *Tunnel) chan error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tun.Run()
+	}()
+	return errc
+}
+
+// poll waits for the condition to be satisfied.
+func poll(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %s", msg)
+}
+
+func TestTunnel(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		packets uint64
+	}{
+		{"batch", 20 * time.Millisecond, 3},
+		{"no-timeout", 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				busA, busB   = newFakeBus(), newFakeBus()
+				connA, connB = listen(t), listen(t)
+				cfg          = Config{Timeout: tc.timeout, MaxSize: minSize}
+			)
+			tunA, err := newTunnel(busA, connA, connB.LocalAddr().(*net.UDPAddr), cfg)
+			if err != nil {
+				t.Fatalf("could not create tunnel: %+v", err)
+			}
+			tunB, err := newTunnel(busB, connB, connA.LocalAddr().(*net.UDPAddr), cfg)
+			if err != nil {
+				t.Fatalf("could not create tunnel: %+v", err)
+			}
+			errA, errB := run(t, tunA), run(t, tunB)
+
+			// frames of 13 bytes: 5 frames per datagram.
+			var frames []canbus.Frame
+			for i := 0; i < 12; i++ {
+				frame := canbus.Frame{ID: uint32(i), Data: []byte{byte(i), 1, 2, 3, 4, 5, 6, 7}}
+				frames = append(frames, frame)
+				busA.in <- frame
+			}
+			busB.in <- canbus.Frame{ID: 0x1fffffff, Kind: canbus.EFF, Data: []byte{}}
+
+			poll(t, "frames", func() bool { return len(busB.frames()) == len(frames) && len(busA.frames()) == 1 })
+			if got := busB.frames(); !reflect.DeepEqual(got, frames) {
+				t.Fatalf("invalid frames:\ngot= %+v\nwant=%+v", got, frames)
+			}
+			if got, want := busA.frames()[0], (canbus.Frame{ID: 0x1fffffff, Kind: canbus.EFF, Data: []byte{}}); !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid frame: got=%+v, want=%+v", got, want)
+			}
+
+			for _, tun := range []*Tunnel{tunA, tunB} {
+				err := tun.Close()
+				if err != nil {
+					t.Fatalf("could not close tunnel: %+v", err)
+				}
+			}
+			for _, errc := range []chan error{errA, errB} {
+				if err := <-errc; !errors.Is(err, ErrClosed) {
+					t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrClosed)
+				}
+			}
+			if !busA.closed || !busB.closed {
+				t.Fatalf("buses not closed")
+			}
+
+			stA, stB := tunA.Stats(), tunB.Stats()
+			if stA.TxFrames != 12 || stB.RxFrames != 12 || stA.RxFrames != 1 || stB.TxFrames != 1 {
+				t.Fatalf("invalid frame statistics:\nA: %+v\nB: %+v", stA, stB)
+			}
+			if stA.TxPackets != stB.RxPackets || (tc.packets != 0 && stA.TxPackets != tc.packets) {
+				t.Fatalf("invalid datagram statistics:\nA: %+v\nB: %+v", stA, stB)
+			}
+			if stB.Lost != 0 || stB.Reordered != 0 || stB.Invalid != 0 {
+				t.Fatalf("invalid statistics: %+v", stB)
+			}
+		})
+	}
+}
+
+func TestTunnelVCAN(t *testing.T) {
+	var (
+		busA = vcan.New("vcan0")
+		busB = vcan.New("vcan1")
+		w    = busA.Open()
+		r    = busB.Open()
+		conn = listen(t)
+	)
+	defer w.Close()
+	defer r.Close()
+
+	tunA, err := New(busA.Open(), Config{Local: "127.0.0.1:0", Remote: conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("could not create tunnel: %+v", err)
+	}
+	tunB, err := newTunnel(busB.Open(), conn, tunA.conn.LocalAddr().(*net.UDPAddr), Config{})
+	if err != nil {
+		t.Fatalf("could not create tunnel: %+v", err)
+	}
+	errA, errB := run(t, tunA), run(t, tunB)
+
+	want := canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{1, 2, 3}}
+	_, err = w.Send(want)
+	if err != nil {
+		t.Fatalf("could not send frame: %+v", err)
+	}
+	err = r.SetRecvTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("could not set recv timeout: %+v", err)
+	}
+	got, err := r.Recv()
+	if err != nil {
+		t.Fatalf("could not receive frame: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frame: got=%+v, want=%+v", got, want)
+	}
+
+	for _, tun := range []*Tunnel{tunA, tunB} {
+		err := tun.Close()
+		if err != nil {
+			t.Fatalf("could not close tunnel: %+v", err)
+		}
+	}
+	for _, errc := range []chan error{errA, errB} {
+		if err := <-errc; !errors.Is(err, ErrClosed) {
+			t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrClosed)
+		}
+	}
+}
+
+func TestTunnelStats(t *testing.T) {
+	// datagrams from other hosts are ignored.
+	other, err := net.ListenPacket("udp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("could not listen on 127.0.0.2: %+v", err)
+	}
+	defer other.Close()
+
+	var (
+		bus    = newFakeBus()
+		conn   = listen(t)
+		remote = listen(t)
+	)
+	defer remote.Close()
+
+	tun, err := newTunnel(bus, conn, remote.LocalAddr().(*net.UDPAddr), Config{})
+	if err != nil {
+		t.Fatalf("could not create tunnel: %+v", err)
+	}
+	errc := run(t, tun)
+
+	send := func(raw []byte) {
+		_, err := remote.WriteTo(raw, conn.LocalAddr())
+		if err != nil {
+			t.Fatalf("could not send datagram: %+v", err)
+		}
+	}
+	packet := func(seq uint8, frames ...Frame) []byte {
+		raw, err := Packet{Seq: seq, Frames: frames}.Append(nil)
+		if err != nil {
+			t.Fatalf("could not encode packet: %+v", err)
+		}
+		return raw
+	}
+	sff := func(id uint32) Frame {
+		return Frame{Frame: canbus.Frame{ID: id, Data: []byte{}}}
+	}
+
+	// datagrams are sent one at a time, as they may otherwise be
+	// reordered by the host.
+	for _, raw := range [][]byte{
+		packet(0, sff(0)),
+		packet(1, sff(1)),
+		packet(3, sff(3)),
+		packet(2, sff(2)),
+		packet(6, sff(6), Frame{Frame: canbus.Frame{ID: 7, Data: make([]byte, 12)}, Flags: canlog.FD}),
+		{0x02, 0x00},
+		{0x03, 0x00, 0x07, 0x00, 0x00},
+	} {
+		n := tun.Stats()
+		send(raw)
+		poll(t, "datagram", func() bool {
+			st := tun.Stats()
+			return st.RxPackets+st.Invalid > n.RxPackets+n.Invalid
+		})
+	}
+	_, err = other.WriteTo(packet(7, sff(8)), conn.LocalAddr())
+	if err != nil {
+		t.Fatalf("could not send datagram: %+v", err)
+	}
+	poll(t, "datagram", func() bool { return tun.Stats().Invalid == 3 })
+
+	err = tun.Close()
+	if err != nil {
+		t.Fatalf("could not close tunnel: %+v", err)
+	}
+	if err := <-errc; !errors.Is(err, ErrClosed) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrClosed)
+	}
+
+	want := Stats{
+		RxFrames:  6,
+		RxPackets: 5,
+		Lost:      2,
+		Reordered: 1,
+		Invalid:   3,
+		Dropped:   1,
+	}
+	if got := tun.Stats(); got != want {
+		t.Fatalf("invalid statistics:\ngot= %+v\nwant=%+v", got, want)
+	}
+	var ids []uint32
+	for _, frame := range bus.frames() {
+		ids = append(ids, frame.ID)
+	}
+	if got, want := ids, []uint32{0, 1, 3, 2, 6}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frames: got=%v, want=%v", got, want)
+	}
+}
+
+func TestTunnelErrors(t *testing.T) {
+	conn := listen(t)
+	defer conn.Close()
+	raddr := conn.LocalAddr().(*net.UDPAddr)
+
+	for _, tc := range []struct {
+		cfg Config
+		err error
+	}{
+		{
+			cfg: Config{MaxSize: 32},
+			err: fmt.Errorf("cannelloni: invalid maximum datagram size 32"),
+		},
+		{
+			cfg: Config{Timeout: -time.Second},
+			err: fmt.Errorf("cannelloni: invalid timeout -1s"),
+		},
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := newTunnel(newFakeBus(), conn, raddr, tc.cfg)
+			if got, want := fmt.Sprint(err), tc.err.Error(); got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cantag encodes and decodes Go structs to and from CAN frames,
+// according to struct field tags.
+//
+// The message identifier is declared with the tag of a blank field,
+// and each signal with the tag of an exported field:
+//
+//	type EngineData struct {
+//	    _           struct{} `can:"id=0x100,size=8"`
+//	    EngineSpeed float64  `can:"start=0,len=16,scale=0.25"`
+//	    CoolantTemp float64  `can:"start=16,len=8,signed,offset=-40,min=-40,max=215"`
+//	    ThrottlePos float64  `can:"start=31,len=10,order=motorola,scale=0.1"`
+//	    Gear        uint8    `can:"start=40,len=3"`
+//	}
+//
+// The keys of the message tag are:
+//   - id: the CAN identifier of the message (decimal, 0x-prefixed hexadecimal, ...),
+//   - ext: the message uses the extended frame format,
+//   - size: the size of the message payload, in bytes (default: 8).
+//
+// The keys of the signal tags are:
+//   - start: the start bit of the signal (see candb.ByteOrder),
+//   - len: the length of the signal, in bits (default: 1 for bool fields),
+//   - order: the byte order of the signal, intel (default) or motorola,
+//   - signed: the raw value is a two's complement signed integer
+//     (default for signed integer fields),
+//   - unsigned: the raw value is an unsigned integer,
+//   - float: the raw value is an IEEE 754 floating point number,
+//   - scale, offset: the conversion from raw to physical values
+//     (phys = raw*scale + offset),
+//   - min, max: the allowed range of physical values,
+//   - name: the name of the signal (default: the name of the field).
+//
+// Fields without a can tag, or with a "-" tag, are ignored.
+// Supported field types are bool, integers and floating point numbers.
+// Values are converted through float64, integer values larger than 2^53
+// may thus lose precision.
+//
+// Tags are checked once, when a type is registered with a Codec:
+// signals may not overlap each other nor extend past the end of the
+// message, and fields must be able to hold all the physical values of
+// their signal, so that an uint8 field may not hold a 16-bit signal, nor
+// an integer field a signal with a fractional scale.
+package cantag
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/candb"
+)
+
+// Codec marshals and unmarshals registered struct types to and from
+// CAN frames.
+//
+// A Codec is safe for concurrent use by multiple goroutines.
+type Codec struct {
+	mu    sync.RWMutex
+	types map[reflect.Type]*message
+	ids   map[msgKey]*message
+}
+
+type msgKey struct {
+	id  uint32
+	ext bool
+}
+
+type message struct {
+	typ    reflect.Type
+	desc   *candb.Message
+	fields []int // index of the struct field of each signal
+}
+
+// NewCodec returns a new codec, with no registered type.
+func NewCodec() *Codec {
+	return &Codec{
+		types: make(map[reflect.Type]*message),
+		ids:   make(map[msgKey]*message),
+	}
+}
+
+// Register checks the tags of the struct type of v, a struct value or
+// a pointer to a struct, and registers it with the codec.
+func (c *Codec) Register(v any) error {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return fmt.Errorf("cantag: invalid type %T (not a struct)", v)
+	}
+
+	msg, err := newMessage(typ)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, dup := c.types[typ]; dup {
+		return fmt.Errorf("cantag: type %v already registered", typ)
+	}
+	key := msgKey{id: msg.desc.ID, ext: msg.desc.Extended}
+	if prev, dup := c.ids[key]; dup {
+		return fmt.Errorf(
+			"cantag: type %v: message identifier 0x%x already used by type %v",
+			typ, key.id, prev.typ,
+		)
+	}
+	c.types[typ] = msg
+	c.ids[key] = msg
+	return nil
+}
+
+// Message returns the description of the registered type of v, or nil.
+func (c *Codec) Message(v any) *candb.Message {
+	msg, err := c.lookup(v)
+	if err != nil {
+		return nil
+	}
+	return msg.desc
+}
+
+// Marshal encodes v, a value of a registered type or a pointer to it,
+// into a new frame.
+func (c *Codec) Marshal(v any) (canbus.Frame, error) {
+	msg, err := c.lookup(v)
+	if err != nil {
+		return canbus.Frame{}, err
+	}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+
+	frame := canbus.Frame{
+		ID:   msg.desc.ID,
+		Kind: msg.desc.Kind(),
+		Data: make([]byte, msg.desc.Size),
+	}
+	for i, sig := range msg.desc.Signals {
+		err := sig.Encode(frame.Data, getValue(rv.Field(msg.fields[i])))
+		if err != nil {
+			return canbus.Frame{}, fmt.Errorf("cantag: could not marshal %v: %w", msg.typ, err)
+		}
+	}
+	return frame, nil
+}
+
+// Unmarshal decodes the provided frame into v, a pointer to a value of
+// a registered type.
+//
+// Unmarshal returns candb.ErrShortFrame if some signals could not be
+// decoded because the frame is too short, and candb.ErrRange if some
+// decoded values lie outside of their allowed range. In both cases,
+// all the other signals are decoded.
+func (c *Codec) Unmarshal(f canbus.Frame, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("cantag: invalid value %T (not a non-nil pointer)", v)
+	}
+	msg, err := c.lookup(v)
+	if err != nil {
+		return err
+	}
+	if f.ID != msg.desc.ID || f.Kind != msg.desc.Kind() {
+		return fmt.Errorf(
+			"cantag: could not unmarshal frame 0x%x (%v) into %v",
+			f.ID, f.Kind, msg.typ,
+		)
+	}
+	return msg.decode(f, rv.Elem())
+}
+
+// Decode decodes the provided frame into a new value of the matching
+// registered type, and returns a pointer to it.
+// Decode returns candb.ErrUnknownMessage if no registered type matches
+// the frame.
+func (c *Codec) Decode(f canbus.Frame) (any, error) {
+	c.mu.RLock()
+	msg, ok := c.ids[msgKey{id: f.ID, ext: f.Kind == canbus.EFF}]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, candb.ErrUnknownMessage
+	}
+	rv := reflect.New(msg.typ)
+	return rv.Interface(), msg.decode(f, rv.Elem())
+}
+
+func (c *Codec) lookup(v any) (*message, error) {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	c.mu.RLock()
+	msg, ok := c.types[typ]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("cantag: type %T not registered", v)
+	}
+	return msg, nil
+}
+
+func (msg *message) decode(f canbus.Frame, rv reflect.Value) error {
+	var err error
+	for i, sig := range msg.desc.Signals {
+		v, e := sig.Decode(f.Data)
+		if e != nil {
+			err = e
+			continue
+		}
+		if !sig.InRange(v) && err == nil {
+			err = candb.ErrRange
+		}
+		setValue(rv.Field(msg.fields[i]), v)
+	}
+	return err
+}
+
+func newMessage(typ reflect.Type) (*message, error) {
+	msg := &message{
+		typ:  typ,
+		desc: &candb.Message{Name: typ.Name(), Size: 8},
+	}
+
+	hasID := false
+	for i := 0; i < typ.NumField(); i++ {
+		ft := typ.Field(i)
+		tag, ok := ft.Tag.Lookup("can")
+		if !ok || tag == "-" {
+			continue
+		}
+
+		if ft.Name == "_" {
+			if hasID {
+				return nil, fmt.Errorf("cantag: type %v: duplicate message tag", typ)
+			}
+			err := parseMessageTag(msg.desc, tag)
+			if err != nil {
+				return nil, fmt.Errorf("cantag: type %v: %w", typ, err)
+			}
+			hasID = true
+			continue
+		}
+
+		if !ft.IsExported() {
+			return nil, fmt.Errorf("cantag: type %v: field %s: unexported field", typ, ft.Name)
+		}
+		sig, err := parseSignalTag(ft, tag)
+		if err != nil {
+			return nil, fmt.Errorf("cantag: type %v: field %s: %w", typ, ft.Name, err)
+		}
+		msg.desc.Signals = append(msg.desc.Signals, sig)
+		msg.fields = append(msg.fields, i)
+	}
+
+	if !hasID {
+		return nil, fmt.Errorf("cantag: type %v: missing message identifier", typ)
+	}
+
+	err := msg.desc.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("cantag: type %v: %w", typ, err)
+	}
+	for i, sig := range msg.desc.Signals {
+		ft := typ.Field(msg.fields[i])
+		err := checkField(ft.Type, sig)
+		if err != nil {
+			return nil, fmt.Errorf("cantag: type %v: field %s: %w", typ, ft.Name, err)
+		}
+	}
+	return msg, nil
+}
+
+// checkField checks that a field of the provided type can hold all the
+// physical values of the signal.
+func checkField(typ reflect.Type, sig *candb.Signal) error {
+	var lo, hi float64 // range of raw values
+	switch sig.Type {
+	case candb.Unsigned:
+		hi = math.Ldexp(1, sig.Length) - 1
+	case candb.Signed:
+		lo = -math.Ldexp(1, sig.Length-1)
+		hi = math.Ldexp(1, sig.Length-1) - 1
+	case candb.Float:
+		hi = math.MaxFloat64
+		if sig.Length == 32 {
+			hi = math.MaxFloat32
+		}
+		lo = -hi
+	}
+	lo = lo*sig.Factor + sig.Offset
+	hi = hi*sig.Factor + sig.Offset
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+
+	var min, max float64 // range of field values
+	switch typ.Kind() {
+	case reflect.Float64:
+		return nil
+	case reflect.Float32:
+		min, max = -math.MaxFloat32, math.MaxFloat32
+	case reflect.Bool:
+		min, max = 0, 1
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		min = -math.Ldexp(1, typ.Bits()-1)
+		max = math.Ldexp(1, typ.Bits()-1) - 1
+	default:
+		max = math.Ldexp(1, typ.Bits()) - 1
+	}
+
+	integer := func(v float64) bool { return v == math.Trunc(v) }
+	if typ.Kind() != reflect.Float32 {
+		if sig.Type == candb.Float || !integer(sig.Factor) || !integer(sig.Offset) {
+			return fmt.Errorf("field type %v can not hold the non-integer values of the signal", typ)
+		}
+	}
+	if lo < min || hi > max {
+		return fmt.Errorf("field type %v can not hold the values [%g, %g] of the signal", typ, lo, hi)
+	}
+	return nil
+}
+
+func parseMessageTag(msg *candb.Message, tag string) error {
+	hasID := false
+	for _, kv := range strings.Split(tag, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		switch key {
+		case "id":
+			v, err := strconv.ParseUint(val, 0, 32)
+			if err != nil {
+				return fmt.Errorf("invalid message identifier %q", val)
+			}
+			msg.ID = uint32(v)
+			hasID = true
+		case "ext":
+			msg.Extended = true
+		case "size":
+			v, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("invalid message size %q", val)
+			}
+			msg.Size = v
+		default:
+			return fmt.Errorf("invalid message tag key %q", key)
+		}
+	}
+	if !hasID {
+		return errors.New("missing message identifier")
+	}
+	return nil
+}
+
+func parseSignalTag(ft reflect.StructField, tag string) (*candb.Signal, error) {
+	sig := &candb.Signal{
+		Name:   ft.Name,
+		Start:  -1,
+		Factor: 1,
+	}
+	switch ft.Type.Kind() {
+	case reflect.Bool:
+		sig.Length = 1
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sig.Type = candb.Signed
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		// ok.
+	default:
+		return nil, fmt.Errorf("unsupported field type %v", ft.Type)
+	}
+
+	float := func(key, val string) (float64, error) {
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s value %q", key, val)
+		}
+		return v, nil
+	}
+
+	var err error
+	for _, kv := range strings.Split(tag, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		switch key {
+		case "start":
+			sig.Start, err = strconv.Atoi(val)
+			if err != nil || sig.Start < 0 {
+				return nil, fmt.Errorf("invalid start bit %q", val)
+			}
+		case "len":
+			sig.Length, err = strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid length %q", val)
+			}
+		case "order":
+			switch strings.ToLower(val) {
+			case "intel", "little", "little-endian":
+				sig.ByteOrder = candb.LittleEndian
+			case "motorola", "big", "big-endian":
+				sig.ByteOrder = candb.BigEndian
+			default:
+				return nil, fmt.Errorf("invalid byte order %q", val)
+			}
+		case "signed":
+			sig.Type = candb.Signed
+		case "unsigned":
+			sig.Type = candb.Unsigned
+		case "float":
+			sig.Type = candb.Float
+		case "scale":
+			sig.Factor, err = float(key, val)
+		case "offset":
+			sig.Offset, err = float(key, val)
+		case "min":
+			sig.Min, err = float(key, val)
+		case "max":
+			sig.Max, err = float(key, val)
+		case "name":
+			if val == "" {
+				return nil, fmt.Errorf("invalid empty signal name")
+			}
+			sig.Name = val
+		default:
+			return nil, fmt.Errorf("invalid signal tag key %q", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case sig.Start < 0:
+		return nil, fmt.Errorf("missing start bit")
+	case sig.Length == 0:
+		return nil, fmt.Errorf("missing length")
+	}
+	return sig, nil
+}
+
+func getValue(rv reflect.Value) float64 {
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1
+		}
+		return 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	default:
+		return rv.Float()
+	}
+}
+
+func setValue(rv reflect.Value, v float64) {
+	switch rv.Kind() {
+	case reflect.Bool:
+		rv.SetBool(v != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		rv.SetInt(int64(math.Round(v)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		rv.SetUint(uint64(math.Round(v)))
+	default:
+		rv.SetFloat(v)
+	}
+}
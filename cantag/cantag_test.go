@@ -0,0 +1,315 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cantag_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/candb"
+	"github.com/go-daq/canbus/cantag"
+)
+
+type EngineData struct {
+	_           struct{} `can:"id=0x100,size=8"`
+	EngineSpeed float64  `can:"start=0,len=16,scale=0.25"`
+	CoolantTemp float64  `can:"start=16,len=8,signed,offset=-40,min=-40,max=215"`
+	ThrottlePos float64  `can:"start=31,len=10,order=motorola,scale=0.1,min=0,max=100"`
+	Gear        uint8    `can:"start=40,len=3"`
+	Brake       bool     `can:"start=43"`
+	Torque      int16    `can:"start=48,len=12,name=EngineTorque"`
+	Comment     string
+}
+
+type Status struct {
+	_       struct{} `can:"id=0x18fef100,ext,size=4"`
+	Flow    float32  `can:"start=0,len=32,float"`
+	ignored int
+}
+
+func newCodec(t *testing.T) *cantag.Codec {
+	t.Helper()
+	codec := cantag.NewCodec()
+	for _, v := range []any{EngineData{}, new(Status)} {
+		err := codec.Register(v)
+		if err != nil {
+			t.Fatalf("could not register %T: %+v", v, err)
+		}
+	}
+	return codec
+}
+
+func TestCodec(t *testing.T) {
+	codec := newCodec(t)
+
+	msg := EngineData{
+		EngineSpeed: 2000,
+		CoolantTemp: 40,
+		ThrottlePos: 50,
+		Gear:        3,
+		Brake:       true,
+		Torque:      -100,
+		Comment:     "not encoded",
+	}
+	frame, err := codec.Marshal(&msg)
+	if err != nil {
+		t.Fatalf("could not marshal: %+v", err)
+	}
+	want := canbus.Frame{
+		ID:   0x100,
+		Kind: canbus.SFF,
+		Data: []byte{0x40, 0x1f, 0x50, 0x7d, 0x00, 0x0b, 0x9c, 0x0f},
+	}
+	if !reflect.DeepEqual(frame, want) {
+		t.Fatalf("invalid frame:\ngot= %+v\nwant=%+v", frame, want)
+	}
+
+	var got EngineData
+	err = codec.Unmarshal(frame, &got)
+	if err != nil {
+		t.Fatalf("could not unmarshal: %+v", err)
+	}
+	msg.Comment = ""
+	if !reflect.DeepEqual(got, msg) {
+		t.Fatalf("invalid round-trip:\ngot= %+v\nwant=%+v", got, msg)
+	}
+
+	v, err := codec.Decode(frame)
+	if err != nil {
+		t.Fatalf("could not decode: %+v", err)
+	}
+	if !reflect.DeepEqual(v, &msg) {
+		t.Fatalf("invalid decoded value:\ngot= %+v\nwant=%+v", v, &msg)
+	}
+
+	desc := codec.Message(msg)
+	if desc == nil {
+		t.Fatalf("could not find message description")
+	}
+	if got, want := desc.Signal("EngineTorque").Length, 12; got != want {
+		t.Fatalf("invalid signal length: got=%d, want=%d", got, want)
+	}
+
+	st := Status{Flow: -1.5}
+	frame, err = codec.Marshal(st)
+	if err != nil {
+		t.Fatalf("could not marshal: %+v", err)
+	}
+	if got, want := frame.Kind, canbus.EFF; got != want {
+		t.Fatalf("invalid frame kind: got=%v, want=%v", got, want)
+	}
+	v, err = codec.Decode(frame)
+	if err != nil {
+		t.Fatalf("could not decode: %+v", err)
+	}
+	if got, want := v.(*Status).Flow, st.Flow; got != want {
+		t.Fatalf("invalid flow: got=%v, want=%v", got, want)
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	codec := newCodec(t)
+
+	_, err := codec.Marshal(EngineData{CoolantTemp: 300})
+	if !errors.Is(err, candb.ErrRange) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrRange)
+	}
+
+	var msg EngineData
+	err = codec.Unmarshal(canbus.Frame{ID: 0x100, Data: []byte{0, 0, 0xff, 0, 0, 0, 0, 0}}, &msg)
+	if !errors.Is(err, candb.ErrRange) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrRange)
+	}
+	if got, want := msg.CoolantTemp, -41.0; got != want {
+		t.Fatalf("invalid value: got=%v, want=%v", got, want)
+	}
+
+	err = codec.Unmarshal(canbus.Frame{ID: 0x100, Data: []byte{0x40, 0x1f}}, &msg)
+	if !errors.Is(err, candb.ErrShortFrame) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrShortFrame)
+	}
+	if got, want := msg.EngineSpeed, 2000.0; got != want {
+		t.Fatalf("invalid value: got=%v, want=%v", got, want)
+	}
+
+	err = codec.Unmarshal(canbus.Frame{ID: 0x101, Data: make([]byte, 8)}, &msg)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	err = codec.Unmarshal(canbus.Frame{ID: 0x100, Data: make([]byte, 8)}, msg)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	_, err = codec.Decode(canbus.Frame{ID: 0x100, Kind: canbus.EFF})
+	if !errors.Is(err, candb.ErrUnknownMessage) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrUnknownMessage)
+	}
+
+	_, err = codec.Marshal(struct{}{})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+type (
+	noID struct {
+		A uint8 `can:"start=0,len=8"`
+	}
+	badID struct {
+		_ struct{} `can:"id=0x800"`
+	}
+	overlap struct {
+		_ struct{} `can:"id=1"`
+		A uint8    `can:"start=0,len=8"`
+		B uint8    `can:"start=4,len=8"`
+	}
+	pastEnd struct {
+		_ struct{} `can:"id=1,size=2"`
+		A uint16   `can:"start=7,len=16,order=motorola"`
+		B uint8    `can:"start=16,len=8"`
+	}
+	badKey struct {
+		_ struct{} `can:"id=1"`
+		A uint8    `can:"start=0,length=8"`
+	}
+	noStart struct {
+		_ struct{} `can:"id=1"`
+		A uint8    `can:"len=8"`
+	}
+	badType struct {
+		_ struct{} `can:"id=1"`
+		A string   `can:"start=0,len=8"`
+	}
+	badFloat struct {
+		_ struct{} `can:"id=1"`
+		A float64  `can:"start=0,len=16,float"`
+	}
+	unexported struct {
+		_ struct{} `can:"id=1"`
+		a uint8    `can:"start=0,len=8"`
+	}
+	dupID struct {
+		_ struct{} `can:"id=0x100"`
+	}
+	tooLong struct {
+		_ struct{} `can:"id=1"`
+		A uint8    `can:"start=0,len=16"`
+	}
+	boolLen struct {
+		_ struct{} `can:"id=1"`
+		A bool     `can:"start=0,len=8"`
+	}
+	unsignedField struct {
+		_ struct{} `can:"id=1"`
+		A uint16   `can:"start=0,len=8,signed"`
+	}
+	negOffset struct {
+		_ struct{} `can:"id=1"`
+		A uint8    `can:"start=0,len=4,offset=-1"`
+	}
+	fracScale struct {
+		_ struct{} `can:"id=1"`
+		A int16    `can:"start=0,len=8,scale=0.5"`
+	}
+	floatField struct {
+		_ struct{} `can:"id=1"`
+		A float32  `can:"start=0,len=64,float"`
+	}
+)
+
+func TestRegisterErrors(t *testing.T) {
+	for _, tc := range []struct {
+		v   any
+		err string
+	}{
+		{
+			v:   42,
+			err: "cantag: invalid type int (not a struct)",
+		},
+		{
+			v:   noID{},
+			err: "cantag: type cantag_test.noID: missing message identifier",
+		},
+		{
+			v:   badID{},
+			err: `cantag: type cantag_test.badID: candb: message "badID": invalid identifier 0x800`,
+		},
+		{
+			v:   overlap{},
+			err: `cantag: type cantag_test.overlap: candb: message "overlap": signals "A" and "B" overlap`,
+		},
+		{
+			v:   pastEnd{},
+			err: `cantag: type cantag_test.pastEnd: candb: message "pastEnd": signal "B": bits past the end of the 2-byte message`,
+		},
+		{
+			v:   badKey{},
+			err: `cantag: type cantag_test.badKey: field A: invalid signal tag key "length"`,
+		},
+		{
+			v:   noStart{},
+			err: "cantag: type cantag_test.noStart: field A: missing start bit",
+		},
+		{
+			v:   badType{},
+			err: "cantag: type cantag_test.badType: field A: unsupported field type string",
+		},
+		{
+			v:   badFloat{},
+			err: `cantag: type cantag_test.badFloat: candb: message "badFloat": signal "A": invalid float length 16`,
+		},
+		{
+			v:   unexported{},
+			err: "cantag: type cantag_test.unexported: field a: unexported field",
+		},
+		{
+			v:   dupID{},
+			err: "cantag: type cantag_test.dupID: message identifier 0x100 already used by type cantag_test.EngineData",
+		},
+		{
+			v:   EngineData{},
+			err: "cantag: type cantag_test.EngineData already registered",
+		},
+		{
+			v:   tooLong{},
+			err: "cantag: type cantag_test.tooLong: field A: field type uint8 can not hold the values [0, 65535] of the signal",
+		},
+		{
+			v:   boolLen{},
+			err: "cantag: type cantag_test.boolLen: field A: field type bool can not hold the values [0, 255] of the signal",
+		},
+		{
+			v:   unsignedField{},
+			err: "cantag: type cantag_test.unsignedField: field A: field type uint16 can not hold the values [-128, 127] of the signal",
+		},
+		{
+			v:   negOffset{},
+			err: "cantag: type cantag_test.negOffset: field A: field type uint8 can not hold the values [-1, 14] of the signal",
+		},
+		{
+			v:   fracScale{},
+			err: "cantag: type cantag_test.fracScale: field A: field type int16 can not hold the non-integer values of the signal",
+		},
+		{
+			v:   floatField{},
+			err: "cantag: type cantag_test.floatField: field A: field type float32 can not hold the values [-1.7976931348623157e+308, 1.7976931348623157e+308] of the signal",
+		},
+	} {
+		t.Run(reflect.TypeOf(tc.v).Name(), func(t *testing.T) {
+			codec := newCodec(t)
+			err := codec.Register(tc.v)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
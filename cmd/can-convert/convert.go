@@ -0,0 +1,68 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/cmd/internal/logopt"
+)
+
+// options describes the selection and transformation of the converted
+// records.
+type options struct {
+	start logopt.Bound
+	end   logopt.Bound
+	ids   logopt.IDFilter
+	chans logopt.ChanMap
+}
+
+// convert writes the selected records of the inputs to w, merged by
+// time, and returns the number of records written.
+func convert(w canlog.Writer, inputs []canlog.Reader, opts options) (int, error) {
+	rs := make([]canlog.Reader, len(inputs))
+	for i, r := range inputs {
+		rs[i] = opts.chans.Reader(i+1, r)
+	}
+	r := canlog.Merge(rs...)
+
+	var (
+		n          int
+		init       bool
+		start, end time.Time
+	)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("could not read record: %w", err)
+		}
+		if !init {
+			init = true
+			start = opts.start.Resolve(rec.Time)
+			end = opts.end.Resolve(rec.Time)
+		}
+		if opts.start.IsSet() && rec.Time.Before(start) {
+			continue
+		}
+		if opts.end.IsSet() && !rec.Time.Before(end) {
+			break
+		}
+		if !opts.ids.Match(rec.Frame) {
+			continue
+		}
+		err = w.Write(rec)
+		if err != nil {
+			return n, fmt.Errorf("could not write record: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
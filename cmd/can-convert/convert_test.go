@@ -0,0 +1,141 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/candump"
+	"github.com/go-daq/canbus/cmd/internal/logopt"
+)
+
+func TestConvert(t *testing.T) {
+	const (
+		in1 = `(1660000000.000000) can0 123#01
+(1660000000.500000) can0 7DF#02
+(1660000001.000000) can1 18FEF100#03
+(1660000002.000000) can0 20000004#0004000000000000
+(1660000003.000000) can0 150#04
+`
+		in2 = `(1660000000.250000) can0 100#11
+(1660000001.500000) can0 200#12
+(1660000004.000000) can0 1FF#13
+`
+	)
+	for _, tc := range []struct {
+		name  string
+		start string
+		end   string
+		ids   string
+		chans string
+		want  string
+	}{
+		{
+			name: "merge",
+			want: `(1660000000.000000) can0 123#01
+(1660000000.250000) can0 100#11
+(1660000000.500000) can0 7DF#02
+(1660000001.000000) can1 18FEF100#03
+(1660000001.500000) can0 200#12
+(1660000002.000000) can0 20000004#0004000000000000
+(1660000003.000000) can0 150#04
+(1660000004.000000) can0 1FF#13
+`,
+		},
+		{
+			name:  "relative-range",
+			start: "500ms",
+			end:   "3s",
+			want: `(1660000000.500000) can0 7DF#02
+(1660000001.000000) can1 18FEF100#03
+(1660000001.500000) can0 200#12
+(1660000002.000000) can0 20000004#0004000000000000
+`,
+		},
+		{
+			name:  "absolute-range",
+			start: "2022-08-08T23:06:41Z",
+			end:   "2022-08-08T23:06:42.000001Z",
+			want: `(1660000001.000000) can1 18FEF100#03
+(1660000001.500000) can0 200#12
+(1660000002.000000) can0 20000004#0004000000000000
+`,
+		},
+		{
+			name: "ids",
+			ids:  "100-1ff,!123,18fef100",
+			want: `(1660000000.250000) can0 100#11
+(1660000001.000000) can1 18FEF100#03
+(1660000002.000000) can0 20000004#0004000000000000
+(1660000003.000000) can0 150#04
+(1660000004.000000) can0 1FF#13
+`,
+		},
+		{
+			name: "exclude",
+			ids:  "!0-1ff",
+			want: `(1660000000.500000) can0 7DF#02
+(1660000001.000000) can1 18FEF100#03
+(1660000001.500000) can0 200#12
+(1660000002.000000) can0 20000004#0004000000000000
+`,
+		},
+		{
+			name:  "map",
+			end:   "1s",
+			chans: "can0=vcan0,2:can0=vcan1",
+			want: `(1660000000.000000) vcan0 123#01
+(1660000000.250000) vcan1 100#11
+(1660000000.500000) vcan0 7DF#02
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				opts options
+				err  error
+			)
+			opts.start, err = logopt.ParseBound(tc.start)
+			if err != nil {
+				t.Fatalf("could not parse start: %+v", err)
+			}
+			opts.end, err = logopt.ParseBound(tc.end)
+			if err != nil {
+				t.Fatalf("could not parse end: %+v", err)
+			}
+			opts.ids, err = logopt.ParseIDFilter(tc.ids)
+			if err != nil {
+				t.Fatalf("could not parse identifiers: %+v", err)
+			}
+			opts.chans, err = logopt.ParseChanMap(tc.chans)
+			if err != nil {
+				t.Fatalf("could not parse channel map: %+v", err)
+			}
+
+			var buf bytes.Buffer
+			w := candump.NewWriter(&buf)
+			n, err := convert(w, []canlog.Reader{
+				candump.NewReader(strings.NewReader(in1)),
+				candump.NewReader(strings.NewReader(in2)),
+			}, opts)
+			if err != nil {
+				t.Fatalf("could not convert: %+v", err)
+			}
+			err = w.Close()
+			if err != nil {
+				t.Fatalf("could not close writer: %+v", err)
+			}
+			if got, want := n, strings.Count(tc.want, "\n"); got != want {
+				t.Fatalf("invalid number of records: got=%d, want=%d", got, want)
+			}
+			if got, want := buf.String(), tc.want; got != want {
+				t.Fatalf("invalid output:\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
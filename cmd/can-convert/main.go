@@ -0,0 +1,164 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// can-convert converts CAN bus log files between formats.
+//
+// The format of the input files is detected from their content, and
+// the format of the output file from its extension, or from the -f
+// option. Supported formats are candump (.log), Vector ASC (.asc) and
+// BLF (.blf), pcap (.pcap), pcapng (.pcapng), ASAM MDF 4 (.mf4), PEAK
+// TRC (.trc) and SavvyCAN GVRET CSV (.csv).
+//
+// The records of several input files are merged by time.
+// Records can be selected by time range (-start, -end), and by frame
+// identifier (-id), and their interfaces renamed (-map).
+//
+// Usage of can-convert:
+//
+//	can-convert [options] <input file> [<input file>...]
+//
+// Examples:
+//
+//	can-convert -o trace.mf4 trace.blf
+//	can-convert -o merged.asc can0.log can1.trc
+//	can-convert -start 10s -end 1m -o slice.log trace.asc
+//	can-convert -start 2022-08-08T23:06:40Z -id '100-1ff,!123' trace.blf
+//	can-convert -map 'vcan0=1,2:vcan0=2' -o trace.blf a.log b.log
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/logfile"
+	"github.com/go-daq/canbus/cmd/internal/logopt"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(
+			os.Stderr,
+			`can-convert converts CAN bus log files between formats.
+
+Usage of can-convert:
+
+sh> can-convert [options] <input file> [<input file>...]
+
+The records of several input files are merged by time.
+
+Examples:
+
+ can-convert -o trace.mf4 trace.blf
+ can-convert -o merged.asc can0.log can1.trc
+ can-convert -start 10s -end 1m -o slice.log trace.asc
+ can-convert -start 2022-08-08T23:06:40Z -id '100-1ff,!123' trace.blf
+ can-convert -map 'vcan0=1,2:vcan0=2' -o trace.blf a.log b.log
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	var (
+		out    = flag.String("o", "", "path to the output file (default: stdout)")
+		format = flag.String("f", "", "format of the output file: candump, asc, blf, pcap, pcapng, mf4, trc or csv (default: from the output file extension, or candump)")
+		start  = flag.String("start", "", "start of the converted records: duration since the first record (10s), or RFC 3339 time")
+		end    = flag.String("end", "", "end of the converted records: duration since the first record (1m), or RFC 3339 time")
+		ids    = flag.String("id", "", "comma-separated list of hexadecimal frame identifiers (123) and ranges (100-1ff) to convert, or to skip when prefixed by '!'")
+		chans  = flag.String("map", "", "comma-separated list of interface renamings (can0=1), restricted to the n-th input file when prefixed by 'n:'")
+	)
+
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("can-convert> ")
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		opts options
+		err  error
+	)
+	opts.start, err = logopt.ParseBound(*start)
+	if err != nil {
+		log.Fatalf("invalid -start option: %+v", err)
+	}
+	opts.end, err = logopt.ParseBound(*end)
+	if err != nil {
+		log.Fatalf("invalid -end option: %+v", err)
+	}
+	opts.ids, err = logopt.ParseIDFilter(*ids)
+	if err != nil {
+		log.Fatalf("invalid -id option: %+v", err)
+	}
+	opts.chans, err = logopt.ParseChanMap(*chans)
+	if err != nil {
+		log.Fatalf("invalid -map option: %+v", err)
+	}
+
+	ofmt := logfile.Unknown
+	if *format != "" {
+		ofmt, err = logfile.ParseFormat(*format)
+		if err != nil {
+			log.Fatalf("invalid -f option: %+v", err)
+		}
+	}
+	if *out == "" {
+		switch ofmt {
+		case logfile.Unknown:
+			ofmt = logfile.Candump
+		case logfile.MDF:
+			log.Fatalf("invalid -f option: %v output requires -o", ofmt)
+		}
+	}
+
+	err = run(*out, ofmt, flag.Args(), opts)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// run converts the named input files to the output file, or to stdout.
+func run(out string, ofmt logfile.Format, fnames []string, opts options) error {
+	inputs := make([]canlog.Reader, len(fnames))
+	for i, fname := range fnames {
+		r, err := logfile.Open(fname)
+		if err != nil {
+			return fmt.Errorf("could not open input file: %w", err)
+		}
+		defer r.Close()
+		inputs[i] = r
+	}
+
+	var (
+		w   canlog.Writer
+		err error
+	)
+	switch out {
+	case "":
+		w, err = logfile.NewWriter(ofmt, os.Stdout)
+	default:
+		w, err = logfile.Create(out, ofmt)
+	}
+	if err != nil {
+		return fmt.Errorf("could not create output file: %w", err)
+	}
+
+	_, err = convert(w, inputs, opts)
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("could not convert log files: %w", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("could not close output file: %w", err)
+	}
+	return nil
+}
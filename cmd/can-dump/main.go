@@ -18,6 +18,7 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -54,26 +55,44 @@ Examples:
 		flag.Usage()
 	}
 
-	sck, err := canbus.New()
+	tr, err := open(flag.Arg(0))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer sck.Close()
+	defer tr.Close()
+
+	err = dump(os.Stdout, tr)
+	if err != nil {
+		log.Fatalf("recv error: %v\n", err)
+	}
+}
+
+// open returns a CAN bus socket bound to the named interface.
+func open(addr string) (canbus.Transport, error) {
+	sck, err := canbus.New()
+	if err != nil {
+		return nil, err
+	}
 
-	addr := flag.Arg(0)
 	err = sck.Bind(addr)
 	if err != nil {
-		log.Fatalf("error binding to [%s]: %v\n", addr, err)
+		sck.Close()
+		return nil, fmt.Errorf("error binding to [%s]: %w", addr, err)
 	}
+	return sck, nil
+}
 
+// dump prints the frames received from the transport until receiving
+// fails.
+func dump(w io.Writer, tr canbus.Transport) error {
 	var blank = strings.Repeat(" ", 24)
 	for {
-		msg, err := sck.Recv()
+		msg, err := tr.Recv()
 		if err != nil {
-			log.Fatalf("recv error: %v\n", err)
+			return err
 		}
 		ascii := strings.ToUpper(hex.Dump(msg.Data))
 		ascii = strings.TrimRight(strings.Replace(ascii, blank, "", -1), "\n")
-		fmt.Printf("%7s  %03x %s\n", sck.Name(), msg.ID, ascii)
+		fmt.Fprintf(w, "%7s  %03x %s\n", tr.Name(), msg.ID, ascii)
 	}
 }
@@ -0,0 +1,57 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/vcan"
+)
+
+func TestDump(t *testing.T) {
+	bus := vcan.New("vcan0")
+	w := bus.Open()
+	defer w.Close()
+	r := bus.Open()
+	defer r.Close()
+
+	err := r.SetRecvTimeout(10 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("could not set recv timeout: %+v", err)
+	}
+
+	for _, frame := range []canbus.Frame{
+		{ID: 0x123, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		{ID: 0x7ff, Data: []byte("data-001")},
+		{ID: 0x042, Data: []byte{}},
+	} {
+		_, err := w.Send(frame)
+		if err != nil {
+			t.Fatalf("could not send frame: %+v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	err = dump(&out, r)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, os.ErrDeadlineExceeded)
+	}
+
+	want := strings.Join([]string{
+		"  vcan0  123 00000000  DE AD BE EF               |....|",
+		"  vcan0  7ff 00000000  64 61 74 61 2D 30 30 31   |DATA-001|",
+		"  vcan0  042 ",
+		"",
+	}, "\n")
+	if got := out.String(); got != want {
+		t.Fatalf("invalid dump:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,760 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-daq/canbus/candb"
+)
+
+// generate generates the Go code of package pkg for the messages of db.
+// src is the name of the description file db was read from.
+func generate(db *candb.Database, pkg, src string) ([]byte, error) {
+	if len(db.Messages) == 0 {
+		return nil, fmt.Errorf("no message to generate")
+	}
+
+	g := &generator{
+		pkg:   pkg,
+		names: make(map[string]string),
+	}
+	for _, name := range []string{"Message", "UnmarshalFrame"} {
+		g.names[name] = "generated code"
+	}
+
+	msgs := make([]*message, len(db.Messages))
+	for i, msg := range db.Messages {
+		m, err := g.newMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+		msgs[i] = m
+	}
+
+	g.printf("// Code generated by can-gen from %s. DO NOT EDIT.\n\n", src)
+	g.printf("package %s\n\n", pkg)
+	g.printf("import (\n\"fmt\"\n\"math\"\n\n")
+	g.printf("%q\n%q\n)\n\n", "github.com/go-daq/canbus", "github.com/go-daq/canbus/candb")
+
+	g.printf("// Message identifiers.\nconst (\n")
+	for _, m := range msgs {
+		g.printf("%s = 0x%x\n", m.id, m.ID)
+	}
+	g.printf(")\n\n")
+
+	g.genDispatch(msgs)
+	for _, m := range msgs {
+		g.genMessage(m)
+	}
+	g.genHelpers()
+
+	out, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("could not format generated code: %w", err)
+	}
+	return out, nil
+}
+
+type generator struct {
+	buf   bytes.Buffer
+	pkg   string
+	names map[string]string // package-level identifiers
+}
+
+type message struct {
+	*candb.Message
+	typ     string // name of the message type
+	id      string // name of the identifier constant
+	signals []*signal
+}
+
+type signal struct {
+	*candb.Signal
+	field  string // name of the struct field
+	enum   string // name of the enum type, if any
+	values []enumValue
+	mux    *signal
+	sw     bool // whether the signal is a multiplexer switch
+}
+
+type enumValue struct {
+	name  string
+	value int64
+	desc  string
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// declare registers a package-level identifier.
+func (g *generator) declare(name, what string) error {
+	if prev, dup := g.names[name]; dup {
+		return fmt.Errorf("identifier %s of %s already used by %s", name, what, prev)
+	}
+	g.names[name] = what
+	return nil
+}
+
+func (g *generator) newMessage(msg *candb.Message) (*message, error) {
+	m := &message{
+		Message: msg,
+		typ:     goName(msg.Name),
+		signals: make([]*signal, len(msg.Signals)),
+	}
+	m.id = m.typ + "ID"
+
+	what := fmt.Sprintf("message %q", msg.Name)
+	for _, name := range []string{m.typ, m.id} {
+		err := g.declare(name, what)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fields := map[string]string{
+		"MarshalFrame":   "method",
+		"UnmarshalFrame": "method",
+		"Validate":       "method",
+	}
+	index := make(map[*candb.Signal]*signal, len(msg.Signals))
+	for i, sig := range msg.Signals {
+		s := &signal{Signal: sig, field: goName(sig.Name)}
+		what := fmt.Sprintf("signal %q of message %q", sig.Name, msg.Name)
+		if prev, dup := fields[s.field]; dup {
+			return nil, fmt.Errorf("field %s of %s already used by %s", s.field, what, prev)
+		}
+		fields[s.field] = what
+
+		if len(sig.Values) > 0 && sig.Type != candb.Float {
+			s.enum = m.typ + s.field
+			err := g.declare(s.enum, what)
+			if err != nil {
+				return nil, err
+			}
+			err = g.newEnumValues(s, what)
+			if err != nil {
+				return nil, err
+			}
+		}
+		m.signals[i] = s
+		index[sig] = s
+	}
+	for _, s := range m.signals {
+		if s.Mux != nil {
+			s.mux = index[s.Mux]
+			s.mux.sw = true
+		}
+	}
+	return m, nil
+}
+
+func (g *generator) newEnumValues(s *signal, what string) error {
+	seen := make(map[int64]bool, len(s.Values))
+	names := make(map[string]bool, len(s.Values))
+	for _, v := range s.Values {
+		if seen[v.Value] {
+			continue
+		}
+		seen[v.Value] = true
+
+		name := s.enum + descName(v.Desc)
+		if name == s.enum || names[name] {
+			name = s.enum + valueName(v.Value)
+		}
+		names[name] = true
+		err := g.declare(name, what)
+		if err != nil {
+			return err
+		}
+		s.values = append(s.values, enumValue{name: name, value: v.Value, desc: v.Desc})
+	}
+	return nil
+}
+
+func (g *generator) genDispatch(msgs []*message) {
+	g.printf(`// Message is implemented by all the message types of the package.
+type Message interface {
+	// MarshalFrame encodes the message into a new frame.
+	MarshalFrame() canbus.Frame
+	// UnmarshalFrame decodes the provided frame into the message.
+	UnmarshalFrame(f canbus.Frame) error
+	// Validate checks that all the signal values lie within their
+	// allowed range.
+	Validate() error
+}
+
+// UnmarshalFrame decodes the provided frame into a new value of the
+// matching message type.
+// UnmarshalFrame returns candb.ErrUnknownMessage if no message type
+// matches the frame.
+func UnmarshalFrame(f canbus.Frame) (Message, error) {
+	var m Message
+	switch {
+`)
+	for _, m := range msgs {
+		g.printf("case f.ID == %s && f.Kind == canbus.%s:\nm = new(%s)\n", m.id, m.Kind(), m.typ)
+	}
+	g.printf(`default:
+		return nil, fmt.Errorf("%s: frame 0x%%x: %%w", f.ID, candb.ErrUnknownMessage)
+	}
+	return m, m.UnmarshalFrame(f)
+}
+
+`, g.pkg)
+}
+
+func (g *generator) genMessage(m *message) {
+	for _, s := range m.signals {
+		if s.enum != "" {
+			g.genEnum(m, s)
+		}
+	}
+
+	g.printf("// %s is message 0x%x", m.typ, m.ID)
+	if m.Extended {
+		g.printf(" (extended)")
+	}
+	if m.Sender != "" && m.Sender != "Vector__XXX" {
+		g.printf(", sent by %s", m.Sender)
+	}
+	g.printf(".\n")
+	if strings.TrimSpace(m.Comment) != "" {
+		g.printf("//\n")
+		g.comment(m.Comment)
+	}
+	g.printf("type %s struct {\n", m.typ)
+	for _, s := range m.signals {
+		g.comment(s.Comment)
+		typ := s.enum
+		if typ == "" {
+			typ = "float64"
+		}
+		g.printf("%s %s // %s\n", s.field, typ, s.doc())
+	}
+	g.printf("}\n\n")
+
+	g.genMarshal(m)
+	g.genUnmarshal(m)
+	g.genValidate(m)
+}
+
+func (g *generator) genEnum(m *message, s *signal) {
+	g.printf(
+		"// %s describes the raw values of signal %s of message %s.\n",
+		s.enum, s.Name, m.Name,
+	)
+	g.printf("type %s %s\n\n", s.enum, s.intType())
+	g.printf("const (\n")
+	for _, v := range s.values {
+		g.printf("%s %s = %d\n", v.name, s.enum, v.value)
+	}
+	g.printf(")\n\n")
+
+	g.printf("func (v %s) String() string {\nswitch v {\n", s.enum)
+	for _, v := range s.values {
+		g.printf("case %s:\nreturn %q\n", v.name, v.desc)
+	}
+	g.printf("}\nreturn fmt.Sprintf(\"%s(%%d)\", int64(v))\n}\n\n", s.enum)
+}
+
+func (g *generator) genMarshal(m *message) {
+	g.printf(`// MarshalFrame encodes the message into a new frame.
+// Multiplexed signals are only encoded when selected by their
+// multiplexer switches.
+// Values that do not fit in their signal are saturated.
+func (m *%s) MarshalFrame() canbus.Frame {
+	f := canbus.Frame{
+		ID:   %s,
+		Kind: canbus.%s,
+		Data: make([]byte, %d),
+	}
+`, m.typ, m.id, m.Kind(), m.Size)
+
+	for _, s := range m.signals {
+		if s.sw {
+			g.printf("%s := %s\n", s.muxVar(), s.rawFromField())
+		}
+	}
+	if len(m.signals) > 0 {
+		g.printf("var raw uint64\n")
+	}
+	for _, s := range m.signals {
+		cond := s.cond()
+		if cond != "" {
+			g.printf("if %s {\n", cond)
+		}
+		if s.sw {
+			g.printf("raw = %s\n", s.muxVar())
+		} else {
+			g.printf("raw = %s\n", s.rawFromField())
+		}
+		g.setRaw(s, "f.Data", "raw")
+		if cond != "" {
+			g.printf("}\n")
+		}
+	}
+	g.printf("return f\n}\n\n")
+}
+
+func (g *generator) genUnmarshal(m *message) {
+	g.printf(`// UnmarshalFrame decodes the provided frame into the message.
+// Multiplexed signals absent from the frame are set to zero.
+// UnmarshalFrame returns candb.ErrRange if some decoded values lie
+// outside of their allowed range. In that case, all the signals are
+// decoded.
+func (m *%[1]s) UnmarshalFrame(f canbus.Frame) error {
+	if f.ID != %[2]s || f.Kind != canbus.%[3]s {
+		return fmt.Errorf("%[4]s: %[1]s: invalid frame 0x%%x (%%v)", f.ID, f.Kind)
+	}
+	if len(f.Data) < %[5]d {
+		return fmt.Errorf("%[4]s: %[1]s: %%w", candb.ErrShortFrame)
+	}
+`, m.typ, m.id, m.Kind(), g.pkg, m.Size)
+
+	for _, s := range m.signals {
+		if s.sw {
+			g.printf("%s := %s\n", s.muxVar(), s.rawFromData("f.Data"))
+		}
+	}
+	g.printf("*m = %s{}\n", m.typ)
+	for _, s := range m.signals {
+		cond := s.cond()
+		if cond != "" {
+			g.printf("if %s {\n", cond)
+		}
+		raw := s.muxVar()
+		if !s.sw {
+			raw = s.rawFromData("f.Data")
+		}
+		g.printf("m.%s = %s\n", s.field, s.fieldFromRaw(raw))
+		if cond != "" {
+			g.printf("}\n")
+		}
+	}
+	g.printf("return m.Validate()\n}\n\n")
+}
+
+func (g *generator) genValidate(m *message) {
+	g.printf(`// Validate checks that the values of the signals present in the
+// message lie within their allowed range.
+func (m *%s) Validate() error {
+`, m.typ)
+
+	// only compute the multiplexer switches needed by range checks.
+	var (
+		checked []*signal
+		needed  = make(map[*signal]bool)
+	)
+	for _, s := range m.signals {
+		if s.Min == 0 && s.Max == 0 {
+			continue
+		}
+		checked = append(checked, s)
+		for x := s.mux; x != nil; x = x.mux {
+			needed[x] = true
+		}
+	}
+	for _, s := range m.signals {
+		if needed[s] {
+			g.printf("%s := %s\n", s.muxVar(), s.rawFromField())
+		}
+	}
+	for _, s := range checked {
+		phys := s.physFromField()
+		check := fmt.Sprintf("!(%s <= %s && %s <= %s)", lit(s.Min), phys, phys, lit(s.Max))
+		if cond := s.cond(); cond != "" {
+			check = "(" + cond + ") && " + check
+		}
+		g.printf("if %s {\n", check)
+		g.printf(
+			"return fmt.Errorf(%q, %s, candb.ErrRange)\n}\n",
+			fmt.Sprintf(
+				"%s: %s: signal %s: value %%v outside of [%s, %s]: %%w",
+				g.pkg, m.typ, s.field, lit(s.Min), lit(s.Max),
+			),
+			phys,
+		)
+	}
+	g.printf("return nil\n}\n\n")
+}
+
+func (g *generator) genHelpers() {
+	g.printf(`// toUnsigned rounds v and saturates it to an unsigned integer of
+// the provided bit length.
+func toUnsigned(v float64, bits int) uint64 {
+	v = math.Round(v)
+	switch {
+	case !(v > 0):
+		return 0
+	case v >= math.Ldexp(1, bits):
+		return math.MaxUint64 >> (64 - bits)
+	}
+	return uint64(v)
+}
+
+// toSigned rounds v and saturates it to a two's complement signed
+// integer of the provided bit length.
+func toSigned(v float64, bits int) uint64 {
+	v = math.Round(v)
+	lim := math.Ldexp(1, bits-1)
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case v < -lim:
+		return uint64(int64(math.MinInt64) >> (64 - bits))
+	case v >= lim:
+		return math.MaxInt64 >> (64 - bits)
+	}
+	return uint64(int64(v))
+}
+`)
+}
+
+// comment prints a Go comment holding txt, if any.
+func (g *generator) comment(txt string) {
+	txt = strings.TrimSpace(txt)
+	if txt == "" {
+		return
+	}
+	for _, line := range strings.Split(txt, "\n") {
+		g.printf("// %s\n", strings.TrimRightFunc(line, unicode.IsSpace))
+	}
+}
+
+// setRaw prints the statements encoding the raw value of the signal
+// into data.
+func (g *generator) setRaw(s *signal, data, raw string) {
+	mask := s.mask()
+	first, last := s.bounds()
+	for i := first; i <= last; i++ {
+		var v string
+		var m byte
+		switch off := s.shift(i); {
+		case off < 0:
+			v, m = fmt.Sprintf("byte(%s<<%d)", raw, -off), byte(mask<<uint(-off))
+		case off == 0:
+			v, m = fmt.Sprintf("byte(%s)", raw), byte(mask)
+		default:
+			v, m = fmt.Sprintf("byte(%s>>%d)", raw, off), byte(mask>>uint(off))
+		}
+		if m == 0xff {
+			g.printf("%s[%d] = %s\n", data, i, v)
+			continue
+		}
+		g.printf("%s[%d] = %s[%d]&^0x%02x | %s&0x%02x\n", data, i, data, i, m, v, m)
+	}
+}
+
+// doc returns the trailing comment of the struct field of the signal.
+func (s *signal) doc() string {
+	var doc []string
+	if s.enum != "" {
+		doc = append(doc, "raw value")
+	}
+	if s.Min != 0 || s.Max != 0 {
+		doc = append(doc, fmt.Sprintf("[%s, %s]", lit(s.Min), lit(s.Max)))
+	}
+	if s.Unit != "" {
+		doc = append(doc, strings.TrimSpace(s.Unit))
+	}
+	if s.mux != nil {
+		doc = append(doc, "multiplexed by "+s.mux.field)
+	}
+	if len(doc) == 0 {
+		return s.Name
+	}
+	return strings.Join(doc, ", ")
+}
+
+func (s *signal) muxVar() string {
+	return "mux" + s.field
+}
+
+// cond returns the condition for the signal to be present in a frame,
+// or an empty string if the signal is always present.
+func (s *signal) cond() string {
+	var conds []string
+	for x := s; x.mux != nil; x = x.mux {
+		var rs []string
+		for _, r := range x.MuxRanges {
+			if r.Min == r.Max {
+				rs = append(rs, fmt.Sprintf("%s == %d", x.mux.muxVar(), r.Min))
+				continue
+			}
+			rs = append(rs, fmt.Sprintf("%d <= %s && %s <= %d", r.Min, x.mux.muxVar(), x.mux.muxVar(), r.Max))
+		}
+		if len(rs) > 1 {
+			for i := range rs {
+				rs[i] = "(" + rs[i] + ")"
+			}
+		}
+		c := strings.Join(rs, " || ")
+		if len(rs) > 1 && (len(conds) > 0 || x.mux.mux != nil) {
+			c = "(" + c + ")"
+		}
+		conds = append([]string{c}, conds...)
+	}
+	return strings.Join(conds, " && ")
+}
+
+// intType returns the Go integer type holding the raw values of the signal.
+func (s *signal) intType() string {
+	bits := 8
+	for bits < s.Length {
+		bits *= 2
+	}
+	if s.Type == candb.Signed {
+		return fmt.Sprintf("int%d", bits)
+	}
+	return fmt.Sprintf("uint%d", bits)
+}
+
+// rawFromData returns the expression extracting the raw value of the
+// signal from data.
+func (s *signal) rawFromData(data string) string {
+	var (
+		terms []string
+		hi    int
+	)
+	first, last := s.bounds()
+	for i := first; i <= last; i++ {
+		off := s.shift(i)
+		switch {
+		case off < 0:
+			terms = append(terms, fmt.Sprintf("uint64(%s[%d])>>%d", data, i, -off))
+		case off == 0:
+			terms = append(terms, fmt.Sprintf("uint64(%s[%d])", data, i))
+		default:
+			terms = append(terms, fmt.Sprintf("uint64(%s[%d])<<%d", data, i, off))
+		}
+		if off+8 > hi {
+			hi = off + 8
+		}
+	}
+	expr := strings.Join(terms, " | ")
+	if hi > s.Length {
+		if len(terms) > 1 {
+			expr = "(" + expr + ")"
+		}
+		expr = fmt.Sprintf("%s & 0x%x", expr, s.mask())
+	}
+	return expr
+}
+
+// fieldFromRaw returns the expression converting the raw value of the
+// signal into its struct field value.
+func (s *signal) fieldFromRaw(raw string) string {
+	if s.enum != "" {
+		if s.Type == candb.Signed {
+			return fmt.Sprintf("%s(%s)", s.enum, s.signExtend(raw))
+		}
+		return fmt.Sprintf("%s(%s)", s.enum, raw)
+	}
+
+	var v string
+	switch s.Type {
+	case candb.Float:
+		if s.Length == 32 {
+			v = fmt.Sprintf("float64(math.Float32frombits(uint32(%s)))", raw)
+		} else {
+			v = fmt.Sprintf("math.Float64frombits(%s)", raw)
+		}
+	case candb.Signed:
+		v = fmt.Sprintf("float64(%s)", s.signExtend(raw))
+	default:
+		v = fmt.Sprintf("float64(%s)", raw)
+	}
+	if s.Factor != 1 {
+		v += "*" + lit(s.Factor)
+	}
+	switch {
+	case s.Offset > 0:
+		v += " + " + lit(s.Offset)
+	case s.Offset < 0:
+		v += " - " + lit(-s.Offset)
+	}
+	return v
+}
+
+func (s *signal) signExtend(raw string) string {
+	if n := 64 - s.Length; n > 0 {
+		if strings.Contains(raw, " ") {
+			raw = "(" + raw + ")"
+		}
+		return fmt.Sprintf("int64(%s<<%d)>>%d", raw, n, n)
+	}
+	return fmt.Sprintf("int64(%s)", raw)
+}
+
+// rawFromField returns the expression converting the struct field
+// value of the signal into its raw value.
+// The raw values of multiplexer switches are masked to the length of
+// the signal.
+func (s *signal) rawFromField() string {
+	field := "m." + s.field
+	if s.enum != "" {
+		if s.sw && s.Length < 64 {
+			return fmt.Sprintf("uint64(%s) & 0x%x", field, s.mask())
+		}
+		return fmt.Sprintf("uint64(%s)", field)
+	}
+
+	v := field
+	switch {
+	case s.Offset > 0:
+		v = fmt.Sprintf("%s - %s", v, lit(s.Offset))
+	case s.Offset < 0:
+		v = fmt.Sprintf("%s + %s", v, lit(-s.Offset))
+	}
+	if s.Factor != 1 {
+		if v != field {
+			v = "(" + v + ")"
+		}
+		v += "/" + lit(s.Factor)
+	}
+	switch s.Type {
+	case candb.Float:
+		if s.Length == 32 {
+			return fmt.Sprintf("uint64(math.Float32bits(float32(%s)))", v)
+		}
+		return fmt.Sprintf("math.Float64bits(%s)", v)
+	case candb.Signed:
+		v = fmt.Sprintf("toSigned(%s, %d)", v, s.Length)
+		if s.sw && s.Length < 64 {
+			v = fmt.Sprintf("%s & 0x%x", v, s.mask())
+		}
+		return v
+	default:
+		return fmt.Sprintf("toUnsigned(%s, %d)", v, s.Length)
+	}
+}
+
+// physFromField returns the expression of the physical value of the
+// signal, from its struct field value.
+func (s *signal) physFromField() string {
+	field := "m." + s.field
+	if s.enum == "" {
+		return field
+	}
+	v := fmt.Sprintf("float64(%s)", field)
+	if s.Factor != 1 {
+		v += "*" + lit(s.Factor)
+	}
+	switch {
+	case s.Offset > 0:
+		v += " + " + lit(s.Offset)
+	case s.Offset < 0:
+		v += " - " + lit(-s.Offset)
+	}
+	if v != "float64("+field+")" {
+		v = "(" + v + ")"
+	}
+	return v
+}
+
+// bounds returns the indices of the first and last bytes spanned by
+// the signal.
+//
+// Big-endian signals are handled in "linear" big-endian bit numbering,
+// where bit 0 is the most significant bit of the first byte.
+func (s *signal) bounds() (first, last int) {
+	switch s.ByteOrder {
+	case candb.BigEndian:
+		lin := s.Start/8*8 + 7 - s.Start%8
+		return lin / 8, (lin + s.Length - 1) / 8
+	default:
+		return s.Start / 8, (s.Start + s.Length - 1) / 8
+	}
+}
+
+// shift returns the left shift to apply to byte i of the frame data
+// to align it with the raw value of the signal.
+func (s *signal) shift(i int) int {
+	switch s.ByteOrder {
+	case candb.BigEndian:
+		lin := s.Start/8*8 + 7 - s.Start%8
+		return lin + s.Length - 8 - 8*i
+	default:
+		return 8*i - s.Start
+	}
+}
+
+func (s *signal) mask() uint64 {
+	if s.Length >= 64 {
+		return math.MaxUint64
+	}
+	return 1<<uint(s.Length) - 1
+}
+
+// lit returns the Go literal of v.
+func lit(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// goName returns the exported Go identifier for the provided name.
+// Underscores are removed and the letters following them upper-cased.
+func goName(name string) string {
+	var o strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part == "" {
+			continue
+		}
+		rs := []rune(part)
+		rs[0] = unicode.ToUpper(rs[0])
+		o.WriteString(string(rs))
+	}
+	out := o.String()
+	if out == "" || !unicode.IsUpper([]rune(out)[0]) {
+		out = "X" + out
+	}
+	return out
+}
+
+// descName returns the identifier suffix for the provided value
+// description, or an empty string.
+func descName(desc string) string {
+	var o strings.Builder
+	words := strings.FieldsFunc(desc, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		rs := []rune(w)
+		rs[0] = unicode.ToUpper(rs[0])
+		o.WriteString(string(rs))
+	}
+	return o.String()
+}
+
+func valueName(v int64) string {
+	if v < 0 {
+		return fmt.Sprintf("Minus%d", -v)
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// pkgName returns the default package name for the provided file name.
+func pkgName(fname string) string {
+	base := strings.TrimSuffix(filepath.Base(fname), filepath.Ext(fname))
+	var o strings.Builder
+	for _, r := range strings.ToLower(base) {
+		if 'a' <= r && r <= 'z' || '0' <= r && r <= '9' {
+			o.WriteRune(r)
+		}
+	}
+	name := o.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "can" + name
+	}
+	return name
+}
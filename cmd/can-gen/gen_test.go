@@ -0,0 +1,115 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/go-daq/canbus/candb"
+	"github.com/go-daq/canbus/dbc"
+)
+
+func TestGenerate(t *testing.T) {
+	db, err := dbc.ParseFile("../../dbc/testdata/example.dbc")
+	if err != nil {
+		t.Fatalf("could not parse DBC file: %+v", err)
+	}
+	cdb, err := db.ToCANDB()
+	if err != nil {
+		t.Fatalf("could not convert DBC file: %+v", err)
+	}
+
+	got, err := generate(cdb, "example", "example.dbc")
+	if err != nil {
+		t.Fatalf("could not generate code: %+v", err)
+	}
+
+	want, err := os.ReadFile("internal/example/example_gen.go")
+	if err != nil {
+		t.Fatalf("could not read reference file: %+v", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("generated code out of date (run go generate ./internal/example):\n%s", got)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	sig := func(name string, start int) *candb.Signal {
+		return &candb.Signal{Name: name, Start: start, Length: 8, Factor: 1}
+	}
+	for _, tc := range []struct {
+		name string
+		msgs []*candb.Message
+		err  string
+	}{
+		{
+			name: "no-message",
+			err:  "no message to generate",
+		},
+		{
+			name: "duplicate-message",
+			msgs: []*candb.Message{
+				{ID: 1, Name: "engine_data", Size: 8},
+				{ID: 2, Name: "EngineData", Size: 8},
+			},
+			err: `identifier EngineData of message "EngineData" already used by message "engine_data"`,
+		},
+		{
+			name: "reserved-message",
+			msgs: []*candb.Message{{ID: 1, Name: "Message", Size: 8}},
+			err:  `identifier Message of message "Message" already used by generated code`,
+		},
+		{
+			name: "duplicate-field",
+			msgs: []*candb.Message{{
+				ID: 1, Name: "M", Size: 8,
+				Signals: []*candb.Signal{sig("speed", 0), sig("Speed", 8)},
+			}},
+			err: `field Speed of signal "Speed" of message "M" already used by signal "speed" of message "M"`,
+		},
+		{
+			name: "method-field",
+			msgs: []*candb.Message{{
+				ID: 1, Name: "M", Size: 8,
+				Signals: []*candb.Signal{sig("Validate", 0)},
+			}},
+			err: `field Validate of signal "Validate" of message "M" already used by method`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := generate(&candb.Database{Messages: tc.msgs}, "pkg", "pkg.dbc")
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestNames(t *testing.T) {
+	for _, tc := range []struct {
+		fct  func(string) string
+		in   string
+		want string
+	}{
+		{goName, "EngineSpeed", "EngineSpeed"},
+		{goName, "engine_speed", "EngineSpeed"},
+		{goName, "_x__y_", "XY"},
+		{descName, "3rd gear", "3rdGear"},
+		{descName, "n/a", "NA"},
+		{descName, "--", ""},
+		{pkgName, "testdata/My-Car.dbc", "mycar"},
+		{pkgName, "2022.dbc", "can2022"},
+	} {
+		if got := tc.fct(tc.in); got != tc.want {
+			t.Fatalf("invalid name for %q: got=%q, want=%q", tc.in, got, tc.want)
+		}
+	}
+}
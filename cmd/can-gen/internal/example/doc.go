@@ -0,0 +1,9 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package example holds the code generated by can-gen for the example
+// DBC file of the dbc package.
+package example
+
+//go:generate go run github.com/go-daq/canbus/cmd/can-gen -p example -o example_gen.go ../../../../dbc/testdata/example.dbc
@@ -0,0 +1,200 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package example_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/candb"
+	"github.com/go-daq/canbus/cmd/can-gen/internal/example"
+	"github.com/go-daq/canbus/dbc"
+)
+
+func TestMessages(t *testing.T) {
+	db, err := dbc.ParseFile("../../../../dbc/testdata/example.dbc")
+	if err != nil {
+		t.Fatalf("could not parse DBC file: %+v", err)
+	}
+	cdb, err := db.ToCANDB()
+	if err != nil {
+		t.Fatalf("could not convert DBC file: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		msg  example.Message
+		vals map[string]float64
+	}{
+		{
+			name: "EngineData",
+			msg: &example.EngineData{
+				EngineSpeed: 2000,
+				CoolantTemp: -12,
+				ThrottlePos: 50,
+				Gear:        example.EngineDataGearDrive,
+			},
+			vals: map[string]float64{
+				"EngineSpeed": 2000,
+				"CoolantTemp": -12,
+				"ThrottlePos": 50,
+				"Gear":        3,
+			},
+		},
+		{
+			name: "Diagnostics-voltage",
+			msg: &example.Diagnostics{
+				Mode:    example.DiagnosticsModeSub,
+				Sub:     0,
+				Voltage: 12.5,
+			},
+			vals: map[string]float64{
+				"Mode":    1,
+				"Sub":     0,
+				"Voltage": 12.5,
+			},
+		},
+		{
+			name: "Diagnostics-current",
+			msg: &example.Diagnostics{
+				Mode:    5,
+				Current: -2.5,
+			},
+			vals: map[string]float64{
+				"Mode":    5,
+				"Current": -2.5,
+			},
+		},
+		{
+			name: "Diagnostics-temperature",
+			msg: &example.Diagnostics{
+				Mode:        example.DiagnosticsModeTemperature,
+				Temperature: 26.85,
+			},
+			vals: map[string]float64{
+				"Mode":        3,
+				"Temperature": 26.85,
+			},
+		},
+		{
+			name: "Sensors",
+			msg: &example.Sensors{
+				Pressure: 101325,
+				Flow:     -1.5,
+			},
+			vals: map[string]float64{
+				"Pressure": 101325,
+				"Flow":     -1.5,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			frame := tc.msg.MarshalFrame()
+
+			want, err := cdb.MessageByName(reflect.TypeOf(tc.msg).Elem().Name()).Encode(tc.vals)
+			if err != nil {
+				t.Fatalf("could not encode reference frame: %+v", err)
+			}
+			if !reflect.DeepEqual(frame, want) {
+				t.Fatalf("invalid frame:\ngot= %+v\nwant=%+v", frame, want)
+			}
+
+			got, err := example.UnmarshalFrame(frame)
+			if err != nil {
+				t.Fatalf("could not unmarshal frame: %+v", err)
+			}
+			vals := make(map[string]float64)
+			_, err = cdb.Decode(frame, vals)
+			if err != nil {
+				t.Fatalf("could not decode reference frame: %+v", err)
+			}
+			rv := reflect.ValueOf(got).Elem()
+			for name, v := range vals {
+				field := rv.FieldByName(name)
+				var fv float64
+				if field.CanFloat() {
+					fv = field.Float()
+				} else {
+					fv = float64(field.Uint())
+				}
+				if fv != v {
+					t.Fatalf("invalid value for %s: got=%v, want=%v", name, fv, v)
+				}
+			}
+		})
+	}
+}
+
+func TestUnmarshalFrameErrors(t *testing.T) {
+	_, err := example.UnmarshalFrame(canbus.Frame{ID: 0x123, Data: make([]byte, 8)})
+	if !errors.Is(err, candb.ErrUnknownMessage) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrUnknownMessage)
+	}
+
+	var msg example.EngineData
+	err = msg.UnmarshalFrame(canbus.Frame{ID: example.EngineDataID, Data: make([]byte, 4)})
+	if !errors.Is(err, candb.ErrShortFrame) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrShortFrame)
+	}
+
+	err = msg.UnmarshalFrame(canbus.Frame{ID: example.EngineDataID, Kind: canbus.EFF, Data: make([]byte, 8)})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	// ThrottlePos: raw 0x3ff, i.e. 102.3%.
+	err = msg.UnmarshalFrame(canbus.Frame{
+		ID:   example.EngineDataID,
+		Data: []byte{0, 0, 0, 0xff, 0xc0, 0, 0, 0},
+	})
+	if !errors.Is(err, candb.ErrRange) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrRange)
+	}
+	if got := msg.ThrottlePos; got <= 100 {
+		t.Fatalf("invalid value: got=%v, want=102.3", got)
+	}
+}
+
+func TestMarshalFrameSaturate(t *testing.T) {
+	msg := example.EngineData{
+		EngineSpeed: 1e6,
+		CoolantTemp: -1000,
+		ThrottlePos: 50,
+		Gear:        example.EngineDataGearPark,
+	}
+	if err := msg.Validate(); !errors.Is(err, candb.ErrRange) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrRange)
+	}
+
+	frame := msg.MarshalFrame()
+	var got example.EngineData
+	err := got.UnmarshalFrame(frame)
+	if !errors.Is(err, candb.ErrRange) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, candb.ErrRange)
+	}
+	if got, want := got.EngineSpeed, 16383.75; got != want {
+		t.Fatalf("invalid engine speed: got=%v, want=%v", got, want)
+	}
+	if got, want := got.CoolantTemp, -168.0; got != want {
+		t.Fatalf("invalid coolant temperature: got=%v, want=%v", got, want)
+	}
+}
+
+func TestEnumString(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{ String() string }
+		want string
+	}{
+		{example.EngineDataGearReverse, "Reverse"},
+		{example.EngineDataGear(7), "EngineDataGear(7)"},
+		{example.DiagnosticsModeCurrent, "Current"},
+	} {
+		if got := tc.v.String(); got != tc.want {
+			t.Fatalf("invalid string: got=%q, want=%q", got, tc.want)
+		}
+	}
+}
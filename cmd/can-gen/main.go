@@ -0,0 +1,104 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// can-gen generates Go code from a DBC file.
+//
+// For each message of the DBC file, can-gen generates a struct type
+// with one field per signal, holding its physical value, and
+// MarshalFrame, UnmarshalFrame and Validate methods to encode, decode
+// and range-check the message.
+// Signals with value descriptions are represented by integer enum types.
+// can-gen also generates message identifier constants and an
+// UnmarshalFrame function that decodes a frame into the matching
+// message type.
+//
+// Usage of can-gen:
+//
+//	can-gen [options] <DBC file>
+//
+// Examples:
+//
+//	can-gen -p vehicle -o vehicle_gen.go vehicle.dbc
+//
+// or, from a go:generate directive:
+//
+//	//go:generate go run github.com/go-daq/canbus/cmd/can-gen -o vehicle_gen.go vehicle.dbc
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/go-daq/canbus/dbc"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(
+			os.Stderr,
+			`can-gen generates Go code from a DBC file.
+
+Usage of can-gen:
+
+sh> can-gen [options] <DBC file>
+
+Examples:
+
+ can-gen -p vehicle -o vehicle_gen.go vehicle.dbc
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	var (
+		pkg = flag.String("p", "", "name of the generated package (default: DBC file name)")
+		out = flag.String("o", "", "path to the generated file (default: stdout)")
+	)
+
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("can-gen> ")
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fname := flag.Arg(0)
+	db, err := dbc.ParseFile(fname)
+	if err != nil {
+		log.Fatalf("could not parse DBC file: %+v", err)
+	}
+
+	cdb, err := db.ToCANDB()
+	if err != nil {
+		log.Fatalf("could not convert DBC file %q: %+v", fname, err)
+	}
+
+	if *pkg == "" {
+		*pkg = pkgName(fname)
+	}
+
+	src, err := generate(cdb, *pkg, filepath.Base(fname))
+	if err != nil {
+		log.Fatalf("could not generate code: %+v", err)
+	}
+
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+		if err != nil {
+			log.Fatalf("could not write generated code: %+v", err)
+		}
+		return
+	}
+
+	err = os.WriteFile(*out, src, 0644)
+	if err != nil {
+		log.Fatalf("could not write generated code: %+v", err)
+	}
+}
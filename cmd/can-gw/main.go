@@ -0,0 +1,133 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// can-gw forwards CAN frames between CAN interfaces.
+//
+// Each rule forwards frames from a source interface to a destination
+// interface (can0>can1), or in both directions (can0<>can1). Frames are
+// handled, for each destination, by the first rule selecting them, and
+// are not forwarded when no rule selects them.
+//
+// Rules are made of the direction followed by space-separated options:
+//
+//	id=[!]ID[/MASK]           select the frames matching (or not) the identifier
+//	drop                      drop the selected frames
+//	map=FROM:TO               remap an identifier
+//	and|or|xor|set=FIELD:VAL  modify the id, len or data field
+//	xor8=FROM:TO:AT[:INIT[:FINAL]]
+//	                          compute a XOR checksum of the data bytes
+//	crc8=FROM:TO:AT[:POLY[:INIT[:FINAL]]]
+//	                          compute a CRC8 checksum of the data bytes
+//	rate=RATE[/BURST]         limit the number of frames per second
+//
+// Identifiers and values are hexadecimal, and byte indices decimal
+// (negative indices are relative to the data length).
+//
+// Usage of can-gw:
+//
+//	can-gw [options] <rule> [<rule>...]
+//	  (use CTRL-C to terminate can-gw)
+//
+// Examples:
+//
+//	can-gw 'vcan0<>vcan1'
+//	can-gw 'can0>can1 id=666 drop' 'can0>can1' 'can1>can0 id=7e8/7f8'
+//	can-gw 'can0>can1 id=123 map=123:321 and=data:ff0f set=len:8 crc8=0:6:7'
+//	can-gw 'can0>can1 id=18fef100/1fffff00 rate=10/5'
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/go-daq/canbus/cangw"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(
+			os.Stderr,
+			`can-gw forwards CAN frames between CAN interfaces.
+
+Usage of can-gw:
+
+sh> can-gw [options] <rule> [<rule>...]
+    (use CTRL-C to terminate can-gw)
+
+Rules are made of a direction (can0>can1, or can0<>can1), followed by
+space-separated options:
+
+ id=[!]ID[/MASK]           select the frames matching (or not) the identifier
+ drop                      drop the selected frames
+ map=FROM:TO               remap an identifier
+ and|or|xor|set=FIELD:VAL  modify the id, len or data field
+ xor8=FROM:TO:AT[:INIT[:FINAL]]
+                           compute a XOR checksum of the data bytes
+ crc8=FROM:TO:AT[:POLY[:INIT[:FINAL]]]
+                           compute a CRC8 checksum of the data bytes
+ rate=RATE[/BURST]         limit the number of frames per second
+
+Examples:
+
+ can-gw 'vcan0<>vcan1'
+ can-gw 'can0>can1 id=666 drop' 'can0>can1' 'can1>can0 id=7e8/7f8'
+ can-gw 'can0>can1 id=123 map=123:321 and=data:ff0f set=len:8 crc8=0:6:7'
+ can-gw 'can0>can1 id=18fef100/1fffff00 rate=10/5'
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("can-gw> ")
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		rules []cangw.Rule
+		names []string
+	)
+	for i, arg := range flag.Args() {
+		rs, err := parseRule(arg)
+		if err != nil {
+			log.Fatalf("could not parse rule: %+v", err)
+		}
+		for _, r := range rs {
+			rules = append(rules, r)
+			names = append(names, fmt.Sprintf("rule %d (%s>%s)", i+1, r.Src, r.Dst))
+		}
+	}
+
+	gw, err := cangw.Open(rules...)
+	if err != nil {
+		log.Fatalf("could not open gateway: %+v", err)
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt)
+	go func() {
+		<-sigc
+		gw.Close()
+	}()
+
+	err = gw.Run()
+	gw.Close()
+	for i, st := range gw.Stats() {
+		log.Printf(
+			"%s: forwarded=%d dropped=%d limited=%d failed=%d",
+			names[i], st.Forwarded, st.Dropped, st.Limited, st.Failed,
+		)
+	}
+	if err != nil && err != cangw.ErrClosed {
+		log.Fatalf("could not forward frames: %+v", err)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-daq/canbus/cangw"
+)
+
+// parseRule parses a forwarding rule, made of a direction (can0>can1,
+// or can0<>can1 for both directions) followed by space-separated
+// options.
+func parseRule(s string) ([]cangw.Rule, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("invalid rule %q: missing interfaces", s)
+	}
+
+	var (
+		rule cangw.Rule
+		both bool
+		dir  = fields[0]
+	)
+	switch {
+	case strings.Contains(dir, "<>"):
+		both = true
+		rule.Src, rule.Dst, _ = strings.Cut(dir, "<>")
+	case strings.Contains(dir, ">"):
+		rule.Src, rule.Dst, _ = strings.Cut(dir, ">")
+	}
+	if rule.Src == "" || rule.Dst == "" {
+		return nil, fmt.Errorf("invalid rule %q: invalid interfaces %q", s, dir)
+	}
+
+	for _, opt := range fields[1:] {
+		err := parseOption(&rule, opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", s, err)
+		}
+	}
+
+	rules := []cangw.Rule{rule}
+	if both {
+		back := rule
+		back.Src, back.Dst = rule.Dst, rule.Src
+		rules = append(rules, back)
+	}
+	return rules, nil
+}
+
+func parseOption(rule *cangw.Rule, opt string) error {
+	if opt == "drop" {
+		rule.Drop = true
+		return nil
+	}
+	key, val, ok := strings.Cut(opt, "=")
+	if !ok {
+		return fmt.Errorf("invalid option %q", opt)
+	}
+	var err error
+	switch key {
+	case "id":
+		var f cangw.Filter
+		f, err = parseFilter(val)
+		rule.Filters = append(rule.Filters, f)
+	case "map":
+		var from, to uint32
+		from, to, err = parseMap(val)
+		if rule.Map == nil {
+			rule.Map = make(map[uint32]uint32)
+		}
+		rule.Map[from] = to
+	case "and", "or", "xor", "set":
+		var mod cangw.Mod
+		mod, err = parseMod(key, val)
+		rule.Mods = append(rule.Mods, mod)
+	case "xor8", "crc8":
+		var sum cangw.Checksum
+		sum, err = parseChecksum(key, val)
+		rule.Checksums = append(rule.Checksums, sum)
+	case "rate":
+		err = parseRate(rule, val)
+	default:
+		return fmt.Errorf("invalid option %q", opt)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid option %q", opt)
+	}
+	return nil
+}
+
+// parseFilter parses a frame identifier filter: [!]ID[/MASK].
+// Without a mask, all the bits of the standard or extended identifier
+// are compared.
+func parseFilter(s string) (cangw.Filter, error) {
+	var f cangw.Filter
+	if strings.HasPrefix(s, "!") {
+		f.Invert = true
+		s = s[1:]
+	}
+	id, mask, ok := strings.Cut(s, "/")
+	v, err := parseHex(id, 29)
+	if err != nil {
+		return f, err
+	}
+	f.ID = v
+	switch {
+	case ok:
+		f.Mask, err = parseHex(mask, 29)
+	case v > 0x7ff:
+		f.Mask = 0x1fffffff
+	default:
+		f.Mask = 0x7ff
+	}
+	return f, err
+}
+
+// parseMap parses an identifier remapping: FROM:TO.
+func parseMap(s string) (from, to uint32, err error) {
+	a, b, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("missing ':'")
+	}
+	from, err = parseHex(a, 29)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err = parseHex(b, 29)
+	return from, to, err
+}
+
+// parseMod parses a modification of the forwarded frames: FIELD:VALUE,
+// where FIELD is id, len or data.
+// Data values hold up to 8 hexadecimal bytes, completed with bytes left
+// unmodified by AND, OR and XOR operations, and with zeros by SET
+// operations.
+func parseMod(op, s string) (cangw.Mod, error) {
+	var mod cangw.Mod
+	switch op {
+	case "and":
+		mod.Op = cangw.And
+	case "or":
+		mod.Op = cangw.Or
+	case "xor":
+		mod.Op = cangw.Xor
+	case "set":
+		mod.Op = cangw.Set
+	}
+	field, val, ok := strings.Cut(s, ":")
+	if !ok {
+		return mod, fmt.Errorf("missing ':'")
+	}
+	switch field {
+	case "id":
+		mod.Fields = cangw.ModID
+		v, err := parseHex(val, 29)
+		if err != nil {
+			return mod, err
+		}
+		mod.ID = v
+	case "len":
+		mod.Fields = cangw.ModLen
+		v, err := strconv.ParseUint(val, 10, 4)
+		if err != nil || v > 8 {
+			return mod, fmt.Errorf("invalid length %q", val)
+		}
+		mod.Len = uint8(v)
+	case "data":
+		mod.Fields = cangw.ModData
+		data, err := hex.DecodeString(val)
+		if err != nil || len(data) == 0 || len(data) > len(mod.Data) {
+			return mod, fmt.Errorf("invalid data %q", val)
+		}
+		if mod.Op == cangw.And {
+			for i := range mod.Data {
+				mod.Data[i] = 0xff
+			}
+		}
+		copy(mod.Data[:], data)
+	default:
+		return mod, fmt.Errorf("invalid field %q", field)
+	}
+	return mod, nil
+}
+
+// parseChecksum parses a checksum: FROM:TO:AT[:INIT[:FINAL]] for XOR
+// checksums, and FROM:TO:AT[:POLY[:INIT[:FINAL]]] for CRC8 checksums,
+// with decimal byte indices and hexadecimal values.
+// CRC8 checksums default to the SAE J1850 parameters.
+func parseChecksum(algo, s string) (cangw.Checksum, error) {
+	sum := cangw.Checksum{Algo: cangw.XOR8}
+	params := []*uint8{&sum.Init, &sum.Final}
+	if algo == "crc8" {
+		sum = cangw.Checksum{Algo: cangw.CRC8, Poly: 0x1d, Init: 0xff, Final: 0xff}
+		params = []*uint8{&sum.Poly, &sum.Init, &sum.Final}
+	}
+
+	fields := strings.Split(s, ":")
+	if len(fields) < 3 || len(fields) > 3+len(params) {
+		return sum, fmt.Errorf("invalid checksum %q", s)
+	}
+	for i, p := range []*int{&sum.From, &sum.To, &sum.At} {
+		v, err := strconv.Atoi(fields[i])
+		if err != nil {
+			return sum, err
+		}
+		*p = v
+	}
+	for i, v := range fields[3:] {
+		p, err := strconv.ParseUint(v, 16, 8)
+		if err != nil {
+			return sum, err
+		}
+		*params[i] = uint8(p)
+	}
+	return sum, nil
+}
+
+// parseRate parses a rate limit, in frames per second: RATE[/BURST].
+func parseRate(rule *cangw.Rule, s string) error {
+	rate, burst, ok := strings.Cut(s, "/")
+	var err error
+	rule.Rate, err = strconv.ParseFloat(rate, 64)
+	if err != nil || rule.Rate <= 0 {
+		return fmt.Errorf("invalid rate %q", rate)
+	}
+	if ok {
+		rule.Burst, err = strconv.Atoi(burst)
+		if err != nil || rule.Burst < 1 {
+			return fmt.Errorf("invalid burst %q", burst)
+		}
+	}
+	return nil
+}
+
+func parseHex(s string, bits int) (uint32, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	v, err := strconv.ParseUint(s, 16, bits)
+	return uint32(v), err
+}
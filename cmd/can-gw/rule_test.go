@@ -0,0 +1,139 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/canbus/cangw"
+)
+
+func TestParseRule(t *testing.T) {
+	for _, tc := range []struct {
+		rule string
+		want []cangw.Rule
+	}{
+		{
+			rule: "can0>can1",
+			want: []cangw.Rule{{Src: "can0", Dst: "can1"}},
+		},
+		{
+			rule: " vcan0<>vcan1  drop ",
+			want: []cangw.Rule{
+				{Src: "vcan0", Dst: "vcan1", Drop: true},
+				{Src: "vcan1", Dst: "vcan0", Drop: true},
+			},
+		},
+		{
+			rule: "can0>can1 id=123 id=!7e8/7f8 id=0x18fef100",
+			want: []cangw.Rule{{
+				Src: "can0", Dst: "can1",
+				Filters: []cangw.Filter{
+					{ID: 0x123, Mask: 0x7ff},
+					{ID: 0x7e8, Mask: 0x7f8, Invert: true},
+					{ID: 0x18fef100, Mask: 0x1fffffff},
+				},
+			}},
+		},
+		{
+			rule: "can0>can1 map=123:321 map=100:18fef100",
+			want: []cangw.Rule{{
+				Src: "can0", Dst: "can1",
+				Map: map[uint32]uint32{0x123: 0x321, 0x100: 0x18fef100},
+			}},
+		},
+		{
+			rule: "can0>can1 and=data:ff0f or=id:400 xor=data:0102030405060708 set=len:8 and=id:7f",
+			want: []cangw.Rule{{
+				Src: "can0", Dst: "can1",
+				Mods: []cangw.Mod{
+					{Op: cangw.And, Fields: cangw.ModData, Data: [8]byte{0xff, 0x0f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+					{Op: cangw.Or, Fields: cangw.ModID, ID: 0x400},
+					{Op: cangw.Xor, Fields: cangw.ModData, Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}},
+					{Op: cangw.Set, Fields: cangw.ModLen, Len: 8},
+					{Op: cangw.And, Fields: cangw.ModID, ID: 0x7f},
+				},
+			}},
+		},
+		{
+			rule: "can0>can1 set=data:aa",
+			want: []cangw.Rule{{
+				Src: "can0", Dst: "can1",
+				Mods: []cangw.Mod{{Op: cangw.Set, Fields: cangw.ModData, Data: [8]byte{0xaa}}},
+			}},
+		},
+		{
+			rule: "can0>can1 xor8=0:-2:-1 xor8=1:2:3:f0:ff crc8=0:6:7 crc8=0:6:7:2f:0:0",
+			want: []cangw.Rule{{
+				Src: "can0", Dst: "can1",
+				Checksums: []cangw.Checksum{
+					{Algo: cangw.XOR8, From: 0, To: -2, At: -1},
+					{Algo: cangw.XOR8, From: 1, To: 2, At: 3, Init: 0xf0, Final: 0xff},
+					{Algo: cangw.CRC8, From: 0, To: 6, At: 7, Poly: 0x1d, Init: 0xff, Final: 0xff},
+					{Algo: cangw.CRC8, From: 0, To: 6, At: 7, Poly: 0x2f},
+				},
+			}},
+		},
+		{
+			rule: "can0>can1 rate=100",
+			want: []cangw.Rule{{Src: "can0", Dst: "can1", Rate: 100}},
+		},
+		{
+			rule: "can0>can1 rate=0.5/10",
+			want: []cangw.Rule{{Src: "can0", Dst: "can1", Rate: 0.5, Burst: 10}},
+		},
+	} {
+		t.Run(tc.rule, func(t *testing.T) {
+			got, err := parseRule(tc.rule)
+			if err != nil {
+				t.Fatalf("could not parse rule: %+v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid rules:\ngot= %+v\nwant=%+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, tc := range []struct {
+		rule string
+		err  string
+	}{
+		{"", `invalid rule "": missing interfaces`},
+		{"can0", `invalid rule "can0": invalid interfaces "can0"`},
+		{"can0>", `invalid rule "can0>": invalid interfaces "can0>"`},
+		{"<>can1", `invalid rule "<>can1": invalid interfaces "<>can1"`},
+		{"can0>can1 keep", `invalid rule "can0>can1 keep": invalid option "keep"`},
+		{"can0>can1 foo=1", `invalid rule "can0>can1 foo=1": invalid option "foo=1"`},
+		{"can0>can1 id=xyz", `invalid rule "can0>can1 id=xyz": invalid option "id=xyz"`},
+		{"can0>can1 id=20000000", `invalid rule "can0>can1 id=20000000": invalid option "id=20000000"`},
+		{"can0>can1 id=123/xyz", `invalid rule "can0>can1 id=123/xyz": invalid option "id=123/xyz"`},
+		{"can0>can1 map=123", `invalid rule "can0>can1 map=123": invalid option "map=123"`},
+		{"can0>can1 map=123:xyz", `invalid rule "can0>can1 map=123:xyz": invalid option "map=123:xyz"`},
+		{"can0>can1 and=id", `invalid rule "can0>can1 and=id": invalid option "and=id"`},
+		{"can0>can1 or=dlc:1", `invalid rule "can0>can1 or=dlc:1": invalid option "or=dlc:1"`},
+		{"can0>can1 set=len:9", `invalid rule "can0>can1 set=len:9": invalid option "set=len:9"`},
+		{"can0>can1 set=data:", `invalid rule "can0>can1 set=data:": invalid option "set=data:"`},
+		{"can0>can1 set=data:001122334455667788", `invalid rule "can0>can1 set=data:001122334455667788": invalid option "set=data:001122334455667788"`},
+		{"can0>can1 xor8=0:7", `invalid rule "can0>can1 xor8=0:7": invalid option "xor8=0:7"`},
+		{"can0>can1 xor8=0:6:7:0:0:0", `invalid rule "can0>can1 xor8=0:6:7:0:0:0": invalid option "xor8=0:6:7:0:0:0"`},
+		{"can0>can1 crc8=0:x:7", `invalid rule "can0>can1 crc8=0:x:7": invalid option "crc8=0:x:7"`},
+		{"can0>can1 crc8=0:6:7:100", `invalid rule "can0>can1 crc8=0:6:7:100": invalid option "crc8=0:6:7:100"`},
+		{"can0>can1 rate=0", `invalid rule "can0>can1 rate=0": invalid option "rate=0"`},
+		{"can0>can1 rate=10/0", `invalid rule "can0>can1 rate=10/0": invalid option "rate=10/0"`},
+	} {
+		t.Run(tc.rule, func(t *testing.T) {
+			_, err := parseRule(tc.rule)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// can-record records the frames of one or more CAN interfaces to log
+// files.
+//
+// The format of the log files is given by the extension of the output
+// file, or by the -f option. Supported formats are candump (.log),
+// Vector ASC (.asc) and BLF (.blf), pcap (.pcap), pcapng (.pcapng),
+// ASAM MDF 4 (.mf4), PEAK TRC (.trc) and SavvyCAN GVRET CSV (.csv).
+//
+// Log files can be rotated once they reach a size (-size) or an age
+// (-every), in which case the name of each log file is suffixed with the
+// time of its first record, and only the last log files can be kept
+// (-keep).
+// Log files are flushed to stable storage at regular intervals (-sync),
+// so a crash only loses the records received since the last sync.
+//
+// In trigger mode (-trigger, -trigger-err), the records of the last
+// -pre duration are kept in memory, and written to a new log file when
+// a trigger frame is received, along with the records received until
+// -post has elapsed since the last trigger frame.
+//
+// Usage of can-record:
+//
+//	can-record [options] <CAN interface> [<CAN interface>...]
+//	  (use CTRL-C to terminate can-record)
+//
+// Examples:
+//
+//	can-record -o trace.blf vcan0
+//	can-record -o trace.mf4 -size 100M -keep 10 can0 can1
+//	can-record -o trace.asc -every 1h vcan0
+//	can-record -o event.log -trigger 7df -trigger-err -pre 10s -post 5s can0
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/logfile"
+	"github.com/go-daq/canbus/cmd/internal/logopt"
+	"golang.org/x/sys/unix"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(
+			os.Stderr,
+			`can-record records the frames of CAN interfaces to log files.
+
+Usage of can-record:
+
+sh> can-record [options] <CAN interface> [<CAN interface>...]
+    (use CTRL-C to terminate can-record)
+
+Examples:
+
+ can-record -o trace.blf vcan0
+ can-record -o trace.mf4 -size 100M -keep 10 can0 can1
+ can-record -o trace.asc -every 1h vcan0
+ can-record -o event.log -trigger 7df -trigger-err -pre 10s -post 5s can0
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	var (
+		out    = flag.String("o", "can.log", "path to the output file")
+		format = flag.String("f", "", "format of the output file: candump, asc, blf, pcap, pcapng, mf4, trc or csv (default: from the output file extension)")
+		size   = flag.String("size", "", "size of the output files triggering a rotation, in bytes, with an optional k, M or G suffix")
+		every  = flag.Duration("every", 0, "age of the output files triggering a rotation")
+		keep   = flag.Int("keep", 0, "number of output files to keep (0: keep all output files)")
+		sync   = flag.Duration("sync", time.Second, "interval between flushes of the output file to stable storage")
+		trig   = flag.String("trigger", "", "comma-separated list of hexadecimal identifiers (123) and ranges (100-1ff) of the trigger frames")
+		trigE  = flag.Bool("trigger-err", false, "enable error frames as trigger frames")
+		pre    = flag.Duration("pre", 10*time.Second, "duration of the records kept before a trigger frame")
+		post   = flag.Duration("post", 10*time.Second, "duration of the records written after a trigger frame")
+	)
+
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("can-record> ")
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := options{name: *out, age: *every, keep: *keep}
+	if *format != "" {
+		var err error
+		opts.format, err = logfile.ParseFormat(*format)
+		if err != nil {
+			log.Fatalf("invalid -f option: %+v", err)
+		}
+	} else {
+		opts.format = logfile.FormatOf(opts.name)
+		if opts.format == logfile.Unknown {
+			log.Fatalf("unknown format for output file %q", opts.name)
+		}
+	}
+	if *size != "" {
+		var err error
+		opts.size, err = parseSize(*size)
+		if err != nil {
+			log.Fatalf("invalid -size option: %+v", err)
+		}
+	}
+	if opts.age < 0 {
+		log.Fatalf("invalid -every option: %v", opts.age)
+	}
+	if opts.keep < 0 {
+		log.Fatalf("invalid -keep option: %v", opts.keep)
+	}
+	if *sync <= 0 {
+		log.Fatalf("invalid -sync option: %v", *sync)
+	}
+
+	var w writer
+	switch {
+	case *trig != "" || *trigE:
+		if *pre < 0 || *post < 0 {
+			log.Fatalf("invalid -pre or -post option: %v, %v", *pre, *post)
+		}
+		tr := &trigger{
+			byID: *trig != "",
+			errs: *trigE,
+			pre:  *pre,
+			post: *post,
+			w:    newRotator(opts, true),
+		}
+		var err error
+		tr.ids, err = logopt.ParseIDFilter(*trig)
+		if err != nil {
+			log.Fatalf("invalid -trigger option: %+v", err)
+		}
+		w = tr
+	default:
+		w = newRotator(opts, false)
+	}
+
+	var (
+		recs = make(chan canlog.Record, 1024)
+		errc = make(chan error, flag.NArg())
+	)
+	for _, iface := range flag.Args() {
+		sck, err := listen(iface)
+		if err != nil {
+			log.Fatalf("could not listen on %q: %+v", iface, err)
+		}
+		defer sck.Close()
+		go receive(sck, recs, errc)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ticker := time.NewTicker(*sync)
+	defer ticker.Stop()
+
+	n, err := record(ctx, w, recs, errc, ticker.C)
+	log.Printf("recorded %d frames", n)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		w.Close()
+		log.Fatalf("could not record frames: %+v", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		log.Fatalf("could not close output file: %+v", err)
+	}
+}
+
+// listen returns a CAN socket bound to the named interface, receiving
+// all frames, including error frames.
+func listen(iface string) (*canbus.Socket, error) {
+	sck, err := canbus.New()
+	if err != nil {
+		return nil, fmt.Errorf("could not create CAN bus socket: %w", err)
+	}
+	err = sck.Bind(iface)
+	if err != nil {
+		sck.Close()
+		return nil, fmt.Errorf("could not bind CAN bus socket: %w", err)
+	}
+	err = sck.SetErrFilter(unix.CAN_ERR_MASK)
+	if err != nil {
+		sck.Close()
+		return nil, err
+	}
+	return sck, nil
+}
+
+// receive sends the frames received by the transport to recs,
+// timestamped and tagged with the name of the interface.
+func receive(tr canbus.Transport, recs chan<- canlog.Record, errc chan<- error) {
+	iface := tr.Name()
+	for {
+		frame, err := tr.Recv()
+		if err != nil {
+			errc <- fmt.Errorf("could not receive frame on %q: %w", iface, err)
+			return
+		}
+		recs <- canlog.Record{Time: time.Now(), Iface: iface, Frame: frame}
+	}
+}
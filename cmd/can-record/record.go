@@ -0,0 +1,312 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/logfile"
+	"github.com/go-daq/canbus/cmd/internal/logopt"
+)
+
+// options describes the output files of the recorder.
+type options struct {
+	name   string // path of the output file, or pattern of the rotated output files
+	format logfile.Format
+	size   int64         // size of the output files triggering a rotation, 0 to disable
+	age    time.Duration // age of the output files triggering a rotation, 0 to disable
+	keep   int           // number of output files kept, 0 to keep them all
+}
+
+// rotating reports whether the records are written to a sequence of
+// output files.
+func (opts options) rotating() bool {
+	return opts.size > 0 || opts.age > 0 || opts.keep > 0
+}
+
+// fileName returns the name of the output file starting at t.
+// The name of rotated files is suffixed with the time of their first
+// record.
+func (opts options) fileName(t time.Time, rotating bool) string {
+	if !rotating {
+		return opts.name
+	}
+	ext := filepath.Ext(opts.name)
+	base := strings.TrimSuffix(opts.name, ext)
+	return base + "-" + t.Format("20060102T150405.000000") + ext
+}
+
+// parseSize parses a file size, in bytes, with an optional k, M or G
+// binary multiple suffix.
+func parseSize(s string) (int64, error) {
+	var (
+		v    = s
+		unit = int64(1)
+	)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/unit {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+// writer writes the recorded records to output files.
+type writer interface {
+	Write(rec canlog.Record) error
+
+	// Sync flushes the records written so far to the output files,
+	// and closes the output files completed at time now.
+	Sync(now time.Time) error
+
+	Close() error
+}
+
+// record writes the records received from recs to w, and syncs w at
+// each tick, until the context is canceled or a receiver fails.
+// It returns the number of records written.
+func record(ctx context.Context, w writer, recs <-chan canlog.Record, errc <-chan error, tick <-chan time.Time) (int, error) {
+	n := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return n, ctx.Err()
+		case err := <-errc:
+			return n, err
+		case now := <-tick:
+			err := w.Sync(now)
+			if err != nil {
+				return n, fmt.Errorf("could not sync output file: %w", err)
+			}
+		case rec := <-recs:
+			err := w.Write(rec)
+			if err != nil {
+				return n, fmt.Errorf("could not write record: %w", err)
+			}
+			n++
+		}
+	}
+}
+
+// output is an output file being written.
+type output struct {
+	f     *countingFile
+	w     canlog.Writer
+	name  string
+	start time.Time // time of the first record
+}
+
+// countingFile counts the bytes written to a file.
+type countingFile struct {
+	*os.File
+	n int64
+}
+
+func (f *countingFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.n += int64(n)
+	return n, err
+}
+
+// rotator writes records to a sequence of output files, rotated once
+// they are big or old enough, and removes the oldest ones.
+type rotator struct {
+	opts     options
+	rotating bool // whether the output files are rotated, or split by rotate calls
+
+	out   *output
+	files []string // names of the closed output files, oldest first
+}
+
+func newRotator(opts options, split bool) *rotator {
+	return &rotator{opts: opts, rotating: split || opts.rotating()}
+}
+
+func (r *rotator) Write(rec canlog.Record) error {
+	if r.out != nil && r.full(rec.Time) {
+		err := r.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	if r.out == nil {
+		err := r.open(rec.Time)
+		if err != nil {
+			return err
+		}
+	}
+	return r.out.w.Write(rec)
+}
+
+// full reports whether the current output file is complete at time t.
+// The size of the file only accounts for the records flushed to it.
+func (r *rotator) full(t time.Time) bool {
+	return r.opts.size > 0 && r.out.f.n >= r.opts.size ||
+		r.opts.age > 0 && t.Sub(r.out.start) >= r.opts.age
+}
+
+func (r *rotator) open(t time.Time) error {
+	// the ring of kept files includes the new output file.
+	for r.opts.keep > 0 && len(r.files) >= r.opts.keep {
+		err := os.Remove(r.files[0])
+		if err != nil {
+			return fmt.Errorf("could not remove output file: %w", err)
+		}
+		r.files = r.files[1:]
+	}
+
+	name := r.opts.fileName(t, r.rotating)
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("could not create output file: %w", err)
+	}
+	cf := &countingFile{File: f}
+	w, err := logfile.NewWriter(r.opts.format, cf)
+	if err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	r.out = &output{f: cf, w: w, name: name, start: t}
+	return nil
+}
+
+// rotate closes the current output file, if any.
+func (r *rotator) rotate() error {
+	if r.out == nil {
+		return nil
+	}
+	out := r.out
+	r.out = nil
+	err := out.w.Close()
+	if err != nil {
+		out.f.Close()
+		return fmt.Errorf("could not close output file %q: %w", out.name, err)
+	}
+	err = out.f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close output file %q: %w", out.name, err)
+	}
+	r.files = append(r.files, out.name)
+	return nil
+}
+
+// Sync flushes the records of the current output file to stable
+// storage, or closes it if it is old enough.
+func (r *rotator) Sync(now time.Time) error {
+	if r.out == nil {
+		return nil
+	}
+	if r.opts.age > 0 && now.Sub(r.out.start) >= r.opts.age {
+		return r.rotate()
+	}
+	if f, ok := r.out.w.(canlog.Flusher); ok {
+		err := f.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	return r.out.f.Sync()
+}
+
+func (r *rotator) Close() error {
+	return r.rotate()
+}
+
+// trigger writes the records surrounding trigger frames, each capture
+// to its own output file.
+//
+// Records are kept in memory for the pre-trigger duration. Once a
+// trigger frame is received, they are written along with the records
+// received until the post-trigger duration has elapsed since the last
+// trigger frame.
+type trigger struct {
+	ids  logopt.IDFilter // identifiers of the trigger frames
+	byID bool            // whether trigger frames are selected by identifier
+	errs bool            // whether error frames are trigger frames
+	pre  time.Duration
+	post time.Duration
+
+	w     *rotator
+	buf   []canlog.Record // records of the pre-trigger duration
+	until time.Time       // end of the current capture, zero when idle
+}
+
+// match reports whether the frame is a trigger frame.
+func (tr *trigger) match(frame canbus.Frame) bool {
+	if frame.Kind == canbus.ERR {
+		return tr.errs
+	}
+	return tr.byID && tr.ids.Match(frame)
+}
+
+func (tr *trigger) Write(rec canlog.Record) error {
+	if !tr.until.IsZero() && rec.Time.After(tr.until) {
+		err := tr.end()
+		if err != nil {
+			return err
+		}
+	}
+	if tr.until.IsZero() {
+		i := 0
+		beg := rec.Time.Add(-tr.pre)
+		for i < len(tr.buf) && tr.buf[i].Time.Before(beg) {
+			i++
+		}
+		tr.buf = tr.buf[i:]
+	}
+	if tr.match(rec.Frame) {
+		if tr.until.IsZero() {
+			for _, prev := range tr.buf {
+				err := tr.w.Write(prev)
+				if err != nil {
+					return err
+				}
+			}
+			tr.buf = tr.buf[:0]
+		}
+		tr.until = rec.Time.Add(tr.post)
+	}
+	if !tr.until.IsZero() {
+		return tr.w.Write(rec)
+	}
+	tr.buf = append(tr.buf, rec)
+	return nil
+}
+
+// end ends the current capture.
+func (tr *trigger) end() error {
+	tr.until = time.Time{}
+	return tr.w.rotate()
+}
+
+func (tr *trigger) Sync(now time.Time) error {
+	if !tr.until.IsZero() && now.After(tr.until) {
+		return tr.end()
+	}
+	return tr.w.Sync(now)
+}
+
+func (tr *trigger) Close() error {
+	return tr.w.Close()
+}
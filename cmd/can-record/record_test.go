@@ -0,0 +1,390 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/logfile"
+	"github.com/go-daq/canbus/cmd/internal/logopt"
+)
+
+var t0 = time.Date(2022, time.August, 8, 23, 6, 40, 0, time.UTC)
+
+// frames returns records of SFF frames with the provided identifiers,
+// received every 100ms, and ERR frames for the 0 identifiers.
+func frames(ids ...uint32) []canlog.Record {
+	recs := make([]canlog.Record, len(ids))
+	for i, id := range ids {
+		frame := canbus.Frame{ID: id, Kind: canbus.SFF, Data: []byte{byte(i)}}
+		if id == 0 {
+			frame = canbus.Frame{ID: 0x004, Kind: canbus.ERR, Data: make([]byte, 8)}
+		}
+		recs[i] = canlog.Record{
+			Time:  t0.Add(time.Duration(i) * 100 * time.Millisecond),
+			Iface: "vcan0",
+			Frame: frame,
+		}
+	}
+	return recs
+}
+
+// dir returns the content of the candump files of a directory, as
+// lists of frame identifiers indexed by file name.
+func dir(t *testing.T, path string) map[string]string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(path, "*"))
+	if err != nil {
+		t.Fatalf("could not list files: %+v", err)
+	}
+	out := make(map[string]string, len(files))
+	for _, fname := range files {
+		raw, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatalf("could not read file: %+v", err)
+		}
+		var ids []string
+		for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+			if line == "" {
+				continue
+			}
+			fields := strings.Fields(line)
+			ids = append(ids, strings.Split(fields[2], "#")[0])
+		}
+		out[filepath.Base(fname)] = strings.Join(ids, " ")
+	}
+	return out
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want int64
+		err  string
+	}{
+		{s: "0", want: 0},
+		{s: "1234", want: 1234},
+		{s: "2k", want: 2048},
+		{s: "100M", want: 100 << 20},
+		{s: "1G", want: 1 << 30},
+		{s: "", err: `invalid size ""`},
+		{s: "M", err: `invalid size "M"`},
+		{s: "-1k", err: `invalid size "-1k"`},
+		{s: "1T", err: `invalid size "1T"`},
+		{s: "9000000000G", err: `invalid size "9000000000G"`},
+	} {
+		t.Run(tc.s, func(t *testing.T) {
+			got, err := parseSize(tc.s)
+			switch {
+			case tc.err != "":
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("could not parse size: %+v", err)
+				}
+				if got != tc.want {
+					t.Fatalf("invalid size: got=%d, want=%d", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRotator(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts options
+		recs []canlog.Record
+		sync time.Duration // sync time, after the last record
+		want map[string]string
+	}{
+		{
+			name: "single",
+			opts: options{name: "trace.log"},
+			recs: frames(0x100, 0x101, 0x102),
+			want: map[string]string{
+				"trace.log": "100 101 102",
+			},
+		},
+		{
+			name: "size",
+			opts: options{name: "trace.log", size: 60},
+			recs: frames(0x100, 0x101, 0x102, 0x103, 0x104),
+			want: map[string]string{
+				"trace-20220808T230640.000000.log": "100 101",
+				"trace-20220808T230640.200000.log": "102 103",
+				"trace-20220808T230640.400000.log": "104",
+			},
+		},
+		{
+			name: "age",
+			opts: options{name: "trace.log", age: 250 * time.Millisecond},
+			recs: frames(0x100, 0x101, 0x102, 0x103, 0x104),
+			want: map[string]string{
+				"trace-20220808T230640.000000.log": "100 101 102",
+				"trace-20220808T230640.300000.log": "103 104",
+			},
+		},
+		{
+			name: "keep",
+			opts: options{name: "trace.log", size: 1, keep: 2},
+			recs: frames(0x100, 0x101, 0x102, 0x103, 0x104),
+			want: map[string]string{
+				"trace-20220808T230640.300000.log": "103",
+				"trace-20220808T230640.400000.log": "104",
+			},
+		},
+		{
+			name: "keep-one",
+			opts: options{name: "trace.log", age: time.Second, keep: 1},
+			recs: frames(0x100, 0x101),
+			sync: time.Second,
+			want: map[string]string{
+				"trace-20220808T230640.000000.log": "100 101",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := t.TempDir()
+			opts := tc.opts
+			opts.name = filepath.Join(path, opts.name)
+			opts.format = logfile.Candump
+
+			w := newRotator(opts, false)
+			for _, rec := range tc.recs {
+				err := w.Write(rec)
+				if err != nil {
+					t.Fatalf("could not write record: %+v", err)
+				}
+				// the size of the output files is known once synced.
+				err = w.Sync(rec.Time)
+				if err != nil {
+					t.Fatalf("could not sync: %+v", err)
+				}
+			}
+			last := tc.recs[len(tc.recs)-1].Time
+			err := w.Sync(last.Add(tc.sync))
+			if err != nil {
+				t.Fatalf("could not sync: %+v", err)
+			}
+
+			// synced records can be read before the writer is closed.
+			if tc.sync == 0 {
+				got := dir(t, path)
+				if !reflect.DeepEqual(got, tc.want) {
+					t.Fatalf("invalid synced files:\ngot= %q\nwant=%q", got, tc.want)
+				}
+			}
+
+			err = w.Close()
+			if err != nil {
+				t.Fatalf("could not close writer: %+v", err)
+			}
+			got := dir(t, path)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid files:\ngot= %q\nwant=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		tr   trigger
+		recs []canlog.Record
+		want map[string]string
+	}{
+		{
+			name: "id",
+			tr:   trigger{ids: ids(t, "7df"), byID: true, pre: 200 * time.Millisecond, post: 100 * time.Millisecond},
+			recs: frames(0x100, 0x101, 0x102, 0x103, 0x7df, 0x104, 0x105, 0x106, 0x107),
+			want: map[string]string{
+				"event-20220808T230640.200000.log": "102 103 7DF 104",
+			},
+		},
+		{
+			name: "retrigger",
+			tr:   trigger{ids: ids(t, "7d0-7df"), byID: true, pre: 100 * time.Millisecond, post: 100 * time.Millisecond},
+			recs: frames(0x100, 0x7d0, 0x7df, 0x101, 0x102, 0x103, 0x7d5, 0x104),
+			want: map[string]string{
+				"event-20220808T230640.000000.log": "100 7D0 7DF 101",
+				"event-20220808T230640.500000.log": "103 7D5 104",
+			},
+		},
+		{
+			name: "errors",
+			tr:   trigger{ids: ids(t, ""), errs: true, pre: 0, post: 200 * time.Millisecond},
+			recs: frames(0x100, 0x101, 0, 0x102, 0x103, 0x104, 0, 0x105),
+			want: map[string]string{
+				"event-20220808T230640.200000.log": "20000004 102 103",
+				"event-20220808T230640.600000.log": "20000004 105",
+			},
+		},
+		{
+			name: "no-error",
+			tr:   trigger{ids: ids(t, "!100"), byID: true, post: 100 * time.Millisecond},
+			recs: frames(0x100, 0, 0x100, 0x101, 0x100),
+			want: map[string]string{
+				"event-20220808T230640.300000.log": "101 100",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := t.TempDir()
+			opts := options{name: filepath.Join(path, "event.log"), format: logfile.Candump}
+			tr := tc.tr
+			tr.w = newRotator(opts, true)
+			for _, rec := range tc.recs {
+				err := tr.Write(rec)
+				if err != nil {
+					t.Fatalf("could not write record: %+v", err)
+				}
+			}
+			err := tr.Close()
+			if err != nil {
+				t.Fatalf("could not close writer: %+v", err)
+			}
+			got := dir(t, path)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid files:\ngot= %q\nwant=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTriggerSync(t *testing.T) {
+	path := t.TempDir()
+	tr := trigger{
+		errs: true, post: time.Second,
+		w: newRotator(options{name: filepath.Join(path, "event.log"), format: logfile.Candump}, true),
+	}
+	for _, rec := range frames(0x100, 0, 0x101) {
+		err := tr.Write(rec)
+		if err != nil {
+			t.Fatalf("could not write record: %+v", err)
+		}
+	}
+
+	// the capture ends at the end of the post-trigger duration, even
+	// without any further frame.
+	for _, now := range []time.Time{t0.Add(1100 * time.Millisecond), t0.Add(1200 * time.Millisecond)} {
+		err := tr.Sync(now)
+		if err != nil {
+			t.Fatalf("could not sync: %+v", err)
+		}
+	}
+	if tr.w.out != nil {
+		t.Fatalf("capture not ended")
+	}
+	got := dir(t, path)
+	want := map[string]string{
+		"event-20220808T230640.100000.log": "20000004 101",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid files:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	path := t.TempDir()
+	w := newRotator(options{name: filepath.Join(path, "trace.log"), format: logfile.Candump}, false)
+
+	var (
+		recs = make(chan canlog.Record)
+		errc = make(chan error)
+		tick = make(chan time.Time)
+		done = make(chan struct{})
+		n    int
+		err  error
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer close(done)
+		n, err = record(ctx, w, recs, errc, tick)
+	}()
+
+	for _, rec := range frames(0x100, 0x101) {
+		recs <- rec
+	}
+	tick <- t0.Add(time.Second)
+	if got, want := dir(t, path), map[string]string{"trace.log": "100 101"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid synced files:\ngot= %q\nwant=%q", got, want)
+	}
+	errc <- fmt.Errorf("network is down")
+	<-done
+
+	if err == nil || err.Error() != "network is down" {
+		t.Fatalf("invalid error: %+v", err)
+	}
+	if n != 2 {
+		t.Fatalf("invalid number of records: got=%d, want=%d", n, 2)
+	}
+
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err = record(ctx, w, recs, errc, tick)
+	}()
+	cancel()
+	<-done
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("invalid error: %+v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("could not close writer: %+v", err)
+	}
+}
+
+func TestRotatorErrors(t *testing.T) {
+	opts := options{name: filepath.Join(t.TempDir(), "missing", "trace.log"), format: logfile.Candump}
+	w := newRotator(opts, false)
+	err := w.Write(frames(0x100)[0])
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "could not create output file: open "+opts.name+": no such file or directory"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	opts.name = filepath.Join(t.TempDir(), "trace.log")
+	opts.format = logfile.Unknown
+	w = newRotator(opts, false)
+	err = w.Write(frames(0x100)[0])
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), "logfile: invalid format unknown"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+	if _, err := os.Stat(opts.name); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("output file not removed: %+v", err)
+	}
+}
+
+func ids(t *testing.T, s string) logopt.IDFilter {
+	f, err := logopt.ParseIDFilter(s)
+	if err != nil {
+		t.Fatalf("could not parse identifiers: %+v", err)
+	}
+	return f
+}
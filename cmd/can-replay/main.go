@@ -0,0 +1,165 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// can-replay replays a CAN bus log file on live CAN interfaces, with the
+// timing of the recorded frames.
+//
+// The format of the log file is detected from its content (candump,
+// Vector ASC and BLF, pcap, pcapng, ASAM MDF 4, PEAK TRC or SavvyCAN
+// GVRET CSV).
+// Recorded interfaces are replayed on the live interfaces given by the
+// -map option, or on the interface given by the -i option, or on the
+// interfaces with the recorded names.
+// CAN FD frames, error frames and remote frames with extended
+// identifiers are not replayed.
+//
+// Once the replay is over, or interrupted, can-replay reports how far
+// the send times of the frames drifted from their schedule.
+//
+// Usage of can-replay:
+//
+//	can-replay [options] <log file>
+//	  (use CTRL-C to terminate can-replay)
+//
+// Examples:
+//
+//	can-replay candump.log
+//	can-replay -i vcan0 -speed 2 trace.asc
+//	can-replay -map 1=can0,2=can1 -loop 0 drive.blf
+//	can-replay -start 1m -end 2m -id '!7df,7e0-7ef' -speed 0 drive.mf4
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/logfile"
+	"github.com/go-daq/canbus/cmd/internal/logopt"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(
+			os.Stderr,
+			`can-replay replays a CAN bus log file on live CAN interfaces.
+
+Usage of can-replay:
+
+sh> can-replay [options] <log file>
+    (use CTRL-C to terminate can-replay)
+
+Examples:
+
+ can-replay candump.log
+ can-replay -i vcan0 -speed 2 trace.asc
+ can-replay -map 1=can0,2=can1 -loop 0 drive.blf
+ can-replay -start 1m -end 2m -id '!7df,7e0-7ef' -speed 0 drive.mf4
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	var (
+		speed = flag.Float64("speed", 1, "replay speed factor (2: twice as fast, 0.5: half as fast, 0: as fast as possible)")
+		loops = flag.Int("loop", 1, "number of replays of the log file (0: endless replays)")
+		start = flag.String("start", "", "start of the replayed records: duration since the first record (10s), or RFC 3339 time")
+		end   = flag.String("end", "", "end of the replayed records: duration since the first record (1m), or RFC 3339 time")
+		ids   = flag.String("id", "", "comma-separated list of hexadecimal frame identifiers (123) and ranges (100-1ff) to replay, or to skip when prefixed by '!'")
+		chans = flag.String("map", "", "comma-separated list of recorded interfaces and of their live interfaces (1=vcan0)")
+		iface = flag.String("i", "", "live interface of the recorded interfaces missing from -map (default: the recorded interface)")
+	)
+
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("can-replay> ")
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := options{speed: *speed, loops: *loops, iface: *iface}
+	if opts.speed < 0 {
+		log.Fatalf("invalid -speed option: %v", opts.speed)
+	}
+	var err error
+	opts.start, err = logopt.ParseBound(*start)
+	if err != nil {
+		log.Fatalf("invalid -start option: %+v", err)
+	}
+	opts.end, err = logopt.ParseBound(*end)
+	if err != nil {
+		log.Fatalf("invalid -end option: %+v", err)
+	}
+	opts.ids, err = logopt.ParseIDFilter(*ids)
+	if err != nil {
+		log.Fatalf("invalid -id option: %+v", err)
+	}
+	opts.chans, err = logopt.ParseChanMap(*chans)
+	if err != nil {
+		log.Fatalf("invalid -map option: %+v", err)
+	}
+
+	fname := flag.Arg(0)
+	open := func() (canlog.Reader, io.Closer, error) {
+		r, err := logfile.Open(fname)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r, r, nil
+	}
+
+	socks := make(sockets)
+	defer socks.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	p := replayer{opts: opts, clock: sysClock{}, send: socks}
+	err = p.run(ctx, open)
+	log.Print(p.stats)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		socks.Close()
+		log.Fatalf("could not replay log file: %+v", err)
+	}
+}
+
+// sockets sends frames on live interfaces, through CAN sockets bound to
+// them.
+type sockets map[string]canbus.Transport
+
+func (socks sockets) Send(iface string, frame canbus.Frame) error {
+	tr, ok := socks[iface]
+	if !ok {
+		sck, err := canbus.New()
+		if err != nil {
+			return fmt.Errorf("could not create CAN bus socket: %w", err)
+		}
+		err = sck.Bind(iface)
+		if err != nil {
+			sck.Close()
+			return fmt.Errorf("could not bind CAN bus socket: %w", err)
+		}
+		tr = sck
+		socks[iface] = tr
+	}
+	_, err := tr.Send(frame)
+	return err
+}
+
+func (socks sockets) Close() {
+	for iface, tr := range socks {
+		tr.Close()
+		delete(socks, iface)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/cmd/internal/logopt"
+)
+
+// options describes the selection and the timing of the replayed
+// records.
+type options struct {
+	speed float64 // replay speed factor, 0 to replay as fast as possible
+	loops int     // number of replays of the trace, 0 for endless replays
+	start logopt.Bound
+	end   logopt.Bound
+	ids   logopt.IDFilter
+	chans logopt.ChanMap
+	iface string // live interface of the unmapped recorded interfaces
+}
+
+// clock tells the time, and waits for it.
+type clock interface {
+	Now() time.Time
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type sysClock struct{}
+
+func (sysClock) Now() time.Time { return time.Now() }
+
+func (sysClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sender sends frames on live interfaces.
+type sender interface {
+	Send(iface string, frame canbus.Frame) error
+}
+
+// stats describes the frames replayed, and the drift of their send
+// times from the schedule of the trace.
+type stats struct {
+	sent    int
+	skipped int // frames that cannot be sent on a CAN socket
+
+	drifts   int // number of frames with a measured drift
+	sumDrift time.Duration
+	minDrift time.Duration
+	maxDrift time.Duration
+}
+
+func (st *stats) add(drift time.Duration) {
+	if st.drifts == 0 || drift < st.minDrift {
+		st.minDrift = drift
+	}
+	if st.drifts == 0 || drift > st.maxDrift {
+		st.maxDrift = drift
+	}
+	st.drifts++
+	st.sumDrift += drift
+}
+
+func (st stats) String() string {
+	s := fmt.Sprintf("sent %d frames, skipped %d frames", st.sent, st.skipped)
+	if st.drifts > 0 {
+		s += fmt.Sprintf(
+			" (drift: mean=%v, min=%v, max=%v)",
+			st.sumDrift/time.Duration(st.drifts), st.minDrift, st.maxDrift,
+		)
+	}
+	return s
+}
+
+// replayer replays traces on live interfaces.
+type replayer struct {
+	opts  options
+	clock clock
+	send  sender
+	stats stats
+}
+
+// run replays the trace opened by the open function, as many times as
+// requested.
+// Replays are scheduled back to back: a replay starts at the time
+// the last frame of the previous replay was scheduled.
+func (p *replayer) run(ctx context.Context, open func() (canlog.Reader, io.Closer, error)) error {
+	base := p.clock.Now()
+	for i := 0; p.opts.loops <= 0 || i < p.opts.loops; i++ {
+		r, c, err := open()
+		if err != nil {
+			return err
+		}
+		base, err = p.replay(ctx, r, base)
+		c.Close()
+		if err != nil {
+			return err
+		}
+		if p.opts.speed <= 0 {
+			base = p.clock.Now()
+		}
+	}
+	return nil
+}
+
+// replay replays the records of a trace, scheduled from base, and
+// returns the time the last frame was scheduled at.
+func (p *replayer) replay(ctx context.Context, r canlog.Reader, base time.Time) (time.Time, error) {
+	var (
+		init     bool
+		ref, end time.Time
+		sched    = base
+		asap     = p.opts.speed <= 0
+		iface    string
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return sched, err
+		}
+		rec, err := r.Read()
+		if err == io.EOF {
+			return sched, nil
+		}
+		if err != nil {
+			return sched, fmt.Errorf("could not read record: %w", err)
+		}
+		if !init {
+			init = true
+			ref = rec.Time
+			if p.opts.start.IsSet() {
+				ref = p.opts.start.Resolve(rec.Time)
+			}
+			end = p.opts.end.Resolve(rec.Time)
+		}
+		if rec.Time.Before(ref) {
+			continue
+		}
+		if p.opts.end.IsSet() && !rec.Time.Before(end) {
+			return sched, nil
+		}
+		if !p.opts.ids.Match(rec.Frame) {
+			continue
+		}
+		if rec.Flags&(canlog.FD|canlog.Ext) != 0 || rec.Frame.Kind == canbus.ERR {
+			// canbus.RTR frames have standard identifiers.
+			p.stats.skipped++
+			continue
+		}
+
+		iface = p.opts.iface
+		if name, ok := p.opts.chans.Lookup(1, rec.Iface); ok || iface == "" {
+			iface = name
+		}
+
+		if !asap {
+			dt := rec.Time.Sub(ref)
+			sched = base.Add(time.Duration(float64(dt) / p.opts.speed))
+			if wait := sched.Sub(p.clock.Now()); wait > 0 {
+				err = p.clock.Sleep(ctx, wait)
+				if err != nil {
+					return sched, err
+				}
+			}
+		}
+
+		err = p.send.Send(iface, rec.Frame)
+		if err != nil {
+			return sched, fmt.Errorf("could not send frame on %q: %w", iface, err)
+		}
+		p.stats.sent++
+		if !asap {
+			p.stats.add(p.clock.Now().Sub(sched))
+		}
+	}
+}
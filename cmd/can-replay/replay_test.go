@@ -0,0 +1,222 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+	"github.com/go-daq/canbus/canlog/candump"
+	"github.com/go-daq/canbus/cmd/internal/logopt"
+)
+
+// fakeClock is a clock waking up late from its sleeps.
+type fakeClock struct {
+	now time.Time
+	lag time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.now = c.now.Add(d + c.lag)
+	return nil
+}
+
+// fakeSender records the frames sent, with their send times.
+type fakeSender struct {
+	clock *fakeClock
+	sent  []string
+}
+
+func (s *fakeSender) Send(iface string, frame canbus.Frame) error {
+	if iface == "bad" {
+		return fmt.Errorf("no such device")
+	}
+	dt := s.clock.now.Sub(time.Unix(0, 0))
+	s.sent = append(s.sent, fmt.Sprintf("%v %s %03x", dt, iface, frame.ID))
+	return nil
+}
+
+const trace = `(1660000000.000000) can0 100#01
+(1660000000.010000) can1 101#02
+(1660000000.020000) can0 102##0
+(1660000000.030000) can0 20000004#0004000000000000
+(1660000000.040000) can0 103#R
+(1660000000.050000) can0 12345678#R
+(1660000000.100000) can1 104#
+`
+
+func TestReplay(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		opts  options
+		lag   time.Duration
+		want  []string
+		stats string
+	}{
+		{
+			name: "default",
+			opts: options{speed: 1, loops: 1},
+			want: []string{
+				"0s can0 100", "10ms can1 101", "40ms can0 103", "100ms can1 104",
+			},
+			stats: "sent 4 frames, skipped 3 frames (drift: mean=0s, min=0s, max=0s)",
+		},
+		{
+			name: "lag",
+			opts: options{speed: 1, loops: 1},
+			lag:  100 * time.Microsecond,
+			want: []string{
+				"0s can0 100", "10.1ms can1 101", "40.1ms can0 103", "100.1ms can1 104",
+			},
+			stats: "sent 4 frames, skipped 3 frames (drift: mean=75µs, min=0s, max=100µs)",
+		},
+		{
+			name: "speed",
+			opts: options{speed: 2, loops: 2},
+			want: []string{
+				"0s can0 100", "5ms can1 101", "20ms can0 103", "50ms can1 104",
+				"50ms can0 100", "55ms can1 101", "70ms can0 103", "100ms can1 104",
+			},
+			stats: "sent 8 frames, skipped 6 frames (drift: mean=0s, min=0s, max=0s)",
+		},
+		{
+			name: "slow",
+			opts: options{speed: 0.5, loops: 1, end: bound(t, "20ms")},
+			want: []string{
+				"0s can0 100", "20ms can1 101",
+			},
+			stats: "sent 2 frames, skipped 0 frames (drift: mean=0s, min=0s, max=0s)",
+		},
+		{
+			name: "asap",
+			opts: options{speed: 0, loops: 3},
+			lag:  time.Second,
+			want: []string{
+				"0s can0 100", "0s can1 101", "0s can0 103", "0s can1 104",
+				"0s can0 100", "0s can1 101", "0s can0 103", "0s can1 104",
+				"0s can0 100", "0s can1 101", "0s can0 103", "0s can1 104",
+			},
+			stats: "sent 12 frames, skipped 9 frames",
+		},
+		{
+			name: "select",
+			opts: options{
+				speed: 1, loops: 1,
+				start: bound(t, "10ms"),
+				ids:   ids(t, "!103"),
+				chans: chans(t, "can1=vcan1"),
+				iface: "vcan0",
+			},
+			want: []string{
+				"0s vcan1 101", "90ms vcan1 104",
+			},
+			stats: "sent 2 frames, skipped 3 frames (drift: mean=0s, min=0s, max=0s)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(0, 0), lag: tc.lag}
+			send := &fakeSender{clock: clock}
+			p := replayer{opts: tc.opts, clock: clock, send: send}
+			err := p.run(context.Background(), func() (canlog.Reader, io.Closer, error) {
+				return candump.NewReader(strings.NewReader(trace)), io.NopCloser(nil), nil
+			})
+			if err != nil {
+				t.Fatalf("could not replay trace: %+v", err)
+			}
+			if !reflect.DeepEqual(send.sent, tc.want) {
+				t.Fatalf("invalid frames:\ngot= %q\nwant=%q", send.sent, tc.want)
+			}
+			if got, want := p.stats.String(), tc.stats; got != want {
+				t.Fatalf("invalid stats:\ngot= %s\nwant=%s", got, want)
+			}
+		})
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	open := func(src string) func() (canlog.Reader, io.Closer, error) {
+		return func() (canlog.Reader, io.Closer, error) {
+			return candump.NewReader(strings.NewReader(src)), io.NopCloser(nil), nil
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		ctx  func() context.Context
+		opts options
+		open func() (canlog.Reader, io.Closer, error)
+		err  string
+	}{
+		{
+			name: "read",
+			open: open("(1660000000.000000) can0 100#XYZ\n"),
+			err:  `could not read record: candump: line 1: invalid frame data "XYZ"`,
+		},
+		{
+			name: "send",
+			opts: options{iface: "bad"},
+			open: open(trace),
+			err:  `could not send frame on "bad": no such device`,
+		},
+		{
+			name: "canceled",
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			},
+			open: open(trace),
+			err:  "context canceled",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.ctx != nil {
+				ctx = tc.ctx()
+			}
+			clock := &fakeClock{now: time.Unix(0, 0)}
+			p := replayer{opts: tc.opts, clock: clock, send: &fakeSender{clock: clock}}
+			err := p.run(ctx, tc.open)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func bound(t *testing.T, s string) logopt.Bound {
+	b, err := logopt.ParseBound(s)
+	if err != nil {
+		t.Fatalf("could not parse bound: %+v", err)
+	}
+	return b
+}
+
+func ids(t *testing.T, s string) logopt.IDFilter {
+	f, err := logopt.ParseIDFilter(s)
+	if err != nil {
+		t.Fatalf("could not parse identifiers: %+v", err)
+	}
+	return f
+}
+
+func chans(t *testing.T, s string) logopt.ChanMap {
+	m, err := logopt.ParseChanMap(s)
+	if err != nil {
+		t.Fatalf("could not parse channel map: %+v", err)
+	}
+	return m
+}
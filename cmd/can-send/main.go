@@ -101,27 +101,45 @@ Examples:
 		log.Fatalf("invalid CAN frame (len=%d>%d)", n, max)
 	}
 
-	sck, err := canbus.New()
+	tr, err := open(dev)
 	if err != nil {
-		log.Fatalf("error creating CAN bus socket: %v\n", err)
+		log.Fatalf("%v\n", err)
 	}
-	defer sck.Close()
+	defer tr.Close()
 
-	err = sck.Bind(dev)
+	err = send(tr, canbus.Frame{ID: uint32(id), Data: data})
 	if err != nil {
-		log.Fatalf("error binding CAN bus socket: %v\n", err)
+		log.Fatalf("%v\n", err)
 	}
 
-	_, err = sck.Send(canbus.Frame{ID: uint32(id), Data: data})
+	err = tr.Close()
 	if err != nil {
-		log.Fatalf("error sending data: %v\n", err)
+		log.Fatalf("error closing CAN bus socket: %v\n", err)
 	}
+}
 
-	err = sck.Close()
+// open returns a CAN bus socket bound to the named interface.
+func open(dev string) (canbus.Transport, error) {
+	sck, err := canbus.New()
 	if err != nil {
-		log.Fatalf("error closing CAN bus socket: %v\n", err)
+		return nil, fmt.Errorf("error creating CAN bus socket: %w", err)
 	}
 
+	err = sck.Bind(dev)
+	if err != nil {
+		sck.Close()
+		return nil, fmt.Errorf("error binding CAN bus socket: %w", err)
+	}
+	return sck, nil
+}
+
+// send sends the frame through the transport.
+func send(tr canbus.Transport, frame canbus.Frame) error {
+	_, err := tr.Send(frame)
+	if err != nil {
+		return fmt.Errorf("error sending data: %w", err)
+	}
+	return nil
 }
 
 func parseFrame(str string) []byte {
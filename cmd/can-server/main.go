@@ -0,0 +1,147 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// can-server serves local CAN interfaces to socketcand clients over TCP.
+//
+// Clients, such as socketcand tools, Kayak or python-can, open one of the
+// served interfaces, and exchange frames with it in raw mode, or manage
+// cyclic transmissions and subscriptions in BCM mode. Each client gets
+// its own CAN socket, and thus its own subscriptions.
+//
+// The server announces itself with socketcand discovery beacons,
+// broadcast on UDP port 42000, unless -beacon=false. The URL advertised
+// by the beacons defaults to the address of the server, or to the first
+// non-loopback IPv4 address of the host when the server listens on all
+// addresses.
+//
+// Usage of can-server:
+//
+//	can-server [options] <CAN interface> [<CAN interface>...]
+//	  (use CTRL-C to terminate can-server)
+//
+// Examples:
+//
+//	can-server vcan0
+//	can-server -addr 192.168.1.2:29536 can0 can1
+//	can-server -beacon=false -addr localhost:29536 vcan0
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/go-daq/canbus/socketcand"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(
+			os.Stderr,
+			`can-server serves local CAN interfaces to socketcand clients over TCP.
+
+Usage of can-server:
+
+sh> can-server [options] <CAN interface> [<CAN interface>...]
+    (use CTRL-C to terminate can-server)
+
+Examples:
+
+ can-server vcan0
+ can-server -addr 192.168.1.2:29536 can0 can1
+ can-server -beacon=false -addr localhost:29536 vcan0
+`,
+		)
+		flag.PrintDefaults()
+	}
+
+	var (
+		addr   = flag.String("addr", ":"+strconv.Itoa(socketcand.DefaultPort), "TCP address to listen on")
+		beacon = flag.Bool("beacon", true, "broadcast discovery beacons")
+		url    = flag.String("url", "", "URL advertised by the discovery beacons (default: address of the server)")
+	)
+
+	flag.Parse()
+
+	log.SetFlags(0)
+	log.SetPrefix("can-server> ")
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	for _, name := range flag.Args() {
+		_, err := net.InterfaceByName(name)
+		if err != nil {
+			log.Fatalf("could not find interface %q: %+v", name, err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("could not listen on %q: %+v", *addr, err)
+	}
+
+	srv := socketcand.NewServer(flag.Args()...)
+
+	if *beacon {
+		if *url == "" {
+			*url, err = beaconURL(ln.Addr().String())
+			if err != nil {
+				log.Fatalf("could not find beacon URL: %+v", err)
+			}
+		}
+		go func() {
+			err := srv.Announce(*url)
+			if err != nil && err != socketcand.ErrServerClosed {
+				log.Printf("could not announce server: %+v", err)
+			}
+		}()
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt)
+	go func() {
+		<-sigc
+		srv.Close()
+	}()
+
+	log.Printf("serving %v on %s", flag.Args(), ln.Addr())
+	err = srv.Serve(ln)
+	if err != nil && err != socketcand.ErrServerClosed {
+		log.Fatalf("could not serve: %+v", err)
+	}
+}
+
+// beaconURL returns the URL advertising a server listening on the
+// provided address.
+func beaconURL(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return "", err
+		}
+		host = ""
+		for _, addr := range addrs {
+			ip, ok := addr.(*net.IPNet)
+			if ok && !ip.IP.IsLoopback() && ip.IP.To4() != nil {
+				host = ip.IP.String()
+				break
+			}
+		}
+		if host == "" {
+			return "", fmt.Errorf("no IPv4 address")
+		}
+	}
+	return "can://" + net.JoinHostPort(host, port), nil
+}
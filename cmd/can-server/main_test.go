@@ -0,0 +1,40 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBeaconURL(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want string
+	}{
+		{"192.168.1.2:29536", "can://192.168.1.2:29536"},
+		{"127.0.0.1:1234", "can://127.0.0.1:1234"},
+		{"[fe80::1]:29536", "can://[fe80::1]:29536"},
+	} {
+		t.Run(tc.addr, func(t *testing.T) {
+			got, err := beaconURL(tc.addr)
+			if err != nil {
+				t.Fatalf("could not find beacon URL: %+v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("invalid URL: got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+
+	got, err := beaconURL("[::]:29536")
+	if err != nil {
+		// hosts without a non-loopback IPv4 address.
+		t.Skipf("could not find beacon URL: %+v", err)
+	}
+	if !strings.HasPrefix(got, "can://") || !strings.HasSuffix(got, ":29536") || strings.Contains(got, "[") {
+		t.Fatalf("invalid URL: %q", got)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package logopt parses the command-line options selecting and renaming
+// the records of CAN bus log files, shared by the commands handling log
+// files.
+package logopt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/canlog"
+)
+
+// Bound is a time bound of log records, either absolute or relative to
+// the first record of a log.
+type Bound struct {
+	t   time.Time
+	dt  time.Duration
+	rel bool
+	set bool
+}
+
+// ParseBound parses a time bound, given as a duration relative to the
+// first record of a log (10s), or as an RFC 3339 time.
+// An empty string gives an unset bound.
+func ParseBound(s string) (Bound, error) {
+	if s == "" {
+		return Bound{}, nil
+	}
+	if dt, err := time.ParseDuration(s); err == nil {
+		return Bound{dt: dt, rel: true, set: true}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return Bound{}, fmt.Errorf("invalid time %q (want a duration or an RFC 3339 time)", s)
+	}
+	return Bound{t: t, set: true}, nil
+}
+
+// IsSet reports whether the bound is set.
+func (b Bound) IsSet() bool {
+	return b.set
+}
+
+// Resolve returns the time of the bound, for a log starting at t0.
+func (b Bound) Resolve(t0 time.Time) time.Time {
+	if b.rel {
+		return t0.Add(b.dt)
+	}
+	return b.t
+}
+
+// IDFilter selects frames from their identifiers.
+type IDFilter struct {
+	incl []idRange
+	excl []idRange
+}
+
+type idRange struct {
+	lo, hi uint32
+}
+
+// ParseIDFilter parses a comma-separated list of hexadecimal frame
+// identifiers (123) or identifier ranges (100-1ff), excluded when
+// prefixed by '!'.
+// An empty string gives a filter selecting all frames.
+func ParseIDFilter(s string) (IDFilter, error) {
+	var f IDFilter
+	if s == "" {
+		return f, nil
+	}
+	for _, tok := range strings.Split(s, ",") {
+		tok = strings.TrimSpace(tok)
+		excl := strings.HasPrefix(tok, "!")
+		v := strings.TrimPrefix(tok, "!")
+		lo, hi := v, v
+		if i := strings.Index(v, "-"); i >= 0 {
+			lo, hi = v[:i], v[i+1:]
+		}
+		var (
+			r   idRange
+			err error
+		)
+		r.lo, err = parseID(lo)
+		if err != nil {
+			return f, fmt.Errorf("invalid identifier filter %q", tok)
+		}
+		r.hi, err = parseID(hi)
+		if err != nil || r.hi < r.lo {
+			return f, fmt.Errorf("invalid identifier filter %q", tok)
+		}
+		if excl {
+			f.excl = append(f.excl, r)
+		} else {
+			f.incl = append(f.incl, r)
+		}
+	}
+	return f, nil
+}
+
+func parseID(s string) (uint32, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 29)
+	return uint32(v), err
+}
+
+// Match reports whether the frame is selected by the filter.
+// Error frames are always selected.
+func (f IDFilter) Match(frame canbus.Frame) bool {
+	if frame.Kind == canbus.ERR {
+		return true
+	}
+	in := func(rs []idRange) bool {
+		for _, r := range rs {
+			if r.lo <= frame.ID && frame.ID <= r.hi {
+				return true
+			}
+		}
+		return false
+	}
+	if len(f.incl) > 0 && !in(f.incl) {
+		return false
+	}
+	return !in(f.excl)
+}
+
+// ChanMap renames the interfaces of log records.
+type ChanMap struct {
+	all  map[string]string         // renaming of the interfaces of all logs
+	some map[int]map[string]string // renaming of the interfaces of a given log
+}
+
+// ParseChanMap parses a comma-separated list of interface renamings
+// (from=to), restricted to the n-th log when prefixed by "n:".
+func ParseChanMap(s string) (ChanMap, error) {
+	m := ChanMap{all: make(map[string]string), some: make(map[int]map[string]string)}
+	if s == "" {
+		return m, nil
+	}
+	for _, tok := range strings.Split(s, ",") {
+		tok = strings.TrimSpace(tok)
+		i := strings.Index(tok, "=")
+		if i <= 0 || i == len(tok)-1 {
+			return m, fmt.Errorf("invalid channel mapping %q", tok)
+		}
+		from, to := tok[:i], tok[i+1:]
+		dst := m.all
+		if j := strings.Index(from, ":"); j >= 0 {
+			n, err := strconv.Atoi(from[:j])
+			if err != nil || n <= 0 || j == len(from)-1 {
+				return m, fmt.Errorf("invalid channel mapping %q", tok)
+			}
+			from = from[j+1:]
+			if m.some[n] == nil {
+				m.some[n] = make(map[string]string)
+			}
+			dst = m.some[n]
+		}
+		dst[from] = to
+	}
+	return m, nil
+}
+
+// Lookup returns the new name of an interface of the n-th log, counted
+// from 1, and whether the interface is renamed.
+func (m ChanMap) Lookup(n int, iface string) (string, bool) {
+	if to, ok := m.some[n][iface]; ok {
+		return to, true
+	}
+	if to, ok := m.all[iface]; ok {
+		return to, true
+	}
+	return iface, false
+}
+
+// Reader returns a reader renaming the interfaces of the records of the
+// n-th log, counted from 1, read from r.
+func (m ChanMap) Reader(n int, r canlog.Reader) canlog.Reader {
+	return renamer{r: r, n: n, m: m}
+}
+
+type renamer struct {
+	r canlog.Reader
+	n int
+	m ChanMap
+}
+
+func (r renamer) Read() (canlog.Record, error) {
+	rec, err := r.r.Read()
+	if err != nil {
+		return rec, err
+	}
+	rec.Iface, _ = r.m.Lookup(r.n, rec.Iface)
+	return rec, nil
+}
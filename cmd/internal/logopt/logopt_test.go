@@ -0,0 +1,141 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logopt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/cmd/internal/logopt"
+)
+
+func TestBound(t *testing.T) {
+	t0 := time.Unix(1660000000, 0)
+	for _, tc := range []struct {
+		s    string
+		set  bool
+		want time.Time
+	}{
+		{"", false, time.Time{}},
+		{"1m30s", true, t0.Add(90 * time.Second)},
+		{"-1s", true, t0.Add(-time.Second)},
+		{"2022-08-08T23:06:41.5Z", true, time.Unix(1660000001, 500000000)},
+	} {
+		b, err := logopt.ParseBound(tc.s)
+		if err != nil {
+			t.Fatalf("could not parse bound %q: %+v", tc.s, err)
+		}
+		if got, want := b.IsSet(), tc.set; got != want {
+			t.Fatalf("invalid bound %q: got=%v, want=%v", tc.s, got, want)
+		}
+		if got, want := b.Resolve(t0), tc.want; !got.Equal(want) {
+			t.Fatalf("invalid time for bound %q: got=%v, want=%v", tc.s, got, want)
+		}
+	}
+}
+
+func TestIDFilter(t *testing.T) {
+	f, err := logopt.ParseIDFilter("100-1ff, !123,0x18FEF100")
+	if err != nil {
+		t.Fatalf("could not parse filter: %+v", err)
+	}
+	for _, tc := range []struct {
+		frame canbus.Frame
+		want  bool
+	}{
+		{canbus.Frame{ID: 0x100}, true},
+		{canbus.Frame{ID: 0x1ff}, true},
+		{canbus.Frame{ID: 0x123}, false},
+		{canbus.Frame{ID: 0x200}, false},
+		{canbus.Frame{ID: 0x18fef100, Kind: canbus.EFF}, true},
+		{canbus.Frame{ID: 0x4, Kind: canbus.ERR}, true},
+	} {
+		if got, want := f.Match(tc.frame), tc.want; got != want {
+			t.Fatalf("invalid match for 0x%x: got=%v, want=%v", tc.frame.ID, got, want)
+		}
+	}
+
+	f, err = logopt.ParseIDFilter("!7df")
+	if err != nil {
+		t.Fatalf("could not parse filter: %+v", err)
+	}
+	if !f.Match(canbus.Frame{ID: 0x7e8}) || f.Match(canbus.Frame{ID: 0x7df}) {
+		t.Fatalf("invalid exclusion filter")
+	}
+}
+
+func TestChanMap(t *testing.T) {
+	m, err := logopt.ParseChanMap("1=can0,2=can1,2:1=vcan0")
+	if err != nil {
+		t.Fatalf("could not parse channel map: %+v", err)
+	}
+	for _, tc := range []struct {
+		n     int
+		iface string
+		want  string
+		ok    bool
+	}{
+		{1, "1", "can0", true},
+		{1, "2", "can1", true},
+		{2, "1", "vcan0", true},
+		{2, "2", "can1", true},
+		{1, "3", "3", false},
+	} {
+		got, ok := m.Lookup(tc.n, tc.iface)
+		if got != tc.want || ok != tc.ok {
+			t.Fatalf("invalid mapping of %d:%s: got=(%q, %v), want=(%q, %v)", tc.n, tc.iface, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		parse func() error
+		err   string
+	}{
+		{
+			name:  "bound",
+			parse: func() error { _, err := logopt.ParseBound("yesterday"); return err },
+			err:   `invalid time "yesterday" (want a duration or an RFC 3339 time)`,
+		},
+		{
+			name:  "id",
+			parse: func() error { _, err := logopt.ParseIDFilter("123,xyz"); return err },
+			err:   `invalid identifier filter "xyz"`,
+		},
+		{
+			name:  "id-range",
+			parse: func() error { _, err := logopt.ParseIDFilter("!200-100"); return err },
+			err:   `invalid identifier filter "!200-100"`,
+		},
+		{
+			name:  "id-overflow",
+			parse: func() error { _, err := logopt.ParseIDFilter("20000000"); return err },
+			err:   `invalid identifier filter "20000000"`,
+		},
+		{
+			name:  "map",
+			parse: func() error { _, err := logopt.ParseChanMap("can0"); return err },
+			err:   `invalid channel mapping "can0"`,
+		},
+		{
+			name:  "map-input",
+			parse: func() error { _, err := logopt.ParseChanMap("0:can0=1"); return err },
+			err:   `invalid channel mapping "0:can0=1"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.parse()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbc
+
+import (
+	"fmt"
+
+	"github.com/go-daq/canbus/candb"
+)
+
+// ToCANDB converts the messages of the DBC database into a candb
+// database, to encode and decode CAN frames.
+// References to the unspecified node (Vector__XXX) are dropped.
+func (db *Database) ToCANDB() (*candb.Database, error) {
+	out := &candb.Database{
+		Messages: make([]*candb.Message, 0, len(db.Messages)),
+	}
+	for _, msg := range db.Messages {
+		m, err := msg.toCANDB()
+		if err != nil {
+			return nil, err
+		}
+		out.Messages = append(out.Messages, m)
+	}
+	return out, nil
+}
+
+func (msg *Message) toCANDB() (*candb.Message, error) {
+	out := &candb.Message{
+		ID:       msg.ID,
+		Extended: msg.Extended,
+		Name:     msg.Name,
+		Size:     msg.Size,
+		Comment:  msg.Comment,
+		Signals:  make([]*candb.Signal, len(msg.Signals)),
+	}
+	if msg.Sender != nullNode {
+		out.Sender = msg.Sender
+	}
+
+	for i, sig := range msg.Signals {
+		s := &candb.Signal{
+			Name:    sig.Name,
+			Start:   sig.StartBit,
+			Length:  sig.Length,
+			Factor:  sig.Factor,
+			Offset:  sig.Offset,
+			Min:     sig.Min,
+			Max:     sig.Max,
+			Unit:    sig.Unit,
+			Comment: sig.Comment,
+		}
+		for _, r := range sig.Receivers {
+			if r != nullNode {
+				s.Receivers = append(s.Receivers, r)
+			}
+		}
+		switch sig.ByteOrder {
+		case BigEndian:
+			s.ByteOrder = candb.BigEndian
+		default:
+			s.ByteOrder = candb.LittleEndian
+		}
+		switch {
+		case sig.ValueType != Integer:
+			s.Type = candb.Float
+		case sig.Signed:
+			s.Type = candb.Signed
+		default:
+			s.Type = candb.Unsigned
+		}
+		for _, v := range sig.Values {
+			s.Values = append(s.Values, candb.ValueDesc{Value: v.Value, Desc: v.Desc})
+		}
+		out.Signals[i] = s
+	}
+
+	for i, sig := range msg.Signals {
+		var (
+			sw     string
+			ranges []candb.MuxRange
+		)
+		switch {
+		case sig.ExtMux != nil:
+			sw = sig.ExtMux.Switch
+			for _, r := range sig.ExtMux.Ranges {
+				ranges = append(ranges, candb.MuxRange{Min: r.Min, Max: r.Max})
+			}
+		case sig.Multiplexed:
+			name, err := msg.muxSwitch()
+			if err != nil {
+				return nil, fmt.Errorf("dbc: message %q: signal %q: %w", msg.Name, sig.Name, err)
+			}
+			sw = name
+			ranges = []candb.MuxRange{{Min: sig.MuxValue, Max: sig.MuxValue}}
+		default:
+			continue
+		}
+		s := out.Signal(sw)
+		if s == nil {
+			return nil, fmt.Errorf(
+				"dbc: message %q: signal %q: unknown multiplexer switch %q",
+				msg.Name, sig.Name, sw,
+			)
+		}
+		out.Signals[i].Mux = s
+		out.Signals[i].MuxRanges = ranges
+	}
+
+	err := out.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("dbc: invalid message: %w", err)
+	}
+	return out, nil
+}
+
+// muxSwitch returns the name of the multiplexer switch of the message,
+// for messages without extended multiplexing.
+func (msg *Message) muxSwitch() (string, error) {
+	var names []string
+	for _, sig := range msg.Signals {
+		if sig.MuxSwitch && !sig.Multiplexed {
+			names = append(names, sig.Name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("no multiplexer switch")
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous multiplexer switch (candidates: %q)", names)
+	}
+}
+
+// FromCANDB converts a candb database into a DBC database.
+//
+// Multiplexed signals are described with simple multiplexing when the
+// message has a single multiplexer switch and each multiplexed signal
+// is selected by a single switch value, and with extended multiplexing
+// (SG_MUL_VAL_) otherwise.
+// Nodes are created for all the senders and receivers of the messages.
+//
+// FromCANDB returns an error if a message of the candb database is not
+// valid, as reported by candb.Message.Validate.
+func FromCANDB(cdb *candb.Database) (*Database, error) {
+	for _, m := range cdb.Messages {
+		err := m.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("dbc: could not convert candb database: %w", err)
+		}
+	}
+
+	db := &Database{
+		Messages: make([]*Message, 0, len(cdb.Messages)),
+	}
+
+	nodes := make(map[string]bool)
+	node := func(name string) string {
+		if name == "" || name == nullNode {
+			return nullNode
+		}
+		if !nodes[name] {
+			nodes[name] = true
+			db.Nodes = append(db.Nodes, &Node{Name: name})
+		}
+		return name
+	}
+
+	for _, m := range cdb.Messages {
+		msg := &Message{
+			ID:       m.ID,
+			Extended: m.Extended,
+			Name:     m.Name,
+			Size:     m.Size,
+			Sender:   node(m.Sender),
+			Comment:  m.Comment,
+			Signals:  make([]*Signal, len(m.Signals)),
+		}
+		simple := simpleMux(m)
+		for i, s := range m.Signals {
+			sig := &Signal{
+				Name:     s.Name,
+				StartBit: s.Start,
+				Length:   s.Length,
+				Signed:   s.Type != candb.Unsigned,
+				Factor:   s.Factor,
+				Offset:   s.Offset,
+				Min:      s.Min,
+				Max:      s.Max,
+				Unit:     s.Unit,
+				Comment:  s.Comment,
+			}
+			switch s.ByteOrder {
+			case candb.BigEndian:
+				sig.ByteOrder = BigEndian
+			default:
+				sig.ByteOrder = LittleEndian
+			}
+			switch {
+			case s.Type == candb.Float && s.Length == 32:
+				sig.ValueType = Float32
+			case s.Type == candb.Float:
+				sig.ValueType = Float64
+			}
+			for _, r := range s.Receivers {
+				sig.Receivers = append(sig.Receivers, node(r))
+			}
+			if len(sig.Receivers) == 0 {
+				sig.Receivers = []string{nullNode}
+			}
+			for _, v := range s.Values {
+				sig.Values = append(sig.Values, ValueDesc{Value: v.Value, Desc: v.Desc})
+			}
+
+			for _, o := range m.Signals {
+				if o.Mux == s {
+					sig.MuxSwitch = true
+					break
+				}
+			}
+			if s.Mux != nil {
+				sig.Multiplexed = true
+				sig.MuxValue = s.MuxRanges[0].Min
+				if !simple {
+					sig.ExtMux = &ExtMux{Switch: s.Mux.Name}
+					for _, r := range s.MuxRanges {
+						sig.ExtMux.Ranges = append(sig.ExtMux.Ranges, MuxRange{Min: r.Min, Max: r.Max})
+					}
+				}
+			}
+			msg.Signals[i] = sig
+		}
+		db.Messages = append(db.Messages, msg)
+	}
+	return db, nil
+}
+
+// simpleMux reports whether the multiplexing of the message can be
+// described without extended multiplexing.
+func simpleMux(msg *candb.Message) bool {
+	var sw *candb.Signal
+	for _, sig := range msg.Signals {
+		if sig.Mux == nil {
+			continue
+		}
+		switch {
+		case sw != nil && sig.Mux != sw,
+			sig.Mux.Mux != nil,
+			len(sig.MuxRanges) != 1,
+			sig.MuxRanges[0].Min != sig.MuxRanges[0].Max:
+			return false
+		}
+		sw = sig.Mux
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbc_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/candb"
+	"github.com/go-daq/canbus/dbc"
+)
+
+func TestToCANDB(t *testing.T) {
+	db, err := dbc.ParseFile("testdata/example.dbc")
+	if err != nil {
+		t.Fatalf("could not parse DBC file: %+v", err)
+	}
+
+	cdb, err := db.ToCANDB()
+	if err != nil {
+		t.Fatalf("could not convert DBC database: %+v", err)
+	}
+
+	if got, want := len(cdb.Messages), len(db.Messages); got != want {
+		t.Fatalf("invalid number of messages: got=%d, want=%d", got, want)
+	}
+
+	diag := cdb.MessageByName("Diagnostics")
+	for _, tc := range []struct {
+		name   string
+		mux    string
+		ranges []candb.MuxRange
+	}{
+		{"Mode", "", nil},
+		{"Sub", "Mode", []candb.MuxRange{{Min: 1, Max: 1}}},
+		{"Voltage", "Sub", []candb.MuxRange{{Min: 0, Max: 0}}},
+		{"Current", "Mode", []candb.MuxRange{{Min: 2, Max: 2}, {Min: 4, Max: 6}}},
+		{"Temperature", "Mode", []candb.MuxRange{{Min: 3, Max: 3}}},
+	} {
+		sig := diag.Signal(tc.name)
+		var mux string
+		if sig.Mux != nil {
+			mux = sig.Mux.Name
+		}
+		if mux != tc.mux || !reflect.DeepEqual(sig.MuxRanges, tc.ranges) {
+			t.Fatalf("invalid multiplexing for %q: got=%q%v, want=%q%v",
+				tc.name, mux, sig.MuxRanges, tc.mux, tc.ranges,
+			)
+		}
+	}
+
+	vals := make(map[string]float64)
+	msg, err := cdb.Decode(canbus.Frame{
+		ID:   0x100,
+		Kind: canbus.SFF,
+		Data: []byte{0x40, 0x1f, 0x50, 0x7d, 0x00, 0x03, 0, 0},
+	}, vals)
+	if err != nil {
+		t.Fatalf("could not decode frame: %+v", err)
+	}
+	if got, want := msg.Name, "EngineData"; got != want {
+		t.Fatalf("invalid message: got=%q, want=%q", got, want)
+	}
+	if got, want := vals, map[string]float64{
+		"EngineSpeed": 2000,
+		"CoolantTemp": 40,
+		"ThrottlePos": 50,
+		"Gear":        3,
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid values:\ngot= %v\nwant=%v", got, want)
+	}
+	if desc, _ := msg.Signal("Gear").Desc(3); desc != "Drive" {
+		t.Fatalf("invalid value description: %q", desc)
+	}
+
+	sensors := cdb.MessageByName("Sensors")
+	if got, want := sensors.Signal("Flow").Type, candb.Float; got != want {
+		t.Fatalf("invalid signal type: got=%v, want=%v", got, want)
+	}
+}
+
+func TestToCANDBErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{
+			name: "overlap",
+			src:  "BO_ 1 M: 8 N\n SG_ A : 0|8@1+ (1,0) [0|0] \"\" N\n SG_ B : 4|8@1+ (1,0) [0|0] \"\" N\n",
+			err:  `dbc: invalid message: candb: message "M": signals "A" and "B" overlap`,
+		},
+		{
+			name: "no-switch",
+			src:  "BO_ 1 M: 8 N\n SG_ A m1 : 0|8@1+ (1,0) [0|0] \"\" N\n",
+			err:  `dbc: message "M": signal "A": no multiplexer switch`,
+		},
+		{
+			name: "float-length",
+			src:  "BO_ 1 M: 8 N\n SG_ A : 0|16@1- (1,0) [0|0] \"\" N\nSIG_VALTYPE_ 1 A : 1;\n",
+			err:  `dbc: invalid message: candb: message "M": signal "A": invalid float length 16`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := dbc.Parse(strings.NewReader(tc.src))
+			if err != nil {
+				t.Fatalf("could not parse DBC: %+v", err)
+			}
+			_, err = db.ToCANDB()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestFromCANDB(t *testing.T) {
+	db, err := dbc.ParseFile("testdata/example.dbc")
+	if err != nil {
+		t.Fatalf("could not parse DBC file: %+v", err)
+	}
+
+	want, err := db.ToCANDB()
+	if err != nil {
+		t.Fatalf("could not convert DBC database: %+v", err)
+	}
+
+	ddb, err := dbc.FromCANDB(want)
+	if err != nil {
+		t.Fatalf("could not convert candb database: %+v", err)
+	}
+	out := new(strings.Builder)
+	err = dbc.Write(out, ddb)
+	if err != nil {
+		t.Fatalf("could not write DBC database: %+v", err)
+	}
+
+	db, err = dbc.Parse(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatalf("could not parse DBC database: %+v\n%s", err, out)
+	}
+	got, err := db.ToCANDB()
+	if err != nil {
+		t.Fatalf("could not convert DBC database: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("round-trip failed:\n%s", out)
+	}
+
+	for _, tc := range []struct {
+		name string
+		ext  bool
+	}{
+		{"EngineData", false},
+		{"Diagnostics", true},
+	} {
+		msg := db.MessageByName(tc.name)
+		ext := false
+		for _, sig := range msg.Signals {
+			ext = ext || sig.ExtMux != nil
+		}
+		if ext != tc.ext {
+			t.Fatalf("invalid extended multiplexing for %q: got=%v, want=%v", tc.name, ext, tc.ext)
+		}
+	}
+}
+
+func TestFromCANDBErrors(t *testing.T) {
+	mux := &candb.Signal{Name: "Mode", Length: 8, Factor: 1}
+	cdb := &candb.Database{Messages: []*candb.Message{{
+		Name: "M", Size: 8,
+		Signals: []*candb.Signal{
+			mux,
+			{Name: "A", Start: 8, Length: 8, Factor: 1, Mux: mux},
+		},
+	}}}
+
+	_, err := dbc.FromCANDB(cdb)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), `dbc: could not convert candb database: candb: message "M": signal "A": no multiplexer values`; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dbc implements a parser for Vector DBC files, describing
+// the messages and signals exchanged over a CAN network.
+//
+// A typical usage might look like:
+//
+//	db, err := dbc.ParseFile("vehicle.dbc")
+//	msg := db.Message(0x123, false)
+//	for _, sig := range msg.Signals {
+//	    fmt.Println(sig.Name, sig.Unit)
+//	}
+package dbc
+
+import "fmt"
+
+// Database is the in-memory representation of a DBC file.
+type Database struct {
+	Version    string   // VERSION
+	NewSymbols []string // NS_
+	BitTiming  BitTiming
+
+	Nodes        []*Node       // BU_
+	ValueTables  []*ValueTable // VAL_TABLE_
+	Messages     []*Message    // BO_
+	EnvVars      []*EnvVar     // EV_
+	Comment      string        // network comment (CM_)
+	Attributes   []Attribute   // network attribute values (BA_)
+	AttrDefs     []*AttrDef    // attribute definitions (BA_DEF_, BA_DEF_REL_)
+	SignalGroups []*SignalGroup
+}
+
+// BitTiming describes the (obsolete) BS_ section of a DBC file.
+type BitTiming struct {
+	Baudrate uint32
+	BTR1     uint32
+	BTR2     uint32
+}
+
+// Node is a network node (ECU).
+type Node struct {
+	Name       string
+	Comment    string
+	Attributes []Attribute
+}
+
+// nullNode is the conventional name of the unspecified node.
+const nullNode = "Vector__XXX"
+
+// ValueTable is a named table of value descriptions.
+type ValueTable struct {
+	Name   string
+	Values []ValueDesc
+}
+
+// ValueDesc associates a description to a raw signal value.
+type ValueDesc struct {
+	Value int64
+	Desc  string
+}
+
+// Message describes a CAN frame.
+type Message struct {
+	ID           uint32 // CAN identifier
+	Extended     bool   // whether the message uses the extended frame format
+	Name         string
+	Size         int      // size of the message payload, in bytes
+	Sender       string   // transmitting node
+	Transmitters []string // additional transmitting nodes (BO_TX_BU_)
+	Signals      []*Signal
+	Comment      string
+	Attributes   []Attribute
+
+	// NodeAttributes holds the node-message relation attribute
+	// values (BA_REL_ BU_BO_REL_).
+	NodeAttributes []RelAttribute
+}
+
+// Signal returns the signal named name, or nil.
+func (msg *Message) Signal(name string) *Signal {
+	for _, sig := range msg.Signals {
+		if sig.Name == name {
+			return sig
+		}
+	}
+	return nil
+}
+
+// ByteOrder describes how the bits of a signal are laid out in a message.
+type ByteOrder uint8
+
+const (
+	BigEndian    ByteOrder = 0 // Motorola byte order
+	LittleEndian ByteOrder = 1 // Intel byte order
+)
+
+func (bo ByteOrder) String() string {
+	switch bo {
+	case BigEndian:
+		return "big-endian"
+	case LittleEndian:
+		return "little-endian"
+	}
+	return fmt.Sprintf("ByteOrder(%d)", uint8(bo))
+}
+
+// ValueType describes the encoding of a signal raw value (SIG_VALTYPE_).
+type ValueType uint8
+
+const (
+	Integer ValueType = 0 // signed or unsigned integer
+	Float32 ValueType = 1 // IEEE 754 single precision
+	Float64 ValueType = 2 // IEEE 754 double precision
+)
+
+// Signal describes a value encoded in a message.
+type Signal struct {
+	Name string
+
+	// MuxSwitch reports whether the signal is a multiplexer switch.
+	MuxSwitch bool
+	// Multiplexed reports whether the signal is only present when
+	// its multiplexer switch holds MuxValue.
+	Multiplexed bool
+	MuxValue    uint64
+
+	StartBit  int
+	Length    int
+	ByteOrder ByteOrder
+	Signed    bool
+	ValueType ValueType
+
+	Factor float64
+	Offset float64
+	Min    float64
+	Max    float64
+	Unit   string
+
+	Receivers  []string
+	Values     []ValueDesc // value descriptions (VAL_)
+	Comment    string
+	Attributes []Attribute
+
+	// NodeAttributes holds the node-signal relation attribute
+	// values (BA_REL_ BU_SG_REL_).
+	NodeAttributes []RelAttribute
+
+	// ExtMux describes the extended multiplexing of the signal
+	// (SG_MUL_VAL_), when present.
+	ExtMux *ExtMux
+}
+
+// ExtMux describes the extended multiplexing of a signal: the signal
+// is present when the named multiplexer switch holds a value within
+// any of the ranges.
+type ExtMux struct {
+	Switch string
+	Ranges []MuxRange
+}
+
+// MuxRange is an inclusive range of multiplexer switch values.
+type MuxRange struct {
+	Min uint64
+	Max uint64
+}
+
+// SignalGroup is a named group of signals of a message (SIG_GROUP_).
+type SignalGroup struct {
+	Message     *Message
+	Name        string
+	Repetitions uint32
+	Signals     []string
+}
+
+// EnvVar is an environment variable (EV_).
+type EnvVar struct {
+	Name     string
+	Type     EnvVarType
+	Min      float64
+	Max      float64
+	Unit     string
+	Initial  float64
+	ID       uint32
+	Access   string // access type, e.g. DUMMY_NODE_VECTOR0
+	Nodes    []string
+	DataSize int // size of ENVVAR_DATA_ variables, in bytes
+
+	Values         []ValueDesc
+	Comment        string
+	Attributes     []Attribute
+	NodeAttributes []RelAttribute // BA_REL_ BU_EV_REL_
+}
+
+// EnvVarType is the type of an environment variable.
+type EnvVarType uint8
+
+const (
+	EnvInt    EnvVarType = 0
+	EnvFloat  EnvVarType = 1
+	EnvString EnvVarType = 2
+)
+
+// ObjectType is the kind of object an attribute applies to.
+type ObjectType uint8
+
+const (
+	ObjNetwork     ObjectType = iota // whole network
+	ObjNode                          // BU_
+	ObjMessage                       // BO_
+	ObjSignal                        // SG_
+	ObjEnvVar                        // EV_
+	ObjNodeMessage                   // BU_BO_REL_
+	ObjNodeSignal                    // BU_SG_REL_
+	ObjNodeEnvVar                    // BU_EV_REL_
+)
+
+func (o ObjectType) keyword() string {
+	switch o {
+	case ObjNetwork:
+		return ""
+	case ObjNode:
+		return "BU_"
+	case ObjMessage:
+		return "BO_"
+	case ObjSignal:
+		return "SG_"
+	case ObjEnvVar:
+		return "EV_"
+	case ObjNodeMessage:
+		return "BU_BO_REL_"
+	case ObjNodeSignal:
+		return "BU_SG_REL_"
+	case ObjNodeEnvVar:
+		return "BU_EV_REL_"
+	}
+	panic(fmt.Errorf("dbc: invalid object type %d", o))
+}
+
+// name returns the name of the object type, as used in error messages.
+func (o ObjectType) name() string {
+	if o == ObjNetwork {
+		return "network"
+	}
+	return o.keyword()
+}
+
+func (o ObjectType) isRel() bool {
+	return o >= ObjNodeMessage
+}
+
+// AttrType is the value type of an attribute.
+type AttrType uint8
+
+const (
+	AttrInt AttrType = iota
+	AttrHex
+	AttrFloat
+	AttrString
+	AttrEnum
+)
+
+func (t AttrType) keyword() string {
+	switch t {
+	case AttrInt:
+		return "INT"
+	case AttrHex:
+		return "HEX"
+	case AttrFloat:
+		return "FLOAT"
+	case AttrString:
+		return "STRING"
+	case AttrEnum:
+		return "ENUM"
+	}
+	panic(fmt.Errorf("dbc: invalid attribute type %d", t))
+}
+
+// AttrDef is an attribute definition (BA_DEF_, BA_DEF_REL_), with its
+// default value (BA_DEF_DEF_, BA_DEF_DEF_REL_).
+type AttrDef struct {
+	Name   string
+	Object ObjectType
+	Type   AttrType
+	Min    float64  // minimum value of INT, HEX and FLOAT attributes
+	Max    float64  // maximum value of INT, HEX and FLOAT attributes
+	Enum   []string // values of ENUM attributes
+
+	// Default is the default value of the attribute, or nil.
+	// Default holds a float64 or a string.
+	Default any
+}
+
+// Attribute is an attribute value (BA_).
+type Attribute struct {
+	Name  string
+	Value any // float64 or string
+}
+
+// RelAttribute is a relation attribute value (BA_REL_), attached to
+// an object and a node.
+type RelAttribute struct {
+	Node  string
+	Name  string
+	Value any // float64 or string
+}
+
+// Node returns the node named name, or nil.
+func (db *Database) Node(name string) *Node {
+	for _, n := range db.Nodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// Message returns the message with the provided CAN identifier, or nil.
+func (db *Database) Message(id uint32, extended bool) *Message {
+	for _, msg := range db.Messages {
+		if msg.ID == id && msg.Extended == extended {
+			return msg
+		}
+	}
+	return nil
+}
+
+// MessageByName returns the message named name, or nil.
+func (db *Database) MessageByName(name string) *Message {
+	for _, msg := range db.Messages {
+		if msg.Name == name {
+			return msg
+		}
+	}
+	return nil
+}
+
+// EnvVar returns the environment variable named name, or nil.
+func (db *Database) EnvVar(name string) *EnvVar {
+	for _, ev := range db.EnvVars {
+		if ev.Name == name {
+			return ev
+		}
+	}
+	return nil
+}
+
+// ValueTable returns the value table named name, or nil.
+func (db *Database) ValueTable(name string) *ValueTable {
+	for _, vt := range db.ValueTables {
+		if vt.Name == name {
+			return vt
+		}
+	}
+	return nil
+}
+
+// AttrDef returns the definition of the attribute named name, or nil.
+func (db *Database) AttrDef(name string) *AttrDef {
+	for _, def := range db.AttrDefs {
+		if def.Name == name {
+			return def
+		}
+	}
+	return nil
+}
+
+// Attr returns the value of the named attribute in attrs.
+func Attr(attrs []Attribute, name string) (any, bool) {
+	for _, attr := range attrs {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return nil, false
+}
+
+// flag marking extended frame identifiers in DBC files.
+const extFlag = 0x80000000
+
+func (msg *Message) dbcID() uint32 {
+	if msg.Extended {
+		return msg.ID | extFlag
+	}
+	return msg.ID
+}
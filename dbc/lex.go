@@ -0,0 +1,213 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbc
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind uint8
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokPunct
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokEOF:
+		return "EOF"
+	case tokIdent:
+		return "identifier"
+	case tokNumber:
+		return "number"
+	case tokString:
+		return "string"
+	case tokPunct:
+		return "punctuation"
+	}
+	return fmt.Sprintf("tokenKind(%d)", uint8(k))
+}
+
+type token struct {
+	kind tokenKind
+	text string // for strings, the unquoted value
+	line int
+	col  int
+}
+
+func (tok token) String() string {
+	if tok.kind == tokEOF {
+		return "EOF"
+	}
+	return fmt.Sprintf("%q", tok.text)
+}
+
+// Error is a DBC syntax error.
+type Error struct {
+	Line int // 1-based line number
+	Col  int // 1-based column number
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dbc: %d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	col  int
+}
+
+func lex(src string) ([]token, error) {
+	lx := lexer{src: src, line: 1, col: 1}
+	var toks []token
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+		if tok.kind == tokEOF {
+			return toks, nil
+		}
+	}
+}
+
+func (lx *lexer) errorf(line, col int, format string, args ...any) error {
+	return &Error{Line: line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (lx *lexer) peek(i int) byte {
+	if lx.pos+i < len(lx.src) {
+		return lx.src[lx.pos+i]
+	}
+	return 0
+}
+
+func (lx *lexer) advance() {
+	if lx.src[lx.pos] == '\n' {
+		lx.line++
+		lx.col = 1
+	} else {
+		lx.col++
+	}
+	lx.pos++
+}
+
+func (lx *lexer) next() (token, error) {
+	// skip blanks and comments.
+	for lx.pos < len(lx.src) {
+		c := lx.src[lx.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			lx.advance()
+			continue
+		case c == '/' && lx.peek(1) == '/':
+			for lx.pos < len(lx.src) && lx.src[lx.pos] != '\n' {
+				lx.advance()
+			}
+			continue
+		}
+		break
+	}
+
+	tok := token{line: lx.line, col: lx.col}
+	if lx.pos >= len(lx.src) {
+		tok.kind = tokEOF
+		return tok, nil
+	}
+
+	beg := lx.pos
+	c := lx.src[lx.pos]
+	switch {
+	case isIdentStart(c):
+		for lx.pos < len(lx.src) && isIdent(lx.src[lx.pos]) {
+			lx.advance()
+		}
+		tok.kind = tokIdent
+		tok.text = lx.src[beg:lx.pos]
+
+	case isDigit(c) || (c == '.' && isDigit(lx.peek(1))):
+		for lx.pos < len(lx.src) && isDigit(lx.src[lx.pos]) {
+			lx.advance()
+		}
+		if lx.pos < len(lx.src) && lx.src[lx.pos] == '.' {
+			lx.advance()
+			for lx.pos < len(lx.src) && isDigit(lx.src[lx.pos]) {
+				lx.advance()
+			}
+		}
+		if c := lx.peek(0); c == 'e' || c == 'E' {
+			i := 1
+			if s := lx.peek(1); s == '+' || s == '-' {
+				i++
+			}
+			if isDigit(lx.peek(i)) {
+				for ; i > 0; i-- {
+					lx.advance()
+				}
+				for lx.pos < len(lx.src) && isDigit(lx.src[lx.pos]) {
+					lx.advance()
+				}
+			}
+		}
+		if lx.pos < len(lx.src) && isIdentStart(lx.src[lx.pos]) {
+			return tok, lx.errorf(lx.line, lx.col, "invalid character %q in number", lx.src[lx.pos])
+		}
+		tok.kind = tokNumber
+		tok.text = lx.src[beg:lx.pos]
+
+	case c == '"':
+		lx.advance()
+		var sb strings.Builder
+		for {
+			if lx.pos >= len(lx.src) {
+				return tok, lx.errorf(tok.line, tok.col, "unterminated string")
+			}
+			c := lx.src[lx.pos]
+			if c == '"' {
+				lx.advance()
+				break
+			}
+			if c == '\\' && (lx.peek(1) == '"' || lx.peek(1) == '\\') {
+				lx.advance()
+				c = lx.src[lx.pos]
+			}
+			sb.WriteByte(c)
+			lx.advance()
+		}
+		tok.kind = tokString
+		tok.text = sb.String()
+
+	case strings.IndexByte(":;,|@+-()[]", c) >= 0:
+		lx.advance()
+		tok.kind = tokPunct
+		tok.text = lx.src[beg:lx.pos]
+
+	default:
+		return tok, lx.errorf(tok.line, tok.col, "unexpected character %q", c)
+	}
+
+	return tok, nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isIdent(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
@@ -0,0 +1,1130 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbc
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseFile parses the named DBC file.
+func ParseFile(fname string) (*Database, error) {
+	raw, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("dbc: could not read DBC file: %w", err)
+	}
+	db, err := parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return db, nil
+}
+
+// Parse parses a DBC database from the provided reader.
+//
+// Syntax errors are reported as *Error values, holding the line and
+// column of the offending token.
+func Parse(r io.Reader) (*Database, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("dbc: could not read DBC data: %w", err)
+	}
+	return parse(string(raw))
+}
+
+func parse(src string) (*Database, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{toks: toks, db: new(Database)}
+	err = p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return p.db, nil
+}
+
+// keywords starting a DBC statement.
+var keywords = map[string]bool{
+	"VERSION":         true,
+	"NS_":             true,
+	"BS_":             true,
+	"BU_":             true,
+	"VAL_TABLE_":      true,
+	"BO_":             true,
+	"SG_":             true,
+	"BO_TX_BU_":       true,
+	"EV_":             true,
+	"ENVVAR_DATA_":    true,
+	"CM_":             true,
+	"BA_DEF_":         true,
+	"BA_DEF_REL_":     true,
+	"BA_DEF_DEF_":     true,
+	"BA_DEF_DEF_REL_": true,
+	"BA_":             true,
+	"BA_REL_":         true,
+	"VAL_":            true,
+	"SIG_GROUP_":      true,
+	"SIG_VALTYPE_":    true,
+	"SG_MUL_VAL_":     true,
+}
+
+type parser struct {
+	toks []token
+	pos  int
+	db   *Database
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	return &Error{Line: tok.line, Col: tok.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) is(kind tokenKind, text string) bool {
+	tok := p.peek()
+	return tok.kind == kind && tok.text == text
+}
+
+func (p *parser) isPunct(text string) bool {
+	return p.is(tokPunct, text)
+}
+
+// isItem reports whether the next token is an identifier that does
+// not start a new statement.
+func (p *parser) isItem() bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && !keywords[tok.text]
+}
+
+func (p *parser) expect(punct string) error {
+	tok := p.next()
+	if tok.kind != tokPunct || tok.text != punct {
+		return p.errorf(tok, "expected %q, got %v", punct, tok)
+	}
+	return nil
+}
+
+func (p *parser) keyword(kw string) error {
+	tok := p.next()
+	if tok.kind != tokIdent || tok.text != kw {
+		return p.errorf(tok, "expected %s, got %v", kw, tok)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		return "", p.errorf(tok, "expected identifier, got %v", tok)
+	}
+	return tok.text, nil
+}
+
+func (p *parser) str() (string, error) {
+	tok := p.next()
+	if tok.kind != tokString {
+		return "", p.errorf(tok, "expected string, got %v", tok)
+	}
+	return tok.text, nil
+}
+
+func (p *parser) float() (float64, error) {
+	sign := 1.0
+	switch {
+	case p.isPunct("-"):
+		p.next()
+		sign = -1
+	case p.isPunct("+"):
+		p.next()
+	}
+	tok := p.next()
+	if tok.kind != tokNumber {
+		return 0, p.errorf(tok, "expected number, got %v", tok)
+	}
+	v, err := strconv.ParseFloat(tok.text, 64)
+	if err != nil {
+		return 0, p.errorf(tok, "invalid number %q: %v", tok.text, err)
+	}
+	return sign * v, nil
+}
+
+func (p *parser) uint(bits int) (uint64, error) {
+	tok := p.next()
+	if tok.kind != tokNumber {
+		return 0, p.errorf(tok, "expected unsigned integer, got %v", tok)
+	}
+	v, err := strconv.ParseUint(tok.text, 10, bits)
+	if err != nil {
+		return 0, p.errorf(tok, "invalid unsigned integer %q: %v", tok.text, err)
+	}
+	return v, nil
+}
+
+func (p *parser) int() (int64, error) {
+	neg := false
+	if p.isPunct("-") {
+		p.next()
+		neg = true
+	}
+	tok := p.next()
+	if tok.kind != tokNumber {
+		return 0, p.errorf(tok, "expected integer, got %v", tok)
+	}
+	u, err := strconv.ParseUint(tok.text, 10, 64)
+	if err != nil {
+		// some tools write integral values as floats.
+		f, ferr := strconv.ParseFloat(tok.text, 64)
+		if ferr != nil || f != math.Trunc(f) || f > math.MaxInt64 {
+			return 0, p.errorf(tok, "invalid integer %q: %v", tok.text, err)
+		}
+		u = uint64(f)
+	}
+	if neg {
+		return -int64(u), nil
+	}
+	return int64(u), nil
+}
+
+func (p *parser) parse() error {
+	for {
+		tok := p.peek()
+		switch tok.kind {
+		case tokEOF:
+			return nil
+		case tokIdent:
+		default:
+			return p.errorf(tok, "expected keyword, got %v", tok)
+		}
+
+		var err error
+		switch tok.text {
+		case "VERSION":
+			err = p.parseVersion()
+		case "NS_":
+			err = p.parseNewSymbols()
+		case "BS_":
+			err = p.parseBitTiming()
+		case "BU_":
+			err = p.parseNodes()
+		case "VAL_TABLE_":
+			err = p.parseValueTable()
+		case "BO_":
+			err = p.parseMessage()
+		case "BO_TX_BU_":
+			err = p.parseTransmitters()
+		case "EV_":
+			err = p.parseEnvVar()
+		case "ENVVAR_DATA_":
+			err = p.parseEnvVarData()
+		case "CM_":
+			err = p.parseComment()
+		case "BA_DEF_", "BA_DEF_REL_":
+			err = p.parseAttrDef()
+		case "BA_DEF_DEF_", "BA_DEF_DEF_REL_":
+			err = p.parseAttrDefault()
+		case "BA_":
+			err = p.parseAttr()
+		case "BA_REL_":
+			err = p.parseRelAttr()
+		case "VAL_":
+			err = p.parseValues()
+		case "SIG_GROUP_":
+			err = p.parseSignalGroup()
+		case "SIG_VALTYPE_":
+			err = p.parseSignalValueType()
+		case "SG_MUL_VAL_":
+			err = p.parseExtMux()
+		default:
+			return p.errorf(tok, "unknown keyword %q", tok.text)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) parseVersion() error {
+	p.next()
+	v, err := p.str()
+	if err != nil {
+		return err
+	}
+	p.db.Version = v
+	return nil
+}
+
+func (p *parser) parseNewSymbols() error {
+	p.next()
+	err := p.expect(":")
+	if err != nil {
+		return err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokIdent || tok.col == 1 {
+			return nil
+		}
+		if nxt := p.toks[p.pos+1]; nxt.kind == tokPunct && nxt.text == ":" {
+			return nil
+		}
+		p.db.NewSymbols = append(p.db.NewSymbols, tok.text)
+		p.next()
+	}
+}
+
+func (p *parser) parseBitTiming() error {
+	p.next()
+	err := p.expect(":")
+	if err != nil {
+		return err
+	}
+	if p.peek().kind != tokNumber {
+		return nil
+	}
+	baud, err := p.uint(32)
+	if err != nil {
+		return err
+	}
+	err = p.expect(":")
+	if err != nil {
+		return err
+	}
+	btr1, err := p.uint(32)
+	if err != nil {
+		return err
+	}
+	err = p.expect(",")
+	if err != nil {
+		return err
+	}
+	btr2, err := p.uint(32)
+	if err != nil {
+		return err
+	}
+	p.db.BitTiming = BitTiming{
+		Baudrate: uint32(baud),
+		BTR1:     uint32(btr1),
+		BTR2:     uint32(btr2),
+	}
+	if p.isPunct(";") {
+		p.next()
+	}
+	return nil
+}
+
+func (p *parser) parseNodes() error {
+	p.next()
+	err := p.expect(":")
+	if err != nil {
+		return err
+	}
+	for p.isItem() {
+		p.db.Nodes = append(p.db.Nodes, &Node{Name: p.next().text})
+	}
+	return nil
+}
+
+func (p *parser) parseValueDescs() ([]ValueDesc, error) {
+	var vals []ValueDesc
+	for !p.isPunct(";") {
+		v, err := p.int()
+		if err != nil {
+			return nil, err
+		}
+		desc, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, ValueDesc{Value: v, Desc: desc})
+	}
+	p.next()
+	return vals, nil
+}
+
+func (p *parser) parseValueTable() error {
+	p.next()
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	vals, err := p.parseValueDescs()
+	if err != nil {
+		return err
+	}
+	p.db.ValueTables = append(p.db.ValueTables, &ValueTable{Name: name, Values: vals})
+	return nil
+}
+
+func (p *parser) parseMessage() error {
+	p.next()
+	tok := p.peek()
+	id, err := p.uint(32)
+	if err != nil {
+		return err
+	}
+	msg := &Message{
+		ID:       uint32(id) &^ extFlag,
+		Extended: uint32(id)&extFlag != 0,
+	}
+	if p.lookupMessage(uint32(id)) != nil {
+		return p.errorf(tok, "duplicate message ID %d", id)
+	}
+	msg.Name, err = p.ident()
+	if err != nil {
+		return err
+	}
+	err = p.expect(":")
+	if err != nil {
+		return err
+	}
+	size, err := p.uint(16)
+	if err != nil {
+		return err
+	}
+	msg.Size = int(size)
+	msg.Sender, err = p.ident()
+	if err != nil {
+		return err
+	}
+
+	for p.is(tokIdent, "SG_") {
+		sig, err := p.parseSignal(msg)
+		if err != nil {
+			return err
+		}
+		msg.Signals = append(msg.Signals, sig)
+	}
+
+	p.db.Messages = append(p.db.Messages, msg)
+	return nil
+}
+
+func (p *parser) parseSignal(msg *Message) (*Signal, error) {
+	p.next()
+	tok := p.peek()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if msg.Signal(name) != nil {
+		return nil, p.errorf(tok, "duplicate signal %q in message %q", name, msg.Name)
+	}
+	sig := &Signal{Name: name}
+
+	if tok := p.peek(); tok.kind == tokIdent {
+		p.next()
+		err = parseMuxIndicator(sig, tok.text)
+		if err != nil {
+			return nil, p.errorf(tok, "%v", err)
+		}
+	}
+
+	err = p.expect(":")
+	if err != nil {
+		return nil, err
+	}
+	start, err := p.uint(16)
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect("|")
+	if err != nil {
+		return nil, err
+	}
+	length, err := p.uint(16)
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect("@")
+	if err != nil {
+		return nil, err
+	}
+	tok = p.peek()
+	order, err := p.uint(8)
+	if err != nil {
+		return nil, err
+	}
+	if order > 1 {
+		return nil, p.errorf(tok, "invalid byte order %d", order)
+	}
+	tok = p.next()
+	switch {
+	case tok.kind == tokPunct && tok.text == "+":
+	case tok.kind == tokPunct && tok.text == "-":
+		sig.Signed = true
+	default:
+		return nil, p.errorf(tok, "expected value type ('+' or '-'), got %v", tok)
+	}
+	sig.StartBit = int(start)
+	sig.Length = int(length)
+	sig.ByteOrder = ByteOrder(order)
+
+	err = p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+	sig.Factor, err = p.float()
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect(",")
+	if err != nil {
+		return nil, err
+	}
+	sig.Offset, err = p.float()
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect(")")
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.expect("[")
+	if err != nil {
+		return nil, err
+	}
+	sig.Min, err = p.float()
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect("|")
+	if err != nil {
+		return nil, err
+	}
+	sig.Max, err = p.float()
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect("]")
+	if err != nil {
+		return nil, err
+	}
+
+	sig.Unit, err = p.str()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isItem() {
+		sig.Receivers = append(sig.Receivers, p.next().text)
+		if p.isPunct(",") {
+			p.next()
+		}
+	}
+
+	return sig, nil
+}
+
+func parseMuxIndicator(sig *Signal, txt string) error {
+	if txt == "M" {
+		sig.MuxSwitch = true
+		return nil
+	}
+	if !strings.HasPrefix(txt, "m") {
+		return fmt.Errorf("invalid multiplexer indicator %q", txt)
+	}
+	v := strings.TrimPrefix(txt, "m")
+	if strings.HasSuffix(v, "M") {
+		sig.MuxSwitch = true
+		v = strings.TrimSuffix(v, "M")
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid multiplexer indicator %q", txt)
+	}
+	sig.Multiplexed = true
+	sig.MuxValue = n
+	return nil
+}
+
+func (p *parser) lookupMessage(id uint32) *Message {
+	return p.db.Message(id&^extFlag, id&extFlag != 0)
+}
+
+// message parses a DBC message ID and returns the associated message.
+func (p *parser) message() (*Message, error) {
+	tok := p.peek()
+	id, err := p.uint(32)
+	if err != nil {
+		return nil, err
+	}
+	msg := p.lookupMessage(uint32(id))
+	if msg == nil {
+		return nil, p.errorf(tok, "unknown message ID %d", id)
+	}
+	return msg, nil
+}
+
+// signal parses a DBC message ID and a signal name and returns the
+// associated signal.
+func (p *parser) signal() (*Message, *Signal, error) {
+	msg, err := p.message()
+	if err != nil {
+		return nil, nil, err
+	}
+	tok := p.peek()
+	name, err := p.ident()
+	if err != nil {
+		return nil, nil, err
+	}
+	sig := msg.Signal(name)
+	if sig == nil {
+		return nil, nil, p.errorf(tok, "unknown signal %q in message %q", name, msg.Name)
+	}
+	return msg, sig, nil
+}
+
+func (p *parser) node() (*Node, error) {
+	tok := p.peek()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	node := p.db.Node(name)
+	if node == nil {
+		return nil, p.errorf(tok, "unknown node %q", name)
+	}
+	return node, nil
+}
+
+func (p *parser) envVar() (*EnvVar, error) {
+	tok := p.peek()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	ev := p.db.EnvVar(name)
+	if ev == nil {
+		return nil, p.errorf(tok, "unknown environment variable %q", name)
+	}
+	return ev, nil
+}
+
+func (p *parser) parseTransmitters() error {
+	p.next()
+	msg, err := p.message()
+	if err != nil {
+		return err
+	}
+	err = p.expect(":")
+	if err != nil {
+		return err
+	}
+	for !p.isPunct(";") {
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		msg.Transmitters = append(msg.Transmitters, name)
+		if p.isPunct(",") {
+			p.next()
+		}
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) parseEnvVar() error {
+	p.next()
+	var (
+		ev  EnvVar
+		err error
+	)
+	ev.Name, err = p.ident()
+	if err != nil {
+		return err
+	}
+	err = p.expect(":")
+	if err != nil {
+		return err
+	}
+	tok := p.peek()
+	typ, err := p.uint(8)
+	if err != nil {
+		return err
+	}
+	if typ > 2 {
+		return p.errorf(tok, "invalid environment variable type %d", typ)
+	}
+	ev.Type = EnvVarType(typ)
+	err = p.expect("[")
+	if err != nil {
+		return err
+	}
+	ev.Min, err = p.float()
+	if err != nil {
+		return err
+	}
+	err = p.expect("|")
+	if err != nil {
+		return err
+	}
+	ev.Max, err = p.float()
+	if err != nil {
+		return err
+	}
+	err = p.expect("]")
+	if err != nil {
+		return err
+	}
+	ev.Unit, err = p.str()
+	if err != nil {
+		return err
+	}
+	ev.Initial, err = p.float()
+	if err != nil {
+		return err
+	}
+	id, err := p.uint(32)
+	if err != nil {
+		return err
+	}
+	ev.ID = uint32(id)
+	ev.Access, err = p.ident()
+	if err != nil {
+		return err
+	}
+	for !p.isPunct(";") {
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		ev.Nodes = append(ev.Nodes, name)
+		if p.isPunct(",") {
+			p.next()
+		}
+	}
+	p.next()
+	p.db.EnvVars = append(p.db.EnvVars, &ev)
+	return nil
+}
+
+func (p *parser) parseEnvVarData() error {
+	p.next()
+	ev, err := p.envVar()
+	if err != nil {
+		return err
+	}
+	err = p.expect(":")
+	if err != nil {
+		return err
+	}
+	n, err := p.uint(32)
+	if err != nil {
+		return err
+	}
+	ev.DataSize = int(n)
+	return p.expect(";")
+}
+
+func (p *parser) parseComment() error {
+	p.next()
+	var dst *string
+	tok := p.peek()
+	switch {
+	case tok.kind == tokString:
+		dst = &p.db.Comment
+	case tok.kind == tokIdent && tok.text == "BU_":
+		p.next()
+		node, err := p.node()
+		if err != nil {
+			return err
+		}
+		dst = &node.Comment
+	case tok.kind == tokIdent && tok.text == "BO_":
+		p.next()
+		msg, err := p.message()
+		if err != nil {
+			return err
+		}
+		dst = &msg.Comment
+	case tok.kind == tokIdent && tok.text == "SG_":
+		p.next()
+		_, sig, err := p.signal()
+		if err != nil {
+			return err
+		}
+		dst = &sig.Comment
+	case tok.kind == tokIdent && tok.text == "EV_":
+		p.next()
+		ev, err := p.envVar()
+		if err != nil {
+			return err
+		}
+		dst = &ev.Comment
+	default:
+		return p.errorf(tok, "invalid comment target %v", tok)
+	}
+	txt, err := p.str()
+	if err != nil {
+		return err
+	}
+	*dst = txt
+	return p.expect(";")
+}
+
+func (p *parser) objectType(rel bool) ObjectType {
+	tok := p.peek()
+	if tok.kind != tokIdent {
+		return ObjNetwork
+	}
+	var obj ObjectType
+	switch tok.text {
+	case "BU_":
+		obj = ObjNode
+	case "BO_":
+		obj = ObjMessage
+	case "SG_":
+		obj = ObjSignal
+	case "EV_":
+		obj = ObjEnvVar
+	case "BU_BO_REL_":
+		obj = ObjNodeMessage
+	case "BU_SG_REL_":
+		obj = ObjNodeSignal
+	case "BU_EV_REL_":
+		obj = ObjNodeEnvVar
+	default:
+		return ObjNetwork
+	}
+	if obj.isRel() != rel {
+		return ObjNetwork
+	}
+	p.next()
+	return obj
+}
+
+func (p *parser) parseAttrDef() error {
+	kw := p.next()
+	rel := kw.text == "BA_DEF_REL_"
+
+	def := &AttrDef{Object: p.objectType(rel)}
+	if rel && !def.Object.isRel() {
+		tok := p.peek()
+		return p.errorf(tok, "expected relation object type, got %v", tok)
+	}
+
+	tok := p.peek()
+	var err error
+	def.Name, err = p.str()
+	if err != nil {
+		return err
+	}
+	if p.db.AttrDef(def.Name) != nil {
+		return p.errorf(tok, "duplicate attribute definition %q", def.Name)
+	}
+
+	tok = p.next()
+	if tok.kind != tokIdent {
+		return p.errorf(tok, "expected attribute type, got %v", tok)
+	}
+	switch tok.text {
+	case "INT", "HEX", "FLOAT":
+		switch tok.text {
+		case "INT":
+			def.Type = AttrInt
+		case "HEX":
+			def.Type = AttrHex
+		case "FLOAT":
+			def.Type = AttrFloat
+		}
+		def.Min, err = p.float()
+		if err != nil {
+			return err
+		}
+		def.Max, err = p.float()
+		if err != nil {
+			return err
+		}
+	case "STRING":
+		def.Type = AttrString
+	case "ENUM":
+		def.Type = AttrEnum
+		def.Enum = []string{}
+		for p.peek().kind == tokString {
+			def.Enum = append(def.Enum, p.next().text)
+			if p.isPunct(",") {
+				p.next()
+			}
+		}
+	default:
+		return p.errorf(tok, "invalid attribute type %q", tok.text)
+	}
+
+	p.db.AttrDefs = append(p.db.AttrDefs, def)
+	return p.expect(";")
+}
+
+func (p *parser) attrValue() (any, error) {
+	if tok := p.peek(); tok.kind == tokString {
+		p.next()
+		return tok.text, nil
+	}
+	return p.float()
+}
+
+func (p *parser) attrDef() (*AttrDef, error) {
+	tok := p.peek()
+	name, err := p.str()
+	if err != nil {
+		return nil, err
+	}
+	def := p.db.AttrDef(name)
+	if def == nil {
+		return nil, p.errorf(tok, "unknown attribute %q", name)
+	}
+	return def, nil
+}
+
+func (p *parser) parseAttrDefault() error {
+	p.next()
+	def, err := p.attrDef()
+	if err != nil {
+		return err
+	}
+	def.Default, err = p.attrValue()
+	if err != nil {
+		return err
+	}
+	return p.expect(";")
+}
+
+func (p *parser) parseAttr() error {
+	p.next()
+	tok := p.peek()
+	def, err := p.attrDef()
+	if err != nil {
+		return err
+	}
+
+	var dst *[]Attribute
+	obj := p.objectType(false)
+	if obj != def.Object {
+		return p.errorf(tok, "attribute %q does not apply to this object type", def.Name)
+	}
+	switch obj {
+	case ObjNetwork:
+		dst = &p.db.Attributes
+	case ObjNode:
+		node, err := p.node()
+		if err != nil {
+			return err
+		}
+		dst = &node.Attributes
+	case ObjMessage:
+		msg, err := p.message()
+		if err != nil {
+			return err
+		}
+		dst = &msg.Attributes
+	case ObjSignal:
+		_, sig, err := p.signal()
+		if err != nil {
+			return err
+		}
+		dst = &sig.Attributes
+	case ObjEnvVar:
+		ev, err := p.envVar()
+		if err != nil {
+			return err
+		}
+		dst = &ev.Attributes
+	}
+
+	v, err := p.attrValue()
+	if err != nil {
+		return err
+	}
+	*dst = append(*dst, Attribute{Name: def.Name, Value: v})
+	return p.expect(";")
+}
+
+func (p *parser) parseRelAttr() error {
+	p.next()
+	tok := p.peek()
+	def, err := p.attrDef()
+	if err != nil {
+		return err
+	}
+
+	obj := p.objectType(true)
+	if obj != def.Object {
+		return p.errorf(tok, "attribute %q does not apply to this object type", def.Name)
+	}
+	node, err := p.node()
+	if err != nil {
+		return err
+	}
+
+	var dst *[]RelAttribute
+	switch obj {
+	case ObjNodeMessage:
+		msg, err := p.message()
+		if err != nil {
+			return err
+		}
+		dst = &msg.NodeAttributes
+	case ObjNodeSignal:
+		err = p.keyword("SG_")
+		if err != nil {
+			return err
+		}
+		_, sig, err := p.signal()
+		if err != nil {
+			return err
+		}
+		dst = &sig.NodeAttributes
+	case ObjNodeEnvVar:
+		ev, err := p.envVar()
+		if err != nil {
+			return err
+		}
+		dst = &ev.NodeAttributes
+	}
+
+	v, err := p.attrValue()
+	if err != nil {
+		return err
+	}
+	*dst = append(*dst, RelAttribute{Node: node.Name, Name: def.Name, Value: v})
+	return p.expect(";")
+}
+
+func (p *parser) parseValues() error {
+	p.next()
+	if p.peek().kind == tokIdent {
+		ev, err := p.envVar()
+		if err != nil {
+			return err
+		}
+		ev.Values, err = p.parseValueDescs()
+		return err
+	}
+	_, sig, err := p.signal()
+	if err != nil {
+		return err
+	}
+	sig.Values, err = p.parseValueDescs()
+	return err
+}
+
+func (p *parser) parseSignalGroup() error {
+	p.next()
+	msg, err := p.message()
+	if err != nil {
+		return err
+	}
+	grp := &SignalGroup{Message: msg}
+	grp.Name, err = p.ident()
+	if err != nil {
+		return err
+	}
+	rep, err := p.uint(32)
+	if err != nil {
+		return err
+	}
+	grp.Repetitions = uint32(rep)
+	err = p.expect(":")
+	if err != nil {
+		return err
+	}
+	for !p.isPunct(";") {
+		tok := p.peek()
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		if msg.Signal(name) == nil {
+			return p.errorf(tok, "unknown signal %q in message %q", name, msg.Name)
+		}
+		grp.Signals = append(grp.Signals, name)
+		if p.isPunct(",") {
+			p.next()
+		}
+	}
+	p.next()
+	p.db.SignalGroups = append(p.db.SignalGroups, grp)
+	return nil
+}
+
+func (p *parser) parseSignalValueType() error {
+	p.next()
+	_, sig, err := p.signal()
+	if err != nil {
+		return err
+	}
+	if p.isPunct(":") {
+		p.next()
+	}
+	tok := p.peek()
+	typ, err := p.uint(8)
+	if err != nil {
+		return err
+	}
+	if typ > 2 {
+		return p.errorf(tok, "invalid signal value type %d", typ)
+	}
+	sig.ValueType = ValueType(typ)
+	return p.expect(";")
+}
+
+func (p *parser) parseExtMux() error {
+	p.next()
+	msg, sig, err := p.signal()
+	if err != nil {
+		return err
+	}
+	tok := p.peek()
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	sw := msg.Signal(name)
+	if sw == nil {
+		return p.errorf(tok, "unknown signal %q in message %q", name, msg.Name)
+	}
+	if !sw.MuxSwitch {
+		return p.errorf(tok, "signal %q is not a multiplexer switch", name)
+	}
+	mux := &ExtMux{Switch: name}
+	for !p.isPunct(";") {
+		lo, err := p.uint(64)
+		if err != nil {
+			return err
+		}
+		err = p.expect("-")
+		if err != nil {
+			return err
+		}
+		hi, err := p.uint(64)
+		if err != nil {
+			return err
+		}
+		mux.Ranges = append(mux.Ranges, MuxRange{Min: lo, Max: hi})
+		if p.isPunct(",") {
+			p.next()
+		}
+	}
+	p.next()
+	sig.ExtMux = mux
+	return nil
+}
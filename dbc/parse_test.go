@@ -0,0 +1,305 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbc_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/canbus/dbc"
+)
+
+func TestParseFile(t *testing.T) {
+	db, err := dbc.ParseFile("testdata/example.dbc")
+	if err != nil {
+		t.Fatalf("could not parse DBC file: %+v", err)
+	}
+
+	if got, want := db.Version, "1.0"; got != want {
+		t.Fatalf("invalid version: got=%q, want=%q", got, want)
+	}
+	if got, want := len(db.NewSymbols), 15; got != want {
+		t.Fatalf("invalid number of new symbols: got=%d, want=%d", got, want)
+	}
+	if got, want := db.Comment, "Example network"; got != want {
+		t.Fatalf("invalid network comment: got=%q, want=%q", got, want)
+	}
+
+	var nodes []string
+	for _, n := range db.Nodes {
+		nodes = append(nodes, n.Name)
+	}
+	if got, want := strings.Join(nodes, ","), "Engine,Gateway,Dashboard"; got != want {
+		t.Fatalf("invalid nodes: got=%q, want=%q", got, want)
+	}
+	engine := db.Node("Engine")
+	if got, want := engine.Comment, "Engine control unit"; got != want {
+		t.Fatalf("invalid node comment: got=%q, want=%q", got, want)
+	}
+	if got, want := engine.Attributes, []dbc.Attribute{{Name: "NodeLayer", Value: 2.0}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid node attributes:\ngot= %v\nwant=%v", got, want)
+	}
+
+	gears := db.ValueTable("GearTable")
+	if gears == nil || len(gears.Values) != 4 || gears.Values[3] != (dbc.ValueDesc{Value: 3, Desc: "Drive"}) {
+		t.Fatalf("invalid value table: %+v", gears)
+	}
+
+	if got, want := len(db.Messages), 3; got != want {
+		t.Fatalf("invalid number of messages: got=%d, want=%d", got, want)
+	}
+
+	msg := db.Message(0x100, false)
+	if msg == nil {
+		t.Fatalf("could not find message 0x100")
+	}
+	if got, want := msg.Name, "EngineData"; got != want {
+		t.Fatalf("invalid message name: got=%q, want=%q", got, want)
+	}
+	if got, want := msg.Size, 8; got != want {
+		t.Fatalf("invalid message size: got=%d, want=%d", got, want)
+	}
+	if got, want := msg.Comment, "Engine \"live\" data\non two lines"; got != want {
+		t.Fatalf("invalid message comment: got=%q, want=%q", got, want)
+	}
+	if got, want := msg.Transmitters, []string{"Engine", "Gateway"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid transmitters: got=%q, want=%q", got, want)
+	}
+	if got, want := msg.Attributes, []dbc.Attribute{
+		{Name: "GenMsgCycleTime", Value: 100.0},
+		{Name: "GenMsgSendType", Value: 0.0},
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid message attributes:\ngot= %v\nwant=%v", got, want)
+	}
+
+	for _, tc := range []dbc.Signal{
+		{
+			Name:      "EngineSpeed",
+			StartBit:  0,
+			Length:    16,
+			ByteOrder: dbc.LittleEndian,
+			Factor:    0.25,
+			Max:       16383.75,
+			Unit:      "rpm",
+			Receivers: []string{"Gateway", "Dashboard"},
+			Comment:   "Crankshaft speed",
+			NodeAttributes: []dbc.RelAttribute{
+				{Node: "Dashboard", Name: "GenSigTimeoutTime", Value: 250.0},
+			},
+		},
+		{
+			Name:       "CoolantTemp",
+			StartBit:   16,
+			Length:     8,
+			ByteOrder:  dbc.LittleEndian,
+			Signed:     true,
+			Factor:     1,
+			Offset:     -40,
+			Min:        -40,
+			Max:        215,
+			Unit:       "degC",
+			Receivers:  []string{"Dashboard"},
+			Attributes: []dbc.Attribute{{Name: "GenSigStartValue", Value: -40.0}},
+		},
+		{
+			Name:      "ThrottlePos",
+			StartBit:  31,
+			Length:    10,
+			ByteOrder: dbc.BigEndian,
+			Factor:    0.1,
+			Max:       100,
+			Unit:      "%",
+			Receivers: []string{"Gateway"},
+		},
+		{
+			Name:      "Gear",
+			StartBit:  40,
+			Length:    3,
+			ByteOrder: dbc.LittleEndian,
+			Factor:    1,
+			Max:       7,
+			Receivers: []string{"Dashboard"},
+			Values: []dbc.ValueDesc{
+				{0, "Park"}, {1, "Reverse"}, {2, "Neutral"}, {3, "Drive"},
+			},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			sig := msg.Signal(tc.Name)
+			if sig == nil {
+				t.Fatalf("could not find signal %q", tc.Name)
+			}
+			if !reflect.DeepEqual(*sig, tc) {
+				t.Fatalf("invalid signal:\ngot= %+v\nwant=%+v", *sig, tc)
+			}
+		})
+	}
+
+	diag := db.MessageByName("Diagnostics")
+	if diag == nil {
+		t.Fatalf("could not find message Diagnostics")
+	}
+	if got, want := diag.ID, uint32(0x160); got != want || !diag.Extended {
+		t.Fatalf("invalid message ID: got=0x%x (ext=%v), want=0x%x (ext=true)", got, diag.Extended, want)
+	}
+	if sig := diag.Signal("Mode"); !sig.MuxSwitch || sig.Multiplexed {
+		t.Fatalf("invalid multiplexer switch: %+v", sig)
+	}
+	if sig := diag.Signal("Sub"); !sig.MuxSwitch || !sig.Multiplexed || sig.MuxValue != 1 {
+		t.Fatalf("invalid extended multiplexer switch: %+v", sig)
+	}
+	if sig := diag.Signal("Temperature"); sig.MuxSwitch || !sig.Multiplexed || sig.MuxValue != 3 || sig.ExtMux != nil {
+		t.Fatalf("invalid multiplexed signal: %+v", sig)
+	}
+	if got, want := diag.Signal("Current").ExtMux, (&dbc.ExtMux{
+		Switch: "Mode",
+		Ranges: []dbc.MuxRange{{2, 2}, {4, 6}},
+	}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid extended multiplexing:\ngot= %+v\nwant=%+v", got, want)
+	}
+	if got, want := diag.Signal("Current").Factor, 1e-5; got != want {
+		t.Fatalf("invalid factor: got=%v, want=%v", got, want)
+	}
+
+	sensors := db.Message(0x200, false)
+	for _, name := range []string{"Pressure", "Flow"} {
+		if got, want := sensors.Signal(name).ValueType, dbc.Float32; got != want {
+			t.Fatalf("invalid value type for %q: got=%v, want=%v", name, got, want)
+		}
+	}
+
+	if got, want := len(db.EnvVars), 2; got != want {
+		t.Fatalf("invalid number of environment variables: got=%d, want=%d", got, want)
+	}
+	ev := db.EnvVar("EnvGear")
+	if got, want := ev.Values, []dbc.ValueDesc{{0, "P"}, {1, "R"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid env-var values: got=%v, want=%v", got, want)
+	}
+	if got, want := ev.Comment, "Gear environment variable"; got != want {
+		t.Fatalf("invalid env-var comment: got=%q, want=%q", got, want)
+	}
+	if got, want := db.EnvVar("EnvData").DataSize, 4; got != want {
+		t.Fatalf("invalid env-var data size: got=%d, want=%d", got, want)
+	}
+
+	if got, want := len(db.AttrDefs), 8; got != want {
+		t.Fatalf("invalid number of attribute definitions: got=%d, want=%d", got, want)
+	}
+	if got, want := db.AttrDef("GenMsgSendType"), (&dbc.AttrDef{
+		Name:    "GenMsgSendType",
+		Object:  dbc.ObjMessage,
+		Type:    dbc.AttrEnum,
+		Enum:    []string{"Cyclic", "Event", "IfActive"},
+		Default: "Cyclic",
+	}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid attribute definition:\ngot= %+v\nwant=%+v", got, want)
+	}
+	if got, want := db.AttrDef("GenSigTimeoutTime"), (&dbc.AttrDef{
+		Name:    "GenSigTimeoutTime",
+		Object:  dbc.ObjNodeSignal,
+		Type:    dbc.AttrInt,
+		Max:     65535,
+		Default: 500.0,
+	}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid attribute definition:\ngot= %+v\nwant=%+v", got, want)
+	}
+	if v, ok := dbc.Attr(db.Attributes, "BusType"); !ok || v != "CAN FD" {
+		t.Fatalf("invalid network attribute: %v", v)
+	}
+
+	if got, want := len(db.SignalGroups), 1; got != want {
+		t.Fatalf("invalid number of signal groups: got=%d, want=%d", got, want)
+	}
+	grp := db.SignalGroups[0]
+	if grp.Message != msg || grp.Name != "Powertrain" || grp.Repetitions != 1 ||
+		!reflect.DeepEqual(grp.Signals, []string{"EngineSpeed", "ThrottlePos", "Gear"}) {
+		t.Fatalf("invalid signal group: %+v", grp)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{
+			name: "unknown-keyword",
+			src:  "VERSION \"\"\n\nFOO_ 1;\n",
+			err:  `dbc: 3:1: unknown keyword "FOO_"`,
+		},
+		{
+			name: "unterminated-string",
+			src:  "VERSION \"\"\nCM_ \"comment;\n",
+			err:  `dbc: 2:5: unterminated string`,
+		},
+		{
+			name: "bad-byte-order",
+			src:  "BO_ 1 M: 8 N\n SG_ S : 0|8@2+ (1,0) [0|0] \"\" N\n",
+			err:  `dbc: 2:14: invalid byte order 2`,
+		},
+		{
+			name: "bad-sign",
+			src:  "BO_ 1 M: 8 N\n SG_ S : 0|8@1* (1,0) [0|0] \"\" N\n",
+			err:  `dbc: 2:15: unexpected character '*'`,
+		},
+		{
+			name: "missing-paren",
+			src:  "BO_ 1 M: 8 N\n SG_ S : 0|8@1+ 1,0) [0|0] \"\" N\n",
+			err:  `dbc: 2:17: expected "(", got "1"`,
+		},
+		{
+			name: "unknown-message",
+			src:  "BO_ 1 M: 8 N\nCM_ BO_ 2 \"comment\";\n",
+			err:  `dbc: 2:9: unknown message ID 2`,
+		},
+		{
+			name: "unknown-signal",
+			src:  "BO_ 1 M: 8 N\n SG_ S : 0|8@1+ (1,0) [0|0] \"\" N\nVAL_ 1 T 0 \"zero\";\n",
+			err:  `dbc: 3:8: unknown signal "T" in message "M"`,
+		},
+		{
+			name: "unknown-attribute",
+			src:  "BA_ \"Foo\" 1;\n",
+			err:  `dbc: 1:5: unknown attribute "Foo"`,
+		},
+		{
+			name: "invalid-attribute-object",
+			src:  "BU_: N\nBA_DEF_ BU_ \"Foo\" INT 0 1;\nBO_ 1 M: 8 N\nBA_ \"Foo\" BO_ 1 1;\n",
+			err:  `dbc: 4:5: attribute "Foo" does not apply to this object type`,
+		},
+		{
+			name: "not-a-switch",
+			src:  "BO_ 1 M: 8 N\n SG_ A : 0|8@1+ (1,0) [0|0] \"\" N\n SG_ B : 8|8@1+ (1,0) [0|0] \"\" N\nSG_MUL_VAL_ 1 B A 0-1;\n",
+			err:  `dbc: 4:17: signal "A" is not a multiplexer switch`,
+		},
+		{
+			name: "duplicate-message",
+			src:  "BO_ 1 M: 8 N\nBO_ 1 P: 8 N\n",
+			err:  `dbc: 2:5: duplicate message ID 1`,
+		},
+		{
+			name: "invalid-mux",
+			src:  "BO_ 1 M: 8 N\n SG_ S x2 : 0|8@1+ (1,0) [0|0] \"\" N\n",
+			err:  `dbc: 2:8: invalid multiplexer indicator "x2"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := dbc.Parse(strings.NewReader(tc.src))
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			var perr *dbc.Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("invalid error type %T", err)
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
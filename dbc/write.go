@@ -0,0 +1,597 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// WriteFile writes the DBC database to the named file.
+func WriteFile(fname string, db *Database) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("dbc: could not create DBC file: %w", err)
+	}
+	defer f.Close()
+
+	err = Write(f, db)
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("dbc: could not close DBC file: %w", err)
+	}
+	return nil
+}
+
+// Write writes the DBC database to w, using the canonical Vector DBC
+// syntax.
+//
+// Write checks that the names of the database objects are valid DBC
+// identifiers and that attribute values refer to defined attributes
+// applying to the right kind of objects, so that the output can be
+// parsed back into an identical database.
+func Write(w io.Writer, db *Database) error {
+	err := db.check()
+	if err != nil {
+		return err
+	}
+
+	ww := &writer{w: bufio.NewWriter(w)}
+	ww.write(db)
+	if ww.err != nil {
+		return fmt.Errorf("dbc: could not write DBC database: %w", ww.err)
+	}
+	err = ww.w.Flush()
+	if err != nil {
+		return fmt.Errorf("dbc: could not flush DBC database: %w", err)
+	}
+	return nil
+}
+
+// SetAttr sets the value of the named attribute in attrs, appending a
+// new attribute when needed, and returns the updated slice.
+func SetAttr(attrs []Attribute, name string, v any) []Attribute {
+	for i := range attrs {
+		if attrs[i].Name == name {
+			attrs[i].Value = v
+			return attrs
+		}
+	}
+	return append(attrs, Attribute{Name: name, Value: v})
+}
+
+type writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *writer) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+func (w *writer) write(db *Database) {
+	w.printf("VERSION %s\n\n\n", quote(db.Version))
+
+	w.printf("NS_ : \n")
+	for _, sym := range db.NewSymbols {
+		w.printf("\t%s\n", sym)
+	}
+	w.printf("\n")
+
+	w.printf("BS_:")
+	if bt := db.BitTiming; bt != (BitTiming{}) {
+		w.printf(" %d : %d,%d", bt.Baudrate, bt.BTR1, bt.BTR2)
+	}
+	w.printf("\n\n")
+
+	w.printf("BU_:")
+	for _, n := range db.Nodes {
+		w.printf(" %s", n.Name)
+	}
+	w.printf("\n")
+
+	for _, vt := range db.ValueTables {
+		w.printf("VAL_TABLE_ %s", vt.Name)
+		w.values(vt.Values)
+	}
+	w.printf("\n\n")
+
+	for _, msg := range db.Messages {
+		w.message(msg)
+	}
+
+	for _, msg := range db.Messages {
+		if len(msg.Transmitters) == 0 {
+			continue
+		}
+		w.printf("BO_TX_BU_ %d : %s;\n", msg.dbcID(), strings.Join(msg.Transmitters, ","))
+	}
+	w.printf("\n\n")
+
+	for _, ev := range db.EnvVars {
+		w.printf(
+			"EV_ %s: %d [%s|%s] %s %s %d %s  %s;\n",
+			ev.Name, ev.Type, fmtFloat(ev.Min), fmtFloat(ev.Max),
+			quote(ev.Unit), fmtFloat(ev.Initial), ev.ID, ev.Access,
+			strings.Join(ev.Nodes, ","),
+		)
+	}
+	w.printf("\n")
+	for _, ev := range db.EnvVars {
+		if ev.DataSize == 0 {
+			continue
+		}
+		w.printf("ENVVAR_DATA_ %s: %d;\n", ev.Name, ev.DataSize)
+	}
+	w.printf("\n")
+
+	w.comments(db)
+	w.attrDefs(db)
+	w.attrs(db)
+	w.valueDescs(db)
+
+	for _, grp := range db.SignalGroups {
+		w.printf(
+			"SIG_GROUP_ %d %s %d : %s;\n",
+			grp.Message.dbcID(), grp.Name, grp.Repetitions,
+			strings.Join(grp.Signals, " "),
+		)
+	}
+
+	for _, msg := range db.Messages {
+		for _, sig := range msg.Signals {
+			if sig.ValueType == Integer {
+				continue
+			}
+			w.printf("SIG_VALTYPE_ %d %s : %d;\n", msg.dbcID(), sig.Name, sig.ValueType)
+		}
+	}
+
+	for _, msg := range db.Messages {
+		for _, sig := range msg.Signals {
+			if sig.ExtMux == nil {
+				continue
+			}
+			w.printf("SG_MUL_VAL_ %d %s %s", msg.dbcID(), sig.Name, sig.ExtMux.Switch)
+			for i, r := range sig.ExtMux.Ranges {
+				if i > 0 {
+					w.printf(",")
+				}
+				w.printf(" %d-%d", r.Min, r.Max)
+			}
+			w.printf(";\n")
+		}
+	}
+}
+
+func (w *writer) values(vals []ValueDesc) {
+	for _, v := range vals {
+		w.printf(" %d %s", v.Value, quote(v.Desc))
+	}
+	w.printf(" ;\n")
+}
+
+func (w *writer) message(msg *Message) {
+	w.printf("BO_ %d %s: %d %s\n", msg.dbcID(), msg.Name, msg.Size, msg.Sender)
+	for _, sig := range msg.Signals {
+		w.printf(" SG_ %s ", sig.Name)
+		switch {
+		case sig.Multiplexed && sig.MuxSwitch:
+			w.printf("m%dM ", sig.MuxValue)
+		case sig.Multiplexed:
+			w.printf("m%d ", sig.MuxValue)
+		case sig.MuxSwitch:
+			w.printf("M ")
+		}
+		sign := "+"
+		if sig.Signed {
+			sign = "-"
+		}
+		w.printf(
+			": %d|%d@%d%s (%s,%s) [%s|%s] %s  %s\n",
+			sig.StartBit, sig.Length, sig.ByteOrder, sign,
+			fmtFloat(sig.Factor), fmtFloat(sig.Offset),
+			fmtFloat(sig.Min), fmtFloat(sig.Max),
+			quote(sig.Unit), strings.Join(sig.Receivers, ","),
+		)
+	}
+	w.printf("\n")
+}
+
+func (w *writer) comments(db *Database) {
+	if db.Comment != "" {
+		w.printf("CM_ %s;\n", quote(db.Comment))
+	}
+	for _, n := range db.Nodes {
+		if n.Comment != "" {
+			w.printf("CM_ BU_ %s %s;\n", n.Name, quote(n.Comment))
+		}
+	}
+	for _, msg := range db.Messages {
+		if msg.Comment != "" {
+			w.printf("CM_ BO_ %d %s;\n", msg.dbcID(), quote(msg.Comment))
+		}
+		for _, sig := range msg.Signals {
+			if sig.Comment != "" {
+				w.printf("CM_ SG_ %d %s %s;\n", msg.dbcID(), sig.Name, quote(sig.Comment))
+			}
+		}
+	}
+	for _, ev := range db.EnvVars {
+		if ev.Comment != "" {
+			w.printf("CM_ EV_ %s %s;\n", ev.Name, quote(ev.Comment))
+		}
+	}
+}
+
+func (w *writer) attrDefs(db *Database) {
+	for _, def := range db.AttrDefs {
+		kw := "BA_DEF_"
+		if def.Object.isRel() {
+			kw = "BA_DEF_REL_"
+		}
+		w.printf("%s %s %s %s", kw, def.Object.keyword(), quote(def.Name), def.Type.keyword())
+		switch def.Type {
+		case AttrInt, AttrHex, AttrFloat:
+			w.printf(" %s %s", fmtFloat(def.Min), fmtFloat(def.Max))
+		case AttrEnum:
+			for i, v := range def.Enum {
+				sep := ","
+				if i == 0 {
+					sep = " "
+				}
+				w.printf("%s%s", sep, quote(v))
+			}
+		}
+		w.printf(";\n")
+	}
+	for _, def := range db.AttrDefs {
+		if def.Default == nil {
+			continue
+		}
+		kw := "BA_DEF_DEF_"
+		if def.Object.isRel() {
+			kw = "BA_DEF_DEF_REL_"
+		}
+		w.printf("%s %s %s;\n", kw, quote(def.Name), fmtValue(def.Default))
+	}
+}
+
+func (w *writer) attrs(db *Database) {
+	for _, attr := range db.Attributes {
+		w.printf("BA_ %s %s;\n", quote(attr.Name), fmtValue(attr.Value))
+	}
+	for _, n := range db.Nodes {
+		for _, attr := range n.Attributes {
+			w.printf("BA_ %s BU_ %s %s;\n", quote(attr.Name), n.Name, fmtValue(attr.Value))
+		}
+	}
+	for _, msg := range db.Messages {
+		for _, attr := range msg.Attributes {
+			w.printf("BA_ %s BO_ %d %s;\n", quote(attr.Name), msg.dbcID(), fmtValue(attr.Value))
+		}
+	}
+	for _, msg := range db.Messages {
+		for _, sig := range msg.Signals {
+			for _, attr := range sig.Attributes {
+				w.printf(
+					"BA_ %s SG_ %d %s %s;\n",
+					quote(attr.Name), msg.dbcID(), sig.Name, fmtValue(attr.Value),
+				)
+			}
+		}
+	}
+	for _, ev := range db.EnvVars {
+		for _, attr := range ev.Attributes {
+			w.printf("BA_ %s EV_ %s %s;\n", quote(attr.Name), ev.Name, fmtValue(attr.Value))
+		}
+	}
+
+	for _, msg := range db.Messages {
+		for _, attr := range msg.NodeAttributes {
+			w.printf(
+				"BA_REL_ %s BU_BO_REL_ %s %d %s;\n",
+				quote(attr.Name), attr.Node, msg.dbcID(), fmtValue(attr.Value),
+			)
+		}
+		for _, sig := range msg.Signals {
+			for _, attr := range sig.NodeAttributes {
+				w.printf(
+					"BA_REL_ %s BU_SG_REL_ %s SG_ %d %s %s;\n",
+					quote(attr.Name), attr.Node, msg.dbcID(), sig.Name, fmtValue(attr.Value),
+				)
+			}
+		}
+	}
+	for _, ev := range db.EnvVars {
+		for _, attr := range ev.NodeAttributes {
+			w.printf(
+				"BA_REL_ %s BU_EV_REL_ %s %s %s;\n",
+				quote(attr.Name), attr.Node, ev.Name, fmtValue(attr.Value),
+			)
+		}
+	}
+}
+
+func (w *writer) valueDescs(db *Database) {
+	for _, msg := range db.Messages {
+		for _, sig := range msg.Signals {
+			if len(sig.Values) == 0 {
+				continue
+			}
+			w.printf("VAL_ %d %s", msg.dbcID(), sig.Name)
+			w.values(sig.Values)
+		}
+	}
+	for _, ev := range db.EnvVars {
+		if len(ev.Values) == 0 {
+			continue
+		}
+		w.printf("VAL_ %s", ev.Name)
+		w.values(ev.Values)
+	}
+}
+
+func quote(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s) + 2)
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func fmtFloat(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func fmtValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return quote(v)
+	case float64:
+		return fmtFloat(v)
+	}
+	panic(fmt.Errorf("dbc: invalid attribute value type %T", v))
+}
+
+// check verifies the database can be written as a valid DBC file.
+func (db *Database) check() error {
+	idents := func(kind string, names ...string) error {
+		for _, name := range names {
+			if !validIdent(name) {
+				return fmt.Errorf("dbc: invalid %s name %q", kind, name)
+			}
+		}
+		return nil
+	}
+
+	for _, sym := range db.NewSymbols {
+		// new symbols are DBC keywords.
+		if !validIdent(sym) && !keywords[sym] {
+			return fmt.Errorf("dbc: invalid new symbol %q", sym)
+		}
+	}
+
+	nodes := make(map[string]bool, len(db.Nodes))
+	for _, n := range db.Nodes {
+		err := idents("node", n.Name)
+		if err != nil {
+			return err
+		}
+		nodes[n.Name] = true
+	}
+	for _, vt := range db.ValueTables {
+		err := idents("value table", vt.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	defs := make(map[string]*AttrDef, len(db.AttrDefs))
+	for _, def := range db.AttrDefs {
+		if defs[def.Name] != nil {
+			return fmt.Errorf("dbc: duplicate attribute definition %q", def.Name)
+		}
+		defs[def.Name] = def
+		if err := checkValue(def.Name, def.Default, true); err != nil {
+			return err
+		}
+	}
+	attrs := func(obj ObjectType, attrs []Attribute) error {
+		for _, attr := range attrs {
+			def := defs[attr.Name]
+			switch {
+			case def == nil:
+				return fmt.Errorf("dbc: undefined attribute %q", attr.Name)
+			case def.Object != obj:
+				return fmt.Errorf("dbc: attribute %q does not apply to %s objects", attr.Name, obj.name())
+			}
+			if err := checkValue(attr.Name, attr.Value, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	rels := func(obj ObjectType, attrs []RelAttribute) error {
+		for _, attr := range attrs {
+			def := defs[attr.Name]
+			switch {
+			case def == nil:
+				return fmt.Errorf("dbc: undefined attribute %q", attr.Name)
+			case def.Object != obj:
+				return fmt.Errorf("dbc: attribute %q does not apply to %s objects", attr.Name, obj.name())
+			case !nodes[attr.Node]:
+				return fmt.Errorf("dbc: attribute %q refers to unknown node %q", attr.Name, attr.Node)
+			}
+			if err := checkValue(attr.Name, attr.Value, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := attrs(ObjNetwork, db.Attributes)
+	if err != nil {
+		return err
+	}
+	for _, n := range db.Nodes {
+		err := attrs(ObjNode, n.Attributes)
+		if err != nil {
+			return err
+		}
+	}
+
+	ids := make(map[uint32]bool, len(db.Messages))
+	for _, msg := range db.Messages {
+		err := idents("message", msg.Name)
+		if err != nil {
+			return err
+		}
+		err = idents("node", append([]string{msg.Sender}, msg.Transmitters...)...)
+		if err != nil {
+			return err
+		}
+		if ids[msg.dbcID()] {
+			return fmt.Errorf("dbc: duplicate message ID %d", msg.dbcID())
+		}
+		ids[msg.dbcID()] = true
+		err = attrs(ObjMessage, msg.Attributes)
+		if err != nil {
+			return err
+		}
+		err = rels(ObjNodeMessage, msg.NodeAttributes)
+		if err != nil {
+			return err
+		}
+		for _, sig := range msg.Signals {
+			err := idents("signal", sig.Name)
+			if err != nil {
+				return err
+			}
+			err = idents("node", sig.Receivers...)
+			if err != nil {
+				return err
+			}
+			if msg.Signal(sig.Name) != sig {
+				return fmt.Errorf("dbc: duplicate signal %q in message %q", sig.Name, msg.Name)
+			}
+			err = attrs(ObjSignal, sig.Attributes)
+			if err != nil {
+				return err
+			}
+			err = rels(ObjNodeSignal, sig.NodeAttributes)
+			if err != nil {
+				return err
+			}
+			if sig.ExtMux != nil {
+				sw := msg.Signal(sig.ExtMux.Switch)
+				if sw == nil || !sw.MuxSwitch {
+					return fmt.Errorf(
+						"dbc: signal %q of message %q: invalid multiplexer switch %q",
+						sig.Name, msg.Name, sig.ExtMux.Switch,
+					)
+				}
+			}
+		}
+	}
+
+	for _, ev := range db.EnvVars {
+		err := idents("environment variable", ev.Name)
+		if err != nil {
+			return err
+		}
+		err = idents("access type", ev.Access)
+		if err != nil {
+			return err
+		}
+		err = idents("node", ev.Nodes...)
+		if err != nil {
+			return err
+		}
+		err = attrs(ObjEnvVar, ev.Attributes)
+		if err != nil {
+			return err
+		}
+		err = rels(ObjNodeEnvVar, ev.NodeAttributes)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, grp := range db.SignalGroups {
+		err := idents("signal group", grp.Name)
+		if err != nil {
+			return err
+		}
+		if grp.Message == nil || !db.owns(grp.Message) {
+			return fmt.Errorf("dbc: signal group %q refers to an unknown message", grp.Name)
+		}
+		for _, name := range grp.Signals {
+			if grp.Message.Signal(name) == nil {
+				return fmt.Errorf(
+					"dbc: signal group %q refers to unknown signal %q",
+					grp.Name, name,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) owns(msg *Message) bool {
+	for _, m := range db.Messages {
+		if m == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func checkValue(name string, v any, optional bool) error {
+	switch v.(type) {
+	case string, float64:
+		return nil
+	case nil:
+		if optional {
+			return nil
+		}
+	}
+	return fmt.Errorf("dbc: attribute %q: invalid value type %T", name, v)
+}
+
+func validIdent(name string) bool {
+	if name == "" || !isIdentStart(name[0]) || keywords[name] {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isIdent(name[i]) {
+			return false
+		}
+	}
+	return true
+}
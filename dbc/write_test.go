@@ -0,0 +1,165 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dbc_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/canbus/dbc"
+)
+
+func TestWrite(t *testing.T) {
+	db, err := dbc.ParseFile("testdata/example.dbc")
+	if err != nil {
+		t.Fatalf("could not parse DBC file: %+v", err)
+	}
+
+	var buf bytes.Buffer
+	err = dbc.Write(&buf, db)
+	if err != nil {
+		t.Fatalf("could not write DBC: %+v", err)
+	}
+
+	want, err := os.ReadFile("testdata/example_golden.dbc")
+	if err != nil {
+		t.Fatalf("could not read golden file: %+v", err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("invalid DBC output:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got, err := dbc.Parse(&buf)
+	if err != nil {
+		t.Fatalf("could not parse written DBC: %+v", err)
+	}
+	if !reflect.DeepEqual(got, db) {
+		t.Fatalf("round-trip failed")
+	}
+}
+
+func TestWriteModified(t *testing.T) {
+	db, err := dbc.ParseFile("testdata/example.dbc")
+	if err != nil {
+		t.Fatalf("could not parse DBC file: %+v", err)
+	}
+
+	msg := db.MessageByName("EngineData")
+	msg.Signals = append(msg.Signals, &dbc.Signal{
+		Name:      "OilPressure",
+		StartBit:  48,
+		Length:    16,
+		ByteOrder: dbc.LittleEndian,
+		Factor:    0.01,
+		Max:       655.35,
+		Unit:      "bar",
+		Receivers: []string{"Dashboard"},
+		Comment:   "Oil pressure, \\ in \"bar\"",
+		Attributes: []dbc.Attribute{
+			{Name: "GenSigStartValue", Value: 1.0},
+		},
+	})
+	msg.Attributes = dbc.SetAttr(msg.Attributes, "GenMsgCycleTime", 20.0)
+	msg.Attributes = dbc.SetAttr(msg.Attributes, "VFrameFormat", 1.0)
+	db.AttrDefs = append(db.AttrDefs, &dbc.AttrDef{
+		Name:    "GenMsgDelayTime",
+		Object:  dbc.ObjMessage,
+		Type:    dbc.AttrInt,
+		Max:     1000,
+		Default: 0.0,
+	})
+	db.Nodes = append(db.Nodes, &dbc.Node{Name: "Logger"})
+
+	fname := filepath.Join(t.TempDir(), "out.dbc")
+	err = dbc.WriteFile(fname, db)
+	if err != nil {
+		t.Fatalf("could not write DBC file: %+v", err)
+	}
+
+	got, err := dbc.ParseFile(fname)
+	if err != nil {
+		t.Fatalf("could not parse written DBC file: %+v", err)
+	}
+	if !reflect.DeepEqual(got, db) {
+		t.Fatalf("round-trip failed")
+	}
+
+	sig := got.MessageByName("EngineData").Signal("OilPressure")
+	if sig == nil {
+		t.Fatalf("could not find new signal")
+	}
+	if got, want := sig.Comment, "Oil pressure, \\ in \"bar\""; got != want {
+		t.Fatalf("invalid comment: got=%q, want=%q", got, want)
+	}
+	if v, _ := dbc.Attr(got.MessageByName("EngineData").Attributes, "GenMsgCycleTime"); v != 20.0 {
+		t.Fatalf("invalid attribute value: %v", v)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		db   *dbc.Database
+		err  string
+	}{
+		{
+			name: "invalid-message-name",
+			db:   &dbc.Database{Messages: []*dbc.Message{{Name: "1msg", Sender: "N"}}},
+			err:  `dbc: invalid message name "1msg"`,
+		},
+		{
+			name: "keyword-signal-name",
+			db: &dbc.Database{Messages: []*dbc.Message{{
+				Name: "M", Sender: "N",
+				Signals: []*dbc.Signal{{Name: "SG_"}},
+			}}},
+			err: `dbc: invalid signal name "SG_"`,
+		},
+		{
+			name: "undefined-attribute",
+			db: &dbc.Database{
+				Attributes: []dbc.Attribute{{Name: "Foo", Value: 1.0}},
+			},
+			err: `dbc: undefined attribute "Foo"`,
+		},
+		{
+			name: "attribute-object",
+			db: &dbc.Database{
+				AttrDefs:   []*dbc.AttrDef{{Name: "Foo", Object: dbc.ObjMessage}},
+				Attributes: []dbc.Attribute{{Name: "Foo", Value: 1.0}},
+			},
+			err: `dbc: attribute "Foo" does not apply to network objects`,
+		},
+		{
+			name: "attribute-value",
+			db: &dbc.Database{
+				AttrDefs:   []*dbc.AttrDef{{Name: "Foo"}},
+				Attributes: []dbc.Attribute{{Name: "Foo", Value: 1}},
+			},
+			err: `dbc: attribute "Foo": invalid value type int`,
+		},
+		{
+			name: "duplicate-message",
+			db: &dbc.Database{Messages: []*dbc.Message{
+				{ID: 1, Name: "A", Sender: "N"},
+				{ID: 1, Name: "B", Sender: "N"},
+			}},
+			err: `dbc: duplicate message ID 1`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := dbc.Write(new(bytes.Buffer), tc.db)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
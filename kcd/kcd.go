@@ -0,0 +1,414 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package kcd reads and writes Kayak CAN definition (KCD) files,
+// describing the messages and signals exchanged over CAN networks.
+//
+// Each bus of a KCD network definition is converted from and into a
+// candb database.
+// Multiplexed signals are described in KCD files by the multiplexer
+// groups of their switch: a signal selected by several switch values
+// appears in several groups.
+package kcd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-daq/canbus/candb"
+)
+
+// Network is a set of CAN buses, described by a KCD network definition.
+type Network struct {
+	Buses []*Bus
+}
+
+// Bus returns the bus named name, or nil.
+func (net *Network) Bus(name string) *Bus {
+	for _, bus := range net.Buses {
+		if bus.Name == name {
+			return bus
+		}
+	}
+	return nil
+}
+
+// Bus is a CAN bus and the messages exchanged over it.
+type Bus struct {
+	Name     string
+	Baudrate int // bit rate, in bit/s
+	DB       *candb.Database
+}
+
+// ParseFile parses the named KCD file.
+func ParseFile(fname string) (*Network, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("kcd: could not open KCD file: %w", err)
+	}
+	defer f.Close()
+
+	net, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return net, nil
+}
+
+// Parse parses a KCD network definition from the provided reader.
+func Parse(r io.Reader) (*Network, error) {
+	var doc xmlNetwork
+	dec := xml.NewDecoder(r)
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("kcd: could not decode KCD document: %w", err)
+	}
+
+	nodes := make(map[string]string, len(doc.Nodes))
+	for _, n := range doc.Nodes {
+		nodes[n.ID] = n.Name
+	}
+
+	net := &Network{Buses: make([]*Bus, 0, len(doc.Buses))}
+	for _, xb := range doc.Buses {
+		bus := &Bus{
+			Name:     xb.Name,
+			Baudrate: 500000,
+			DB:       &candb.Database{},
+		}
+		if xb.Baudrate != "" {
+			bus.Baudrate, err = strconv.Atoi(xb.Baudrate)
+			if err != nil {
+				return nil, fmt.Errorf("kcd: bus %q: invalid baudrate %q", xb.Name, xb.Baudrate)
+			}
+		}
+		for _, xm := range xb.Messages {
+			msg, err := loadMessage(xm, nodes)
+			if err != nil {
+				return nil, fmt.Errorf("kcd: bus %q: %w", xb.Name, err)
+			}
+			bus.DB.Messages = append(bus.DB.Messages, msg)
+		}
+		err = bus.DB.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("kcd: bus %q: invalid message: %w", xb.Name, err)
+		}
+		net.Buses = append(net.Buses, bus)
+	}
+	return net, nil
+}
+
+func loadMessage(xm xmlMessage, nodes map[string]string) (*candb.Message, error) {
+	id, err := strconv.ParseUint(xm.ID, 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("message %q: invalid identifier %q", xm.Name, xm.ID)
+	}
+	msg := &candb.Message{
+		ID:       uint32(id),
+		Extended: xm.Format == "extended",
+		Name:     xm.Name,
+		Comment:  trim(xm.Notes),
+	}
+	if xm.Producer != nil {
+		for _, ref := range xm.Producer.Refs {
+			name, ok := nodes[ref.ID]
+			if !ok {
+				return nil, fmt.Errorf("message %q: unknown node %q", xm.Name, ref.ID)
+			}
+			if msg.Sender == "" {
+				msg.Sender = name
+			}
+		}
+	}
+
+	for _, xs := range xm.Multiplexes {
+		mux, err := loadSignal(xs, nodes)
+		if err != nil {
+			return nil, fmt.Errorf("message %q: %w", xm.Name, err)
+		}
+		msg.Signals = append(msg.Signals, mux)
+
+		var (
+			sigs []*candb.Signal
+			vals = make(map[*candb.Signal][]uint64)
+		)
+		for _, grp := range xs.Groups {
+			v, err := strconv.ParseUint(grp.Count, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("message %q: multiplexer %q: invalid count %q", xm.Name, mux.Name, grp.Count)
+			}
+			for _, xs := range grp.Signals {
+				sig, err := loadSignal(xs, nodes)
+				if err != nil {
+					return nil, fmt.Errorf("message %q: %w", xm.Name, err)
+				}
+				prev := find(sigs, sig.Name)
+				switch {
+				case prev == nil:
+					sigs = append(sigs, sig)
+				case !reflect.DeepEqual(prev, sig):
+					return nil, fmt.Errorf("message %q: conflicting definitions of signal %q", xm.Name, sig.Name)
+				default:
+					sig = prev
+				}
+				vals[sig] = append(vals[sig], v)
+			}
+		}
+		for _, sig := range sigs {
+			sig.Mux = mux
+			sig.MuxRanges = ranges(vals[sig])
+			msg.Signals = append(msg.Signals, sig)
+		}
+	}
+
+	for _, xs := range xm.Signals {
+		sig, err := loadSignal(xs, nodes)
+		if err != nil {
+			return nil, fmt.Errorf("message %q: %w", xm.Name, err)
+		}
+		msg.Signals = append(msg.Signals, sig)
+	}
+
+	switch xm.Length {
+	case "", "auto":
+		for _, sig := range msg.Signals {
+			end := sig.Start + sig.Length
+			if sig.ByteOrder == candb.BigEndian {
+				end = flip(sig.Start) + sig.Length
+			}
+			if n := (end + 7) / 8; n > msg.Size {
+				msg.Size = n
+			}
+		}
+	default:
+		msg.Size, err = strconv.Atoi(xm.Length)
+		if err != nil {
+			return nil, fmt.Errorf("message %q: invalid length %q", xm.Name, xm.Length)
+		}
+	}
+	return msg, nil
+}
+
+// maxLabels is the maximum number of values described by a label group.
+const maxLabels = 256
+
+func loadSignal(xs xmlSignal, nodes map[string]string) (*candb.Signal, error) {
+	sig := &candb.Signal{
+		Name:    xs.Name,
+		Length:  1,
+		Factor:  1,
+		Comment: trim(xs.Notes),
+	}
+	var err error
+	sig.Start, err = strconv.Atoi(xs.Offset)
+	if err != nil || sig.Start < 0 {
+		return nil, fmt.Errorf("signal %q: invalid offset %q", xs.Name, xs.Offset)
+	}
+	if xs.Length != "" {
+		sig.Length, err = strconv.Atoi(xs.Length)
+		if err != nil {
+			return nil, fmt.Errorf("signal %q: invalid length %q", xs.Name, xs.Length)
+		}
+	}
+	switch xs.Endianess {
+	case "", "little":
+	case "big":
+		sig.ByteOrder = candb.BigEndian
+		sig.Start = flip(sig.Start)
+	default:
+		return nil, fmt.Errorf("signal %q: invalid endianess %q", xs.Name, xs.Endianess)
+	}
+
+	if xs.Consumer != nil {
+		for _, ref := range xs.Consumer.Refs {
+			name, ok := nodes[ref.ID]
+			if !ok {
+				return nil, fmt.Errorf("signal %q: unknown node %q", xs.Name, ref.ID)
+			}
+			sig.Receivers = append(sig.Receivers, name)
+		}
+	}
+
+	if v := xs.Value; v != nil {
+		switch v.Type {
+		case "", "unsigned":
+		case "signed":
+			sig.Type = candb.Signed
+		case "single", "double":
+			sig.Type = candb.Float
+		default:
+			return nil, fmt.Errorf("signal %q: invalid type %q", xs.Name, v.Type)
+		}
+		sig.Unit = v.Unit
+		for _, attr := range []struct {
+			val string
+			ptr *float64
+		}{
+			{v.Slope, &sig.Factor},
+			{v.Intercept, &sig.Offset},
+			{v.Min, &sig.Min},
+			{v.Max, &sig.Max},
+		} {
+			if attr.val == "" {
+				continue
+			}
+			*attr.ptr, err = strconv.ParseFloat(attr.val, 64)
+			if err != nil {
+				return nil, fmt.Errorf("signal %q: invalid value %q", xs.Name, attr.val)
+			}
+		}
+	}
+
+	if xs.Labels != nil {
+		for _, l := range xs.Labels.Labels {
+			v, err := strconv.ParseInt(l.Value, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("signal %q: invalid label value %q", xs.Name, l.Value)
+			}
+			sig.Values = append(sig.Values, candb.ValueDesc{Value: v, Desc: l.Name})
+		}
+		for _, g := range xs.Labels.Groups {
+			from, err1 := strconv.ParseInt(g.From, 0, 64)
+			to, err2 := strconv.ParseInt(g.To, 0, 64)
+			if err1 != nil || err2 != nil || to < from || to-from >= maxLabels {
+				return nil, fmt.Errorf("signal %q: invalid label group [%s, %s]", xs.Name, g.From, g.To)
+			}
+			for v := from; v <= to; v++ {
+				sig.Values = append(sig.Values, candb.ValueDesc{Value: v, Desc: g.Name})
+			}
+		}
+	}
+	return sig, nil
+}
+
+// ranges returns the ranges covered by the provided values.
+func ranges(vs []uint64) []candb.MuxRange {
+	sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+	var out []candb.MuxRange
+	for _, v := range vs {
+		if n := len(out); n > 0 && out[n-1].Max+1 == v {
+			out[n-1].Max = v
+			continue
+		}
+		out = append(out, candb.MuxRange{Min: v, Max: v})
+	}
+	return out
+}
+
+func find(sigs []*candb.Signal, name string) *candb.Signal {
+	for _, sig := range sigs {
+		if sig.Name == name {
+			return sig
+		}
+	}
+	return nil
+}
+
+// flip converts between the big-endian offsets of KCD files, counting
+// bits from the most significant bit of the first byte, and the start
+// bits of candb.
+func flip(start int) int {
+	return start/8*8 + 7 - start%8
+}
+
+const namespace = "http://kayak.2codeornot2code.org/1.0"
+
+type xmlNetwork struct {
+	XMLName  xml.Name    `xml:"NetworkDefinition"`
+	Xmlns    string      `xml:"xmlns,attr,omitempty"`
+	Document xmlDocument `xml:"Document"`
+	Nodes    []xmlNode   `xml:"Node"`
+	Buses    []xmlBus    `xml:"Bus"`
+}
+
+type xmlDocument struct {
+	Name string `xml:"name,attr,omitempty"`
+}
+
+type xmlNode struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+}
+
+type xmlBus struct {
+	Name     string       `xml:"name,attr"`
+	Baudrate string       `xml:"baudrate,attr,omitempty"`
+	Messages []xmlMessage `xml:"Message"`
+}
+
+type xmlMessage struct {
+	ID          string       `xml:"id,attr"`
+	Name        string       `xml:"name,attr"`
+	Length      string       `xml:"length,attr,omitempty"`
+	Format      string       `xml:"format,attr,omitempty"`
+	Notes       string       `xml:"Notes,omitempty"`
+	Producer    *xmlNodeRefs `xml:"Producer"`
+	Multiplexes []xmlSignal  `xml:"Multiplex"`
+	Signals     []xmlSignal  `xml:"Signal"`
+}
+
+type xmlNodeRefs struct {
+	Refs []xmlNodeRef `xml:"NodeRef"`
+}
+
+type xmlNodeRef struct {
+	ID string `xml:"id,attr"`
+}
+
+// xmlSignal describes a Signal or a Multiplex element.
+type xmlSignal struct {
+	Name      string       `xml:"name,attr"`
+	Offset    string       `xml:"offset,attr"`
+	Length    string       `xml:"length,attr,omitempty"`
+	Endianess string       `xml:"endianess,attr,omitempty"`
+	Notes     string       `xml:"Notes,omitempty"`
+	Consumer  *xmlNodeRefs `xml:"Consumer"`
+	Value     *xmlValue    `xml:"Value"`
+	Labels    *xmlLabelSet `xml:"LabelSet"`
+	Groups    []xmlGroup   `xml:"MuxGroup"`
+}
+
+type xmlValue struct {
+	Type      string `xml:"type,attr,omitempty"`
+	Slope     string `xml:"slope,attr,omitempty"`
+	Intercept string `xml:"intercept,attr,omitempty"`
+	Unit      string `xml:"unit,attr,omitempty"`
+	Min       string `xml:"min,attr,omitempty"`
+	Max       string `xml:"max,attr,omitempty"`
+}
+
+type xmlLabelSet struct {
+	Labels []xmlLabel      `xml:"Label"`
+	Groups []xmlLabelGroup `xml:"LabelGroup"`
+}
+
+type xmlLabel struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type xmlLabelGroup struct {
+	Name string `xml:"name,attr"`
+	From string `xml:"from,attr"`
+	To   string `xml:"to,attr"`
+}
+
+type xmlGroup struct {
+	Count   string      `xml:"count,attr"`
+	Signals []xmlSignal `xml:"Signal"`
+}
+
+func format(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func trim(s string) string {
+	return strings.TrimSpace(s)
+}
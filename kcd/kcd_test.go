@@ -0,0 +1,300 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kcd_test
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/candb"
+	"github.com/go-daq/canbus/dbc"
+	"github.com/go-daq/canbus/kcd"
+)
+
+func TestParseFile(t *testing.T) {
+	net, err := kcd.ParseFile("testdata/example.kcd")
+	if err != nil {
+		t.Fatalf("could not parse KCD file: %+v", err)
+	}
+
+	if got, want := len(net.Buses), 2; got != want {
+		t.Fatalf("invalid number of buses: got=%d, want=%d", got, want)
+	}
+	bus := net.Bus("Powertrain")
+	if got, want := bus.Baudrate, 500000; got != want {
+		t.Fatalf("invalid baudrate: got=%d, want=%d", got, want)
+	}
+
+	vals := make(map[string]float64)
+	msg, err := bus.DB.Decode(canbus.Frame{
+		ID:   0x100,
+		Kind: canbus.SFF,
+		Data: []byte{0x40, 0x1f, 0x50, 0x03, 0, 0, 0, 0},
+	}, vals)
+	if err != nil {
+		t.Fatalf("could not decode frame: %+v", err)
+	}
+	if got, want := vals, map[string]float64{
+		"EngineSpeed": 2000,
+		"CoolantTemp": 40,
+		"Gear":        3,
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid values:\ngot= %v\nwant=%v", got, want)
+	}
+	if got, want := msg.Sender, "Engine"; got != want {
+		t.Fatalf("invalid sender: got=%q, want=%q", got, want)
+	}
+	if got, want := msg.Signal("EngineSpeed").Receivers, []string{"Dashboard"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid receivers: got=%q, want=%q", got, want)
+	}
+	if desc, _ := msg.Signal("Gear").Desc(3); desc != "Drive" {
+		t.Fatalf("invalid value description: %q", desc)
+	}
+
+	status := bus.DB.Message(0x18fef100, true)
+	if got, want := status.Signal("Level").Start, 39; got != want {
+		t.Fatalf("invalid big-endian start bit: got=%d, want=%d", got, want)
+	}
+	if got, want := status.Signal("Pressure").Type, candb.Float; got != want {
+		t.Fatalf("invalid signal type: got=%v, want=%v", got, want)
+	}
+
+	req := net.Bus("Diagnostics").DB.MessageByName("Request")
+	for _, tc := range []struct {
+		name   string
+		mux    string
+		ranges []candb.MuxRange
+	}{
+		{"Mode", "", nil},
+		{"Counter", "", nil},
+		{"Voltage", "Mode", []candb.MuxRange{{Min: 1, Max: 1}}},
+		{"Current", "Mode", []candb.MuxRange{{Min: 2, Max: 3}}},
+	} {
+		sig := req.Signal(tc.name)
+		var mux string
+		if sig.Mux != nil {
+			mux = sig.Mux.Name
+		}
+		if mux != tc.mux || !reflect.DeepEqual(sig.MuxRanges, tc.ranges) {
+			t.Fatalf("invalid multiplexing for %q: got=%q%v, want=%q%v",
+				tc.name, mux, sig.MuxRanges, tc.mux, tc.ranges,
+			)
+		}
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	const src = `<NetworkDefinition xmlns="http://kayak.2codeornot2code.org/1.0">
+  <Document name="test"/>
+  <Bus name="CAN">
+    <Message id="0x42" name="M">
+      <Signal name="Flag" offset="12"/>
+      <Signal name="State" offset="0" length="4">
+        <LabelSet>
+          <Label name="Idle" value="0"/>
+          <LabelGroup name="Error" from="8" to="9"/>
+        </LabelSet>
+      </Signal>
+    </Message>
+  </Bus>
+</NetworkDefinition>
+`
+	net, err := kcd.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("could not parse KCD: %+v", err)
+	}
+	bus := net.Buses[0]
+	if got, want := bus.Baudrate, 500000; got != want {
+		t.Fatalf("invalid baudrate: got=%d, want=%d", got, want)
+	}
+	want := &candb.Message{
+		ID:   0x42,
+		Name: "M",
+		Size: 2,
+		Signals: []*candb.Signal{
+			{Name: "Flag", Start: 12, Length: 1, Factor: 1},
+			{
+				Name: "State", Length: 4, Factor: 1,
+				Values: []candb.ValueDesc{
+					{Value: 0, Desc: "Idle"},
+					{Value: 8, Desc: "Error"},
+					{Value: 9, Desc: "Error"},
+				},
+			},
+		},
+	}
+	if got := bus.DB.Messages[0]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid message:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	net, err := kcd.ParseFile("testdata/example.kcd")
+	if err != nil {
+		t.Fatalf("could not parse KCD file: %+v", err)
+	}
+
+	var buf bytes.Buffer
+	err = kcd.Write(&buf, net)
+	if err != nil {
+		t.Fatalf("could not write KCD: %+v", err)
+	}
+
+	want, err := os.ReadFile("testdata/example.kcd")
+	if err != nil {
+		t.Fatalf("could not read golden file: %+v", err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("invalid KCD output:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got, err := kcd.Parse(&buf)
+	if err != nil {
+		t.Fatalf("could not parse written KCD: %+v", err)
+	}
+	if !reflect.DeepEqual(got, net) {
+		t.Fatalf("round-trip failed")
+	}
+}
+
+func TestConvertDBC(t *testing.T) {
+	net, err := kcd.ParseFile("testdata/example.kcd")
+	if err != nil {
+		t.Fatalf("could not parse KCD file: %+v", err)
+	}
+
+	for _, bus := range net.Buses {
+		ddb, err := dbc.FromCANDB(bus.DB)
+		if err != nil {
+			t.Fatalf("could not convert bus %q: %+v", bus.Name, err)
+		}
+		var buf bytes.Buffer
+		err = dbc.Write(&buf, ddb)
+		if err != nil {
+			t.Fatalf("could not write DBC file: %+v", err)
+		}
+
+		db, err := dbc.Parse(&buf)
+		if err != nil {
+			t.Fatalf("could not parse DBC file: %+v", err)
+		}
+		got, err := db.ToCANDB()
+		if err != nil {
+			t.Fatalf("could not convert DBC database: %+v", err)
+		}
+		if !reflect.DeepEqual(got, bus.DB) {
+			t.Fatalf("round-trip of bus %q through DBC failed", bus.Name)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	const (
+		head = `<NetworkDefinition><Document/><Node id="1" name="N"/><Bus name="CAN">`
+		tail = `</Bus></NetworkDefinition>`
+	)
+	for _, tc := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{
+			name: "root",
+			src:  `<Network/>`,
+			err:  `kcd: could not decode KCD document: expected element type <NetworkDefinition> but have <Network>`,
+		},
+		{
+			name: "invalid-id",
+			src:  head + `<Message id="x" name="M"/>` + tail,
+			err:  `kcd: bus "CAN": message "M": invalid identifier "x"`,
+		},
+		{
+			name: "unknown-producer",
+			src:  head + `<Message id="1" name="M"><Producer><NodeRef id="2"/></Producer></Message>` + tail,
+			err:  `kcd: bus "CAN": message "M": unknown node "2"`,
+		},
+		{
+			name: "invalid-type",
+			src:  head + `<Message id="1" name="M"><Signal name="S" offset="0"><Value type="int"/></Signal></Message>` + tail,
+			err:  `kcd: bus "CAN": message "M": signal "S": invalid type "int"`,
+		},
+		{
+			name: "invalid-endianess",
+			src:  head + `<Message id="1" name="M"><Signal name="S" offset="0" endianess="middle"/></Message>` + tail,
+			err:  `kcd: bus "CAN": message "M": signal "S": invalid endianess "middle"`,
+		},
+		{
+			name: "conflicting-signals",
+			src: head + `<Message id="1" name="M"><Multiplex name="X" offset="0" length="8">` +
+				`<MuxGroup count="1"><Signal name="S" offset="8" length="8"/></MuxGroup>` +
+				`<MuxGroup count="2"><Signal name="S" offset="8" length="4"/></MuxGroup>` +
+				`</Multiplex></Message>` + tail,
+			err: `kcd: bus "CAN": message "M": conflicting definitions of signal "S"`,
+		},
+		{
+			name: "overlap",
+			src:  head + `<Message id="1" name="M"><Signal name="A" offset="0" length="8"/><Signal name="B" offset="4" length="8"/></Message>` + tail,
+			err:  `kcd: bus "CAN": invalid message: candb: message "M": signals "A" and "B" overlap`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := kcd.Parse(strings.NewReader(tc.src))
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	mux := &candb.Signal{Name: "Mux", Length: 8, Factor: 1}
+	sub := &candb.Signal{
+		Name: "Sub", Start: 8, Length: 8, Factor: 1,
+		Mux: mux, MuxRanges: []candb.MuxRange{{Min: 1, Max: 1}},
+	}
+	for _, tc := range []struct {
+		name string
+		msg  *candb.Message
+		err  string
+	}{
+		{
+			name: "invalid",
+			msg:  &candb.Message{ID: 1, Name: "M", Size: 100},
+			err:  `kcd: bus "CAN": invalid database: candb: message "M": invalid size 100`,
+		},
+		{
+			name: "nested-mux",
+			msg: &candb.Message{ID: 1, Name: "M", Size: 8, Signals: []*candb.Signal{
+				mux, sub,
+				{
+					Name: "A", Start: 16, Length: 8, Factor: 1,
+					Mux: sub, MuxRanges: []candb.MuxRange{{Min: 1, Max: 1}},
+				},
+			}},
+			err: `kcd: bus "CAN": message "M": signal "A": nested multiplexing is not supported`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			net := &kcd.Network{Buses: []*kcd.Bus{{
+				Name: "CAN",
+				DB:   &candb.Database{Messages: []*candb.Message{tc.msg}},
+			}}}
+			err := kcd.Write(new(bytes.Buffer), net)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
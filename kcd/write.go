@@ -0,0 +1,242 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kcd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/go-daq/canbus/candb"
+)
+
+// maxMuxValues is the maximum number of multiplexer groups of a
+// multiplexer switch.
+const maxMuxValues = 256
+
+// WriteFile writes the network definition to the named KCD file.
+func WriteFile(fname string, net *Network) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("kcd: could not create KCD file: %w", err)
+	}
+	defer f.Close()
+
+	err = Write(f, net)
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("kcd: could not close KCD file: %w", err)
+	}
+	return nil
+}
+
+// Write writes the network definition to w, as a KCD document.
+//
+// The nodes of the document are named after the senders and receivers
+// of the messages and signals of all the buses.
+// Multiplexer switches must not be multiplexed themselves.
+func Write(w io.Writer, net *Network) error {
+	doc := xmlNetwork{Xmlns: namespace}
+	ids := make(map[string]string)
+	node := func(name string) xmlNodeRef {
+		id, ok := ids[name]
+		if !ok {
+			id = strconv.Itoa(len(ids) + 1)
+			ids[name] = id
+			doc.Nodes = append(doc.Nodes, xmlNode{ID: id, Name: name})
+		}
+		return xmlNodeRef{ID: id}
+	}
+
+	for _, bus := range net.Buses {
+		err := bus.DB.Validate()
+		if err != nil {
+			return fmt.Errorf("kcd: bus %q: invalid database: %w", bus.Name, err)
+		}
+		xb := xmlBus{Name: bus.Name}
+		if bus.Baudrate != 0 {
+			xb.Baudrate = strconv.Itoa(bus.Baudrate)
+		}
+		for _, msg := range bus.DB.Messages {
+			xm, err := newMessage(msg, node)
+			if err != nil {
+				return fmt.Errorf("kcd: bus %q: %w", bus.Name, err)
+			}
+			xb.Messages = append(xb.Messages, xm)
+		}
+		doc.Buses = append(doc.Buses, xb)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return fmt.Errorf("kcd: could not write KCD document: %w", err)
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return fmt.Errorf("kcd: could not encode KCD document: %w", err)
+	}
+	_, err = io.WriteString(w, "\n")
+	if err != nil {
+		return fmt.Errorf("kcd: could not write KCD document: %w", err)
+	}
+	return nil
+}
+
+func newMessage(msg *candb.Message, node func(string) xmlNodeRef) (xmlMessage, error) {
+	xm := xmlMessage{
+		ID:     fmt.Sprintf("0x%X", msg.ID),
+		Name:   msg.Name,
+		Length: strconv.Itoa(msg.Size),
+		Notes:  msg.Comment,
+	}
+	if msg.Extended {
+		xm.Format = "extended"
+	}
+	if msg.Sender != "" {
+		xm.Producer = &xmlNodeRefs{Refs: []xmlNodeRef{node(msg.Sender)}}
+	}
+
+	switches := make(map[*candb.Signal]bool)
+	for _, sig := range msg.Signals {
+		if sig.Mux == nil {
+			continue
+		}
+		if sig.Mux.Mux != nil {
+			return xm, fmt.Errorf(
+				"message %q: signal %q: nested multiplexing is not supported",
+				msg.Name, sig.Name,
+			)
+		}
+		switches[sig.Mux] = true
+	}
+
+	for _, sig := range msg.Signals {
+		switch {
+		case sig.Mux != nil:
+			continue
+		case !switches[sig]:
+			xm.Signals = append(xm.Signals, newSignal(sig, node))
+			continue
+		}
+
+		xs := newSignal(sig, node)
+		vals := muxValues(msg, sig)
+		if len(vals) > maxMuxValues {
+			return xm, fmt.Errorf("message %q: multiplexer %q: too many multiplexer values", msg.Name, sig.Name)
+		}
+		for _, v := range vals {
+			grp := xmlGroup{Count: strconv.FormatUint(v, 10)}
+			for _, s := range msg.Signals {
+				if s.Mux == sig && selected(s, v) {
+					grp.Signals = append(grp.Signals, newSignal(s, node))
+				}
+			}
+			xs.Groups = append(xs.Groups, grp)
+		}
+		xm.Multiplexes = append(xm.Multiplexes, xs)
+	}
+	return xm, nil
+}
+
+func newSignal(sig *candb.Signal, node func(string) xmlNodeRef) xmlSignal {
+	xs := xmlSignal{
+		Name:   sig.Name,
+		Offset: strconv.Itoa(sig.Start),
+		Length: strconv.Itoa(sig.Length),
+		Notes:  sig.Comment,
+	}
+	if sig.ByteOrder == candb.BigEndian {
+		xs.Offset = strconv.Itoa(flip(sig.Start))
+		xs.Endianess = "big"
+	}
+	if len(sig.Receivers) > 0 {
+		xs.Consumer = &xmlNodeRefs{}
+		for _, r := range sig.Receivers {
+			xs.Consumer.Refs = append(xs.Consumer.Refs, node(r))
+		}
+	}
+
+	var v xmlValue
+	switch {
+	case sig.Type == candb.Signed:
+		v.Type = "signed"
+	case sig.Type == candb.Float && sig.Length == 32:
+		v.Type = "single"
+	case sig.Type == candb.Float:
+		v.Type = "double"
+	}
+	if sig.Factor != 1 {
+		v.Slope = format(sig.Factor)
+	}
+	if sig.Offset != 0 {
+		v.Intercept = format(sig.Offset)
+	}
+	v.Unit = sig.Unit
+	if sig.Min != 0 || sig.Max != 0 {
+		v.Min = format(sig.Min)
+		v.Max = format(sig.Max)
+	}
+	if v != (xmlValue{}) {
+		xs.Value = &v
+	}
+
+	if len(sig.Values) > 0 {
+		xs.Labels = &xmlLabelSet{}
+		for _, d := range sig.Values {
+			xs.Labels.Labels = append(xs.Labels.Labels, xmlLabel{
+				Name:  d.Desc,
+				Value: strconv.FormatInt(d.Value, 10),
+			})
+		}
+	}
+	return xs
+}
+
+// muxValues returns the values of the multiplexer switch selecting
+// the signals of the message.
+func muxValues(msg *candb.Message, mux *candb.Signal) []uint64 {
+	var (
+		vals []uint64
+		seen = make(map[uint64]bool)
+	)
+	for _, sig := range msg.Signals {
+		if sig.Mux != mux {
+			continue
+		}
+		for _, r := range sig.MuxRanges {
+			for v := r.Min; v <= r.Max && len(vals) <= maxMuxValues; v++ {
+				if !seen[v] {
+					seen[v] = true
+					vals = append(vals, v)
+				}
+				if v == r.Max {
+					break // avoid overflows
+				}
+			}
+		}
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+	return vals
+}
+
+// selected reports whether the signal is present in the frames where
+// its multiplexer switch has value v.
+func selected(sig *candb.Signal, v uint64) bool {
+	for _, r := range sig.MuxRanges {
+		if r.Min <= v && v <= r.Max {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,417 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lss implements a CANopen Layer Setting Services (LSS) master,
+// as described in CiA 305.
+//
+// LSS is used to assign a node-ID and a bit timing to CANopen nodes
+// that have not been configured yet, addressing them through their
+// LSS address (vendor-ID, product code, revision number and serial
+// number) instead of a node-ID.
+//
+// A typical usage might look like:
+//
+//	sck, err := canbus.New()
+//	err = sck.Bind("can0")
+//	m := lss.NewMaster(sck)
+//	addr, err := m.Fastscan()
+//	err = m.ConfigureNodeID(42)
+//	err = m.StoreConfiguration()
+//	err = m.SwitchStateGlobal(lss.Waiting)
+package lss
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-daq/canbus"
+)
+
+const (
+	// MasterID is the COB-ID used by the LSS master to send requests.
+	MasterID = 0x7e5
+	// SlaveID is the COB-ID used by LSS slaves to send responses.
+	SlaveID = 0x7e4
+
+	// DefaultTimeout is the default duration the master waits for an
+	// LSS slave response.
+	DefaultTimeout = 100 * time.Millisecond
+)
+
+// LSS command specifiers.
+const (
+	csSwitchGlobal       = 0x04
+	csConfigureNodeID    = 0x11
+	csConfigureBitTiming = 0x13
+	csActivateBitTiming  = 0x15
+	csStoreConfiguration = 0x17
+	csSwitchVendor       = 0x40
+	csSwitchProduct      = 0x41
+	csSwitchRevision     = 0x42
+	csSwitchSerial       = 0x43
+	csSwitchResponse     = 0x44
+	csIdentifySlave      = 0x4f
+	csFastscan           = 0x51
+	csInquireVendor      = 0x5a
+	csInquireProduct     = 0x5b
+	csInquireRevision    = 0x5c
+	csInquireSerial      = 0x5d
+	csInquireNodeID      = 0x5e
+
+	// fastscanConfirm is the BitChecked value used to reset the
+	// fastscan state of all non-configured slaves.
+	fastscanConfirm = 0x80
+)
+
+var (
+	// ErrNoResponse is returned when no LSS slave answered a request
+	// before the master timeout expired.
+	ErrNoResponse = errors.New("lss: no response from slave")
+
+	// ErrInvalidNodeID is returned when trying to assign a node-ID
+	// outside of the [1, 127] range (or 255, to unconfigure a node).
+	ErrInvalidNodeID = errors.New("lss: invalid node-ID")
+)
+
+// Mode is the LSS state a slave can be switched to.
+type Mode uint8
+
+const (
+	Waiting       Mode = 0 // LSS waiting state
+	Configuration Mode = 1 // LSS configuration state
+)
+
+// Address is the LSS address of a CANopen node, as stored in its
+// identity object (0x1018).
+type Address struct {
+	VendorID       uint32
+	ProductCode    uint32
+	RevisionNumber uint32
+	SerialNumber   uint32
+}
+
+func (addr Address) String() string {
+	return fmt.Sprintf(
+		"vendor=0x%08x product=0x%08x revision=0x%08x serial=0x%08x",
+		addr.VendorID, addr.ProductCode, addr.RevisionNumber, addr.SerialNumber,
+	)
+}
+
+func (addr Address) field(i int) uint32 {
+	switch i {
+	case 0:
+		return addr.VendorID
+	case 1:
+		return addr.ProductCode
+	case 2:
+		return addr.RevisionNumber
+	case 3:
+		return addr.SerialNumber
+	}
+	panic(fmt.Errorf("lss: invalid LSS address index %d", i))
+}
+
+func (addr *Address) setField(i int, v uint32) {
+	switch i {
+	case 0:
+		addr.VendorID = v
+	case 1:
+		addr.ProductCode = v
+	case 2:
+		addr.RevisionNumber = v
+	case 3:
+		addr.SerialNumber = v
+	default:
+		panic(fmt.Errorf("lss: invalid LSS address index %d", i))
+	}
+}
+
+// Bitrate is an index into the CiA 305 standard bit timing table.
+type Bitrate uint8
+
+const (
+	Bitrate1000k Bitrate = 0
+	Bitrate800k  Bitrate = 1
+	Bitrate500k  Bitrate = 2
+	Bitrate250k  Bitrate = 3
+	Bitrate125k  Bitrate = 4
+	Bitrate50k   Bitrate = 6
+	Bitrate20k   Bitrate = 7
+	Bitrate10k   Bitrate = 8
+	BitrateAuto  Bitrate = 9 // automatic bit rate detection
+)
+
+// ConfigError describes an error reported by an LSS slave in answer
+// to a configuration request.
+type ConfigError struct {
+	Op   string // configuration operation that failed
+	Code uint8  // LSS error code
+	Spec uint8  // implementation specific error code (when Code is 0xff)
+}
+
+func (e *ConfigError) Error() string {
+	if e.Code == 0xff {
+		return fmt.Sprintf("lss: %s failed: implementation specific error 0x%02x", e.Op, e.Spec)
+	}
+	return fmt.Sprintf("lss: %s failed: error code %d", e.Op, e.Code)
+}
+
+// Master is a CANopen LSS master.
+//
+// A Master is not safe for concurrent use: LSS is a request/response
+// protocol and only one request may be in flight on the bus.
+type Master struct {
+	bus canbus.Transport
+
+	// Timeout is the duration the master waits for a slave response.
+	// The zero value means DefaultTimeout.
+	Timeout time.Duration
+}
+
+// NewMaster returns a new LSS master sending and receiving LSS
+// messages through the provided transport, such as a canbus.Socket
+// bound to a CAN interface.
+//
+// The master reconfigures the transport receive timeout while waiting
+// for slave responses.
+func NewMaster(tr canbus.Transport) *Master {
+	return &Master{bus: tr, Timeout: DefaultTimeout}
+}
+
+// SwitchStateGlobal switches all the LSS slaves on the bus to the
+// provided mode.
+func (m *Master) SwitchStateGlobal(mode Mode) error {
+	return m.send([8]byte{csSwitchGlobal, byte(mode)})
+}
+
+// SwitchStateSelective switches the LSS slave whose LSS address
+// matches the provided one to the configuration state.
+func (m *Master) SwitchStateSelective(addr Address) error {
+	for i, cs := range []byte{csSwitchVendor, csSwitchProduct, csSwitchRevision} {
+		err := m.send(request(cs, addr.field(i)))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := m.query(request(csSwitchSerial, addr.SerialNumber), csSwitchResponse)
+	if err != nil {
+		return fmt.Errorf("lss: could not switch state selective (%v): %w", addr, err)
+	}
+	return nil
+}
+
+// ConfigureNodeID assigns the provided node-ID to the LSS slave in
+// configuration state.
+//
+// Valid node-IDs are in the [1, 127] range. The 255 node-ID marks the
+// slave as unconfigured.
+func (m *Master) ConfigureNodeID(id uint8) error {
+	if (id == 0 || id > 127) && id != 0xff {
+		return ErrInvalidNodeID
+	}
+	return m.configure("configure node-ID", [8]byte{csConfigureNodeID, id})
+}
+
+// ConfigureBitTiming configures the bit timing of the LSS slave in
+// configuration state, using the CiA 305 standard bit timing table.
+// The new bit timing is only applied once ActivateBitTiming is called.
+func (m *Master) ConfigureBitTiming(rate Bitrate) error {
+	return m.ConfigureBitTimingTable(0, uint8(rate))
+}
+
+// ConfigureBitTimingTable configures the bit timing of the LSS slave
+// in configuration state, using the provided table selector and
+// table index.
+// Table selector 0 is the CiA 305 standard bit timing table, selectors
+// 128 to 255 are manufacturer specific tables.
+func (m *Master) ConfigureBitTimingTable(table, index uint8) error {
+	return m.configure("configure bit timing", [8]byte{csConfigureBitTiming, table, index})
+}
+
+// ActivateBitTiming requests all LSS slaves in configuration state to
+// switch to their newly configured bit timing.
+// Slaves stop transmitting for delay, switch bit timing and wait for
+// another delay before transmitting again.
+func (m *Master) ActivateBitTiming(delay time.Duration) error {
+	ms := delay.Milliseconds()
+	if ms < 0 || ms > 0xffff {
+		return fmt.Errorf("lss: invalid switch delay %v", delay)
+	}
+	var data [8]byte
+	data[0] = csActivateBitTiming
+	binary.LittleEndian.PutUint16(data[1:], uint16(ms))
+	return m.send(data)
+}
+
+// StoreConfiguration requests the LSS slave in configuration state to
+// store its configured node-ID and bit timing into non-volatile memory.
+func (m *Master) StoreConfiguration() error {
+	return m.configure("store configuration", [8]byte{csStoreConfiguration})
+}
+
+// InquireIdentity retrieves the LSS address of the LSS slave in
+// configuration state.
+func (m *Master) InquireIdentity() (Address, error) {
+	var addr Address
+	for i, cs := range []byte{csInquireVendor, csInquireProduct, csInquireRevision, csInquireSerial} {
+		resp, err := m.query([8]byte{cs}, cs)
+		if err != nil {
+			return addr, fmt.Errorf("lss: could not inquire identity: %w", err)
+		}
+		addr.setField(i, binary.LittleEndian.Uint32(resp[1:]))
+	}
+	return addr, nil
+}
+
+// InquireNodeID retrieves the node-ID of the LSS slave in
+// configuration state.
+func (m *Master) InquireNodeID() (uint8, error) {
+	resp, err := m.query([8]byte{csInquireNodeID}, csInquireNodeID)
+	if err != nil {
+		return 0, fmt.Errorf("lss: could not inquire node-ID: %w", err)
+	}
+	return resp[1], nil
+}
+
+// Fastscan runs the LSS Fastscan protocol to discover the LSS address
+// of one of the non-configured LSS slaves on the bus.
+// On success, the discovered slave is left in configuration state.
+//
+// Fastscan returns ErrNoResponse when there is no non-configured
+// slave on the bus.
+func (m *Master) Fastscan() (Address, error) {
+	return m.FastscanKnown(Address{}, [4]bool{})
+}
+
+// FastscanKnown runs the LSS Fastscan protocol, skipping the binary
+// search for the parts of the LSS address that are already known.
+// known[i] reports whether the i-th field of addr (vendor-ID, product
+// code, revision number, serial number) is known.
+func (m *Master) FastscanKnown(addr Address, known [4]bool) (Address, error) {
+	ok, err := m.fastscan(0, fastscanConfirm, 0, 0)
+	if err != nil {
+		return Address{}, err
+	}
+	if !ok {
+		return Address{}, ErrNoResponse
+	}
+
+	for sub := 0; sub < 4; sub++ {
+		id := addr.field(sub)
+		if !known[sub] {
+			id = 0
+			for bit := 31; bit >= 0; bit-- {
+				ok, err := m.fastscan(id, uint8(bit), uint8(sub), uint8(sub))
+				if err != nil {
+					return Address{}, err
+				}
+				if !ok {
+					id |= 1 << bit
+				}
+			}
+		}
+		next := (sub + 1) % 4
+		ok, err := m.fastscan(id, 0, uint8(sub), uint8(next))
+		if err != nil {
+			return Address{}, err
+		}
+		if !ok {
+			return Address{}, fmt.Errorf(
+				"lss: fastscan could not confirm LSS address field %d (0x%08x): %w",
+				sub, id, ErrNoResponse,
+			)
+		}
+		addr.setField(sub, id)
+	}
+
+	return addr, nil
+}
+
+// fastscan sends a single fastscan request and reports whether any
+// slave answered it.
+func (m *Master) fastscan(id uint32, bit, sub, next uint8) (bool, error) {
+	data := request(csFastscan, id)
+	data[5] = bit
+	data[6] = sub
+	data[7] = next
+	_, err := m.query(data, csIdentifySlave)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrNoResponse):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (m *Master) configure(op string, data [8]byte) error {
+	resp, err := m.query(data, data[0])
+	if err != nil {
+		return fmt.Errorf("lss: could not %s: %w", op, err)
+	}
+	if resp[1] != 0 {
+		return &ConfigError{Op: op, Code: resp[1], Spec: resp[2]}
+	}
+	return nil
+}
+
+func (m *Master) send(data [8]byte) error {
+	_, err := m.bus.Send(canbus.Frame{ID: MasterID, Data: data[:], Kind: canbus.SFF})
+	if err != nil {
+		return fmt.Errorf("lss: could not send request: %w", err)
+	}
+	return nil
+}
+
+// query sends the provided request and waits for a slave response
+// with the provided command specifier.
+// Unrelated frames received in the meantime are discarded.
+func (m *Master) query(data [8]byte, cs byte) ([8]byte, error) {
+	var resp [8]byte
+	err := m.send(data)
+	if err != nil {
+		return resp, err
+	}
+
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	defer m.bus.SetRecvTimeout(0)
+
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return resp, ErrNoResponse
+		}
+		err = m.bus.SetRecvTimeout(left)
+		if err != nil {
+			return resp, err
+		}
+		msg, err := m.bus.Recv()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return resp, ErrNoResponse
+			}
+			return resp, fmt.Errorf("lss: could not receive response: %w", err)
+		}
+		if msg.Kind != canbus.SFF || msg.ID != SlaveID || len(msg.Data) != 8 || msg.Data[0] != cs {
+			continue
+		}
+		copy(resp[:], msg.Data)
+		return resp, nil
+	}
+}
+
+func request(cs byte, v uint32) [8]byte {
+	var data [8]byte
+	data[0] = cs
+	binary.LittleEndian.PutUint32(data[1:], v)
+	return data
+}
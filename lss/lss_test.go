@@ -0,0 +1,341 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lss
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"golang.org/x/sys/unix"
+)
+
+// slave is a minimal CiA 305 LSS slave.
+type slave struct {
+	addr   Address
+	mode   Mode
+	nodeID uint8
+	rate   uint8
+	stored bool
+	pos    int // fastscan LSS position
+	sel    int // number of switch state selective fields matched
+}
+
+func (s *slave) configured() bool { return s.nodeID != 0xff }
+
+func (s *slave) handle(req []byte) []byte {
+	resp := func(data ...byte) []byte {
+		var o [8]byte
+		copy(o[:], data)
+		return o[:]
+	}
+	u32 := func(cs byte, v uint32) []byte {
+		var o [8]byte
+		o[0] = cs
+		binary.LittleEndian.PutUint32(o[1:], v)
+		return o[:]
+	}
+	v := binary.LittleEndian.Uint32(req[1:5])
+
+	switch cs := req[0]; cs {
+	case csSwitchGlobal:
+		s.mode = Mode(req[1])
+	case csSwitchVendor, csSwitchProduct, csSwitchRevision, csSwitchSerial:
+		i := int(cs - csSwitchVendor)
+		switch {
+		case i != s.sel || s.addr.field(i) != v:
+			s.sel = 0
+		case i == 3:
+			s.sel = 0
+			s.mode = Configuration
+			return resp(csSwitchResponse)
+		default:
+			s.sel++
+		}
+	case csConfigureNodeID:
+		if s.mode != Configuration {
+			return nil
+		}
+		s.nodeID = req[1]
+		return resp(cs, 0)
+	case csConfigureBitTiming:
+		if s.mode != Configuration {
+			return nil
+		}
+		if req[1] != 0 {
+			return resp(cs, 0xff, 0x42)
+		}
+		if req[2] == 5 || req[2] > 9 {
+			return resp(cs, 1)
+		}
+		s.rate = req[2]
+		return resp(cs, 0)
+	case csStoreConfiguration:
+		if s.mode != Configuration {
+			return nil
+		}
+		s.stored = true
+		return resp(cs, 0)
+	case csInquireVendor, csInquireProduct, csInquireRevision, csInquireSerial:
+		if s.mode != Configuration {
+			return nil
+		}
+		return u32(cs, s.addr.field(int(cs-csInquireVendor)))
+	case csInquireNodeID:
+		if s.mode != Configuration {
+			return nil
+		}
+		return resp(cs, s.nodeID)
+	case csFastscan:
+		if s.mode != Waiting || s.configured() {
+			return nil
+		}
+		bit, sub, next := req[5], int(req[6]), int(req[7])
+		if bit == fastscanConfirm {
+			s.pos = 0
+			return resp(csIdentifySlave)
+		}
+		if sub != s.pos {
+			return nil
+		}
+		mask := uint32(0xffffffff) << bit
+		if (s.addr.field(sub)^v)&mask != 0 {
+			return nil
+		}
+		if bit == 0 {
+			s.pos = next
+			if next < sub {
+				s.mode = Configuration
+			}
+		}
+		return resp(csIdentifySlave)
+	}
+	return nil
+}
+
+// fakeBus dispatches the frames sent by the master to a set of LSS
+// slaves and queues their responses.
+type fakeBus struct {
+	slaves []*slave
+	queue  []canbus.Frame
+	noise  int // number of unrelated frames to inject before responses
+}
+
+func (b *fakeBus) Send(msg canbus.Frame) (int, error) {
+	if msg.ID != MasterID || len(msg.Data) != 8 {
+		return 0, fmt.Errorf("invalid LSS request: %+v", msg)
+	}
+	for i := 0; i < b.noise; i++ {
+		b.queue = append(b.queue, canbus.Frame{ID: 0x123, Data: []byte{csIdentifySlave}})
+	}
+	// identical frames sent simultaneously by several slaves show up
+	// as a single frame on the bus.
+	seen := make(map[string]bool)
+	for _, s := range b.slaves {
+		resp := s.handle(msg.Data)
+		if resp == nil || seen[string(resp)] {
+			continue
+		}
+		seen[string(resp)] = true
+		b.queue = append(b.queue, canbus.Frame{ID: SlaveID, Data: resp})
+	}
+	return len(msg.Data), nil
+}
+
+func (b *fakeBus) Recv() (canbus.Frame, error) {
+	if len(b.queue) == 0 {
+		return canbus.Frame{}, fmt.Errorf("recv: %w", os.ErrDeadlineExceeded)
+	}
+	msg := b.queue[0]
+	b.queue = b.queue[1:]
+	return msg, nil
+}
+
+func (b *fakeBus) Name() string                       { return "fake" }
+func (b *fakeBus) SetFilters([]unix.CanFilter) error  { return nil }
+func (b *fakeBus) SetRecvTimeout(time.Duration) error { return nil }
+func (b *fakeBus) Close() error                       { return nil }
+
+func newSlave(addr Address) *slave {
+	return &slave{addr: addr, nodeID: 0xff}
+}
+
+func TestFastscan(t *testing.T) {
+	slaves := []*slave{
+		newSlave(Address{0x00000319, 0x00001234, 0x00010002, 0xdeadbeef}),
+		newSlave(Address{0x00000319, 0x00001234, 0x00010002, 0x0000cafe}),
+		newSlave(Address{0x000000aa, 0xffffffff, 0x00000000, 0x80000001}),
+	}
+	// already configured node: must not take part in fastscan.
+	done := newSlave(Address{0x00000001, 0x00000001, 0x00000001, 0x00000001})
+	done.nodeID = 1
+
+	bus := &fakeBus{slaves: append(slaves, done), noise: 1}
+	m := &Master{bus: bus}
+
+	var found []Address
+	for id := uint8(10); ; id++ {
+		addr, err := m.Fastscan()
+		if errors.Is(err, ErrNoResponse) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not run fastscan: %+v", err)
+		}
+		found = append(found, addr)
+
+		err = m.ConfigureNodeID(id)
+		if err != nil {
+			t.Fatalf("could not configure node-ID: %+v", err)
+		}
+		err = m.SwitchStateGlobal(Waiting)
+		if err != nil {
+			t.Fatalf("could not switch state global: %+v", err)
+		}
+	}
+
+	// fastscan finds the smallest LSS address first.
+	want := []Address{slaves[2].addr, slaves[1].addr, slaves[0].addr}
+	if len(found) != len(want) {
+		t.Fatalf("invalid number of discovered slaves: got=%d, want=%d", len(found), len(want))
+	}
+	for i := range want {
+		if found[i] != want[i] {
+			t.Fatalf("invalid slave %d:\ngot= %v\nwant=%v", i, found[i], want[i])
+		}
+	}
+	for i, id := range []uint8{12, 11, 10} {
+		if got := slaves[i].nodeID; got != id {
+			t.Fatalf("invalid node-ID for slave %d: got=%d, want=%d", i, got, id)
+		}
+	}
+}
+
+func TestFastscanKnown(t *testing.T) {
+	s := newSlave(Address{0x00000319, 0x00001234, 0x00010002, 0xdeadbeef})
+	bus := &fakeBus{slaves: []*slave{s}}
+	m := &Master{bus: bus}
+
+	addr, err := m.FastscanKnown(
+		Address{VendorID: 0x319, ProductCode: 0x1234},
+		[4]bool{true, true, false, false},
+	)
+	if err != nil {
+		t.Fatalf("could not run fastscan: %+v", err)
+	}
+	if addr != s.addr {
+		t.Fatalf("invalid LSS address:\ngot= %v\nwant=%v", addr, s.addr)
+	}
+	if s.mode != Configuration {
+		t.Fatalf("slave not in configuration state")
+	}
+
+	_, err = m.FastscanKnown(
+		Address{VendorID: 0x42},
+		[4]bool{true, false, false, false},
+	)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestSelective(t *testing.T) {
+	s1 := newSlave(Address{1, 2, 3, 4})
+	s2 := newSlave(Address{1, 2, 3, 5})
+	bus := &fakeBus{slaves: []*slave{s1, s2}, noise: 2}
+	m := &Master{bus: bus}
+
+	err := m.SwitchStateSelective(s2.addr)
+	if err != nil {
+		t.Fatalf("could not switch state selective: %+v", err)
+	}
+	if s1.mode != Waiting || s2.mode != Configuration {
+		t.Fatalf("invalid modes: s1=%d, s2=%d", s1.mode, s2.mode)
+	}
+
+	addr, err := m.InquireIdentity()
+	if err != nil {
+		t.Fatalf("could not inquire identity: %+v", err)
+	}
+	if addr != s2.addr {
+		t.Fatalf("invalid identity:\ngot= %v\nwant=%v", addr, s2.addr)
+	}
+
+	err = m.ConfigureNodeID(0)
+	if !errors.Is(err, ErrInvalidNodeID) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, ErrInvalidNodeID)
+	}
+
+	err = m.ConfigureNodeID(42)
+	if err != nil {
+		t.Fatalf("could not configure node-ID: %+v", err)
+	}
+
+	id, err := m.InquireNodeID()
+	if err != nil {
+		t.Fatalf("could not inquire node-ID: %+v", err)
+	}
+	if id != 42 {
+		t.Fatalf("invalid node-ID: got=%d, want=%d", id, 42)
+	}
+
+	err = m.ConfigureBitTiming(Bitrate250k)
+	if err != nil {
+		t.Fatalf("could not configure bit timing: %+v", err)
+	}
+	if s2.rate != uint8(Bitrate250k) {
+		t.Fatalf("invalid bit timing: got=%d, want=%d", s2.rate, Bitrate250k)
+	}
+
+	err = m.ActivateBitTiming(50 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("could not activate bit timing: %+v", err)
+	}
+
+	err = m.StoreConfiguration()
+	if err != nil {
+		t.Fatalf("could not store configuration: %+v", err)
+	}
+	if !s2.stored || s1.stored {
+		t.Fatalf("invalid stored configuration: s1=%v, s2=%v", s1.stored, s2.stored)
+	}
+
+	err = m.SwitchStateGlobal(Waiting)
+	if err != nil {
+		t.Fatalf("could not switch state global: %+v", err)
+	}
+
+	err = m.SwitchStateSelective(Address{1, 2, 3, 6})
+	if !errors.Is(err, ErrNoResponse) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, ErrNoResponse)
+	}
+}
+
+func TestConfigError(t *testing.T) {
+	s := newSlave(Address{1, 2, 3, 4})
+	s.mode = Configuration
+	m := &Master{bus: &fakeBus{slaves: []*slave{s}}}
+
+	for _, tc := range []struct {
+		table, index uint8
+		want         string
+	}{
+		{0, 5, "lss: configure bit timing failed: error code 1"},
+		{128, 0, "lss: configure bit timing failed: implementation specific error 0x42"},
+	} {
+		err := m.ConfigureBitTimingTable(tc.table, tc.index)
+		var cerr *ConfigError
+		if !errors.As(err, &cerr) {
+			t.Fatalf("invalid error type: %T", err)
+		}
+		if got, want := err.Error(), tc.want; got != want {
+			t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+		}
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -54,6 +56,65 @@ func (sck *Socket) SetFilters(filters []unix.CanFilter) error {
 	return nil
 }
 
+// SetErrFilter sets the CAN_RAW_ERR_FILTER option on the underlying
+// socket, so that error frames of the error classes selected by the
+// provided mask are received.
+// Use unix.CAN_ERR_MASK to receive all error frames.
+func (sck *Socket) SetErrFilter(mask uint32) error {
+	err := unix.SetsockoptInt(sck.dev.fd, unix.SOL_CAN_RAW, unix.CAN_RAW_ERR_FILTER, int(mask))
+	if err != nil {
+		return fmt.Errorf("could not set CAN error filter: %w", err)
+	}
+
+	return nil
+}
+
+// SetLoopback sets the CAN_RAW_LOOPBACK option on the underlying socket.
+// When enabled, which is the default, the frames sent by the socket are
+// also received by the other sockets of the host bound to the same
+// interface.
+func (sck *Socket) SetLoopback(enable bool) error {
+	err := unix.SetsockoptInt(sck.dev.fd, unix.SOL_CAN_RAW, unix.CAN_RAW_LOOPBACK, boolInt(enable))
+	if err != nil {
+		return fmt.Errorf("could not set CAN loopback: %w", err)
+	}
+
+	return nil
+}
+
+// SetRecvOwnMsgs sets the CAN_RAW_RECV_OWN_MSGS option on the underlying
+// socket. When enabled, the socket receives the frames it sends, once
+// looped back. It is disabled by default.
+func (sck *Socket) SetRecvOwnMsgs(enable bool) error {
+	err := unix.SetsockoptInt(sck.dev.fd, unix.SOL_CAN_RAW, unix.CAN_RAW_RECV_OWN_MSGS, boolInt(enable))
+	if err != nil {
+		return fmt.Errorf("could not set CAN own messages reception: %w", err)
+	}
+
+	return nil
+}
+
+func boolInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// SetRecvTimeout sets the SO_RCVTIMEO option on the underlying socket.
+// Once the timeout has elapsed without any frame being received, Recv
+// returns an error wrapping os.ErrDeadlineExceeded.
+// A zero duration disables the timeout.
+func (sck *Socket) SetRecvTimeout(timeout time.Duration) error {
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	err := unix.SetsockoptTimeval(sck.dev.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	if err != nil {
+		return fmt.Errorf("could not set CAN recv timeout: %w", err)
+	}
+
+	return nil
+}
+
 // Close closes the CAN bus socket.
 func (sck *Socket) Close() error {
 	return unix.Close(sck.dev.fd)
@@ -76,7 +137,9 @@ func (sck *Socket) Bind(addr string) error {
 	return unix.Bind(sck.dev.fd, sck.addr)
 }
 
-// Send sends the provided frame on the CAN bus.
+// Send sends the provided frame on the CAN bus, and returns the number
+// of bytes written to the socket: the size of a struct can_frame, 16
+// bytes, on success.
 func (sck *Socket) Send(msg Frame) (int, error) {
 	if len(msg.Data) > 8 {
 		return 0, errDataTooBig
@@ -109,6 +172,9 @@ func (sck *Socket) Recv() (msg Frame, err error) {
 	var frame [frameSize]byte
 	n, err := io.ReadFull(sck.dev, frame[:])
 	if err != nil {
+		if errors.Is(err, unix.EAGAIN) {
+			err = fmt.Errorf("canbus: recv timeout: %w", os.ErrDeadlineExceeded)
+		}
 		return msg, err
 	}
 
@@ -143,7 +209,11 @@ type device struct {
 }
 
 func (d device) Read(data []byte) (int, error) {
-	return unix.Read(d.fd, data)
+	n, err := unix.Read(d.fd, data)
+	if n < 0 {
+		n = 0
+	}
+	return n, err
 }
 
 func (d device) Write(data []byte) (int, error) {
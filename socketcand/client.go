@@ -0,0 +1,340 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package socketcand
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"golang.org/x/sys/unix"
+)
+
+// ackTimeout is the duration a client waits for the server to
+// acknowledge a command.
+const ackTimeout = 5 * time.Second
+
+// Conn is a client connection to a bus of a socketcand server.
+//
+// A Conn exchanges frames with the bus in raw mode, as a
+// canbus.Transport.
+type Conn struct {
+	conn net.Conn
+	name string
+
+	wmu sync.Mutex // serializes writes
+
+	rmu     sync.Mutex // serializes reads
+	r       *bufio.Reader
+	buf     []byte         // message being read, when a read timed out
+	pending []canbus.Frame // frames received while waiting for an acknowledgment
+	timeout time.Duration
+	filters []unix.CanFilter // nil when all frames are received
+}
+
+// Dial connects to the socketcand server at addr, opens its bus, and
+// switches to raw mode.
+func Dial(addr, bus string) (*Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("socketcand: could not dial %q: %w", addr, err)
+	}
+	c, err := newConn(conn, bus)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newConn(conn net.Conn, bus string) (*Conn, error) {
+	c := &Conn{
+		conn: conn,
+		name: bus,
+		r:    bufio.NewReader(conn),
+	}
+
+	// the server greets its clients with "< hi >".
+	conn.SetReadDeadline(time.Now().Add(ackTimeout))
+	fs, err := c.next()
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("socketcand: could not read greeting: %w", err)
+	case fs[0] != "hi":
+		return nil, fmt.Errorf("socketcand: invalid greeting %q", strings.Join(fs, " "))
+	}
+
+	err = c.command("< open " + bus + " >")
+	if err != nil {
+		return nil, fmt.Errorf("socketcand: could not open bus %q: %w", bus, err)
+	}
+	err = c.command("< rawmode >")
+	if err != nil {
+		return nil, fmt.Errorf("socketcand: could not switch to raw mode: %w", err)
+	}
+	return c, nil
+}
+
+// Name returns the name of the bus.
+func (c *Conn) Name() string {
+	return c.name
+}
+
+// SetFilters applies the provided filters to the frames received, with
+// the semantics of the CAN_RAW_FILTER socket option.
+// Error frames are not filtered.
+func (c *Conn) SetFilters(filters []unix.CanFilter) error {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	c.filters = append([]unix.CanFilter{}, filters...)
+	return nil
+}
+
+// SetRecvTimeout sets the duration Recv waits for a frame.
+// Once the timeout has elapsed without any frame being received, Recv
+// returns an error wrapping os.ErrDeadlineExceeded.
+// A zero duration disables the timeout.
+func (c *Conn) SetRecvTimeout(timeout time.Duration) error {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	c.timeout = timeout
+	return nil
+}
+
+// Send sends the provided frame on the bus, and returns the number of
+// data bytes sent.
+// Only standard and extended data frames may be sent.
+func (c *Conn) Send(msg canbus.Frame) (int, error) {
+	if len(msg.Data) > 8 {
+		return 0, errDataTooBig
+	}
+	switch msg.Kind {
+	case canbus.SFF, canbus.EFF:
+	default:
+		return 0, fmt.Errorf("socketcand: unsupported %v frame", msg.Kind)
+	}
+
+	err := c.write("< send " + formatID(msg.ID, msg.Kind) + " " + formatData(msg.Data) + " >")
+	if err != nil {
+		return 0, err
+	}
+	return len(msg.Data), nil
+}
+
+// Recv receives a frame from the bus.
+// Error reports of the server are returned as errors.
+func (c *Conn) Recv() (canbus.Frame, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	var deadline time.Time
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	c.conn.SetReadDeadline(deadline)
+
+	for {
+		if len(c.pending) > 0 {
+			frame := c.pending[0]
+			c.pending = c.pending[1:]
+			if c.match(frame) {
+				return frame, nil
+			}
+			continue
+		}
+
+		fs, err := c.next()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = fmt.Errorf("socketcand: recv timeout: %w", err)
+			}
+			return canbus.Frame{}, err
+		}
+		frame, ok, err := handle(fs)
+		if err != nil {
+			return canbus.Frame{}, err
+		}
+		if ok && c.match(frame) {
+			return frame, nil
+		}
+	}
+}
+
+// RawMode switches the connection to raw mode, where all the frames of
+// the bus are received.
+func (c *Conn) RawMode() error {
+	err := c.command("< rawmode >")
+	if err != nil {
+		return fmt.Errorf("socketcand: could not switch to raw mode: %w", err)
+	}
+	return nil
+}
+
+// BCMMode switches the connection to BCM mode, where only the frames of
+// the subscriptions are received.
+func (c *Conn) BCMMode() error {
+	err := c.command("< bcmmode >")
+	if err != nil {
+		return fmt.Errorf("socketcand: could not switch to BCM mode: %w", err)
+	}
+	return nil
+}
+
+// Subscribe subscribes to the frames with the provided identifier, in
+// BCM mode. A non-zero interval throttles the frames received.
+func (c *Conn) Subscribe(id uint32, interval time.Duration) error {
+	return c.write("< subscribe " + formatDuration(interval) + " " + formatID(id, kindOf(id)) + " >")
+}
+
+// SubscribeChanges subscribes to the frames with the provided identifier
+// whose payload bytes selected by the mask changed, in BCM mode.
+// A non-zero interval throttles the frames received.
+func (c *Conn) SubscribeChanges(id uint32, interval time.Duration, mask []byte) error {
+	if len(mask) > 8 {
+		return errDataTooBig
+	}
+	return c.write("< filter " + formatDuration(interval) + " " + formatID(id, kindOf(id)) + " " + formatData(mask) + " >")
+}
+
+// Unsubscribe cancels the subscription to the frames with the provided
+// identifier, in BCM mode.
+func (c *Conn) Unsubscribe(id uint32) error {
+	return c.write("< unsubscribe " + formatID(id, kindOf(id)) + " >")
+}
+
+// AddCyclic makes the server send the provided frame on the bus at the
+// provided interval, in BCM mode.
+func (c *Conn) AddCyclic(msg canbus.Frame, interval time.Duration) error {
+	if len(msg.Data) > 8 {
+		return errDataTooBig
+	}
+	return c.write("< add " + formatDuration(interval) + " " + formatID(msg.ID, msg.Kind) + " " + formatData(msg.Data) + " >")
+}
+
+// UpdateCyclic updates the payload of a frame sent cyclically, in BCM
+// mode.
+func (c *Conn) UpdateCyclic(msg canbus.Frame) error {
+	if len(msg.Data) > 8 {
+		return errDataTooBig
+	}
+	return c.write("< update " + formatID(msg.ID, msg.Kind) + " " + formatData(msg.Data) + " >")
+}
+
+// DeleteCyclic stops sending the frame with the provided identifier
+// cyclically, in BCM mode.
+func (c *Conn) DeleteCyclic(id uint32) error {
+	return c.write("< delete " + formatID(id, kindOf(id)) + " >")
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+var (
+	_ canbus.Transport = (*Conn)(nil)
+)
+
+func (c *Conn) write(msg string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	_, err := io.WriteString(c.conn, msg)
+	if err != nil {
+		return fmt.Errorf("socketcand: could not send %q: %w", msg, err)
+	}
+	return nil
+}
+
+// command sends a command, and waits for its acknowledgment.
+func (c *Conn) command(cmd string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	_, err := io.WriteString(c.conn, cmd)
+	if err != nil {
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(ackTimeout))
+	for {
+		fs, err := c.next()
+		if err != nil {
+			return err
+		}
+		if fs[0] == "ok" {
+			return nil
+		}
+		frame, ok, err := handle(fs)
+		if err != nil {
+			return err
+		}
+		if ok {
+			c.pending = append(c.pending, frame)
+		}
+	}
+}
+
+// next returns the fields of the next message.
+// Messages interrupted by a timeout are completed by the next call.
+func (c *Conn) next() ([]string, error) {
+	for {
+		b, err := c.r.ReadSlice('>')
+		c.buf = append(c.buf, b...)
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		msg := string(c.buf)
+		c.buf = c.buf[:0]
+		return fields(msg)
+	}
+}
+
+// match reports whether a received frame passes the filters.
+func (c *Conn) match(frame canbus.Frame) bool {
+	if c.filters == nil || frame.Kind == canbus.ERR {
+		return true
+	}
+	id := frame.ID
+	if frame.Kind == canbus.EFF {
+		id |= unix.CAN_EFF_FLAG
+	}
+	for _, f := range c.filters {
+		inv := f.Id&unix.CAN_INV_FILTER != 0
+		if (id&f.Mask == f.Id&^unix.CAN_INV_FILTER&f.Mask) != inv {
+			return true
+		}
+	}
+	return false
+}
+
+// handle returns the frame of a received message, if any, or the error it
+// reports.
+func handle(fs []string) (canbus.Frame, bool, error) {
+	switch {
+	case fs[0] == "frame" || isErrFrame(fs):
+		frame, _, err := parseFrame(fs)
+		if err != nil {
+			return frame, false, fmt.Errorf("socketcand: invalid frame %q: %w", strings.Join(fs, " "), err)
+		}
+		return frame, true, nil
+	case fs[0] == "error":
+		return canbus.Frame{}, false, fmt.Errorf("socketcand: server error: %s", strings.Join(fs[1:], " "))
+	}
+	return canbus.Frame{}, false, nil
+}
@@ -0,0 +1,343 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package socketcand
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"golang.org/x/sys/unix"
+)
+
+// script is a server exchanging messages with a client, as scripted by
+// its steps: lines starting with "> " are written to the client, and the
+// other ones are expected from the client.
+type script struct {
+	ln    net.Listener
+	steps chan string
+	errc  chan error
+}
+
+func newScript(t *testing.T) *script {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %+v", err)
+	}
+	s := &script{
+		ln:    ln,
+		steps: make(chan string, 64),
+		errc:  make(chan error, 1),
+	}
+	go func() {
+		s.errc <- s.run()
+	}()
+	return s
+}
+
+func (s *script) run() error {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for step := range s.steps {
+		if msg := strings.TrimPrefix(step, "> "); msg != step {
+			_, err = conn.Write([]byte(msg))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		got, err := r.ReadString('>')
+		if err != nil {
+			return fmt.Errorf("could not read %q: %w", step, err)
+		}
+		if got != step {
+			return fmt.Errorf("invalid message: got=%q, want=%q", got, step)
+		}
+	}
+	return nil
+}
+
+func (s *script) do(steps ...string) {
+	for _, step := range steps {
+		s.steps <- step
+	}
+}
+
+// wait waits for the scripted steps to be played.
+func (s *script) wait(t *testing.T) {
+	t.Helper()
+	close(s.steps)
+	err := <-s.errc
+	if err != nil {
+		t.Fatalf("server error: %+v", err)
+	}
+	s.ln.Close()
+}
+
+func TestConn(t *testing.T) {
+	srv := newScript(t)
+	srv.do(
+		"> < hi >",
+		"< open can0 >",
+		"> < ok >",
+		"< rawmode >",
+		// frames received before the acknowledgment are not lost.
+		"> < frame 123 1661871060.000001 1122 >< ok >",
+		"> < frame 1FFFFFFF 1661871060.000002  >",
+		"> < error 004 1661871060.000003 >",
+		"> < frame 00000042 1661871060.000004 AABBCCDDEEFF0011 >",
+		"< send 321 3 01 02 03 >",
+		"< send 12345678 0 >",
+		"> < error could not send frame >",
+	)
+
+	conn, err := Dial(srv.ln.Addr().String(), "can0")
+	if err != nil {
+		t.Fatalf("could not dial: %+v", err)
+	}
+	defer conn.Close()
+
+	if got, want := conn.Name(), "can0"; got != want {
+		t.Fatalf("invalid name: got=%q, want=%q", got, want)
+	}
+
+	for _, want := range []canbus.Frame{
+		{ID: 0x123, Kind: canbus.SFF, Data: []byte{0x11, 0x22}},
+		{ID: 0x1fffffff, Kind: canbus.EFF, Data: []byte{}},
+		{ID: 0x004, Kind: canbus.ERR},
+		{ID: 0x42, Kind: canbus.EFF, Data: []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x00, 0x11}},
+	} {
+		got, err := conn.Recv()
+		if err != nil {
+			t.Fatalf("could not receive frame: %+v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid frame: got=%+v, want=%+v", got, want)
+		}
+	}
+
+	for _, frame := range []canbus.Frame{
+		{ID: 0x321, Kind: canbus.SFF, Data: []byte{1, 2, 3}},
+		{ID: 0x12345678, Kind: canbus.EFF},
+	} {
+		_, err = conn.Send(frame)
+		if err != nil {
+			t.Fatalf("could not send frame: %+v", err)
+		}
+	}
+
+	_, err = conn.Recv()
+	if got, want := fmt.Sprint(err), "socketcand: server error: could not send frame"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	_, err = conn.Send(canbus.Frame{ID: 0x1, Kind: canbus.RTR})
+	if got, want := fmt.Sprint(err), "socketcand: unsupported RTR frame"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+	_, err = conn.Send(canbus.Frame{ID: 0x1, Data: make([]byte, 9)})
+	if !errors.Is(err, errDataTooBig) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, errDataTooBig)
+	}
+
+	srv.wait(t)
+}
+
+func TestConnBCM(t *testing.T) {
+	srv := newScript(t)
+	srv.do(
+		"> < hi >",
+		"< open vcan0 >",
+		"> < ok >",
+		"< rawmode >",
+		"> < ok >",
+		"< bcmmode >",
+		"> < ok >",
+		"< subscribe 0 100000 123 >",
+		"< subscribe 0 0 00012345 >",
+		"< add 1 500000 7FF 2 11 22 >",
+		"< update 7FF 2 33 44 >",
+		"< delete 7FF >",
+		"< unsubscribe 123 >",
+		"> < frame 123 1661871060.000001 01 >",
+		"< rawmode >",
+		"> < ok >",
+	)
+
+	conn, err := Dial(srv.ln.Addr().String(), "vcan0")
+	if err != nil {
+		t.Fatalf("could not dial: %+v", err)
+	}
+	defer conn.Close()
+
+	err = conn.BCMMode()
+	if err != nil {
+		t.Fatalf("could not switch to BCM mode: %+v", err)
+	}
+	for _, f := range []func() error{
+		func() error { return conn.Subscribe(0x123, 100*time.Millisecond) },
+		func() error { return conn.Subscribe(0x12345, 0) },
+		func() error {
+			return conn.AddCyclic(canbus.Frame{ID: 0x7ff, Data: []byte{0x11, 0x22}}, 1500*time.Millisecond)
+		},
+		func() error { return conn.UpdateCyclic(canbus.Frame{ID: 0x7ff, Data: []byte{0x33, 0x44}}) },
+		func() error { return conn.DeleteCyclic(0x7ff) },
+		func() error { return conn.Unsubscribe(0x123) },
+	} {
+		err := f()
+		if err != nil {
+			t.Fatalf("could not send command: %+v", err)
+		}
+	}
+	err = conn.RawMode()
+	if err != nil {
+		t.Fatalf("could not switch to raw mode: %+v", err)
+	}
+	got, err := conn.Recv()
+	if err != nil {
+		t.Fatalf("could not receive frame: %+v", err)
+	}
+	if want := (canbus.Frame{ID: 0x123, Data: []byte{0x01}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frame: got=%+v, want=%+v", got, want)
+	}
+
+	srv.wait(t)
+}
+
+func TestConnFilters(t *testing.T) {
+	srv := newScript(t)
+	srv.do(
+		"> < hi >",
+		"< open can0 >",
+		"> < ok >",
+		"< rawmode >",
+		"> < ok >",
+		"> < frame 100 1.000000 >",
+		"> < frame 101 2.000000 >",
+		"> < frame 00000101 3.000000 >",
+		"> < error 020 4.000000 >",
+		"> < frame 123 5.000000 >",
+		"> < frame 456 6.000000 >",
+	)
+
+	conn, err := Dial(srv.ln.Addr().String(), "can0")
+	if err != nil {
+		t.Fatalf("could not dial: %+v", err)
+	}
+	defer conn.Close()
+
+	err = conn.SetFilters([]unix.CanFilter{
+		{Id: 0x101, Mask: unix.CAN_SFF_MASK | unix.CAN_EFF_FLAG},
+		{Id: 0x100 | unix.CAN_INV_FILTER, Mask: 0x700},
+	})
+	if err != nil {
+		t.Fatalf("could not set filters: %+v", err)
+	}
+
+	for _, want := range []string{"SFF:101", "ERR:020", "SFF:456"} {
+		frame, err := conn.Recv()
+		if err != nil {
+			t.Fatalf("could not receive frame: %+v", err)
+		}
+		if got := fmt.Sprintf("%v:%03x", frame.Kind, frame.ID); got != want {
+			t.Fatalf("invalid frame: got=%q, want=%q", got, want)
+		}
+	}
+
+	srv.wait(t)
+}
+
+func TestConnTimeout(t *testing.T) {
+	srv := newScript(t)
+	srv.do(
+		"> < hi >",
+		"< open can0 >",
+		"> < ok >",
+		"< rawmode >",
+		"> < ok >",
+		"> < frame 7",
+	)
+
+	conn, err := Dial(srv.ln.Addr().String(), "can0")
+	if err != nil {
+		t.Fatalf("could not dial: %+v", err)
+	}
+	defer conn.Close()
+
+	err = conn.SetRecvTimeout(20 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("could not set timeout: %+v", err)
+	}
+	_, err = conn.Recv()
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, os.ErrDeadlineExceeded)
+	}
+
+	// the message interrupted by the timeout is completed.
+	srv.do("> 00 1.000000 42 >")
+	err = conn.SetRecvTimeout(0)
+	if err != nil {
+		t.Fatalf("could not set timeout: %+v", err)
+	}
+	frame, err := conn.Recv()
+	if err != nil {
+		t.Fatalf("could not receive frame: %+v", err)
+	}
+	if want := (canbus.Frame{ID: 0x700, Data: []byte{0x42}}); !reflect.DeepEqual(frame, want) {
+		t.Fatalf("invalid frame: got=%+v, want=%+v", frame, want)
+	}
+
+	srv.wait(t)
+}
+
+func TestDialErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		steps []string
+		err   string
+	}{
+		{
+			name:  "greeting",
+			steps: []string{"> < hello >"},
+			err:   `socketcand: invalid greeting "hello"`,
+		},
+		{
+			name:  "open",
+			steps: []string{"> < hi >", "< open can0 >", "> < error could not bind >"},
+			err:   `socketcand: could not open bus "can0": socketcand: server error: could not bind`,
+		},
+		{
+			name:  "invalid",
+			steps: []string{"> < hi >", "< open can0 >", "> < frame 12G 1.000000 >"},
+			err:   `socketcand: could not open bus "can0": socketcand: invalid frame "frame 12G 1.000000": invalid identifier "12G"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newScript(t)
+			srv.do(tc.steps...)
+			conn, err := Dial(srv.ln.Addr().String(), "can0")
+			if err == nil {
+				conn.Close()
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+			srv.wait(t)
+		})
+	}
+}
@@ -0,0 +1,625 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package socketcand
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DiscoveryPort is the UDP port the discovery beacons are broadcast to.
+	DiscoveryPort = 42000
+
+	// BeaconInterval is the interval between two discovery beacons.
+	BeaconInterval = 2 * time.Second
+
+	// pollTimeout is the receive timeout of the transports of the server,
+	// bounding the time it takes to notice a client has left.
+	pollTimeout = 100 * time.Millisecond
+
+	// maxMsgSize is the maximum size of the messages sent by clients.
+	maxMsgSize = 1024
+)
+
+// ErrServerClosed is returned by Serve and Announce once the server has
+// been closed.
+var ErrServerClosed = errors.New("socketcand: server closed")
+
+// Server serves local CAN buses to socketcand clients.
+//
+// Each client opening a bus gets its own transport, a CAN socket by
+// default, so that its subscriptions and cyclic transmissions do not
+// interfere with the ones of the other clients, and that it receives the
+// frames they send.
+// Clients start in BCM mode once their bus is opened, as with
+// socketcand.
+type Server struct {
+	// Buses are the names of the interfaces clients may open.
+	Buses []string
+
+	// Name is the name of the server in its discovery beacons.
+	// It defaults to the host name.
+	Name string
+
+	// Open opens a new transport to the named bus, for a client.
+	// It defaults to opening a CAN socket bound to the interface, and
+	// receiving all the error frames.
+	// The receive timeout of the transport is set by the server.
+	Open func(name string) (canbus.Transport, error)
+
+	now func() time.Time
+
+	mu      sync.Mutex
+	lns     map[net.Listener]struct{}
+	clients map[*client]struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+	closed  bool
+}
+
+// NewServer returns a server serving the provided interfaces.
+func NewServer(buses ...string) *Server {
+	return &Server{
+		Buses:   buses,
+		Open:    open,
+		now:     time.Now,
+		lns:     make(map[net.Listener]struct{}),
+		clients: make(map[*client]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func open(name string) (canbus.Transport, error) {
+	sck, err := canbus.New()
+	if err != nil {
+		return nil, err
+	}
+	err = sck.Bind(name)
+	if err != nil {
+		sck.Close()
+		return nil, err
+	}
+	err = sck.SetErrFilter(unix.CAN_ERR_MASK)
+	if err != nil {
+		sck.Close()
+		return nil, err
+	}
+	return sck, nil
+}
+
+// ListenAndServe listens on the TCP address addr, and serves the
+// clients connecting to it until the server is closed.
+func (srv *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("socketcand: could not listen on %q: %w", addr, err)
+	}
+	return srv.Serve(ln)
+}
+
+// Serve serves the clients connecting to the listener until the server
+// is closed. The listener is closed once Serve returns.
+func (srv *Server) Serve(ln net.Listener) error {
+	defer ln.Close()
+
+	srv.mu.Lock()
+	if srv.closed {
+		srv.mu.Unlock()
+		return ErrServerClosed
+	}
+	srv.lns[ln] = struct{}{}
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.lns, ln)
+		srv.mu.Unlock()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			srv.mu.Lock()
+			closed := srv.closed
+			srv.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return fmt.Errorf("socketcand: could not accept connection: %w", err)
+		}
+
+		c := &client{
+			srv:     srv,
+			conn:    conn,
+			subs:    make(map[uint32]*subscription),
+			cyclics: make(map[uint32]*cyclic),
+		}
+		srv.mu.Lock()
+		if srv.closed {
+			srv.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		srv.clients[c] = struct{}{}
+		srv.wg.Add(1)
+		srv.mu.Unlock()
+
+		go func() {
+			defer srv.wg.Done()
+			c.serve()
+
+			srv.mu.Lock()
+			delete(srv.clients, c)
+			srv.mu.Unlock()
+		}()
+	}
+}
+
+// Announce broadcasts discovery beacons advertising the server at the
+// provided URL, such as "can://192.168.1.2:29536", until the server is
+// closed.
+func (srv *Server) Announce(url string) error {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4bcast, Port: DiscoveryPort})
+	if err != nil {
+		return fmt.Errorf("socketcand: could not open beacon socket: %w", err)
+	}
+	defer conn.Close()
+	return srv.announce(conn, url, BeaconInterval)
+}
+
+func (srv *Server) announce(w io.Writer, url string, interval time.Duration) error {
+	msg, err := srv.beacon(url)
+	if err != nil {
+		return err
+	}
+	tck := time.NewTicker(interval)
+	defer tck.Stop()
+	for {
+		_, err = w.Write(msg)
+		if err != nil {
+			return fmt.Errorf("socketcand: could not send beacon: %w", err)
+		}
+		select {
+		case <-srv.done:
+			return ErrServerClosed
+		case <-tck.C:
+		}
+	}
+}
+
+// beacon returns the discovery beacon advertising the server at the
+// provided URL.
+func (srv *Server) beacon(url string) ([]byte, error) {
+	type bus struct {
+		Name string `xml:"name,attr"`
+	}
+	beacon := struct {
+		XMLName     xml.Name `xml:"CANBeacon"`
+		Name        string   `xml:"name,attr"`
+		Type        string   `xml:"type,attr"`
+		Description string   `xml:"description,attr"`
+		URL         string   `xml:"URL"`
+		Buses       []bus    `xml:"Bus"`
+	}{
+		Name:        srv.Name,
+		Type:        "SocketCAN",
+		Description: "socketcand",
+		URL:         url,
+	}
+	if beacon.Name == "" {
+		name, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("socketcand: could not get host name: %w", err)
+		}
+		beacon.Name = name
+	}
+	for _, name := range srv.Buses {
+		beacon.Buses = append(beacon.Buses, bus{Name: name})
+	}
+	return xml.Marshal(beacon)
+}
+
+// Close closes the listeners and the client connections of the server,
+// and stops its announcements.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	if srv.closed {
+		srv.mu.Unlock()
+		return nil
+	}
+	srv.closed = true
+	close(srv.done)
+	var err error
+	for ln := range srv.lns {
+		if e := ln.Close(); e != nil && err == nil {
+			err = fmt.Errorf("socketcand: could not close listener: %w", e)
+		}
+	}
+	for c := range srv.clients {
+		c.conn.Close()
+	}
+	srv.mu.Unlock()
+
+	srv.wg.Wait()
+	return err
+}
+
+// client modes.
+const (
+	noBus = iota
+	bcmMode
+	rawMode
+)
+
+// client is a client connection of a server.
+type client struct {
+	srv  *Server
+	conn net.Conn
+	wmu  sync.Mutex // serializes writes
+
+	bus    canbus.Transport
+	wg     sync.WaitGroup
+	closed uint32
+
+	mu      sync.Mutex
+	mode    int
+	subs    map[uint32]*subscription // subscriptions by raw identifier
+	cyclics map[uint32]*cyclic       // cyclic transmissions by raw identifier
+}
+
+// subscription selects the frames forwarded to a client in BCM mode.
+type subscription struct {
+	interval time.Duration // minimum interval between two frames
+	last     time.Time     // time of the last frame forwarded
+
+	mask []byte // selection of the payload bytes whose changes are forwarded
+	data []byte // selected payload bytes of the last frame forwarded
+}
+
+// pass reports whether a frame received at time now is forwarded.
+func (sub *subscription) pass(frame canbus.Frame, now time.Time) bool {
+	var data []byte
+	if sub.mask != nil {
+		data = make([]byte, len(frame.Data))
+		for i := range data {
+			if i < len(sub.mask) {
+				data[i] = frame.Data[i] & sub.mask[i]
+			}
+		}
+		if sub.data != nil && bytes.Equal(data, sub.data) {
+			return false
+		}
+	}
+	if sub.interval > 0 && !sub.last.IsZero() && now.Sub(sub.last) < sub.interval {
+		return false
+	}
+	sub.last = now
+	sub.data = data
+	return true
+}
+
+// cyclic is a frame sent cyclically on behalf of a client.
+type cyclic struct {
+	frame canbus.Frame
+	stop  chan struct{}
+}
+
+func rawID(id uint32, kind canbus.Kind) uint32 {
+	if kind == canbus.EFF {
+		return id | unix.CAN_EFF_FLAG
+	}
+	return id
+}
+
+func (c *client) serve() {
+	defer c.close()
+
+	c.write("< hi >")
+	r := bufio.NewReaderSize(c.conn, maxMsgSize)
+	for {
+		msg, err := r.ReadSlice('>')
+		if err != nil {
+			// clients sending messages larger than maxMsgSize are
+			// disconnected as well.
+			return
+		}
+		fs, err := fields(string(msg))
+		if err != nil {
+			c.write("< error invalid message >")
+			continue
+		}
+		err = c.handle(fs)
+		if err != nil {
+			c.write("< error " + err.Error() + " >")
+		}
+	}
+}
+
+func (c *client) handle(fs []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case fs[0] == "echo" && len(fs) == 1:
+		c.write("< echo >")
+		return nil
+
+	case c.mode == noBus:
+		if fs[0] != "open" || len(fs) != 2 {
+			return fmt.Errorf("no bus opened")
+		}
+		name := fs[1]
+		ok := false
+		for _, b := range c.srv.Buses {
+			ok = ok || b == name
+		}
+		if !ok {
+			return fmt.Errorf("unknown bus %s", name)
+		}
+		b, err := c.srv.Open(name)
+		if err != nil {
+			return fmt.Errorf("could not open bus %s", name)
+		}
+		err = b.SetRecvTimeout(pollTimeout)
+		if err != nil {
+			b.Close()
+			return fmt.Errorf("could not open bus %s", name)
+		}
+		c.bus = b
+		c.mode = bcmMode
+		c.wg.Add(1)
+		go c.receive()
+		c.write("< ok >")
+		return nil
+
+	case fs[0] == "rawmode" && len(fs) == 1:
+		c.mode = rawMode
+		c.write("< ok >")
+		return nil
+
+	case fs[0] == "bcmmode" && len(fs) == 1:
+		c.mode = bcmMode
+		c.write("< ok >")
+		return nil
+
+	case fs[0] == "send":
+		if len(fs) < 3 {
+			return fmt.Errorf("invalid send command")
+		}
+		frame, err := parseSend(fs[1], fs[2:])
+		if err != nil {
+			return fmt.Errorf("invalid send command: %v", err)
+		}
+		_, err = c.bus.Send(frame)
+		if err != nil {
+			return fmt.Errorf("could not send frame")
+		}
+		return nil
+
+	case c.mode == bcmMode:
+		return c.handleBCM(fs)
+	}
+	return fmt.Errorf("unknown command %s", fs[0])
+}
+
+// handleBCM handles the commands of the BCM mode.
+func (c *client) handleBCM(fs []string) error {
+	switch fs[0] {
+	case "add":
+		// < add sec usec can_id can_dlc [data]* >
+		if len(fs) < 5 {
+			return fmt.Errorf("invalid add command")
+		}
+		interval, err := parseDuration(fs[1], fs[2])
+		if err == nil && interval == 0 {
+			err = fmt.Errorf("invalid zero interval")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid add command: %v", err)
+		}
+		frame, err := parseSend(fs[3], fs[4:])
+		if err != nil {
+			return fmt.Errorf("invalid add command: %v", err)
+		}
+		id := rawID(frame.ID, frame.Kind)
+		if cy, ok := c.cyclics[id]; ok {
+			close(cy.stop)
+		}
+		cy := &cyclic{frame: frame, stop: make(chan struct{})}
+		c.cyclics[id] = cy
+		c.wg.Add(1)
+		go c.cycle(cy, interval)
+		return nil
+
+	case "update":
+		// < update can_id can_dlc [data]* >
+		if len(fs) < 3 {
+			return fmt.Errorf("invalid update command")
+		}
+		frame, err := parseSend(fs[1], fs[2:])
+		if err != nil {
+			return fmt.Errorf("invalid update command: %v", err)
+		}
+		cy, ok := c.cyclics[rawID(frame.ID, frame.Kind)]
+		if !ok {
+			return fmt.Errorf("unknown cyclic frame %s", fs[1])
+		}
+		cy.frame = frame
+		return nil
+
+	case "delete":
+		// < delete can_id >
+		id, err := c.parseID(fs, 2)
+		if err != nil {
+			return err
+		}
+		cy, ok := c.cyclics[id]
+		if !ok {
+			return fmt.Errorf("unknown cyclic frame %s", fs[1])
+		}
+		close(cy.stop)
+		delete(c.cyclics, id)
+		return nil
+
+	case "subscribe", "filter":
+		// < subscribe sec usec can_id >
+		// < filter sec usec can_id can_dlc [data]* >
+		n := 4
+		if fs[0] == "filter" {
+			n = len(fs)
+		}
+		if len(fs) < 4 || len(fs) != n {
+			return fmt.Errorf("invalid %s command", fs[0])
+		}
+		interval, err := parseDuration(fs[1], fs[2])
+		if err != nil {
+			return fmt.Errorf("invalid %s command: %v", fs[0], err)
+		}
+		sub := &subscription{interval: interval}
+		id, kind, err := parseID(fs[3])
+		if err != nil {
+			return fmt.Errorf("invalid %s command: %v", fs[0], err)
+		}
+		if fs[0] == "filter" {
+			sub.mask, err = parseData(fs[4:])
+			if err != nil {
+				return fmt.Errorf("invalid filter command: %v", err)
+			}
+		}
+		c.subs[rawID(id, kind)] = sub
+		return nil
+
+	case "unsubscribe":
+		// < unsubscribe can_id >
+		id, err := c.parseID(fs, 2)
+		if err != nil {
+			return err
+		}
+		if _, ok := c.subs[id]; !ok {
+			return fmt.Errorf("unknown subscription %s", fs[1])
+		}
+		delete(c.subs, id)
+		return nil
+	}
+	return fmt.Errorf("unknown command %s", fs[0])
+}
+
+// parseID parses the raw identifier of a command with n fields.
+func (c *client) parseID(fs []string, n int) (uint32, error) {
+	if len(fs) != n {
+		return 0, fmt.Errorf("invalid %s command", fs[0])
+	}
+	id, kind, err := parseID(fs[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s command: %v", fs[0], err)
+	}
+	return rawID(id, kind), nil
+}
+
+// parseSend parses the identifier and the payload of a frame to send.
+func parseSend(id string, data []string) (canbus.Frame, error) {
+	var (
+		frame canbus.Frame
+		err   error
+	)
+	frame.ID, frame.Kind, err = parseID(id)
+	if err != nil {
+		return frame, err
+	}
+	frame.Data, err = parseData(data)
+	return frame, err
+}
+
+// receive forwards the frames received from the bus to the client.
+func (c *client) receive() {
+	defer c.wg.Done()
+	for {
+		frame, err := c.bus.Recv()
+		if atomic.LoadUint32(&c.closed) != 0 {
+			return
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			c.write("< error could not receive frame >")
+			c.conn.Close()
+			return
+		}
+
+		now := c.srv.now()
+		c.mu.Lock()
+		ok := c.mode == rawMode
+		if c.mode == bcmMode && frame.Kind != canbus.ERR {
+			sub := c.subs[rawID(frame.ID, frame.Kind)]
+			ok = sub != nil && sub.pass(frame, now)
+		}
+		c.mu.Unlock()
+
+		if ok {
+			c.write(formatFrame(frame, now))
+		}
+	}
+}
+
+// cycle sends a frame at the provided interval, until it is stopped.
+func (c *client) cycle(cy *cyclic, interval time.Duration) {
+	defer c.wg.Done()
+	tck := time.NewTicker(interval)
+	defer tck.Stop()
+	for {
+		c.mu.Lock()
+		frame := cy.frame
+		c.mu.Unlock()
+		_, err := c.bus.Send(frame)
+		if err != nil {
+			c.write("< error could not send frame >")
+		}
+
+		select {
+		case <-cy.stop:
+			return
+		case <-tck.C:
+		}
+	}
+}
+
+func (c *client) write(msg string) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	// write errors are detected by the reads of the serve loop.
+	_, _ = io.WriteString(c.conn, msg)
+}
+
+// close closes the connection of the client, and its bus.
+func (c *client) close() {
+	c.conn.Close()
+	atomic.StoreUint32(&c.closed, 1)
+
+	c.mu.Lock()
+	for id, cy := range c.cyclics {
+		close(cy.stop)
+		delete(c.cyclics, id)
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	if c.bus != nil {
+		c.bus.Close()
+	}
+}
@@ -0,0 +1,424 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package socketcand
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/vcan"
+	"golang.org/x/sys/unix"
+)
+
+// fakeBus is a bus receiving frames from a channel, and recording the
+// frames sent.
+type fakeBus struct {
+	in chan canbus.Frame
+
+	mu     sync.Mutex
+	sent   []canbus.Frame
+	closed bool
+}
+
+func (b *fakeBus) Send(frame canbus.Frame) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent = append(b.sent, frame)
+	return len(frame.Data), nil
+}
+
+func (b *fakeBus) Recv() (canbus.Frame, error) {
+	select {
+	case frame := <-b.in:
+		return frame, nil
+	case <-time.After(10 * time.Millisecond):
+		return canbus.Frame{}, fmt.Errorf("recv timeout: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+func (b *fakeBus) Name() string                       { return "fake" }
+func (b *fakeBus) SetFilters([]unix.CanFilter) error  { return nil }
+func (b *fakeBus) SetRecvTimeout(time.Duration) error { return nil }
+
+func (b *fakeBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+func (b *fakeBus) frames() []canbus.Frame {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]canbus.Frame(nil), b.sent...)
+}
+
+// fakeBuses records the buses opened by a server.
+type fakeBuses struct {
+	mu    sync.Mutex
+	buses []*fakeBus
+}
+
+func (fb *fakeBuses) open(name string) (canbus.Transport, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	b := &fakeBus{in: make(chan canbus.Frame, 16)}
+	fb.buses = append(fb.buses, b)
+	return b, nil
+}
+
+func (fb *fakeBuses) get(i int) *fakeBus {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.buses[i]
+}
+
+// poll waits for the condition to be satisfied.
+func poll(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %s", msg)
+}
+
+func newTestServer(t *testing.T, buses ...string) (*Server, *fakeBuses, string, chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %+v", err)
+	}
+	fb := new(fakeBuses)
+	srv := NewServer(buses...)
+	srv.Open = fb.open
+	srv.now = func() time.Time { return time.Unix(1661871060, 1000) }
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+	return srv, fb, ln.Addr().String(), errc
+}
+
+func TestServer(t *testing.T) {
+	srv, fb, addr, errc := newTestServer(t, "vcan0", "vcan1")
+
+	raw, err := Dial(addr, "vcan0")
+	if err != nil {
+		t.Fatalf("could not dial: %+v", err)
+	}
+	defer raw.Close()
+
+	bcm, err := Dial(addr, "vcan0")
+	if err != nil {
+		t.Fatalf("could not dial: %+v", err)
+	}
+	defer bcm.Close()
+
+	err = bcm.BCMMode()
+	if err != nil {
+		t.Fatalf("could not switch to BCM mode: %+v", err)
+	}
+	err = bcm.Subscribe(0x123, 0)
+	if err != nil {
+		t.Fatalf("could not subscribe: %+v", err)
+	}
+	err = bcm.SubscribeChanges(0x200, 0, []byte{0x0f})
+	if err != nil {
+		t.Fatalf("could not subscribe: %+v", err)
+	}
+	// the server handles the commands in order: once the acknowledgment
+	// of a mode switch is received, the previous commands have been
+	// handled.
+	err = bcm.BCMMode()
+	if err != nil {
+		t.Fatalf("could not switch to BCM mode: %+v", err)
+	}
+
+	frames := []canbus.Frame{
+		{ID: 0x100, Data: []byte{0x01}},
+		{ID: 0x123, Data: []byte{0x11, 0x22}},
+		{ID: 0x123, Kind: canbus.EFF, Data: []byte{0x33}},
+		{ID: 0x004, Kind: canbus.ERR},
+		{ID: 0x200, Data: []byte{0x01}},
+		{ID: 0x200, Data: []byte{0x11}},
+		{ID: 0x200, Data: []byte{0x02}},
+		{ID: 0x200, Data: []byte{0x02, 0xff}},
+	}
+	for _, i := range []int{0, 1} {
+		for _, frame := range frames {
+			fb.get(i).in <- frame
+		}
+	}
+
+	for _, tc := range []struct {
+		conn *Conn
+		want []canbus.Frame
+	}{
+		{raw, frames},
+		{bcm, []canbus.Frame{frames[1], frames[4], frames[6], frames[7]}},
+	} {
+		for _, want := range tc.want {
+			got, err := tc.conn.Recv()
+			if err != nil {
+				t.Fatalf("could not receive frame: %+v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid frame: got=%+v, want=%+v", got, want)
+			}
+		}
+	}
+
+	for _, frame := range frames[:3] {
+		_, err = raw.Send(frame)
+		if err != nil {
+			t.Fatalf("could not send frame: %+v", err)
+		}
+	}
+	err = raw.RawMode()
+	if err != nil {
+		t.Fatalf("could not switch to raw mode: %+v", err)
+	}
+	if got, want := fb.get(0).frames(), frames[:3]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frames sent:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	err = bcm.Unsubscribe(0x456)
+	if err != nil {
+		t.Fatalf("could not unsubscribe: %+v", err)
+	}
+	_, err = bcm.Recv()
+	if got, want := fmt.Sprint(err), "socketcand: server error: unknown subscription 456"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	_, err = Dial(addr, "can0")
+	if got, want := fmt.Sprint(err), `socketcand: could not open bus "can0": socketcand: server error: unknown bus can0`; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+
+	err = srv.Close()
+	if err != nil {
+		t.Fatalf("could not close server: %+v", err)
+	}
+	if err := <-errc; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrServerClosed)
+	}
+	for i := 0; i < 2; i++ {
+		b := fb.get(i)
+		b.mu.Lock()
+		closed := b.closed
+		b.mu.Unlock()
+		if !closed {
+			t.Fatalf("bus %d not closed", i)
+		}
+	}
+}
+
+func TestServerVCAN(t *testing.T) {
+	bus := vcan.New("vcan0")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %+v", err)
+	}
+	srv := NewServer(bus.Name())
+	srv.Open = func(name string) (canbus.Transport, error) {
+		return bus.Open(), nil
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	conn, err := Dial(ln.Addr().String(), bus.Name())
+	if err != nil {
+		t.Fatalf("could not dial server: %+v", err)
+	}
+	defer conn.Close()
+	err = conn.RawMode()
+	if err != nil {
+		t.Fatalf("could not switch to raw mode: %+v", err)
+	}
+	err = conn.SetRecvTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("could not set recv timeout: %+v", err)
+	}
+
+	ep := bus.Open()
+	defer ep.Close()
+	err = ep.SetRecvTimeout(time.Second)
+	if err != nil {
+		t.Fatalf("could not set recv timeout: %+v", err)
+	}
+
+	want := canbus.Frame{ID: 0x123, Kind: canbus.SFF, Data: []byte{1, 2}}
+	_, err = ep.Send(want)
+	if err != nil {
+		t.Fatalf("could not send frame: %+v", err)
+	}
+	got, err := conn.Recv()
+	if err != nil {
+		t.Fatalf("could not receive frame: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frame received by client: got=%+v, want=%+v", got, want)
+	}
+
+	want = canbus.Frame{ID: 0x12345, Kind: canbus.EFF, Data: []byte{3}}
+	_, err = conn.Send(want)
+	if err != nil {
+		t.Fatalf("could not send frame: %+v", err)
+	}
+	got, err = ep.Recv()
+	if err != nil {
+		t.Fatalf("could not receive frame: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frame sent by client: got=%+v, want=%+v", got, want)
+	}
+
+	err = srv.Close()
+	if err != nil {
+		t.Fatalf("could not close server: %+v", err)
+	}
+	if err := <-errc; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrServerClosed)
+	}
+}
+
+func TestServerCyclic(t *testing.T) {
+	srv, fb, addr, _ := newTestServer(t, "vcan0")
+	defer srv.Close()
+
+	conn, err := Dial(addr, "vcan0")
+	if err != nil {
+		t.Fatalf("could not dial: %+v", err)
+	}
+	defer conn.Close()
+
+	err = conn.BCMMode()
+	if err != nil {
+		t.Fatalf("could not switch to BCM mode: %+v", err)
+	}
+	b := fb.get(0)
+	sent := func(data byte) func() bool {
+		return func() bool {
+			frames := b.frames()
+			n := 0
+			for _, f := range frames {
+				if f.ID == 0x7ff && f.Data[0] == data {
+					n++
+				}
+			}
+			return n >= 2
+		}
+	}
+
+	err = conn.AddCyclic(canbus.Frame{ID: 0x7ff, Data: []byte{1}}, 5*time.Millisecond)
+	if err != nil {
+		t.Fatalf("could not add cyclic frame: %+v", err)
+	}
+	poll(t, "cyclic frames", sent(1))
+
+	err = conn.UpdateCyclic(canbus.Frame{ID: 0x7ff, Data: []byte{2}})
+	if err != nil {
+		t.Fatalf("could not update cyclic frame: %+v", err)
+	}
+	poll(t, "updated cyclic frames", sent(2))
+
+	err = conn.DeleteCyclic(0x7ff)
+	if err != nil {
+		t.Fatalf("could not delete cyclic frame: %+v", err)
+	}
+	err = conn.BCMMode()
+	if err != nil {
+		t.Fatalf("could not switch to BCM mode: %+v", err)
+	}
+	n := len(b.frames())
+	time.Sleep(20 * time.Millisecond)
+	if got := len(b.frames()); got != n {
+		t.Fatalf("invalid number of frames: got=%d, want=%d", got, n)
+	}
+
+	err = conn.AddCyclic(canbus.Frame{ID: 0x7ff}, 0)
+	if err != nil {
+		t.Fatalf("could not add cyclic frame: %+v", err)
+	}
+	_, err = conn.Recv()
+	if got, want := fmt.Sprint(err), "socketcand: server error: invalid add command: invalid zero interval"; got != want {
+		t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	sub := &subscription{interval: 100 * time.Millisecond, mask: []byte{0xff, 0x00}}
+	for i, tc := range []struct {
+		dt   time.Duration
+		data []byte
+		want bool
+	}{
+		{0, []byte{1, 1}, true},
+		{200 * time.Millisecond, []byte{1, 2}, false}, // unchanged
+		{250 * time.Millisecond, []byte{2, 2}, true},
+		{300 * time.Millisecond, []byte{3, 2}, false}, // throttled
+		{350 * time.Millisecond, []byte{3, 2}, true},
+		{450 * time.Millisecond, []byte{3}, true}, // length changed
+	} {
+		got := sub.pass(canbus.Frame{ID: 0x123, Data: tc.data}, t0.Add(tc.dt))
+		if got != tc.want {
+			t.Fatalf("invalid selection of frame %d: got=%v, want=%v", i, got, tc.want)
+		}
+	}
+}
+
+func TestBeacon(t *testing.T) {
+	srv := NewServer("vcan0", "vcan1")
+	srv.Name = "rig"
+
+	var (
+		mu  sync.Mutex
+		buf bytes.Buffer
+	)
+	w := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	})
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.announce(w, "can://10.0.0.1:29536", time.Millisecond)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	srv.Close()
+	if err := <-errc; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrServerClosed)
+	}
+
+	want := `<CANBeacon name="rig" type="SocketCAN" description="socketcand">` +
+		`<URL>can://10.0.0.1:29536</URL><Bus name="vcan0"></Bus><Bus name="vcan1"></Bus>` +
+		`</CANBeacon>`
+	mu.Lock()
+	defer mu.Unlock()
+	if got := buf.String(); len(got) < 2*len(want) || got[:len(want)] != want {
+		t.Fatalf("invalid beacons:\ngot= %q\nwant=%q...", got, want)
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,214 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package socketcand implements the socketcand protocol, exposing CAN
+// buses over TCP.
+//
+// socketcand (https://github.com/linux-can/socketcand) exchanges ASCII
+// messages such as "< send 123 2 11 22 >" over TCP connections. Once
+// connected to a server, a client opens one of its buses, and either
+// exchanges raw frames with it (raw mode), or manages the cyclic
+// transmissions and the subscriptions of the broadcast manager of the
+// server (BCM mode).
+//
+// Conn is a client connection to a bus of a server, and Server serves
+// local CAN interfaces to clients such as socketcand tools, Kayak or
+// python-can.
+//
+// Identifiers are sent as extended identifiers when written with 8 hex
+// digits, and as standard identifiers otherwise.
+//
+// A typical usage might look like:
+//
+//	conn, err := socketcand.Dial("rpi:29536", "can0")
+//	defer conn.Close()
+//	_, err = conn.Send(canbus.Frame{ID: 0x123, Data: []byte{0x11, 0x22}})
+//	frame, err := conn.Recv()
+//
+// and, on the server side:
+//
+//	srv := socketcand.NewServer("can0", "can1")
+//	defer srv.Close()
+//	go srv.Announce("can://192.168.1.2:29536")
+//	err = srv.ListenAndServe(":29536")
+package socketcand
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/canbus"
+)
+
+// DefaultPort is the default TCP port of socketcand servers.
+const DefaultPort = 29536
+
+var (
+	errDataTooBig = errors.New("socketcand: data too big")
+	errInvalidMsg = errors.New("socketcand: invalid message")
+)
+
+// fields returns the fields of a "< ... >" message.
+func fields(msg string) ([]string, error) {
+	msg = strings.TrimSpace(msg)
+	if !strings.HasPrefix(msg, "<") || !strings.HasSuffix(msg, ">") {
+		return nil, fmt.Errorf("%w %q", errInvalidMsg, msg)
+	}
+	fs := strings.Fields(msg[1 : len(msg)-1])
+	if len(fs) == 0 {
+		return nil, fmt.Errorf("%w %q", errInvalidMsg, msg)
+	}
+	return fs, nil
+}
+
+func formatID(id uint32, kind canbus.Kind) string {
+	if kind == canbus.EFF {
+		return fmt.Sprintf("%08X", id&0x1fffffff)
+	}
+	return fmt.Sprintf("%03X", id&0x7ff)
+}
+
+func parseID(s string) (uint32, canbus.Kind, error) {
+	id, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || id > 0x1fffffff {
+		return 0, 0, fmt.Errorf("invalid identifier %q", s)
+	}
+	if len(s) == 8 || id > 0x7ff {
+		return uint32(id), canbus.EFF, nil
+	}
+	return uint32(id), canbus.SFF, nil
+}
+
+// kindOf returns the kind of frames with the provided identifier, for the
+// commands identifying frames without their kind.
+func kindOf(id uint32) canbus.Kind {
+	if id > 0x7ff {
+		return canbus.EFF
+	}
+	return canbus.SFF
+}
+
+// formatDuration formats a duration as seconds and microseconds, as in
+// "1 500000".
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%d %d", d/time.Second, d%time.Second/time.Microsecond)
+}
+
+func parseDuration(sec, usec string) (time.Duration, error) {
+	s, err := strconv.ParseUint(sec, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid seconds %q", sec)
+	}
+	us, err := strconv.ParseUint(usec, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid microseconds %q", usec)
+	}
+	return time.Duration(s)*time.Second + time.Duration(us)*time.Microsecond, nil
+}
+
+// formatData formats the length and the bytes of a payload, as in
+// "2 11 22".
+func formatData(data []byte) string {
+	var o strings.Builder
+	o.WriteString(strconv.Itoa(len(data)))
+	for _, v := range data {
+		fmt.Fprintf(&o, " %02X", v)
+	}
+	return o.String()
+}
+
+// parseData parses the length and the bytes of a payload.
+func parseData(fs []string) ([]byte, error) {
+	if len(fs) == 0 {
+		return nil, fmt.Errorf("missing length")
+	}
+	n, err := strconv.ParseUint(fs[0], 10, 8)
+	if err != nil || n > 8 {
+		return nil, fmt.Errorf("invalid length %q", fs[0])
+	}
+	if len(fs)-1 != int(n) {
+		return nil, fmt.Errorf("invalid number of bytes %d, want %d", len(fs)-1, n)
+	}
+	data := make([]byte, n)
+	for i, s := range fs[1:] {
+		v, err := strconv.ParseUint(s, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte %q", s)
+		}
+		data[i] = uint8(v)
+	}
+	return data, nil
+}
+
+// formatFrame formats a frame received at time t, as in
+// "< frame 123 1661871060.123456 1122 >".
+func formatFrame(frame canbus.Frame, t time.Time) string {
+	ts := fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/1e3)
+	if frame.Kind == canbus.ERR {
+		return fmt.Sprintf("< error %03X %s >", frame.ID, ts)
+	}
+	return fmt.Sprintf("< frame %s %s %X >", formatID(frame.ID, frame.Kind), ts, frame.Data)
+}
+
+// parseFrame parses the fields of a frame or an error frame message.
+func parseFrame(fs []string) (canbus.Frame, time.Time, error) {
+	var frame canbus.Frame
+	if len(fs) < 3 || (fs[0] == "frame" && len(fs) > 4) || (fs[0] == "error" && len(fs) != 3) {
+		return frame, time.Time{}, fmt.Errorf("invalid number of fields %d", len(fs))
+	}
+	sec, usec, ok := strings.Cut(fs[2], ".")
+	if !ok {
+		return frame, time.Time{}, fmt.Errorf("invalid timestamp %q", fs[2])
+	}
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return frame, time.Time{}, fmt.Errorf("invalid timestamp %q", fs[2])
+	}
+	us, err := strconv.ParseUint(usec, 10, 32)
+	if err != nil || len(usec) != 6 {
+		return frame, time.Time{}, fmt.Errorf("invalid timestamp %q", fs[2])
+	}
+	t := time.Unix(s, int64(us)*1e3)
+
+	if fs[0] == "error" {
+		class, err := strconv.ParseUint(fs[1], 16, 32)
+		if err != nil || class > 0x1fffffff {
+			return frame, t, fmt.Errorf("invalid error class %q", fs[1])
+		}
+		return canbus.Frame{ID: uint32(class), Kind: canbus.ERR}, t, nil
+	}
+
+	frame.ID, frame.Kind, err = parseID(fs[1])
+	if err != nil {
+		return frame, t, err
+	}
+	frame.Data = []byte{}
+	if len(fs) == 4 {
+		if len(fs[3])%2 != 0 || len(fs[3]) > 16 {
+			return frame, t, fmt.Errorf("invalid data %q", fs[3])
+		}
+		frame.Data = make([]byte, len(fs[3])/2)
+		for i := range frame.Data {
+			v, err := strconv.ParseUint(fs[3][2*i:2*i+2], 16, 8)
+			if err != nil {
+				return frame, t, fmt.Errorf("invalid data %q", fs[3])
+			}
+			frame.Data[i] = uint8(v)
+		}
+	}
+	return frame, t, nil
+}
+
+// isErrFrame reports whether the fields of an error message are the ones
+// of an error frame, rather than the ones of an error report.
+func isErrFrame(fs []string) bool {
+	if fs[0] != "error" || len(fs) != 3 {
+		return false
+	}
+	_, _, err := parseFrame(fs)
+	return err == nil
+}
@@ -0,0 +1,660 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sym reads and writes PCAN symbol (.sym) files, describing the
+// messages and signals exchanged over a CAN network.
+//
+// Symbol files are converted from and into candb databases.
+// Multiplexed messages are described in symbol files by a section per
+// multiplexer value: signals present in all the sections of a message
+// are not multiplexed, the others are multiplexed by the values of the
+// sections they appear in.
+// Symbol files do not describe nodes, so the senders and receivers of
+// messages and signals are not represented.
+package sym
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-daq/canbus/candb"
+)
+
+// ParseFile parses the named PCAN symbol file.
+func ParseFile(fname string) (*candb.Database, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("sym: could not open symbol file: %w", err)
+	}
+	defer f.Close()
+
+	db, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return db, nil
+}
+
+// Parse parses a PCAN symbol file from the provided reader.
+func Parse(r io.Reader) (*candb.Database, error) {
+	p := &parser{
+		enums: make(map[string][]candb.ValueDesc),
+		defs:  make(map[string]*candb.Signal),
+		refs:  make(map[*candb.Signal]string),
+	}
+	err := p.parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return p.build()
+}
+
+// section is a [Name] section of a symbol file.
+type section struct {
+	name    string
+	id      uint32
+	ext     bool
+	hasID   bool
+	size    int
+	mux     *candb.Signal
+	muxVal  uint64
+	signals []*candb.Signal
+}
+
+type parser struct {
+	line  int
+	block string // current {BLOCK}
+
+	enums map[string][]candb.ValueDesc
+	defs  map[string]*candb.Signal // signals of the {SIGNALS} block
+	refs  map[*candb.Signal]string // enumerations referenced by signals
+
+	secs []*section
+	cur  *section
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("sym: line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) parse(r io.Reader) error {
+	var (
+		sc   = bufio.NewScanner(r)
+		enum strings.Builder
+		n    int // line of the current enumeration
+	)
+	for sc.Scan() {
+		p.line++
+		line, comment := split(sc.Text())
+		if enum.Len() > 0 {
+			enum.WriteString(" " + line)
+			if !closed(enum.String()) {
+				continue
+			}
+			cur := p.line
+			p.line = n
+			err := p.parseEnum(enum.String())
+			if err != nil {
+				return err
+			}
+			p.line = cur
+			enum.Reset()
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(line, "{") && strings.HasSuffix(line, "}"):
+			p.block = line[1 : len(line)-1]
+			p.cur = nil
+		case p.block == "ENUMS" && strings.HasPrefix(line, "enum"):
+			if !closed(line) {
+				enum.WriteString(line)
+				n = p.line
+				continue
+			}
+			err = p.parseEnum(line)
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			err = p.parseSection(line[1 : len(line)-1])
+		default:
+			err = p.parseField(line, comment)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("sym: could not read symbol file: %w", err)
+	}
+	if enum.Len() > 0 {
+		p.line = n
+		return p.errorf("unterminated enumeration")
+	}
+	return nil
+}
+
+func (p *parser) parseSection(name string) error {
+	switch p.block {
+	case "SEND", "RECEIVE", "SENDRECEIVE":
+	default:
+		return p.errorf("unexpected section [%s] in block {%s}", name, p.block)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return p.errorf("empty section name")
+	}
+	p.cur = &section{name: name, size: -1}
+	p.secs = append(p.secs, p.cur)
+	return nil
+}
+
+func (p *parser) parseField(line, comment string) error {
+	i := strings.Index(line, "=")
+	if i < 0 {
+		return p.errorf("invalid line %q", line)
+	}
+	key, val := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+
+	if p.cur == nil {
+		switch {
+		case p.block == "SIGNALS" && key == "Sig":
+			return p.parseSigDef(val, comment)
+		case p.block == "":
+			// header fields (FormatVersion, Title, ...)
+			return nil
+		}
+		return p.errorf("unexpected field %q in block {%s}", key, p.block)
+	}
+
+	sec := p.cur
+	switch key {
+	case "ID":
+		if strings.Contains(val, "-") {
+			return p.errorf("unsupported identifier range %q", val)
+		}
+		id, err := parseUint(val, 32)
+		if err != nil {
+			return p.errorf("invalid identifier %q", val)
+		}
+		sec.id = uint32(id)
+		sec.hasID = true
+	case "Type":
+		switch val {
+		case "Standard":
+			sec.ext = false
+		case "Extended":
+			sec.ext = true
+		default:
+			return p.errorf("invalid frame type %q", val)
+		}
+	case "DLC", "Len":
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return p.errorf("invalid length %q", val)
+		}
+		sec.size = n
+	case "Mux":
+		return p.parseMux(val)
+	case "Var":
+		sig, err := p.parseVar(val, true)
+		if err != nil {
+			return err
+		}
+		sig.Comment = comment
+		sec.signals = append(sec.signals, sig)
+	case "Sig":
+		return p.parseSigRef(val)
+	}
+	return nil
+}
+
+// parseVar parses a signal definition:
+//
+//	Name type start,length [flags]
+//
+// or, when withStart is false:
+//
+//	Name type length [flags]
+func (p *parser) parseVar(val string, withStart bool) (*candb.Signal, error) {
+	toks := fields(val)
+	if len(toks) < 3 {
+		return nil, p.errorf("invalid signal definition %q", val)
+	}
+	sig := &candb.Signal{Name: toks[0], Factor: 1}
+	switch toks[1] {
+	case "unsigned", "bit", "char", "string", "raw":
+		sig.Type = candb.Unsigned
+	case "signed":
+		sig.Type = candb.Signed
+	case "float", "double":
+		sig.Type = candb.Float
+	default:
+		return nil, p.errorf("signal %q: invalid type %q", sig.Name, toks[1])
+	}
+
+	var err error
+	switch {
+	case withStart:
+		sig.Start, sig.Length, err = parsePos(toks[2])
+	default:
+		sig.Length, err = strconv.Atoi(toks[2])
+	}
+	if err != nil || sig.Length <= 0 {
+		return nil, p.errorf("signal %q: invalid position %q", sig.Name, toks[2])
+	}
+	switch toks[1] {
+	case "bit":
+		if sig.Length != 1 {
+			return nil, p.errorf("signal %q: invalid bit length %d", sig.Name, sig.Length)
+		}
+	case "float":
+		if sig.Length != 32 {
+			return nil, p.errorf("signal %q: invalid float length %d", sig.Name, sig.Length)
+		}
+	case "double":
+		if sig.Length != 64 {
+			return nil, p.errorf("signal %q: invalid double length %d", sig.Name, sig.Length)
+		}
+	}
+
+	for _, tok := range toks[3:] {
+		if tok == "-m" {
+			sig.ByteOrder = candb.BigEndian
+			continue
+		}
+		if !strings.HasPrefix(tok, "/") {
+			continue
+		}
+		i := strings.Index(tok, ":")
+		if i < 0 {
+			continue
+		}
+		key, v := tok[1:i], unquote(tok[i+1:])
+		switch key {
+		case "u":
+			sig.Unit = v
+		case "f":
+			sig.Factor, err = strconv.ParseFloat(v, 64)
+		case "o":
+			sig.Offset, err = strconv.ParseFloat(v, 64)
+		case "min":
+			sig.Min, err = strconv.ParseFloat(v, 64)
+		case "max":
+			sig.Max, err = strconv.ParseFloat(v, 64)
+		case "e":
+			p.refs[sig] = v
+		}
+		if err != nil {
+			return nil, p.errorf("signal %q: invalid attribute %q", sig.Name, tok)
+		}
+	}
+	if withStart && sig.ByteOrder == candb.BigEndian {
+		sig.Start = flip(sig.Start)
+	}
+	return sig, nil
+}
+
+func (p *parser) parseSigDef(val, comment string) error {
+	sig, err := p.parseVar(val, false)
+	if err != nil {
+		return err
+	}
+	if _, dup := p.defs[sig.Name]; dup {
+		return p.errorf("duplicate signal definition %q", sig.Name)
+	}
+	sig.Comment = comment
+	p.defs[sig.Name] = sig
+	return nil
+}
+
+// parseSigRef parses a reference to a signal of the {SIGNALS} block:
+//
+//	Name start
+func (p *parser) parseSigRef(val string) error {
+	toks := fields(val)
+	if len(toks) < 2 {
+		return p.errorf("invalid signal reference %q", val)
+	}
+	def, ok := p.defs[toks[0]]
+	if !ok {
+		return p.errorf("unknown signal %q", toks[0])
+	}
+	start, err := strconv.Atoi(toks[1])
+	if err != nil || start < 0 {
+		return p.errorf("signal %q: invalid start bit %q", toks[0], toks[1])
+	}
+	sig := *def
+	sig.Start = start
+	if sig.ByteOrder == candb.BigEndian {
+		sig.Start = flip(sig.Start)
+	}
+	if enum, ok := p.refs[def]; ok {
+		p.refs[&sig] = enum
+	}
+	p.cur.signals = append(p.cur.signals, &sig)
+	return nil
+}
+
+// parseMux parses the multiplexer of a section:
+//
+//	Name start,length value [flags]
+func (p *parser) parseMux(val string) error {
+	toks := fields(val)
+	if len(toks) < 3 {
+		return p.errorf("invalid multiplexer definition %q", val)
+	}
+	if p.cur.mux != nil {
+		return p.errorf("duplicate multiplexer %q", toks[0])
+	}
+	sig := &candb.Signal{Name: toks[0], Factor: 1}
+	start, length, err := parsePos(toks[1])
+	if err != nil || length <= 0 {
+		return p.errorf("multiplexer %q: invalid position %q", sig.Name, toks[1])
+	}
+	sig.Start, sig.Length = start, length
+	v, err := parseUint(toks[2], 64)
+	if err != nil {
+		return p.errorf("multiplexer %q: invalid value %q", sig.Name, toks[2])
+	}
+	for _, tok := range toks[3:] {
+		if tok == "-m" {
+			sig.ByteOrder = candb.BigEndian
+			sig.Start = flip(sig.Start)
+		}
+	}
+	p.cur.mux = sig
+	p.cur.muxVal = v
+	return nil
+}
+
+func (p *parser) parseEnum(line string) error {
+	// enum Name(0="a", 1="b")
+	line = strings.TrimSpace(strings.TrimPrefix(line, "enum"))
+	beg := strings.Index(line, "(")
+	end := strings.LastIndex(line, ")")
+	if beg < 0 || end < beg {
+		return p.errorf("invalid enumeration %q", line)
+	}
+	name := strings.TrimSpace(line[:beg])
+	if name == "" {
+		return p.errorf("invalid enumeration %q", line)
+	}
+	if _, dup := p.enums[name]; dup {
+		return p.errorf("duplicate enumeration %q", name)
+	}
+
+	var (
+		vals []candb.ValueDesc
+		body = line[beg+1 : end]
+	)
+	for strings.TrimSpace(body) != "" {
+		i := strings.Index(body, "=")
+		if i < 0 {
+			return p.errorf("enumeration %q: invalid value %q", name, strings.TrimSpace(body))
+		}
+		v, err := strconv.ParseInt(strings.TrimSpace(body[:i]), 10, 64)
+		if err != nil {
+			return p.errorf("enumeration %q: invalid value %q", name, strings.TrimSpace(body[:i]))
+		}
+		body = strings.TrimSpace(body[i+1:])
+		if !strings.HasPrefix(body, `"`) {
+			return p.errorf("enumeration %q: missing description for value %d", name, v)
+		}
+		j := strings.Index(body[1:], `"`)
+		if j < 0 {
+			return p.errorf("enumeration %q: unterminated description", name)
+		}
+		vals = append(vals, candb.ValueDesc{Value: v, Desc: body[1 : j+1]})
+		body = strings.TrimSpace(body[j+2:])
+		body = strings.TrimPrefix(body, ",")
+	}
+	p.enums[name] = vals
+	return nil
+}
+
+// build assembles the sections of the symbol file into messages.
+func (p *parser) build() (*candb.Database, error) {
+	var (
+		db    = &candb.Database{}
+		names []string
+		secs  = make(map[string][]*section)
+	)
+	for _, sec := range p.secs {
+		if _, dup := secs[sec.name]; !dup {
+			names = append(names, sec.name)
+		}
+		secs[sec.name] = append(secs[sec.name], sec)
+	}
+
+	for sig, name := range p.refs {
+		vals, ok := p.enums[name]
+		if !ok {
+			return nil, fmt.Errorf("sym: signal %q: unknown enumeration %q", sig.Name, name)
+		}
+		sig.Values = vals
+	}
+
+	for _, name := range names {
+		msg, err := p.message(name, secs[name])
+		if err != nil {
+			return nil, err
+		}
+		db.Messages = append(db.Messages, msg)
+	}
+
+	err := db.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("sym: invalid message: %w", err)
+	}
+	return db, nil
+}
+
+func (p *parser) message(name string, secs []*section) (*candb.Message, error) {
+	first := secs[0]
+	if !first.hasID {
+		return nil, fmt.Errorf("sym: message %q: missing identifier", name)
+	}
+	msg := &candb.Message{
+		ID:       first.id,
+		Extended: first.ext,
+		Name:     name,
+		Size:     first.size,
+	}
+	for _, sec := range secs[1:] {
+		if sec.hasID && (sec.id != first.id || sec.ext != first.ext) {
+			return nil, fmt.Errorf("sym: message %q: conflicting identifiers", name)
+		}
+		if sec.size >= 0 && sec.size != first.size {
+			return nil, fmt.Errorf("sym: message %q: conflicting lengths", name)
+		}
+	}
+	if msg.Size < 0 {
+		msg.Size = 8
+	}
+
+	mux := first.mux
+	if mux == nil {
+		if len(secs) > 1 {
+			return nil, fmt.Errorf("sym: message %q: duplicate section", name)
+		}
+		msg.Signals = first.signals
+		return msg, nil
+	}
+
+	// multiplexed message: merge the signals of all the sections.
+	var (
+		sigs []*candb.Signal
+		vals = make(map[string][]uint64)
+		seen = make(map[uint64]bool)
+	)
+	for _, sec := range secs {
+		if sec.mux == nil || !reflect.DeepEqual(sec.mux, mux) {
+			return nil, fmt.Errorf("sym: message %q: conflicting multiplexers", name)
+		}
+		if seen[sec.muxVal] {
+			return nil, fmt.Errorf("sym: message %q: duplicate multiplexer value %d", name, sec.muxVal)
+		}
+		seen[sec.muxVal] = true
+		for _, sig := range sec.signals {
+			if prev := find(sigs, sig.Name); prev == nil {
+				sigs = append(sigs, sig)
+			} else if !reflect.DeepEqual(prev, sig) {
+				return nil, fmt.Errorf("sym: message %q: conflicting definitions of signal %q", name, sig.Name)
+			}
+			vals[sig.Name] = append(vals[sig.Name], sec.muxVal)
+		}
+	}
+
+	msg.Signals = append(msg.Signals, mux)
+	var muxed []*candb.Signal
+	for _, sig := range sigs {
+		vs := vals[sig.Name]
+		if len(secs) > 1 && len(vs) == len(secs) {
+			msg.Signals = append(msg.Signals, sig)
+			continue
+		}
+		sig.Mux = mux
+		sig.MuxRanges = ranges(vs)
+		muxed = append(muxed, sig)
+	}
+	msg.Signals = append(msg.Signals, muxed...)
+	return msg, nil
+}
+
+// ranges returns the ranges covered by the provided values.
+func ranges(vs []uint64) []candb.MuxRange {
+	sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+	var out []candb.MuxRange
+	for _, v := range vs {
+		if n := len(out); n > 0 && out[n-1].Max+1 == v {
+			out[n-1].Max = v
+			continue
+		}
+		out = append(out, candb.MuxRange{Min: v, Max: v})
+	}
+	return out
+}
+
+func find(sigs []*candb.Signal, name string) *candb.Signal {
+	for _, sig := range sigs {
+		if sig.Name == name {
+			return sig
+		}
+	}
+	return nil
+}
+
+// flip converts between the big-endian start bits of symbol files,
+// counting bits from the most significant bit of the first byte, and
+// the start bits of candb.
+func flip(start int) int {
+	return start/8*8 + 7 - start%8
+}
+
+// split splits a line into its content and its trailing comment.
+func split(line string) (string, string) {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(line[i:], "//"):
+			return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+2:])
+		}
+	}
+	return strings.TrimSpace(line), ""
+}
+
+// closed reports whether the parentheses of an enumeration are closed.
+func closed(line string) bool {
+	var (
+		quoted = false
+		depth  = 0
+		opened = false
+	)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+			opened = true
+		case c == ')':
+			depth--
+		}
+	}
+	return opened && depth <= 0
+}
+
+// fields splits s around white space, keeping quoted strings whole.
+func fields(s string) []string {
+	var (
+		out    []string
+		beg    = -1
+		quoted = false
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == ' ' || c == '\t':
+			if beg >= 0 {
+				out = append(out, s[beg:i])
+				beg = -1
+			}
+			continue
+		}
+		if beg < 0 {
+			beg = i
+		}
+	}
+	if beg >= 0 {
+		out = append(out, s[beg:])
+	}
+	return out
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// parsePos parses a start,length signal position.
+func parsePos(s string) (start, length int, err error) {
+	i := strings.Index(s, ",")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("missing length")
+	}
+	start, err = strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, 0, err
+	}
+	if start < 0 {
+		return 0, 0, fmt.Errorf("negative start bit")
+	}
+	length, err = strconv.Atoi(s[i+1:])
+	return start, length, err
+}
+
+// parseUint parses a decimal number, or a hexadecimal number with a
+// trailing 'h'.
+func parseUint(s string, bits int) (uint64, error) {
+	if strings.HasSuffix(s, "h") || strings.HasSuffix(s, "H") {
+		return strconv.ParseUint(s[:len(s)-1], 16, bits)
+	}
+	return strconv.ParseUint(s, 10, bits)
+}
@@ -0,0 +1,319 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sym_test
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/candb"
+	"github.com/go-daq/canbus/dbc"
+	"github.com/go-daq/canbus/sym"
+)
+
+func TestParseFile(t *testing.T) {
+	db, err := sym.ParseFile("testdata/example.sym")
+	if err != nil {
+		t.Fatalf("could not parse symbol file: %+v", err)
+	}
+
+	if got, want := len(db.Messages), 3; got != want {
+		t.Fatalf("invalid number of messages: got=%d, want=%d", got, want)
+	}
+
+	vals := make(map[string]float64)
+	msg, err := db.Decode(canbus.Frame{
+		ID:   0x100,
+		Kind: canbus.SFF,
+		Data: []byte{0x40, 0x1f, 0x50, 0x0b, 0, 0, 0, 0},
+	}, vals)
+	if err != nil {
+		t.Fatalf("could not decode frame: %+v", err)
+	}
+	if got, want := vals, map[string]float64{
+		"EngineSpeed": 2000,
+		"CoolantTemp": 40,
+		"Gear":        3,
+		"Warning":     1,
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid values:\ngot= %v\nwant=%v", got, want)
+	}
+	if desc, _ := msg.Signal("Gear").Desc(3); desc != "Drive" {
+		t.Fatalf("invalid value description: %q", desc)
+	}
+	if got, want := msg.Signal("EngineSpeed").Comment, "Engine speed"; got != want {
+		t.Fatalf("invalid comment: got=%q, want=%q", got, want)
+	}
+
+	status := db.Message(0x18fef100, true)
+	level := status.Signal("Level")
+	if got, want := level.Start, 39; got != want {
+		t.Fatalf("invalid big-endian start bit: got=%d, want=%d", got, want)
+	}
+	if got, want := level.Unit, "l/100km"; got != want {
+		t.Fatalf("invalid unit: got=%q, want=%q", got, want)
+	}
+	if got, want := status.Signal("Pressure").Type, candb.Float; got != want {
+		t.Fatalf("invalid signal type: got=%v, want=%v", got, want)
+	}
+
+	diag := db.MessageByName("Diagnostics")
+	for _, tc := range []struct {
+		name   string
+		mux    string
+		ranges []candb.MuxRange
+	}{
+		{"Mode", "", nil},
+		{"Counter", "", nil},
+		{"Voltage", "Mode", []candb.MuxRange{{Min: 1, Max: 1}}},
+		{"Current", "Mode", []candb.MuxRange{{Min: 2, Max: 3}}},
+	} {
+		sig := diag.Signal(tc.name)
+		var mux string
+		if sig.Mux != nil {
+			mux = sig.Mux.Name
+		}
+		if mux != tc.mux || !reflect.DeepEqual(sig.MuxRanges, tc.ranges) {
+			t.Fatalf("invalid multiplexing for %q: got=%q%v, want=%q%v",
+				tc.name, mux, sig.MuxRanges, tc.mux, tc.ranges,
+			)
+		}
+	}
+}
+
+func TestParseSignals(t *testing.T) {
+	const src = `FormatVersion=6.0 // Do not edit this line!
+
+{ENUMS}
+enum OnOff(0="Off",
+	1="On")
+
+{SIGNALS}
+Sig=Speed unsigned 16 -m /u:"km/h" /f:0.1 // vehicle speed
+Sig=Light bit 1 /e:OnOff
+
+{SEND}
+
+[Vehicle]
+ID=123h
+Len=3
+Sig=Speed 0
+Sig=Light 16
+`
+	db, err := sym.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("could not parse symbol file: %+v", err)
+	}
+
+	want := &candb.Message{
+		ID:   0x123,
+		Name: "Vehicle",
+		Size: 3,
+		Signals: []*candb.Signal{
+			{
+				Name:      "Speed",
+				Start:     7,
+				Length:    16,
+				ByteOrder: candb.BigEndian,
+				Factor:    0.1,
+				Unit:      "km/h",
+				Comment:   "vehicle speed",
+			},
+			{
+				Name:   "Light",
+				Start:  16,
+				Length: 1,
+				Factor: 1,
+				Values: []candb.ValueDesc{{Value: 0, Desc: "Off"}, {Value: 1, Desc: "On"}},
+			},
+		},
+	}
+	if got := db.Messages[0]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid message:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	db, err := sym.ParseFile("testdata/example.sym")
+	if err != nil {
+		t.Fatalf("could not parse symbol file: %+v", err)
+	}
+
+	var buf bytes.Buffer
+	err = sym.Write(&buf, db)
+	if err != nil {
+		t.Fatalf("could not write symbol file: %+v", err)
+	}
+
+	want, err := os.ReadFile("testdata/example.sym")
+	if err != nil {
+		t.Fatalf("could not read golden file: %+v", err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("invalid symbol file:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	got, err := sym.Parse(&buf)
+	if err != nil {
+		t.Fatalf("could not parse written symbol file: %+v", err)
+	}
+	if !reflect.DeepEqual(got, db) {
+		t.Fatalf("round-trip failed")
+	}
+}
+
+func TestConvertDBC(t *testing.T) {
+	want, err := sym.ParseFile("testdata/example.sym")
+	if err != nil {
+		t.Fatalf("could not parse symbol file: %+v", err)
+	}
+
+	ddb, err := dbc.FromCANDB(want)
+	if err != nil {
+		t.Fatalf("could not convert symbol file: %+v", err)
+	}
+	var buf bytes.Buffer
+	err = dbc.Write(&buf, ddb)
+	if err != nil {
+		t.Fatalf("could not write DBC file: %+v", err)
+	}
+
+	db, err := dbc.Parse(&buf)
+	if err != nil {
+		t.Fatalf("could not parse DBC file: %+v", err)
+	}
+	got, err := db.ToCANDB()
+	if err != nil {
+		t.Fatalf("could not convert DBC database: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("round-trip through DBC failed")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{
+			name: "missing-id",
+			src:  "{SEND}\n[M]\nDLC=1\n",
+			err:  `sym: message "M": missing identifier`,
+		},
+		{
+			name: "invalid-type",
+			src:  "{SEND}\n[M]\nID=1h\nVar=A int 0,8\n",
+			err:  `sym: line 4: signal "A": invalid type "int"`,
+		},
+		{
+			name: "invalid-id",
+			src:  "{SEND}\n[M]\nID=xyzh\n",
+			err:  `sym: line 3: invalid identifier "xyzh"`,
+		},
+		{
+			name: "unknown-enum",
+			src:  "{SEND}\n[M]\nID=1h\nVar=A unsigned 0,8 /e:E\n",
+			err:  `sym: signal "A": unknown enumeration "E"`,
+		},
+		{
+			name: "unknown-signal",
+			src:  "{SEND}\n[M]\nID=1h\nSig=A 0\n",
+			err:  `sym: line 4: unknown signal "A"`,
+		},
+		{
+			name: "unterminated-enum",
+			src:  "{ENUMS}\nenum E(0=\"a\",\n1=\"b\"\n",
+			err:  `sym: line 2: unterminated enumeration`,
+		},
+		{
+			name: "section-outside-block",
+			src:  "[M]\nID=1h\n",
+			err:  `sym: line 1: unexpected section [M] in block {}`,
+		},
+		{
+			name: "duplicate-section",
+			src:  "{SEND}\n[M]\nID=1h\n[M]\nID=1h\n",
+			err:  `sym: message "M": duplicate section`,
+		},
+		{
+			name: "conflicting-signals",
+			src:  "{SEND}\n[M]\nID=1h\nMux=S 0,8 1\nVar=A unsigned 8,8\n[M]\nMux=S 0,8 2\nVar=A unsigned 8,4\n",
+			err:  `sym: message "M": conflicting definitions of signal "A"`,
+		},
+		{
+			name: "overlap",
+			src:  "{SEND}\n[M]\nID=1h\nVar=A unsigned 0,8\nVar=B unsigned 4,8\n",
+			err:  `sym: invalid message: candb: message "M": signals "A" and "B" overlap`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := sym.Parse(strings.NewReader(tc.src))
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	mux := &candb.Signal{Name: "Mux", Length: 8, Factor: 1}
+	sub := &candb.Signal{
+		Name: "Sub", Start: 8, Length: 8, Factor: 1,
+		Mux: mux, MuxRanges: []candb.MuxRange{{Min: 1, Max: 1}},
+	}
+	for _, tc := range []struct {
+		name string
+		msg  *candb.Message
+		err  string
+	}{
+		{
+			name: "message-name",
+			msg:  &candb.Message{ID: 1, Name: "A B", Size: 8},
+			err:  `sym: invalid message name "A B"`,
+		},
+		{
+			name: "nested-mux",
+			msg: &candb.Message{ID: 1, Name: "M", Size: 8, Signals: []*candb.Signal{
+				mux, sub,
+				{
+					Name: "A", Start: 16, Length: 8, Factor: 1,
+					Mux: sub, MuxRanges: []candb.MuxRange{{Min: 1, Max: 1}},
+				},
+			}},
+			err: `sym: message "M": signal "A": nested multiplexing is not supported`,
+		},
+		{
+			name: "mux-values",
+			msg: &candb.Message{ID: 1, Name: "M", Size: 8, Signals: []*candb.Signal{
+				mux,
+				{
+					Name: "A", Start: 16, Length: 8, Factor: 1,
+					Mux: mux, MuxRanges: []candb.MuxRange{{Min: 0, Max: 1000}},
+				},
+			}},
+			err: `sym: message "M": too many multiplexer values`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &candb.Database{Messages: []*candb.Message{tc.msg}}
+			err := sym.Write(new(bytes.Buffer), db)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got, want := err.Error(), tc.err; got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
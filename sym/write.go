@@ -0,0 +1,312 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sym
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-daq/canbus/candb"
+)
+
+// maxMuxValues is the maximum number of multiplexer values, and thus of
+// sections, of a multiplexed message.
+const maxMuxValues = 256
+
+// WriteFile writes the candb database to the named PCAN symbol file.
+func WriteFile(fname string, db *candb.Database) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("sym: could not create symbol file: %w", err)
+	}
+	defer f.Close()
+
+	err = Write(f, db)
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("sym: could not close symbol file: %w", err)
+	}
+	return nil
+}
+
+// Write writes the candb database to w, using the PCAN symbol file
+// format (version 6.0).
+//
+// Multiplexed messages must have a single multiplexer switch, which
+// must not be multiplexed itself.
+// Messages whose multiplexed signals are all selected by the same
+// single value cannot be told apart from non-multiplexed messages once
+// written.
+// Value descriptions are written as enumerations named after their
+// signal.
+func Write(w io.Writer, db *candb.Database) error {
+	err := db.Validate()
+	if err != nil {
+		return fmt.Errorf("sym: invalid database: %w", err)
+	}
+
+	ww := &writer{
+		w:     bufio.NewWriter(w),
+		enums: make(map[*candb.Signal]string),
+	}
+	err = ww.prepare(db)
+	if err != nil {
+		return err
+	}
+	ww.write(db)
+	if ww.err != nil {
+		return fmt.Errorf("sym: could not write symbol file: %w", ww.err)
+	}
+	err = ww.w.Flush()
+	if err != nil {
+		return fmt.Errorf("sym: could not flush symbol file: %w", err)
+	}
+	return nil
+}
+
+type writer struct {
+	w   *bufio.Writer
+	err error
+
+	names []string                     // enumeration names, in order
+	vals  map[string][]candb.ValueDesc // enumerations, by name
+	enums map[*candb.Signal]string     // enumeration of signals
+	muxes map[*candb.Message]*candb.Signal
+}
+
+func (w *writer) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+// prepare checks the database can be represented in a symbol file and
+// names the enumerations of the signals.
+func (w *writer) prepare(db *candb.Database) error {
+	w.vals = make(map[string][]candb.ValueDesc)
+	w.muxes = make(map[*candb.Message]*candb.Signal)
+	for _, msg := range db.Messages {
+		if !validName(msg.Name) {
+			return fmt.Errorf("sym: invalid message name %q", msg.Name)
+		}
+		for _, sig := range msg.Signals {
+			if !validName(sig.Name) {
+				return fmt.Errorf("sym: message %q: invalid signal name %q", msg.Name, sig.Name)
+			}
+			if strings.ContainsAny(sig.Unit, "\"\n") {
+				return fmt.Errorf("sym: message %q: signal %q: invalid unit %q", msg.Name, sig.Name, sig.Unit)
+			}
+			if sig.Mux != nil {
+				mux := w.muxes[msg]
+				switch {
+				case sig.Mux.Mux != nil:
+					return fmt.Errorf("sym: message %q: signal %q: nested multiplexing is not supported", msg.Name, sig.Name)
+				case mux != nil && mux != sig.Mux:
+					return fmt.Errorf("sym: message %q: multiple multiplexer switches", msg.Name)
+				}
+				w.muxes[msg] = sig.Mux
+			}
+			if len(sig.Values) == 0 {
+				continue
+			}
+			for _, v := range sig.Values {
+				if strings.ContainsAny(v.Desc, "\"\n") {
+					return fmt.Errorf("sym: message %q: signal %q: invalid value description %q", msg.Name, sig.Name, v.Desc)
+				}
+			}
+			name := sig.Name
+			if vals, dup := w.vals[name]; dup && !reflect.DeepEqual(vals, sig.Values) {
+				name = msg.Name + "_" + sig.Name
+			}
+			if _, dup := w.vals[name]; !dup {
+				w.names = append(w.names, name)
+				w.vals[name] = sig.Values
+			}
+			w.enums[sig] = name
+		}
+		if mux := w.muxes[msg]; mux != nil && len(muxValues(msg, mux)) > maxMuxValues {
+			return fmt.Errorf("sym: message %q: too many multiplexer values", msg.Name)
+		}
+	}
+	return nil
+}
+
+func (w *writer) write(db *candb.Database) {
+	w.printf("FormatVersion=6.0 // Do not edit this line!\n")
+	w.printf("Title=\"\"\n")
+
+	if len(w.names) > 0 {
+		w.printf("\n{ENUMS}\n")
+		for _, name := range w.names {
+			w.printf("enum %s(", name)
+			for i, v := range w.vals[name] {
+				if i > 0 {
+					w.printf(", ")
+				}
+				w.printf("%d=%q", v.Value, v.Desc)
+			}
+			w.printf(")\n")
+		}
+	}
+
+	w.printf("\n{SENDRECEIVE}\n")
+	for _, msg := range db.Messages {
+		mux := w.muxes[msg]
+		if mux == nil {
+			w.section(msg, nil, 0)
+			continue
+		}
+		for _, v := range muxValues(msg, mux) {
+			w.section(msg, mux, v)
+		}
+	}
+}
+
+func (w *writer) section(msg *candb.Message, mux *candb.Signal, val uint64) {
+	w.printf("\n[%s]\n", msg.Name)
+	w.printf("ID=%Xh\n", msg.ID)
+	if msg.Extended {
+		w.printf("Type=Extended\n")
+	}
+	w.printf("DLC=%d\n", msg.Size)
+	if mux != nil {
+		w.printf("Mux=%s %d,%d %d", mux.Name, start(mux), mux.Length, val)
+		if mux.ByteOrder == candb.BigEndian {
+			w.printf(" -m")
+		}
+		w.printf("\n")
+	}
+	for _, sig := range msg.Signals {
+		if sig == mux || !selected(sig, val) {
+			continue
+		}
+		w.signal(sig)
+	}
+}
+
+func (w *writer) signal(sig *candb.Signal) {
+	var typ string
+	switch {
+	case sig.Type == candb.Float && sig.Length == 32:
+		typ = "float"
+	case sig.Type == candb.Float:
+		typ = "double"
+	case sig.Type == candb.Signed:
+		typ = "signed"
+	case sig.Length == 1:
+		typ = "bit"
+	default:
+		typ = "unsigned"
+	}
+	w.printf("Var=%s %s %d,%d", sig.Name, typ, start(sig), sig.Length)
+	if sig.ByteOrder == candb.BigEndian {
+		w.printf(" -m")
+	}
+	if sig.Unit != "" {
+		unit := sig.Unit
+		if strings.ContainsAny(unit, " \t/") {
+			unit = `"` + unit + `"`
+		}
+		w.printf(" /u:%s", unit)
+	}
+	if sig.Factor != 1 {
+		w.printf(" /f:%s", format(sig.Factor))
+	}
+	if sig.Offset != 0 {
+		w.printf(" /o:%s", format(sig.Offset))
+	}
+	if sig.Min != 0 || sig.Max != 0 {
+		w.printf(" /min:%s /max:%s", format(sig.Min), format(sig.Max))
+	}
+	if name, ok := w.enums[sig]; ok {
+		w.printf(" /e:%s", name)
+	}
+	if c := strings.Join(strings.Fields(sig.Comment), " "); c != "" {
+		w.printf(" // %s", c)
+	}
+	w.printf("\n")
+}
+
+// muxValues returns the values of the multiplexer switch selecting
+// the signals of the message.
+func muxValues(msg *candb.Message, mux *candb.Signal) []uint64 {
+	var (
+		vals []uint64
+		seen = make(map[uint64]bool)
+	)
+	for _, sig := range msg.Signals {
+		if sig.Mux != mux {
+			continue
+		}
+		for _, r := range sig.MuxRanges {
+			for v := r.Min; v <= r.Max && len(vals) <= maxMuxValues; v++ {
+				if !seen[v] {
+					seen[v] = true
+					vals = append(vals, v)
+				}
+				if v == r.Max {
+					break // avoid overflows
+				}
+			}
+		}
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+	return vals
+}
+
+// selected reports whether the signal is present in the frames where
+// the multiplexer switch has value v.
+func selected(sig *candb.Signal, v uint64) bool {
+	if sig.Mux == nil {
+		return true
+	}
+	for _, r := range sig.MuxRanges {
+		if r.Min <= v && v <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// start returns the start bit of the signal, as described in symbol files.
+func start(sig *candb.Signal) int {
+	if sig.ByteOrder == candb.BigEndian {
+		return flip(sig.Start)
+	}
+	return sig.Start
+}
+
+func format(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_',
+			'a' <= c && c <= 'z',
+			'A' <= c && c <= 'Z',
+			'0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package canbus
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Transport exchanges frames with a CAN bus.
+//
+// Transport is implemented by Socket, and may be implemented by serial
+// adapters, network tunnels or simulated buses, so that the code using
+// a CAN bus does not depend on a SocketCAN interface.
+type Transport interface {
+	// Name returns the name of the bus.
+	Name() string
+
+	// Send sends the provided frame on the bus, and returns a number of
+	// bytes sent, whose meaning is defined by each transport: Socket
+	// returns the number of bytes written to the socket, while other
+	// transports may return the number of data bytes of the frame.
+	// Callers should only rely on the returned error.
+	Send(msg Frame) (int, error)
+
+	// Recv receives a frame from the bus.
+	// Recv returns an error wrapping os.ErrDeadlineExceeded once the
+	// receive timeout has elapsed without any frame being received.
+	Recv() (Frame, error)
+
+	// SetFilters sets the filters of the received frames, with the
+	// semantics of the CAN_RAW_FILTER socket option: frames are
+	// received when they match any of the filters, and an empty list
+	// of filters receives no frames.
+	SetFilters(filters []unix.CanFilter) error
+
+	// SetRecvTimeout sets the receive timeout of Recv.
+	// A zero duration disables the timeout.
+	SetRecvTimeout(timeout time.Duration) error
+
+	// Close closes the connection to the bus.
+	Close() error
+}
+
+var (
+	_ Transport = (*Socket)(nil)
+)
@@ -0,0 +1,579 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcan
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// txQueueLen is the number of frames a node holds until they are
+	// transmitted, as the default transmit queue length of the CAN
+	// interfaces of Linux.
+	txQueueLen = 10
+
+	ifsBits     = 3        // interframe space
+	errorBits   = 6 + 8    // error flag and error delimiter
+	suspendBits = 8        // suspend transmission of error passive transmitters
+	busOffBits  = 128 * 11 // recovery sequence of bus-off nodes
+
+	errCnt = 0x200 // CAN_ERR_CNT: error counters in the data of error frames
+)
+
+var (
+	errBusOff    = fmt.Errorf("vcan: node is bus-off: %w", unix.ENOBUFS)
+	errQueueFull = fmt.Errorf("vcan: transmit queue full: %w", unix.ENOBUFS)
+	errErrFrame  = errors.New("vcan: error frames can not be transmitted")
+)
+
+// Config describes a simulated CAN bus.
+type Config struct {
+	// Bitrate is the bit rate of the bus, in bits per second.
+	Bitrate int
+
+	// Restart is the delay after which bus-off nodes restart, as the
+	// restart-ms option of the CAN interfaces of Linux. Nodes are not
+	// restarted before the bus-off recovery sequence of 128 occurrences
+	// of 11 recessive bits has elapsed.
+	// A zero Restart disables automatic restarts: bus-off nodes are then
+	// restarted with Node.Restart.
+	Restart time.Duration
+}
+
+// State is the error state of a CAN node.
+type State uint8
+
+const (
+	ErrorActive  State = iota // node taking part in bus communication, signaling errors with active error flags
+	ErrorPassive              // node signaling errors with passive error flags, and waiting before transmitting
+	BusOff                    // node not taking part in bus communication
+)
+
+func (s State) String() string {
+	switch s {
+	case ErrorActive:
+		return "error-active"
+	case ErrorPassive:
+		return "error-passive"
+	case BusOff:
+		return "bus-off"
+	}
+	return fmt.Sprintf("State(%d)", uint8(s))
+}
+
+// Stats describes the traffic of a simulated bus.
+type Stats struct {
+	Frames uint64 // frames transmitted
+	Errors uint64 // failed transmissions
+	Bits   uint64 // bit times the bus was busy
+}
+
+// NewSim returns a new simulated CAN bus with the provided name.
+//
+// Frames sent by the endpoints of a simulated bus are queued by their
+// node, and transmitted in real time, according to the bit rate of the
+// bus and to their length, including stuff bits. Nodes with pending
+// frames compete for the bus with the bitwise arbitration of CAN, once
+// the bus is idle, so that frames with lower identifiers are transmitted
+// first. Frames are looped back to the endpoints of their node once
+// transmitted, and need to be acknowledged by another node.
+// As with CAN sockets, sending a frame fails with an error wrapping
+// unix.ENOBUFS when the transmit queue of the node is full.
+//
+// Nodes handle transmission errors, injected with Node.InjectTxErrors
+// and Node.InjectRxErrors, with the fault confinement rules of CAN:
+// their error counters move them between the error-active,
+// error-passive and bus-off states, reported to their endpoints with
+// error frames, as CAN interfaces of Linux do. Failed transmissions are
+// retried until they succeed, until their node is bus-off, or until
+// their endpoint is closed: closing an endpoint drops the frames it
+// queued, once their ongoing transmission attempt, if any, is over.
+func NewSim(name string, cfg Config) (*Bus, error) {
+	switch {
+	case cfg.Bitrate <= 0:
+		return nil, fmt.Errorf("vcan: invalid bitrate %d", cfg.Bitrate)
+	case cfg.Restart < 0:
+		return nil, fmt.Errorf("vcan: invalid restart delay %v", cfg.Restart)
+	}
+	bus := New(name)
+	bus.sim = &simulation{cfg: cfg}
+	return bus, nil
+}
+
+// Stats returns the statistics of the bus.
+// They are only collected by simulated buses.
+func (bus *Bus) Stats() Stats {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.sim == nil {
+		return Stats{}
+	}
+	return bus.sim.stats
+}
+
+// simulation is the state of a simulated bus.
+type simulation struct {
+	cfg     Config
+	running bool     // whether frames are being transmitted
+	tx      *pending // frame being transmitted
+	seq     uint64   // sequence number of the last queued frame
+	stats   Stats
+}
+
+// duration returns the duration of the provided number of bits.
+func (sim *simulation) duration(bits int) time.Duration {
+	return time.Duration(bits) * time.Second / time.Duration(sim.cfg.Bitrate)
+}
+
+// controller is the CAN controller of a node.
+type controller struct {
+	state  State
+	tec    int // transmit error counter
+	rec    int // receive error counter
+	txErrs int // injected transmission errors
+	rxErrs int // injected reception errors
+
+	txq     []*pending // frames waiting for transmission
+	restart *time.Timer
+}
+
+// pending is a frame waiting for transmission.
+type pending struct {
+	*txFrame
+	seq  uint64 // queuing order
+	prio uint64 // arbitration priority
+	bits int    // length on the bus
+}
+
+// State returns the error state of the node.
+func (node *Node) State() State {
+	bus := node.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	return node.ctl.state
+}
+
+// Counters returns the transmit and receive error counters of the node.
+func (node *Node) Counters() (tec, rec int) {
+	bus := node.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	return node.ctl.tec, node.ctl.rec
+}
+
+// InjectTxErrors makes the next n transmissions of the node fail, as if
+// it detected a bit error. The node and the receivers of the frames
+// increase their error counters, and the frames are retransmitted.
+// Errors are only injected in simulated buses.
+func (node *Node) InjectTxErrors(n int) {
+	bus := node.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	node.ctl.txErrs += n
+}
+
+// InjectRxErrors makes the node detect an error in the next n frames it
+// receives. Error-active nodes signal the error to the other nodes, so
+// that the frames are retransmitted; error-passive nodes only miss the
+// frames.
+// Errors are only injected in simulated buses.
+func (node *Node) InjectRxErrors(n int) {
+	bus := node.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	node.ctl.rxErrs += n
+}
+
+// Restart restarts the bus-off node, resetting its error counters.
+func (node *Node) Restart() error {
+	bus := node.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if node.ctl.state != BusOff {
+		return fmt.Errorf("vcan: node %q is not bus-off", node.name)
+	}
+	node.restart()
+	return nil
+}
+
+// restart restarts the bus-off node.
+func (node *Node) restart() {
+	ctl := &node.ctl
+	if ctl.restart != nil {
+		ctl.restart.Stop()
+		ctl.restart = nil
+	}
+	ctl.state = ErrorActive
+	ctl.tec = 0
+	ctl.rec = 0
+	node.report(unix.CAN_ERR_RESTARTED, [8]byte{})
+}
+
+// report delivers an error frame of the provided class to the endpoints
+// of the node.
+func (node *Node) report(class uint32, data [8]byte) {
+	for _, ep := range node.eps {
+		ep.deliver(unix.CAN_ERR_FLAG|class, data[:])
+	}
+}
+
+// update updates the error state of the node from its error counters.
+func (node *Node) update() {
+	var (
+		bus   = node.bus
+		ctl   = &node.ctl
+		state = ErrorActive
+	)
+	switch {
+	case ctl.tec > 255:
+		state = BusOff
+	case ctl.tec > 127 || ctl.rec > 127:
+		state = ErrorPassive
+	}
+	if state == ctl.state {
+		return
+	}
+	ctl.state = state
+
+	var data [8]byte
+	data[6] = byte(ctl.tec)
+	data[7] = byte(ctl.rec)
+	switch state {
+	case ErrorActive:
+		data[1] = unix.CAN_ERR_CRTL_ACTIVE
+		node.report(unix.CAN_ERR_CRTL|errCnt, data)
+	case ErrorPassive:
+		if ctl.tec > 127 {
+			data[1] |= unix.CAN_ERR_CRTL_TX_PASSIVE
+		}
+		if ctl.rec > 127 {
+			data[1] |= unix.CAN_ERR_CRTL_RX_PASSIVE
+		}
+		node.report(unix.CAN_ERR_CRTL|errCnt, data)
+	case BusOff:
+		ctl.txq = nil
+		node.report(unix.CAN_ERR_BUSOFF, [8]byte{})
+		if bus.sim.cfg.Restart > 0 {
+			delay := bus.sim.duration(busOffBits)
+			if delay < bus.sim.cfg.Restart {
+				delay = bus.sim.cfg.Restart
+			}
+			ctl.restart = time.AfterFunc(delay, func() {
+				bus.mu.Lock()
+				defer bus.mu.Unlock()
+				if ctl.state == BusOff {
+					node.restart()
+				}
+			})
+		}
+	}
+}
+
+// queue queues the frame for transmission by its node.
+func (bus *Bus) queue(f *txFrame) error {
+	var (
+		sim = bus.sim
+		ctl = &f.src.node.ctl
+	)
+	switch {
+	case f.id&unix.CAN_ERR_FLAG != 0:
+		return errErrFrame
+	case ctl.state == BusOff:
+		return errBusOff
+	case len(ctl.txq) >= txQueueLen:
+		return errQueueFull
+	}
+
+	sim.seq++
+	ctl.txq = append(ctl.txq, &pending{
+		txFrame: f,
+		seq:     sim.seq,
+		prio:    priority(f.id),
+		bits:    frameBits(f.id, f.data),
+	})
+	if !sim.running {
+		// the bus is idle: transmit the frame at once.
+		sim.running = true
+		go bus.run(bus.arbitrate())
+	}
+	return nil
+}
+
+// drop drops the frames queued by the endpoint, except for the frame
+// being transmitted.
+func (bus *Bus) drop(ep *Endpoint) {
+	var (
+		ctl = &ep.node.ctl
+		txq = ctl.txq[:0]
+	)
+	for _, f := range ctl.txq {
+		if f.src != ep || f == bus.sim.tx {
+			txq = append(txq, f)
+		}
+	}
+	ctl.txq = txq
+}
+
+// attempt is the transmission of a frame on the bus.
+type attempt struct {
+	tx   *Node
+	f    *pending
+	rx   []*Node // receiving nodes
+	fail failure
+	miss map[*Node]bool // error-passive receivers missing the frame
+	bits int            // bit times taken by the attempt
+}
+
+type failure uint8
+
+const (
+	noFailure  failure = iota
+	txFailure          // error detected by the transmitter
+	rxFailure          // error signaled by a receiver
+	ackFailure         // frame not acknowledged
+)
+
+// run transmits the queued frames, starting with the provided attempt,
+// until no frames are left.
+func (bus *Bus) run(att *attempt) {
+	next := time.Now()
+	for {
+		next = next.Add(bus.sim.duration(att.bits))
+		time.Sleep(time.Until(next))
+
+		bus.mu.Lock()
+		bus.complete(att)
+		att = bus.arbitrate()
+		if att == nil {
+			bus.sim.running = false
+			bus.mu.Unlock()
+			return
+		}
+		bus.mu.Unlock()
+	}
+}
+
+// arbitrate selects the frame transmitted next on the bus, among the
+// first frames queued by the nodes, and the outcome of its transmission.
+func (bus *Bus) arbitrate() *attempt {
+	att := &attempt{}
+	for _, node := range bus.nodes {
+		txq := node.ctl.txq
+		if len(txq) == 0 {
+			continue
+		}
+		f := txq[0]
+		if att.f == nil || f.prio < att.f.prio || (f.prio == att.f.prio && f.seq < att.f.seq) {
+			att.tx, att.f = node, f
+		}
+	}
+	bus.sim.tx = att.f
+	if att.f == nil {
+		return nil
+	}
+
+	for _, node := range bus.nodes {
+		if node != att.tx && node.ctl.state != BusOff {
+			att.rx = append(att.rx, node)
+		}
+	}
+
+	switch ctl := &att.tx.ctl; {
+	case ctl.txErrs > 0:
+		ctl.txErrs--
+		att.fail = txFailure
+	case len(att.rx) == 0:
+		att.fail = ackFailure
+	default:
+		for _, node := range att.rx {
+			if node.ctl.rxErrs == 0 {
+				continue
+			}
+			node.ctl.rxErrs--
+			if node.ctl.state == ErrorActive {
+				att.fail = rxFailure
+				continue
+			}
+			if att.miss == nil {
+				att.miss = make(map[*Node]bool)
+			}
+			att.miss[node] = true
+		}
+	}
+
+	att.bits = att.f.bits + ifsBits
+	if att.fail != noFailure {
+		att.bits += errorBits
+	}
+	if att.tx.ctl.state == ErrorPassive {
+		att.bits += suspendBits
+	}
+	return att
+}
+
+// complete applies the outcome of the transmission attempt.
+func (bus *Bus) complete(att *attempt) {
+	var (
+		sim = bus.sim
+		tx  = &att.tx.ctl
+	)
+	sim.stats.Bits += uint64(att.bits)
+
+	switch att.fail {
+	case noFailure:
+		sim.stats.Frames++
+		tx.txq = tx.txq[1:]
+		if tx.tec > 0 {
+			tx.tec--
+		}
+		for _, node := range att.rx {
+			ctl := &node.ctl
+			switch {
+			case att.miss[node]:
+				ctl.rec++
+			case ctl.rec > 127:
+				ctl.rec = 127
+			case ctl.rec > 0:
+				ctl.rec--
+			}
+		}
+		rx := make(map[*Node]bool, len(att.rx))
+		for _, node := range att.rx {
+			rx[node] = !att.miss[node]
+		}
+		bus.deliver(att.f.txFrame, func(node *Node) bool { return !rx[node] })
+
+	default:
+		sim.stats.Errors++
+		// error-passive transmitters do not increase their error counter
+		// on acknowledgment errors, when no other node takes part.
+		if att.fail != ackFailure || tx.state != ErrorPassive {
+			tx.tec += 8
+		}
+		for _, node := range att.rx {
+			node.ctl.rec++
+		}
+		if att.f.src.closed {
+			// the endpoint was closed during the attempt.
+			tx.txq = tx.txq[1:]
+		}
+	}
+
+	for _, node := range att.rx {
+		if node.ctl.rec > 255 {
+			node.ctl.rec = 255
+		}
+		node.update()
+	}
+	att.tx.update()
+}
+
+// priority returns the arbitration priority of a frame, from its
+// identifier: lower values win the arbitration, as dominant bits.
+func priority(id uint32) uint64 {
+	var rtr uint64
+	if id&unix.CAN_RTR_FLAG != 0 {
+		rtr = 1
+	}
+	if id&unix.CAN_EFF_FLAG != 0 {
+		// base identifier, SRR, IDE, identifier extension and RTR.
+		return uint64(id>>18&0x7ff)<<21 | 1<<20 | 1<<19 | uint64(id&0x3ffff)<<1 | rtr
+	}
+	// identifier, RTR and IDE.
+	return uint64(id&unix.CAN_SFF_MASK)<<21 | rtr<<20
+}
+
+// frameBits returns the number of bits of a frame on the bus, from its
+// start of frame to its end of frame, including its stuff bits.
+func frameBits(id uint32, data []byte) int {
+	var (
+		w   stuffer
+		rtr = id&unix.CAN_RTR_FLAG != 0
+	)
+	w.write(0, 1) // start of frame
+	if id&unix.CAN_EFF_FLAG != 0 {
+		w.write(id>>18&0x7ff, 11)
+		w.write(1, 1) // SRR
+		w.write(1, 1) // IDE
+		w.write(id&0x3ffff, 18)
+		w.write(bit(rtr), 1)
+		w.write(0, 2) // r1, r0
+	} else {
+		w.write(id&unix.CAN_SFF_MASK, 11)
+		w.write(bit(rtr), 1)
+		w.write(0, 1) // IDE
+		w.write(0, 1) // r0
+	}
+	w.write(uint32(len(data)), 4)
+	if !rtr {
+		for _, v := range data {
+			w.write(uint32(v), 8)
+		}
+	}
+	crc := w.crc
+	w.stuffed(uint32(crc), 15)
+
+	// CRC delimiter, ACK slot, ACK delimiter and end of frame.
+	return w.n + 1 + 2 + 7
+}
+
+func bit(v bool) uint32 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// stuffer counts the bits of the stuffed part of a frame, and computes
+// its CRC.
+type stuffer struct {
+	n    int    // number of bits, including stuff bits
+	last uint32 // last bit
+	run  int    // number of consecutive bits equal to the last bit
+	crc  uint16 // CRC-15 of the bits written
+}
+
+// write writes the n least significant bits of v, most significant bit
+// first, and updates the CRC.
+func (w *stuffer) write(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b := v >> i & 1
+		next := uint16(b) ^ (w.crc >> 14 & 1)
+		w.crc = w.crc << 1 & 0x7fff
+		if next != 0 {
+			w.crc ^= 0x4599
+		}
+		w.put(b)
+	}
+}
+
+// stuffed writes the n least significant bits of v, most significant
+// bit first, without updating the CRC.
+func (w *stuffer) stuffed(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.put(v >> i & 1)
+	}
+}
+
+// put writes a bit, followed by a stuff bit after five consecutive equal
+// bits.
+func (w *stuffer) put(b uint32) {
+	w.n++
+	switch {
+	case w.n > 1 && b == w.last:
+		w.run++
+	default:
+		w.last, w.run = b, 1
+	}
+	if w.run == 5 {
+		w.n++
+		w.last, w.run = b^1, 1
+	}
+}
@@ -0,0 +1,466 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcan
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"golang.org/x/sys/unix"
+)
+
+func TestFrameBits(t *testing.T) {
+	// 34 dominant bits, stuffed every 5 bits.
+	if got, want := frameBits(0, nil), 34+6+10; got != want {
+		t.Fatalf("invalid frame length: got=%d, want=%d", got, want)
+	}
+
+	rnd := rand.New(rand.NewSource(1234))
+	for i := 0; i < 1000; i++ {
+		var (
+			data = make([]byte, rnd.Intn(9))
+			id   = rnd.Uint32() & unix.CAN_SFF_MASK
+			n    = 1 + 11 + 3 + 4 + 15 // stuffed bits of an empty standard frame
+		)
+		rnd.Read(data)
+		switch rnd.Intn(3) {
+		case 1:
+			id = rnd.Uint32()&unix.CAN_EFF_MASK | unix.CAN_EFF_FLAG
+			n += 20
+		case 2:
+			id |= unix.CAN_RTR_FLAG
+		}
+		if id&unix.CAN_RTR_FLAG == 0 {
+			n += 8 * len(data)
+		}
+
+		// stuff bits are inserted at most every 4 bits, after the first.
+		min, max := n+10, n+10+(n-1)/4
+		got := frameBits(id, data)
+		if got < min || got > max {
+			t.Fatalf("invalid frame length for id=0x%x, data=%x: got=%d, want=[%d, %d]", id, data, got, min, max)
+		}
+	}
+}
+
+func TestPriority(t *testing.T) {
+	ids := []uint32{
+		0x000,
+		0x000 | unix.CAN_RTR_FLAG,
+		0x000<<18 | unix.CAN_EFF_FLAG,
+		0x000<<18 | 0x3ffff | unix.CAN_EFF_FLAG | unix.CAN_RTR_FLAG,
+		0x001,
+		0x123,
+		0x123 | unix.CAN_RTR_FLAG,
+		0x123<<18 | unix.CAN_EFF_FLAG,
+		0x123<<18 | 1 | unix.CAN_EFF_FLAG,
+		0x124,
+		0x7ff,
+		0x7ff<<18 | unix.CAN_EFF_FLAG,
+		0x1fffffff | unix.CAN_EFF_FLAG | unix.CAN_RTR_FLAG,
+	}
+	got := append([]uint32(nil), ids...)
+	rand.New(rand.NewSource(1234)).Shuffle(len(got), func(i, j int) {
+		got[i], got[j] = got[j], got[i]
+	})
+	sort.Slice(got, func(i, j int) bool {
+		return priority(got[i]) < priority(got[j])
+	})
+	if !reflect.DeepEqual(got, ids) {
+		t.Fatalf("invalid arbitration order:\ngot= %x\nwant=%x", got, ids)
+	}
+}
+
+func TestNewSim(t *testing.T) {
+	for _, tc := range []struct {
+		cfg Config
+		err error
+	}{
+		{
+			cfg: Config{},
+			err: fmt.Errorf("vcan: invalid bitrate 0"),
+		},
+		{
+			cfg: Config{Bitrate: 500000, Restart: -time.Second},
+			err: fmt.Errorf("vcan: invalid restart delay -1s"),
+		},
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := NewSim("can0", tc.cfg)
+			if got, want := fmt.Sprint(err), tc.err.Error(); got != want {
+				t.Fatalf("invalid error:\ngot= %q\nwant=%q", got, want)
+			}
+		})
+	}
+}
+
+// recvAll returns the frames received by the endpoint until its receive
+// timeout elapses.
+func recvAll(t *testing.T, ep *Endpoint) []canbus.Frame {
+	t.Helper()
+	var frames []canbus.Frame
+	for {
+		frame, err := ep.Recv()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("could not recv frame: %+v", err)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func open(t *testing.T, node *Node, timeout time.Duration) *Endpoint {
+	t.Helper()
+	ep := node.Open()
+	err := ep.SetRecvTimeout(timeout)
+	if err != nil {
+		t.Fatalf("could not set recv timeout: %+v", err)
+	}
+	err = ep.SetErrFilter(unix.CAN_ERR_MASK)
+	if err != nil {
+		t.Fatalf("could not set error filter: %+v", err)
+	}
+	return ep
+}
+
+func send(t *testing.T, ep *Endpoint, id uint32) {
+	t.Helper()
+	_, err := ep.Send(canbus.Frame{ID: id, Data: []byte{1, 2, 3, 4}})
+	if err != nil {
+		t.Fatalf("could not send frame 0x%x: %+v", id, err)
+	}
+}
+
+// poll waits for the condition to be satisfied.
+func poll(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 400; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %s", msg)
+}
+
+func TestSimArbitration(t *testing.T) {
+	// frames of about 80 bits, taking 8ms.
+	bus, err := NewSim("can0", Config{Bitrate: 10000})
+	if err != nil {
+		t.Fatalf("could not create bus: %+v", err)
+	}
+
+	var (
+		a = open(t, bus.AddNode("a"), 100*time.Millisecond)
+		b = open(t, bus.AddNode("b"), 100*time.Millisecond)
+		c = open(t, bus.AddNode("c"), 100*time.Millisecond)
+		r = open(t, bus.AddNode("r"), 100*time.Millisecond)
+	)
+
+	// the first frame is transmitted at once, while the others wait for
+	// the next arbitration. Frames of a node are transmitted in order.
+	send(t, a, 0x300)
+	send(t, b, 0x200)
+	send(t, b, 0x050)
+	send(t, c, 0x100)
+	send(t, a, 0x080)
+
+	var ids []uint32
+	for _, frame := range recvAll(t, r) {
+		ids = append(ids, frame.ID)
+	}
+	if got, want := ids, []uint32{0x300, 0x080, 0x100, 0x200, 0x050}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid transmission order: got=%x, want=%x", got, want)
+	}
+}
+
+func TestSimTiming(t *testing.T) {
+	const bitrate = 100000
+	bus, err := NewSim("can0", Config{Bitrate: bitrate})
+	if err != nil {
+		t.Fatalf("could not create bus: %+v", err)
+	}
+
+	var (
+		w = open(t, bus.AddNode("w"), 50*time.Millisecond)
+		r = open(t, bus.AddNode("r"), 50*time.Millisecond)
+
+		start = time.Now()
+		bits  int
+	)
+	for i := 0; i < txQueueLen; i++ {
+		data := []byte{byte(i), 0xff, 0x00, 0xaa, 0x55, 0x0f, 0xf0, byte(i)}
+		_, err := w.Send(canbus.Frame{ID: uint32(i), Data: data})
+		if err != nil {
+			t.Fatalf("could not send frame %d: %+v", i, err)
+		}
+		bits += frameBits(uint32(i), data) + ifsBits
+	}
+	_, err = w.Send(canbus.Frame{ID: 0x7ff})
+	if !errors.Is(err, unix.ENOBUFS) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, unix.ENOBUFS)
+	}
+
+	poll(t, "frames", func() bool { return bus.Stats().Frames == txQueueLen })
+	if got, want := time.Since(start), time.Duration(bits)*time.Second/bitrate; got < want {
+		t.Fatalf("invalid transmission time: got=%v, want>=%v", got, want)
+	}
+
+	if got, want := len(recvAll(t, r)), txQueueLen; got != want {
+		t.Fatalf("invalid number of frames: got=%d, want=%d", got, want)
+	}
+	if got, want := bus.Stats(), (Stats{Frames: txQueueLen, Bits: uint64(bits)}); got != want {
+		t.Fatalf("invalid statistics: got=%+v, want=%+v", got, want)
+	}
+}
+
+// errFrame returns the error frame of the provided class, with the
+// provided controller status and error counters.
+func errFrame(class uint32, status byte, tec, rec byte) canbus.Frame {
+	frame := canbus.Frame{ID: class, Kind: canbus.ERR, Data: make([]byte, 8)}
+	if class&errCnt != 0 {
+		frame.Data[1] = status
+		frame.Data[6] = tec
+		frame.Data[7] = rec
+	}
+	return frame
+}
+
+func TestSimFaultConfinement(t *testing.T) {
+	bus, err := NewSim("can0", Config{Bitrate: 1000000})
+	if err != nil {
+		t.Fatalf("could not create bus: %+v", err)
+	}
+
+	var (
+		na = bus.AddNode("a")
+		nr = bus.AddNode("r")
+		a  = open(t, na, 20*time.Millisecond)
+		r  = open(t, nr, 20*time.Millisecond)
+	)
+	check := func(node *Node, state State, tec, rec int) {
+		t.Helper()
+		if got := node.State(); got != state {
+			t.Fatalf("invalid state of %s: got=%v, want=%v", node.Name(), got, state)
+		}
+		gtec, grec := node.Counters()
+		if gtec != tec || grec != rec {
+			t.Fatalf("invalid counters of %s: got=(%d, %d), want=(%d, %d)", node.Name(), gtec, grec, tec, rec)
+		}
+	}
+
+	// 16 errors make the transmitter error-passive, until the frame is
+	// transmitted.
+	na.InjectTxErrors(16)
+	send(t, a, 0x123)
+	poll(t, "frame", func() bool { return bus.Stats().Frames == 1 })
+	check(na, ErrorActive, 127, 0)
+	check(nr, ErrorActive, 0, 15)
+
+	want := []canbus.Frame{
+		errFrame(unix.CAN_ERR_CRTL|errCnt, unix.CAN_ERR_CRTL_TX_PASSIVE, 128, 0),
+		errFrame(unix.CAN_ERR_CRTL|errCnt, unix.CAN_ERR_CRTL_ACTIVE, 127, 0),
+	}
+	if got := recvAll(t, a); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid error frames:\ngot= %+v\nwant=%+v", got, want)
+	}
+	want = []canbus.Frame{{ID: 0x123, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4}}}
+	if got := recvAll(t, r); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frames:\ngot= %+v\nwant=%+v", got, want)
+	}
+
+	// 17 more errors make the transmitter bus-off, dropping its frames.
+	na.InjectTxErrors(17)
+	send(t, a, 0x124)
+	send(t, a, 0x125)
+	poll(t, "bus-off", func() bool { return na.State() == BusOff })
+	check(na, BusOff, 127+17*8, 0)
+	check(nr, ErrorActive, 0, 15+17)
+
+	_, err = a.Send(canbus.Frame{ID: 0x126})
+	if !errors.Is(err, unix.ENOBUFS) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, unix.ENOBUFS)
+	}
+	want = []canbus.Frame{
+		errFrame(unix.CAN_ERR_CRTL|errCnt, unix.CAN_ERR_CRTL_TX_PASSIVE, 127+8, 0),
+		errFrame(unix.CAN_ERR_BUSOFF, 0, 0, 0),
+	}
+	if got := recvAll(t, a); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid error frames:\ngot= %+v\nwant=%+v", got, want)
+	}
+	if got := recvAll(t, r); len(got) != 0 {
+		t.Fatalf("invalid frames: %+v", got)
+	}
+
+	err = nr.Restart()
+	if err == nil {
+		t.Fatalf("expected an error restarting an error-active node")
+	}
+	err = na.Restart()
+	if err != nil {
+		t.Fatalf("could not restart node: %+v", err)
+	}
+	check(na, ErrorActive, 0, 0)
+
+	send(t, a, 0x127)
+	want = []canbus.Frame{{ID: 0x127, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4}}}
+	if got := recvAll(t, r); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frames:\ngot= %+v\nwant=%+v", got, want)
+	}
+	want = []canbus.Frame{errFrame(unix.CAN_ERR_RESTARTED, 0, 0, 0)}
+	if got := recvAll(t, a); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid error frames:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestSimRestart(t *testing.T) {
+	bus, err := NewSim("can0", Config{Bitrate: 1000000, Restart: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("could not create bus: %+v", err)
+	}
+
+	var (
+		na = bus.AddNode("a")
+		a  = open(t, na, 20*time.Millisecond)
+		_  = open(t, bus.AddNode("r"), 20*time.Millisecond)
+	)
+
+	na.InjectTxErrors(32)
+	send(t, a, 0x123)
+	poll(t, "bus-off", func() bool { return na.State() == BusOff })
+	poll(t, "restart", func() bool { return na.State() == ErrorActive })
+
+	frames := recvAll(t, a)
+	if got, want := frames[len(frames)-1], errFrame(unix.CAN_ERR_RESTARTED, 0, 0, 0); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid error frame:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestSimAck(t *testing.T) {
+	bus, err := NewSim("can0", Config{Bitrate: 1000000})
+	if err != nil {
+		t.Fatalf("could not create bus: %+v", err)
+	}
+
+	var (
+		w = open(t, bus.host, 20*time.Millisecond)
+		r = open(t, bus.host, 20*time.Millisecond)
+	)
+
+	// frames of a lone node are not acknowledged: it becomes error-passive,
+	// and keeps retransmitting them.
+	send(t, w, 0x123)
+	poll(t, "error-passive", func() bool { return bus.Stats().Errors > 20 })
+	if got, want := bus.host.State(), ErrorPassive; got != want {
+		t.Fatalf("invalid state: got=%v, want=%v", got, want)
+	}
+	if tec, _ := bus.host.Counters(); tec != 128 {
+		t.Fatalf("invalid transmit error counter: got=%d, want=%d", tec, 128)
+	}
+
+	// once acknowledged, frames are looped back.
+	o := open(t, bus.AddNode("other"), 20*time.Millisecond)
+	want := []canbus.Frame{{ID: 0x123, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4}}}
+	if got := recvAll(t, o); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frames:\ngot= %+v\nwant=%+v", got, want)
+	}
+	want = []canbus.Frame{
+		errFrame(unix.CAN_ERR_CRTL|errCnt, unix.CAN_ERR_CRTL_TX_PASSIVE, 128, 0),
+		{ID: 0x123, Kind: canbus.SFF, Data: []byte{1, 2, 3, 4}},
+		errFrame(unix.CAN_ERR_CRTL|errCnt, unix.CAN_ERR_CRTL_ACTIVE, 127, 0),
+	}
+	if got := recvAll(t, r); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frames:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestSimClose(t *testing.T) {
+	bus, err := NewSim("can0", Config{Bitrate: 1000000})
+	if err != nil {
+		t.Fatalf("could not create bus: %+v", err)
+	}
+
+	// frames of a closed endpoint are not retransmitted.
+	w := open(t, bus.host, 20*time.Millisecond)
+	send(t, w, 0x123)
+	send(t, w, 0x124)
+	poll(t, "retransmissions", func() bool { return bus.Stats().Errors > 2 })
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("could not close endpoint: %+v", err)
+	}
+	poll(t, "idle bus", func() bool {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		return !bus.sim.running
+	})
+	if n := len(bus.host.ctl.txq); n != 0 {
+		t.Fatalf("invalid number of queued frames: got=%d, want=0", n)
+	}
+	if got := bus.Stats().Frames; got != 0 {
+		t.Fatalf("invalid number of frames: got=%d, want=0", got)
+	}
+}
+
+func TestSimRxErrors(t *testing.T) {
+	bus, err := NewSim("can0", Config{Bitrate: 1000000})
+	if err != nil {
+		t.Fatalf("could not create bus: %+v", err)
+	}
+
+	var (
+		na = bus.AddNode("a")
+		nb = bus.AddNode("b")
+		nc = bus.AddNode("c")
+		a  = open(t, na, 20*time.Millisecond)
+		b  = open(t, nb, 20*time.Millisecond)
+		c  = open(t, nc, 20*time.Millisecond)
+	)
+
+	// an error-active receiver destroys the frame, which is retransmitted.
+	nb.InjectRxErrors(1)
+	send(t, a, 0x123)
+	poll(t, "frame", func() bool { return bus.Stats().Frames == 1 })
+	if got, want := bus.Stats().Errors, uint64(1); got != want {
+		t.Fatalf("invalid number of errors: got=%d, want=%d", got, want)
+	}
+	for _, ep := range []*Endpoint{b, c} {
+		if got, want := len(recvAll(t, ep)), 1; got != want {
+			t.Fatalf("invalid number of frames received by %s: got=%d, want=%d", ep.Name(), got, want)
+		}
+	}
+
+	// an error-passive receiver only misses the frame.
+	bus.mu.Lock()
+	nb.ctl.rec = 128
+	nb.update()
+	bus.mu.Unlock()
+	recvAll(t, b)
+
+	nb.InjectRxErrors(1)
+	send(t, a, 0x200)
+	poll(t, "frame", func() bool { return bus.Stats().Frames == 2 })
+	if got, want := bus.Stats().Errors, uint64(1); got != want {
+		t.Fatalf("invalid number of errors: got=%d, want=%d", got, want)
+	}
+	if got := recvAll(t, b); len(got) != 0 {
+		t.Fatalf("invalid frames received by error-passive node: %+v", got)
+	}
+	if got := recvAll(t, c); len(got) != 1 {
+		t.Fatalf("invalid frames received: %+v", got)
+	}
+	if _, rec := nb.Counters(); rec != 129 {
+		t.Fatalf("invalid receive error counter: got=%d, want=%d", rec, 129)
+	}
+}
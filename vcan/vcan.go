@@ -0,0 +1,419 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vcan implements an in-memory virtual CAN bus.
+//
+// A Bus behaves as a virtual CAN interface of the vcan Linux driver, and
+// its endpoints as CAN sockets bound to that interface: frames sent by an
+// endpoint are looped back to the other endpoints of the bus, with the
+// loopback, own messages reception and filtering semantics of SocketCAN.
+// Endpoints implement canbus.Transport, so that code exchanging frames
+// through a transport can be tested without any CAN interface.
+//
+// A typical usage might look like:
+//
+//	bus := vcan.New("vcan0")
+//	w := bus.Open()
+//	r := bus.Open()
+//	_, err := w.Send(canbus.Frame{ID: 0x123, Data: []byte{1, 2}})
+//	msg, err := r.Recv()
+//
+// Other nodes, each with its own CAN interface, may be attached to a bus
+// with AddNode. The frames sent by the endpoints of a node are received
+// by the endpoints of the other nodes, whatever their loopback option,
+// as with CAN interfaces of distinct hosts connected to the same bus.
+//
+// Buses created with NewSim simulate the transmission of the frames
+// on the bus, with its bit rate, arbitration and error handling.
+package vcan
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"golang.org/x/sys/unix"
+)
+
+// queueSize is the number of frames an endpoint holds until they are
+// received. As with the receive buffer of a socket, frames are dropped
+// once it is full.
+const queueSize = 1024
+
+var (
+	// ErrClosed is returned when using a closed endpoint.
+	ErrClosed = errors.New("vcan: endpoint closed")
+
+	errDataTooBig = errors.New("vcan: data too big")
+)
+
+// Bus is an in-memory virtual CAN bus.
+type Bus struct {
+	name string
+	sim  *simulation // simulation of the bus, if any
+
+	mu    sync.Mutex
+	nodes []*Node
+	host  *Node // node of the endpoints opened by the bus
+}
+
+// New returns a new virtual CAN bus with the provided name.
+func New(name string) *Bus {
+	bus := &Bus{name: name}
+	bus.host = bus.AddNode(name)
+	return bus
+}
+
+// Name returns the name of the bus.
+func (bus *Bus) Name() string {
+	return bus.name
+}
+
+// AddNode attaches a new node to the bus, with a CAN interface of the
+// provided name.
+func (bus *Bus) AddNode(name string) *Node {
+	node := &Node{bus: bus, name: name}
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.nodes = append(bus.nodes, node)
+	return node
+}
+
+// Open returns a new endpoint attached to the interface of the bus,
+// named after the bus.
+//
+// As a CAN socket, the endpoint receives all the data frames, no error
+// frames, and has loopback enabled and own messages reception disabled.
+func (bus *Bus) Open() *Endpoint {
+	return bus.host.Open()
+}
+
+// send sends the frame of the provided endpoint on the bus.
+func (bus *Bus) send(src *Endpoint, msg canbus.Frame) error {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if src.closed {
+		return ErrClosed
+	}
+
+	src.mu.Lock()
+	f := &txFrame{
+		src:      src,
+		id:       canID(msg),
+		data:     append([]byte{}, msg.Data...),
+		loopback: src.loopback,
+		own:      src.recvOwn,
+	}
+	src.mu.Unlock()
+
+	if bus.sim != nil {
+		return bus.queue(f)
+	}
+	bus.deliver(f, nil)
+	return nil
+}
+
+// deliver delivers a transmitted frame to the endpoints of the nodes of
+// the bus, except for the nodes that are skipped.
+func (bus *Bus) deliver(f *txFrame, skip func(node *Node) bool) {
+	for _, node := range bus.nodes {
+		if node == f.src.node {
+			if !f.loopback {
+				continue
+			}
+			for _, ep := range node.eps {
+				if ep == f.src && !f.own {
+					continue
+				}
+				ep.deliver(f.id, f.data)
+			}
+			continue
+		}
+		if skip != nil && skip(node) {
+			continue
+		}
+		for _, ep := range node.eps {
+			ep.deliver(f.id, f.data)
+		}
+	}
+}
+
+// txFrame is a frame sent by an endpoint.
+type txFrame struct {
+	src      *Endpoint
+	id       uint32 // identifier, with its SocketCAN flags
+	data     []byte
+	loopback bool // whether the frame is looped back to the node
+	own      bool // whether the frame is looped back to its sender
+}
+
+// Node is a node of a virtual CAN bus, with its own CAN interface.
+type Node struct {
+	bus  *Bus
+	name string
+
+	eps []*Endpoint // protected by the mutex of the bus
+	ctl controller  // protected by the mutex of the bus
+}
+
+// Name returns the name of the interface of the node.
+func (node *Node) Name() string {
+	return node.name
+}
+
+// Open returns a new endpoint attached to the interface of the node.
+//
+// As a CAN socket, the endpoint receives all the data frames, no error
+// frames, and has loopback enabled and own messages reception disabled.
+func (node *Node) Open() *Endpoint {
+	ep := &Endpoint{
+		node:     node,
+		rx:       make(chan canbus.Frame, queueSize),
+		done:     make(chan struct{}),
+		filters:  []unix.CanFilter{{Id: 0, Mask: 0}},
+		loopback: true,
+	}
+
+	bus := node.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	node.eps = append(node.eps, ep)
+	return ep
+}
+
+// Endpoint is a connection to a virtual CAN bus, through the interface
+// of one of its nodes.
+type Endpoint struct {
+	node *Node
+
+	rx     chan canbus.Frame
+	done   chan struct{}
+	closed bool // protected by the mutex of the bus
+
+	mu       sync.Mutex
+	filters  []unix.CanFilter
+	errMask  uint32
+	loopback bool
+	recvOwn  bool
+	timeout  time.Duration
+}
+
+// Name returns the name of the interface the endpoint is attached to.
+func (ep *Endpoint) Name() string {
+	return ep.node.name
+}
+
+// Node returns the node the endpoint is attached to.
+func (ep *Endpoint) Node() *Node {
+	return ep.node
+}
+
+// SetFilters applies the provided filters to the frames received, with
+// the semantics of the CAN_RAW_FILTER socket option.
+// An empty list of filters receives no frames.
+func (ep *Endpoint) SetFilters(filters []unix.CanFilter) error {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.filters = append([]unix.CanFilter{}, filters...)
+	return nil
+}
+
+// SetErrFilter selects the error frames received, with the semantics
+// of the CAN_RAW_ERR_FILTER socket option.
+// Use unix.CAN_ERR_MASK to receive all error frames.
+func (ep *Endpoint) SetErrFilter(mask uint32) error {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.errMask = mask & unix.CAN_ERR_MASK
+	return nil
+}
+
+// SetLoopback sets whether the frames sent by the endpoint are received
+// by the other endpoints of its node, as the CAN_RAW_LOOPBACK socket
+// option. It is enabled by default.
+func (ep *Endpoint) SetLoopback(enable bool) error {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.loopback = enable
+	return nil
+}
+
+// SetRecvOwnMsgs sets whether the endpoint receives the frames it sends,
+// once looped back, as the CAN_RAW_RECV_OWN_MSGS socket option.
+// It is disabled by default.
+func (ep *Endpoint) SetRecvOwnMsgs(enable bool) error {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.recvOwn = enable
+	return nil
+}
+
+// SetRecvTimeout sets the duration Recv waits for a frame.
+// Once the timeout has elapsed without any frame being received, Recv
+// returns an error wrapping os.ErrDeadlineExceeded.
+// A zero duration disables the timeout.
+func (ep *Endpoint) SetRecvTimeout(timeout time.Duration) error {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.timeout = timeout
+	return nil
+}
+
+// Send sends the provided frame on the bus, and returns the number of
+// data bytes sent.
+func (ep *Endpoint) Send(msg canbus.Frame) (int, error) {
+	if len(msg.Data) > 8 {
+		return 0, errDataTooBig
+	}
+
+	err := ep.node.bus.send(ep, msg)
+	if err != nil {
+		return 0, err
+	}
+	return len(msg.Data), nil
+}
+
+// Recv receives a frame from the bus.
+func (ep *Endpoint) Recv() (canbus.Frame, error) {
+	select {
+	case <-ep.done:
+		return canbus.Frame{}, ErrClosed
+	default:
+	}
+
+	ep.mu.Lock()
+	timeout := ep.timeout
+	ep.mu.Unlock()
+
+	var expire <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expire = timer.C
+	}
+
+	select {
+	case msg := <-ep.rx:
+		return msg, nil
+	case <-ep.done:
+		return canbus.Frame{}, ErrClosed
+	case <-expire:
+		return canbus.Frame{}, fmt.Errorf("vcan: recv timeout: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+// Close detaches the endpoint from the bus.
+// With simulated buses, the frames it sent that are not transmitted yet
+// are dropped.
+func (ep *Endpoint) Close() error {
+	bus := ep.node.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if ep.closed {
+		return ErrClosed
+	}
+	ep.closed = true
+	close(ep.done)
+	eps := ep.node.eps
+	for i, v := range eps {
+		if v == ep {
+			ep.node.eps = append(eps[:i], eps[i+1:]...)
+			break
+		}
+	}
+	if bus.sim != nil {
+		bus.drop(ep)
+	}
+	return nil
+}
+
+// deliver queues the frame with the provided identifier, if it passes
+// the filters of the endpoint.
+func (ep *Endpoint) deliver(id uint32, data []byte) {
+	ep.mu.Lock()
+	ok := ep.match(id)
+	ep.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case ep.rx <- frameOf(id, data):
+	default:
+		// queue full: drop the frame.
+	}
+}
+
+// match reports whether a frame with the provided identifier passes the
+// filters of the endpoint.
+func (ep *Endpoint) match(id uint32) bool {
+	if id&unix.CAN_ERR_FLAG != 0 {
+		return id&ep.errMask != 0
+	}
+	for _, f := range ep.filters {
+		inv := f.Id&unix.CAN_INV_FILTER != 0
+		if (id&f.Mask == f.Id&^unix.CAN_INV_FILTER&f.Mask) != inv {
+			return true
+		}
+	}
+	return false
+}
+
+// canID returns the identifier of the frame, along with its SocketCAN
+// flags, as sent by a canbus.Socket.
+func canID(msg canbus.Frame) uint32 {
+	id := msg.ID
+	switch msg.Kind {
+	case canbus.SFF:
+		id &= unix.CAN_SFF_MASK
+	case canbus.EFF:
+		id &= unix.CAN_EFF_MASK
+		id |= unix.CAN_EFF_FLAG
+	case canbus.RTR:
+		id &= unix.CAN_EFF_MASK
+		id |= unix.CAN_RTR_FLAG
+	case canbus.ERR:
+		id &= unix.CAN_ERR_MASK
+		id |= unix.CAN_ERR_FLAG
+	}
+	return id
+}
+
+// frameOf returns the frame with the provided identifier and data, as
+// received by a canbus.Socket.
+func frameOf(id uint32, data []byte) canbus.Frame {
+	var msg canbus.Frame
+	switch {
+	case id&unix.CAN_EFF_FLAG != 0:
+		msg.Kind = canbus.EFF
+		msg.ID = id & unix.CAN_EFF_MASK
+	case id&unix.CAN_ERR_FLAG != 0:
+		msg.Kind = canbus.ERR
+		msg.ID = id & unix.CAN_ERR_MASK
+	case id&unix.CAN_RTR_FLAG != 0:
+		msg.Kind = canbus.RTR
+		msg.ID = id & unix.CAN_EFF_MASK
+	default:
+		msg.Kind = canbus.SFF
+		msg.ID = id & unix.CAN_SFF_MASK
+	}
+	msg.Data = make([]byte, len(data))
+	copy(msg.Data, data)
+	return msg
+}
+
+var (
+	_ canbus.Transport = (*Endpoint)(nil)
+)
@@ -0,0 +1,47 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcan_test
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/vcan"
+)
+
+func ExampleBus() {
+	bus := vcan.New("vcan0")
+
+	recv := bus.Open()
+	defer recv.Close()
+
+	send := bus.Open()
+	defer send.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := send.Send(canbus.Frame{
+			ID:   0x123,
+			Data: []byte(fmt.Sprintf("data-%02d", i)),
+			Kind: canbus.SFF,
+		})
+		if err != nil {
+			log.Fatalf("could not send frame %d: %+v", i, err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		frame, err := recv.Recv()
+		if err != nil {
+			log.Fatalf("could not recv frame %d: %+v", i, err)
+		}
+		fmt.Printf("frame-%02d: %q (id=0x%x)\n", i, frame.Data, frame.ID)
+	}
+
+	// Output:
+	// frame-00: "data-00" (id=0x123)
+	// frame-01: "data-01" (id=0x123)
+	// frame-02: "data-02" (id=0x123)
+}
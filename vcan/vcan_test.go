@@ -0,0 +1,360 @@
+// Copyright 2022 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcan_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/canbus"
+	"github.com/go-daq/canbus/vcan"
+	"golang.org/x/sys/unix"
+)
+
+func TestBus(t *testing.T) {
+	for _, kind := range []canbus.Kind{canbus.SFF, canbus.EFF, canbus.RTR} {
+		t.Run(fmt.Sprintf("kind=%v", kind), func(t *testing.T) {
+			const (
+				N  = 10
+				ID = 128
+			)
+			msg := canbus.Frame{
+				ID:   ID,
+				Data: []byte{0, 0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, 0xda},
+				Kind: kind,
+			}
+			if kind == canbus.SFF {
+				msg.Data = msg.Data[:4]
+			}
+
+			bus := vcan.New("vcan0")
+			r := bus.Open()
+			defer r.Close()
+			w := bus.Open()
+			defer w.Close()
+
+			if got, want := r.Name(), "vcan0"; got != want {
+				t.Fatalf("invalid name: got=%q, want=%q", got, want)
+			}
+
+			go func() {
+				for i := 0; i < N; i++ {
+					msg.Data[0] = byte(i)
+					n, err := w.Send(msg)
+					if err != nil {
+						t.Errorf("error send[%d]: %v\n", i, err)
+					}
+					if n != len(msg.Data) {
+						t.Errorf("invalid number of bytes sent[%d]: got=%d, want=%d", i, n, len(msg.Data))
+					}
+				}
+			}()
+
+			for i := 0; i < N; i++ {
+				got, err := r.Recv()
+				if err != nil {
+					t.Fatalf("error recv: %v\n", err)
+				}
+				want := canbus.Frame{ID: ID, Kind: kind, Data: append([]byte{byte(i)}, msg.Data[1:]...)}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("invalid frame %d:\ngot= %+v\nwant=%+v", i, got, want)
+				}
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	bus := vcan.New("vcan0")
+	w := bus.Open()
+	defer w.Close()
+	r := bus.Open()
+	defer r.Close()
+
+	for _, ep := range []*vcan.Endpoint{w, r} {
+		err := ep.SetRecvTimeout(10 * time.Millisecond)
+		if err != nil {
+			t.Fatalf("could not set recv timeout: %+v", err)
+		}
+	}
+
+	send := func(data string) {
+		t.Helper()
+		_, err := w.Send(canbus.Frame{ID: 0x123, Data: []byte(data)})
+		if err != nil {
+			t.Fatalf("could not send frame %q: %+v", data, err)
+		}
+	}
+	recv := func(ep *vcan.Endpoint) string {
+		t.Helper()
+		var msgs []string
+		for {
+			frame, err := ep.Recv()
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return strings.Join(msgs, ",")
+			}
+			if err != nil {
+				t.Fatalf("could not recv frame: %+v", err)
+			}
+			msgs = append(msgs, string(frame.Data))
+		}
+	}
+
+	send("00")
+	err := w.SetRecvOwnMsgs(true)
+	if err != nil {
+		t.Fatalf("could not enable own messages: %+v", err)
+	}
+	send("01")
+	err = w.SetLoopback(false)
+	if err != nil {
+		t.Fatalf("could not disable loopback: %+v", err)
+	}
+	send("02")
+
+	if got, want := recv(r), "00,01"; got != want {
+		t.Fatalf("invalid frames received by other endpoint: got=%q, want=%q", got, want)
+	}
+	if got, want := recv(w), "01"; got != want {
+		t.Fatalf("invalid frames received by sender: got=%q, want=%q", got, want)
+	}
+}
+
+func TestSetFilters(t *testing.T) {
+	const kind = canbus.EFF
+
+	bus := vcan.New("vcan0")
+
+	r1 := bus.Open()
+	defer r1.Close()
+
+	err := r1.SetFilters([]unix.CanFilter{
+		{Id: 0x123, Mask: unix.CAN_SFF_MASK},
+	})
+	if err != nil {
+		t.Fatalf("could not set CAN filters: %+v", err)
+	}
+
+	r2 := bus.Open()
+	defer r2.Close()
+
+	err = r2.SetFilters([]unix.CanFilter{
+		{Id: 0xddd, Mask: unix.CAN_SFF_MASK},
+	})
+	if err != nil {
+		t.Fatalf("could not set CAN filters: %+v", err)
+	}
+
+	r3 := bus.Open()
+	defer r3.Close()
+
+	err = r3.SetFilters([]unix.CanFilter{
+		{Id: 0xddd | unix.CAN_INV_FILTER, Mask: unix.CAN_SFF_MASK},
+	})
+	if err != nil {
+		t.Fatalf("could not set CAN filters: %+v", err)
+	}
+
+	r4 := bus.Open()
+	defer r4.Close()
+
+	r5 := bus.Open()
+	defer r5.Close()
+
+	err = r5.SetFilters([]unix.CanFilter{
+		{Id: 0x123 | unix.CAN_EFF_FLAG, Mask: unix.CAN_EFF_MASK | unix.CAN_EFF_FLAG},
+	})
+	if err != nil {
+		t.Fatalf("could not set CAN filters: %+v", err)
+	}
+
+	r6 := bus.Open()
+	defer r6.Close()
+
+	err = r6.SetFilters(nil)
+	if err != nil {
+		t.Fatalf("could not set CAN filters: %+v", err)
+	}
+
+	w := bus.Open()
+	defer w.Close()
+
+	for i := 0; i < 4; i++ {
+		var id uint32 = 0x123
+		if i%2 == 0 {
+			id = 0xddd
+		}
+		_, err = w.Send(canbus.Frame{
+			ID:   id,
+			Data: []byte(fmt.Sprintf("%02d", i)),
+			Kind: kind,
+		})
+		if err != nil {
+			t.Fatalf("could not send frame %d: %+v", i, err)
+		}
+	}
+	_, err = w.Send(canbus.Frame{ID: 0x123, Data: []byte("04"), Kind: canbus.SFF})
+	if err != nil {
+		t.Fatalf("could not send frame: %+v", err)
+	}
+
+	recv := func(r *vcan.Endpoint) []string {
+		err := r.SetRecvTimeout(10 * time.Millisecond)
+		if err != nil {
+			t.Fatalf("could not set recv timeout: %+v", err)
+		}
+		var msgs []string
+		for {
+			frame, err := r.Recv()
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return msgs
+			}
+			if err != nil {
+				t.Fatalf("could not retrieve frame: %+v", err)
+			}
+			msgs = append(msgs, string(frame.Data))
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		r    *vcan.Endpoint
+		want string
+	}{
+		{"r1", r1, "01,03,04"},
+		{"r2", r2, "00,02"},
+		{"r3", r3, "01,03,04"},
+		{"r4", r4, "00,01,02,03,04"},
+		{"r5", r5, "01,03"},
+		{"r6", r6, ""},
+	} {
+		if got := strings.Join(recv(tc.r), ","); got != tc.want {
+			t.Fatalf("%s filter failed:\ngot= %q\nwant=%q\n", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSetErrFilter(t *testing.T) {
+	bus := vcan.New("vcan0")
+	w := bus.Open()
+	defer w.Close()
+	r1 := bus.Open()
+	defer r1.Close()
+	r2 := bus.Open()
+	defer r2.Close()
+	r3 := bus.Open()
+	defer r3.Close()
+
+	err := r2.SetErrFilter(unix.CAN_ERR_BUSOFF)
+	if err != nil {
+		t.Fatalf("could not set error filter: %+v", err)
+	}
+	err = r3.SetErrFilter(unix.CAN_ERR_MASK)
+	if err != nil {
+		t.Fatalf("could not set error filter: %+v", err)
+	}
+
+	for _, id := range []uint32{unix.CAN_ERR_CRTL, unix.CAN_ERR_BUSOFF} {
+		_, err := w.Send(canbus.Frame{ID: id, Kind: canbus.ERR, Data: make([]byte, 8)})
+		if err != nil {
+			t.Fatalf("could not send error frame: %+v", err)
+		}
+	}
+
+	recv := func(r *vcan.Endpoint) []uint32 {
+		err := r.SetRecvTimeout(10 * time.Millisecond)
+		if err != nil {
+			t.Fatalf("could not set recv timeout: %+v", err)
+		}
+		var ids []uint32
+		for {
+			frame, err := r.Recv()
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return ids
+			}
+			if err != nil {
+				t.Fatalf("could not retrieve frame: %+v", err)
+			}
+			if frame.Kind != canbus.ERR {
+				t.Fatalf("invalid frame kind: got=%v, want=%v", frame.Kind, canbus.ERR)
+			}
+			ids = append(ids, frame.ID)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		r    *vcan.Endpoint
+		want []uint32
+	}{
+		{"r1", r1, nil},
+		{"r2", r2, []uint32{unix.CAN_ERR_BUSOFF}},
+		{"r3", r3, []uint32{unix.CAN_ERR_CRTL, unix.CAN_ERR_BUSOFF}},
+	} {
+		if got := recv(tc.r); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%s error filter failed: got=%v, want=%v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEndpointErrors(t *testing.T) {
+	bus := vcan.New("vcan0")
+	ep := bus.Open()
+
+	_, err := ep.Send(canbus.Frame{ID: 42, Data: make([]byte, 8+1)})
+	if got, want := fmt.Sprint(err), "vcan: data too big"; got != want {
+		t.Fatalf("invalid error: got=%q, want=%q", got, want)
+	}
+
+	err = ep.SetRecvTimeout(10 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("could not set recv timeout: %+v", err)
+	}
+	_, err = ep.Recv()
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, os.ErrDeadlineExceeded)
+	}
+
+	err = ep.SetRecvTimeout(0)
+	if err != nil {
+		t.Fatalf("could not set recv timeout: %+v", err)
+	}
+	errc := make(chan error)
+	go func() {
+		_, err := ep.Recv()
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	err = ep.Close()
+	if err != nil {
+		t.Fatalf("could not close endpoint: %+v", err)
+	}
+	if err := <-errc; err != vcan.ErrClosed {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, vcan.ErrClosed)
+	}
+
+	_, err = ep.Send(canbus.Frame{ID: 42})
+	if err != vcan.ErrClosed {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, vcan.ErrClosed)
+	}
+	err = ep.Close()
+	if err != vcan.ErrClosed {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, vcan.ErrClosed)
+	}
+}